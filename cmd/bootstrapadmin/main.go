@@ -0,0 +1,69 @@
+// Command bootstrapadmin creates the first admin, so a fresh deployment has
+// someone who can sign in to the admin API and hand out roles. It reads the same
+// MONGODB_URI and DB_NAME as the API, and the admin from ADMIN_EMAIL,
+// ADMIN_PASSWORD, ADMIN_FIRST_NAME and ADMIN_LAST_NAME.
+//
+// It does nothing once any user has the admin role, so it is safe to run on every
+// deploy. If a user with ADMIN_EMAIL already exists it fails, unless run with
+// --promote-existing and that user has verified their email; they are then given
+// the admin role and keep their password.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
+	"github.com/eddie-wainaina1/maggiesb/internal/handlers"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	promoteExisting := flag.Bool("promote-existing", false, "give the admin role to an existing, verified user with ADMIN_EMAIL")
+	flag.Parse()
+	_ = godotenv.Load()
+
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		log.Fatalf("MONGODB_URI is required. Set it in the environment or in a .env file (see .env.example)")
+	}
+	if dbName := os.Getenv("DB_NAME"); dbName != "" {
+		database.SetDBName(dbName)
+	}
+	email := os.Getenv("ADMIN_EMAIL")
+	if email == "" {
+		log.Fatalf("ADMIN_EMAIL is required")
+	}
+
+	if err := database.InitMongo(mongoURI); err != nil {
+		log.Fatalf("Failed to initialize MongoDB: %v", err)
+	}
+	defer database.DisconnectMongo()
+
+	if err := database.CreateIndexes(); err != nil {
+		log.Fatalf("Failed to create indexes: %v", err)
+	}
+	handlers.InitDependencies()
+	if err := handlers.InitRoles(); err != nil {
+		log.Fatalf("Failed to create roles: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := handlers.BootstrapAdmin(ctx, email, os.Getenv("ADMIN_PASSWORD"), os.Getenv("ADMIN_FIRST_NAME"), os.Getenv("ADMIN_LAST_NAME"), *promoteExisting)
+	if err != nil {
+		log.Fatalf("Failed to bootstrap admin: %v", err)
+	}
+
+	switch result {
+	case handlers.BootstrapSkipped:
+		log.Printf("An admin already exists; nothing to do")
+	case handlers.BootstrapPromoted:
+		log.Printf("Gave the admin role to existing user %s", email)
+	case handlers.BootstrapCreated:
+		log.Printf("Created admin %s", email)
+	}
+}
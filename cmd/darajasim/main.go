@@ -0,0 +1,60 @@
+// Command darajasim runs the Daraja simulator as a standalone server, so the API
+// can be exercised end to end without Safaricom's sandbox. Point the API at it with
+// MPESA_BASE_URL and MPESA_PUBLIC_KEY_PATH (the key written by -key-out).
+//
+// Scenarios can be changed while it runs with PUT /simulator/scenarios/{operation}.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/payment/darajasim"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "listen address")
+	consumerKey := flag.String("consumer-key", os.Getenv("MPESA_CONSUMER_KEY"), "consumer key clients must present")
+	consumerSecret := flag.String("consumer-secret", os.Getenv("MPESA_CONSUMER_SECRET"), "consumer secret clients must present")
+	passKey := flag.String("passkey", os.Getenv("MPESA_PASSKEY"), "STK Push passkey; passwords are not checked if empty")
+	initiatorPassword := flag.String("initiator-password", os.Getenv("MPESA_INITIATOR_PASSWORD"), "initiator password; security credentials are not checked if empty")
+	outcome := flag.String("outcome", string(darajasim.Succeed), "default outcome: succeed, fail, timeout or reject")
+	delay := flag.Duration("delay", 2*time.Second, "wait before posting callbacks")
+	duplicates := flag.Int("duplicates", 0, "extra copies of each result callback")
+	keyOut := flag.String("key-out", "", "write the public key security credentials are encrypted with here, for MPESA_PUBLIC_KEY_PATH")
+	flag.Parse()
+
+	switch darajasim.Outcome(*outcome) {
+	case darajasim.Succeed, darajasim.Fail, darajasim.Timeout, darajasim.Reject:
+	default:
+		log.Fatalf("Unknown outcome %q", *outcome)
+	}
+
+	sim := darajasim.NewServer(darajasim.Config{
+		ConsumerKey:       *consumerKey,
+		ConsumerSecret:    *consumerSecret,
+		PassKey:           *passKey,
+		InitiatorPassword: *initiatorPassword,
+		Logger:            log.Default(),
+	})
+
+	scenario := darajasim.Scenario{Outcome: darajasim.Outcome(*outcome), Delay: *delay, Duplicates: *duplicates}
+	for _, op := range []string{darajasim.STKPush, darajasim.Reversal, darajasim.B2C, darajasim.C2B} {
+		sim.SetScenario(op, scenario)
+	}
+
+	if *keyOut != "" {
+		if err := os.WriteFile(*keyOut, sim.PublicKeyPEM(), 0o644); err != nil {
+			log.Fatalf("Failed to write public key: %v", err)
+		}
+		log.Printf("Public key written to %s", *keyOut)
+	}
+
+	log.Printf("Daraja simulator listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, sim); err != nil {
+		log.Fatalf("Simulator stopped: %v", err)
+	}
+}
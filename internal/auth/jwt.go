@@ -8,11 +8,11 @@ import (
 
 	"github.com/eddie-wainaina1/maggiesb/internal/database"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	secretKey = []byte("your-secret-key-change-this-in-production")
 	ErrInvalidToken = errors.New("invalid token")
 	ErrExpiredToken = errors.New("token has expired")
 	ErrInvalidClaims = errors.New("invalid claims")
@@ -20,42 +20,103 @@ var (
 )
 
 type Claims struct {
-	Email  string `json:"email"`
-	Role   string `json:"role"`
-	UserID string `json:"userId"`
+	Email     string   `json:"email"`
+	Role      string   `json:"role"` // first of Roles
+	UserID    string   `json:"userId"`
+	Roles     []string `json:"roles,omitempty"` // the user's roles when the token was issued
+	SessionID string   `json:"sid,omitempty"`   // login session the token was issued for
+	// AuthMethods records how the user proved who they are when the session began
+	AuthMethods []string `json:"amr,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Authentication methods (RFC 8176) recorded in Claims.AuthMethods
+const (
+	MethodPassword = "pwd"
+	MethodOTP      = "otp"
+)
+
+// HasAuthMethod reports whether the user proved who they are with method
+func (c *Claims) HasAuthMethod(method string) bool {
+	for _, m := range c.AuthMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleNames returns the roles the token was issued for; tokens from before users
+// could have several roles carry only Role
+func (c *Claims) RoleNames() []string {
+	if len(c.Roles) > 0 {
+		return c.Roles
+	}
+	if c.Role != "" {
+		return []string{c.Role}
+	}
+	return nil
+}
+
+// BlacklistKey is what the blacklist records for this token: its ID, or the whole
+// token for tokens issued before tokens carried one
+func (c *Claims) BlacklistKey(tokenString string) string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return tokenString
+}
+
 func GenerateToken(userID, email, role string, expirationTime time.Duration) (string, error) {
-	expiryTime := time.Now().Add(expirationTime)
+	tokenString, _, err := GenerateSessionToken(userID, email, []string{role}, "", nil, expirationTime)
+	return tokenString, err
+}
+
+// GenerateSessionToken issues an access token for a login session and returns its
+// claims, whose ID is what gets blacklisted when the session is revoked
+func GenerateSessionToken(userID, email string, roles []string, sessionID string, methods []string, expirationTime time.Duration) (string, *Claims, error) {
+	now := time.Now()
 	claims := &Claims{
-		Email:  email,
-		Role:   role,
-		UserID: userID,
+		Email:       email,
+		UserID:      userID,
+		Roles:       roles,
+		SessionID:   sessionID,
+		AuthMethods: methods,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiryTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expirationTime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "maggiesb-ecommerce",
 		},
 	}
+	if len(roles) > 0 {
+		claims.Role = roles[0]
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secretKey)
+	key := signingKeys.Load().active
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	tokenString, err := token.SignedString(key.sign)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		return "", nil, fmt.Errorf("failed to sign token: %w", err)
 	}
 
-	return tokenString, nil
+	return tokenString, claims, nil
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	// Check if token is blacklisted
 	tokenRepo := database.NewTokenRepository()
-	isBlacklisted, err := tokenRepo.IsTokenBlacklisted(ctx, tokenString)
+	isBlacklisted, err := tokenRepo.IsTokenBlacklisted(ctx, claims.BlacklistKey(tokenString))
 	if err != nil {
 		return nil, fmt.Errorf("failed to check token blacklist: %w", err)
 	}
@@ -64,14 +125,15 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, ErrTokenBlacklisted
 	}
 
+	return claims, nil
+}
+
+// parseToken checks a token's signature and lifetime against the keyring
+func parseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
+	ring := signingKeys.Load()
+	token, err := jwt.ParseWithClaims(tokenString, claims, ring.keyFunc, jwt.WithValidMethods(ring.methods()))
 
 	if err != nil {
 		return nil, fmt.Errorf("token parsing error: %w", err)
@@ -96,10 +158,8 @@ func VerifyPassword(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-func SetSecretKey(key string) {
-	secretKey = []byte(key)
-}
-
+// BlacklistToken revokes a token until it expires. Pass the key from
+// Claims.BlacklistKey.
 func BlacklistToken(tokenString string, expiresAt time.Time) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -47,3 +47,53 @@ func TestGenerateAndValidateToken(t *testing.T) {
 		t.Fatalf("unexpected user id: %s", claims.UserID)
 	}
 }
+
+func TestGenerateSessionToken(t *testing.T) {
+	SetSecretKey("test-secret-key")
+	tkn, claims, err := GenerateSessionToken("userid123", "user@example.com", []string{"user", "finance"}, "session-1", []string{MethodPassword}, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateSessionToken error: %v", err)
+	}
+	if claims.ID == "" || claims.SessionID != "session-1" {
+		t.Fatalf("expected token ID and session ID, got %+v", claims)
+	}
+	parsed, err := parseToken(tkn)
+	if err != nil || !parsed.HasAuthMethod(MethodPassword) || parsed.HasAuthMethod(MethodOTP) {
+		t.Fatalf("expected the token to record a password sign-in, got %v (err=%v)", parsed.AuthMethods, err)
+	}
+	if parsed.Role != "user" || len(parsed.RoleNames()) != 2 || parsed.RoleNames()[1] != "finance" {
+		t.Fatalf("expected the token to carry both roles, got %q %v", parsed.Role, parsed.Roles)
+	}
+	if roles := (&Claims{Role: "admin"}).RoleNames(); len(roles) != 1 || roles[0] != "admin" {
+		t.Fatalf("expected tokens with only a role to report it, got %v", roles)
+	}
+
+	// Session tokens are blacklisted by their ID, older tokens by the whole token
+	if key := claims.BlacklistKey(tkn); key != claims.ID {
+		t.Fatalf("unexpected blacklist key: %s", key)
+	}
+	legacy := &Claims{}
+	if key := legacy.BlacklistKey(tkn); key != tkn {
+		t.Fatalf("expected tokens without an ID to be blacklisted whole")
+	}
+
+	other, _, _ := GenerateSessionToken("userid123", "user@example.com", []string{"user", "finance"}, "session-1", []string{MethodPassword}, 15*time.Minute)
+	if other == tkn {
+		t.Fatalf("expected each token to be unique")
+	}
+}
+
+func TestValidateToken_RejectsTamperedToken(t *testing.T) {
+	// Signature checks run before the blacklist lookup, so no database is needed
+	SetSecretKey("test-secret-key")
+	tkn, err := GenerateToken("userid123", "user@example.com", "user", 1*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken error: %v", err)
+	}
+
+	SetSecretKey("another-secret-key")
+	defer SetSecretKey("test-secret-key")
+	if _, err := ValidateToken(tkn); err == nil {
+		t.Fatalf("expected token signed with another key to be rejected")
+	}
+}
@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms supported for access tokens
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// defaultSecret signs tokens in development when JWT_SECRET is not set
+const defaultSecret = "your-secret-key-change-this-in-production"
+
+// placeholderSecrets are secrets published in this repository, which anyone could
+// sign tokens with
+var placeholderSecrets = []string{defaultSecret, "your-secret-key-change-in-production"}
+
+// minSecretLength is the shortest HS256 secret accepted in production
+const minSecretLength = 32
+
+// minRSABits is the smallest RSA key accepted for RS256
+const minRSABits = 2048
+
+// signingKey is one key of the keyring. Keys kept only to verify tokens signed
+// before a rotation have no private half.
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	sign   interface{} // []byte, *rsa.PrivateKey or ed25519.PrivateKey; nil if verify-only
+	verify interface{} // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// keyring holds the key new tokens are signed with and every key tokens are still
+// accepted from. Each token names its key in the "kid" header.
+type keyring struct {
+	active *signingKey
+	keys   map[string]*signingKey
+	order  []*signingKey // active key first, then in configured order
+}
+
+var signingKeys atomic.Pointer[keyring]
+
+func init() {
+	ring, _ := newKeyring(hmacKey(defaultSecret))
+	signingKeys.Store(ring)
+}
+
+func newKeyring(active *signingKey, verifyOnly ...*signingKey) (*keyring, error) {
+	ring := &keyring{active: active, keys: make(map[string]*signingKey)}
+	for _, key := range append([]*signingKey{active}, verifyOnly...) {
+		if _, dup := ring.keys[key.id]; dup {
+			return nil, fmt.Errorf("duplicate signing key ID %q", key.id)
+		}
+		ring.keys[key.id] = key
+		ring.order = append(ring.order, key)
+	}
+	return ring, nil
+}
+
+// keyFunc picks the key a token is verified with from its "kid" header. The
+// token's algorithm must be the key's, so a public key can never be used as an
+// HMAC secret.
+func (r *keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		// Tokens issued before key IDs were added were all HMAC signed
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		var set jwt.VerificationKeySet
+		for _, key := range r.order {
+			if key.method == jwt.SigningMethodHS256 {
+				set.Keys = append(set.Keys, key.verify)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, ErrInvalidToken
+		}
+		return set, nil
+	}
+
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verify, nil
+}
+
+// methods lists the algorithms of every key in the ring
+func (r *keyring) methods() []string {
+	var algs []string
+	seen := map[string]bool{}
+	for _, key := range r.order {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// SetSecretKey signs and verifies tokens with a single HS256 secret
+func SetSecretKey(key string) {
+	ring, _ := newKeyring(hmacKey(key))
+	signingKeys.Store(ring)
+}
+
+// InitSigningKeys loads the token signing keys from the environment and refuses
+// insecure settings when APP_ENV is "production":
+//
+//   - JWT_SIGNING_ALG: HS256 (default), RS256 or EdDSA
+//   - JWT_SECRET: the HS256 secret. With RS256/EdDSA it is still accepted for
+//     verifying tokens issued before the switch.
+//   - JWT_PRIVATE_KEY_PATH: PEM private key for RS256/EdDSA
+//   - JWT_PREVIOUS_SECRETS: comma-separated retired HS256 secrets
+//   - JWT_PREVIOUS_PUBLIC_KEYS: comma-separated PEM files of retired public keys
+//
+// Key IDs are derived from the keys, so a retired key keeps the ID its tokens name.
+// Retired keys only verify tokens, so tokens signed before a rotation keep working
+// until they expire.
+func InitSigningKeys() error {
+	production := os.Getenv("APP_ENV") == "production"
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		alg = AlgHS256
+	}
+	secret := os.Getenv("JWT_SECRET")
+
+	var active *signingKey
+	var verifyOnly []*signingKey
+
+	switch alg {
+	case AlgHS256:
+		if err := checkSecret("JWT_SECRET", secret, production); err != nil {
+			return err
+		}
+		if secret == "" {
+			log.Printf("Warning: JWT_SECRET is not set; signing tokens with the development secret")
+			secret = defaultSecret
+		}
+		active = hmacKey(secret)
+	case AlgRS256, AlgEdDSA:
+		path := os.Getenv("JWT_PRIVATE_KEY_PATH")
+		if path == "" {
+			return fmt.Errorf("JWT_PRIVATE_KEY_PATH is required for %s signing", alg)
+		}
+		key, err := loadPrivateKey(alg, path)
+		if err != nil {
+			return err
+		}
+		active = key
+		if secret != "" && !isPlaceholder(secret) {
+			verifyOnly = append(verifyOnly, hmacKey(secret))
+		}
+	default:
+		return fmt.Errorf("unsupported JWT_SIGNING_ALG %q; use %s, %s or %s", alg, AlgHS256, AlgRS256, AlgEdDSA)
+	}
+
+	for _, previous := range splitList(os.Getenv("JWT_PREVIOUS_SECRETS")) {
+		if err := checkSecret("JWT_PREVIOUS_SECRETS", previous, production); err != nil {
+			return err
+		}
+		verifyOnly = append(verifyOnly, hmacKey(previous))
+	}
+	for _, path := range splitList(os.Getenv("JWT_PREVIOUS_PUBLIC_KEYS")) {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return err
+		}
+		verifyOnly = append(verifyOnly, key)
+	}
+
+	ring, err := newKeyring(active, verifyOnly...)
+	if err != nil {
+		return err
+	}
+	signingKeys.Store(ring)
+	return nil
+}
+
+// checkSecret refuses published or weak HMAC secrets in production
+func checkSecret(name, secret string, production bool) error {
+	if !production {
+		return nil
+	}
+	if secret == "" || isPlaceholder(secret) {
+		return fmt.Errorf("%s must be set to a private value in production", name)
+	}
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("%s must be at least %d characters in production", name, minSecretLength)
+	}
+	return nil
+}
+
+func isPlaceholder(secret string) bool {
+	for _, placeholder := range placeholderSecrets {
+		if secret == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+func hmacKey(secret string) *signingKey {
+	return &signingKey{id: deriveKeyID([]byte("hmac:" + secret)), method: jwt.SigningMethodHS256, sign: []byte(secret), verify: []byte(secret)}
+}
+
+// loadPrivateKey reads an RS256 or EdDSA private key from a PEM file
+func loadPrivateKey(alg, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT private key: %w", err)
+	}
+
+	var key *signingKey
+	switch alg {
+	case AlgRS256:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RS256 private key: %w", err)
+		}
+		key = &signingKey{method: jwt.SigningMethodRS256, sign: private}
+		key.verify, err = asymmetricPublicKey(private.Public())
+		if err != nil {
+			return nil, err
+		}
+	case AlgEdDSA:
+		private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse EdDSA private key: %w", err)
+		}
+		edPrivate, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("EdDSA private key is not an Ed25519 key")
+		}
+		key = &signingKey{method: jwt.SigningMethodEdDSA, sign: edPrivate, verify: edPrivate.Public()}
+	}
+
+	if key.id, err = publicKeyID(key.verify); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// loadPublicKey reads a retired RSA or Ed25519 public key from a PEM file
+func loadPublicKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT public key %s is not PEM encoded", path)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT public key %s: %w", path, err)
+	}
+
+	public, err := asymmetricPublicKey(parsed)
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{verify: public}
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	}
+	if key.id, err = publicKeyID(public); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// asymmetricPublicKey accepts the public key types tokens can be verified with
+func asymmetricPublicKey(key crypto.PublicKey) (crypto.PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+		}
+		return k, nil
+	case ed25519.PublicKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported JWT key type %T", key)
+}
+
+// publicKeyID derives a key ID from the public key
+func publicKeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWT public key: %w", err)
+	}
+	return deriveKeyID(der), nil
+}
+
+func deriveKeyID(material []byte) string {
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:8])
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKSet is the document served at the JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns the public keys tokens can be verified with, for other
+// services to verify our tokens. HS256 secrets are never published, so the set is
+// empty unless tokens are signed with RS256 or EdDSA.
+func PublicJWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range signingKeys.Load().order {
+		switch public := key.verify.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.id,
+				Use: "sig",
+				Alg: AlgRS256,
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.id,
+				Use: "sig",
+				Alg: AlgEdDSA,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
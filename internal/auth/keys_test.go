@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useSigningEnv clears the signing settings, applies env and restores the keyring
+// when the test ends
+func useSigningEnv(t *testing.T, env map[string]string) {
+	old := signingKeys.Load()
+	t.Cleanup(func() { signingKeys.Store(old) })
+	for _, name := range []string{"APP_ENV", "JWT_SIGNING_ALG", "JWT_SECRET", "JWT_PRIVATE_KEY_PATH", "JWT_PREVIOUS_SECRETS", "JWT_PREVIOUS_PUBLIC_KEYS"} {
+		t.Setenv(name, env[name])
+	}
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return path
+}
+
+func issue(t *testing.T) string {
+	tkn, err := GenerateToken("userid123", "user@example.com", "user", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken error: %v", err)
+	}
+	return tkn
+}
+
+func TestInitSigningKeys_RefusesDefaultSecretInProduction(t *testing.T) {
+	strong := strings.Repeat("s", minSecretLength)
+	cases := map[string]string{
+		"unset":       "",
+		"built-in":    defaultSecret,
+		"compose":     "your-secret-key-change-in-production",
+		"too short":   "short-secret",
+		"strong":      strong,
+		"development": "",
+	}
+	for name, secret := range cases {
+		t.Run(name, func(t *testing.T) {
+			env := map[string]string{"APP_ENV": "production", "JWT_SECRET": secret}
+			if name == "development" {
+				env["APP_ENV"] = "development"
+			}
+			useSigningEnv(t, env)
+
+			err := InitSigningKeys()
+			if ok := name == "strong" || name == "development"; ok != (err == nil) {
+				t.Fatalf("unexpected result for %s secret: %v", name, err)
+			}
+		})
+	}
+}
+
+func TestInitSigningKeys_RotatedSecretStillValidates(t *testing.T) {
+	useSigningEnv(t, map[string]string{"JWT_SECRET": "old-secret"})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+	oldToken := issue(t)
+
+	useSigningEnv(t, map[string]string{"JWT_SECRET": "new-secret", "JWT_PREVIOUS_SECRETS": "old-secret"})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+	newToken := issue(t)
+
+	if _, err := parseToken(oldToken); err != nil {
+		t.Fatalf("expected token signed with the retired secret to validate: %v", err)
+	}
+	if _, err := parseToken(newToken); err != nil {
+		t.Fatalf("expected token signed with the new secret to validate: %v", err)
+	}
+
+	// Once the old secret is dropped its tokens stop working
+	useSigningEnv(t, map[string]string{"JWT_SECRET": "new-secret"})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+	if _, err := parseToken(oldToken); err == nil {
+		t.Fatalf("expected token signed with a dropped secret to be rejected")
+	}
+}
+
+func TestParseToken_LegacyTokenWithoutKeyID(t *testing.T) {
+	SetSecretKey("test-secret-key")
+	t.Cleanup(func() { SetSecretKey("test-secret-key") })
+
+	claims := &Claims{UserID: "userid123", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}}
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret-key"))
+	if err != nil {
+		t.Fatalf("failed to sign legacy token: %v", err)
+	}
+
+	parsed, err := parseToken(legacy)
+	if err != nil || parsed.UserID != "userid123" {
+		t.Fatalf("expected legacy token to validate, got %v", err)
+	}
+}
+
+func TestInitSigningKeys_RS256WithJWKS(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	path := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(private))
+
+	useSigningEnv(t, map[string]string{"JWT_SIGNING_ALG": AlgRS256, "JWT_PRIVATE_KEY_PATH": path, "JWT_SECRET": "hs-secret"})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+
+	tkn := issue(t)
+	parsed, _, err := jwt.NewParser().ParseUnverified(tkn, &Claims{})
+	if err != nil || parsed.Method.Alg() != AlgRS256 {
+		t.Fatalf("expected an RS256 token, got %v (err=%v)", parsed.Header, err)
+	}
+	if _, err := parseToken(tkn); err != nil {
+		t.Fatalf("expected RS256 token to validate: %v", err)
+	}
+
+	// The JWKS publishes the RSA key under the token's kid, and never the HMAC secret
+	jwks := PublicJWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != parsed.Header["kid"] || jwks.Keys[0].Kty != "RSA" || jwks.Keys[0].E != "AQAB" {
+		t.Fatalf("unexpected JWKS: %+v", jwks)
+	}
+
+	// Tokens issued with the HMAC secret before the switch still validate
+	useSigningEnv(t, map[string]string{"JWT_SECRET": "hs-secret"})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+	hsToken := issue(t)
+	useSigningEnv(t, map[string]string{"JWT_SIGNING_ALG": AlgRS256, "JWT_PRIVATE_KEY_PATH": path, "JWT_SECRET": "hs-secret"})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+	if _, err := parseToken(hsToken); err != nil {
+		t.Fatalf("expected HS256 token from before the switch to validate: %v", err)
+	}
+}
+
+func TestParseToken_RejectsPublicKeyUsedAsHMACSecret(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	path := writePEM(t, "PRIVATE KEY", der)
+
+	useSigningEnv(t, map[string]string{"JWT_SIGNING_ALG": AlgEdDSA, "JWT_PRIVATE_KEY_PATH": path})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+	if _, err := parseToken(issue(t)); err != nil {
+		t.Fatalf("expected EdDSA token to validate: %v", err)
+	}
+	kid := PublicJWKS().Keys[0].Kid
+
+	// An attacker who knows the published key signs an HS256 token naming its kid
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: "attacker", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}})
+	forged.Header["kid"] = kid
+	forgedString, _ := forged.SignedString([]byte(public))
+	if _, err := parseToken(forgedString); err == nil {
+		t.Fatalf("expected token signed with the public key as an HMAC secret to be rejected")
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &Claims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}})
+	unknown.Header["kid"] = "not-a-key"
+	unknownString, _ := unknown.SignedString(private)
+	if _, err := parseToken(unknownString); err == nil {
+		t.Fatalf("expected token naming an unknown key to be rejected")
+	}
+}
+
+func TestInitSigningKeys_RetiredPublicKey(t *testing.T) {
+	oldPublic, oldPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	oldDER, _ := x509.MarshalPKCS8PrivateKey(oldPrivate)
+	oldPath := writePEM(t, "PRIVATE KEY", oldDER)
+	pubDER, _ := x509.MarshalPKIXPublicKey(oldPublic)
+	oldPublicPath := writePEM(t, "PUBLIC KEY", pubDER)
+
+	_, newPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	newDER, _ := x509.MarshalPKCS8PrivateKey(newPrivate)
+	newPath := writePEM(t, "PRIVATE KEY", newDER)
+
+	useSigningEnv(t, map[string]string{"JWT_SIGNING_ALG": AlgEdDSA, "JWT_PRIVATE_KEY_PATH": oldPath})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+	oldToken := issue(t)
+
+	useSigningEnv(t, map[string]string{"JWT_SIGNING_ALG": AlgEdDSA, "JWT_PRIVATE_KEY_PATH": newPath, "JWT_PREVIOUS_PUBLIC_KEYS": oldPublicPath})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("InitSigningKeys error: %v", err)
+	}
+	if _, err := parseToken(oldToken); err != nil {
+		t.Fatalf("expected token signed with the retired key to validate: %v", err)
+	}
+	if keys := PublicJWKS().Keys; len(keys) != 2 || keys[0].Kid == keys[1].Kid {
+		t.Fatalf("expected the new and retired keys to be published, got %+v", keys)
+	}
+}
+
+func TestInitSigningKeys_RejectsBadConfiguration(t *testing.T) {
+	useSigningEnv(t, map[string]string{"JWT_SIGNING_ALG": "none"})
+	if err := InitSigningKeys(); err == nil {
+		t.Fatalf("expected unsupported algorithm to be rejected")
+	}
+
+	useSigningEnv(t, map[string]string{"JWT_SIGNING_ALG": AlgRS256})
+	if err := InitSigningKeys(); err == nil {
+		t.Fatalf("expected RS256 without a private key to be rejected")
+	}
+
+	// An Ed25519 key configured for RS256
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	useSigningEnv(t, map[string]string{"JWT_SIGNING_ALG": AlgRS256, "JWT_PRIVATE_KEY_PATH": writePEM(t, "PRIVATE KEY", der)})
+	if err := InitSigningKeys(); err == nil {
+		t.Fatalf("expected a key of the wrong type to be rejected")
+	}
+}
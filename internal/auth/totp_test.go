@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors for SHA1, truncated to six digits
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil || got != want {
+			t.Fatalf("code at %d: got %q (err=%v), want %q", unix, got, err, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret error: %v", err)
+	}
+	now := time.Now()
+	step := TOTPStep(now)
+	code, _ := TOTPCode(secret, step)
+
+	matched, ok := ValidateTOTP(secret, code, now, 0)
+	if !ok || matched != step {
+		t.Fatalf("expected the current code to validate, got step %d ok=%v", matched, ok)
+	}
+
+	// The previous code is still accepted for clock drift; older ones are not
+	previous, _ := TOTPCode(secret, step-1)
+	if _, ok := ValidateTOTP(secret, previous, now, 0); !ok {
+		t.Fatalf("expected the previous code to validate")
+	}
+	stale, _ := TOTPCode(secret, step-2)
+	if _, ok := ValidateTOTP(secret, stale, now, 0); ok {
+		t.Fatalf("expected a code two steps old to be rejected")
+	}
+
+	// A code already used is refused
+	if _, ok := ValidateTOTP(secret, code, now, step); ok {
+		t.Fatalf("expected a used code to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now, 0); ok {
+		t.Fatalf("expected a short code to be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(TOTPProvisioningURI("Maggiesb", "jane@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatalf("invalid URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || !strings.HasSuffix(uri.Path, "Maggiesb:jane@example.com") {
+		t.Fatalf("unexpected URI %s", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != "JBSWY3DPEHPK3PXP" || query.Get("issuer") != "Maggiesb" || query.Get("digits") != "6" {
+		t.Fatalf("unexpected URI parameters %s", uri.RawQuery)
+	}
+}
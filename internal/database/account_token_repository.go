@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	AccountTokensCollectionName = "account_tokens"
+)
+
+// AccountTokenRepository stores password reset and email verification tokens
+type AccountTokenRepository struct {
+	collection Collection
+}
+
+// NewAccountTokenRepository creates a new account token repository
+func NewAccountTokenRepository() *AccountTokenRepository {
+	return &AccountTokenRepository{collection: NewMongoCollection(GetCollection(DBName, AccountTokensCollectionName))}
+}
+
+// NewAccountTokenRepositoryWithCollection creates an account token repository with custom collection (for testing)
+func NewAccountTokenRepositoryWithCollection(c Collection) *AccountTokenRepository {
+	return &AccountTokenRepository{collection: c}
+}
+
+// CreateAccountToken inserts a new token
+func (ar *AccountTokenRepository) CreateAccountToken(ctx context.Context, token *models.AccountToken) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	token.CreatedAt = time.Now()
+	_, err := ar.collection.InsertOne(ctx, token)
+	if err != nil {
+		return fmt.Errorf("failed to create account token: %w", err)
+	}
+	return nil
+}
+
+// GetAccountToken retrieves a token by the hash of its value
+func (ar *AccountTokenRepository) GetAccountToken(ctx context.Context, tokenHash string) (*models.AccountToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var token models.AccountToken
+	err := ar.collection.FindOne(ctx, bson.M{"_id": tokenHash}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkAccountTokenUsed redeems a token. It returns false without changing anything
+// if the token has already been used or has expired, so a token works only once
+// even when redeemed twice at the same time.
+func (ar *AccountTokenRepository) MarkAccountTokenUsed(ctx context.Context, tokenHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	res, err := ar.collection.UpdateOne(ctx,
+		bson.M{"_id": tokenHash, "usedAt": bson.M{"$exists": false}, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem account token: %w", err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// InvalidateAccountTokens retires a user's outstanding tokens for a purpose, so
+// only the most recently sent link works
+func (ar *AccountTokenRepository) InvalidateAccountTokens(ctx context.Context, userID, purpose string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := ar.collection.UpdateMany(ctx,
+		bson.M{"userId": userID, "purpose": purpose, "usedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate account tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestAccountTokenRepository_OneTimeUse(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping account token repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewAccountTokenRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	now := time.Now()
+	for _, token := range []*models.AccountToken{
+		{ID: "hash-1", UserID: "user-1", Purpose: models.AccountTokenPasswordReset, Email: "a@example.com", ExpiresAt: now.Add(time.Hour)},
+		{ID: "hash-2", UserID: "user-1", Purpose: models.AccountTokenPasswordReset, Email: "a@example.com", ExpiresAt: now.Add(time.Hour)},
+		{ID: "hash-expired", UserID: "user-1", Purpose: models.AccountTokenEmailVerification, Email: "a@example.com", ExpiresAt: now.Add(-time.Minute)},
+	} {
+		if err := repo.CreateAccountToken(ctx, token); err != nil {
+			t.Fatalf("CreateAccountToken error: %v", err)
+		}
+	}
+
+	ok, err := repo.MarkAccountTokenUsed(ctx, "hash-1")
+	if err != nil || !ok {
+		t.Fatalf("expected token to be redeemed (ok=%v, err=%v)", ok, err)
+	}
+	if ok, _ := repo.MarkAccountTokenUsed(ctx, "hash-1"); ok {
+		t.Fatalf("expected a used token not to be redeemed twice")
+	}
+	if ok, _ := repo.MarkAccountTokenUsed(ctx, "hash-expired"); ok {
+		t.Fatalf("expected an expired token not to be redeemed")
+	}
+
+	if err := repo.InvalidateAccountTokens(ctx, "user-1", models.AccountTokenPasswordReset); err != nil {
+		t.Fatalf("InvalidateAccountTokens error: %v", err)
+	}
+	found, err := repo.GetAccountToken(ctx, "hash-2")
+	if err != nil || found.UsedAt == nil {
+		t.Fatalf("expected outstanding token to be invalidated: %+v (err=%v)", found, err)
+	}
+}
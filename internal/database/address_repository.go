@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AddressesCollectionName = "addresses"
+)
+
+// AddressRepository stores users' address books
+type AddressRepository struct {
+	collection Collection
+}
+
+// NewAddressRepository creates a new address repository
+func NewAddressRepository() *AddressRepository {
+	return &AddressRepository{collection: NewMongoCollection(GetCollection(DBName, AddressesCollectionName))}
+}
+
+// NewAddressRepositoryWithCollection creates an address repository with custom collection (for testing)
+func NewAddressRepositoryWithCollection(c Collection) *AddressRepository {
+	return &AddressRepository{collection: c}
+}
+
+// CreateAddress adds an address to a user's address book
+func (ar *AddressRepository) CreateAddress(ctx context.Context, address *models.Address) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	address.CreatedAt = now
+	address.UpdatedAt = now
+
+	_, err := ar.collection.InsertOne(ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to create address: %w", err)
+	}
+	return nil
+}
+
+// GetAddress retrieves one of a user's addresses
+func (ar *AddressRepository) GetAddress(ctx context.Context, userID, addressID string) (*models.Address, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var address models.Address
+	err := ar.collection.FindOne(ctx, bson.M{"_id": addressID, "userId": userID}).Decode(&address)
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// ListAddresses retrieves a user's addresses, the default first and then oldest first
+func (ar *AddressRepository) ListAddresses(ctx context.Context, userID string) ([]*models.Address, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "isDefault", Value: -1}, {Key: "createdAt", Value: 1}})
+	cursor, err := ar.collection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch addresses: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var addresses []*models.Address
+	if err := cursor.All(ctx, &addresses); err != nil {
+		return nil, fmt.Errorf("failed to decode addresses: %w", err)
+	}
+	return addresses, nil
+}
+
+// CountAddresses counts the addresses in a user's address book
+func (ar *AddressRepository) CountAddresses(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := ar.collection.CountDocuments(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count addresses: %w", err)
+	}
+	return count, nil
+}
+
+// UpdateAddress replaces the details of one of a user's addresses. Whether it is
+// the default is changed with SetDefaultAddress. It returns false if the user has
+// no such address.
+func (ar *AddressRepository) UpdateAddress(ctx context.Context, userID string, address *models.Address) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	address.UpdatedAt = time.Now()
+	result, err := ar.collection.UpdateOne(ctx, bson.M{"_id": address.ID, "userId": userID}, bson.M{"$set": bson.M{
+		"label":        address.Label,
+		"county":       address.County,
+		"town":         address.Town,
+		"landmark":     address.Landmark,
+		"details":      address.Details,
+		"location":     address.Location,
+		"contactName":  address.ContactName,
+		"contactPhone": address.ContactPhone,
+		"updatedAt":    address.UpdatedAt,
+	}})
+	if err != nil {
+		return false, fmt.Errorf("failed to update address: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// SetDefaultAddress makes one of a user's addresses their default, and the others
+// not. It returns false if the user has no such address.
+func (ar *AddressRepository) SetDefaultAddress(ctx context.Context, userID, addressID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := ar.collection.UpdateOne(ctx, bson.M{"_id": addressID, "userId": userID}, bson.M{
+		"$set": bson.M{"isDefault": true, "updatedAt": now},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to set default address: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+
+	_, err = ar.collection.UpdateMany(ctx, bson.M{"userId": userID, "_id": bson.M{"$ne": addressID}, "isDefault": true}, bson.M{
+		"$set": bson.M{"isDefault": false, "updatedAt": now},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to set default address: %w", err)
+	}
+	return true, nil
+}
+
+// DeleteAddress removes one of a user's addresses. It returns false if the user
+// has no such address.
+func (ar *AddressRepository) DeleteAddress(ctx context.Context, userID, addressID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ar.collection.DeleteOne(ctx, bson.M{"_id": addressID, "userId": userID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete address: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// DeleteAddressesByUser empties a user's address book
+func (ar *AddressRepository) DeleteAddressesByUser(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ar.collection.DeleteMany(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete addresses: %w", err)
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestAddressRepository_DefaultAndDelete(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping address repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewAddressRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	home := &models.Address{ID: "addr-home", UserID: "u-addr", County: "Nairobi", Town: "Kilimani", IsDefault: true}
+	office := &models.Address{ID: "addr-office", UserID: "u-addr", County: "Nairobi", Town: "Westlands"}
+	other := &models.Address{ID: "addr-other", UserID: "u-other", County: "Mombasa", Town: "Nyali", IsDefault: true}
+	for _, a := range []*models.Address{home, office, other} {
+		if err := repo.CreateAddress(ctx, a); err != nil {
+			t.Fatalf("CreateAddress error: %v", err)
+		}
+	}
+
+	// Another user's address can be neither read nor made the default
+	if _, err := repo.GetAddress(ctx, "u-addr", other.ID); err == nil {
+		t.Fatalf("expected another user's address to be hidden")
+	}
+	if ok, err := repo.SetDefaultAddress(ctx, "u-addr", other.ID); err != nil || ok {
+		t.Fatalf("SetDefaultAddress on another user's address: ok=%v err=%v", ok, err)
+	}
+
+	if ok, err := repo.SetDefaultAddress(ctx, "u-addr", office.ID); err != nil || !ok {
+		t.Fatalf("SetDefaultAddress: ok=%v err=%v", ok, err)
+	}
+	addresses, err := repo.ListAddresses(ctx, "u-addr")
+	if err != nil || len(addresses) != 2 || addresses[0].ID != office.ID || addresses[1].IsDefault {
+		t.Fatalf("expected the office first and only default, got %+v (err=%v)", addresses, err)
+	}
+	if got, _ := repo.GetAddress(ctx, "u-other", other.ID); got == nil || !got.IsDefault {
+		t.Fatalf("expected other users' defaults to be untouched, got %+v", got)
+	}
+
+	office.Details = "5th floor"
+	if ok, err := repo.UpdateAddress(ctx, "u-addr", office); err != nil || !ok {
+		t.Fatalf("UpdateAddress: ok=%v err=%v", ok, err)
+	}
+	if got, _ := repo.GetAddress(ctx, "u-addr", office.ID); got == nil || got.Details != "5th floor" || !got.IsDefault {
+		t.Fatalf("expected updated details on the default address, got %+v", got)
+	}
+
+	if n, err := repo.DeleteAddressesByUser(ctx, "u-addr"); err != nil || n != 2 {
+		t.Fatalf("DeleteAddressesByUser: n=%d err=%v", n, err)
+	}
+	if count, _ := repo.CountAddresses(ctx, "u-other"); count != 1 {
+		t.Fatalf("expected other users' addresses to be kept, got %d", count)
+	}
+}
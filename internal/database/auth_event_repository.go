@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuthEventsCollectionName = "auth_events"
+)
+
+// AuthEventRepository stores the audit log of lockouts and other sign-in changes
+type AuthEventRepository struct {
+	collection Collection
+}
+
+// NewAuthEventRepository creates a new auth event repository
+func NewAuthEventRepository() *AuthEventRepository {
+	return &AuthEventRepository{collection: NewMongoCollection(GetCollection(DBName, AuthEventsCollectionName))}
+}
+
+// NewAuthEventRepositoryWithCollection creates an auth event repository with custom collection (for testing)
+func NewAuthEventRepositoryWithCollection(c Collection) *AuthEventRepository {
+	return &AuthEventRepository{collection: c}
+}
+
+// RecordAuthEvent inserts an audit entry
+func (ar *AuthEventRepository) RecordAuthEvent(ctx context.Context, event *models.AuthEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	event.CreatedAt = time.Now()
+	_, err := ar.collection.InsertOne(ctx, event)
+	if err != nil {
+		return fmt.Errorf("failed to record auth event: %w", err)
+	}
+	return nil
+}
+
+// ListAuthEvents retrieves audit entries, newest first, optionally of one type or
+// for one user
+func (ar *AuthEventRepository) ListAuthEvents(ctx context.Context, eventType, userID string, page, limit int) ([]*models.AuthEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := ar.collection.Find(ctx, authEventFilter(eventType, userID), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch auth events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var events []*models.AuthEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode auth events: %w", err)
+	}
+	return events, nil
+}
+
+// CountAuthEvents counts audit entries matching the same filter as ListAuthEvents
+func (ar *AuthEventRepository) CountAuthEvents(ctx context.Context, eventType, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := ar.collection.CountDocuments(ctx, authEventFilter(eventType, userID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count auth events: %w", err)
+	}
+	return count, nil
+}
+
+func authEventFilter(eventType, userID string) bson.M {
+	filter := bson.M{}
+	if eventType != "" {
+		filter["type"] = eventType
+	}
+	if userID != "" {
+		filter["userId"] = userID
+	}
+	return filter
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	C2BTransactionsCollectionName = "c2b_transactions"
+)
+
+type C2BRepository struct {
+	collection *mongo.Collection
+}
+
+// NewC2BRepository creates a new C2B transaction repository
+func NewC2BRepository() *C2BRepository {
+	return &C2BRepository{collection: GetCollection(DBName, C2BTransactionsCollectionName)}
+}
+
+// CreateC2BTransaction records a confirmed Paybill/Till payment. Each M-Pesa
+// transaction ID is recorded once, so repeated confirmations are rejected.
+func (cr *C2BRepository) CreateC2BTransaction(ctx context.Context, txn *models.C2BTransaction) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	txn.CreatedAt = time.Now()
+	txn.UpdatedAt = time.Now()
+
+	_, err := cr.collection.InsertOne(ctx, txn)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("c2b transaction already recorded")
+		}
+		return fmt.Errorf("failed to create c2b transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteC2BTransaction removes a C2B transaction whose invoice could not be credited
+func (cr *C2BRepository) DeleteC2BTransaction(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := cr.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete c2b transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetC2BTransactionByID retrieves a C2B transaction by ID
+func (cr *C2BRepository) GetC2BTransactionByID(ctx context.Context, id string) (*models.C2BTransaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var txn models.C2BTransaction
+	err := cr.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&txn)
+	if err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+// GetC2BTransactionsByStatus retrieves C2B transactions in a status with pagination, oldest first
+func (cr *C2BRepository) GetC2BTransactionsByStatus(ctx context.Context, status string, page, limit int) ([]*models.C2BTransaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := cr.collection.Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch c2b transactions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var txns []*models.C2BTransaction
+	if err := cursor.All(ctx, &txns); err != nil {
+		return nil, fmt.Errorf("failed to decode c2b transactions: %w", err)
+	}
+	return txns, nil
+}
+
+// AllocateC2BTransaction assigns a suspense payment to an invoice. It fails if the
+// payment is no longer in suspense, so two admins cannot allocate it twice.
+func (cr *C2BRepository) AllocateC2BTransaction(ctx context.Context, id, invoiceID, adminID, note string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": models.C2BStatusSuspense},
+		bson.M{"$set": bson.M{
+			"status":         models.C2BStatusAllocated,
+			"invoiceId":      invoiceID,
+			"allocatedBy":    adminID,
+			"allocationNote": note,
+			"allocatedAt":    now,
+			"updatedAt":      now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to allocate c2b transaction: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("c2b transaction not found or already allocated")
+	}
+
+	return nil
+}
+
+// ReleaseC2BAllocation returns an allocated payment to the suspense queue
+func (cr *C2BRepository) ReleaseC2BAllocation(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": models.C2BStatusAllocated},
+		bson.M{
+			"$set":   bson.M{"status": models.C2BStatusSuspense, "updatedAt": time.Now()},
+			"$unset": bson.M{"invoiceId": "", "allocatedBy": "", "allocationNote": "", "allocatedAt": ""},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release c2b allocation: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestC2BRepository_RecordAndAllocate(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping c2b repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	if err := CreateIndexes(); err != nil {
+		t.Fatalf("CreateIndexes error: %v", err)
+	}
+
+	repo := NewC2BRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	txn := &models.C2BTransaction{ID: "c2b-test-1", TransID: "SAB1CD2EF3", Amount: 750, BillRefNumber: "order 42", Status: models.C2BStatusSuspense}
+	if err := repo.CreateC2BTransaction(ctx, txn); err != nil {
+		t.Fatalf("CreateC2BTransaction error: %v", err)
+	}
+
+	// M-Pesa resending the same confirmation is rejected by the unique index
+	dup := &models.C2BTransaction{ID: "c2b-test-2", TransID: "SAB1CD2EF3", Amount: 750, Status: models.C2BStatusSuspense}
+	if err := repo.CreateC2BTransaction(ctx, dup); err == nil || err.Error() != "c2b transaction already recorded" {
+		t.Fatalf("expected duplicate TransID to be rejected, got %v", err)
+	}
+
+	suspense, err := repo.GetC2BTransactionsByStatus(ctx, models.C2BStatusSuspense, 1, 10)
+	if err != nil || len(suspense) != 1 {
+		t.Fatalf("expected one suspense payment, got %d (err=%v)", len(suspense), err)
+	}
+
+	if err := repo.AllocateC2BTransaction(ctx, "c2b-test-1", "inv-1", "admin-1", "matched by phone"); err != nil {
+		t.Fatalf("AllocateC2BTransaction error: %v", err)
+	}
+	if err := repo.AllocateC2BTransaction(ctx, "c2b-test-1", "inv-2", "admin-2", ""); err == nil {
+		t.Fatalf("expected second allocation to fail")
+	}
+
+	found, _ := repo.GetC2BTransactionByID(ctx, "c2b-test-1")
+	if found.Status != models.C2BStatusAllocated || found.InvoiceID != "inv-1" || found.AllocatedBy != "admin-1" {
+		t.Fatalf("unexpected allocation: %+v", found)
+	}
+
+	if err := repo.ReleaseC2BAllocation(ctx, "c2b-test-1"); err != nil {
+		t.Fatalf("ReleaseC2BAllocation error: %v", err)
+	}
+	found, _ = repo.GetC2BTransactionByID(ctx, "c2b-test-1")
+	if found.Status != models.C2BStatusSuspense || found.InvoiceID != "" {
+		t.Fatalf("expected payment back in suspense, got %+v", found)
+	}
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
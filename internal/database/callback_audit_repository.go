@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RejectedCallbacksCollectionName = "rejected_callbacks"
+)
+
+// CallbackAuditRepository stores callbacks the shop refused to act on
+type CallbackAuditRepository struct {
+	collection Collection
+}
+
+// NewCallbackAuditRepository creates a new callback audit repository
+func NewCallbackAuditRepository() *CallbackAuditRepository {
+	return &CallbackAuditRepository{collection: NewMongoCollection(GetCollection(DBName, RejectedCallbacksCollectionName))}
+}
+
+// NewCallbackAuditRepositoryWithCollection creates a callback audit repository with custom collection (for testing)
+func NewCallbackAuditRepositoryWithCollection(c Collection) *CallbackAuditRepository {
+	return &CallbackAuditRepository{collection: c}
+}
+
+// RecordRejectedCallback inserts a rejected callback audit entry
+func (cr *CallbackAuditRepository) RecordRejectedCallback(ctx context.Context, entry *models.RejectedCallback) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	entry.CreatedAt = time.Now()
+	_, err := cr.collection.InsertOne(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("failed to record rejected callback: %w", err)
+	}
+	return nil
+}
+
+// ListRejectedCallbacks retrieves rejected callbacks, newest first. An empty source
+// lists every source.
+func (cr *CallbackAuditRepository) ListRejectedCallbacks(ctx context.Context, source string, page, limit int) ([]*models.RejectedCallback, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := cr.collection.Find(ctx, rejectedCallbackFilter(source), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rejected callbacks: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var entries []*models.RejectedCallback
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode rejected callbacks: %w", err)
+	}
+	return entries, nil
+}
+
+// CountRejectedCallbacks counts rejected callbacks from a source, or all of them
+func (cr *CallbackAuditRepository) CountRejectedCallbacks(ctx context.Context, source string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := cr.collection.CountDocuments(ctx, rejectedCallbackFilter(source))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rejected callbacks: %w", err)
+	}
+	return count, nil
+}
+
+func rejectedCallbackFilter(source string) bson.M {
+	if source == "" {
+		return bson.M{}
+	}
+	return bson.M{"source": source}
+}
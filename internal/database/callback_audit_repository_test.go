@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestCallbackAuditRepository_RecordAndList(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping callback audit repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewCallbackAuditRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	entries := []*models.RejectedCallback{
+		{ID: "cb-test-1", Source: models.CallbackSourceSTKPush, Reason: models.CallbackRejectTokenMismatch, RemoteIP: "10.0.0.1", Reference: "ws_CO_1"},
+		{ID: "cb-test-2", Source: models.CallbackSourceB2C, Reason: models.CallbackRejectIPNotAllowed, RemoteIP: "10.0.0.2"},
+	}
+	for _, entry := range entries {
+		if err := repo.RecordRejectedCallback(ctx, entry); err != nil {
+			t.Fatalf("RecordRejectedCallback error: %v", err)
+		}
+	}
+
+	found, err := repo.ListRejectedCallbacks(ctx, models.CallbackSourceSTKPush, 1, 10)
+	if err != nil || len(found) != 1 || found[0].ID != "cb-test-1" {
+		t.Fatalf("expected one STK rejection, got %+v (err=%v)", found, err)
+	}
+
+	count, err := repo.CountRejectedCallbacks(ctx, "")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 rejected callbacks, got %d (err=%v)", count, err)
+	}
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
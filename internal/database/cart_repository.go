@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CartsCollectionName = "carts"
+)
+
+type CartRepository struct {
+	collection *mongo.Collection
+}
+
+// NewCartRepository creates a new cart repository
+func NewCartRepository() *CartRepository {
+	return &CartRepository{collection: GetCollection(DBName, CartsCollectionName)}
+}
+
+// GetCartByUser retrieves a user's cart. A user without a cart gets an empty one.
+func (cr *CartRepository) GetCartByUser(ctx context.Context, userID string) (*models.Cart, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var cart models.Cart
+	err := cr.collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&cart)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &models.Cart{UserID: userID, Items: []models.CartItem{}}, nil
+		}
+		return nil, fmt.Errorf("failed to fetch cart: %w", err)
+	}
+	return &cart, nil
+}
+
+// AddItem adds units of a product to a user's cart, creating the cart if needed.
+// A product has at most one line, even when added from two requests at once.
+func (cr *CartRepository) AddItem(ctx context.Context, userID string, productID string, quantity int) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		now := time.Now()
+
+		// Bump the quantity if the product is already in the cart
+		result, err := cr.collection.UpdateOne(
+			ctx,
+			bson.M{"_id": userID, "items.productId": productID},
+			bson.M{
+				"$inc": bson.M{"items.$.quantity": quantity},
+				"$set": bson.M{"updatedAt": now},
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add cart item: %w", err)
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+
+		// Otherwise append a new line, creating the cart on first use. The push only
+		// matches a cart without the product, so when a concurrent add got there
+		// first the upsert collides with the existing cart and the add starts over.
+		item := models.CartItem{ProductID: productID, Quantity: quantity, AddedAt: now}
+		_, err = cr.collection.UpdateOne(
+			ctx,
+			bson.M{"_id": userID, "items.productId": bson.M{"$ne": productID}},
+			bson.M{
+				"$push":        bson.M{"items": item},
+				"$set":         bson.M{"updatedAt": now},
+				"$setOnInsert": bson.M{"createdAt": now},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if mongo.IsDuplicateKeyError(err) && attempt < 2 {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add cart item: %w", err)
+		}
+		return nil
+	}
+}
+
+// UpdateItemQuantity sets the quantity of a product already in the cart
+func (cr *CartRepository) UpdateItemQuantity(ctx context.Context, userID string, productID string, quantity int) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "items.productId": productID},
+		bson.M{"$set": bson.M{"items.$.quantity": quantity, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update cart item: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("cart item not found")
+	}
+	return nil
+}
+
+// RemoveItem removes a product from the cart
+func (cr *CartRepository) RemoveItem(ctx context.Context, userID string, productID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "items.productId": productID},
+		bson.M{
+			"$pull": bson.M{"items": bson.M{"productId": productID}},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove cart item: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("cart item not found")
+	}
+	return nil
+}
+
+// ClearCart empties a user's cart (used after checkout)
+func (cr *CartRepository) ClearCart(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"items": []models.CartItem{}, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear cart: %w", err)
+	}
+	return nil
+}
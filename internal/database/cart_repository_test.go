@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCartRepository_ItemLifecycle(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping cart repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewCartRepository()
+	ctx := context.Background()
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	userID := "cart-user-1"
+
+	// Empty cart for a new user
+	cart, err := repo.GetCartByUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetCartByUser error: %v", err)
+	}
+	if len(cart.Items) != 0 {
+		t.Fatalf("expected empty cart, got %d items", len(cart.Items))
+	}
+
+	// Adding the same product twice merges the lines
+	if err := repo.AddItem(ctx, userID, "p1", 1); err != nil {
+		t.Fatalf("AddItem error: %v", err)
+	}
+	if err := repo.AddItem(ctx, userID, "p1", 2); err != nil {
+		t.Fatalf("AddItem error: %v", err)
+	}
+	if err := repo.AddItem(ctx, userID, "p2", 1); err != nil {
+		t.Fatalf("AddItem error: %v", err)
+	}
+
+	cart, _ = repo.GetCartByUser(ctx, userID)
+	if len(cart.Items) != 2 || cart.Items[0].Quantity != 3 {
+		t.Fatalf("unexpected cart contents: %+v", cart.Items)
+	}
+
+	if err := repo.UpdateItemQuantity(ctx, userID, "p2", 5); err != nil {
+		t.Fatalf("UpdateItemQuantity error: %v", err)
+	}
+	if err := repo.UpdateItemQuantity(ctx, userID, "missing", 5); err == nil {
+		t.Fatalf("expected error updating missing item")
+	}
+
+	if err := repo.RemoveItem(ctx, userID, "p1"); err != nil {
+		t.Fatalf("RemoveItem error: %v", err)
+	}
+
+	cart, _ = repo.GetCartByUser(ctx, userID)
+	if len(cart.Items) != 1 || cart.Items[0].ProductID != "p2" || cart.Items[0].Quantity != 5 {
+		t.Fatalf("unexpected cart contents after update/remove: %+v", cart.Items)
+	}
+
+	// Concurrent adds of a product not yet in the cart still make one line
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := repo.AddItem(ctx, "cart-user-2", "p3", 1); err != nil {
+				t.Errorf("concurrent AddItem error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	cart, _ = repo.GetCartByUser(ctx, "cart-user-2")
+	if len(cart.Items) != 1 || cart.Items[0].Quantity != 8 {
+		t.Fatalf("expected one line of 8 units after concurrent adds, got %+v", cart.Items)
+	}
+
+	if err := repo.ClearCart(ctx, userID); err != nil {
+		t.Fatalf("ClearCart error: %v", err)
+	}
+	cart, _ = repo.GetCartByUser(ctx, userID)
+	if len(cart.Items) != 0 {
+		t.Fatalf("expected cleared cart, got %d items", len(cart.Items))
+	}
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
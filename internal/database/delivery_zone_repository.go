@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DeliveryZonesCollectionName = "delivery_zones"
+)
+
+// DeliveryZoneRepository stores the delivery zones set up by admins
+type DeliveryZoneRepository struct {
+	collection Collection
+}
+
+// NewDeliveryZoneRepository creates a new delivery zone repository
+func NewDeliveryZoneRepository() *DeliveryZoneRepository {
+	return &DeliveryZoneRepository{collection: NewMongoCollection(GetCollection(DBName, DeliveryZonesCollectionName))}
+}
+
+// NewDeliveryZoneRepositoryWithCollection creates a delivery zone repository with custom collection (for testing)
+func NewDeliveryZoneRepositoryWithCollection(c Collection) *DeliveryZoneRepository {
+	return &DeliveryZoneRepository{collection: c}
+}
+
+// CreateDeliveryZone inserts a new delivery zone
+func (dr *DeliveryZoneRepository) CreateDeliveryZone(ctx context.Context, zone *models.DeliveryZone) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	zone.CreatedAt = now
+	zone.UpdatedAt = now
+
+	_, err := dr.collection.InsertOne(ctx, zone)
+	if err != nil {
+		return fmt.Errorf("failed to create delivery zone: %w", err)
+	}
+	return nil
+}
+
+// GetDeliveryZone retrieves a delivery zone by its ID
+func (dr *DeliveryZoneRepository) GetDeliveryZone(ctx context.Context, zoneID string) (*models.DeliveryZone, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var zone models.DeliveryZone
+	err := dr.collection.FindOne(ctx, bson.M{"_id": zoneID}).Decode(&zone)
+	if err != nil {
+		return nil, err
+	}
+	return &zone, nil
+}
+
+// ListDeliveryZones retrieves the delivery zones ordered by county and name,
+// optionally only the active ones
+func (dr *DeliveryZoneRepository) ListDeliveryZones(ctx context.Context, activeOnly bool) ([]*models.DeliveryZone, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if activeOnly {
+		filter["active"] = true
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "county", Value: 1}, {Key: "name", Value: 1}})
+	cursor, err := dr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch delivery zones: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var zones []*models.DeliveryZone
+	if err := cursor.All(ctx, &zones); err != nil {
+		return nil, fmt.Errorf("failed to decode delivery zones: %w", err)
+	}
+	return zones, nil
+}
+
+// UpdateDeliveryZone replaces a zone's settings. It returns false if there is no
+// such zone.
+func (dr *DeliveryZoneRepository) UpdateDeliveryZone(ctx context.Context, zone *models.DeliveryZone) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	zone.UpdatedAt = time.Now()
+	result, err := dr.collection.UpdateOne(ctx, bson.M{"_id": zone.ID}, bson.M{"$set": bson.M{
+		"name":          zone.Name,
+		"county":        zone.County,
+		"towns":         zone.Towns,
+		"fee":           zone.Fee,
+		"leadTimeHours": zone.LeadTimeHours,
+		"active":        zone.Active,
+		"updatedAt":     zone.UpdatedAt,
+	}})
+	if err != nil {
+		return false, fmt.Errorf("failed to update delivery zone: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// DeleteDeliveryZone removes a delivery zone. Orders keep the fee and lead time
+// they were placed with. It returns false if there is no such zone.
+func (dr *DeliveryZoneRepository) DeleteDeliveryZone(ctx context.Context, zoneID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := dr.collection.DeleteOne(ctx, bson.M{"_id": zoneID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete delivery zone: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -37,6 +38,57 @@ func CreateIndexes() error {
 		return fmt.Errorf("failed to create unique index on users email: %w", err)
 	}
 
+	_, err = userCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "roles", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on users roles: %w", err)
+	}
+
+	// Create indexes so a user's orders, and their invoices, can be listed
+	_, err = GetCollection(DBName, OrdersCollectionName).Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "user", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on orders user: %w", err)
+	}
+
+	_, err = GetCollection(DBName, InvoicesCollectionName).Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "orderId", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on invoices orderId: %w", err)
+	}
+
+	// Create index so a user's address book can be listed
+	_, err = GetCollection(DBName, AddressesCollectionName).Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on addresses userId: %w", err)
+	}
+
+	// Create indexes so an order's shipments can be listed, and a deleted user's
+	// shipments and signatures found
+	shipmentCollection := GetCollection(DBName, ShipmentsCollectionName)
+
+	for _, keys := range []bson.D{
+		{{Key: "orderId", Value: 1}, {Key: "createdAt", Value: 1}},
+		{{Key: "userId", Value: 1}},
+	} {
+		_, err = shipmentCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: keys})
+		if err != nil {
+			return fmt.Errorf("failed to create index on shipments %s: %w", keys[0].Key, err)
+		}
+	}
+
+	_, err = GetCollection(DBName, DeliverySignaturesCollectionName).Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on delivery_signatures userId: %w", err)
+	}
+
 	// Create index on products collection for name searches
 	productCollection := GetCollection(DBName, ProductsCollectionName)
 
@@ -49,5 +101,306 @@ func CreateIndexes() error {
 		return fmt.Errorf("failed to create text index on products: %w", err)
 	}
 
+	// Create index on stock_adjustments for per-product audit history
+	adjustmentCollection := GetCollection(DBName, StockAdjustmentsCollectionName)
+
+	adjustmentIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "productId", Value: 1}, {Key: "createdAt", Value: -1}},
+	}
+
+	_, err = adjustmentCollection.Indexes().CreateOne(context.Background(), adjustmentIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on stock_adjustments: %w", err)
+	}
+
+	// Create index on carts so stale (abandoned) carts can be found by last activity
+	cartCollection := GetCollection(DBName, CartsCollectionName)
+
+	cartIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "updatedAt", Value: 1}},
+	}
+
+	_, err = cartCollection.Indexes().CreateOne(context.Background(), cartIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on carts: %w", err)
+	}
+
+	// Create unique index on promotions so coupon codes resolve to a single campaign
+	promotionCollection := GetCollection(DBName, PromotionsCollectionName)
+
+	promotionIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	_, err = promotionCollection.Indexes().CreateOne(context.Background(), promotionIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on promotions code: %w", err)
+	}
+
+	// Create index on promotion_redemptions for per-user limits and campaign reports
+	redemptionCollection := GetCollection(DBName, RedemptionsCollectionName)
+
+	redemptionIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "promotionId", Value: 1}, {Key: "userId", Value: 1}},
+	}
+
+	_, err = redemptionCollection.Indexes().CreateOne(context.Background(), redemptionIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on promotion_redemptions: %w", err)
+	}
+
+	// Create index on payment_records so the reconciler can find stale initiated payments
+	paymentCollection := GetCollection(DBName, PaymentRecordsCollectionName)
+
+	paymentIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}},
+	}
+
+	_, err = paymentCollection.Indexes().CreateOne(context.Background(), paymentIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on payment_records: %w", err)
+	}
+
+	// Create unique indexes on payment_records so each M-Pesa transaction is recorded once
+	checkoutIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "checkoutRequestId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	_, err = paymentCollection.Indexes().CreateOne(context.Background(), checkoutIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on payment_records checkoutRequestId: %w", err)
+	}
+
+	// Failed and pending payments have no receipt, so only non-empty receipts must be unique
+	receiptIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "mpesaReceiptNumber", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"mpesaReceiptNumber": bson.M{"$gt": ""},
+		}),
+	}
+
+	_, err = paymentCollection.Indexes().CreateOne(context.Background(), receiptIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on payment_records mpesaReceiptNumber: %w", err)
+	}
+
+	// Create index on payment_records for the admin review queue of over/under-payments
+	reviewIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "needsReview", Value: 1}, {Key: "createdAt", Value: 1}},
+	}
+
+	_, err = paymentCollection.Indexes().CreateOne(context.Background(), reviewIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on payment_records needsReview: %w", err)
+	}
+
+	// Create index on payment_records so users can list every attempt for an invoice
+	paymentInvoiceIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "invoiceId", Value: 1}, {Key: "createdAt", Value: -1}},
+	}
+
+	_, err = paymentCollection.Indexes().CreateOne(context.Background(), paymentInvoiceIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on payment_records invoiceId: %w", err)
+	}
+
+	// Create indexes on payment_records for admin search by phone
+	for _, field := range []string{"phone", "payerPhone"} {
+		_, err = paymentCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+			Keys: bson.D{{Key: field, Value: 1}, {Key: "createdAt", Value: -1}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create index on payment_records %s: %w", field, err)
+		}
+	}
+
+	// Create unique index on c2b_transactions so each Paybill/Till payment is recorded once
+	c2bCollection := GetCollection(DBName, C2BTransactionsCollectionName)
+
+	c2bTransIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "transId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	_, err = c2bCollection.Indexes().CreateOne(context.Background(), c2bTransIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on c2b_transactions transId: %w", err)
+	}
+
+	// Create index on c2b_transactions for the admin suspense queue
+	c2bStatusIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}},
+	}
+
+	_, err = c2bCollection.Indexes().CreateOne(context.Background(), c2bStatusIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on c2b_transactions status: %w", err)
+	}
+
+	// Create indexes on reversals so M-Pesa result callbacks can find their reversal
+	reversalCollection := GetCollection(DBName, ReversalsCollectionName)
+
+	for _, field := range []string{"conversationId", "originatorConversationId"} {
+		_, err = reversalCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+			Keys: bson.D{{Key: field, Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{
+				field: bson.M{"$gt": ""},
+			}),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create index on reversals %s: %w", field, err)
+		}
+	}
+
+	// Create index on reversals for looking up the refund owed on an invoice
+	reversalInvoiceIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "invoiceId", Value: 1}, {Key: "createdAt", Value: -1}},
+	}
+
+	_, err = reversalCollection.Indexes().CreateOne(context.Background(), reversalInvoiceIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on reversals invoiceId: %w", err)
+	}
+
+	// Create indexes on refunds for B2C result callbacks and invoice history
+	refundCollection := GetCollection(DBName, RefundsCollectionName)
+
+	for _, field := range []string{"conversationId", "originatorConversationId"} {
+		_, err = refundCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+			Keys: bson.D{{Key: field, Value: 1}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create index on refunds %s: %w", field, err)
+		}
+	}
+
+	refundInvoiceIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "invoiceId", Value: 1}, {Key: "createdAt", Value: -1}},
+	}
+
+	_, err = refundCollection.Indexes().CreateOne(context.Background(), refundInvoiceIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on refunds invoiceId: %w", err)
+	}
+
+	// One pending refund per invoice, so two payout requests cannot both go out
+	refundPendingIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "invoiceId", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"status": models.RefundStatusPending,
+		}),
+	}
+
+	_, err = refundCollection.Indexes().CreateOne(context.Background(), refundPendingIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on refunds pending invoiceId: %w", err)
+	}
+
+	refundReversalIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "reversalId", Value: 1}, {Key: "status", Value: 1}},
+	}
+
+	_, err = refundCollection.Indexes().CreateOne(context.Background(), refundReversalIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on refunds reversalId: %w", err)
+	}
+
+	// Create indexes on rejected callbacks for the audit log; entries expire after 90 days
+	rejectedCallbackCollection := GetCollection(DBName, RejectedCallbacksCollectionName)
+
+	rejectedCallbackIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "createdAt", Value: -1}},
+		Options: options.Index().SetExpireAfterSeconds(90 * 24 * 60 * 60),
+	}
+
+	_, err = rejectedCallbackCollection.Indexes().CreateOne(context.Background(), rejectedCallbackIndexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create index on rejected_callbacks createdAt: %w", err)
+	}
+
+	_, err = rejectedCallbackCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "source", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on rejected_callbacks source: %w", err)
+	}
+
+	// Create indexes on sessions for listing a user's devices; sessions are removed
+	// once their refresh token expires
+	sessionCollection := GetCollection(DBName, SessionsCollectionName)
+
+	_, err = sessionCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "lastUsedAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on sessions userId: %w", err)
+	}
+
+	_, err = sessionCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on sessions expiresAt: %w", err)
+	}
+
+	// Create indexes on password reset and email verification tokens; tokens are
+	// removed a day after they expire
+	accountTokenCollection := GetCollection(DBName, AccountTokensCollectionName)
+
+	_, err = accountTokenCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "purpose", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on account_tokens userId: %w", err)
+	}
+
+	_, err = accountTokenCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on account_tokens expiresAt: %w", err)
+	}
+
+	// Create indexes on failed login counters; counters are removed once their
+	// failures, and any lockout, are past the failure window
+	loginThrottleCollection := GetCollection(DBName, LoginThrottlesCollectionName)
+
+	_, err = loginThrottleCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on login_throttles expiresAt: %w", err)
+	}
+
+	_, err = loginThrottleCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "lockedUntil", Value: -1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on login_throttles lockedUntil: %w", err)
+	}
+
+	// Create indexes on the sign-in audit log
+	authEventCollection := GetCollection(DBName, AuthEventsCollectionName)
+
+	_, err = authEventCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on auth_events userId: %w", err)
+	}
+
+	_, err = authEventCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "type", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index on auth_events type: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	StockAdjustmentsCollectionName = "stock_adjustments"
+)
+
+type InventoryRepository struct {
+	collection Collection
+}
+
+// NewInventoryRepository creates a new inventory repository
+func NewInventoryRepository() *InventoryRepository {
+	return &InventoryRepository{collection: NewMongoCollection(GetCollection(DBName, StockAdjustmentsCollectionName))}
+}
+
+// NewInventoryRepositoryWithCollection creates an inventory repository with custom collection (for testing)
+func NewInventoryRepositoryWithCollection(c Collection) *InventoryRepository {
+	return &InventoryRepository{collection: c}
+}
+
+// CreateStockAdjustment inserts a stock adjustment audit record
+func (ir *InventoryRepository) CreateStockAdjustment(ctx context.Context, adj *models.StockAdjustment) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	adj.CreatedAt = time.Now()
+	_, err := ir.collection.InsertOne(ctx, adj)
+	if err != nil {
+		return fmt.Errorf("failed to create stock adjustment: %w", err)
+	}
+	return nil
+}
+
+// GetStockAdjustmentsByProduct retrieves a product's stock adjustments, newest first
+func (ir *InventoryRepository) GetStockAdjustmentsByProduct(ctx context.Context, productID string, page, limit int) ([]*models.StockAdjustment, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := ir.collection.Find(ctx, bson.M{"productId": productID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stock adjustments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var adjustments []*models.StockAdjustment
+	if err := cursor.All(ctx, &adjustments); err != nil {
+		return nil, fmt.Errorf("failed to decode stock adjustments: %w", err)
+	}
+	return adjustments, nil
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestInventoryRepository_CreateStockAdjustment_Mock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	mockCollection.On("InsertOne", mock.Anything, mock.MatchedBy(func(doc interface{}) bool {
+		adj, ok := doc.(*models.StockAdjustment)
+		return ok && adj.ID == "adj-1" && adj.Delta == -2
+	})).Return(&mongo.InsertOneResult{InsertedID: "adj-1"}, nil)
+
+	repo := NewInventoryRepositoryWithCollection(mockCollection)
+	ctx := context.Background()
+
+	adj := &models.StockAdjustment{
+		ID:         "adj-1",
+		ProductID:  "prod-1",
+		Delta:      -2,
+		StockAfter: 8,
+		Reason:     "damaged in transit",
+		AdminID:    "admin-1",
+	}
+
+	err := repo.CreateStockAdjustment(ctx, adj)
+
+	assert.NoError(t, err)
+	mockCollection.AssertCalled(t, "InsertOne", mock.Anything, mock.Anything)
+	assert.NotZero(t, adj.CreatedAt)
+}
+
+func TestInventoryRepository_CreateStockAdjustment_Error_Mock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	mockCollection.On("InsertOne", mock.Anything, mock.Anything).
+		Return(nil, mongo.ErrNilDocument)
+
+	repo := NewInventoryRepositoryWithCollection(mockCollection)
+
+	err := repo.CreateStockAdjustment(context.Background(), &models.StockAdjustment{ID: "adj-bad"})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to create stock adjustment")
+}
@@ -209,6 +209,71 @@ func (ir *InvoiceRepository) GetInvoicesByType(ctx context.Context, invoiceType
 	return invoices, nil
 }
 
+// userInvoicesPipeline finds the invoices of a user's orders, newest order first
+func userInvoicesPipeline(userID string) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user": userID}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}}}},
+		{{Key: "$lookup", Value: bson.M{"from": InvoicesCollectionName, "localField": "_id", "foreignField": "orderId", "as": "invoice"}}},
+		{{Key: "$unwind", Value: "$invoice"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$invoice"}}},
+	}
+}
+
+// GetInvoicesByUser retrieves the invoices of a user's orders with pagination.
+// Invoices do not record the user, so this starts from the user's orders.
+func (ir *InvoiceRepository) GetInvoicesByUser(ctx context.Context, userID string, page, limit int) ([]*models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	pipeline := append(userInvoicesPipeline(userID),
+		bson.D{{Key: "$skip", Value: int64((page - 1) * limit)}},
+		bson.D{{Key: "$limit", Value: int64(limit)}},
+	)
+	cursor, err := GetCollection(DBName, OrdersCollectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invoices: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var invoices []*models.Invoice
+	if err := cursor.All(ctx, &invoices); err != nil {
+		return nil, fmt.Errorf("failed to decode invoices: %w", err)
+	}
+	return invoices, nil
+}
+
+// CountInvoicesByUser returns the number of invoices of a user's orders
+func (ir *InvoiceRepository) CountInvoicesByUser(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pipeline := append(userInvoicesPipeline(userID), bson.D{{Key: "$count", Value: "total"}})
+	cursor, err := GetCollection(DBName, OrdersCollectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count invoices: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, fmt.Errorf("failed to decode invoice count: %w", err)
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Total, nil
+}
+
 // GetInvoiceCount returns the total count of invoices
 func (ir *InvoiceRepository) GetInvoiceCount(ctx context.Context) (int64, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
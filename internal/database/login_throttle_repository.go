@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	LoginThrottlesCollectionName = "login_throttles"
+)
+
+// LoginThrottleRepository counts failed logins per account and per client IP
+type LoginThrottleRepository struct {
+	collection Collection
+}
+
+// NewLoginThrottleRepository creates a new login throttle repository
+func NewLoginThrottleRepository() *LoginThrottleRepository {
+	return &LoginThrottleRepository{collection: NewMongoCollection(GetCollection(DBName, LoginThrottlesCollectionName))}
+}
+
+// NewLoginThrottleRepositoryWithCollection creates a login throttle repository with custom collection (for testing)
+func NewLoginThrottleRepositoryWithCollection(c Collection) *LoginThrottleRepository {
+	return &LoginThrottleRepository{collection: c}
+}
+
+// GetLoginThrottles retrieves the throttles with the given keys; keys with no
+// recent failures are left out
+func (lr *LoginThrottleRepository) GetLoginThrottles(ctx context.Context, keys []string) ([]*models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := lr.collection.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch login throttles: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var throttles []*models.LoginThrottle
+	if err := cursor.All(ctx, &throttles); err != nil {
+		return nil, fmt.Errorf("failed to decode login throttles: %w", err)
+	}
+	return throttles, nil
+}
+
+// RecordLoginFailure counts a failed login and returns the updated throttle. The
+// count starts over at one when the previous failure, and any lockout, ended more
+// than window ago. The update is a single pipeline so concurrent failures are all
+// counted.
+func (lr *LoginThrottleRepository) RecordLoginFailure(ctx context.Context, scope, subject string, window time.Duration) (*models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	key := models.LoginThrottleKey(scope, subject)
+	now := time.Now()
+	recent := bson.M{"$gte": bson.A{bson.M{"$max": bson.A{"$lastFailureAt", "$lockedUntil"}}, now.Add(-window)}}
+	update := bson.A{bson.M{"$set": bson.M{
+		"scope":         scope,
+		"subject":       subject,
+		"failures":      bson.M{"$cond": bson.A{recent, bson.M{"$add": bson.A{"$failures", 1}}, 1}},
+		"lastFailureAt": now,
+		"expiresAt":     bson.M{"$max": bson.A{now.Add(window), bson.M{"$add": bson.A{"$lockedUntil", window.Milliseconds()}}}},
+	}}}
+
+	_, err := lr.collection.UpdateOne(ctx, bson.M{"_id": key}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	var throttle models.LoginThrottle
+	if err := lr.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&throttle); err != nil {
+		return nil, fmt.Errorf("failed to fetch login throttle: %w", err)
+	}
+	return &throttle, nil
+}
+
+// LockLogin refuses logins for a throttle's account or IP until the given time. An
+// existing longer lockout is kept.
+func (lr *LoginThrottleRepository) LockLogin(ctx context.Context, key string, until time.Time, window time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := lr.collection.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$max": bson.M{"lockedUntil": until, "expiresAt": until.Add(window)}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+	return nil
+}
+
+// ClearLoginThrottle forgets an account's or IP's failed logins, lifting any
+// lockout. It returns false if there was nothing to clear.
+func (lr *LoginThrottleRepository) ClearLoginThrottle(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := lr.collection.DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		return false, fmt.Errorf("failed to clear login throttle: %w", err)
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// ListLockedLogins retrieves the accounts and IPs currently locked out, longest
+// lockout first
+func (lr *LoginThrottleRepository) ListLockedLogins(ctx context.Context) ([]*models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "lockedUntil", Value: -1}})
+	cursor, err := lr.collection.Find(ctx, bson.M{"lockedUntil": bson.M{"$gt": time.Now()}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch locked logins: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var throttles []*models.LoginThrottle
+	if err := cursor.All(ctx, &throttles); err != nil {
+		return nil, fmt.Errorf("failed to decode locked logins: %w", err)
+	}
+	return throttles, nil
+}
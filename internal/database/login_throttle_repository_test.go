@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestLoginThrottleRepository_CountLockAndClear(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping login throttle repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewLoginThrottleRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	for want := 1; want <= 3; want++ {
+		throttle, err := repo.RecordLoginFailure(ctx, models.LoginThrottleAccount, "a@example.com", time.Hour)
+		if err != nil || throttle.Failures != want {
+			t.Fatalf("expected %d failures, got %+v (err=%v)", want, throttle, err)
+		}
+	}
+
+	key := models.LoginThrottleKey(models.LoginThrottleAccount, "a@example.com")
+	until := time.Now().Add(time.Hour)
+	if err := repo.LockLogin(ctx, key, until, time.Hour); err != nil {
+		t.Fatalf("LockLogin error: %v", err)
+	}
+	locked, err := repo.ListLockedLogins(ctx)
+	if err != nil || len(locked) != 1 || locked[0].ID != key || !locked[0].Locked(time.Now()) {
+		t.Fatalf("expected the account to be locked, got %+v (err=%v)", locked, err)
+	}
+
+	// A failure with a short window still counts on while the account is locked
+	throttle, err := repo.RecordLoginFailure(ctx, models.LoginThrottleAccount, "a@example.com", time.Millisecond)
+	if err != nil || throttle.Failures != 4 {
+		t.Fatalf("expected the count to carry on during a lockout, got %+v (err=%v)", throttle, err)
+	}
+
+	// Failures older than the window start over
+	time.Sleep(5 * time.Millisecond)
+	throttle, err = repo.RecordLoginFailure(ctx, models.LoginThrottleIP, "203.0.113.7", time.Millisecond)
+	if err != nil || throttle.Failures != 1 {
+		t.Fatalf("expected a new count, got %+v (err=%v)", throttle, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	throttle, _ = repo.RecordLoginFailure(ctx, models.LoginThrottleIP, "203.0.113.7", time.Millisecond)
+	if throttle.Failures != 1 {
+		t.Fatalf("expected a stale count to start over, got %d", throttle.Failures)
+	}
+
+	cleared, err := repo.ClearLoginThrottle(ctx, key)
+	if err != nil || !cleared {
+		t.Fatalf("expected the account's failures to be cleared (cleared=%v, err=%v)", cleared, err)
+	}
+	throttles, _ := repo.GetLoginThrottles(ctx, []string{key, models.LoginThrottleKey(models.LoginThrottleIP, "203.0.113.7")})
+	if len(throttles) != 1 || throttles[0].Scope != models.LoginThrottleIP {
+		t.Fatalf("expected only the IP throttle to remain, got %+v", throttles)
+	}
+}
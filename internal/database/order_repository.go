@@ -133,3 +133,110 @@ func (or *OrderRepository) UpdateOrderStatus(ctx context.Context, orderID string
 
 	return nil
 }
+
+// openOrderStatuses are the statuses of orders that have not reached the customer
+// or been closed
+var openOrderStatuses = []string{
+	models.OrderStatusInQueue,
+	models.OrderStatusProcessing,
+	models.OrderStatusShipped,
+	models.OrderStatusAwaitingPickup,
+}
+
+// CountOpenOrdersByUser counts a user's orders that are still being fulfilled
+func (or *OrderRepository) CountOpenOrdersByUser(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := or.collection.CountDocuments(ctx, bson.M{"user": userID, "status": bson.M{"$in": openOrderStatuses}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count open orders: %w", err)
+	}
+	return count, nil
+}
+
+// AnonymiseOrdersByUser removes the contact and delivery details from a user's
+// orders. Products, amounts and the user ID are kept for accounting.
+func (or *OrderRepository) AnonymiseOrdersByUser(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := or.collection.UpdateMany(ctx, bson.M{"user": userID}, bson.M{
+		"$set": bson.M{
+			"phone":                    "",
+			"metadata.notes":           "",
+			"metadata.locationDetails": "",
+			"updatedAt":                time.Now(),
+		},
+		"$unset": bson.M{"delivery.address": ""},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to anonymise orders: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// ReleasePromotion marks the coupon redeemed on the order as given back. It reports
+// whether the order had an unreleased coupon, so callers only release it once.
+func (or *OrderRepository) ReleasePromotion(ctx context.Context, orderID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := or.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": orderID, "promotion": bson.M{"$ne": nil}, "promotion.released": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"promotion.released": true, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to release promotion: %w", err)
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// ClaimShipmentVersion moves the order's shipment version on from version. It
+// returns false if another shipment was created since the order was read at that
+// version, in which case what is left to ship must be worked out again.
+func (or *OrderRepository) ClaimShipmentVersion(ctx context.Context, orderID string, version int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// Orders from before shipments were versioned have no version yet
+	current := bson.M{"shipmentVersion": version}
+	if version == 0 {
+		current = bson.M{"shipmentVersion": bson.M{"$in": bson.A{0, nil}}}
+	}
+	current["_id"] = orderID
+
+	result, err := or.collection.UpdateOne(
+		ctx,
+		current,
+		bson.M{
+			"$inc": bson.M{"shipmentVersion": 1},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim shipment version: %w", err)
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// ReleaseStockReservation clears the order's stock reservation flag. It reports
+// whether the flag was set, so callers only return stock to inventory once.
+func (or *OrderRepository) ReleaseStockReservation(ctx context.Context, orderID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := or.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": orderID, "stockReserved": true},
+		bson.M{"$set": bson.M{"stockReserved": false, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to release stock reservation: %w", err)
+	}
+
+	return result.ModifiedCount > 0, nil
+}
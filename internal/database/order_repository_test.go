@@ -149,3 +149,131 @@ func TestOrderRepository_UpdateOrderStatus(t *testing.T) {
 
 	repo.collection.DeleteMany(ctx, map[string]interface{}{})
 }
+
+func TestOrderRepository_OpenOrdersAndAnonymise(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping order repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewOrderRepository()
+	ctx := context.Background()
+	filter := map[string]interface{}{"user": "test-anon-user"}
+	repo.collection.DeleteMany(ctx, filter)
+
+	for i, status := range []string{models.OrderStatusComplete, models.OrderStatusShipped} {
+		order := &models.Order{
+			ID:        "test-anon-order-" + status,
+			Products:  []models.OrderItem{{ProductID: "p1", Quantity: 1, Price: 5.0}},
+			TotalCost: float64(5 * (i + 1)),
+			Status:    status,
+			UserID:    "test-anon-user",
+			Phone:     "254712345678",
+			Metadata:  models.OrderMetadata{Notes: "Call Jane", LocationDetails: "Gate B, Kilimani"},
+			Delivery:  &models.OrderDelivery{AddressID: "addr-1", Address: &models.Address{ID: "addr-1", Town: "Kilimani"}, Fee: 150},
+		}
+		if err := repo.CreateOrder(ctx, order); err != nil {
+			t.Fatalf("CreateOrder error: %v", err)
+		}
+	}
+
+	open, err := repo.CountOpenOrdersByUser(ctx, "test-anon-user")
+	if err != nil || open != 1 {
+		t.Fatalf("expected 1 open order, got %d (err=%v)", open, err)
+	}
+
+	if n, err := repo.AnonymiseOrdersByUser(ctx, "test-anon-user"); err != nil || n != 2 {
+		t.Fatalf("AnonymiseOrdersByUser: n=%d err=%v", n, err)
+	}
+	order, err := repo.GetOrderByID(ctx, "test-anon-order-"+models.OrderStatusShipped)
+	if err != nil {
+		t.Fatalf("GetOrderByID error: %v", err)
+	}
+	if order.Phone != "" || order.Metadata.Notes != "" || order.Metadata.LocationDetails != "" || order.Delivery.Address != nil {
+		t.Fatalf("expected contact details removed, got %+v", order)
+	}
+	if order.TotalCost != 10 || len(order.Products) != 1 || order.Delivery.Fee != 150 {
+		t.Fatalf("expected the amounts kept, got %+v", order)
+	}
+
+	repo.collection.DeleteMany(ctx, filter)
+}
+
+func TestOrderRepository_ClaimShipmentVersion(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping order repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewOrderRepository()
+	ctx := context.Background()
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	// An order stored before shipments were versioned
+	repo.collection.InsertOne(ctx, map[string]interface{}{"_id": "ship-order-1", "status": models.OrderStatusProcessing})
+
+	if ok, err := repo.ClaimShipmentVersion(ctx, "ship-order-1", 0); err != nil || !ok {
+		t.Fatalf("ClaimShipmentVersion: ok=%v err=%v", ok, err)
+	}
+	// A second shipment worked out from the same read loses
+	if ok, err := repo.ClaimShipmentVersion(ctx, "ship-order-1", 0); err != nil || ok {
+		t.Fatalf("second ClaimShipmentVersion at version 0: ok=%v err=%v", ok, err)
+	}
+
+	order, err := repo.GetOrderByID(ctx, "ship-order-1")
+	if err != nil || order.ShipmentVersion != 1 {
+		t.Fatalf("expected shipment version 1, got %+v (err=%v)", order, err)
+	}
+	if ok, err := repo.ClaimShipmentVersion(ctx, "ship-order-1", 1); err != nil || !ok {
+		t.Fatalf("ClaimShipmentVersion at version 1: ok=%v err=%v", ok, err)
+	}
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
+
+func TestOrderRepository_ReleasePromotion(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping order repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewOrderRepository()
+	ctx := context.Background()
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	repo.CreateOrder(ctx, &models.Order{ID: "promo-order-1", Promotion: &models.AppliedPromotion{PromotionID: "promo-1", RedemptionID: "red-1"}})
+	repo.CreateOrder(ctx, &models.Order{ID: "plain-order-1"})
+
+	if ok, err := repo.ReleasePromotion(ctx, "promo-order-1"); err != nil || !ok {
+		t.Fatalf("ReleasePromotion: ok=%v err=%v", ok, err)
+	}
+	// Only once per order, and never for orders without a coupon
+	if ok, err := repo.ReleasePromotion(ctx, "promo-order-1"); err != nil || ok {
+		t.Fatalf("second ReleasePromotion: ok=%v err=%v", ok, err)
+	}
+	if ok, err := repo.ReleasePromotion(ctx, "plain-order-1"); err != nil || ok {
+		t.Fatalf("ReleasePromotion without a coupon: ok=%v err=%v", ok, err)
+	}
+
+	order, err := repo.GetOrderByID(ctx, "promo-order-1")
+	if err != nil || order.Promotion == nil || !order.Promotion.Released {
+		t.Fatalf("expected the coupon marked released, got %+v (err=%v)", order, err)
+	}
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
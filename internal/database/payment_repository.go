@@ -2,12 +2,15 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -51,6 +54,19 @@ func (pr *PaymentRepository) GetPaymentByCheckoutRequestID(ctx context.Context,
 	return &payment, nil
 }
 
+// GetPaymentByID retrieves a payment by ID
+func (pr *PaymentRepository) GetPaymentByID(ctx context.Context, paymentID string) (*models.PaymentRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var payment models.PaymentRecord
+	err := pr.collection.FindOne(ctx, bson.M{"_id": paymentID}).Decode(&payment)
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
 // GetPaymentByInvoiceID retrieves a payment by invoice ID
 func (pr *PaymentRepository) GetPaymentByInvoiceID(ctx context.Context, invoiceID string) (*models.PaymentRecord, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -64,6 +80,142 @@ func (pr *PaymentRepository) GetPaymentByInvoiceID(ctx context.Context, invoiceI
 	return &payment, nil
 }
 
+// GetPaymentsByInvoiceID retrieves every payment attempt for an invoice, newest first
+func (pr *PaymentRepository) GetPaymentsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.PaymentRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := pr.collection.Find(ctx, bson.M{"invoiceId": invoiceID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var payments []*models.PaymentRecord
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, fmt.Errorf("failed to decode payments: %w", err)
+	}
+	return payments, nil
+}
+
+// paymentSearchFilter builds the MongoDB filter for an admin payment search
+func paymentSearchFilter(query models.PaymentSearchQuery) bson.M {
+	filter := bson.M{}
+	if query.Phone != "" {
+		filter["$or"] = []bson.M{
+			{"phone": query.Phone},
+			{"payerPhone": query.Phone},
+		}
+	}
+	if query.Receipt != "" {
+		receipt := []bson.M{
+			{"mpesaReceiptNumber": query.Receipt},
+			{"receipt": query.Receipt},
+		}
+		if phone, ok := filter["$or"]; ok {
+			delete(filter, "$or")
+			filter["$and"] = []bson.M{{"$or": phone}, {"$or": receipt}}
+		} else {
+			filter["$or"] = receipt
+		}
+	}
+	if query.Provider != "" {
+		filter["provider"] = query.Provider
+		if query.Provider == models.PaymentProviderMpesa {
+			// Records from before other providers were added carry no provider
+			filter["provider"] = bson.M{"$in": []string{models.PaymentProviderMpesa, ""}}
+		}
+	}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+
+	// createdAt is stored as "2006-01-02 15:04:05", so whole days compare as strings
+	createdAt := bson.M{}
+	if query.StartDate != "" {
+		createdAt["$gte"] = query.StartDate + " 00:00:00"
+	}
+	if query.EndDate != "" {
+		createdAt["$lte"] = query.EndDate + " 23:59:59"
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	return filter
+}
+
+// SearchPayments retrieves payments matching the admin search filters with pagination, newest first
+func (pr *PaymentRepository) SearchPayments(ctx context.Context, query models.PaymentSearchQuery, page, limit int) ([]*models.PaymentRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := pr.collection.Find(ctx, paymentSearchFilter(query), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search payments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var payments []*models.PaymentRecord
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, fmt.Errorf("failed to decode payments: %w", err)
+	}
+	return payments, nil
+}
+
+// CountPayments returns the number of payments matching the admin search filters
+func (pr *PaymentRepository) CountPayments(ctx context.Context, query models.PaymentSearchQuery) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := pr.collection.CountDocuments(ctx, paymentSearchFilter(query))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count payments: %w", err)
+	}
+	return count, nil
+}
+
+// GetStaleInitiatedPayments retrieves payments still awaiting a provider result
+// that were initiated before the given time, oldest first
+func (pr *PaymentRepository) GetStaleInitiatedPayments(ctx context.Context, olderThan time.Time, limit int) ([]*models.PaymentRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if limit < 1 {
+		limit = 50
+	}
+
+	filter := bson.M{
+		"status":    models.PaymentStatusInitiated,
+		"createdAt": bson.M{"$lt": olderThan.Format("2006-01-02 15:04:05")},
+	}
+	opts := options.Find().SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := pr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stale payments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var payments []*models.PaymentRecord
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, fmt.Errorf("failed to decode stale payments: %w", err)
+	}
+	return payments, nil
+}
+
 // UpdatePaymentStatus updates payment record status and transaction details
 func (pr *PaymentRepository) UpdatePaymentStatus(ctx context.Context, checkoutID string, status, receiptNum, transDate string) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -88,6 +240,142 @@ func (pr *PaymentRepository) UpdatePaymentStatus(ctx context.Context, checkoutID
 	return nil
 }
 
+// TransitionPaymentStatus moves a payment from one status to another and records the
+// transaction details the provider reported (nil clears them). It returns false without
+// changing anything if the payment is no longer in fromStatus or the receipt number
+// was already recorded, so repeated M-Pesa callbacks for the same transaction are harmless.
+// Payments settled with an amount mismatch are flagged for admin review.
+func (pr *PaymentRepository) TransitionPaymentStatus(ctx context.Context, checkoutID, fromStatus, toStatus string, settlement *models.PaymentSettlement) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if settlement == nil {
+		settlement = &models.PaymentSettlement{}
+	}
+
+	update := bson.M{
+		"status":             toStatus,
+		"mpesaReceiptNumber": settlement.MpesaReceiptNumber,
+		"receipt":            settlement.Receipt,
+		"collectedBy":        settlement.CollectedBy,
+		"transactionDate":    settlement.TransactionDate,
+		"transactedAt":       settlement.TransactedAt,
+		"paidAmount":         settlement.PaidAmount,
+		"payerPhone":         settlement.PayerPhone,
+		"amountMismatch":     settlement.AmountMismatch,
+		"needsReview":        settlement.AmountMismatch != "",
+		"updatedAt":          time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if settlement.Method != "" {
+		update["method"] = settlement.Method
+	}
+
+	// Look for the receipt on another payment first. Inside a transaction the
+	// unique index cannot be relied on for this, as a duplicate key error aborts
+	// the whole transaction.
+	if settlement.MpesaReceiptNumber != "" {
+		err := pr.collection.FindOne(ctx, bson.M{
+			"mpesaReceiptNumber": settlement.MpesaReceiptNumber,
+			"checkoutRequestId":  bson.M{"$ne": checkoutID},
+		}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+		if err == nil {
+			return false, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return false, fmt.Errorf("failed to check receipt number: %w", err)
+		}
+	}
+
+	result, err := pr.collection.UpdateOne(
+		ctx,
+		bson.M{"checkoutRequestId": checkoutID, "status": fromStatus},
+		bson.M{"$set": update},
+	)
+	if err != nil {
+		// Settled concurrently on another payment. Outside a transaction that makes
+		// this a no-op; inside one the transaction is already aborted, so the caller
+		// must fail and the callback be retried, when the lookup above catches it.
+		if isReceiptConflict(err) && mongo.SessionFromContext(ctx) == nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to update payment status: %w", err)
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// isReceiptConflict reports whether err is a unique-index violation on the M-Pesa
+// receipt number, i.e. the transaction was already settled on another payment.
+// Other duplicate-key errors are real failures and are not matched.
+func isReceiptConflict(err error) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == 11000 && strings.Contains(e.Message, "mpesaReceiptNumber") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPaymentsNeedingReview retrieves payments flagged for admin review, oldest first
+func (pr *PaymentRepository) GetPaymentsNeedingReview(ctx context.Context, page, limit int) ([]*models.PaymentRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := pr.collection.Find(ctx, bson.M{"needsReview": true}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payments for review: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var payments []*models.PaymentRecord
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, fmt.Errorf("failed to decode payments: %w", err)
+	}
+	return payments, nil
+}
+
+// MarkPaymentReviewed clears the review flag on a payment and records who resolved it
+func (pr *PaymentRepository) MarkPaymentReviewed(ctx context.Context, paymentID, adminID, note string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	result, err := pr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": paymentID, "needsReview": true},
+		bson.M{"$set": bson.M{
+			"needsReview": false,
+			"reviewedBy":  adminID,
+			"reviewNote":  note,
+			"reviewedAt":  now,
+			"updatedAt":   now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update payment review: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("payment not found or not awaiting review")
+	}
+
+	return nil
+}
+
 // ReversePaymentsByInvoiceID marks all payment records for an invoice as reversed
 func (pr *PaymentRepository) ReversePaymentsByInvoiceID(ctx context.Context, invoiceID string) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
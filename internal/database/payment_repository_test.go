@@ -2,12 +2,38 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+func TestIsReceiptConflict(t *testing.T) {
+	receipt := mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+		Code:    11000,
+		Message: `E11000 duplicate key error collection: maggiesb.payment_records index: mpesaReceiptNumber_1 dup key: { mpesaReceiptNumber: "QGH12345" }`,
+	}}}
+	if !isReceiptConflict(receipt) {
+		t.Fatalf("expected a receipt number conflict to be matched")
+	}
+
+	other := mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+		Code:    11000,
+		Message: `E11000 duplicate key error collection: maggiesb.payment_records index: checkoutRequestId_1 dup key: { checkoutRequestId: "ws_CO_1" }`,
+	}}}
+	if isReceiptConflict(other) {
+		t.Fatalf("expected other duplicate keys not to be matched")
+	}
+
+	if !isReceiptConflict(fmt.Errorf("wrapped: %w", receipt)) {
+		t.Fatalf("expected a wrapped receipt conflict to be matched")
+	}
+}
+
 func TestPaymentRepository_CreateAndGet(t *testing.T) {
 	uri := os.Getenv("MONGO_TEST_URI")
 	if uri == "" {
@@ -177,3 +203,233 @@ func TestPaymentRepository_ReversePaymentsByInvoiceID(t *testing.T) {
 	// cleanup
 	repo.collection.DeleteMany(ctx, map[string]interface{}{})
 }
+
+func TestPaymentRepository_TransitionPaymentStatusIsIdempotent(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping payment repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	if err := CreateIndexes(); err != nil {
+		t.Fatalf("CreateIndexes error: %v", err)
+	}
+
+	repo := NewPaymentRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	for _, p := range []*models.PaymentRecord{
+		{ID: "pay-idem-1", InvoiceID: "inv-idem", CheckoutRequestID: "chk-idem-1", Status: "initiated"},
+		{ID: "pay-idem-2", InvoiceID: "inv-idem", CheckoutRequestID: "chk-idem-2", Status: "initiated"},
+	} {
+		if err := repo.CreatePaymentRecord(ctx, p); err != nil {
+			t.Fatalf("CreatePaymentRecord error: %v", err)
+		}
+	}
+
+	// A second record with the same CheckoutRequestID is rejected by the unique index
+	if err := repo.CreatePaymentRecord(ctx, &models.PaymentRecord{ID: "pay-idem-3", CheckoutRequestID: "chk-idem-1"}); err == nil {
+		t.Fatalf("expected duplicate CheckoutRequestID to be rejected")
+	}
+
+	settlement := &models.PaymentSettlement{MpesaReceiptNumber: "RCPT-1", TransactionDate: "20260208120000", PaidAmount: 100}
+
+	ok, err := repo.TransitionPaymentStatus(ctx, "chk-idem-1", "initiated", "completed", settlement)
+	if err != nil || !ok {
+		t.Fatalf("first transition: ok=%v err=%v", ok, err)
+	}
+
+	// Repeating the callback is a no-op
+	ok, err = repo.TransitionPaymentStatus(ctx, "chk-idem-1", "initiated", "completed", settlement)
+	if err != nil || ok {
+		t.Fatalf("repeat transition: ok=%v err=%v", ok, err)
+	}
+
+	// A completed payment cannot be failed by a late callback
+	ok, err = repo.TransitionPaymentStatus(ctx, "chk-idem-1", "initiated", "failed", nil)
+	if err != nil || ok {
+		t.Fatalf("late failure transition: ok=%v err=%v", ok, err)
+	}
+
+	// The same receipt cannot settle a different payment
+	ok, err = repo.TransitionPaymentStatus(ctx, "chk-idem-2", "initiated", "completed", settlement)
+	if err != nil || ok {
+		t.Fatalf("duplicate receipt transition: ok=%v err=%v", ok, err)
+	}
+
+	found, _ := repo.GetPaymentByCheckoutRequestID(ctx, "chk-idem-2")
+	if found.Status != "initiated" {
+		t.Fatalf("expected second payment to stay initiated, got %s", found.Status)
+	}
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
+
+// TestPaymentRepository_DuplicateReceiptInTransaction tests a receipt already
+// recorded on another payment is a no-op inside a transaction too, where a
+// duplicate key error would abort the transaction
+func TestPaymentRepository_DuplicateReceiptInTransaction(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping payment repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	if !supportsTransactions(MongoClient) {
+		t.Skip("MongoDB deployment does not support transactions")
+	}
+	if err := CreateIndexes(); err != nil {
+		t.Fatalf("CreateIndexes error: %v", err)
+	}
+
+	repo := NewPaymentRepository()
+	ctx := context.Background()
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	for _, p := range []*models.PaymentRecord{
+		{ID: "pay-txn-1", InvoiceID: "inv-txn", CheckoutRequestID: "chk-txn-1", Status: "initiated"},
+		{ID: "pay-txn-2", InvoiceID: "inv-txn", CheckoutRequestID: "chk-txn-2", Status: "initiated"},
+	} {
+		if err := repo.CreatePaymentRecord(ctx, p); err != nil {
+			t.Fatalf("CreatePaymentRecord error: %v", err)
+		}
+	}
+
+	settlement := &models.PaymentSettlement{MpesaReceiptNumber: "RCPT-TXN", TransactionDate: "20260208120000", PaidAmount: 100}
+	if ok, err := repo.TransitionPaymentStatus(ctx, "chk-txn-1", "initiated", "completed", settlement); err != nil || !ok {
+		t.Fatalf("first transition: ok=%v err=%v", ok, err)
+	}
+
+	uow := NewMongoUnitOfWork(MongoClient)
+	var settled bool
+	err := uow.Do(ctx, func(ctx context.Context) error {
+		ok, err := repo.TransitionPaymentStatus(ctx, "chk-txn-2", "initiated", "completed", settlement)
+		settled = ok
+		return err
+	})
+	if err != nil || settled {
+		t.Fatalf("duplicate receipt in a transaction: settled=%v err=%v", settled, err)
+	}
+
+	found, _ := repo.GetPaymentByCheckoutRequestID(ctx, "chk-txn-2")
+	if found.Status != "initiated" {
+		t.Fatalf("expected second payment to stay initiated, got %s", found.Status)
+	}
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
+
+func TestPaymentRepository_MismatchedPaymentReview(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping payment repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewPaymentRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	if err := repo.CreatePaymentRecord(ctx, &models.PaymentRecord{ID: "pay-review-1", CheckoutRequestID: "chk-review-1", Amount: 500, Status: "initiated"}); err != nil {
+		t.Fatalf("CreatePaymentRecord error: %v", err)
+	}
+
+	transactedAt := time.Date(2026, 2, 8, 12, 0, 0, 0, time.UTC)
+	ok, err := repo.TransitionPaymentStatus(ctx, "chk-review-1", "initiated", "completed", &models.PaymentSettlement{
+		MpesaReceiptNumber: "RCPT-REVIEW",
+		TransactionDate:    "20260208150000",
+		TransactedAt:       &transactedAt,
+		PaidAmount:         200,
+		PayerPhone:         "254708374149",
+		AmountMismatch:     models.AmountMismatchUnderpaid,
+	})
+	if err != nil || !ok {
+		t.Fatalf("transition: ok=%v err=%v", ok, err)
+	}
+
+	found, _ := repo.GetPaymentByCheckoutRequestID(ctx, "chk-review-1")
+	if found.PaidAmount != 200 || found.PayerPhone != "254708374149" || found.TransactedAt == nil || !found.TransactedAt.Equal(transactedAt) {
+		t.Fatalf("unexpected settlement details: %+v", found)
+	}
+
+	flagged, err := repo.GetPaymentsNeedingReview(ctx, 1, 10)
+	if err != nil || len(flagged) != 1 || flagged[0].AmountMismatch != models.AmountMismatchUnderpaid {
+		t.Fatalf("expected one underpaid payment for review, got %v (err=%v)", flagged, err)
+	}
+
+	if err := repo.MarkPaymentReviewed(ctx, "pay-review-1", "admin-1", "customer will pay the balance"); err != nil {
+		t.Fatalf("MarkPaymentReviewed error: %v", err)
+	}
+	if err := repo.MarkPaymentReviewed(ctx, "pay-review-1", "admin-1", "again"); err == nil {
+		t.Fatalf("expected reviewing twice to fail")
+	}
+
+	flagged, _ = repo.GetPaymentsNeedingReview(ctx, 1, 10)
+	if len(flagged) != 0 {
+		t.Fatalf("expected review queue to be empty, got %d", len(flagged))
+	}
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
+
+func TestPaymentSearchFilter(t *testing.T) {
+	filter := paymentSearchFilter(models.PaymentSearchQuery{
+		Phone:     "254712345678",
+		Receipt:   "NHY4GT5HJI",
+		Status:    "completed",
+		StartDate: "2024-01-01",
+		EndDate:   "2024-01-31",
+	})
+
+	if filter["status"] != "completed" {
+		t.Fatalf("unexpected filter: %v", filter)
+	}
+	// Phone matches either phone field and receipt matches either receipt field
+	and, ok := filter["$and"].([]bson.M)
+	if !ok || len(and) != 2 {
+		t.Fatalf("expected phone and receipt alternatives to be combined: %v", filter)
+	}
+	receipt := and[1]["$or"].([]bson.M)
+	if receipt[0]["mpesaReceiptNumber"] != "NHY4GT5HJI" || receipt[1]["receipt"] != "NHY4GT5HJI" {
+		t.Fatalf("unexpected receipt filter: %v", receipt)
+	}
+
+	byProvider := paymentSearchFilter(models.PaymentSearchQuery{Receipt: "NHY4GT5HJI", Provider: "mpesa"})
+	if _, ok := byProvider["$or"]; !ok {
+		t.Fatalf("expected receipt to match either receipt field: %v", byProvider)
+	}
+	if byProvider["provider"].(bson.M)["$in"] == nil {
+		t.Fatalf("expected mpesa to include records without a provider: %v", byProvider)
+	}
+	if paymentSearchFilter(models.PaymentSearchQuery{Provider: "card"})["provider"] != "card" {
+		t.Fatalf("expected provider filter")
+	}
+
+	createdAt := filter["createdAt"].(bson.M)
+	if createdAt["$gte"] != "2024-01-01 00:00:00" || createdAt["$lte"] != "2024-01-31 23:59:59" {
+		t.Fatalf("unexpected date range: %v", createdAt)
+	}
+
+	if len(paymentSearchFilter(models.PaymentSearchQuery{})) != 0 {
+		t.Fatalf("expected empty query to match everything")
+	}
+}
@@ -171,3 +171,106 @@ func (pr *ProductRepository) GetProductCount(ctx context.Context) (int64, error)
 
 	return count, nil
 }
+
+// CountProductsWithoutStock counts products created before stock was tracked.
+// They have no stock field, so they cannot be ordered until restocked.
+func (pr *ProductRepository) CountProductsWithoutStock(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := pr.collection.CountDocuments(ctx, bson.M{"stock": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count products: %w", err)
+	}
+
+	return count, nil
+}
+
+// ReserveStock atomically decrements a product's stock if enough units are available
+func (pr *ProductRepository) ReserveStock(ctx context.Context, productID string, quantity int) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := pr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": productID, "stock": bson.M{"$gte": quantity}},
+		bson.M{
+			"$inc": bson.M{"stock": -quantity},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reserve stock: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("insufficient stock")
+	}
+
+	return nil
+}
+
+// ReleaseStock returns previously reserved units to a product's stock
+func (pr *ProductRepository) ReleaseStock(ctx context.Context, productID string, quantity int) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := pr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": productID},
+		bson.M{
+			"$inc": bson.M{"stock": quantity},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release stock: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("product not found")
+	}
+
+	return nil
+}
+
+// AdjustStock applies a manual stock change and returns the updated product.
+// Negative adjustments are rejected if they would take stock below zero.
+func (pr *ProductRepository) AdjustStock(ctx context.Context, productID string, delta int) (*models.Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": productID}
+	if delta < 0 {
+		filter["stock"] = bson.M{"$gte": -delta}
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var product models.Product
+	err := pr.collection.FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.M{
+			"$inc": bson.M{"stock": delta},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		opts,
+	).Decode(&product)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to adjust stock: %w", err)
+		}
+
+		// Distinguish a missing product from one without enough stock
+		count, cerr := pr.collection.CountDocuments(ctx, bson.M{"_id": productID})
+		if cerr != nil {
+			return nil, fmt.Errorf("failed to adjust stock: %w", cerr)
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("product not found")
+		}
+		return nil, fmt.Errorf("insufficient stock")
+	}
+
+	return &product, nil
+}
@@ -160,3 +160,83 @@ func TestProductRepository_GetAll_Search_Update_Delete(t *testing.T) {
 
 	repo.collection.DeleteMany(ctx, map[string]interface{}{})
 }
+
+func TestProductRepository_StockReservation(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping product repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewProductRepository()
+	ctx := context.Background()
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	p := &models.Product{
+		ID:          "test-prod-stock-1",
+		Name:        "kettle",
+		Description: "electric kettle",
+		Price:       30.0,
+		Stock:       5,
+	}
+	if err := repo.CreateProduct(ctx, p); err != nil {
+		t.Fatalf("CreateProduct error: %v", err)
+	}
+
+	// Reserve within available stock
+	if err := repo.ReserveStock(ctx, p.ID, 3); err != nil {
+		t.Fatalf("ReserveStock error: %v", err)
+	}
+
+	// Reserving more than remains must fail and leave stock untouched
+	if err := repo.ReserveStock(ctx, p.ID, 3); err == nil || err.Error() != "insufficient stock" {
+		t.Fatalf("expected insufficient stock error, got %v", err)
+	}
+
+	found, _ := repo.GetProductByID(ctx, p.ID)
+	if found.Stock != 2 {
+		t.Fatalf("expected stock 2, got %d", found.Stock)
+	}
+
+	if err := repo.ReleaseStock(ctx, p.ID, 3); err != nil {
+		t.Fatalf("ReleaseStock error: %v", err)
+	}
+
+	// Manual adjustments
+	adjusted, err := repo.AdjustStock(ctx, p.ID, -4)
+	if err != nil {
+		t.Fatalf("AdjustStock error: %v", err)
+	}
+	if adjusted.Stock != 1 {
+		t.Fatalf("expected stock 1 after adjustment, got %d", adjusted.Stock)
+	}
+
+	if _, err := repo.AdjustStock(ctx, p.ID, -2); err == nil || err.Error() != "insufficient stock" {
+		t.Fatalf("expected insufficient stock error, got %v", err)
+	}
+
+	if _, err := repo.AdjustStock(ctx, "nonexistent", 1); err == nil || err.Error() != "product not found" {
+		t.Fatalf("expected product not found error, got %v", err)
+	}
+
+	// Products from before stock was tracked are out of stock until restocked
+	repo.collection.InsertOne(ctx, map[string]interface{}{"_id": "test-prod-legacy", "name": "teapot", "price": 20.0})
+	if n, err := repo.CountProductsWithoutStock(ctx); err != nil || n != 1 {
+		t.Fatalf("CountProductsWithoutStock: n=%d err=%v", n, err)
+	}
+	if err := repo.ReserveStock(ctx, "test-prod-legacy", 1); err == nil || err.Error() != "insufficient stock" {
+		t.Fatalf("expected insufficient stock error for a product without stock, got %v", err)
+	}
+	if _, err := repo.AdjustStock(ctx, "test-prod-legacy", 4); err != nil {
+		t.Fatalf("AdjustStock error: %v", err)
+	}
+	if err := repo.ReserveStock(ctx, "test-prod-legacy", 1); err != nil {
+		t.Fatalf("ReserveStock after restocking: %v", err)
+	}
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	PromotionsCollectionName      = "promotions"
+	RedemptionsCollectionName     = "promotion_redemptions"
+	UserRedemptionsCollectionName = "promotion_user_redemptions"
+)
+
+type PromotionRepository struct {
+	collection                *mongo.Collection
+	redemptionsCollection     *mongo.Collection
+	userRedemptionsCollection *mongo.Collection
+}
+
+// NewPromotionRepository creates a new promotion repository
+func NewPromotionRepository() *PromotionRepository {
+	return &PromotionRepository{
+		collection:                GetCollection(DBName, PromotionsCollectionName),
+		redemptionsCollection:     GetCollection(DBName, RedemptionsCollectionName),
+		userRedemptionsCollection: GetCollection(DBName, UserRedemptionsCollectionName),
+	}
+}
+
+// CreatePromotion inserts a new promotion. Codes are stored upper-case and must be unique.
+func (pr *PromotionRepository) CreatePromotion(ctx context.Context, promotion *models.Promotion) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	promotion.Code = strings.ToUpper(promotion.Code)
+	promotion.CreatedAt = time.Now()
+	promotion.UpdatedAt = time.Now()
+
+	_, err := pr.collection.InsertOne(ctx, promotion)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("promotion code already exists")
+		}
+		return fmt.Errorf("failed to create promotion: %w", err)
+	}
+
+	return nil
+}
+
+// GetPromotionByID retrieves a promotion by ID
+func (pr *PromotionRepository) GetPromotionByID(ctx context.Context, promotionID string) (*models.Promotion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var promotion models.Promotion
+	err := pr.collection.FindOne(ctx, bson.M{"_id": promotionID}).Decode(&promotion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &promotion, nil
+}
+
+// GetPromotionByCode retrieves a promotion by its coupon code (case-insensitive)
+func (pr *PromotionRepository) GetPromotionByCode(ctx context.Context, code string) (*models.Promotion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var promotion models.Promotion
+	err := pr.collection.FindOne(ctx, bson.M{"code": strings.ToUpper(strings.TrimSpace(code))}).Decode(&promotion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &promotion, nil
+}
+
+// GetAllPromotions retrieves promotions with pagination, newest first
+func (pr *PromotionRepository) GetAllPromotions(ctx context.Context, page, limit int) ([]*models.Promotion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := pr.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch promotions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var promotions []*models.Promotion
+	if err := cursor.All(ctx, &promotions); err != nil {
+		return nil, fmt.Errorf("failed to decode promotions: %w", err)
+	}
+
+	return promotions, nil
+}
+
+// UpdatePromotion updates a promotion
+func (pr *PromotionRepository) UpdatePromotion(ctx context.Context, promotionID string, updates map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	updates["updatedAt"] = time.Now()
+
+	result, err := pr.collection.UpdateOne(ctx, bson.M{"_id": promotionID}, bson.M{"$set": updates})
+	if err != nil {
+		return fmt.Errorf("failed to update promotion: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("promotion not found")
+	}
+
+	return nil
+}
+
+// ClaimRedemption atomically counts one use of a promotion against its global
+// limit and, when perUserLimit is set, against the user's own limit
+func (pr *PromotionRepository) ClaimRedemption(ctx context.Context, promotionID, userID string, perUserLimit int) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if perUserLimit > 0 {
+		if err := pr.claimUserRedemption(ctx, promotionID, userID, perUserLimit); err != nil {
+			return err
+		}
+	}
+
+	// Either the promotion is unlimited or it still has redemptions left
+	filter := bson.M{
+		"_id":    promotionID,
+		"active": true,
+		"$or": bson.A{
+			bson.M{"maxRedemptions": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$redemptionCount", "$maxRedemptions"}}},
+		},
+	}
+
+	result, err := pr.collection.UpdateOne(
+		ctx,
+		filter,
+		bson.M{
+			"$inc": bson.M{"redemptionCount": 1},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err == nil && result.MatchedCount == 0 {
+		err = fmt.Errorf("promotion redemption limit reached")
+	} else if err != nil {
+		err = fmt.Errorf("failed to claim redemption: %w", err)
+	}
+
+	// Give back the user's use when the global limit refused the claim
+	if err != nil && perUserLimit > 0 {
+		_ = pr.releaseUserRedemption(ctx, promotionID, userID)
+	}
+
+	return err
+}
+
+// claimUserRedemption takes one of a user's uses of a promotion from a per-user
+// counter. The counter starts from the user's recorded redemptions so uses made
+// before it existed still count.
+func (pr *PromotionRepository) claimUserRedemption(ctx context.Context, promotionID, userID string, perUserLimit int) error {
+	used, err := pr.redemptionsCollection.CountDocuments(ctx, bson.M{"promotionId": promotionID, "userId": userID})
+	if err != nil {
+		return fmt.Errorf("failed to count redemptions: %w", err)
+	}
+
+	key := promotionID + ":" + userID
+	_, err = pr.userRedemptionsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": key},
+		bson.M{"$setOnInsert": bson.M{"promotionId": promotionID, "userId": userID, "count": used}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to claim redemption: %w", err)
+	}
+
+	result, err := pr.userRedemptionsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": key, "count": bson.M{"$lt": perUserLimit}},
+		bson.M{"$inc": bson.M{"count": 1}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to claim redemption: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("promotion user redemption limit reached")
+	}
+
+	return nil
+}
+
+// releaseUserRedemption gives back a use taken with claimUserRedemption
+func (pr *PromotionRepository) releaseUserRedemption(ctx context.Context, promotionID, userID string) error {
+	_, err := pr.userRedemptionsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": promotionID + ":" + userID, "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	return err
+}
+
+// ReleaseRedemption gives back a use previously taken with ClaimRedemption. The
+// user's own count is given back too when userID is set.
+func (pr *PromotionRepository) ReleaseRedemption(ctx context.Context, promotionID, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := pr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": promotionID, "redemptionCount": bson.M{"$gt": 0}},
+		bson.M{
+			"$inc": bson.M{"redemptionCount": -1},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release redemption: %w", err)
+	}
+
+	if userID != "" {
+		if err := pr.releaseUserRedemption(ctx, promotionID, userID); err != nil {
+			return fmt.Errorf("failed to release redemption: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CountUserRedemptions returns how many times a user has redeemed a promotion
+func (pr *PromotionRepository) CountUserRedemptions(ctx context.Context, promotionID, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := pr.redemptionsCollection.CountDocuments(ctx, bson.M{"promotionId": promotionID, "userId": userID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count redemptions: %w", err)
+	}
+
+	return count, nil
+}
+
+// CreateRedemption records a promotion used on an order
+func (pr *PromotionRepository) CreateRedemption(ctx context.Context, redemption *models.PromotionRedemption) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	redemption.CreatedAt = time.Now()
+
+	_, err := pr.redemptionsCollection.InsertOne(ctx, redemption)
+	if err != nil {
+		return fmt.Errorf("failed to create redemption: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteRedemption removes a redemption record whose order could not be placed
+func (pr *PromotionRepository) DeleteRedemption(ctx context.Context, redemptionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := pr.redemptionsCollection.DeleteOne(ctx, bson.M{"_id": redemptionID})
+	if err != nil {
+		return fmt.Errorf("failed to delete redemption: %w", err)
+	}
+
+	return nil
+}
+
+// GetRedemptionsByPromotion retrieves a promotion's redemptions with pagination, newest first
+func (pr *PromotionRepository) GetRedemptionsByPromotion(ctx context.Context, promotionID string, page, limit int) ([]*models.PromotionRedemption, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := pr.redemptionsCollection.Find(ctx, bson.M{"promotionId": promotionID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch redemptions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var redemptions []*models.PromotionRedemption
+	if err := cursor.All(ctx, &redemptions); err != nil {
+		return nil, fmt.Errorf("failed to decode redemptions: %w", err)
+	}
+
+	return redemptions, nil
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RefundsCollectionName = "refunds"
+)
+
+type RefundRepository struct {
+	collection *mongo.Collection
+}
+
+// NewRefundRepository creates a new refund repository
+func NewRefundRepository() *RefundRepository {
+	return &RefundRepository{collection: GetCollection(DBName, RefundsCollectionName)}
+}
+
+// CreateRefund records a refund payout. An invoice can have only one pending
+// refund, enforced by a unique index, so a pending refund inserted before the
+// payout is requested reserves it against concurrent requests.
+func (rr *RefundRepository) CreateRefund(ctx context.Context, refund *models.Refund) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	refund.CreatedAt = time.Now()
+	refund.UpdatedAt = time.Now()
+
+	_, err := rr.collection.InsertOne(ctx, refund)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("refund already pending")
+		}
+		return fmt.Errorf("failed to create refund: %w", err)
+	}
+
+	return nil
+}
+
+// SetRefundReferences records the references the provider returned for a
+// refund's payout request, which its result callback is matched by
+func (rr *RefundRepository) SetRefundReferences(ctx context.Context, refundID, conversationID, originatorConversationID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := rr.collection.UpdateOne(ctx, bson.M{"_id": refundID}, bson.M{"$set": bson.M{
+		"conversationId":           conversationID,
+		"originatorConversationId": originatorConversationID,
+		"updatedAt":                time.Now(),
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("refund not found")
+	}
+
+	return nil
+}
+
+// GetRefundByConversationID finds the refund an asynchronous B2C result refers to,
+// by either of the conversation IDs returned when the payout was requested
+func (rr *RefundRepository) GetRefundByConversationID(ctx context.Context, conversationID, originatorConversationID string) (*models.Refund, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var ids []bson.M
+	if conversationID != "" {
+		ids = append(ids, bson.M{"conversationId": conversationID})
+	}
+	if originatorConversationID != "" {
+		ids = append(ids, bson.M{"originatorConversationId": originatorConversationID})
+	}
+	if len(ids) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	var refund models.Refund
+	if err := rr.collection.FindOne(ctx, bson.M{"$or": ids}).Decode(&refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// GetRefundsByInvoiceID retrieves all refunds for an invoice, newest first
+func (rr *RefundRepository) GetRefundsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.Refund, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := rr.collection.Find(ctx, bson.M{"invoiceId": invoiceID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find refunds: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var refunds []*models.Refund
+	if err = cursor.All(ctx, &refunds); err != nil {
+		return nil, fmt.Errorf("failed to decode refunds: %w", err)
+	}
+
+	return refunds, nil
+}
+
+// TransitionRefundStatus moves a refund from one status to another, recording the
+// M-Pesa result when one is given. It returns false without changing anything if
+// the refund is no longer in fromStatus, so repeated result callbacks are harmless.
+func (rr *RefundRepository) TransitionRefundStatus(ctx context.Context, refundID, fromStatus, toStatus string, result *models.RefundResult) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	set := bson.M{
+		"status":    toStatus,
+		"updatedAt": time.Now(),
+	}
+	update := bson.M{"$set": set}
+	if result != nil {
+		set["resultCode"] = result.ResultCode
+		set["resultDesc"] = result.ResultDesc
+		set["transactionId"] = result.TransactionID
+		set["receiverName"] = result.ReceiverName
+	} else {
+		update["$unset"] = bson.M{"resultCode": "", "resultDesc": "", "transactionId": "", "receiverName": ""}
+	}
+	if toStatus == models.RefundStatusPaid {
+		set["completedAt"] = time.Now()
+	}
+
+	res, err := rr.collection.UpdateOne(ctx, bson.M{"_id": refundID, "status": fromStatus}, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to update refund status: %w", err)
+	}
+
+	return res.ModifiedCount > 0, nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestRefundRepository_PayoutLifecycle(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping refund repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewRefundRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	refund := &models.Refund{ID: "refund-test-1", InvoiceID: "inv-1", ReversalID: "rev-1", Amount: 500, Status: models.RefundStatusPending, ConversationID: "AG_1", OriginatorConversationID: "orig-1"}
+	if err := repo.CreateRefund(ctx, refund); err != nil {
+		t.Fatalf("CreateRefund error: %v", err)
+	}
+
+	found, err := repo.GetRefundByConversationID(ctx, "", "orig-1")
+	if err != nil || found.ID != "refund-test-1" {
+		t.Fatalf("expected refund by originator conversation ID, got %+v (err=%v)", found, err)
+	}
+
+	result := &models.RefundResult{TransactionID: "NLJ41HAY6Q", ReceiverName: "254712345678 - Jane Doe"}
+	ok, err := repo.TransitionRefundStatus(ctx, "refund-test-1", models.RefundStatusPending, models.RefundStatusPaid, result)
+	if err != nil || !ok {
+		t.Fatalf("expected refund to be marked paid (ok=%v, err=%v)", ok, err)
+	}
+
+	// A repeated result callback finds the refund already settled
+	ok, _ = repo.TransitionRefundStatus(ctx, "refund-test-1", models.RefundStatusPending, models.RefundStatusPaid, result)
+	if ok {
+		t.Fatalf("expected second transition to be a no-op")
+	}
+
+	refunds, err := repo.GetRefundsByInvoiceID(ctx, "inv-1")
+	if err != nil || len(refunds) != 1 {
+		t.Fatalf("expected one refund for invoice, got %d (err=%v)", len(refunds), err)
+	}
+	if refunds[0].TransactionID != "NLJ41HAY6Q" || refunds[0].CompletedAt == nil {
+		t.Fatalf("unexpected paid refund: %+v", refunds[0])
+	}
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
+
+func TestRefundRepository_OnePendingRefundPerInvoice(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping refund repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewRefundRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+	if err := CreateIndexes(); err != nil {
+		t.Fatalf("CreateIndexes error: %v", err)
+	}
+
+	reserved := &models.Refund{ID: "refund-res-1", InvoiceID: "inv-res", Amount: 500, Status: models.RefundStatusPending}
+	if err := repo.CreateRefund(ctx, reserved); err != nil {
+		t.Fatalf("CreateRefund error: %v", err)
+	}
+
+	// A concurrent request for the same invoice cannot reserve a second payout
+	err := repo.CreateRefund(ctx, &models.Refund{ID: "refund-res-2", InvoiceID: "inv-res", Amount: 500, Status: models.RefundStatusPending})
+	if err == nil || err.Error() != "refund already pending" {
+		t.Fatalf("expected refund already pending, got %v", err)
+	}
+
+	if err := repo.SetRefundReferences(ctx, "refund-res-1", "AG_RES", "orig-res"); err != nil {
+		t.Fatalf("SetRefundReferences error: %v", err)
+	}
+	if found, err := repo.GetRefundByConversationID(ctx, "AG_RES", ""); err != nil || found.ID != "refund-res-1" {
+		t.Fatalf("expected the reserved refund by conversation ID, got %+v (err=%v)", found, err)
+	}
+
+	// Once the first payout fails, the invoice can be refunded again
+	if ok, err := repo.TransitionRefundStatus(ctx, "refund-res-1", models.RefundStatusPending, models.RefundStatusFailed, nil); err != nil || !ok {
+		t.Fatalf("TransitionRefundStatus: ok=%v err=%v", ok, err)
+	}
+	if err := repo.CreateRefund(ctx, &models.Refund{ID: "refund-res-3", InvoiceID: "inv-res", Amount: 500, Status: models.RefundStatusPending}); err != nil {
+		t.Fatalf("expected a new refund after the first failed, got %v", err)
+	}
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
@@ -11,20 +11,32 @@ import (
 )
 
 type ReportRepository struct {
-	ordersCollection    *mongo.Collection
-	paymentsCollection  *mongo.Collection
-	reversalsCollection Collection
+	ordersCollection      *mongo.Collection
+	paymentsCollection    *mongo.Collection
+	reversalsCollection   Collection
+	redemptionsCollection *mongo.Collection
+	refundsCollection     *mongo.Collection
 }
 
 // NewReportRepository creates a new report repository
 func NewReportRepository() *ReportRepository {
 	return &ReportRepository{
-		ordersCollection:    GetCollection(DBName, OrdersCollectionName),
-		paymentsCollection:  GetCollection(DBName, PaymentRecordsCollectionName),
-		reversalsCollection: NewMongoCollection(GetCollection(DBName, ReversalsCollectionName)),
+		ordersCollection:      GetCollection(DBName, OrdersCollectionName),
+		paymentsCollection:    GetCollection(DBName, PaymentRecordsCollectionName),
+		reversalsCollection:   NewMongoCollection(GetCollection(DBName, ReversalsCollectionName)),
+		redemptionsCollection: GetCollection(DBName, RedemptionsCollectionName),
+		refundsCollection:     GetCollection(DBName, RefundsCollectionName),
 	}
 }
 
+// settledReversals matches reversals that took money off an invoice. M-Pesa reversals
+// that are still pending, or that Safaricom rejected, never did.
+var settledReversals = bson.E{Key: "status", Value: bson.D{{Key: "$nin", Value: []string{
+	models.ReversalStatusPending,
+	models.ReversalStatusFailed,
+	models.ReversalStatusTimedOut,
+}}}}
+
 // GetSummaryReport returns aggregate metrics for a date range
 func (rr *ReportRepository) GetSummaryReport(ctx context.Context, startDate, endDate string) (*models.SummaryReport, error) {
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
@@ -87,6 +99,7 @@ func (rr *ReportRepository) GetSummaryReport(ctx context.Context, startDate, end
 					{Key: "$gte", Value: startDate},
 					{Key: "$lte", Value: endDate},
 				}},
+				settledReversals,
 			}},
 		},
 		bson.D{
@@ -320,6 +333,7 @@ func (rr *ReportRepository) GetDailyBreakdown(ctx context.Context, startDate, en
 			bson.D{
 				{Key: "$match", Value: bson.D{
 					{Key: "date", Value: stat.Date},
+					settledReversals,
 				}},
 			},
 			bson.D{
@@ -346,3 +360,166 @@ func (rr *ReportRepository) GetDailyBreakdown(ctx context.Context, startDate, en
 
 	return dailyReports, nil
 }
+
+// GetPromotionReport returns redemptions and discounts per promotion for a date range
+func (rr *ReportRepository) GetPromotionReport(ctx context.Context, startDate, endDate string) ([]models.PromotionReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// Parse dates
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date format: %w", err)
+	}
+	end, err := time.Parse("2006-01-02", endDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date format: %w", err)
+	}
+
+	// Include the entire end date
+	end = end.AddDate(0, 0, 1).Add(-time.Second)
+
+	pipeline := mongo.Pipeline{
+		bson.D{
+			{Key: "$match", Value: bson.D{
+				{Key: "createdAt", Value: bson.D{
+					{Key: "$gte", Value: start},
+					{Key: "$lte", Value: end},
+				}},
+			}},
+		},
+		bson.D{
+			{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$promotionId"},
+				{Key: "code", Value: bson.D{{Key: "$first", Value: "$code"}}},
+				{Key: "redemptions", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "totalDiscount", Value: bson.D{{Key: "$sum", Value: "$discount"}}},
+			}},
+		},
+		bson.D{
+			{Key: "$sort", Value: bson.D{{Key: "totalDiscount", Value: -1}}},
+		},
+	}
+
+	cursor, err := rr.redemptionsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate redemptions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	reports := []models.PromotionReport{}
+	if err = cursor.All(ctx, &reports); err != nil {
+		return nil, fmt.Errorf("failed to decode promotion stats: %w", err)
+	}
+
+	return reports, nil
+}
+
+// GetRefundReport reconciles the refunds owed on reversals dated within a range
+// against what has been paid out, listing the reversals not yet fully refunded
+func (rr *ReportRepository) GetRefundReport(ctx context.Context, startDate, endDate string) (*models.RefundReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := time.Parse("2006-01-02", startDate); err != nil {
+		return nil, fmt.Errorf("invalid start date format: %w", err)
+	}
+	if _, err := time.Parse("2006-01-02", endDate); err != nil {
+		return nil, fmt.Errorf("invalid end date format: %w", err)
+	}
+
+	pipeline := mongo.Pipeline{
+		bson.D{
+			{Key: "$match", Value: bson.D{
+				{Key: "date", Value: bson.D{
+					{Key: "$gte", Value: startDate},
+					{Key: "$lte", Value: endDate},
+				}},
+				{Key: "refundOwed", Value: bson.D{{Key: "$gt", Value: 0}}},
+			}},
+		},
+		bson.D{
+			{Key: "$project", Value: bson.D{
+				{Key: "invoiceId", Value: 1},
+				{Key: "date", Value: 1},
+				{Key: "refundOwed", Value: 1},
+				{Key: "refundedAmount", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$refundedAmount", 0}}}},
+				{Key: "outstanding", Value: bson.D{{Key: "$subtract", Value: bson.A{
+					"$refundOwed",
+					bson.D{{Key: "$ifNull", Value: bson.A{"$refundedAmount", 0}}},
+				}}}},
+			}},
+		},
+		bson.D{
+			{Key: "$sort", Value: bson.D{{Key: "date", Value: 1}}},
+		},
+	}
+
+	cursor, err := rr.reversalsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate refunds owed: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var owed []models.RefundReconciliation
+	if err = cursor.All(ctx, &owed); err != nil {
+		return nil, fmt.Errorf("failed to decode refunds owed: %w", err)
+	}
+
+	report := &models.RefundReport{
+		DateRange: models.DateRange{
+			StartDate: startDate,
+			EndDate:   endDate,
+		},
+		Unsettled: []models.RefundReconciliation{},
+	}
+
+	reversalIDs := make([]string, 0, len(owed))
+	for _, r := range owed {
+		report.RefundsOwed += r.RefundOwed
+		report.RefundsPaid += r.RefundedAmount
+		if r.Outstanding > 0.005 {
+			report.Unsettled = append(report.Unsettled, r)
+		}
+		reversalIDs = append(reversalIDs, r.ReversalID)
+	}
+	report.Outstanding = report.RefundsOwed - report.RefundsPaid
+
+	if len(reversalIDs) == 0 {
+		return report, nil
+	}
+
+	// Payouts requested against these reversals that M-Pesa has not yet confirmed
+	pendingPipeline := mongo.Pipeline{
+		bson.D{
+			{Key: "$match", Value: bson.D{
+				{Key: "reversalId", Value: bson.D{{Key: "$in", Value: reversalIDs}}},
+				{Key: "status", Value: models.RefundStatusPending},
+			}},
+		},
+		bson.D{
+			{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: nil},
+				{Key: "totalPending", Value: bson.D{{Key: "$sum", Value: "$amount"}}},
+			}},
+		},
+	}
+
+	pendingCursor, err := rr.refundsCollection.Aggregate(ctx, pendingPipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate pending refunds: %w", err)
+	}
+	defer pendingCursor.Close(ctx)
+
+	var pending []struct {
+		TotalPending float64 `bson:"totalPending"`
+	}
+	if err = pendingCursor.All(ctx, &pending); err != nil {
+		return nil, fmt.Errorf("failed to decode pending refunds: %w", err)
+	}
+	if len(pending) > 0 {
+		report.RefundsPending = pending[0].TotalPending
+	}
+
+	return report, nil
+}
@@ -6,6 +6,9 @@ import (
 	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -36,3 +39,95 @@ func (rr *ReversalRepository) CreateReversalRecord(ctx context.Context, r *model
 	}
 	return nil
 }
+
+// GetReversalByConversationID finds the reversal an asynchronous M-Pesa result refers
+// to, by either of the conversation IDs returned when the reversal was requested
+func (rr *ReversalRepository) GetReversalByConversationID(ctx context.Context, conversationID, originatorConversationID string) (*models.ReversalRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var ids []bson.M
+	if conversationID != "" {
+		ids = append(ids, bson.M{"conversationId": conversationID})
+	}
+	if originatorConversationID != "" {
+		ids = append(ids, bson.M{"originatorConversationId": originatorConversationID})
+	}
+	if len(ids) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	var rev models.ReversalRecord
+	if err := rr.collection.FindOne(ctx, bson.M{"$or": ids}).Decode(&rev); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// TransitionReversalStatus moves a reversal from one status to another and records the
+// M-Pesa result. It returns false without changing anything if the reversal is no
+// longer in fromStatus, so repeated result callbacks are harmless.
+func (rr *ReversalRepository) TransitionReversalStatus(ctx context.Context, reversalID, fromStatus, toStatus string, resultCode int, resultDesc, resultTransactionID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	set := bson.M{
+		"status":              toStatus,
+		"resultCode":          resultCode,
+		"resultDesc":          resultDesc,
+		"resultTransactionId": resultTransactionID,
+	}
+	if toStatus == models.ReversalStatusCompleted {
+		set["completedAt"] = time.Now()
+	}
+
+	result, err := rr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": reversalID, "status": fromStatus},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update reversal status: %w", err)
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// GetReversalsByInvoiceID retrieves all reversals recorded against an invoice, newest first
+func (rr *ReversalRepository) GetReversalsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.ReversalRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := rr.collection.Find(ctx, bson.M{"invoiceId": invoiceID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find reversals: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var reversals []*models.ReversalRecord
+	if err = cursor.All(ctx, &reversals); err != nil {
+		return nil, fmt.Errorf("failed to decode reversals: %w", err)
+	}
+
+	return reversals, nil
+}
+
+// AddRefundedAmount adds a confirmed refund payout to the amount refunded on a
+// reversal. A negative amount takes a payout back off, undoing an earlier call.
+func (rr *ReversalRepository) AddRefundedAmount(ctx context.Context, reversalID string, amount float64) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := rr.collection.UpdateOne(ctx, bson.M{"_id": reversalID}, bson.M{
+		"$inc": bson.M{"refundedAmount": amount},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update refunded amount: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("reversal not found")
+	}
+
+	return nil
+}
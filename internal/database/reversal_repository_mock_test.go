@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -57,3 +58,92 @@ func TestReversalRepository_CreateReversalRecord_Error_Mock(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to create reversal record")
 }
+
+func TestReversalRepository_GetReversalByConversationID_Mock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	mockCollection.On("FindOne", mock.Anything, bson.M{"$or": []bson.M{
+		{"conversationId": "AG_1"},
+		{"originatorConversationId": "orig-1"},
+	}}).Return(mongo.NewSingleResultFromDocument(bson.M{
+		"_id":            "rev-1",
+		"invoiceId":      "inv-1",
+		"status":         models.ReversalStatusPending,
+		"conversationId": "AG_1",
+	}, nil, nil))
+
+	repo := NewReversalRepositoryWithCollection(mockCollection)
+
+	rev, err := repo.GetReversalByConversationID(context.Background(), "AG_1", "orig-1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "rev-1", rev.ID)
+	assert.Equal(t, models.ReversalStatusPending, rev.Status)
+}
+
+func TestReversalRepository_GetReversalByConversationID_NoIDs_Mock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	repo := NewReversalRepositoryWithCollection(mockCollection)
+
+	_, err := repo.GetReversalByConversationID(context.Background(), "", "")
+
+	assert.Equal(t, mongo.ErrNoDocuments, err)
+	mockCollection.AssertNotCalled(t, "FindOne", mock.Anything, mock.Anything)
+}
+
+func TestReversalRepository_TransitionReversalStatus_Mock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	mockCollection.On("UpdateOne", mock.Anything,
+		bson.M{"_id": "rev-1", "status": models.ReversalStatusPending},
+		mock.MatchedBy(func(update interface{}) bool {
+			set := update.(bson.M)["$set"].(bson.M)
+			_, hasCompletedAt := set["completedAt"]
+			return set["status"] == models.ReversalStatusCompleted && set["resultTransactionId"] == "REV123" && hasCompletedAt
+		}), mock.Anything).Return(&mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil)
+
+	repo := NewReversalRepositoryWithCollection(mockCollection)
+
+	ok, err := repo.TransitionReversalStatus(context.Background(), "rev-1", models.ReversalStatusPending, models.ReversalStatusCompleted, 0, "Accepted", "REV123")
+
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestReversalRepository_TransitionReversalStatus_AlreadySettled_Mock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	mockCollection.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&mongo.UpdateResult{}, nil)
+
+	repo := NewReversalRepositoryWithCollection(mockCollection)
+
+	ok, err := repo.TransitionReversalStatus(context.Background(), "rev-1", models.ReversalStatusPending, models.ReversalStatusFailed, 1, "Failed", "")
+
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestReversalRepository_AddRefundedAmount_Mock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	mockCollection.On("UpdateOne", mock.Anything,
+		bson.M{"_id": "rev-1"},
+		bson.M{"$inc": bson.M{"refundedAmount": 500.0}},
+		mock.Anything).Return(&mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil)
+
+	repo := NewReversalRepositoryWithCollection(mockCollection)
+
+	err := repo.AddRefundedAmount(context.Background(), "rev-1", 500)
+
+	assert.NoError(t, err)
+	mockCollection.AssertExpectations(t)
+}
+
+func TestReversalRepository_AddRefundedAmount_NotFound_Mock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	mockCollection.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&mongo.UpdateResult{}, nil)
+
+	repo := NewReversalRepositoryWithCollection(mockCollection)
+
+	err := repo.AddRefundedAmount(context.Background(), "missing", 500)
+
+	assert.EqualError(t, err, "reversal not found")
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RolesCollectionName = "roles"
+)
+
+// RoleRepository stores roles and the permissions they grant
+type RoleRepository struct {
+	collection Collection
+}
+
+// NewRoleRepository creates a new role repository
+func NewRoleRepository() *RoleRepository {
+	return &RoleRepository{collection: NewMongoCollection(GetCollection(DBName, RolesCollectionName))}
+}
+
+// NewRoleRepositoryWithCollection creates a role repository with custom collection (for testing)
+func NewRoleRepositoryWithCollection(c Collection) *RoleRepository {
+	return &RoleRepository{collection: c}
+}
+
+// EnsureRoles creates the roles that do not exist yet, leaving existing ones as
+// they are, and marks them built in
+func (rr *RoleRepository) EnsureRoles(ctx context.Context, roles []*models.Role) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	for _, role := range roles {
+		_, err := rr.collection.UpdateOne(ctx, bson.M{"_id": role.ID}, bson.M{
+			"$setOnInsert": bson.M{
+				"description": role.Description,
+				"permissions": role.Permissions,
+				"createdAt":   now,
+				"updatedAt":   now,
+			},
+			"$set": bson.M{"builtIn": true},
+		}, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to create role %s: %w", role.ID, err)
+		}
+	}
+	return nil
+}
+
+// CreateRole inserts a new role
+func (rr *RoleRepository) CreateRole(ctx context.Context, role *models.Role) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	role.CreatedAt = now
+	role.UpdatedAt = now
+
+	_, err := rr.collection.InsertOne(ctx, role)
+	if err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+	return nil
+}
+
+// GetRole retrieves a role by its ID
+func (rr *RoleRepository) GetRole(ctx context.Context, roleID string) (*models.Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var role models.Role
+	err := rr.collection.FindOne(ctx, bson.M{"_id": roleID}).Decode(&role)
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// GetRoles retrieves the roles with the given IDs; IDs with no role are left out
+func (rr *RoleRepository) GetRoles(ctx context.Context, roleIDs []string) ([]*models.Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := rr.collection.Find(ctx, bson.M{"_id": bson.M{"$in": roleIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch roles: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var roles []*models.Role
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, fmt.Errorf("failed to decode roles: %w", err)
+	}
+	return roles, nil
+}
+
+// ListRoles retrieves every role, ordered by ID
+func (rr *RoleRepository) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := rr.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch roles: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var roles []*models.Role
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, fmt.Errorf("failed to decode roles: %w", err)
+	}
+	return roles, nil
+}
+
+// UpdateRole replaces a role's description and permissions. It returns false if
+// there is no such role.
+func (rr *RoleRepository) UpdateRole(ctx context.Context, roleID string, description string, permissions []string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := rr.collection.UpdateOne(ctx, bson.M{"_id": roleID}, bson.M{
+		"$set": bson.M{"description": description, "permissions": permissions, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to update role: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// DeleteRole removes a role that is not built in. It returns false if there is no
+// such role or it is built in.
+func (rr *RoleRepository) DeleteRole(ctx context.Context, roleID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := rr.collection.DeleteOne(ctx, bson.M{"_id": roleID, "builtIn": bson.M{"$ne": true}})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete role: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestRoleRepository_EnsureUpdateDelete(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping role repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewRoleRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	if err := repo.EnsureRoles(ctx, models.DefaultRoles()); err != nil {
+		t.Fatalf("EnsureRoles error: %v", err)
+	}
+
+	// Edits to a built-in role survive the next startup
+	if ok, err := repo.UpdateRole(ctx, models.RoleSupport, "Help desk", []string{models.PermissionOrdersRead}); err != nil || !ok {
+		t.Fatalf("UpdateRole: ok=%v err=%v", ok, err)
+	}
+	if err := repo.EnsureRoles(ctx, models.DefaultRoles()); err != nil {
+		t.Fatalf("EnsureRoles error: %v", err)
+	}
+	support, err := repo.GetRole(ctx, models.RoleSupport)
+	if err != nil || support.Description != "Help desk" || len(support.Permissions) != 1 || !support.BuiltIn {
+		t.Fatalf("expected the edited support role, got %+v (err=%v)", support, err)
+	}
+
+	custom := &models.Role{ID: "packer", Permissions: []string{models.PermissionOrdersRead}}
+	if err := repo.CreateRole(ctx, custom); err != nil {
+		t.Fatalf("CreateRole error: %v", err)
+	}
+	if err := repo.CreateRole(ctx, custom); err == nil {
+		t.Fatalf("expected a duplicate role to be refused")
+	}
+
+	roles, err := repo.GetRoles(ctx, []string{"packer", models.RoleWarehouse, "missing"})
+	if err != nil || len(roles) != 2 {
+		t.Fatalf("expected 2 roles, got %d (err=%v)", len(roles), err)
+	}
+
+	all, err := repo.ListRoles(ctx)
+	if err != nil || len(all) != len(models.DefaultRoles())+1 {
+		t.Fatalf("expected every role, got %d (err=%v)", len(all), err)
+	}
+
+	if ok, _ := repo.DeleteRole(ctx, models.RoleWarehouse); ok {
+		t.Fatalf("expected built-in roles to be kept")
+	}
+	if ok, err := repo.DeleteRole(ctx, "packer"); err != nil || !ok {
+		t.Fatalf("DeleteRole: ok=%v err=%v", ok, err)
+	}
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
+
+func TestUserRepository_Roles(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping user repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewUserRepository()
+	ctx := context.Background()
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{"_id": map[string]interface{}{"$in": []string{"test-roles-1", "test-roles-2"}}})
+
+	// A user from before roles could be combined, and one with several roles
+	legacy := &models.User{ID: "test-roles-1", Email: "legacy-roles@example.com", Role: "warehouse", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	current := &models.User{ID: "test-roles-2", Email: "current-roles@example.com", Role: "user", Roles: []string{"user"}, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	for _, u := range []*models.User{legacy, current} {
+		if err := repo.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser error: %v", err)
+		}
+	}
+	if err := repo.SetUserRoles(ctx, current.ID, []string{"finance", "warehouse"}); err != nil {
+		t.Fatalf("SetUserRoles error: %v", err)
+	}
+
+	found, err := repo.FindUserByID(ctx, current.ID)
+	if err != nil || found.Role != "finance" || len(found.RoleNames()) != 2 {
+		t.Fatalf("expected finance and warehouse, got %q %v (err=%v)", found.Role, found.Roles, err)
+	}
+	count, err := repo.CountUsersWithRole(ctx, "warehouse")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 warehouse users, got %d (err=%v)", count, err)
+	}
+
+	// Suspended users are not counted as active
+	if _, err := repo.SuspendUser(ctx, legacy.ID, &models.Suspension{Reason: "test", SuspendedAt: time.Now()}); err != nil {
+		t.Fatalf("SuspendUser error: %v", err)
+	}
+	count, err = repo.CountActiveUsersWithRole(ctx, "warehouse")
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 active warehouse user, got %d (err=%v)", count, err)
+	}
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{"_id": map[string]interface{}{"$in": []string{"test-roles-1", "test-roles-2"}}})
+}
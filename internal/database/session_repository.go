@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	SessionsCollectionName = "sessions"
+)
+
+// maxPreviousRefreshHashes caps how many replaced refresh tokens a session
+// remembers for reuse detection
+const maxPreviousRefreshHashes = 20
+
+// SessionRepository stores login sessions and their refresh tokens
+type SessionRepository struct {
+	collection Collection
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository() *SessionRepository {
+	return &SessionRepository{collection: NewMongoCollection(GetCollection(DBName, SessionsCollectionName))}
+}
+
+// NewSessionRepositoryWithCollection creates a session repository with custom collection (for testing)
+func NewSessionRepositoryWithCollection(c Collection) *SessionRepository {
+	return &SessionRepository{collection: c}
+}
+
+// CreateSession inserts a new session
+func (sr *SessionRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := sr.collection.InsertOne(ctx, session)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// GetSessionByID retrieves a session, revoked or not
+func (sr *SessionRepository) GetSessionByID(ctx context.Context, sessionID string) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := sr.collection.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateRefreshToken replaces the session's refresh token and access token. It
+// returns false without changing anything unless refreshTokenHash is still the
+// session's current refresh token and the session is active, so of two refreshes
+// racing with the same token only one succeeds.
+func (sr *SessionRepository) RotateRefreshToken(ctx context.Context, sessionID, refreshTokenHash string, rotation *models.SessionRotation) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"_id":              sessionID,
+		"refreshTokenHash": refreshTokenHash,
+		"revokedAt":        bson.M{"$exists": false},
+		"expiresAt":        bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"refreshTokenHash": rotation.RefreshTokenHash,
+			"accessTokenId":    rotation.AccessTokenID,
+			"accessExpiresAt":  rotation.AccessExpiresAt,
+			"expiresAt":        rotation.ExpiresAt,
+			"userAgent":        rotation.UserAgent,
+			"ip":               rotation.IP,
+			"lastUsedAt":       now,
+		},
+		"$push": bson.M{
+			"previousRefreshHashes": bson.M{"$each": []string{refreshTokenHash}, "$slice": -maxPreviousRefreshHashes},
+		},
+	}
+
+	res, err := sr.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// ListActiveSessions retrieves a user's unrevoked, unexpired sessions, most
+// recently used first
+func (sr *SessionRepository) ListActiveSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":    userID,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "lastUsedAt", Value: -1}})
+
+	cursor, err := sr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sessions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []*models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession marks a session revoked so its refresh token stops working. It
+// returns false if the session was already revoked.
+func (sr *SessionRepository) RevokeSession(ctx context.Context, sessionID, reason string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := sr.collection.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now(), "revokedReason": reason}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return res.ModifiedCount > 0, nil
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestSessionRepository_RotateAndRevoke(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping session repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewSessionRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+
+	now := time.Now()
+	session := &models.Session{
+		ID:               "session-test-1",
+		UserID:           "user-1",
+		RefreshTokenHash: "hash-1",
+		AccessTokenID:    "jti-1",
+		AccessExpiresAt:  now.Add(15 * time.Minute),
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(24 * time.Hour),
+	}
+	if err := repo.CreateSession(ctx, session); err != nil {
+		t.Fatalf("CreateSession error: %v", err)
+	}
+
+	rotation := &models.SessionRotation{RefreshTokenHash: "hash-2", AccessTokenID: "jti-2", AccessExpiresAt: now.Add(15 * time.Minute), ExpiresAt: now.Add(24 * time.Hour), IP: "10.0.0.2"}
+	ok, err := repo.RotateRefreshToken(ctx, "session-test-1", "hash-1", rotation)
+	if err != nil || !ok {
+		t.Fatalf("expected refresh token to rotate (ok=%v, err=%v)", ok, err)
+	}
+
+	// The replaced token no longer rotates, and is remembered for reuse detection
+	ok, _ = repo.RotateRefreshToken(ctx, "session-test-1", "hash-1", rotation)
+	if ok {
+		t.Fatalf("expected rotation with a replaced token to be a no-op")
+	}
+	found, err := repo.GetSessionByID(ctx, "session-test-1")
+	if err != nil || found.RefreshTokenHash != "hash-2" || found.AccessTokenID != "jti-2" || found.IP != "10.0.0.2" {
+		t.Fatalf("unexpected rotated session: %+v (err=%v)", found, err)
+	}
+	if len(found.PreviousRefreshHashes) != 1 || found.PreviousRefreshHashes[0] != "hash-1" {
+		t.Fatalf("expected replaced hash to be remembered, got %v", found.PreviousRefreshHashes)
+	}
+
+	sessions, err := repo.ListActiveSessions(ctx, "user-1")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("expected one active session, got %d (err=%v)", len(sessions), err)
+	}
+
+	ok, err = repo.RevokeSession(ctx, "session-test-1", models.SessionRevokedByUser)
+	if err != nil || !ok {
+		t.Fatalf("expected session to be revoked (ok=%v, err=%v)", ok, err)
+	}
+	ok, _ = repo.RevokeSession(ctx, "session-test-1", models.SessionRevokedByUser)
+	if ok {
+		t.Fatalf("expected second revoke to be a no-op")
+	}
+
+	// A revoked session neither rotates nor lists
+	ok, _ = repo.RotateRefreshToken(ctx, "session-test-1", "hash-2", rotation)
+	if ok {
+		t.Fatalf("expected revoked session not to rotate")
+	}
+	sessions, _ = repo.ListActiveSessions(ctx, "user-1")
+	if len(sessions) != 0 {
+		t.Fatalf("expected no active sessions after revoke, got %d", len(sessions))
+	}
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+}
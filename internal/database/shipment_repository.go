@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ShipmentsCollectionName          = "shipments"
+	DeliverySignaturesCollectionName = "delivery_signatures"
+)
+
+// openShipmentStatuses are the statuses of shipments still on their way
+var openShipmentStatuses = []string{
+	models.ShipmentStatusPreparing,
+	models.ShipmentStatusDispatched,
+	models.ShipmentStatusReadyForPickup,
+}
+
+// ShipmentRepository stores shipments and their proof-of-delivery signatures
+type ShipmentRepository struct {
+	collection Collection
+	signatures Collection
+}
+
+// NewShipmentRepository creates a new shipment repository
+func NewShipmentRepository() *ShipmentRepository {
+	return &ShipmentRepository{
+		collection: NewMongoCollection(GetCollection(DBName, ShipmentsCollectionName)),
+		signatures: NewMongoCollection(GetCollection(DBName, DeliverySignaturesCollectionName)),
+	}
+}
+
+// NewShipmentRepositoryWithCollections creates a shipment repository with custom collections (for testing)
+func NewShipmentRepositoryWithCollections(shipments, signatures Collection) *ShipmentRepository {
+	return &ShipmentRepository{collection: shipments, signatures: signatures}
+}
+
+// CreateShipment inserts a new shipment
+func (sr *ShipmentRepository) CreateShipment(ctx context.Context, shipment *models.Shipment) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	shipment.CreatedAt = now
+	shipment.UpdatedAt = now
+
+	_, err := sr.collection.InsertOne(ctx, shipment)
+	if err != nil {
+		return fmt.Errorf("failed to create shipment: %w", err)
+	}
+	return nil
+}
+
+// DeleteShipment removes a shipment that could not be created with its order
+func (sr *ShipmentRepository) DeleteShipment(ctx context.Context, shipmentID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := sr.collection.DeleteOne(ctx, bson.M{"_id": shipmentID})
+	if err != nil {
+		return fmt.Errorf("failed to delete shipment: %w", err)
+	}
+	return nil
+}
+
+// GetShipment retrieves a shipment by its ID
+func (sr *ShipmentRepository) GetShipment(ctx context.Context, shipmentID string) (*models.Shipment, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var shipment models.Shipment
+	err := sr.collection.FindOne(ctx, bson.M{"_id": shipmentID}).Decode(&shipment)
+	if err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// ListShipmentsByOrder retrieves an order's shipments, oldest first
+func (sr *ShipmentRepository) ListShipmentsByOrder(ctx context.Context, orderID string) ([]*models.Shipment, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := sr.collection.Find(ctx, bson.M{"orderId": orderID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch shipments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var shipments []*models.Shipment
+	if err := cursor.All(ctx, &shipments); err != nil {
+		return nil, fmt.Errorf("failed to decode shipments: %w", err)
+	}
+	return shipments, nil
+}
+
+// UpdateShipmentTracking sets carrier, rider, tracking and pickup fields on a
+// shipment still on its way. It returns false if there is no such open shipment.
+func (sr *ShipmentRepository) UpdateShipmentTracking(ctx context.Context, shipmentID string, updates map[string]interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	set := bson.M{"updatedAt": time.Now()}
+	for field, value := range updates {
+		set[field] = value
+	}
+	result, err := sr.collection.UpdateOne(ctx, bson.M{"_id": shipmentID, "status": bson.M{"$in": openShipmentStatuses}}, bson.M{"$set": set})
+	if err != nil {
+		return false, fmt.Errorf("failed to update shipment: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// DispatchShipment moves a prepared shipment to status (dispatched or ready for
+// pickup) and stores the customer's delivery code. It returns false if the
+// shipment is not being prepared.
+func (sr *ShipmentRepository) DispatchShipment(ctx context.Context, shipmentID, status, deliveryCode string, dispatchedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := sr.collection.UpdateOne(ctx, bson.M{"_id": shipmentID, "status": models.ShipmentStatusPreparing}, bson.M{"$set": bson.M{
+		"status":               status,
+		"deliveryCode":         deliveryCode,
+		"deliveryCodeAttempts": 0,
+		"dispatchedAt":         dispatchedAt,
+		"updatedAt":            dispatchedAt,
+	}})
+	if err != nil {
+		return false, fmt.Errorf("failed to dispatch shipment: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// RecordDeliveryCodeFailure counts a wrong delivery code given for a shipment
+func (sr *ShipmentRepository) RecordDeliveryCodeFailure(ctx context.Context, shipmentID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := sr.collection.UpdateOne(ctx, bson.M{"_id": shipmentID}, bson.M{
+		"$inc": bson.M{"deliveryCodeAttempts": 1},
+		"$set": bson.M{"updatedAt": time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record delivery code failure: %w", err)
+	}
+	return nil
+}
+
+// DeliverShipment marks a dispatched or ready shipment delivered with its proof,
+// and clears the delivery code. It returns false if the shipment is not awaiting
+// delivery.
+func (sr *ShipmentRepository) DeliverShipment(ctx context.Context, shipmentID string, proof *models.ProofOfDelivery) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": shipmentID, "status": bson.M{"$in": []string{models.ShipmentStatusDispatched, models.ShipmentStatusReadyForPickup}}}
+	result, err := sr.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"status":      models.ShipmentStatusDelivered,
+			"proof":       proof,
+			"deliveredAt": proof.DeliveredAt,
+			"updatedAt":   proof.DeliveredAt,
+		},
+		"$unset": bson.M{"deliveryCode": ""},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to deliver shipment: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// FailShipment marks a shipment still on its way as failed, so its items can be
+// shipped again. It returns false if the shipment is not open.
+func (sr *ShipmentRepository) FailShipment(ctx context.Context, shipmentID, reason string, failedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := sr.collection.UpdateOne(ctx, bson.M{"_id": shipmentID, "status": bson.M{"$in": openShipmentStatuses}}, bson.M{
+		"$set": bson.M{
+			"status":        models.ShipmentStatusFailed,
+			"failureReason": reason,
+			"failedAt":      failedAt,
+			"updatedAt":     failedAt,
+		},
+		"$unset": bson.M{"deliveryCode": ""},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to fail shipment: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// SaveSignature stores the signature image for a shipment, replacing any earlier one
+func (sr *ShipmentRepository) SaveSignature(ctx context.Context, signature *models.DeliverySignature) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	signature.CreatedAt = time.Now()
+	_, err := sr.signatures.UpdateOne(ctx, bson.M{"_id": signature.ShipmentID}, bson.M{"$set": bson.M{
+		"userId":      signature.UserID,
+		"contentType": signature.ContentType,
+		"data":        signature.Data,
+		"createdAt":   signature.CreatedAt,
+	}}, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save signature: %w", err)
+	}
+	return nil
+}
+
+// GetSignature retrieves the signature image for a shipment
+func (sr *ShipmentRepository) GetSignature(ctx context.Context, shipmentID string) (*models.DeliverySignature, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var signature models.DeliverySignature
+	err := sr.signatures.FindOne(ctx, bson.M{"_id": shipmentID}).Decode(&signature)
+	if err != nil {
+		return nil, err
+	}
+	return &signature, nil
+}
+
+// AnonymiseShipmentsByUser removes the recipient names and signatures from a
+// user's shipments. Items, riders and timestamps are kept.
+func (sr *ShipmentRepository) AnonymiseShipmentsByUser(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := sr.collection.UpdateMany(ctx, bson.M{"userId": userID, "proof": bson.M{"$exists": true}}, bson.M{"$set": bson.M{
+		"proof.recipientName": "",
+		"updatedAt":           time.Now(),
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to anonymise shipments: %w", err)
+	}
+	if _, err := sr.signatures.DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+		return 0, fmt.Errorf("failed to delete signatures: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+)
+
+func TestShipmentRepository_Lifecycle(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping shipment repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewShipmentRepository()
+	ctx := context.Background()
+
+	// cleanup
+	repo.collection.DeleteMany(ctx, map[string]interface{}{})
+	repo.signatures.DeleteMany(ctx, map[string]interface{}{})
+
+	shipment := &models.Shipment{
+		ID:      "ship-1",
+		OrderID: "order-ship",
+		UserID:  "u-ship",
+		Items:   []models.ShipmentItem{{ProductID: "p1", Quantity: 2}},
+		Method:  models.ShipmentMethodDelivery,
+		Status:  models.ShipmentStatusPreparing,
+	}
+	if err := repo.CreateShipment(ctx, shipment); err != nil {
+		t.Fatalf("CreateShipment error: %v", err)
+	}
+
+	if ok, err := repo.UpdateShipmentTracking(ctx, shipment.ID, map[string]interface{}{"riderName": "Otieno"}); err != nil || !ok {
+		t.Fatalf("UpdateShipmentTracking: ok=%v err=%v", ok, err)
+	}
+
+	now := time.Now()
+	if ok, err := repo.DispatchShipment(ctx, shipment.ID, models.ShipmentStatusDispatched, "482915", now); err != nil || !ok {
+		t.Fatalf("DispatchShipment: ok=%v err=%v", ok, err)
+	}
+	// Only prepared shipments can be dispatched
+	if ok, err := repo.DispatchShipment(ctx, shipment.ID, models.ShipmentStatusDispatched, "000000", now); err != nil || ok {
+		t.Fatalf("second DispatchShipment: ok=%v err=%v", ok, err)
+	}
+
+	if err := repo.RecordDeliveryCodeFailure(ctx, shipment.ID); err != nil {
+		t.Fatalf("RecordDeliveryCodeFailure error: %v", err)
+	}
+	got, err := repo.GetShipment(ctx, shipment.ID)
+	if err != nil || got.DeliveryCode != "482915" || got.DeliveryCodeAttempts != 1 || got.RiderName != "Otieno" || got.DispatchedAt == nil {
+		t.Fatalf("expected a dispatched shipment with one failed code, got %+v (err=%v)", got, err)
+	}
+
+	if err := repo.SaveSignature(ctx, &models.DeliverySignature{ShipmentID: shipment.ID, UserID: "u-ship", ContentType: "image/png", Data: []byte("png")}); err != nil {
+		t.Fatalf("SaveSignature error: %v", err)
+	}
+	proof := &models.ProofOfDelivery{RecipientName: "Jane Doe", SignatureType: "image/png", CodeVerified: true, DeliveredAt: now}
+	if ok, err := repo.DeliverShipment(ctx, shipment.ID, proof); err != nil || !ok {
+		t.Fatalf("DeliverShipment: ok=%v err=%v", ok, err)
+	}
+	// Delivered shipments can neither fail nor change carrier
+	if ok, err := repo.FailShipment(ctx, shipment.ID, "not home", now); err != nil || ok {
+		t.Fatalf("FailShipment on a delivered shipment: ok=%v err=%v", ok, err)
+	}
+	if ok, err := repo.UpdateShipmentTracking(ctx, shipment.ID, map[string]interface{}{"riderName": "Kamau"}); err != nil || ok {
+		t.Fatalf("UpdateShipmentTracking on a delivered shipment: ok=%v err=%v", ok, err)
+	}
+
+	shipments, err := repo.ListShipmentsByOrder(ctx, "order-ship")
+	if err != nil || len(shipments) != 1 || shipments[0].Status != models.ShipmentStatusDelivered || shipments[0].DeliveryCode != "" {
+		t.Fatalf("expected a delivered shipment without its code, got %+v (err=%v)", shipments, err)
+	}
+
+	if n, err := repo.AnonymiseShipmentsByUser(ctx, "u-ship"); err != nil || n != 1 {
+		t.Fatalf("AnonymiseShipmentsByUser: n=%d err=%v", n, err)
+	}
+	if got, _ := repo.GetShipment(ctx, shipment.ID); got == nil || got.Proof == nil || got.Proof.RecipientName != "" {
+		t.Fatalf("expected the recipient name removed, got %+v", got)
+	}
+	if _, err := repo.GetSignature(ctx, shipment.ID); err == nil {
+		t.Fatalf("expected the signature deleted")
+	}
+
+	if err := repo.DeleteShipment(ctx, shipment.ID); err != nil {
+		t.Fatalf("DeleteShipment error: %v", err)
+	}
+	if _, err := repo.GetShipment(ctx, shipment.ID); err == nil {
+		t.Fatalf("expected the shipment deleted")
+	}
+}
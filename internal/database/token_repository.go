@@ -37,7 +37,7 @@ func NewTokenRepositoryWithCollection(c Collection) *TokenRepository {
 	}
 }
 
-// BlacklistToken adds a token to the blacklist
+// BlacklistToken adds a token to the blacklist. Blacklisting a token twice is not an error.
 func (tr *TokenRepository) BlacklistToken(ctx context.Context, token string, expiresAt time.Time) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -50,6 +50,10 @@ func (tr *TokenRepository) BlacklistToken(ctx context.Context, token string, exp
 
 	_, err := tr.collection.InsertOne(ctx, blacklistedToken)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Already blacklisted, e.g. by logout and session revocation both
+			return nil
+		}
 		return fmt.Errorf("failed to blacklist token: %w", err)
 	}
 
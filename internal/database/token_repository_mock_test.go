@@ -82,3 +82,15 @@ func TestTokenRepository_BlacklistToken_Error_WithMock(t *testing.T) {
 	// The repository wraps the error, so we check for the wrapped message
 	assert.Contains(t, err.Error(), "failed to blacklist token")
 }
+
+func TestTokenRepository_BlacklistToken_AlreadyBlacklisted_WithMock(t *testing.T) {
+	mockCollection := NewMockCollection()
+	dupErr := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "duplicate key"}}}
+	mockCollection.On("InsertOne", mock.Anything, mock.Anything).Return(nil, dupErr)
+
+	repo := NewTokenRepositoryWithCollection(mockCollection)
+
+	err := repo.BlacklistToken(context.Background(), "test-token", time.Now().Add(1*time.Hour))
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UnitOfWork runs a group of repository writes so they commit or roll back together.
+// Repositories take part in the unit simply by being called with the context passed to fn.
+type UnitOfWork interface {
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type rollbackKey struct{}
+
+// rollbackLog collects undo steps registered during a unit of work
+type rollbackLog struct {
+	mu    sync.Mutex
+	steps []func()
+}
+
+// OnRollback registers an undo step for a write made inside a unit of work.
+// Steps run in reverse order if the unit fails and the store cannot discard the
+// writes itself; inside a MongoDB transaction they are never needed and are ignored.
+func OnRollback(ctx context.Context, undo func()) {
+	if rollback, ok := ctx.Value(rollbackKey{}).(*rollbackLog); ok {
+		rollback.mu.Lock()
+		rollback.steps = append(rollback.steps, undo)
+		rollback.mu.Unlock()
+	}
+}
+
+// MongoUnitOfWork runs units of work inside MongoDB multi-document transactions
+type MongoUnitOfWork struct {
+	client *mongo.Client
+}
+
+// NewUnitOfWork returns a transactional unit of work when the connected MongoDB
+// deployment supports transactions (replica set or sharded cluster). Standalone
+// servers cannot run transactions, so they fall back to undoing completed steps.
+func NewUnitOfWork() UnitOfWork {
+	if supportsTransactions(MongoClient) {
+		return &MongoUnitOfWork{client: MongoClient}
+	}
+	log.Println("Warning: MongoDB deployment does not support transactions; falling back to compensating writes")
+	return NewInMemoryUnitOfWork()
+}
+
+// NewMongoUnitOfWork creates a transactional unit of work on the given client
+func NewMongoUnitOfWork(client *mongo.Client) *MongoUnitOfWork {
+	return &MongoUnitOfWork{client: client}
+}
+
+// supportsTransactions reports whether the server is a replica set member or mongos
+func supportsTransactions(client *mongo.Client) bool {
+	if client == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var hello bson.M
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false
+	}
+
+	if _, ok := hello["setName"]; ok {
+		return true
+	}
+	return hello["msg"] == "isdbgrid"
+}
+
+// Do runs fn in a transaction. The driver retries fn on transient transaction
+// errors, so fn must be safe to run more than once.
+func (u *MongoUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := u.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// InMemoryUnitOfWork runs units of work without a database transaction. When fn
+// fails, undo steps registered with OnRollback run in reverse order. It is used in
+// tests and as the fallback for MongoDB deployments without transaction support.
+type InMemoryUnitOfWork struct {
+	mu        sync.Mutex
+	Commits   int
+	Rollbacks int
+}
+
+// NewInMemoryUnitOfWork creates an in-memory unit of work
+func NewInMemoryUnitOfWork() *InMemoryUnitOfWork {
+	return &InMemoryUnitOfWork{}
+}
+
+// Do runs fn, undoing its registered steps if it fails
+func (u *InMemoryUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	rollback := &rollbackLog{}
+
+	if err := fn(context.WithValue(ctx, rollbackKey{}, rollback)); err != nil {
+		for i := len(rollback.steps) - 1; i >= 0; i-- {
+			rollback.steps[i]()
+		}
+		u.mu.Lock()
+		u.Rollbacks++
+		u.mu.Unlock()
+		return err
+	}
+
+	u.mu.Lock()
+	u.Commits++
+	u.mu.Unlock()
+	return nil
+}
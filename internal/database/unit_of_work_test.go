@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestInMemoryUnitOfWork_CommitSkipsUndo(t *testing.T) {
+	uow := NewInMemoryUnitOfWork()
+	undone := false
+
+	err := uow.Do(context.Background(), func(ctx context.Context) error {
+		OnRollback(ctx, func() { undone = true })
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	if undone {
+		t.Fatalf("undo step ran on commit")
+	}
+	if uow.Commits != 1 || uow.Rollbacks != 0 {
+		t.Fatalf("unexpected counts: commits=%d rollbacks=%d", uow.Commits, uow.Rollbacks)
+	}
+}
+
+func TestInMemoryUnitOfWork_RollbackRunsUndoInReverse(t *testing.T) {
+	uow := NewInMemoryUnitOfWork()
+	failure := errors.New("invoice insert failed")
+	var order []string
+
+	err := uow.Do(context.Background(), func(ctx context.Context) error {
+		OnRollback(ctx, func() { order = append(order, "stock") })
+		OnRollback(ctx, func() { order = append(order, "coupon") })
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected fn error, got %v", err)
+	}
+	if len(order) != 2 || order[0] != "coupon" || order[1] != "stock" {
+		t.Fatalf("undo steps ran in wrong order: %v", order)
+	}
+	if uow.Rollbacks != 1 || uow.Commits != 0 {
+		t.Fatalf("unexpected counts: commits=%d rollbacks=%d", uow.Commits, uow.Rollbacks)
+	}
+}
+
+func TestOnRollback_OutsideUnitIsNoop(t *testing.T) {
+	// Must not panic when no unit of work is in progress
+	OnRollback(context.Background(), func() { t.Fatalf("undo step should never run") })
+}
+
+func TestMongoUnitOfWork_RollsBackOrderAndInvoice(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping unit of work tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	if !supportsTransactions(MongoClient) {
+		t.Skip("MongoDB deployment does not support transactions")
+	}
+
+	ctx := context.Background()
+	orderRepo := NewOrderRepository()
+	invoiceRepo := NewInvoiceRepository()
+	uow := NewMongoUnitOfWork(MongoClient)
+
+	failure := errors.New("abort")
+	err := uow.Do(ctx, func(ctx context.Context) error {
+		if err := orderRepo.CreateOrder(ctx, &models.Order{ID: "uow-order-1"}); err != nil {
+			return err
+		}
+		if err := invoiceRepo.CreateInvoice(ctx, &models.Invoice{ID: "uow-invoice-1", OrderID: "uow-order-1"}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected abort error, got %v", err)
+	}
+
+	if _, err := orderRepo.GetOrderByID(ctx, "uow-order-1"); err == nil {
+		t.Fatalf("order persisted despite rollback")
+	}
+	if _, err := invoiceRepo.GetInvoiceByID(ctx, "uow-invoice-1"); err == nil {
+		t.Fatalf("invoice persisted despite rollback")
+	}
+
+	// A successful unit commits both documents
+	err = uow.Do(ctx, func(ctx context.Context) error {
+		if err := orderRepo.CreateOrder(ctx, &models.Order{ID: "uow-order-2"}); err != nil {
+			return err
+		}
+		return invoiceRepo.CreateInvoice(ctx, &models.Invoice{ID: "uow-invoice-2", OrderID: "uow-order-2"})
+	})
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	defer orderRepo.collection.DeleteOne(ctx, bson.M{"_id": "uow-order-2"})
+	defer invoiceRepo.collection.DeleteOne(ctx, bson.M{"_id": "uow-invoice-2"})
+
+	if _, err := orderRepo.GetOrderByID(ctx, "uow-order-2"); err != nil {
+		t.Fatalf("order not committed: %v", err)
+	}
+	if _, err := invoiceRepo.GetInvoiceByID(ctx, "uow-invoice-2"); err != nil {
+		t.Fatalf("invoice not committed: %v", err)
+	}
+}
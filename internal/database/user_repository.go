@@ -3,11 +3,13 @@ package database
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -85,6 +87,401 @@ func (ur *UserRepository) UpdateUser(ctx context.Context, userID string, user *m
 	return nil
 }
 
+// UpdatePassword replaces a user's password hash
+func (ur *UserRepository) UpdatePassword(ctx context.Context, userID string, passwordHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{"password": passwordHash, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// MarkEmailVerified records that the user proved they control email. It returns
+// false if the user's address has changed since the verification was sent.
+func (ur *UserRepository) MarkEmailVerified(ctx context.Context, userID string, email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": userID, "email": email}, bson.M{
+		"$set": bson.M{"emailVerified": true, "emailVerifiedAt": now, "updatedAt": now},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to mark email verified: %w", err)
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// SetPendingTwoFactor stores a newly provisioned TOTP secret until the user confirms
+// it with a code, replacing any earlier unconfirmed one
+func (ur *UserRepository) SetPendingTwoFactor(ctx context.Context, userID string, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{"twoFactor.pendingSecret": secret, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store two-factor secret: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// EnableTwoFactor turns two-factor authentication on with the pending secret. It
+// returns false if the pending secret has been replaced in the meantime.
+func (ur *UserRepository) EnableTwoFactor(ctx context.Context, userID string, secret string, recoveryCodeHashes []string, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": userID, "twoFactor.pendingSecret": secret}, bson.M{
+		"$set": bson.M{
+			"twoFactorEnabled": true,
+			"twoFactor": models.TwoFactor{
+				Secret:             secret,
+				LastStep:           step,
+				RecoveryCodeHashes: recoveryCodeHashes,
+				EnabledAt:          &now,
+			},
+			"updatedAt": now,
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off and forgets the secret and
+// recovery codes
+func (ur *UserRepository) DisableTwoFactor(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set":   bson.M{"twoFactorEnabled": false, "updatedAt": time.Now()},
+		"$unset": bson.M{"twoFactor": ""},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// UseTwoFactorStep records the time step of an accepted code. It returns false if
+// a code for that step or a later one was already accepted, so a code intercepted
+// or replayed in the same window is refused.
+func (ur *UserRepository) UseTwoFactorStep(ctx context.Context, userID string, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx,
+		bson.M{"_id": userID, "twoFactorEnabled": true, "$or": bson.A{
+			bson.M{"twoFactor.lastStep": bson.M{"$lt": step}},
+			bson.M{"twoFactor.lastStep": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"twoFactor.lastStep": step}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record two-factor code: %w", err)
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// UseRecoveryCode removes a recovery code by its hash. It returns false if the
+// user has no such code, including one already used.
+func (ur *UserRepository) UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx,
+		bson.M{"_id": userID, "twoFactorEnabled": true, "twoFactor.recoveryCodeHashes": codeHash},
+		bson.M{"$pull": bson.M{"twoFactor.recoveryCodeHashes": codeHash}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// ReplaceRecoveryCodes swaps a user's recovery codes for new ones
+func (ur *UserRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": userID, "twoFactorEnabled": true}, bson.M{
+		"$set": bson.M{"twoFactor.recoveryCodeHashes": recoveryCodeHashes, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace recovery codes: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// SetUserRoles replaces a user's roles. Role is kept as the first of them for
+// clients that show a single role.
+func (ur *UserRepository) SetUserRoles(ctx context.Context, userID string, roles []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{"roles": roles, "role": roles[0], "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set user roles: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// CountUsersWithRole counts the users who have a role, including users created
+// before roles could be combined
+func (ur *UserRepository) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := ur.collection.CountDocuments(ctx, roleFilter(role))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users with role: %w", err)
+	}
+
+	return count, nil
+}
+
+// CountActiveUsersWithRole counts the users who have a role and are not suspended
+func (ur *UserRepository) CountActiveUsersWithRole(ctx context.Context, role string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := roleFilter(role)
+	filter["suspension"] = bson.M{"$exists": false}
+	count, err := ur.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count active users with role: %w", err)
+	}
+
+	return count, nil
+}
+
+// userSearchFilter builds the MongoDB filter for the admin user search
+func userSearchFilter(query models.UserSearchQuery) bson.M {
+	var clauses bson.A
+	if query.Q != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(query.Q), "$options": "i"}
+		clauses = append(clauses, bson.M{"$or": bson.A{
+			bson.M{"email": pattern},
+			bson.M{"firstName": pattern},
+			bson.M{"lastName": pattern},
+		}})
+	}
+	if query.Role != "" {
+		clauses = append(clauses, roleFilter(query.Role))
+	}
+
+	filter := bson.M{}
+	switch query.Status {
+	case models.UserStatusSuspended:
+		filter["suspension"] = bson.M{"$exists": true}
+	case models.UserStatusActive:
+		filter["suspension"] = bson.M{"$exists": false}
+	}
+	if len(clauses) > 0 {
+		filter["$and"] = clauses
+	}
+	return filter
+}
+
+// roleFilter matches users who have a role, including users created before roles
+// could be combined
+func roleFilter(role string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"roles": role},
+		bson.M{"role": role, "roles": bson.M{"$exists": false}},
+	}}
+}
+
+// SearchUsers retrieves users matching the admin search filters, newest first
+func (ur *UserRepository) SearchUsers(ctx context.Context, query models.UserSearchQuery, page, limit int) ([]*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	skip := int64((page - 1) * limit)
+	opts := options.Find().SetSkip(skip).SetLimit(int64(limit)).SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := ur.collection.Find(ctx, userSearchFilter(query), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var users []*models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %w", err)
+	}
+	return users, nil
+}
+
+// CountUsers returns the number of users matching the admin search filters
+func (ur *UserRepository) CountUsers(ctx context.Context, query models.UserSearchQuery) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := ur.collection.CountDocuments(ctx, userSearchFilter(query))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// SuspendUser suspends an account. It returns false if there is no such user or
+// the account is already suspended.
+func (ur *UserRepository) SuspendUser(ctx context.Context, userID string, suspension *models.Suspension) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx,
+		bson.M{"_id": userID, "suspension": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"suspension": suspension, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to suspend user: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ReactivateUser lifts a suspension. It returns false if there is no such user or
+// the account is not suspended.
+func (ur *UserRepository) ReactivateUser(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx,
+		bson.M{"_id": userID, "suspension": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"suspension": ""}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to reactivate user: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// IsUserSuspended reports whether a user's account is suspended; unknown users
+// are not
+func (ur *UserRepository) IsUserSuspended(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := ur.collection.CountDocuments(ctx, bson.M{"_id": userID, "suspension": bson.M{"$exists": true}})
+	if err != nil {
+		return false, fmt.Errorf("failed to check user suspension: %w", err)
+	}
+	return count > 0, nil
+}
+
+// UpdateProfile sets the given profile fields, e.g. "firstName" or "phone". Fields
+// set to nil are removed.
+func (ur *UserRepository) UpdateProfile(ctx context.Context, userID string, updates map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	set := bson.M{"updatedAt": time.Now()}
+	unset := bson.M{}
+	for field, value := range updates {
+		if value == nil {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": userID, "deletedAt": bson.M{"$exists": false}}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// AnonymiseUser removes a user's personal data and marks the account deleted. The
+// document is kept so the user's orders and invoices still have a customer. It
+// returns false if there is no such user or the account is already deleted.
+func (ur *UserRepository) AnonymiseUser(ctx context.Context, userID string, deletedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := ur.collection.UpdateOne(ctx,
+		bson.M{"_id": userID, "deletedAt": bson.M{"$exists": false}},
+		bson.M{
+			"$set": bson.M{
+				// Unique and unroutable, so the address can be registered again
+				"email":            "deleted-" + userID + "@deleted.invalid",
+				"password":         "",
+				"firstName":        "Deleted",
+				"lastName":         "User",
+				"role":             models.RoleUser,
+				"roles":            []string{models.RoleUser},
+				"emailVerified":    false,
+				"twoFactorEnabled": false,
+				"deletedAt":        deletedAt,
+				"updatedAt":        deletedAt,
+			},
+			"$unset": bson.M{"phone": "", "deliveryDefaults": "", "emailVerifiedAt": "", "twoFactor": ""},
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to anonymise user: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
 // DeleteUser deletes a user by ID
 func (ur *UserRepository) DeleteUser(ctx context.Context, userID string) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -140,3 +140,128 @@ func TestUserRepository_FindByID_Update_Delete(t *testing.T) {
 	// cleanup
 	repo.collection.DeleteMany(ctx, map[string]interface{}{"email": testEmail})
 }
+
+func TestUserRepository_SearchAndSuspend(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping user repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewUserRepository()
+	ctx := context.Background()
+	ids := []string{"test-search-1", "test-search-2"}
+	repo.collection.DeleteMany(ctx, map[string]interface{}{"_id": map[string]interface{}{"$in": ids}})
+
+	for i, u := range []*models.User{
+		{ID: ids[0], Email: "wanjiru.search@example.com", FirstName: "Wanjiru", Role: "user", Roles: []string{"user"}},
+		{ID: ids[1], Email: "otieno.search@example.com", FirstName: "Otieno", Role: "finance"},
+	} {
+		u.CreatedAt = time.Now().Add(time.Duration(i) * time.Second)
+		u.UpdatedAt = u.CreatedAt
+		if err := repo.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser error: %v", err)
+		}
+	}
+
+	// Search is case-insensitive and treats the query as text, not a pattern
+	users, err := repo.SearchUsers(ctx, models.UserSearchQuery{Q: "WANJIRU.search"}, 1, 10)
+	if err != nil || len(users) != 1 || users[0].ID != ids[0] {
+		t.Fatalf("expected the first user, got %d (err=%v)", len(users), err)
+	}
+	if users, _ := repo.SearchUsers(ctx, models.UserSearchQuery{Q: ".*"}, 1, 10); len(users) != 0 {
+		t.Fatalf("expected no users for a regex, got %d", len(users))
+	}
+
+	// A legacy user is found by their single role
+	count, err := repo.CountUsers(ctx, models.UserSearchQuery{Q: ".search@", Role: "finance"})
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 finance user, got %d (err=%v)", count, err)
+	}
+
+	suspension := &models.Suspension{Reason: "testing", SuspendedBy: "admin-1", SuspendedAt: time.Now()}
+	if ok, err := repo.SuspendUser(ctx, ids[1], suspension); err != nil || !ok {
+		t.Fatalf("SuspendUser: ok=%v err=%v", ok, err)
+	}
+	if ok, _ := repo.SuspendUser(ctx, ids[1], suspension); ok {
+		t.Fatalf("expected a suspended user not to be suspended again")
+	}
+	if suspended, err := repo.IsUserSuspended(ctx, ids[1]); err != nil || !suspended {
+		t.Fatalf("IsUserSuspended: %v err=%v", suspended, err)
+	}
+	count, _ = repo.CountUsers(ctx, models.UserSearchQuery{Q: ".search@", Status: models.UserStatusSuspended})
+	if count != 1 {
+		t.Fatalf("expected 1 suspended user, got %d", count)
+	}
+
+	if ok, err := repo.ReactivateUser(ctx, ids[1]); err != nil || !ok {
+		t.Fatalf("ReactivateUser: ok=%v err=%v", ok, err)
+	}
+	if ok, _ := repo.ReactivateUser(ctx, ids[1]); ok {
+		t.Fatalf("expected an active user not to be reactivated")
+	}
+	count, _ = repo.CountUsers(ctx, models.UserSearchQuery{Q: ".search@", Status: models.UserStatusActive})
+	if count != 2 {
+		t.Fatalf("expected 2 active users, got %d", count)
+	}
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{"_id": map[string]interface{}{"$in": ids}})
+}
+
+func TestUserRepository_UpdateProfileAndAnonymise(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; skipping user repository tests")
+	}
+
+	if err := InitMongo(uri); err != nil {
+		t.Fatalf("InitMongo error: %v", err)
+	}
+	defer DisconnectMongo()
+
+	repo := NewUserRepository()
+	ctx := context.Background()
+	repo.collection.DeleteMany(ctx, map[string]interface{}{"_id": "test-profile-1"})
+
+	u := &models.User{ID: "test-profile-1", Email: "profile@example.com", Password: "hashed", FirstName: "Jane", Role: "user", Phone: "254700000000", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := repo.CreateUser(ctx, u); err != nil {
+		t.Fatalf("CreateUser error: %v", err)
+	}
+
+	// nil removes a field
+	err := repo.UpdateProfile(ctx, u.ID, map[string]interface{}{
+		"lastName":         "Doe",
+		"phone":            nil,
+		"deliveryDefaults": &models.DeliveryDefaults{LocationDetails: "Gate B"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateProfile error: %v", err)
+	}
+	found, _ := repo.FindUserByID(ctx, u.ID)
+	if found.LastName != "Doe" || found.Phone != "" || found.DeliveryDefaults == nil {
+		t.Fatalf("unexpected profile %+v", found)
+	}
+
+	if ok, err := repo.AnonymiseUser(ctx, u.ID, time.Now()); err != nil || !ok {
+		t.Fatalf("AnonymiseUser: ok=%v err=%v", ok, err)
+	}
+	if ok, _ := repo.AnonymiseUser(ctx, u.ID, time.Now()); ok {
+		t.Fatalf("expected a deleted user not to be anonymised again")
+	}
+	found, _ = repo.FindUserByID(ctx, u.ID)
+	if !found.Deleted() || found.Email == "profile@example.com" || found.Password != "" || found.DeliveryDefaults != nil {
+		t.Fatalf("expected personal data removed, got %+v", found)
+	}
+	if exists, _ := repo.UserExists(ctx, "profile@example.com"); exists {
+		t.Fatalf("expected the email to be free again")
+	}
+	if err := repo.UpdateProfile(ctx, u.ID, map[string]interface{}{"firstName": "Jane"}); err == nil {
+		t.Fatalf("expected a deleted profile not to be updated")
+	}
+
+	repo.collection.DeleteMany(ctx, map[string]interface{}{"_id": "test-profile-1"})
+}
@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/mail"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// accountConfig controls password reset and email verification
+type accountConfig struct {
+	// linkBaseURL is the storefront the emailed links open; it calls the API with the token
+	linkBaseURL     string
+	resetTTL        time.Duration
+	verificationTTL time.Duration
+	// requireVerifiedEmail refuses checkout until the user has verified their email
+	requireVerifiedEmail bool
+}
+
+var accountSettings = accountConfig{
+	linkBaseURL:     "http://localhost:3000",
+	resetTTL:        time.Hour,
+	verificationTTL: 48 * time.Hour,
+}
+
+// mailSender delivers account emails; messages go to the log until InitAccountEmail
+// configures a sender
+var mailSender mail.Sender = &mail.Sink{}
+
+// forgotPasswordResponse is sent whether or not the email belongs to an account,
+// so the endpoint cannot be used to find out who has one
+const forgotPasswordResponse = "if an account exists for that email, a password reset link has been sent"
+
+// InitAccountEmail configures account emails from the environment:
+//
+//   - MAIL_DRIVER: "smtp", "file" (MAIL_FILE_DIR) or "log" (default)
+//   - MAIL_FROM, SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD
+//   - FRONTEND_URL: base of the links in emails
+//   - PASSWORD_RESET_TTL (default 1h), EMAIL_VERIFICATION_TTL (default 48h)
+//   - REQUIRE_VERIFIED_EMAIL: refuse checkout for unverified accounts
+//
+// It returns the name of the mail driver in use.
+func InitAccountEmail() (string, error) {
+	config := accountSettings
+	if base := os.Getenv("FRONTEND_URL"); base != "" {
+		parsed, err := url.Parse(base)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return "", fmt.Errorf("invalid FRONTEND_URL %q", base)
+		}
+		config.linkBaseURL = strings.TrimRight(base, "/")
+	}
+	for _, setting := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"PASSWORD_RESET_TTL", &config.resetTTL},
+		{"EMAIL_VERIFICATION_TTL", &config.verificationTTL},
+	} {
+		if raw := os.Getenv(setting.name); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d <= 0 {
+				return "", fmt.Errorf("invalid %s %q", setting.name, raw)
+			}
+			*setting.value = d
+		}
+	}
+	config.requireVerifiedEmail = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+
+	driver := os.Getenv("MAIL_DRIVER")
+	var sender mail.Sender
+	switch driver {
+	case "", "log":
+		driver = "log"
+		sender = &mail.Sink{From: from}
+	case "file":
+		dir := os.Getenv("MAIL_FILE_DIR")
+		if dir == "" {
+			return "", fmt.Errorf("MAIL_FILE_DIR is required for the file mail driver")
+		}
+		sender = &mail.Sink{Dir: dir, From: from}
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" || os.Getenv("MAIL_FROM") == "" {
+			return "", fmt.Errorf("SMTP_HOST and MAIL_FROM are required for the smtp mail driver")
+		}
+		port := 0
+		if raw := os.Getenv("SMTP_PORT"); raw != "" {
+			p, err := strconv.Atoi(raw)
+			if err != nil || p <= 0 {
+				return "", fmt.Errorf("invalid SMTP_PORT %q", raw)
+			}
+			port = p
+		}
+		sender = mail.NewSMTPSender(mail.SMTPConfig{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		})
+	default:
+		return "", fmt.Errorf("unknown MAIL_DRIVER %q; use smtp, file or log", driver)
+	}
+
+	accountSettings = config
+	mailSender = sender
+	return driver, nil
+}
+
+// ForgotPassword emails a password reset link. It answers the same way whether or
+// not the email has an account.
+func ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := NewUserRepository.FindUserByEmail(ctx, normalizeLoginEmail(req.Email))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusAccepted, gin.H{"message": forgotPasswordResponse})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+		return
+	}
+
+	if err := sendPasswordReset(ctx, user); err != nil {
+		// Reporting the failure would reveal that the account exists
+		log.Printf("Failed to send password reset to user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": forgotPasswordResponse})
+}
+
+// ResetPassword sets a new password with a token from a reset email and signs the
+// user out everywhere
+func ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	token, ok := redeemAccountToken(c, req.Token, models.AccountTokenPasswordReset)
+	if !ok {
+		return
+	}
+
+	user, err := NewUserRepository.FindUserByID(ctx, token.UserID)
+	if err != nil || user.Email != token.Email {
+		// The account was deleted or moved to another address since the email was sent
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process password"})
+		return
+	}
+	if err := NewUserRepository.UpdatePassword(ctx, user.ID, hashedPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	// Whoever knew the old password may still be signed in
+	if _, err := revokeUserSessions(ctx, user.ID, "", models.SessionRevokedPasswordReset); err != nil {
+		log.Printf("Failed to revoke sessions of user %s after password reset: %v", user.ID, err)
+	}
+	if err := NewAccountTokenRepository.InvalidateAccountTokens(ctx, user.ID, models.AccountTokenPasswordReset); err != nil {
+		log.Printf("Failed to invalidate password reset tokens of user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password has been reset; sign in with the new password"})
+}
+
+// VerifyEmail confirms a user's email address with a token from a verification email
+func VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, ok := redeemAccountToken(c, req.Token, models.AccountTokenEmailVerification)
+	if !ok {
+		return
+	}
+
+	verified, err := NewUserRepository.MarkEmailVerified(c.Request.Context(), token.UserID, token.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+	if !verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the account's email address has changed since this link was sent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+// ResendEmailVerification emails the current user a new verification link
+func ResendEmailVerification(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := NewUserRepository.FindUserByID(ctx, userID.(string))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+		return
+	}
+
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "email is already verified"})
+		return
+	}
+
+	if err := sendEmailVerification(ctx, user); err != nil {
+		log.Printf("Failed to send email verification to user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+}
+
+// RequireVerifiedEmail refuses the request until the user has verified their email,
+// when REQUIRE_VERIFIED_EMAIL is set
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !accountSettings.requireVerifiedEmail {
+			c.Next()
+			return
+		}
+
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+			return
+		}
+
+		user, err := NewUserRepository.FindUserByID(c.Request.Context(), userID.(string))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+			return
+		}
+		if !user.EmailVerified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "verify your email address before checking out", "code": "email_not_verified"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func sendPasswordReset(ctx context.Context, user *models.User) error {
+	token, expiresAt, err := issueAccountToken(ctx, user, models.AccountTokenPasswordReset, accountSettings.resetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := accountSettings.linkBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	return mailSender.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. If it was you, choose a new password here:\n\n%s\n\n"+
+			"The link works once and expires at %s. If you did not ask for this, ignore this email; your password has not changed.\n",
+			user.FirstName, link, expiresAt.UTC().Format("15:04 MST on 2 Jan 2006")),
+	})
+}
+
+func sendEmailVerification(ctx context.Context, user *models.User) error {
+	token, expiresAt, err := issueAccountToken(ctx, user, models.AccountTokenEmailVerification, accountSettings.verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := accountSettings.linkBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return mailSender.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm this is your email address by opening:\n\n%s\n\nThe link expires at %s.\n",
+			user.FirstName, link, expiresAt.UTC().Format("15:04 MST on 2 Jan 2006")),
+	})
+}
+
+// issueAccountToken creates a one-time token for the user's current email address,
+// replacing any earlier token for the same purpose. Only its hash is stored.
+func issueAccountToken(ctx context.Context, user *models.User, purpose string, ttl time.Duration) (string, time.Time, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	tokenRepo := NewAccountTokenRepository
+	if err := tokenRepo.InvalidateAccountTokens(ctx, user.ID, purpose); err != nil {
+		return "", time.Time{}, err
+	}
+
+	record := &models.AccountToken{
+		ID:        hashAccountToken(token),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := tokenRepo.CreateAccountToken(ctx, record); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, record.ExpiresAt, nil
+}
+
+// redeemAccountToken uses up a token, answering 400 if it is unknown, for another
+// purpose, expired or already used
+func redeemAccountToken(c *gin.Context, token, purpose string) (*models.AccountToken, bool) {
+	ctx := c.Request.Context()
+	tokenRepo := NewAccountTokenRepository
+	hash := hashAccountToken(token)
+
+	record, err := tokenRepo.GetAccountToken(ctx, hash)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check token"})
+		return nil, false
+	}
+	if err == mongo.ErrNoDocuments || record.Purpose != purpose || !record.Usable() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return nil, false
+	}
+
+	redeemed, err := tokenRepo.MarkAccountTokenUsed(ctx, hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check token"})
+		return nil, false
+	}
+	if !redeemed {
+		// Used by a concurrent request
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return nil, false
+	}
+	return record, true
+}
+
+func hashAccountToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
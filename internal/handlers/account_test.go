@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/mail"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// recordingSender keeps sent messages instead of delivering them
+type recordingSender struct {
+	messages []mail.Message
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg mail.Message) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// useAccountEmail swaps in an account token mock and a recording mail sender for one test
+func useAccountEmail(t *testing.T) (*MockAccountTokenRepository, *recordingSender) {
+	oldTokens, oldSender, oldSettings := NewAccountTokenRepository, mailSender, accountSettings
+	tokens, sender := new(MockAccountTokenRepository), &recordingSender{}
+	NewAccountTokenRepository, mailSender = tokens, sender
+	t.Cleanup(func() { NewAccountTokenRepository, mailSender, accountSettings = oldTokens, oldSender, oldSettings })
+	return tokens, sender
+}
+
+func useUserRepo(t *testing.T) *MockUserRepository {
+	old := NewUserRepository
+	users := new(MockUserRepository)
+	NewUserRepository = users
+	t.Cleanup(func() { NewUserRepository = old })
+	return users
+}
+
+// tokenFromLink extracts the token from the link in an account email
+func tokenFromLink(t *testing.T, body string) string {
+	start := strings.Index(body, "http")
+	if start < 0 {
+		t.Fatalf("no link in email: %q", body)
+	}
+	link, err := url.Parse(strings.Fields(body[start:])[0])
+	assert.NoError(t, err)
+	return link.Query().Get("token")
+}
+
+func postJSON(handler gin.HandlerFunc, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", path, bytes.NewBuffer(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+func TestForgotPassword_UnknownEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, sender := useAccountEmail(t)
+	users := useUserRepo(t)
+	users.On("FindUserByEmail", mock.Anything, "nobody@example.com").Return(nil, mongo.ErrNoDocuments)
+
+	w := postJSON(ForgotPassword, "/auth/password/forgot", models.ForgotPasswordRequest{Email: "nobody@example.com"})
+
+	// Same answer as for a real account
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), forgotPasswordResponse)
+	assert.Empty(t, sender.messages)
+}
+
+func TestForgotPassword_SendsResetLink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens, sender := useAccountEmail(t)
+	accountSettings.linkBaseURL = "https://shop.example"
+	users := useUserRepo(t)
+
+	user := &models.User{ID: "user-1", Email: "jane@example.com", FirstName: "Jane"}
+	users.On("FindUserByEmail", mock.Anything, "jane@example.com").Return(user, nil)
+	tokens.On("InvalidateAccountTokens", mock.Anything, "user-1", models.AccountTokenPasswordReset).Return(nil)
+	var stored *models.AccountToken
+	tokens.On("CreateAccountToken", mock.Anything, mock.MatchedBy(func(tok *models.AccountToken) bool {
+		stored = tok
+		return tok.UserID == "user-1" && tok.Purpose == models.AccountTokenPasswordReset && tok.Email == "jane@example.com"
+	})).Return(nil)
+
+	w := postJSON(ForgotPassword, "/auth/password/forgot", models.ForgotPasswordRequest{Email: "jane@example.com"})
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Len(t, sender.messages, 1)
+	assert.Equal(t, "jane@example.com", sender.messages[0].To)
+	assert.Contains(t, sender.messages[0].Body, "https://shop.example/reset-password?token=")
+
+	// Only the hash of the emailed token is stored
+	token := tokenFromLink(t, sender.messages[0].Body)
+	assert.NotEqual(t, token, stored.ID)
+	assert.Equal(t, hashAccountToken(token), stored.ID)
+	assert.WithinDuration(t, time.Now().Add(accountSettings.resetTTL), stored.ExpiresAt, time.Minute)
+	tokens.AssertExpectations(t)
+}
+
+func resetToken(purpose string, expiresAt time.Time) (string, *models.AccountToken) {
+	token := "reset-token-value"
+	return token, &models.AccountToken{ID: hashAccountToken(token), UserID: "user-1", Purpose: purpose, Email: "jane@example.com", ExpiresAt: expiresAt}
+}
+
+func TestResetPassword_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens, _ := useAccountEmail(t)
+	users := useUserRepo(t)
+	sessions, blacklist := useSessionRepos(t)
+
+	token, record := resetToken(models.AccountTokenPasswordReset, time.Now().Add(time.Hour))
+	tokens.On("GetAccountToken", mock.Anything, record.ID).Return(record, nil)
+	tokens.On("MarkAccountTokenUsed", mock.Anything, record.ID).Return(true, nil)
+	tokens.On("InvalidateAccountTokens", mock.Anything, "user-1", models.AccountTokenPasswordReset).Return(nil)
+
+	users.On("FindUserByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1", Email: "jane@example.com"}, nil)
+	var newHash string
+	users.On("UpdatePassword", mock.Anything, "user-1", mock.MatchedBy(func(hash string) bool {
+		newHash = hash
+		return true
+	})).Return(nil)
+
+	// Every session is signed out, including whoever knew the old password
+	other := &models.Session{ID: "session-1", UserID: "user-1", AccessTokenID: "jti-1", AccessExpiresAt: time.Now().Add(5 * time.Minute)}
+	sessions.On("ListActiveSessions", mock.Anything, "user-1").Return([]*models.Session{other}, nil)
+	sessions.On("RevokeSession", mock.Anything, "session-1", models.SessionRevokedPasswordReset).Return(true, nil)
+	sessions.On("GetSessionByID", mock.Anything, "session-1").Return(other, nil)
+	blacklist.On("BlacklistToken", mock.Anything, "jti-1", other.AccessExpiresAt).Return(nil)
+
+	w := postJSON(ResetPassword, "/auth/password/reset", models.ResetPasswordRequest{Token: token, Password: "new-password"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, auth.VerifyPassword(newHash, "new-password"))
+	tokens.AssertExpectations(t)
+	users.AssertExpectations(t)
+	sessions.AssertExpectations(t)
+	blacklist.AssertExpectations(t)
+}
+
+func TestResetPassword_RejectsUnusableTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := map[string]*models.AccountToken{
+		"expired":            {Purpose: models.AccountTokenPasswordReset, ExpiresAt: time.Now().Add(-time.Minute)},
+		"used":               {Purpose: models.AccountTokenPasswordReset, ExpiresAt: time.Now().Add(time.Hour), UsedAt: &time.Time{}},
+		"email verification": {Purpose: models.AccountTokenEmailVerification, ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for name, record := range cases {
+		t.Run(name, func(t *testing.T) {
+			tokens, _ := useAccountEmail(t)
+			users := useUserRepo(t)
+			tokens.On("GetAccountToken", mock.Anything, hashAccountToken("some-token")).Return(record, nil)
+
+			w := postJSON(ResetPassword, "/auth/password/reset", models.ResetPasswordRequest{Token: "some-token", Password: "new-password"})
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			tokens.AssertNotCalled(t, "MarkAccountTokenUsed", mock.Anything, mock.Anything)
+			users.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestResetPassword_TokenRedeemedConcurrently(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens, _ := useAccountEmail(t)
+	users := useUserRepo(t)
+
+	token, record := resetToken(models.AccountTokenPasswordReset, time.Now().Add(time.Hour))
+	tokens.On("GetAccountToken", mock.Anything, record.ID).Return(record, nil)
+	tokens.On("MarkAccountTokenUsed", mock.Anything, record.ID).Return(false, nil)
+
+	w := postJSON(ResetPassword, "/auth/password/reset", models.ResetPasswordRequest{Token: token, Password: "new-password"})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	users.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestResetPassword_UnknownToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens, _ := useAccountEmail(t)
+	tokens.On("GetAccountToken", mock.Anything, mock.Anything).Return(nil, mongo.ErrNoDocuments)
+
+	w := postJSON(ResetPassword, "/auth/password/reset", models.ResetPasswordRequest{Token: "guess", Password: "new-password"})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestVerifyEmail_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens, _ := useAccountEmail(t)
+	users := useUserRepo(t)
+
+	token, record := resetToken(models.AccountTokenEmailVerification, time.Now().Add(time.Hour))
+	tokens.On("GetAccountToken", mock.Anything, record.ID).Return(record, nil)
+	tokens.On("MarkAccountTokenUsed", mock.Anything, record.ID).Return(true, nil)
+	users.On("MarkEmailVerified", mock.Anything, "user-1", "jane@example.com").Return(true, nil)
+
+	w := postJSON(VerifyEmail, "/auth/email/verify", models.VerifyEmailRequest{Token: token})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	users.AssertExpectations(t)
+}
+
+func TestVerifyEmail_AddressChanged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens, _ := useAccountEmail(t)
+	users := useUserRepo(t)
+
+	token, record := resetToken(models.AccountTokenEmailVerification, time.Now().Add(time.Hour))
+	tokens.On("GetAccountToken", mock.Anything, record.ID).Return(record, nil)
+	tokens.On("MarkAccountTokenUsed", mock.Anything, record.ID).Return(true, nil)
+	users.On("MarkEmailVerified", mock.Anything, "user-1", "jane@example.com").Return(false, nil)
+
+	w := postJSON(VerifyEmail, "/auth/email/verify", models.VerifyEmailRequest{Token: token})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestResendEmailVerification(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, verified := range []bool{false, true} {
+		tokens, sender := useAccountEmail(t)
+		users := useUserRepo(t)
+		users.On("FindUserByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1", Email: "jane@example.com", EmailVerified: verified}, nil)
+		tokens.On("InvalidateAccountTokens", mock.Anything, "user-1", models.AccountTokenEmailVerification).Return(nil)
+		tokens.On("CreateAccountToken", mock.Anything, mock.Anything).Return(nil)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/auth/email/verification", nil)
+		c.Set("userID", "user-1")
+
+		ResendEmailVerification(c)
+
+		if verified {
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Empty(t, sender.messages)
+		} else {
+			assert.Equal(t, http.StatusAccepted, w.Code)
+			assert.Len(t, sender.messages, 1)
+			assert.Contains(t, sender.messages[0].Body, "/verify-email?token=")
+		}
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useAccountEmail(t)
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "unverified").Return(&models.User{ID: "unverified"}, nil)
+	users.On("FindUserByID", mock.Anything, "verified").Return(&models.User{ID: "verified", EmailVerified: true}, nil)
+
+	checkout := func(userID string) int {
+		router := gin.New()
+		router.POST("/checkout", func(c *gin.Context) { c.Set("userID", userID) }, RequireVerifiedEmail(), func(c *gin.Context) {
+			c.Status(http.StatusCreated)
+		})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/checkout", nil))
+		return w.Code
+	}
+
+	// Not enforced unless configured
+	assert.Equal(t, http.StatusCreated, checkout("unverified"))
+
+	accountSettings.requireVerifiedEmail = true
+	assert.Equal(t, http.StatusForbidden, checkout("unverified"))
+	assert.Equal(t, http.StatusCreated, checkout("verified"))
+}
+
+func TestInitAccountEmail(t *testing.T) {
+	useAccountEmail(t)
+	for _, name := range []string{"MAIL_DRIVER", "MAIL_FROM", "MAIL_FILE_DIR", "SMTP_HOST", "SMTP_PORT", "FRONTEND_URL", "PASSWORD_RESET_TTL", "EMAIL_VERIFICATION_TTL", "REQUIRE_VERIFIED_EMAIL"} {
+		t.Setenv(name, "")
+	}
+
+	driver, err := InitAccountEmail()
+	assert.NoError(t, err)
+	assert.Equal(t, "log", driver)
+
+	t.Setenv("MAIL_DRIVER", "file")
+	_, err = InitAccountEmail()
+	assert.Error(t, err, "file driver needs a directory")
+
+	t.Setenv("MAIL_DRIVER", "smtp")
+	_, err = InitAccountEmail()
+	assert.Error(t, err, "smtp driver needs a host")
+
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("MAIL_FROM", "no-reply@example.com")
+	t.Setenv("FRONTEND_URL", "https://shop.example/")
+	t.Setenv("PASSWORD_RESET_TTL", "30m")
+	t.Setenv("REQUIRE_VERIFIED_EMAIL", "true")
+	driver, err = InitAccountEmail()
+	assert.NoError(t, err)
+	assert.Equal(t, "smtp", driver)
+	assert.Equal(t, "https://shop.example", accountSettings.linkBaseURL)
+	assert.Equal(t, 30*time.Minute, accountSettings.resetTTL)
+	assert.True(t, accountSettings.requireVerifiedEmail)
+
+	t.Setenv("MAIL_DRIVER", "pigeon")
+	_, err = InitAccountEmail()
+	assert.Error(t, err)
+}
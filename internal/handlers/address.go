@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxAddresses caps the size of a user's address book
+const maxAddresses = 20
+
+// newAddress builds an address from a request, trimming its text
+func newAddress(id, userID string, req *models.AddressRequest) *models.Address {
+	return &models.Address{
+		ID:           id,
+		UserID:       userID,
+		Label:        strings.TrimSpace(req.Label),
+		County:       strings.TrimSpace(req.County),
+		Town:         strings.TrimSpace(req.Town),
+		Landmark:     strings.TrimSpace(req.Landmark),
+		Details:      strings.TrimSpace(req.Details),
+		Location:     req.Location,
+		ContactName:  strings.TrimSpace(req.ContactName),
+		ContactPhone: strings.TrimSpace(req.ContactPhone),
+	}
+}
+
+// ListAddresses returns the signed-in user's address book, default address first
+func ListAddresses(c *gin.Context) {
+	addresses, err := NewAddressRepository.ListAddresses(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve addresses"})
+		return
+	}
+	if addresses == nil {
+		addresses = []*models.Address{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": addresses})
+}
+
+// CreateAddress adds an address to the signed-in user's address book. The first
+// address becomes the default.
+func CreateAddress(c *gin.Context) {
+	var req models.AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.GetString("userID")
+	addressRepo := NewAddressRepository
+
+	count, err := addressRepo.CountAddresses(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create address"})
+		return
+	}
+	if count >= maxAddresses {
+		c.JSON(http.StatusConflict, gin.H{"error": "address book is full", "limit": maxAddresses})
+		return
+	}
+
+	address := newAddress(uuid.New().String(), userID, &req)
+	if err := addressRepo.CreateAddress(ctx, address); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create address"})
+		return
+	}
+
+	if count == 0 || req.IsDefault {
+		if _, err := addressRepo.SetDefaultAddress(ctx, userID, address.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set default address"})
+			return
+		}
+		address.IsDefault = true
+	}
+
+	c.JSON(http.StatusCreated, address)
+}
+
+// UpdateAddress replaces one of the signed-in user's addresses. Orders already
+// placed keep the address they were placed with.
+func UpdateAddress(c *gin.Context) {
+	var req models.AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.GetString("userID")
+	addressRepo := NewAddressRepository
+
+	address := newAddress(c.Param("id"), userID, &req)
+	updated, err := addressRepo.UpdateAddress(ctx, userID, address)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update address"})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{"error": "address not found"})
+		return
+	}
+
+	if req.IsDefault {
+		if _, err := addressRepo.SetDefaultAddress(ctx, userID, address.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set default address"})
+			return
+		}
+	}
+
+	address, err = addressRepo.GetAddress(ctx, userID, address.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve address"})
+		return
+	}
+	c.JSON(http.StatusOK, address)
+}
+
+// SetDefaultAddress makes one of the signed-in user's addresses their default
+func SetDefaultAddress(c *gin.Context) {
+	updated, err := NewAddressRepository.SetDefaultAddress(c.Request.Context(), c.GetString("userID"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set default address"})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{"error": "address not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "default address updated"})
+}
+
+// DeleteAddress removes one of the signed-in user's addresses. When it was the
+// default, the oldest remaining address takes its place.
+func DeleteAddress(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetString("userID")
+	addressRepo := NewAddressRepository
+
+	address, err := addressRepo.GetAddress(ctx, userID, c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "address not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve address"})
+		return
+	}
+
+	deleted, err := addressRepo.DeleteAddress(ctx, userID, address.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete address"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "address not found"})
+		return
+	}
+
+	if address.IsDefault {
+		remaining, err := addressRepo.ListAddresses(ctx, userID)
+		if err == nil && len(remaining) > 0 {
+			_, err = addressRepo.SetDefaultAddress(ctx, userID, remaining[0].ID)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set default address"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "address deleted"})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func useAddressRepos(t *testing.T) (*MockAddressRepository, *MockDeliveryZoneRepository) {
+	oldAddresses, oldZones := NewAddressRepository, NewDeliveryZoneRepository
+	addresses, zones := new(MockAddressRepository), new(MockDeliveryZoneRepository)
+	NewAddressRepository, NewDeliveryZoneRepository = addresses, zones
+	t.Cleanup(func() { NewAddressRepository, NewDeliveryZoneRepository = oldAddresses, oldZones })
+	return addresses, zones
+}
+
+// asCustomer serves one request to handler, routed by pattern, as user-1
+func asCustomer(method, pattern, path string, handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, pattern, func(c *gin.Context) {
+		c.Set("userID", "user-1")
+	}, handler)
+
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func homeAddress() *models.Address {
+	return &models.Address{
+		ID: "addr-1", UserID: "user-1", Label: "Home", County: "Nairobi", Town: "Kilimani",
+		Landmark: "Yaya Centre", Details: "Apt 4B", ContactPhone: "254712345678", IsDefault: true,
+	}
+}
+
+func TestCreateAddress_FirstBecomesDefault(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	addresses, _ := useAddressRepos(t)
+	addresses.On("CountAddresses", mock.Anything, "user-1").Return(int64(0), nil)
+	addresses.On("CreateAddress", mock.Anything, mock.MatchedBy(func(a *models.Address) bool {
+		return a.UserID == "user-1" && a.Town == "Kilimani" && a.Location.Latitude == -1.29
+	})).Return(nil)
+	addresses.On("SetDefaultAddress", mock.Anything, "user-1", mock.Anything).Return(true, nil)
+
+	w := asCustomer("POST", "/addresses", "/addresses", CreateAddress, models.AddressRequest{
+		County: "Nairobi", Town: " Kilimani ", Landmark: "Yaya Centre", ContactPhone: "254712345678",
+		Location: &models.GeoPoint{Latitude: -1.29, Longitude: 36.79},
+	})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"isDefault":true`)
+	addresses.AssertExpectations(t)
+}
+
+func TestCreateAddress_Invalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	addresses, _ := useAddressRepos(t)
+
+	for _, body := range []interface{}{
+		models.AddressRequest{Town: "Kilimani", ContactPhone: "254712345678"},
+		models.AddressRequest{County: "Nairobi", Town: "Kilimani", ContactPhone: "254712345678", Location: &models.GeoPoint{Latitude: 91}},
+	} {
+		w := asCustomer("POST", "/addresses", "/addresses", CreateAddress, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	// A full address book takes no more
+	addresses.On("CountAddresses", mock.Anything, "user-1").Return(int64(maxAddresses), nil)
+	w := asCustomer("POST", "/addresses", "/addresses", CreateAddress, models.AddressRequest{County: "Nairobi", Town: "Kilimani", ContactPhone: "254712345678"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	addresses.AssertNotCalled(t, "CreateAddress", mock.Anything, mock.Anything)
+}
+
+func TestUpdateAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	addresses, _ := useAddressRepos(t)
+	addresses.On("UpdateAddress", mock.Anything, "user-1", mock.MatchedBy(func(a *models.Address) bool {
+		return a.ID == "addr-1" && a.Details == "Apt 5C"
+	})).Return(true, nil)
+	addresses.On("UpdateAddress", mock.Anything, "user-1", mock.Anything).Return(false, nil)
+	addresses.On("GetAddress", mock.Anything, "user-1", "addr-1").Return(homeAddress(), nil)
+
+	body := models.AddressRequest{County: "Nairobi", Town: "Kilimani", Details: "Apt 5C", ContactPhone: "254712345678"}
+	w := asCustomer("PUT", "/addresses/:id", "/addresses/addr-1", UpdateAddress, body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Another user's address is not found
+	w = asCustomer("PUT", "/addresses/:id", "/addresses/addr-9", UpdateAddress, body)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	addresses.AssertNotCalled(t, "SetDefaultAddress", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeleteAddress_MovesDefault(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	addresses, _ := useAddressRepos(t)
+	office := &models.Address{ID: "addr-2", UserID: "user-1", County: "Nairobi", Town: "Westlands"}
+	addresses.On("GetAddress", mock.Anything, "user-1", "addr-1").Return(homeAddress(), nil)
+	addresses.On("DeleteAddress", mock.Anything, "user-1", "addr-1").Return(true, nil)
+	addresses.On("ListAddresses", mock.Anything, "user-1").Return([]*models.Address{office}, nil)
+	addresses.On("SetDefaultAddress", mock.Anything, "user-1", "addr-2").Return(true, nil)
+	addresses.On("GetAddress", mock.Anything, "user-1", "addr-9").Return(nil, mongo.ErrNoDocuments)
+
+	w := asCustomer("DELETE", "/addresses/:id", "/addresses/addr-1", DeleteAddress, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = asCustomer("DELETE", "/addresses/:id", "/addresses/addr-9", DeleteAddress, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	addresses.AssertExpectations(t)
+}
+
+func TestSetDefaultAddress_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	addresses, _ := useAddressRepos(t)
+	addresses.On("SetDefaultAddress", mock.Anything, "user-1", "addr-9").Return(false, nil)
+
+	w := asCustomer("POST", "/addresses/:id/default", "/addresses/addr-9/default", SetDefaultAddress, nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -23,6 +24,9 @@ func Register(c *gin.Context) {
 
 	userRepo := NewUserRepository
 
+	// Emails are stored lowercased so Login finds them whatever case is typed
+	req.Email = normalizeLoginEmail(req.Email)
+
 	// Check if user already exists
 	exists, err := userRepo.UserExists(context.Background(), req.Email)
 	if err != nil {
@@ -49,7 +53,8 @@ func Register(c *gin.Context) {
 		Password:  hashedPassword,
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
-		Role:      "user", // Default role
+		Role:      models.RoleUser, // Default role
+		Roles:     []string{models.RoleUser},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -60,22 +65,18 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// Generate token
-	tokenDuration := 24 * time.Hour
-	token, err := auth.GenerateToken(user.ID, user.Email, user.Role, tokenDuration)
+	// A failed email only delays verification; the user can ask for another
+	if err := sendEmailVerification(c.Request.Context(), user); err != nil {
+		log.Printf("Failed to send email verification to user %s: %v", user.ID, err)
+	}
+
+	// Sign the new user in
+	response, err := startSession(c, user, req.Device, auth.MethodPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	expiresAt := time.Now().Add(tokenDuration).Unix()
-
-	response := models.AuthResponse{
-		Token:     token,
-		User:      user,
-		ExpiresAt: expiresAt,
-	}
-
 	c.JSON(http.StatusCreated, response)
 }
 
@@ -87,12 +88,28 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+	email := normalizeLoginEmail(req.Email)
+	ip := c.ClientIP()
+
+	// Refuse attempts while the account or IP is locked out or must wait
+	retryAt, err := loginRetryAt(ctx, email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login attempts"})
+		return
+	}
+	if time.Now().Before(retryAt) {
+		tooManyLoginAttempts(c, retryAt)
+		return
+	}
+
 	userRepo := NewUserRepository
 
-	// Find user by email
-	user, err := userRepo.FindUserByEmail(context.Background(), req.Email)
+	// Find user by email, looked up as normalised so the account and its throttle agree
+	user, err := userRepo.FindUserByEmail(context.Background(), email)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
+			recordLoginFailure(ctx, email, ip, nil)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
 			return
 		}
@@ -102,24 +119,32 @@ func Login(c *gin.Context) {
 
 	// Verify password
 	if err := auth.VerifyPassword(user.Password, req.Password); err != nil {
+		recordLoginFailure(ctx, email, ip, user)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
 		return
 	}
-
-	// Generate token
-	tokenDuration := 24 * time.Hour
-	token, err := auth.GenerateToken(user.ID, user.Email, user.Role, tokenDuration)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+	if refuseSuspended(c, user) {
 		return
 	}
 
-	expiresAt := time.Now().Add(tokenDuration).Unix()
+	// Users with two-factor authentication finish signing in with a code; failed
+	// logins are only cleared once they have entered it
+	if user.TwoFactorEnabled {
+		challenge, err := startTwoFactorChallenge(ctx, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start two-factor login"})
+			return
+		}
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+	clearLoginFailures(ctx, email)
 
-	response := models.AuthResponse{
-		Token:     token,
-		User:      user,
-		ExpiresAt: expiresAt,
+	// Start a session for this device
+	response, err := startSession(c, user, req.Device, auth.MethodPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -148,7 +173,7 @@ func GetProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-// Logout handles user logout by blacklisting their token
+// Logout handles user logout by ending their session and blacklisting their token
 func Logout(c *gin.Context) {
 	// Extract the token from Authorization header
 	authHeader := c.GetHeader("Authorization")
@@ -173,12 +198,27 @@ func Logout(c *gin.Context) {
 		return
 	}
 
-	// Blacklist the token
-	expiresAt := claims.ExpiresAt.Time
-	if err := auth.BlacklistToken(tokenString, expiresAt); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
-		return
+	if claims.SessionID != "" {
+		// End the session, which also blacklists this token
+		if err := revokeSession(c.Request.Context(), claims.SessionID, models.SessionRevokedLogout); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
+			return
+		}
+	} else {
+		// Blacklist the token
+		expiresAt := claims.ExpiresAt.Time
+		if err := auth.BlacklistToken(claims.BlacklistKey(tokenString), expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
+			return
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
 }
+
+// GetJWKS publishes the public keys access tokens are signed with, so other
+// services can verify them. It is empty while tokens are signed with HS256.
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.PublicJWKS())
+}
@@ -30,6 +30,11 @@ func TestRegister_Success(t *testing.T) {
 	oldUserRepo := NewUserRepository
 	NewUserRepository = UserRepository(mockUserRepo)
 	defer func() { NewUserRepository = oldUserRepo }()
+	mockSessionRepo, _ := useSessionRepos(t)
+	mockSessionRepo.On("CreateSession", mock.Anything, mock.AnythingOfType("*models.Session")).Return(nil)
+	mockTokenRepo, sender := useAccountEmail(t)
+	mockTokenRepo.On("InvalidateAccountTokens", mock.Anything, mock.Anything, models.AccountTokenEmailVerification).Return(nil)
+	mockTokenRepo.On("CreateAccountToken", mock.Anything, mock.Anything).Return(nil)
 	
 	req := models.RegisterRequest{
 		Email:     "newuser@example.com",
@@ -55,8 +60,15 @@ func TestRegister_Success(t *testing.T) {
 	assert.Equal(t, "newuser@example.com", response.User.Email)
 	assert.NotEmpty(t, response.Token)
 	assert.NotZero(t, response.ExpiresAt)
+	assert.NotEmpty(t, response.RefreshToken)
+	assert.False(t, response.User.EmailVerified)
+	
+	// New users are asked to verify their email
+	assert.Len(t, sender.messages, 1)
+	assert.Equal(t, "newuser@example.com", sender.messages[0].To)
 	
 	mockUserRepo.AssertExpectations(t)
+	mockSessionRepo.AssertExpectations(t)
 }
 
 func TestRegister_InvalidRequest(t *testing.T) {
@@ -86,8 +98,9 @@ func TestRegister_UserAlreadyExists(t *testing.T) {
 	NewUserRepository = mockUserRepo
 	defer func() { NewUserRepository = oldUserRepo }()
 	
+	// Emails differing only by case are the same account
 	req := models.RegisterRequest{
-		Email:     "existing@example.com",
+		Email:     "Existing@Example.com",
 		Password:  "password123",
 		FirstName: "John",
 		LastName:  "Doe",
@@ -142,10 +155,18 @@ func TestLogin_Success(t *testing.T) {
 	oldUserRepo := NewUserRepository
 	NewUserRepository = UserRepository(mockUserRepo)
 	defer func() { NewUserRepository = oldUserRepo }()
+	mockSessionRepo, _ := useSessionRepos(t)
+	mockSessionRepo.On("CreateSession", mock.Anything, mock.MatchedBy(func(s *models.Session) bool {
+		return s.UserID == user.ID && s.Device == "Jane's phone" && s.RefreshTokenHash != "" && s.AccessTokenID != ""
+	})).Return(nil)
+	mockThrottleRepo, _ := useLoginThrottles(t)
+	mockThrottleRepo.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	mockThrottleRepo.On("ClearLoginThrottle", mock.Anything, "account:test@example.com").Return(false, nil)
 
 	req := models.LoginRequest{
 		Email:    "test@example.com",
 		Password: "password123",
+		Device:   "Jane's phone",
 	}
 	
 	body, _ := json.Marshal(req)
@@ -164,8 +185,12 @@ func TestLogin_Success(t *testing.T) {
 	json.Unmarshal(w.Body.Bytes(), &response)
 	assert.Equal(t, "test@example.com", response.User.Email)
 	assert.NotEmpty(t, response.Token)
+	assert.NotEmpty(t, response.RefreshToken)
+	assert.NotEmpty(t, response.SessionID)
 	
 	mockUserRepo.AssertExpectations(t)
+	mockSessionRepo.AssertExpectations(t)
+	mockThrottleRepo.AssertExpectations(t)
 }
 
 func TestGetProfile_NotAuthenticated(t *testing.T) {
@@ -263,9 +288,6 @@ func TestLogout_MissingBearer(t *testing.T) {
 func TestLogout_InvalidToken(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	
-	// Skip: ValidateToken requires database access to check token blacklist
-	t.Skip("ValidateToken requires database initialization")
-	
 	// For invalid token, ValidateToken will fail before blacklisting
 	// So we just need to verify the handler rejects it
 	httpReq := httptest.NewRequest("POST", "/logout", nil)
@@ -303,3 +325,17 @@ func TestLogout_ValidToken(t *testing.T) {
 	// In a real scenario with mocks, we could test success
 	assert.True(t, w.Code == http.StatusUnauthorized || w.Code == http.StatusInternalServerError)
 }
+
+func TestGetJWKS_NoPublicKeysWithHS256(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+
+	GetJWKS(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[]}`, w.Body.String())
+	assert.Contains(t, w.Header().Get("Cache-Control"), "max-age")
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// c2bRejectUnmatched makes the validation URL reject Paybill payments whose account
+// number matches no invoice, instead of accepting them into the suspense queue
+var c2bRejectUnmatched bool
+
+// matchC2BInvoice finds the payable invoice a customer's account number refers to.
+// Customers type the invoice ID as the account number; it returns nil if none matches.
+func matchC2BInvoice(ctx context.Context, billRef string) (*models.Invoice, error) {
+	ref := strings.ToLower(strings.TrimSpace(billRef))
+	if ref == "" {
+		return nil, nil
+	}
+
+	invoice, err := NewInvoiceRepository.GetInvoiceByID(ctx, ref)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if invoice.Type != models.InvoiceTypePayable {
+		return nil, nil
+	}
+	return invoice, nil
+}
+
+// readC2BNotification parses a C2B callback and checks it came from Daraja for the
+// shop's short code. It answers rejected callbacks itself and returns false.
+func readC2BNotification(c *gin.Context, notification *models.C2BNotification) bool {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid c2b callback"})
+		return false
+	}
+
+	if err := json.Unmarshal(body, notification); err != nil {
+		auditRejectedCallback(c, models.CallbackSourceC2B, models.CallbackRejectInvalidBody, "", body)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid c2b callback"})
+		return false
+	}
+
+	if reason := c2bCallbackRejection(c.Param("token"), notification.BusinessShortCode); reason != "" {
+		rejectCallback(c, models.CallbackSourceC2B, reason, notification.TransID, body)
+		return false
+	}
+	return true
+}
+
+// HandleC2BValidation answers M-Pesa's request to accept or reject a Paybill payment
+// before it completes. Only sent when external validation is enabled on the short code.
+func HandleC2BValidation(c *gin.Context) {
+	var notification models.C2BNotification
+	if !readC2BNotification(c, &notification) {
+		return
+	}
+
+	if c2bRejectUnmatched {
+		invoice, err := matchC2BInvoice(context.Background(), notification.BillRefNumber)
+		if err == nil && invoice == nil {
+			c.JSON(http.StatusOK, gin.H{"ResultCode": mpesa.C2BRejectInvalidAccount, "ResultDesc": "Rejected"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ResultCode": mpesa.C2BAccepted, "ResultDesc": "Accepted"})
+}
+
+// HandleC2BConfirmation records a completed Paybill payment. Payments whose account
+// number matches an invoice credit it; the rest wait in the suspense queue.
+func HandleC2BConfirmation(c *gin.Context) {
+	var notification models.C2BNotification
+	if !readC2BNotification(c, &notification) {
+		return
+	}
+
+	amount, err := strconv.ParseFloat(notification.TransAmount, 64)
+	if err != nil || amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid amount"})
+		return
+	}
+
+	var names []string
+	for _, name := range []string{notification.FirstName, notification.MiddleName, notification.LastName} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	txn := &models.C2BTransaction{
+		ID:              uuid.New().String(),
+		TransID:         notification.TransID,
+		TransactionType: notification.TransactionType,
+		TransTime:       notification.TransTime,
+		Amount:          amount,
+		ShortCode:       notification.BusinessShortCode,
+		BillRefNumber:   notification.BillRefNumber,
+		Phone:           notification.MSISDN,
+		PayerName:       strings.Join(names, " "),
+	}
+	if t, err := time.ParseInLocation("20060102150405", notification.TransTime, mpesaTimeZone); err == nil {
+		txn.TransactedAt = &t
+	}
+
+	if err := recordC2BPayment(context.Background(), txn); err != nil {
+		// M-Pesa retries confirmations; the first one already recorded the payment
+		if err.Error() == "c2b transaction already recorded" {
+			c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Confirmation already processed"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"ResultCode": "1", "ResultDesc": "Failed to record payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Confirmation received"})
+}
+
+// recordC2BPayment stores a confirmed Paybill payment and credits its invoice in one
+// unit of work, so a payment is never recorded as credited without the invoice update
+func recordC2BPayment(ctx context.Context, txn *models.C2BTransaction) error {
+	c2bRepo := NewC2BRepository
+	invoiceRepo := NewInvoiceRepository
+
+	return NewUnitOfWork.Do(ctx, func(ctx context.Context) error {
+		invoice, err := matchC2BInvoice(ctx, txn.BillRefNumber)
+		if err != nil {
+			return err
+		}
+
+		txn.Status = models.C2BStatusSuspense
+		txn.InvoiceID = ""
+		txn.AmountMismatch = ""
+		if invoice != nil {
+			txn.Status = models.C2BStatusCredited
+			txn.InvoiceID = invoice.ID
+			if txn.Amount > invoice.InvoiceAmount-invoice.PaidAmount+amountTolerance {
+				txn.AmountMismatch = models.AmountMismatchOverpaid
+			}
+		}
+
+		if err := c2bRepo.CreateC2BTransaction(ctx, txn); err != nil {
+			return err
+		}
+		if invoice == nil {
+			return nil
+		}
+		database.OnRollback(ctx, func() {
+			_ = c2bRepo.DeleteC2BTransaction(context.Background(), txn.ID)
+		})
+
+		return invoiceRepo.RecordPayment(ctx, invoice.ID, txn.Amount, paidOnDate(txn.TransactedAt))
+	})
+}
+
+// AdminListC2BTransactions lists Paybill payments by status, the suspense queue by default (admin)
+func AdminListC2BTransactions(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	status := c.DefaultQuery("status", models.C2BStatusSuspense)
+
+	c2bRepo := NewC2BRepository
+	txns, err := c2bRepo.GetC2BTransactionsByStatus(context.Background(), status, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve c2b transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": txns, "status": status, "page": page, "limit": limit})
+}
+
+// AdminAllocateC2BTransaction credits a suspense payment to the invoice an admin chose
+func AdminAllocateC2BTransaction(c *gin.Context) {
+	txnID := c.Param("id")
+
+	var req models.AllocateC2BRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c2bRepo := NewC2BRepository
+	invoiceRepo := NewInvoiceRepository
+
+	txn, err := c2bRepo.GetC2BTransactionByID(context.Background(), txnID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "c2b transaction not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve c2b transaction"})
+		return
+	}
+
+	if txn.Status != models.C2BStatusSuspense {
+		c.JSON(http.StatusConflict, gin.H{"error": "c2b transaction is not in suspense"})
+		return
+	}
+
+	invoice, err := invoiceRepo.GetInvoiceByID(context.Background(), req.InvoiceID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve invoice"})
+		return
+	}
+
+	adminID := c.GetString("userID")
+	err = NewUnitOfWork.Do(context.Background(), func(ctx context.Context) error {
+		if err := c2bRepo.AllocateC2BTransaction(ctx, txn.ID, invoice.ID, adminID, req.Note); err != nil {
+			return err
+		}
+		database.OnRollback(ctx, func() {
+			_ = c2bRepo.ReleaseC2BAllocation(context.Background(), txn.ID)
+		})
+
+		return invoiceRepo.RecordPayment(ctx, invoice.ID, txn.Amount, paidOnDate(txn.TransactedAt))
+	})
+	if err != nil {
+		if err.Error() == "c2b transaction not found or already allocated" {
+			c.JSON(http.StatusConflict, gin.H{"error": "c2b transaction is not in suspense"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to allocate c2b transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "payment allocated", "invoiceId": invoice.ID, "amount": txn.Amount})
+}
+
+// AdminRegisterC2BURLs registers the configured confirmation and validation URLs with M-Pesa (admin)
+func AdminRegisterC2BURLs(c *gin.Context) {
+	if mpesaClient == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "M-Pesa client not initialized or not configured"})
+		return
+	}
+
+	resp, err := mpesaClient.RegisterC2BURLs(c.Request.Context())
+	if err != nil {
+		c.JSON(mpesa.HTTPStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
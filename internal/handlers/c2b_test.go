@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	c2bInvoiceID = "5b8e6f3c-1a2b-4c3d-9e8f-0a1b2c3d4e5f"
+	c2bToken     = "c2b-secret"
+)
+
+// c2bConfirmation builds a Daraja C2B confirmation payload
+func c2bConfirmation(transID, billRef, amount string) string {
+	return `{"TransactionType":"Pay Bill","TransID":"` + transID + `","TransTime":"20240115103015","TransAmount":"` + amount + `",` +
+		`"BusinessShortCode":"600999","BillRefNumber":"` + billRef + `","MSISDN":"254712345678","FirstName":"Jane","LastName":"Doe"}`
+}
+
+func postC2B(handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	return postC2BWithToken(handler, c2bToken, body)
+}
+
+func postC2BWithToken(handler gin.HandlerFunc, token, body string) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest("POST", "/payments/c2b", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	if token != "" {
+		c.Params = gin.Params{{Key: "token", Value: token}}
+	}
+
+	handler(c)
+	return w
+}
+
+// useC2BSecurity expects C2B callbacks with c2bToken for short code 600999
+func useC2BSecurity(t *testing.T) {
+	useCallbackSecurity(t, callbackSecurityConfig{c2bToken: c2bToken, c2bShortCode: "600999"})
+}
+
+func useC2BMocks(t *testing.T, c2bRepo *MockC2BRepository, invoiceRepo *MockInvoiceRepository) {
+	useC2BSecurity(t)
+	oldC2BRepo := NewC2BRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	NewC2BRepository = C2BRepository(c2bRepo)
+	NewInvoiceRepository = InvoiceRepository(invoiceRepo)
+	t.Cleanup(func() {
+		NewC2BRepository = oldC2BRepo
+		NewInvoiceRepository = oldInvoiceRepo
+	})
+}
+
+func TestHandleC2BConfirmation_MatchedInvoiceIsCredited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, c2bInvoiceID).Return(&models.Invoice{ID: c2bInvoiceID, InvoiceAmount: 1000, Type: models.InvoiceTypePayable}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, c2bInvoiceID, 500.0, "2024-01-15").Return(nil)
+
+	mockC2BRepo := new(MockC2BRepository)
+	mockC2BRepo.On("CreateC2BTransaction", mock.Anything, mock.MatchedBy(func(txn *models.C2BTransaction) bool {
+		return txn.TransID == "SAB1CD2EF3" &&
+			txn.Status == models.C2BStatusCredited &&
+			txn.InvoiceID == c2bInvoiceID &&
+			txn.Amount == 500 &&
+			txn.PayerName == "Jane Doe" &&
+			txn.TransactedAt != nil &&
+			txn.AmountMismatch == ""
+	})).Return(nil)
+	useC2BMocks(t, mockC2BRepo, mockInvoiceRepo)
+
+	// Customers often type the account number with different case and spacing
+	w := postC2B(HandleC2BConfirmation, c2bConfirmation("SAB1CD2EF3", " 5B8E6F3C-1A2B-4C3D-9E8F-0A1B2C3D4E5F ", "500.00"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"ResultCode":"0"`)
+	mockC2BRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertExpectations(t)
+}
+
+func TestHandleC2BConfirmation_UnmatchedGoesToSuspense(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "order 42").Return(nil, mongo.ErrNoDocuments)
+
+	mockC2BRepo := new(MockC2BRepository)
+	mockC2BRepo.On("CreateC2BTransaction", mock.Anything, mock.MatchedBy(func(txn *models.C2BTransaction) bool {
+		return txn.Status == models.C2BStatusSuspense && txn.InvoiceID == ""
+	})).Return(nil)
+	useC2BMocks(t, mockC2BRepo, mockInvoiceRepo)
+
+	w := postC2B(HandleC2BConfirmation, c2bConfirmation("SAB1CD2EF4", "Order 42", "750"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockC2BRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertNotCalled(t, "RecordPayment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleC2BConfirmation_DuplicateIsNoop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, c2bInvoiceID).Return(&models.Invoice{ID: c2bInvoiceID, InvoiceAmount: 1000, Type: models.InvoiceTypePayable}, nil)
+
+	mockC2BRepo := new(MockC2BRepository)
+	mockC2BRepo.On("CreateC2BTransaction", mock.Anything, mock.Anything).Return(errors.New("c2b transaction already recorded"))
+	useC2BMocks(t, mockC2BRepo, mockInvoiceRepo)
+
+	w := postC2B(HandleC2BConfirmation, c2bConfirmation("SAB1CD2EF3", c2bInvoiceID, "500"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "already processed")
+	mockInvoiceRepo.AssertNotCalled(t, "RecordPayment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleC2BConfirmation_CreditFailureRollsBack(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, c2bInvoiceID).Return(&models.Invoice{ID: c2bInvoiceID, InvoiceAmount: 1000, Type: models.InvoiceTypePayable}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, c2bInvoiceID, 500.0, "2024-01-15").Return(assert.AnError)
+
+	mockC2BRepo := new(MockC2BRepository)
+	mockC2BRepo.On("CreateC2BTransaction", mock.Anything, mock.Anything).Return(nil)
+	mockC2BRepo.On("DeleteC2BTransaction", mock.Anything, mock.Anything).Return(nil)
+	useC2BMocks(t, mockC2BRepo, mockInvoiceRepo)
+
+	w := postC2B(HandleC2BConfirmation, c2bConfirmation("SAB1CD2EF3", c2bInvoiceID, "500"))
+
+	// M-Pesa retries the confirmation, which finds no record and tries again
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockC2BRepo.AssertCalled(t, "DeleteC2BTransaction", mock.Anything, mock.Anything)
+}
+
+func TestHandleC2BConfirmation_InvalidAmount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useC2BSecurity(t)
+
+	w := postC2B(HandleC2BConfirmation, c2bConfirmation("SAB1CD2EF3", c2bInvoiceID, "abc"))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleC2BConfirmation_ForgedIsNotCredited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockC2BRepo := new(MockC2BRepository)
+	useC2BMocks(t, mockC2BRepo, mockInvoiceRepo)
+
+	// A customer posts a confirmation for their own invoice straight to the public route
+	forged := c2bConfirmation("FAKE000001", c2bInvoiceID, "1000")
+	for _, tc := range []struct {
+		token, reason string
+	}{
+		{"", models.CallbackRejectMissingToken},
+		{"guessed", models.CallbackRejectTokenMismatch},
+	} {
+		auditRepo := useCallbackAudit(t)
+		w := postC2BWithToken(HandleC2BConfirmation, tc.token, forged)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assertRejected(t, auditRepo, models.CallbackSourceC2B, tc.reason)
+	}
+
+	// The right token does not help a payment to another business's short code
+	auditRepo := useCallbackAudit(t)
+	otherShortCode := strings.Replace(forged, `"600999"`, `"123456"`, 1)
+	w := postC2B(HandleC2BConfirmation, otherShortCode)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assertRejected(t, auditRepo, models.CallbackSourceC2B, models.CallbackRejectShortCode)
+
+	mockC2BRepo.AssertNotCalled(t, "CreateC2BTransaction", mock.Anything, mock.Anything)
+	mockInvoiceRepo.AssertNotCalled(t, "GetInvoiceByID", mock.Anything, mock.Anything)
+	mockInvoiceRepo.AssertNotCalled(t, "RecordPayment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleC2BConfirmation_RefusedWithoutTokenOrAllowlist(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC2BRepo := new(MockC2BRepository)
+	useC2BMocks(t, mockC2BRepo, new(MockInvoiceRepository))
+	useCallbackSecurity(t, callbackSecurityConfig{c2bShortCode: "600999"})
+	auditRepo := useCallbackAudit(t)
+
+	w := postC2BWithToken(HandleC2BConfirmation, "", c2bConfirmation("SAB1CD2EF3", c2bInvoiceID, "500"))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assertRejected(t, auditRepo, models.CallbackSourceC2B, models.CallbackRejectUnauthenticated)
+	mockC2BRepo.AssertNotCalled(t, "CreateC2BTransaction", mock.Anything, mock.Anything)
+}
+
+func TestHandleC2BValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, c2bInvoiceID).Return(&models.Invoice{ID: c2bInvoiceID, Type: models.InvoiceTypePayable}, nil)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "unknown").Return(nil, mongo.ErrNoDocuments)
+	useC2BMocks(t, new(MockC2BRepository), mockInvoiceRepo)
+
+	old := c2bRejectUnmatched
+	defer func() { c2bRejectUnmatched = old }()
+
+	// By default unmatched payments are accepted into the suspense queue
+	c2bRejectUnmatched = false
+	w := postC2B(HandleC2BValidation, c2bConfirmation("SAB1CD2EF5", "unknown", "100"))
+	assert.Contains(t, w.Body.String(), `"ResultCode":"0"`)
+
+	c2bRejectUnmatched = true
+	w = postC2B(HandleC2BValidation, c2bConfirmation("SAB1CD2EF5", "unknown", "100"))
+	assert.Contains(t, w.Body.String(), `"ResultCode":"C2B00012"`)
+
+	w = postC2B(HandleC2BValidation, c2bConfirmation("SAB1CD2EF6", c2bInvoiceID, "100"))
+	assert.Contains(t, w.Body.String(), `"ResultCode":"0"`)
+}
+
+func allocateC2B(txnID, body string) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest("POST", "/admin/payments/c2b/"+txnID+"/allocate", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{{Key: "id", Value: txnID}}
+	c.Set("userID", "admin-1")
+
+	AdminAllocateC2BTransaction(c)
+	return w
+}
+
+func TestAdminAllocateC2BTransaction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC2BRepo := new(MockC2BRepository)
+	mockC2BRepo.On("GetC2BTransactionByID", mock.Anything, "c2b-1").Return(&models.C2BTransaction{ID: "c2b-1", Amount: 750, Status: models.C2BStatusSuspense}, nil)
+	mockC2BRepo.On("GetC2BTransactionByID", mock.Anything, "c2b-2").Return(&models.C2BTransaction{ID: "c2b-2", Amount: 750, Status: models.C2BStatusCredited}, nil)
+	mockC2BRepo.On("AllocateC2BTransaction", mock.Anything, "c2b-1", "inv-1", "admin-1", "customer typed order number").Return(nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", InvoiceAmount: 750, Type: models.InvoiceTypePayable}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, "inv-1", 750.0, mock.Anything).Return(nil)
+	useC2BMocks(t, mockC2BRepo, mockInvoiceRepo)
+
+	w := allocateC2B("c2b-1", `{"invoiceId":"inv-1","note":"customer typed order number"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Credited payments cannot be allocated again
+	w = allocateC2B("c2b-2", `{"invoiceId":"inv-1"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	mockC2BRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertNumberOfCalls(t, "RecordPayment", 1)
+}
+
+func TestAdminAllocateC2BTransaction_UnknownInvoice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC2BRepo := new(MockC2BRepository)
+	mockC2BRepo.On("GetC2BTransactionByID", mock.Anything, "c2b-1").Return(&models.C2BTransaction{ID: "c2b-1", Amount: 750, Status: models.C2BStatusSuspense}, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "missing").Return(nil, mongo.ErrNoDocuments)
+	useC2BMocks(t, mockC2BRepo, mockInvoiceRepo)
+
+	w := allocateC2B("c2b-1", `{"invoiceId":"missing"}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockC2BRepo.AssertNotCalled(t, "AllocateC2BTransaction", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
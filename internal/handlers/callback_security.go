@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// callbackSecurityConfig controls how Daraja callbacks are authenticated. The
+// callback routes are public, so anything that reaches them is untrusted until it
+// passes these checks.
+type callbackSecurityConfig struct {
+	// requireToken rejects STK, reversal and B2C callbacks for requests issued
+	// without a callback token. Requests issued with one always need it.
+	requireToken bool
+	// allowedNets limits callback senders to these networks; empty allows any sender
+	allowedNets []*net.IPNet
+	// replayWindow is how long after a request its callback is accepted; zero
+	// accepts callbacks of any age
+	replayWindow time.Duration
+	// c2bToken is the secret carried in the registered C2B URLs. Without it C2B
+	// callbacks are only accepted from an allowlisted sender.
+	c2bToken string
+	// c2bShortCode is the Paybill/Till C2B notifications must be addressed to
+	c2bShortCode string
+}
+
+var callbackSecurity callbackSecurityConfig
+
+// maxAuditedBody caps how much of a rejected callback's payload is kept
+const maxAuditedBody = 4096
+
+// InitCallbackSecurity loads the callback checks from the environment:
+// MPESA_CALLBACK_REQUIRE_TOKEN, MPESA_CALLBACK_ALLOWED_IPS (comma-separated IPs or
+// CIDRs; "safaricom" adds Safaricom's published callback IPs),
+// MPESA_CALLBACK_REPLAY_WINDOW (a duration such as "24h") and
+// MPESA_C2B_CALLBACK_TOKEN. C2B notifications must name MPESA_BUSINESS_SHORTCODE,
+// the short code the C2B URLs are registered for.
+func InitCallbackSecurity() error {
+	config := callbackSecurityConfig{
+		requireToken: os.Getenv("MPESA_CALLBACK_REQUIRE_TOKEN") == "true",
+		c2bToken:     os.Getenv("MPESA_C2B_CALLBACK_TOKEN"),
+		c2bShortCode: os.Getenv("MPESA_BUSINESS_SHORTCODE"),
+	}
+
+	nets, err := parseAllowedNets(os.Getenv("MPESA_CALLBACK_ALLOWED_IPS"))
+	if err != nil {
+		return err
+	}
+	config.allowedNets = nets
+
+	if window := os.Getenv("MPESA_CALLBACK_REPLAY_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid MPESA_CALLBACK_REPLAY_WINDOW %q", window)
+		}
+		config.replayWindow = d
+	}
+
+	callbackSecurity = config
+	return nil
+}
+
+// parseAllowedNets reads a comma-separated list of IPs and CIDRs
+func parseAllowedNets(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		entries := []string{entry}
+		if strings.EqualFold(entry, "safaricom") {
+			entries = mpesa.SafaricomCallbackIPs
+		}
+
+		for _, e := range entries {
+			if !strings.Contains(e, "/") {
+				ip := net.ParseIP(e)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid callback allowlist entry %q", e)
+				}
+				bits := 32
+				if ip.To4() == nil {
+					bits = 128
+				}
+				e = e + "/" + strconv.Itoa(bits)
+			}
+			_, ipNet, err := net.ParseCIDR(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid callback allowlist entry %q", e)
+			}
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets, nil
+}
+
+// callbackSenderAllowed reports whether ip may post Daraja callbacks
+func callbackSenderAllowed(ip string) bool {
+	if len(callbackSecurity.allowedNets) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range callbackSecurity.allowedNets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// DarajaCallbackGuard rejects callbacks from senders outside the configured
+// allowlist. The sender is taken from gin's ClientIP, so set trusted proxies when
+// the API runs behind a load balancer.
+func DarajaCallbackGuard(source string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !callbackSenderAllowed(c.ClientIP()) {
+			rejectCallback(c, source, models.CallbackRejectIPNotAllowed, "", nil)
+			return
+		}
+		c.Next()
+	}
+}
+
+// rejectCallback records a refused callback in the audit log and answers it with 403
+func rejectCallback(c *gin.Context, source, reason, reference string, body []byte) {
+	auditRejectedCallback(c, source, reason, reference, body)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"ResultCode": "1", "ResultDesc": "Callback rejected"})
+}
+
+// auditRejectedCallback records a refused callback without answering it. Audit
+// failures are logged, never returned, so they cannot change the response Daraja sees.
+func auditRejectedCallback(c *gin.Context, source, reason, reference string, body []byte) {
+	if len(body) > maxAuditedBody {
+		body = body[:maxAuditedBody]
+	}
+
+	// The route pattern keeps callback tokens out of the log
+	path := c.FullPath()
+	if path == "" {
+		path = c.Request.URL.Path
+	}
+
+	entry := &models.RejectedCallback{
+		ID:        uuid.New().String(),
+		Source:    source,
+		Reason:    reason,
+		RemoteIP:  c.ClientIP(),
+		Path:      path,
+		Reference: reference,
+		Body:      string(body),
+	}
+	log.Printf("Rejected %s callback from %s: %s (reference %q)", source, entry.RemoteIP, reason, reference)
+
+	if NewCallbackAuditRepository == nil {
+		return
+	}
+	if err := NewCallbackAuditRepository.RecordRejectedCallback(context.Background(), entry); err != nil {
+		log.Printf("Failed to record rejected callback: %v", err)
+	}
+}
+
+// newCallbackToken returns an unguessable token for a payment's callback URL and
+// the hash to store. Only the hash is kept, so a database leak cannot forge callbacks.
+func newCallbackToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate callback token: %w", err)
+	}
+	token = hex.EncodeToString(b)
+	return token, hashCallbackToken(token), nil
+}
+
+func hashCallbackToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// callbackTokenRejection checks the token a callback arrived with against the
+// payment it names, returning the rejection reason or "" if the token is acceptable
+func callbackTokenRejection(payment *models.PaymentRecord, token string) string {
+	return callbackTokenHashRejection(payment.CallbackTokenHash, token)
+}
+
+// callbackTokenHashRejection checks a callback token against the hash stored on the
+// request it names (a payment, reversal or refund). Requests stored without a hash
+// predate callback tokens.
+func callbackTokenHashRejection(tokenHash, token string) string {
+	if tokenHash == "" {
+		// Issued before callback tokens existed
+		if token != "" {
+			return models.CallbackRejectTokenMismatch
+		}
+		if callbackSecurity.requireToken {
+			return models.CallbackRejectMissingToken
+		}
+		return ""
+	}
+
+	if token == "" {
+		return models.CallbackRejectMissingToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hashCallbackToken(token)), []byte(tokenHash)) != 1 {
+		return models.CallbackRejectTokenMismatch
+	}
+	return ""
+}
+
+// c2bCallbackURL appends the C2B callback token, when one is configured, to a C2B
+// URL before it is registered with Daraja
+func c2bCallbackURL(url string) string {
+	token := os.Getenv("MPESA_C2B_CALLBACK_TOKEN")
+	if url == "" || token == "" {
+		return url
+	}
+	return strings.TrimRight(url, "/") + "/" + token
+}
+
+// c2bCallbackRejection checks a C2B callback's URL token and the short code it was
+// sent to, returning the rejection reason or "" if the callback is acceptable.
+// C2B notifications carry nothing the shop issued, so without a token only an
+// allowlisted sender is trusted.
+func c2bCallbackRejection(token, shortCode string) string {
+	switch {
+	case callbackSecurity.c2bToken != "":
+		if token == "" {
+			return models.CallbackRejectMissingToken
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(callbackSecurity.c2bToken)) != 1 {
+			return models.CallbackRejectTokenMismatch
+		}
+	case len(callbackSecurity.allowedNets) == 0:
+		return models.CallbackRejectUnauthenticated
+	}
+
+	if shortCode == "" || shortCode != callbackSecurity.c2bShortCode {
+		return models.CallbackRejectShortCode
+	}
+	return ""
+}
+
+// withinReplayWindow reports whether a callback for a request made at requestedAt
+// is still acceptable. An unknown request time is always accepted.
+func withinReplayWindow(requestedAt time.Time) bool {
+	if callbackSecurity.replayWindow == 0 || requestedAt.IsZero() {
+		return true
+	}
+	return time.Since(requestedAt) <= callbackSecurity.replayWindow
+}
+
+// paymentCreatedAt parses a payment record's creation time, stored in local time
+func paymentCreatedAt(payment *models.PaymentRecord) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", payment.CreatedAt, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// AdminListRejectedCallbacks lists rejected callbacks, newest first, optionally by
+// source (admin)
+func AdminListRejectedCallbacks(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	source := c.Query("source")
+
+	auditRepo := NewCallbackAuditRepository
+	entries, err := auditRepo.ListRejectedCallbacks(context.Background(), source, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve rejected callbacks"})
+		return
+	}
+
+	total, err := auditRepo.CountRejectedCallbacks(context.Background(), source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count rejected callbacks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": entries, "total": total, "page": page, "limit": limit})
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// useCallbackSecurity replaces the callback checks for a test
+func useCallbackSecurity(t *testing.T, config callbackSecurityConfig) {
+	old := callbackSecurity
+	callbackSecurity = config
+	t.Cleanup(func() { callbackSecurity = old })
+}
+
+// useCallbackAudit records rejected callbacks in a mock for a test
+func useCallbackAudit(t *testing.T) *MockCallbackAuditRepository {
+	mockAuditRepo := new(MockCallbackAuditRepository)
+	mockAuditRepo.On("RecordRejectedCallback", mock.Anything, mock.Anything).Return(nil)
+	old := NewCallbackAuditRepository
+	NewCallbackAuditRepository = CallbackAuditRepository(mockAuditRepo)
+	t.Cleanup(func() { NewCallbackAuditRepository = old })
+	return mockAuditRepo
+}
+
+// assertRejected checks the audit log got exactly one entry with the given reason
+func assertRejected(t *testing.T, auditRepo *MockCallbackAuditRepository, source, reason string) {
+	auditRepo.AssertNumberOfCalls(t, "RecordRejectedCallback", 1)
+	entry := auditRepo.Calls[0].Arguments.Get(1).(*models.RejectedCallback)
+	assert.Equal(t, source, entry.Source)
+	assert.Equal(t, reason, entry.Reason)
+}
+
+// callbackRouter serves the STK callback routes the way main.go does
+func callbackRouter() *gin.Engine {
+	router := gin.New()
+	guard := DarajaCallbackGuard(models.CallbackSourceSTKPush)
+	router.POST("/api/v1/mpesa/callback", guard, HandleMpesaCallback)
+	router.POST("/api/v1/mpesa/callback/:token", guard, HandleMpesaCallback)
+	return router
+}
+
+func postTokenCallback(path, remoteAddr, checkoutID string) *httptest.ResponseRecorder {
+	body := `{"Body":{"stkCallback":{"MerchantRequestID":"m-1","CheckoutRequestID":"` + checkoutID + `","ResultCode":1032,"ResultDesc":"Request cancelled by user"}}}`
+	httpReq := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.RemoteAddr = remoteAddr
+
+	w := httptest.NewRecorder()
+	callbackRouter().ServeHTTP(w, httpReq)
+	return w
+}
+
+// tokenPayment is an initiated M-Pesa payment whose callback URL carries token
+func tokenPayment(token string) *models.PaymentRecord {
+	return &models.PaymentRecord{
+		ID:                "pay-1",
+		InvoiceID:         "inv-1",
+		CheckoutRequestID: "ws_CO_1",
+		Amount:            100,
+		Status:            models.PaymentStatusInitiated,
+		CallbackTokenHash: hashCallbackToken(token),
+		CreatedAt:         time.Now().Format("2006-01-02 15:04:05"),
+	}
+}
+
+func TestHandleMpesaCallback_ValidTokenSettles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{requireToken: true})
+	auditRepo := useCallbackAudit(t)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "ws_CO_1").Return(tokenPayment("secret-token"), nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "ws_CO_1", models.PaymentStatusInitiated, models.PaymentStatusFailed, mock.Anything).Return(true, nil)
+	usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+	w := postTokenCallback("/api/v1/mpesa/callback/secret-token", "196.201.214.200:443", "ws_CO_1")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Callback received")
+	mockPaymentRepo.AssertExpectations(t)
+	auditRepo.AssertNotCalled(t, "RecordRejectedCallback", mock.Anything, mock.Anything)
+}
+
+func TestHandleMpesaCallback_TokenMismatchRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{})
+	auditRepo := useCallbackAudit(t)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "ws_CO_1").Return(tokenPayment("secret-token"), nil)
+	usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+	w := postTokenCallback("/api/v1/mpesa/callback/guessed-token", "10.0.0.1:443", "ws_CO_1")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Callback rejected")
+	mockPaymentRepo.AssertNotCalled(t, "TransitionPaymentStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceSTKPush, models.CallbackRejectTokenMismatch)
+
+	// The audit log keeps the route pattern, never the token that was tried
+	entry := auditRepo.Calls[0].Arguments.Get(1).(*models.RejectedCallback)
+	assert.Equal(t, "/api/v1/mpesa/callback/:token", entry.Path)
+	assert.Equal(t, "ws_CO_1", entry.Reference)
+	assert.Contains(t, entry.Body, "ws_CO_1")
+}
+
+func TestHandleMpesaCallback_TokenPaymentNeedsToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{})
+	auditRepo := useCallbackAudit(t)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "ws_CO_1").Return(tokenPayment("secret-token"), nil)
+	usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+	// A payment issued with a token cannot be settled through the bare route
+	w := postTokenCallback("/api/v1/mpesa/callback", "10.0.0.1:443", "ws_CO_1")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assertRejected(t, auditRepo, models.CallbackSourceSTKPush, models.CallbackRejectMissingToken)
+}
+
+func TestHandleMpesaCallback_LegacyPaymentRequireToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	legacy := &models.PaymentRecord{ID: "pay-0", InvoiceID: "inv-1", CheckoutRequestID: "ws_CO_0", Amount: 100, Status: models.PaymentStatusInitiated}
+
+	// Without MPESA_CALLBACK_REQUIRE_TOKEN, payments from before tokens still settle
+	useCallbackSecurity(t, callbackSecurityConfig{})
+	useCallbackAudit(t)
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "ws_CO_0").Return(legacy, nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "ws_CO_0", models.PaymentStatusInitiated, models.PaymentStatusFailed, mock.Anything).Return(true, nil)
+	usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+	w := postTokenCallback("/api/v1/mpesa/callback", "10.0.0.1:443", "ws_CO_0")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// With it, they are refused
+	useCallbackSecurity(t, callbackSecurityConfig{requireToken: true})
+	auditRepo := useCallbackAudit(t)
+
+	w = postTokenCallback("/api/v1/mpesa/callback", "10.0.0.1:443", "ws_CO_0")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assertRejected(t, auditRepo, models.CallbackSourceSTKPush, models.CallbackRejectMissingToken)
+}
+
+func TestHandleMpesaCallback_OutsideReplayWindow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{replayWindow: time.Hour})
+	auditRepo := useCallbackAudit(t)
+
+	stale := tokenPayment("secret-token")
+	stale.CreatedAt = time.Now().Add(-2 * time.Hour).Format("2006-01-02 15:04:05")
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "ws_CO_1").Return(stale, nil)
+	usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+	w := postTokenCallback("/api/v1/mpesa/callback/secret-token", "10.0.0.1:443", "ws_CO_1")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockPaymentRepo.AssertNotCalled(t, "TransitionPaymentStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceSTKPush, models.CallbackRejectReplayWindow)
+}
+
+func TestHandleMpesaCallback_UnknownPaymentAudited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{})
+	auditRepo := useCallbackAudit(t)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "ws_forged").Return(nil, assert.AnError)
+	usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+	w := postTokenCallback("/api/v1/mpesa/callback", "10.0.0.1:443", "ws_forged")
+
+	// Daraja gets its usual answer; the attempt is still recorded
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Payment not found")
+	assertRejected(t, auditRepo, models.CallbackSourceSTKPush, models.CallbackRejectUnknown)
+}
+
+func TestDarajaCallbackGuard_IPAllowlist(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	nets, err := parseAllowedNets("safaricom, 10.1.0.0/16")
+	assert.NoError(t, err)
+	assert.Len(t, nets, len(mpesa.SafaricomCallbackIPs)+1)
+	useCallbackSecurity(t, callbackSecurityConfig{allowedNets: nets})
+	auditRepo := useCallbackAudit(t)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "ws_CO_1").Return(tokenPayment("secret-token"), nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "ws_CO_1", mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+	usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+	w := postTokenCallback("/api/v1/mpesa/callback/secret-token", "203.0.113.9:443", "ws_CO_1")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockPaymentRepo.AssertNotCalled(t, "GetPaymentByCheckoutRequestID", mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceSTKPush, models.CallbackRejectIPNotAllowed)
+
+	for _, addr := range []string{"196.201.214.200:443", "10.1.2.3:443"} {
+		w = postTokenCallback("/api/v1/mpesa/callback/secret-token", addr, "ws_CO_1")
+		assert.Equal(t, http.StatusOK, w.Code, addr)
+	}
+}
+
+func TestParseAllowedNets_Invalid(t *testing.T) {
+	for _, list := range []string{"not-an-ip", "10.0.0.0/33"} {
+		_, err := parseAllowedNets(list)
+		assert.Error(t, err, list)
+	}
+
+	nets, err := parseAllowedNets("")
+	assert.NoError(t, err)
+	assert.Empty(t, nets)
+}
+
+func TestHandleB2CResult_OutsideReplayWindow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{replayWindow: time.Hour})
+	auditRepo := useCallbackAudit(t)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundByConversationID", mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(&models.Refund{ID: "refund-1", Status: models.RefundStatusPending, CreatedAt: time.Now().Add(-48 * time.Hour)}, nil)
+	useRefundMocks(t, new(MockInvoiceRepository), new(MockOrderRepository), new(MockReversalRepository), mockRefundRepo)
+
+	w := postReversalCallback(HandleB2CResult, b2cResult("AG_B2C_1", 0))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockRefundRepo.AssertNotCalled(t, "TransitionRefundStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceB2C, models.CallbackRejectReplayWindow)
+}
+
+// postResultCallback posts a reversal or B2C result to path, served by handler on
+// route and route/:token the way main.go registers it
+func postResultCallback(route, path string, handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST(route, handler)
+	router.POST(route+"/:token", handler)
+
+	httpReq := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httpReq)
+	return w
+}
+
+func TestHandleReversalResult_ValidTokenSettles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{requireToken: true})
+	auditRepo := useCallbackAudit(t)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_1", "orig-AG_1").Return(&models.ReversalRecord{ID: "rev-1", InvoiceID: "inv-1", Amount: 200, Status: models.ReversalStatusPending, CallbackTokenHash: hashCallbackToken("secret-token")}, nil)
+	mockReversalRepo.On("TransitionReversalStatus", mock.Anything, "rev-1", models.ReversalStatusPending, models.ReversalStatusFailed, 1, "Reversal processed", "REV123").Return(true, nil)
+	useReversalMocks(t, new(MockInvoiceRepository), new(MockPaymentRepository), mockReversalRepo)
+
+	w := postResultCallback("/api/v1/mpesa/reversal/result", "/api/v1/mpesa/reversal/result/secret-token", HandleReversalResult, reversalResult("AG_1", 1))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Callback received")
+	mockReversalRepo.AssertExpectations(t)
+	auditRepo.AssertNotCalled(t, "RecordRejectedCallback", mock.Anything, mock.Anything)
+}
+
+func TestHandleReversalResult_TokenMismatchRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{})
+	auditRepo := useCallbackAudit(t)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_1", "orig-AG_1").Return(&models.ReversalRecord{ID: "rev-1", InvoiceID: "inv-1", Amount: 200, Status: models.ReversalStatusPending, CallbackTokenHash: hashCallbackToken("secret-token")}, nil)
+	useReversalMocks(t, new(MockInvoiceRepository), new(MockPaymentRepository), mockReversalRepo)
+
+	w := postResultCallback("/api/v1/mpesa/reversal/result", "/api/v1/mpesa/reversal/result/guessed-token", HandleReversalResult, reversalResult("AG_1", 0))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockReversalRepo.AssertNotCalled(t, "TransitionReversalStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceReversal, models.CallbackRejectTokenMismatch)
+}
+
+func TestHandleReversalTimeout_TokenReversalNeedsToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{})
+	auditRepo := useCallbackAudit(t)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_1", "orig-AG_1").Return(&models.ReversalRecord{ID: "rev-1", InvoiceID: "inv-1", Amount: 200, Status: models.ReversalStatusPending, CallbackTokenHash: hashCallbackToken("secret-token")}, nil)
+	useReversalMocks(t, new(MockInvoiceRepository), new(MockPaymentRepository), mockReversalRepo)
+
+	// The bare route only serves reversals requested before tokens existed
+	w := postResultCallback("/api/v1/mpesa/reversal/timeout", "/api/v1/mpesa/reversal/timeout", HandleReversalTimeout, reversalResult("AG_1", 1))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockReversalRepo.AssertNotCalled(t, "TransitionReversalStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceReversal, models.CallbackRejectMissingToken)
+}
+
+func TestHandleB2CResult_TokenMismatchRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{})
+	auditRepo := useCallbackAudit(t)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundByConversationID", mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(&models.Refund{ID: "refund-1", ReversalID: "rev-1", Amount: 500, Status: models.RefundStatusPending, CallbackTokenHash: hashCallbackToken("secret-token")}, nil)
+	useRefundMocks(t, new(MockInvoiceRepository), new(MockOrderRepository), new(MockReversalRepository), mockRefundRepo)
+
+	w := postResultCallback("/api/v1/mpesa/b2c/result", "/api/v1/mpesa/b2c/result/guessed-token", HandleB2CResult, b2cResult("AG_B2C_1", 0))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockRefundRepo.AssertNotCalled(t, "TransitionRefundStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceB2C, models.CallbackRejectTokenMismatch)
+}
+
+func TestHandleB2CTimeout_LegacyRefundRequireToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useCallbackSecurity(t, callbackSecurityConfig{requireToken: true})
+	auditRepo := useCallbackAudit(t)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundByConversationID", mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(&models.Refund{ID: "refund-1", Status: models.RefundStatusPending}, nil)
+	useRefundMocks(t, new(MockInvoiceRepository), new(MockOrderRepository), new(MockReversalRepository), mockRefundRepo)
+
+	w := postResultCallback("/api/v1/mpesa/b2c/timeout", "/api/v1/mpesa/b2c/timeout", HandleB2CTimeout, b2cResult("AG_B2C_1", 1))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockRefundRepo.AssertNotCalled(t, "TransitionRefundStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceB2C, models.CallbackRejectMissingToken)
+}
+
+func TestInitiatePayment_MpesaStoresCallbackTokenHash(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var got mpesa.STKPushRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(mpesa.STKPushResponse{CheckoutRequestID: "ws_CO_1", MerchantRequestID: "m-1", ResponseCode: "0"})
+	}))
+	defer server.Close()
+
+	old := mpesaClient
+	mpesaClient = mpesa.NewClient(mpesa.Config{ConsumerKey: "key", ConsumerSecret: "secret", BusinessShortCode: "174379", PassKey: "passkey", CallbackURL: "https://shop.example/api/v1/mpesa/callback", BaseURL: server.URL})
+	t.Cleanup(func() { mpesaClient = old })
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", InvoiceAmount: 800}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", UserID: "user-1", Phone: "254712345678"}, nil)
+	var stored *models.PaymentRecord
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("CreatePaymentRecord", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*models.PaymentRecord)
+	}).Return(nil)
+	usePaymentMocks(t, mockPaymentRepo, mockInvoiceRepo, mockOrderRepo)
+
+	w := postPayment(`{"invoiceId":"inv-1"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Daraja is given the token; only its hash is stored and it is never returned
+	prefix := "https://shop.example/api/v1/mpesa/callback/"
+	assert.True(t, strings.HasPrefix(got.CallBackURL, prefix), got.CallBackURL)
+	token := strings.TrimPrefix(got.CallBackURL, prefix)
+	assert.Len(t, token, 64)
+	assert.Equal(t, hashCallbackToken(token), stored.CallbackTokenHash)
+	assert.NotContains(t, w.Body.String(), token)
+	assert.Empty(t, callbackTokenRejection(stored, token))
+}
+
+func TestAdminListRejectedCallbacks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAuditRepo := new(MockCallbackAuditRepository)
+	mockAuditRepo.On("ListRejectedCallbacks", mock.Anything, models.CallbackSourceSTKPush, 2, 5).Return([]*models.RejectedCallback{{ID: "cb-1", Source: models.CallbackSourceSTKPush, Reason: models.CallbackRejectTokenMismatch}}, nil)
+	mockAuditRepo.On("CountRejectedCallbacks", mock.Anything, models.CallbackSourceSTKPush).Return(int64(6), nil)
+	old := NewCallbackAuditRepository
+	NewCallbackAuditRepository = CallbackAuditRepository(mockAuditRepo)
+	t.Cleanup(func() { NewCallbackAuditRepository = old })
+
+	httpReq := httptest.NewRequest("GET", "/admin/payments/callbacks/rejected?source=stk_push&page=2&limit=5", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	AdminListRejectedCallbacks(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"reason":"token_mismatch"`)
+	assert.Contains(t, w.Body.String(), `"total":6`)
+	mockAuditRepo.AssertExpectations(t)
+}
+
+func TestC2BCallbackURL(t *testing.T) {
+	assert.Equal(t, "https://shop.example/api/v1/payments/c2b/confirmation", c2bCallbackURL("https://shop.example/api/v1/payments/c2b/confirmation"))
+
+	t.Setenv("MPESA_C2B_CALLBACK_TOKEN", "c2b-secret")
+	assert.Equal(t, "https://shop.example/api/v1/payments/c2b/confirmation/c2b-secret", c2bCallbackURL("https://shop.example/api/v1/payments/c2b/confirmation/"))
+	assert.Equal(t, "", c2bCallbackURL(""))
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// priceCart prices every cart line against the current product catalogue
+func priceCart(ctx context.Context, cart *models.Cart) (*models.CartView, error) {
+	productRepo := NewProductRepository
+
+	view := &models.CartView{
+		UserID:    cart.UserID,
+		Items:     []models.PricedCartItem{},
+		UpdatedAt: cart.UpdatedAt,
+	}
+
+	for _, item := range cart.Items {
+		prod, err := productRepo.GetProductByID(ctx, item.ProductID)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				// Product was removed from the catalogue since it was added
+				view.Items = append(view.Items, models.PricedCartItem{
+					ProductID:   item.ProductID,
+					Quantity:    item.Quantity,
+					Unavailable: true,
+				})
+				continue
+			}
+			return nil, err
+		}
+
+		itemCost, itemDiscount := priceItem(prod, item.Quantity)
+		view.Cost += itemCost
+		view.Discount += itemDiscount
+
+		view.Items = append(view.Items, models.PricedCartItem{
+			ProductID: prod.ID,
+			Name:      prod.Name,
+			Quantity:  item.Quantity,
+			Price:     prod.Price,
+			Discount:  itemDiscount,
+			LineTotal: itemCost - itemDiscount,
+			Available: prod.Stock,
+		})
+	}
+
+	view.TotalCost = view.Cost - view.Discount
+	if view.TotalCost < 0 {
+		view.TotalCost = 0
+	}
+
+	return view, nil
+}
+
+// respondWithCart loads, prices and returns the user's cart
+func respondWithCart(c *gin.Context, userID string) {
+	cartRepo := NewCartRepository
+	cart, err := cartRepo.GetCartByUser(context.Background(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve cart"})
+		return
+	}
+
+	view, err := priceCart(context.Background(), cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, view)
+}
+
+// GetCart returns the authenticated user's cart with live prices
+func GetCart(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	respondWithCart(c, userID.(string))
+}
+
+// AddCartItem adds units of a product to the authenticated user's cart
+func AddCartItem(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req models.AddCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Only allow products that exist in the catalogue
+	productRepo := NewProductRepository
+	if _, err := productRepo.GetProductByID(context.Background(), req.ProductID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve product"})
+		return
+	}
+
+	cartRepo := NewCartRepository
+	if err := cartRepo.AddItem(context.Background(), userID.(string), req.ProductID, req.Quantity); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add item to cart"})
+		return
+	}
+
+	respondWithCart(c, userID.(string))
+}
+
+// UpdateCartItem sets the quantity of a product in the authenticated user's cart
+func UpdateCartItem(c *gin.Context) {
+	productID := c.Param("productId")
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req models.UpdateCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cartRepo := NewCartRepository
+	if err := cartRepo.UpdateItemQuantity(context.Background(), userID.(string), productID, req.Quantity); err != nil {
+		if err.Error() == "cart item not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cart item not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update cart item"})
+		return
+	}
+
+	respondWithCart(c, userID.(string))
+}
+
+// RemoveCartItem removes a product from the authenticated user's cart
+func RemoveCartItem(c *gin.Context) {
+	productID := c.Param("productId")
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	cartRepo := NewCartRepository
+	if err := cartRepo.RemoveItem(context.Background(), userID.(string), productID); err != nil {
+		if err.Error() == "cart item not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cart item not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove cart item"})
+		return
+	}
+
+	respondWithCart(c, userID.(string))
+}
+
+// CheckoutCart converts the authenticated user's cart into an order and invoice
+func CheckoutCart(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req models.CheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cartRepo := NewCartRepository
+	cart, err := cartRepo.GetCartByUser(context.Background(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve cart"})
+		return
+	}
+
+	if len(cart.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cart is empty"})
+		return
+	}
+
+	metadata := models.OrderMetadata{}
+	if req.Metadata != nil {
+		metadata = *req.Metadata
+	}
+
+	lines := make([]orderLine, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		lines = append(lines, orderLine{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+
+	order, oerr := placeOrder(context.Background(), userID.(string), req.Phone, req.AddressID, lines, req.CouponCode, req.PaymentProvider, metadata)
+	if oerr != nil {
+		c.JSON(oerr.Status, oerr.Body)
+		return
+	}
+
+	// The order is placed; a failure to clear the cart should not fail checkout
+	_ = cartRepo.ClearCart(context.Background(), userID.(string))
+
+	c.JSON(http.StatusCreated, order)
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestGetCart_NotAuthenticated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	httpReq := httptest.NewRequest("GET", "/cart", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	GetCart(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestGetCart_LivePricing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	goneID := uuid.New().String()
+
+	mockCartRepo := new(MockCartRepository)
+	mockCartRepo.On("GetCartByUser", mock.Anything, userID).Return(&models.Cart{
+		UserID: userID,
+		Items: []models.CartItem{
+			{ProductID: productID, Quantity: 2},
+			{ProductID: goneID, Quantity: 1},
+		},
+	}, nil)
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(&models.Product{
+		ID: productID, Name: "Kettle", Price: 100, Discount: 10, Stock: 4,
+	}, nil)
+	mockProductRepo.On("GetProductByID", mock.Anything, goneID).Return(nil, mongo.ErrNoDocuments)
+
+	oldCartRepo := NewCartRepository
+	oldProductRepo := NewProductRepository
+	NewCartRepository = CartRepository(mockCartRepo)
+	NewProductRepository = ProductRepository(mockProductRepo)
+	defer func() {
+		NewCartRepository = oldCartRepo
+		NewProductRepository = oldProductRepo
+	}()
+
+	httpReq := httptest.NewRequest("GET", "/cart", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	GetCart(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var view models.CartView
+	json.Unmarshal(w.Body.Bytes(), &view)
+	assert.Equal(t, 200.0, view.Cost)
+	assert.Equal(t, 20.0, view.Discount)
+	assert.Equal(t, 180.0, view.TotalCost)
+	assert.Len(t, view.Items, 2)
+	assert.Equal(t, 4, view.Items[0].Available)
+	assert.True(t, view.Items[1].Unavailable)
+}
+
+func TestAddCartItem_ProductNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(nil, mongo.ErrNoDocuments)
+
+	mockCartRepo := new(MockCartRepository)
+
+	oldCartRepo := NewCartRepository
+	oldProductRepo := NewProductRepository
+	NewCartRepository = CartRepository(mockCartRepo)
+	NewProductRepository = ProductRepository(mockProductRepo)
+	defer func() {
+		NewCartRepository = oldCartRepo
+		NewProductRepository = oldProductRepo
+	}()
+
+	body, _ := json.Marshal(models.AddCartItemRequest{ProductID: productID, Quantity: 1})
+	httpReq := httptest.NewRequest("POST", "/cart/items", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	AddCartItem(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockCartRepo.AssertNotCalled(t, "AddItem", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAddCartItem_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	product := &models.Product{ID: productID, Name: "Mug", Price: 50, Stock: 10}
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(product, nil)
+
+	mockCartRepo := new(MockCartRepository)
+	mockCartRepo.On("AddItem", mock.Anything, userID, productID, 3).Return(nil)
+	mockCartRepo.On("GetCartByUser", mock.Anything, userID).Return(&models.Cart{
+		UserID: userID,
+		Items:  []models.CartItem{{ProductID: productID, Quantity: 3}},
+	}, nil)
+
+	oldCartRepo := NewCartRepository
+	oldProductRepo := NewProductRepository
+	NewCartRepository = CartRepository(mockCartRepo)
+	NewProductRepository = ProductRepository(mockProductRepo)
+	defer func() {
+		NewCartRepository = oldCartRepo
+		NewProductRepository = oldProductRepo
+	}()
+
+	body, _ := json.Marshal(models.AddCartItemRequest{ProductID: productID, Quantity: 3})
+	httpReq := httptest.NewRequest("POST", "/cart/items", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	AddCartItem(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var view models.CartView
+	json.Unmarshal(w.Body.Bytes(), &view)
+	assert.Equal(t, 150.0, view.TotalCost)
+	mockCartRepo.AssertExpectations(t)
+}
+
+func TestUpdateCartItem_NotInCart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+
+	mockCartRepo := new(MockCartRepository)
+	mockCartRepo.On("UpdateItemQuantity", mock.Anything, userID, "p1", 2).Return(errors.New("cart item not found"))
+
+	oldCartRepo := NewCartRepository
+	NewCartRepository = CartRepository(mockCartRepo)
+	defer func() { NewCartRepository = oldCartRepo }()
+
+	httpReq := httptest.NewRequest("PUT", "/cart/items/p1", bytes.NewBuffer([]byte(`{"quantity":2}`)))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "productId", Value: "p1"}}
+	c.Set("userID", userID)
+
+	UpdateCartItem(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRemoveCartItem_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+
+	mockCartRepo := new(MockCartRepository)
+	mockCartRepo.On("RemoveItem", mock.Anything, userID, "p1").Return(nil)
+	mockCartRepo.On("GetCartByUser", mock.Anything, userID).Return(&models.Cart{UserID: userID}, nil)
+
+	oldCartRepo := NewCartRepository
+	NewCartRepository = CartRepository(mockCartRepo)
+	defer func() { NewCartRepository = oldCartRepo }()
+
+	httpReq := httptest.NewRequest("DELETE", "/cart/items/p1", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "productId", Value: "p1"}}
+	c.Set("userID", userID)
+
+	RemoveCartItem(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockCartRepo.AssertExpectations(t)
+}
+
+func TestCheckoutCart_EmptyCart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+
+	mockCartRepo := new(MockCartRepository)
+	mockCartRepo.On("GetCartByUser", mock.Anything, userID).Return(&models.Cart{UserID: userID}, nil)
+
+	oldCartRepo := NewCartRepository
+	NewCartRepository = CartRepository(mockCartRepo)
+	defer func() { NewCartRepository = oldCartRepo }()
+
+	httpReq := httptest.NewRequest("POST", "/cart/checkout", bytes.NewBuffer([]byte(`{"phone":"254712345678"}`)))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	CheckoutCart(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCheckoutCart_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	product := &models.Product{ID: productID, Name: "Mug", Price: 50, Discount: 20, Stock: 10}
+
+	mockCartRepo := new(MockCartRepository)
+	mockCartRepo.On("GetCartByUser", mock.Anything, userID).Return(&models.Cart{
+		UserID: userID,
+		Items:  []models.CartItem{{ProductID: productID, Quantity: 2}},
+	}, nil)
+	mockCartRepo.On("ClearCart", mock.Anything, userID).Return(nil)
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(product, nil)
+	mockProductRepo.On("ReserveStock", mock.Anything, productID, 2).Return(nil)
+
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("CreateOrder", mock.Anything, mock.MatchedBy(func(o *models.Order) bool {
+		return o.UserID == userID && o.Cost == 100 && o.Discount == 20 && o.TotalCost == 80
+	})).Return(nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("CreateInvoice", mock.Anything, mock.MatchedBy(func(inv *models.Invoice) bool {
+		return inv.InvoiceAmount == 80
+	})).Return(nil)
+
+	oldCartRepo := NewCartRepository
+	oldProductRepo := NewProductRepository
+	oldOrderRepo := NewOrderRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	NewCartRepository = CartRepository(mockCartRepo)
+	NewProductRepository = ProductRepository(mockProductRepo)
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	defer func() {
+		NewCartRepository = oldCartRepo
+		NewProductRepository = oldProductRepo
+		NewOrderRepository = oldOrderRepo
+		NewInvoiceRepository = oldInvoiceRepo
+	}()
+
+	httpReq := httptest.NewRequest("POST", "/cart/checkout", bytes.NewBuffer([]byte(`{"phone":"254712345678"}`)))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	CheckoutCart(c)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	mockCartRepo.AssertExpectations(t)
+	mockOrderRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertExpectations(t)
+}
+
+func TestCheckoutCart_InsufficientStockKeepsCart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+
+	mockCartRepo := new(MockCartRepository)
+	mockCartRepo.On("GetCartByUser", mock.Anything, userID).Return(&models.Cart{
+		UserID: userID,
+		Items:  []models.CartItem{{ProductID: productID, Quantity: 5}},
+	}, nil)
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(&models.Product{ID: productID, Price: 10, Stock: 2}, nil)
+
+	oldCartRepo := NewCartRepository
+	oldProductRepo := NewProductRepository
+	NewCartRepository = CartRepository(mockCartRepo)
+	NewProductRepository = ProductRepository(mockProductRepo)
+	defer func() {
+		NewCartRepository = oldCartRepo
+		NewProductRepository = oldProductRepo
+	}()
+
+	httpReq := httptest.NewRequest("POST", "/cart/checkout", bytes.NewBuffer([]byte(`{"phone":"254712345678"}`)))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	CheckoutCart(c)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mockCartRepo.AssertNotCalled(t, "ClearCart", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// quoteDelivery works out the fee and expected delivery time for sending an order
+// placed now to one of the user's addresses. The returned delivery holds a copy of
+// the address so the order keeps it if the address book changes.
+func quoteDelivery(ctx context.Context, userID, addressID string, now time.Time) (*models.OrderDelivery, *orderError) {
+	address, err := NewAddressRepository.GetAddress(ctx, userID, addressID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, &orderError{http.StatusBadRequest, gin.H{"error": "address not found"}}
+		}
+		return nil, &orderError{http.StatusInternalServerError, gin.H{"error": "failed to retrieve address"}}
+	}
+
+	zones, err := NewDeliveryZoneRepository.ListDeliveryZones(ctx, true)
+	if err != nil {
+		return nil, &orderError{http.StatusInternalServerError, gin.H{"error": "failed to retrieve delivery zones"}}
+	}
+	zone := models.MatchDeliveryZone(zones, address)
+	if zone == nil {
+		return nil, &orderError{http.StatusBadRequest, gin.H{"error": "we do not deliver to this address", "county": address.County, "town": address.Town}}
+	}
+
+	return &models.OrderDelivery{
+		AddressID:     address.ID,
+		Address:       address,
+		ZoneID:        zone.ID,
+		ZoneName:      zone.Name,
+		Fee:           zone.Fee,
+		LeadTimeHours: zone.LeadTimeHours,
+		ExpectedBy:    now.Add(time.Duration(zone.LeadTimeHours) * time.Hour),
+	}, nil
+}
+
+// GetDeliveryQuote returns the delivery fee and expected delivery time for an
+// order sent now to one of the signed-in user's addresses
+func GetDeliveryQuote(c *gin.Context) {
+	delivery, oerr := quoteDelivery(c.Request.Context(), c.GetString("userID"), c.Param("id"), time.Now())
+	if oerr != nil {
+		if oerr.Body["error"] == "address not found" {
+			oerr.Status = http.StatusNotFound
+		}
+		c.JSON(oerr.Status, oerr.Body)
+		return
+	}
+
+	delivery.Address = nil
+	c.JSON(http.StatusOK, delivery)
+}
+
+// ListDeliveryZones lists the zones delivered to, with their fees and lead times
+func ListDeliveryZones(c *gin.Context) {
+	zones, err := NewDeliveryZoneRepository.ListDeliveryZones(c.Request.Context(), true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve delivery zones"})
+		return
+	}
+	if zones == nil {
+		zones = []*models.DeliveryZone{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": zones})
+}
+
+// AdminListDeliveryZones lists every delivery zone, including inactive ones
+func AdminListDeliveryZones(c *gin.Context) {
+	zones, err := NewDeliveryZoneRepository.ListDeliveryZones(c.Request.Context(), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve delivery zones"})
+		return
+	}
+	if zones == nil {
+		zones = []*models.DeliveryZone{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": zones})
+}
+
+// newDeliveryZone builds a zone from a request, trimming names and dropping blank
+// towns. Zones are active unless the request says otherwise.
+func newDeliveryZone(id string, req *models.DeliveryZoneRequest) *models.DeliveryZone {
+	zone := &models.DeliveryZone{
+		ID:            id,
+		Name:          strings.TrimSpace(req.Name),
+		County:        strings.TrimSpace(req.County),
+		Towns:         []string{},
+		Fee:           req.Fee,
+		LeadTimeHours: req.LeadTimeHours,
+		Active:        req.Active == nil || *req.Active,
+	}
+	for _, town := range req.Towns {
+		if town = strings.TrimSpace(town); town != "" {
+			zone.Towns = append(zone.Towns, town)
+		}
+	}
+	return zone
+}
+
+// AdminCreateDeliveryZone adds a delivery zone
+func AdminCreateDeliveryZone(c *gin.Context) {
+	var req models.DeliveryZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zone := newDeliveryZone(uuid.New().String(), &req)
+	if err := NewDeliveryZoneRepository.CreateDeliveryZone(c.Request.Context(), zone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create delivery zone"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, zone)
+}
+
+// AdminUpdateDeliveryZone replaces a delivery zone's settings. Orders already
+// placed keep the fee and lead time they were quoted.
+func AdminUpdateDeliveryZone(c *gin.Context) {
+	var req models.DeliveryZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zoneRepo := NewDeliveryZoneRepository
+	zone := newDeliveryZone(c.Param("id"), &req)
+	updated, err := zoneRepo.UpdateDeliveryZone(c.Request.Context(), zone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update delivery zone"})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{"error": "delivery zone not found"})
+		return
+	}
+
+	zone, err = zoneRepo.GetDeliveryZone(c.Request.Context(), zone.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve delivery zone"})
+		return
+	}
+	c.JSON(http.StatusOK, zone)
+}
+
+// AdminDeleteDeliveryZone removes a delivery zone
+func AdminDeleteDeliveryZone(c *gin.Context) {
+	deleted, err := NewDeliveryZoneRepository.DeleteDeliveryZone(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete delivery zone"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "delivery zone not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "delivery zone deleted"})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func nairobiZones() []*models.DeliveryZone {
+	return []*models.DeliveryZone{
+		{ID: "zone-nairobi", Name: "Nairobi", County: "Nairobi", Fee: 350, LeadTimeHours: 48, Active: true},
+		{ID: "zone-cbd", Name: "Nairobi CBD & Kilimani", County: "nairobi", Towns: []string{"CBD", "kilimani"}, Fee: 150, LeadTimeHours: 24, Active: true},
+	}
+}
+
+func TestMatchDeliveryZone(t *testing.T) {
+	zones := nairobiZones()
+
+	// A zone naming the town beats the county-wide one, whatever the order
+	assert.Equal(t, "zone-cbd", models.MatchDeliveryZone(zones, homeAddress()).ID)
+	assert.Equal(t, "zone-nairobi", models.MatchDeliveryZone(zones, &models.Address{County: "Nairobi", Town: "Karen"}).ID)
+	assert.Nil(t, models.MatchDeliveryZone(zones, &models.Address{County: "Mombasa", Town: "Nyali"}))
+
+	zones[1].Active = false
+	assert.Equal(t, "zone-nairobi", models.MatchDeliveryZone(zones, homeAddress()).ID)
+}
+
+func TestGetDeliveryQuote(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	addresses, zones := useAddressRepos(t)
+	addresses.On("GetAddress", mock.Anything, "user-1", "addr-1").Return(homeAddress(), nil)
+	addresses.On("GetAddress", mock.Anything, "user-1", "addr-2").
+		Return(&models.Address{ID: "addr-2", UserID: "user-1", County: "Turkana", Town: "Lodwar"}, nil)
+	addresses.On("GetAddress", mock.Anything, "user-1", "addr-9").Return(nil, mongo.ErrNoDocuments)
+	zones.On("ListDeliveryZones", mock.Anything, true).Return(nairobiZones(), nil)
+
+	w := asCustomer("GET", "/addresses/:id/delivery", "/addresses/addr-1/delivery", GetDeliveryQuote, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var quote models.OrderDelivery
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &quote))
+	assert.Equal(t, "zone-cbd", quote.ZoneID)
+	assert.Equal(t, 150.0, quote.Fee)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), quote.ExpectedBy, time.Minute)
+	assert.Nil(t, quote.Address)
+
+	w = asCustomer("GET", "/addresses/:id/delivery", "/addresses/addr-2/delivery", GetDeliveryQuote, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = asCustomer("GET", "/addresses/:id/delivery", "/addresses/addr-9/delivery", GetDeliveryQuote, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateOrder_WithDeliveryAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	addresses, zones := useAddressRepos(t)
+	addresses.On("GetAddress", mock.Anything, "user-1", "addr-1").Return(homeAddress(), nil)
+	zones.On("ListDeliveryZones", mock.Anything, true).Return(nairobiZones(), nil)
+
+	products, orders, invoices := new(MockProductRepository), new(MockOrderRepository), new(MockInvoiceRepository)
+	oldProducts, oldOrders, oldInvoices := NewProductRepository, NewOrderRepository, NewInvoiceRepository
+	NewProductRepository, NewOrderRepository, NewInvoiceRepository = products, orders, invoices
+	t.Cleanup(func() {
+		NewProductRepository, NewOrderRepository, NewInvoiceRepository = oldProducts, oldOrders, oldInvoices
+	})
+
+	products.On("GetProductByID", mock.Anything, "prod-1").Return(&models.Product{ID: "prod-1", Price: 100, Stock: 5}, nil)
+	products.On("ReserveStock", mock.Anything, "prod-1", 2).Return(nil)
+	orders.On("CreateOrder", mock.Anything, mock.MatchedBy(func(o *models.Order) bool {
+		return o.DeliveryFee == 150 && o.TotalCost == 350 && o.Delivery != nil && o.Delivery.Address.ID == "addr-1" &&
+			o.Metadata.LocationDetails == "Apt 4B, Yaya Centre, Kilimani, Nairobi"
+	})).Return(nil)
+	invoices.On("CreateInvoice", mock.Anything, mock.MatchedBy(func(inv *models.Invoice) bool {
+		return inv.InvoiceAmount == 350 && inv.DeliveryFee == 150
+	})).Return(nil)
+
+	req := models.CreateOrderRequest{Phone: "254712345678", AddressID: "addr-1"}
+	req.Products = append(req.Products, struct {
+		ProductID string `json:"productId" binding:"required"`
+		Quantity  int    `json:"quantity" binding:"required,gt=0"`
+	}{ProductID: "prod-1", Quantity: 2})
+	w := asUser(CreateOrder, "user-1", req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	orders.AssertExpectations(t)
+	invoices.AssertExpectations(t)
+}
+
+func TestCreateOrder_UndeliverableAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	addresses, zones := useAddressRepos(t)
+	addresses.On("GetAddress", mock.Anything, "user-1", "addr-2").
+		Return(&models.Address{ID: "addr-2", UserID: "user-1", County: "Turkana", Town: "Lodwar"}, nil)
+	zones.On("ListDeliveryZones", mock.Anything, true).Return(nairobiZones(), nil)
+
+	products, orders := new(MockProductRepository), new(MockOrderRepository)
+	oldProducts, oldOrders := NewProductRepository, NewOrderRepository
+	NewProductRepository, NewOrderRepository = products, orders
+	t.Cleanup(func() { NewProductRepository, NewOrderRepository = oldProducts, oldOrders })
+	products.On("GetProductByID", mock.Anything, "prod-1").Return(&models.Product{ID: "prod-1", Price: 100, Stock: 5}, nil)
+
+	req := models.CreateOrderRequest{Phone: "254712345678", AddressID: "addr-2"}
+	req.Products = append(req.Products, struct {
+		ProductID string `json:"productId" binding:"required"`
+		Quantity  int    `json:"quantity" binding:"required,gt=0"`
+	}{ProductID: "prod-1", Quantity: 1})
+	w := asUser(CreateOrder, "user-1", req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "we do not deliver to this address")
+	products.AssertNotCalled(t, "ReserveStock", mock.Anything, mock.Anything, mock.Anything)
+	orders.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestAdminDeliveryZones(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, zones := useAddressRepos(t)
+	zones.On("CreateDeliveryZone", mock.Anything, mock.MatchedBy(func(z *models.DeliveryZone) bool {
+		return z.Name == "Mombasa Island" && z.Active && len(z.Towns) == 1 && z.Towns[0] == "Mombasa Island"
+	})).Return(nil)
+	zones.On("UpdateDeliveryZone", mock.Anything, mock.MatchedBy(func(z *models.DeliveryZone) bool { return z.ID == "zone-9" })).Return(false, nil)
+	zones.On("DeleteDeliveryZone", mock.Anything, "zone-1").Return(true, nil)
+
+	w := asAdmin("POST", "/admin/delivery-zones", "/admin/delivery-zones", AdminCreateDeliveryZone, models.DeliveryZoneRequest{
+		Name: "Mombasa Island", County: "Mombasa", Towns: []string{" Mombasa Island ", " "}, Fee: 200, LeadTimeHours: 72,
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = asAdmin("POST", "/admin/delivery-zones", "/admin/delivery-zones", AdminCreateDeliveryZone, models.DeliveryZoneRequest{
+		Name: "Free", County: "Mombasa", Fee: -1,
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = asAdmin("PUT", "/admin/delivery-zones/:id", "/admin/delivery-zones/zone-9", AdminUpdateDeliveryZone, models.DeliveryZoneRequest{
+		Name: "Gone", County: "Kisumu",
+	})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = asAdmin("DELETE", "/admin/delivery-zones/:id", "/admin/delivery-zones/zone-1", AdminDeleteDeliveryZone, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	zones.AssertExpectations(t)
+}
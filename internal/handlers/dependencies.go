@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/database"
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
@@ -16,6 +17,19 @@ type OrderRepository interface {
 	GetOrderCountByUser(ctx context.Context, userID string) (int64, error)
 	GetOrderCount(ctx context.Context) (int64, error)
 	UpdateOrderStatus(ctx context.Context, orderID string, status string) error
+	ReleaseStockReservation(ctx context.Context, orderID string) (bool, error)
+	ReleasePromotion(ctx context.Context, orderID string) (bool, error)
+	ClaimShipmentVersion(ctx context.Context, orderID string, version int) (bool, error)
+	CountOpenOrdersByUser(ctx context.Context, userID string) (int64, error)
+	AnonymiseOrdersByUser(ctx context.Context, userID string) (int64, error)
+}
+
+type CartRepository interface {
+	GetCartByUser(ctx context.Context, userID string) (*models.Cart, error)
+	AddItem(ctx context.Context, userID string, productID string, quantity int) error
+	UpdateItemQuantity(ctx context.Context, userID string, productID string, quantity int) error
+	RemoveItem(ctx context.Context, userID string, productID string) error
+	ClearCart(ctx context.Context, userID string) error
 }
 
 type InvoiceRepository interface {
@@ -27,6 +41,8 @@ type InvoiceRepository interface {
 	ReversePaymentAmount(ctx context.Context, invoiceID string, amount float64, dateStr string) error
 	GetInvoicesByType(ctx context.Context, invoiceType string, page int, limit int) ([]*models.Invoice, error)
 	GetInvoiceCount(ctx context.Context) (int64, error)
+	GetInvoicesByUser(ctx context.Context, userID string, page int, limit int) ([]*models.Invoice, error)
+	CountInvoicesByUser(ctx context.Context, userID string) (int64, error)
 }
 
 type UserRepository interface {
@@ -35,6 +51,23 @@ type UserRepository interface {
 	FindUserByEmail(ctx context.Context, email string) (*models.User, error)
 	FindUserByID(ctx context.Context, userID string) (*models.User, error)
 	UpdateUser(ctx context.Context, userID string, user *models.User) error
+	UpdatePassword(ctx context.Context, userID string, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, userID string, email string) (bool, error)
+	SetPendingTwoFactor(ctx context.Context, userID string, secret string) error
+	EnableTwoFactor(ctx context.Context, userID string, secret string, recoveryCodeHashes []string, step int64) (bool, error)
+	DisableTwoFactor(ctx context.Context, userID string) error
+	UseTwoFactorStep(ctx context.Context, userID string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID string, recoveryCodeHashes []string) error
+	SetUserRoles(ctx context.Context, userID string, roles []string) error
+	CountUsersWithRole(ctx context.Context, role string) (int64, error)
+	CountActiveUsersWithRole(ctx context.Context, role string) (int64, error)
+	SearchUsers(ctx context.Context, query models.UserSearchQuery, page int, limit int) ([]*models.User, error)
+	CountUsers(ctx context.Context, query models.UserSearchQuery) (int64, error)
+	SuspendUser(ctx context.Context, userID string, suspension *models.Suspension) (bool, error)
+	ReactivateUser(ctx context.Context, userID string) (bool, error)
+	UpdateProfile(ctx context.Context, userID string, updates map[string]interface{}) error
+	AnonymiseUser(ctx context.Context, userID string, deletedAt time.Time) (bool, error)
 	DeleteUser(ctx context.Context, userID string) error
 }
 
@@ -46,34 +79,187 @@ type ProductRepository interface {
 	UpdateProduct(ctx context.Context, productID string, updates map[string]interface{}) error
 	DeleteProduct(ctx context.Context, productID string) error
 	GetProductCount(ctx context.Context) (int64, error)
+	ReserveStock(ctx context.Context, productID string, quantity int) error
+	ReleaseStock(ctx context.Context, productID string, quantity int) error
+	AdjustStock(ctx context.Context, productID string, delta int) (*models.Product, error)
+}
+
+type InventoryRepository interface {
+	CreateStockAdjustment(ctx context.Context, adj *models.StockAdjustment) error
+	GetStockAdjustmentsByProduct(ctx context.Context, productID string, page int, limit int) ([]*models.StockAdjustment, error)
+}
+
+type PromotionRepository interface {
+	CreatePromotion(ctx context.Context, promotion *models.Promotion) error
+	GetPromotionByID(ctx context.Context, promotionID string) (*models.Promotion, error)
+	GetPromotionByCode(ctx context.Context, code string) (*models.Promotion, error)
+	GetAllPromotions(ctx context.Context, page int, limit int) ([]*models.Promotion, error)
+	UpdatePromotion(ctx context.Context, promotionID string, updates map[string]interface{}) error
+	ClaimRedemption(ctx context.Context, promotionID string, userID string, perUserLimit int) error
+	ReleaseRedemption(ctx context.Context, promotionID string, userID string) error
+	CountUserRedemptions(ctx context.Context, promotionID string, userID string) (int64, error)
+	CreateRedemption(ctx context.Context, redemption *models.PromotionRedemption) error
+	DeleteRedemption(ctx context.Context, redemptionID string) error
+	GetRedemptionsByPromotion(ctx context.Context, promotionID string, page int, limit int) ([]*models.PromotionRedemption, error)
 }
 
 type PaymentRepository interface {
 	CreatePaymentRecord(ctx context.Context, payment *models.PaymentRecord) error
 	GetPaymentByCheckoutRequestID(ctx context.Context, checkoutRequestID string) (*models.PaymentRecord, error)
+	GetPaymentByID(ctx context.Context, paymentID string) (*models.PaymentRecord, error)
 	GetPaymentByInvoiceID(ctx context.Context, invoiceID string) (*models.PaymentRecord, error)
+	GetPaymentsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.PaymentRecord, error)
+	SearchPayments(ctx context.Context, query models.PaymentSearchQuery, page int, limit int) ([]*models.PaymentRecord, error)
+	CountPayments(ctx context.Context, query models.PaymentSearchQuery) (int64, error)
+	GetStaleInitiatedPayments(ctx context.Context, olderThan time.Time, limit int) ([]*models.PaymentRecord, error)
 	UpdatePaymentStatus(ctx context.Context, checkoutID string, status string, receiptNum string, transDate string) error
+	TransitionPaymentStatus(ctx context.Context, checkoutID string, fromStatus string, toStatus string, settlement *models.PaymentSettlement) (bool, error)
+	GetPaymentsNeedingReview(ctx context.Context, page int, limit int) ([]*models.PaymentRecord, error)
+	MarkPaymentReviewed(ctx context.Context, paymentID string, adminID string, note string) error
 	ReversePaymentsByInvoiceID(ctx context.Context, invoiceID string) error
 }
 
+type C2BRepository interface {
+	CreateC2BTransaction(ctx context.Context, txn *models.C2BTransaction) error
+	DeleteC2BTransaction(ctx context.Context, id string) error
+	GetC2BTransactionByID(ctx context.Context, id string) (*models.C2BTransaction, error)
+	GetC2BTransactionsByStatus(ctx context.Context, status string, page int, limit int) ([]*models.C2BTransaction, error)
+	AllocateC2BTransaction(ctx context.Context, id string, invoiceID string, adminID string, note string) error
+	ReleaseC2BAllocation(ctx context.Context, id string) error
+}
+
 type ReversalRepository interface {
 	CreateReversalRecord(ctx context.Context, record *models.ReversalRecord) error
+	GetReversalByConversationID(ctx context.Context, conversationID, originatorConversationID string) (*models.ReversalRecord, error)
+	TransitionReversalStatus(ctx context.Context, reversalID, fromStatus, toStatus string, resultCode int, resultDesc, resultTransactionID string) (bool, error)
+	GetReversalsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.ReversalRecord, error)
+	AddRefundedAmount(ctx context.Context, reversalID string, amount float64) error
+}
+
+type RefundRepository interface {
+	CreateRefund(ctx context.Context, refund *models.Refund) error
+	SetRefundReferences(ctx context.Context, refundID, conversationID, originatorConversationID string) error
+	GetRefundByConversationID(ctx context.Context, conversationID, originatorConversationID string) (*models.Refund, error)
+	GetRefundsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.Refund, error)
+	TransitionRefundStatus(ctx context.Context, refundID, fromStatus, toStatus string, result *models.RefundResult) (bool, error)
+}
+
+type CallbackAuditRepository interface {
+	RecordRejectedCallback(ctx context.Context, entry *models.RejectedCallback) error
+	ListRejectedCallbacks(ctx context.Context, source string, page int, limit int) ([]*models.RejectedCallback, error)
+	CountRejectedCallbacks(ctx context.Context, source string) (int64, error)
+}
+
+type TokenRepository interface {
+	BlacklistToken(ctx context.Context, token string, expiresAt time.Time) error
+	IsTokenBlacklisted(ctx context.Context, token string) (bool, error)
+}
+
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetSessionByID(ctx context.Context, sessionID string) (*models.Session, error)
+	RotateRefreshToken(ctx context.Context, sessionID, refreshTokenHash string, rotation *models.SessionRotation) (bool, error)
+	ListActiveSessions(ctx context.Context, userID string) ([]*models.Session, error)
+	RevokeSession(ctx context.Context, sessionID, reason string) (bool, error)
+}
+
+type AccountTokenRepository interface {
+	CreateAccountToken(ctx context.Context, token *models.AccountToken) error
+	GetAccountToken(ctx context.Context, tokenHash string) (*models.AccountToken, error)
+	MarkAccountTokenUsed(ctx context.Context, tokenHash string) (bool, error)
+	InvalidateAccountTokens(ctx context.Context, userID, purpose string) error
+}
+
+type LoginThrottleRepository interface {
+	GetLoginThrottles(ctx context.Context, keys []string) ([]*models.LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, scope, subject string, window time.Duration) (*models.LoginThrottle, error)
+	LockLogin(ctx context.Context, key string, until time.Time, window time.Duration) error
+	ClearLoginThrottle(ctx context.Context, key string) (bool, error)
+	ListLockedLogins(ctx context.Context) ([]*models.LoginThrottle, error)
+}
+
+type AuthEventRepository interface {
+	RecordAuthEvent(ctx context.Context, event *models.AuthEvent) error
+	ListAuthEvents(ctx context.Context, eventType, userID string, page, limit int) ([]*models.AuthEvent, error)
+	CountAuthEvents(ctx context.Context, eventType, userID string) (int64, error)
+}
+
+type RoleRepository interface {
+	EnsureRoles(ctx context.Context, roles []*models.Role) error
+	CreateRole(ctx context.Context, role *models.Role) error
+	GetRole(ctx context.Context, roleID string) (*models.Role, error)
+	GetRoles(ctx context.Context, roleIDs []string) ([]*models.Role, error)
+	ListRoles(ctx context.Context) ([]*models.Role, error)
+	UpdateRole(ctx context.Context, roleID string, description string, permissions []string) (bool, error)
+	DeleteRole(ctx context.Context, roleID string) (bool, error)
+}
+
+type AddressRepository interface {
+	CreateAddress(ctx context.Context, address *models.Address) error
+	GetAddress(ctx context.Context, userID, addressID string) (*models.Address, error)
+	ListAddresses(ctx context.Context, userID string) ([]*models.Address, error)
+	CountAddresses(ctx context.Context, userID string) (int64, error)
+	UpdateAddress(ctx context.Context, userID string, address *models.Address) (bool, error)
+	SetDefaultAddress(ctx context.Context, userID, addressID string) (bool, error)
+	DeleteAddress(ctx context.Context, userID, addressID string) (bool, error)
+	DeleteAddressesByUser(ctx context.Context, userID string) (int64, error)
+}
+
+type DeliveryZoneRepository interface {
+	CreateDeliveryZone(ctx context.Context, zone *models.DeliveryZone) error
+	GetDeliveryZone(ctx context.Context, zoneID string) (*models.DeliveryZone, error)
+	ListDeliveryZones(ctx context.Context, activeOnly bool) ([]*models.DeliveryZone, error)
+	UpdateDeliveryZone(ctx context.Context, zone *models.DeliveryZone) (bool, error)
+	DeleteDeliveryZone(ctx context.Context, zoneID string) (bool, error)
+}
+
+type ShipmentRepository interface {
+	CreateShipment(ctx context.Context, shipment *models.Shipment) error
+	DeleteShipment(ctx context.Context, shipmentID string) error
+	GetShipment(ctx context.Context, shipmentID string) (*models.Shipment, error)
+	ListShipmentsByOrder(ctx context.Context, orderID string) ([]*models.Shipment, error)
+	UpdateShipmentTracking(ctx context.Context, shipmentID string, updates map[string]interface{}) (bool, error)
+	DispatchShipment(ctx context.Context, shipmentID, status, deliveryCode string, dispatchedAt time.Time) (bool, error)
+	RecordDeliveryCodeFailure(ctx context.Context, shipmentID string) error
+	DeliverShipment(ctx context.Context, shipmentID string, proof *models.ProofOfDelivery) (bool, error)
+	FailShipment(ctx context.Context, shipmentID, reason string, failedAt time.Time) (bool, error)
+	SaveSignature(ctx context.Context, signature *models.DeliverySignature) error
+	GetSignature(ctx context.Context, shipmentID string) (*models.DeliverySignature, error)
+	AnonymiseShipmentsByUser(ctx context.Context, userID string) (int64, error)
 }
 
 type ReportRepository interface {
 	GetSummaryReport(ctx context.Context, startDate, endDate string) (*models.SummaryReport, error)
 	GetDailyBreakdown(ctx context.Context, startDate, endDate string) ([]models.DailySalesReport, error)
+	GetPromotionReport(ctx context.Context, startDate, endDate string) ([]models.PromotionReport, error)
+	GetRefundReport(ctx context.Context, startDate, endDate string) (*models.RefundReport, error)
 }
 
 // DI variables - can be overridden in tests before handlers are called
 var (
-	NewOrderRepository    OrderRepository
-	NewInvoiceRepository  InvoiceRepository
-	NewUserRepository     UserRepository
-	NewProductRepository  ProductRepository
-	NewPaymentRepository  PaymentRepository
-	NewReversalRepository ReversalRepository
-	NewReportRepository   ReportRepository
+	NewOrderRepository         OrderRepository
+	NewCartRepository          CartRepository
+	NewInvoiceRepository       InvoiceRepository
+	NewUserRepository          UserRepository
+	NewProductRepository       ProductRepository
+	NewPaymentRepository       PaymentRepository
+	NewC2BRepository           C2BRepository
+	NewReversalRepository      ReversalRepository
+	NewRefundRepository        RefundRepository
+	NewReportRepository        ReportRepository
+	NewInventoryRepository     InventoryRepository
+	NewPromotionRepository     PromotionRepository
+	NewCallbackAuditRepository CallbackAuditRepository
+	NewTokenRepository         TokenRepository
+	NewSessionRepository       SessionRepository
+	NewAccountTokenRepository  AccountTokenRepository
+	NewLoginThrottleRepository LoginThrottleRepository
+	NewAuthEventRepository     AuthEventRepository
+	NewRoleRepository          RoleRepository
+	NewAddressRepository       AddressRepository
+	NewDeliveryZoneRepository  DeliveryZoneRepository
+	NewShipmentRepository      ShipmentRepository
+	NewUnitOfWork              database.UnitOfWork
 )
 
 // InitDependencies initializes all repositories (called from main)
@@ -81,6 +267,9 @@ func InitDependencies() {
 	if NewOrderRepository == nil {
 		NewOrderRepository = database.NewOrderRepository()
 	}
+	if NewCartRepository == nil {
+		NewCartRepository = database.NewCartRepository()
+	}
 	if NewInvoiceRepository == nil {
 		NewInvoiceRepository = database.NewInvoiceRepository()
 	}
@@ -93,10 +282,55 @@ func InitDependencies() {
 	if NewPaymentRepository == nil {
 		NewPaymentRepository = database.NewPaymentRepository()
 	}
+	if NewC2BRepository == nil {
+		NewC2BRepository = database.NewC2BRepository()
+	}
 	if NewReversalRepository == nil {
 		NewReversalRepository = database.NewReversalRepository()
 	}
+	if NewRefundRepository == nil {
+		NewRefundRepository = database.NewRefundRepository()
+	}
 	if NewReportRepository == nil {
 		NewReportRepository = database.NewReportRepository()
 	}
+	if NewInventoryRepository == nil {
+		NewInventoryRepository = database.NewInventoryRepository()
+	}
+	if NewPromotionRepository == nil {
+		NewPromotionRepository = database.NewPromotionRepository()
+	}
+	if NewCallbackAuditRepository == nil {
+		NewCallbackAuditRepository = database.NewCallbackAuditRepository()
+	}
+	if NewTokenRepository == nil {
+		NewTokenRepository = database.NewTokenRepository()
+	}
+	if NewSessionRepository == nil {
+		NewSessionRepository = database.NewSessionRepository()
+	}
+	if NewAccountTokenRepository == nil {
+		NewAccountTokenRepository = database.NewAccountTokenRepository()
+	}
+	if NewLoginThrottleRepository == nil {
+		NewLoginThrottleRepository = database.NewLoginThrottleRepository()
+	}
+	if NewAuthEventRepository == nil {
+		NewAuthEventRepository = database.NewAuthEventRepository()
+	}
+	if NewRoleRepository == nil {
+		NewRoleRepository = database.NewRoleRepository()
+	}
+	if NewAddressRepository == nil {
+		NewAddressRepository = database.NewAddressRepository()
+	}
+	if NewDeliveryZoneRepository == nil {
+		NewDeliveryZoneRepository = database.NewDeliveryZoneRepository()
+	}
+	if NewShipmentRepository == nil {
+		NewShipmentRepository = database.NewShipmentRepository()
+	}
+	if NewUnitOfWork == nil {
+		NewUnitOfWork = database.NewUnitOfWork()
+	}
 }
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// stockDemand totals the quantity requested per product, preserving the order
+// in which products first appear on the order.
+func stockDemand(items []models.OrderItem) ([]string, map[string]int) {
+	var ids []string
+	demand := make(map[string]int)
+	for _, item := range items {
+		if _, seen := demand[item.ProductID]; !seen {
+			ids = append(ids, item.ProductID)
+		}
+		demand[item.ProductID] += item.Quantity
+	}
+	return ids, demand
+}
+
+// reserveStock reserves stock for every item on an order. If any product is
+// short, nothing stays reserved and the shortages are returned instead.
+func reserveStock(ctx context.Context, items []models.OrderItem, products map[string]*models.Product) ([]models.StockShortage, error) {
+	productRepo := NewProductRepository
+	ids, demand := stockDemand(items)
+
+	var shortages []models.StockShortage
+	for _, id := range ids {
+		prod := products[id]
+		if prod.Stock < demand[id] {
+			shortages = append(shortages, models.StockShortage{
+				ProductID: id,
+				Name:      prod.Name,
+				Requested: demand[id],
+				Available: prod.Stock,
+			})
+		}
+	}
+	if len(shortages) > 0 {
+		return shortages, nil
+	}
+
+	var reserved []string
+	for _, id := range ids {
+		if err := productRepo.ReserveStock(ctx, id, demand[id]); err != nil {
+			for _, r := range reserved {
+				_ = productRepo.ReleaseStock(ctx, r, demand[r])
+			}
+
+			if err.Error() == "insufficient stock" {
+				// Another order took the remaining units after our snapshot
+				available := 0
+				if prod, gerr := productRepo.GetProductByID(ctx, id); gerr == nil {
+					available = prod.Stock
+				}
+				return []models.StockShortage{{
+					ProductID: id,
+					Name:      products[id].Name,
+					Requested: demand[id],
+					Available: available,
+				}}, nil
+			}
+			return nil, err
+		}
+		reserved = append(reserved, id)
+	}
+
+	return nil, nil
+}
+
+// releaseStock returns the stock held by an order's items to inventory
+func releaseStock(ctx context.Context, items []models.OrderItem) error {
+	productRepo := NewProductRepository
+	ids, demand := stockDemand(items)
+
+	var firstErr error
+	for _, id := range ids {
+		if err := productRepo.ReleaseStock(ctx, id, demand[id]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// AdminAdjustStock applies a manual stock adjustment to a product (admin)
+func AdminAdjustStock(c *gin.Context) {
+	productID := c.Param("id")
+
+	var req models.AdjustStockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	productRepo := NewProductRepository
+	product, err := productRepo.AdjustStock(context.Background(), productID, req.Delta)
+	if err != nil {
+		switch err.Error() {
+		case "product not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		case "insufficient stock":
+			c.JSON(http.StatusConflict, gin.H{"error": "adjustment would take stock below zero"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to adjust stock"})
+		}
+		return
+	}
+
+	// Record adjustment audit
+	adj := &models.StockAdjustment{
+		ID:         uuid.New().String(),
+		ProductID:  productID,
+		Delta:      req.Delta,
+		StockAfter: product.Stock,
+		Reason:     req.Reason,
+		AdminID:    c.GetString("userID"),
+	}
+
+	inventoryRepo := NewInventoryRepository
+	if err := inventoryRepo.CreateStockAdjustment(context.Background(), adj); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "stock adjusted but failed to record adjustment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"product": product, "adjustment": adj})
+}
+
+// AdminListStockAdjustments lists the stock adjustment history of a product (admin)
+func AdminListStockAdjustments(c *gin.Context) {
+	productID := c.Param("id")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	inventoryRepo := NewInventoryRepository
+	adjustments, err := inventoryRepo.GetStockAdjustmentsByProduct(context.Background(), productID, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve stock adjustments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": adjustments, "page": page, "limit": limit})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAdminAdjustStock_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	productID := uuid.New().String()
+	adminID := uuid.New().String()
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("AdjustStock", mock.Anything, productID, -2).Return(&models.Product{ID: productID, Stock: 8}, nil)
+
+	mockInventoryRepo := new(MockInventoryRepository)
+	mockInventoryRepo.On("CreateStockAdjustment", mock.Anything, mock.MatchedBy(func(a *models.StockAdjustment) bool {
+		return a.ProductID == productID && a.Delta == -2 && a.StockAfter == 8 && a.Reason == "damaged" && a.AdminID == adminID
+	})).Return(nil)
+
+	oldProductRepo := NewProductRepository
+	oldInventoryRepo := NewInventoryRepository
+	NewProductRepository = ProductRepository(mockProductRepo)
+	NewInventoryRepository = InventoryRepository(mockInventoryRepo)
+	defer func() {
+		NewProductRepository = oldProductRepo
+		NewInventoryRepository = oldInventoryRepo
+	}()
+
+	body, _ := json.Marshal(models.AdjustStockRequest{Delta: -2, Reason: "damaged"})
+	httpReq := httptest.NewRequest("POST", "/admin/products/"+productID+"/stock", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: productID}}
+	c.Set("userID", adminID)
+
+	AdminAdjustStock(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockProductRepo.AssertExpectations(t)
+	mockInventoryRepo.AssertExpectations(t)
+}
+
+func TestAdminAdjustStock_MissingReason(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	body := []byte(`{"delta":5}`)
+	httpReq := httptest.NewRequest("POST", "/admin/products/p1/stock", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: "p1"}}
+
+	AdminAdjustStock(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminAdjustStock_BelowZero(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	productID := uuid.New().String()
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("AdjustStock", mock.Anything, productID, -50).Return(nil, errors.New("insufficient stock"))
+
+	mockInventoryRepo := new(MockInventoryRepository)
+
+	oldProductRepo := NewProductRepository
+	oldInventoryRepo := NewInventoryRepository
+	NewProductRepository = ProductRepository(mockProductRepo)
+	NewInventoryRepository = InventoryRepository(mockInventoryRepo)
+	defer func() {
+		NewProductRepository = oldProductRepo
+		NewInventoryRepository = oldInventoryRepo
+	}()
+
+	body := []byte(`{"delta":-50,"reason":"stock count"}`)
+	httpReq := httptest.NewRequest("POST", "/admin/products/"+productID+"/stock", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: productID}}
+
+	AdminAdjustStock(c)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mockInventoryRepo.AssertNotCalled(t, "CreateStockAdjustment", mock.Anything, mock.Anything)
+}
+
+func TestAdminListStockAdjustments_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	productID := uuid.New().String()
+	adjustments := []*models.StockAdjustment{
+		{ID: uuid.New().String(), ProductID: productID, Delta: 10, Reason: "restock"},
+	}
+
+	mockInventoryRepo := new(MockInventoryRepository)
+	mockInventoryRepo.On("GetStockAdjustmentsByProduct", mock.Anything, productID, 1, 10).Return(adjustments, nil)
+
+	oldInventoryRepo := NewInventoryRepository
+	NewInventoryRepository = InventoryRepository(mockInventoryRepo)
+	defer func() { NewInventoryRepository = oldInventoryRepo }()
+
+	httpReq := httptest.NewRequest("GET", "/admin/products/"+productID+"/stock/adjustments", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: productID}}
+
+	AdminListStockAdjustments(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockInventoryRepo.AssertExpectations(t)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -186,56 +187,72 @@ func AdminReverseInvoice(c *gin.Context) {
 		return
 	}
 
-	// Optional MPesa reversal
+	adminID := c.GetString("userID")
+	rev := &models.ReversalRecord{
+		ID:        fmt.Sprintf("rev_%s_%d", invoiceID, time.Now().Unix()),
+		InvoiceID: invoiceID,
+		Amount:    amt,
+		Date:      req.Date,
+		Phone:     req.Phone,
+		AdminID:   adminID,
+		Reason:    req.Reason,
+	}
+	revRepo := NewReversalRepository
+
+	// M-Pesa reversals are asynchronous: record the request as pending and leave the
+	// invoice alone until Safaricom reports the outcome on the result URL.
 	if req.UseMpesa {
 		if mpesaClient == nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "M-Pesa client not initialized or not configured"})
 			return
 		}
 
-		if err := mpesaClient.InitiateReversal(req.Phone, fmt.Sprintf("%.2f", amt), invoice.ID); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("mpesa reversal failed: %v", err)})
-			return
+		transactionID := req.TransactionID
+		if transactionID == "" {
+			transactionID, err = latestMpesaReceipt(context.Background(), invoiceID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve invoice payments"})
+				return
+			}
+			if transactionID == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "no M-Pesa receipt found to reverse; provide transactionId"})
+				return
+			}
 		}
-	}
 
-	// Apply DB reversals
-	if amt >= invoice.PaidAmount {
-		// full reversal
-		if err := invoiceRepo.ReverseAllPayments(context.Background(), invoiceID, time.Now().Format("2006-01-02")); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reverse invoice payments"})
+		callbackToken, callbackTokenHash, err := newCallbackToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		paymentRepo := NewPaymentRepository
-		if err := paymentRepo.ReversePaymentsByInvoiceID(context.Background(), invoiceID); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mark payment records reversed"})
+		resp, err := mpesaClient.InitiateReversal(context.Background(), transactionID, fmt.Sprintf("%.2f", amt), invoice.ID, callbackToken)
+		if err != nil {
+			c.JSON(mpesa.HTTPStatus(err, http.StatusBadGateway), gin.H{"error": fmt.Sprintf("mpesa reversal failed: %v", err)})
 			return
 		}
-	} else {
-		// partial reversal
-		dateStr := req.Date
-		if dateStr == "" {
-			dateStr = time.Now().Format("2006-01-02")
-		}
-		if err := invoiceRepo.ReversePaymentAmount(context.Background(), invoiceID, amt, dateStr); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply partial reversal"})
+
+		rev.Status = models.ReversalStatusPending
+		rev.TransactionID = transactionID
+		rev.ConversationID = resp.ConversationID
+		rev.OriginatorConversationID = resp.OriginatorConversationID
+		rev.CallbackTokenHash = callbackTokenHash
+		if err := revRepo.CreateReversalRecord(context.Background(), rev); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record reversal"})
 			return
 		}
+
+		c.JSON(http.StatusAccepted, gin.H{"invoice": invoice, "reversal": rev})
+		return
 	}
 
-	// Record reversal audit
-	adminID, _ := c.Get("userID")
-	revRepo := NewReversalRepository
-	rev := &models.ReversalRecord{
-		ID:        fmt.Sprintf("rev_%s_%d", invoiceID, time.Now().Unix()),
-		InvoiceID: invoiceID,
-		Amount:    amt,
-		Date:      req.Date,
-		Phone:     req.Phone,
-		AdminID:   adminID.(string),
-		Reason:    req.Reason,
+	if err := applyReversal(context.Background(), invoice, amt, req.Date); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+
+	// Record reversal audit
+	rev.Status = models.ReversalStatusCompleted
 	_ = revRepo.CreateReversalRecord(context.Background(), rev)
 
 	updated, err := invoiceRepo.GetInvoiceByID(context.Background(), invoiceID)
@@ -246,3 +263,43 @@ func AdminReverseInvoice(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"invoice": updated, "reversal": rev})
 }
+
+// latestMpesaReceipt returns the receipt of the newest completed M-Pesa payment on an
+// invoice, or an empty string if there is none
+func latestMpesaReceipt(ctx context.Context, invoiceID string) (string, error) {
+	payments, err := NewPaymentRepository.GetPaymentsByInvoiceID(ctx, invoiceID)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range payments {
+		if p.Status == "completed" && p.MpesaReceiptNumber != "" {
+			return p.MpesaReceiptNumber, nil
+		}
+	}
+	return "", nil
+}
+
+// applyReversal takes amt off the invoice's paid amount. Reversing the whole paid
+// amount also marks the invoice's payment records reversed.
+func applyReversal(ctx context.Context, invoice *models.Invoice, amt float64, dateStr string) error {
+	if dateStr == "" {
+		dateStr = time.Now().Format("2006-01-02")
+	}
+
+	if amt >= invoice.PaidAmount {
+		// full reversal
+		if err := NewInvoiceRepository.ReverseAllPayments(ctx, invoice.ID, dateStr); err != nil {
+			return fmt.Errorf("failed to reverse invoice payments")
+		}
+		if err := NewPaymentRepository.ReversePaymentsByInvoiceID(ctx, invoice.ID); err != nil {
+			return fmt.Errorf("failed to mark payment records reversed")
+		}
+		return nil
+	}
+
+	// partial reversal
+	if err := NewInvoiceRepository.ReversePaymentAmount(ctx, invoice.ID, amt, dateStr); err != nil {
+		return fmt.Errorf("failed to apply partial reversal")
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// loginProtectionConfig limits password guessing. Failed logins are counted per
+// account (by email, whether or not it exists) and per client IP. From the second
+// failure on an account, the next attempt must wait baseDelay, doubling with each
+// failure; at maxAccountFailures the account is locked for lockout, doubling with
+// each further failure up to maxLockout. Counts start over after window without
+// failures.
+type loginProtectionConfig struct {
+	maxAccountFailures int // 0 disables per-account limits and delays
+	maxIPFailures      int // 0 disables per-IP limits
+	window             time.Duration
+	baseDelay          time.Duration // 0 disables delays
+	lockout            time.Duration
+	maxLockout         time.Duration
+}
+
+var loginProtection = loginProtectionConfig{
+	maxAccountFailures: 5,
+	maxIPFailures:      50,
+	window:             15 * time.Minute,
+	baseDelay:          time.Second,
+	lockout:            15 * time.Minute,
+	maxLockout:         24 * time.Hour,
+}
+
+// maxLoginDelay caps the wait between attempts before an account is locked
+const maxLoginDelay = 30 * time.Second
+
+// InitLoginProtection loads login limits from the environment:
+// LOGIN_MAX_FAILURES (default 5), LOGIN_MAX_FAILURES_PER_IP (default 50),
+// LOGIN_FAILURE_WINDOW (default 15m), LOGIN_DELAY (default 1s), LOGIN_LOCKOUT
+// (default 15m) and LOGIN_MAX_LOCKOUT (default 24h). A limit of 0 disables it.
+func InitLoginProtection() error {
+	config := loginProtection
+	for _, setting := range []struct {
+		name  string
+		value *int
+	}{
+		{"LOGIN_MAX_FAILURES", &config.maxAccountFailures},
+		{"LOGIN_MAX_FAILURES_PER_IP", &config.maxIPFailures},
+	} {
+		if raw := os.Getenv(setting.name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s %q", setting.name, raw)
+			}
+			*setting.value = n
+		}
+	}
+	for _, setting := range []struct {
+		name     string
+		value    *time.Duration
+		positive bool
+	}{
+		{"LOGIN_FAILURE_WINDOW", &config.window, true},
+		{"LOGIN_DELAY", &config.baseDelay, false},
+		{"LOGIN_LOCKOUT", &config.lockout, true},
+		{"LOGIN_MAX_LOCKOUT", &config.maxLockout, true},
+	} {
+		if raw := os.Getenv(setting.name); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d < 0 || (setting.positive && d == 0) {
+				return fmt.Errorf("invalid %s %q", setting.name, raw)
+			}
+			*setting.value = d
+		}
+	}
+	if config.maxLockout < config.lockout {
+		return fmt.Errorf("LOGIN_MAX_LOCKOUT must not be shorter than LOGIN_LOCKOUT")
+	}
+
+	loginProtection = config
+	return nil
+}
+
+// normalizeLoginEmail is the form of an email that failed logins are counted under
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginDelay is how long to wait after an account's latest failure before trying again
+func loginDelay(failures int) time.Duration {
+	if loginProtection.baseDelay <= 0 || failures < 2 {
+		return 0
+	}
+	delay := loginProtection.baseDelay
+	for i := 2; i < failures && delay < maxLoginDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxLoginDelay)
+}
+
+// loginLockout is how long a throttle with this many failures is locked for, or 0
+func loginLockout(failures, limit int) time.Duration {
+	if limit <= 0 || failures < limit {
+		return 0
+	}
+	lockout := loginProtection.lockout
+	for i := limit; i < failures && lockout < loginProtection.maxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, loginProtection.maxLockout)
+}
+
+// loginRetryAt returns when the account and IP may next try to sign in; a time in
+// the past means now
+func loginRetryAt(ctx context.Context, email, ip string) (time.Time, error) {
+	var keys []string
+	if loginProtection.maxAccountFailures > 0 {
+		keys = append(keys, models.LoginThrottleKey(models.LoginThrottleAccount, email))
+	}
+	if loginProtection.maxIPFailures > 0 {
+		keys = append(keys, models.LoginThrottleKey(models.LoginThrottleIP, ip))
+	}
+	if len(keys) == 0 {
+		return time.Time{}, nil
+	}
+
+	throttles, err := NewLoginThrottleRepository.GetLoginThrottles(ctx, keys)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var retryAt time.Time
+	for _, throttle := range throttles {
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(retryAt) {
+			retryAt = *throttle.LockedUntil
+		}
+		if throttle.Scope == models.LoginThrottleAccount {
+			if next := throttle.LastFailureAt.Add(loginDelay(throttle.Failures)); next.After(retryAt) {
+				retryAt = next
+			}
+		}
+	}
+	return retryAt, nil
+}
+
+// tooManyLoginAttempts refuses a login until retryAt
+func tooManyLoginAttempts(c *gin.Context, retryAt time.Time) {
+	seconds := int(math.Ceil(time.Until(retryAt).Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts; try again later", "retryAfter": seconds})
+}
+
+// recordLoginFailure counts a failed login against the account and the client IP,
+// locking either once it reaches its limit. user is nil when no account has the
+// email. Failures to record are logged rather than failing the login response.
+func recordLoginFailure(ctx context.Context, email, ip string, user *models.User) {
+	for _, scope := range []struct {
+		scope, subject, event string
+		limit                 int
+	}{
+		{models.LoginThrottleAccount, email, models.AuthEventAccountLocked, loginProtection.maxAccountFailures},
+		{models.LoginThrottleIP, ip, models.AuthEventIPLocked, loginProtection.maxIPFailures},
+	} {
+		if scope.limit <= 0 {
+			continue
+		}
+		throttle, err := NewLoginThrottleRepository.RecordLoginFailure(ctx, scope.scope, scope.subject, loginProtection.window)
+		if err != nil {
+			log.Printf("Failed to record login failure for %s: %v", models.LoginThrottleKey(scope.scope, scope.subject), err)
+			continue
+		}
+
+		lockout := loginLockout(throttle.Failures, scope.limit)
+		if lockout == 0 {
+			continue
+		}
+		until := time.Now().Add(lockout)
+		if err := NewLoginThrottleRepository.LockLogin(ctx, throttle.ID, until, loginProtection.window); err != nil {
+			log.Printf("Failed to lock %s: %v", throttle.ID, err)
+			continue
+		}
+
+		event := &models.AuthEvent{
+			ID:          uuid.New().String(),
+			Type:        scope.event,
+			Email:       email,
+			IP:          ip,
+			Failures:    throttle.Failures,
+			LockedUntil: &until,
+		}
+		if user != nil {
+			event.UserID = user.ID
+		}
+		if scope.scope == models.LoginThrottleIP {
+			// The IP's failures span many accounts; the one that tipped it over is incidental
+			event.Email, event.UserID = "", ""
+		}
+		recordAuthEvent(ctx, event)
+	}
+}
+
+// clearLoginFailures forgets an account's failed logins after it signs in. The
+// IP's count is kept, so one valid account cannot be used to keep guessing others.
+func clearLoginFailures(ctx context.Context, email string) {
+	if loginProtection.maxAccountFailures <= 0 {
+		return
+	}
+	key := models.LoginThrottleKey(models.LoginThrottleAccount, email)
+	if _, err := NewLoginThrottleRepository.ClearLoginThrottle(ctx, key); err != nil {
+		log.Printf("Failed to clear login failures for %s: %v", key, err)
+	}
+}
+
+// recordAuthEvent writes an audit entry, logging rather than failing if it cannot
+func recordAuthEvent(ctx context.Context, event *models.AuthEvent) {
+	if err := NewAuthEventRepository.RecordAuthEvent(ctx, event); err != nil {
+		log.Printf("Failed to record %s auth event: %v", event.Type, err)
+	}
+}
+
+// AdminUnlockUser lifts a lockout on a user's account and forgets its failed
+// logins (admin)
+func AdminUnlockUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, err := NewUserRepository.FindUserByID(ctx, c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+		return
+	}
+
+	email := normalizeLoginEmail(user.Email)
+	cleared, err := NewLoginThrottleRepository.ClearLoginThrottle(ctx, models.LoginThrottleKey(models.LoginThrottleAccount, email))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock account"})
+		return
+	}
+	if cleared {
+		recordAuthEvent(ctx, &models.AuthEvent{
+			ID:      uuid.New().String(),
+			Type:    models.AuthEventUnlocked,
+			UserID:  user.ID,
+			Email:   email,
+			ActorID: c.GetString("userID"),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked", "hadFailures": cleared})
+}
+
+// AdminListLoginLockouts lists the accounts and IPs currently locked out (admin)
+func AdminListLoginLockouts(c *gin.Context) {
+	throttles, err := NewLoginThrottleRepository.ListLockedLogins(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve lockouts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": throttles})
+}
+
+// AdminClearLoginLockout lifts a lockout by its ID, e.g. "ip:203.0.113.7" (admin)
+func AdminClearLoginLockout(c *gin.Context) {
+	key := c.Param("id")
+	scope, subject, ok := strings.Cut(key, ":")
+	if !ok || (scope != models.LoginThrottleAccount && scope != models.LoginThrottleIP) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lockout id must be account:<email> or ip:<address>"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	cleared, err := NewLoginThrottleRepository.ClearLoginThrottle(ctx, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to clear lockout"})
+		return
+	}
+	if !cleared {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no failed logins recorded for " + key})
+		return
+	}
+
+	event := &models.AuthEvent{ID: uuid.New().String(), Type: models.AuthEventUnlocked, ActorID: c.GetString("userID")}
+	if scope == models.LoginThrottleIP {
+		event.IP = subject
+	} else {
+		event.Email = subject
+	}
+	recordAuthEvent(ctx, event)
+
+	c.JSON(http.StatusOK, gin.H{"message": "lockout cleared"})
+}
+
+// AdminListAuthEvents lists lockout and unlock audit entries, newest first,
+// optionally of one type or for one user (admin)
+func AdminListAuthEvents(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	eventType := c.Query("type")
+	userID := c.Query("userId")
+
+	events, err := NewAuthEventRepository.ListAuthEvents(c.Request.Context(), eventType, userID, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve auth events"})
+		return
+	}
+
+	total, err := NewAuthEventRepository.CountAuthEvents(c.Request.Context(), eventType, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count auth events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": events, "total": total, "page": page, "limit": limit})
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// httptest requests come from this address
+const testClientIP = "192.0.2.1"
+
+// useLoginThrottles swaps in login throttle and auth event mocks for one test
+func useLoginThrottles(t *testing.T) (*MockLoginThrottleRepository, *MockAuthEventRepository) {
+	oldThrottles, oldEvents, oldSettings := NewLoginThrottleRepository, NewAuthEventRepository, loginProtection
+	throttles, events := new(MockLoginThrottleRepository), new(MockAuthEventRepository)
+	NewLoginThrottleRepository, NewAuthEventRepository = throttles, events
+	t.Cleanup(func() {
+		NewLoginThrottleRepository, NewAuthEventRepository, loginProtection = oldThrottles, oldEvents, oldSettings
+	})
+	return throttles, events
+}
+
+func postLogin(email, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.LoginRequest{Email: email, Password: password})
+	httpReq := httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	Login(c)
+	return w
+}
+
+func loginUser(t *testing.T) *MockUserRepository {
+	hashedPassword, _ := auth.HashPassword("password123")
+	users := useUserRepo(t)
+	users.On("FindUserByEmail", mock.Anything, "jane@example.com").Return(&models.User{ID: "user-1", Email: "jane@example.com", Password: hashedPassword, Role: "user"}, nil)
+	return users
+}
+
+var (
+	accountKey = models.LoginThrottleKey(models.LoginThrottleAccount, "jane@example.com")
+	ipKey      = models.LoginThrottleKey(models.LoginThrottleIP, testClientIP)
+)
+
+func TestLogin_WrongPasswordCountsFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	users := useUserRepo(t)
+	users.On("FindUserByEmail", mock.Anything, "jane@example.com").Return(&models.User{ID: "user-1", Email: "jane@example.com", Password: "not-a-hash"}, nil)
+
+	throttles.On("GetLoginThrottles", mock.Anything, []string{accountKey, ipKey}).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleAccount, "jane@example.com", loginProtection.window).
+		Return(&models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 1}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleIP, testClientIP, loginProtection.window).
+		Return(&models.LoginThrottle{ID: ipKey, Scope: models.LoginThrottleIP, Failures: 1}, nil)
+
+	// The email is looked up and counted in its normalized form
+	w := postLogin("Jane@Example.com", "wrong-password")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	users.AssertExpectations(t)
+	throttles.AssertExpectations(t)
+	throttles.AssertNotCalled(t, "LockLogin", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	events.AssertNotCalled(t, "RecordAuthEvent", mock.Anything, mock.Anything)
+}
+
+func TestLogin_UnknownEmailCountsFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	loginProtection.maxIPFailures = 0
+	users := useUserRepo(t)
+	users.On("FindUserByEmail", mock.Anything, "nobody@example.com").Return(nil, mongo.ErrNoDocuments)
+
+	key := models.LoginThrottleKey(models.LoginThrottleAccount, "nobody@example.com")
+	throttles.On("GetLoginThrottles", mock.Anything, []string{key}).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleAccount, "nobody@example.com", loginProtection.window).
+		Return(&models.LoginThrottle{ID: key, Scope: models.LoginThrottleAccount, Failures: 1}, nil)
+
+	w := postLogin("nobody@example.com", "password123")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	throttles.AssertExpectations(t)
+}
+
+func TestLogin_LocksAccountAtMaxFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	loginUser(t)
+
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleAccount, "jane@example.com", mock.Anything).
+		Return(&models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: loginProtection.maxAccountFailures}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleIP, testClientIP, mock.Anything).
+		Return(&models.LoginThrottle{ID: ipKey, Scope: models.LoginThrottleIP, Failures: loginProtection.maxAccountFailures}, nil)
+	throttles.On("LockLogin", mock.Anything, accountKey, mock.MatchedBy(func(until time.Time) bool {
+		return until.Sub(time.Now()) > loginProtection.lockout-time.Minute
+	}), loginProtection.window).Return(nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventAccountLocked && e.UserID == "user-1" && e.Email == "jane@example.com" &&
+			e.IP == testClientIP && e.Failures == loginProtection.maxAccountFailures && e.LockedUntil != nil
+	})).Return(nil)
+
+	w := postLogin("jane@example.com", "wrong-password")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	throttles.AssertExpectations(t)
+	events.AssertExpectations(t)
+}
+
+func TestLogin_LocksIPAtMaxFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	loginUser(t)
+
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleAccount, "jane@example.com", mock.Anything).
+		Return(&models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 1}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleIP, testClientIP, mock.Anything).
+		Return(&models.LoginThrottle{ID: ipKey, Scope: models.LoginThrottleIP, Failures: loginProtection.maxIPFailures}, nil)
+	throttles.On("LockLogin", mock.Anything, ipKey, mock.Anything, loginProtection.window).Return(nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventIPLocked && e.IP == testClientIP && e.Email == "" && e.UserID == ""
+	})).Return(nil)
+
+	w := postLogin("jane@example.com", "wrong-password")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	throttles.AssertExpectations(t)
+	events.AssertExpectations(t)
+}
+
+func TestLogin_RefusedWhileLocked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	users := loginUser(t)
+
+	until := time.Now().Add(10 * time.Minute)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{
+		{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 5, LastFailureAt: time.Now().Add(-5 * time.Minute), LockedUntil: &until},
+	}, nil)
+
+	// Even the right password is refused, and the attempt is not counted
+	w := postLogin("jane@example.com", "password123")
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "600", w.Header().Get("Retry-After"))
+	users.AssertNotCalled(t, "FindUserByEmail", mock.Anything, mock.Anything)
+	throttles.AssertNotCalled(t, "RecordLoginFailure", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLogin_RefusedWhileIPLocked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	loginUser(t)
+
+	until := time.Now().Add(time.Hour)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{
+		{ID: ipKey, Scope: models.LoginThrottleIP, Failures: 50, LastFailureAt: time.Now(), LockedUntil: &until},
+	}, nil)
+
+	w := postLogin("jane@example.com", "password123")
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestLogin_ProgressiveDelay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	loginUser(t)
+
+	// Three failures: the next attempt must wait two seconds
+	recent := &models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 3, LastFailureAt: time.Now()}
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{recent}, nil).Once()
+
+	w := postLogin("jane@example.com", "password123")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// Once the delay has passed the right password signs in and clears the count
+	sessions, _ := useSessionRepos(t)
+	sessions.On("CreateSession", mock.Anything, mock.Anything).Return(nil)
+	waited := &models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 3, LastFailureAt: time.Now().Add(-3 * time.Second)}
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{waited}, nil).Once()
+	throttles.On("ClearLoginThrottle", mock.Anything, accountKey).Return(true, nil)
+
+	w = postLogin("jane@example.com", "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+	throttles.AssertExpectations(t)
+}
+
+func TestLoginDelayAndLockoutGrow(t *testing.T) {
+	oldSettings := loginProtection
+	defer func() { loginProtection = oldSettings }()
+	loginProtection.baseDelay = time.Second
+	loginProtection.lockout = 15 * time.Minute
+	loginProtection.maxLockout = 24 * time.Hour
+
+	delays := map[int]time.Duration{1: 0, 2: time.Second, 3: 2 * time.Second, 4: 4 * time.Second, 20: maxLoginDelay}
+	for failures, want := range delays {
+		assert.Equal(t, want, loginDelay(failures), "delay after %d failures", failures)
+	}
+
+	lockouts := map[int]time.Duration{4: 0, 5: 15 * time.Minute, 6: 30 * time.Minute, 7: time.Hour, 100: 24 * time.Hour}
+	for failures, want := range lockouts {
+		assert.Equal(t, want, loginLockout(failures, 5), "lockout after %d failures", failures)
+	}
+	assert.Equal(t, time.Duration(0), loginLockout(100, 0), "a limit of 0 disables lockouts")
+}
+
+func TestAdminUnlockUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1", Email: "Jane@Example.com"}, nil)
+	users.On("FindUserByID", mock.Anything, "missing").Return(nil, mongo.ErrNoDocuments)
+	throttles.On("ClearLoginThrottle", mock.Anything, accountKey).Return(true, nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventUnlocked && e.UserID == "user-1" && e.Email == "jane@example.com" && e.ActorID == "admin-1"
+	})).Return(nil)
+
+	unlock := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/admin/users/"+id+"/unlock", nil)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		c.Set("userID", "admin-1")
+		AdminUnlockUser(c)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, unlock("user-1").Code)
+	assert.Equal(t, http.StatusNotFound, unlock("missing").Code)
+	events.AssertExpectations(t)
+}
+
+func TestAdminClearLoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	throttles.On("ClearLoginThrottle", mock.Anything, ipKey).Return(true, nil)
+	throttles.On("ClearLoginThrottle", mock.Anything, "ip:198.51.100.9").Return(false, nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventUnlocked && e.IP == testClientIP && e.ActorID == "admin-1"
+	})).Return(nil)
+
+	clear := func(id string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("DELETE", "/admin/auth/lockouts/"+id, nil)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		c.Set("userID", "admin-1")
+		AdminClearLoginLockout(c)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, clear(ipKey))
+	assert.Equal(t, http.StatusNotFound, clear("ip:198.51.100.9"))
+	assert.Equal(t, http.StatusBadRequest, clear("session:abc"))
+	events.AssertNumberOfCalls(t, "RecordAuthEvent", 1)
+}
+
+func TestInitLoginProtection(t *testing.T) {
+	useLoginThrottles(t)
+	for _, name := range []string{"LOGIN_MAX_FAILURES", "LOGIN_MAX_FAILURES_PER_IP", "LOGIN_FAILURE_WINDOW", "LOGIN_DELAY", "LOGIN_LOCKOUT", "LOGIN_MAX_LOCKOUT"} {
+		t.Setenv(name, "")
+	}
+
+	t.Setenv("LOGIN_MAX_FAILURES", "3")
+	t.Setenv("LOGIN_MAX_FAILURES_PER_IP", "0")
+	t.Setenv("LOGIN_DELAY", "0")
+	t.Setenv("LOGIN_LOCKOUT", "1h")
+	assert.NoError(t, InitLoginProtection())
+	assert.Equal(t, 3, loginProtection.maxAccountFailures)
+	assert.Equal(t, 0, loginProtection.maxIPFailures)
+	assert.Equal(t, time.Duration(0), loginProtection.baseDelay)
+	assert.Equal(t, time.Hour, loginProtection.lockout)
+
+	t.Setenv("LOGIN_MAX_LOCKOUT", "30m")
+	assert.Error(t, InitLoginProtection(), "max lockout shorter than the first lockout")
+
+	t.Setenv("LOGIN_MAX_LOCKOUT", "")
+	t.Setenv("LOGIN_FAILURE_WINDOW", "0")
+	assert.Error(t, InitLoginProtection())
+
+	t.Setenv("LOGIN_FAILURE_WINDOW", "")
+	t.Setenv("LOGIN_MAX_FAILURES", "-1")
+	assert.Error(t, InitLoginProtection())
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
+)
+
+func TestMain(m *testing.M) {
+	// Handler tests run against mocks, so units of work run in memory
+	NewUnitOfWork = database.NewInMemoryUnitOfWork()
+	os.Exit(m.Run())
+}
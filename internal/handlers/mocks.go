@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	"github.com/stretchr/testify/mock"
@@ -64,6 +65,64 @@ func (m *MockOrderRepository) UpdateOrderStatus(ctx context.Context, orderID str
 	return args.Error(0)
 }
 
+func (m *MockOrderRepository) ReleaseStockReservation(ctx context.Context, orderID string) (bool, error) {
+	args := m.Called(ctx, orderID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOrderRepository) ReleasePromotion(ctx context.Context, orderID string) (bool, error) {
+	args := m.Called(ctx, orderID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOrderRepository) ClaimShipmentVersion(ctx context.Context, orderID string, version int) (bool, error) {
+	args := m.Called(ctx, orderID, version)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOrderRepository) CountOpenOrdersByUser(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockOrderRepository) AnonymiseOrdersByUser(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// MockCartRepository mocks the cart repository
+type MockCartRepository struct {
+	mock.Mock
+}
+
+func (m *MockCartRepository) GetCartByUser(ctx context.Context, userID string) (*models.Cart, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Cart), args.Error(1)
+}
+
+func (m *MockCartRepository) AddItem(ctx context.Context, userID string, productID string, quantity int) error {
+	args := m.Called(ctx, userID, productID, quantity)
+	return args.Error(0)
+}
+
+func (m *MockCartRepository) UpdateItemQuantity(ctx context.Context, userID string, productID string, quantity int) error {
+	args := m.Called(ctx, userID, productID, quantity)
+	return args.Error(0)
+}
+
+func (m *MockCartRepository) RemoveItem(ctx context.Context, userID string, productID string) error {
+	args := m.Called(ctx, userID, productID)
+	return args.Error(0)
+}
+
+func (m *MockCartRepository) ClearCart(ctx context.Context, userID string) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
 // MockInvoiceRepository mocks the invoice repository
 type MockInvoiceRepository struct {
 	mock.Mock
@@ -118,6 +177,19 @@ func (m *MockInvoiceRepository) GetInvoiceCount(ctx context.Context) (int64, err
 	return int64(args.Int(0)), args.Error(1)
 }
 
+func (m *MockInvoiceRepository) GetInvoicesByUser(ctx context.Context, userID string, page int, limit int) ([]*models.Invoice, error) {
+	args := m.Called(ctx, userID, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Invoice), args.Error(1)
+}
+
+func (m *MockInvoiceRepository) CountInvoicesByUser(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // MockUserRepository mocks the user repository
 type MockUserRepository struct {
 	mock.Mock
@@ -149,6 +221,94 @@ func (m *MockUserRepository) UpdateUser(ctx context.Context, userID string, user
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) UpdatePassword(ctx context.Context, userID string, passwordHash string) error {
+	args := m.Called(ctx, userID, passwordHash)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) MarkEmailVerified(ctx context.Context, userID string, email string) (bool, error) {
+	args := m.Called(ctx, userID, email)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) SetPendingTwoFactor(ctx context.Context, userID string, secret string) error {
+	args := m.Called(ctx, userID, secret)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) EnableTwoFactor(ctx context.Context, userID string, secret string, recoveryCodeHashes []string, step int64) (bool, error) {
+	args := m.Called(ctx, userID, secret, recoveryCodeHashes, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) DisableTwoFactor(ctx context.Context, userID string) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) UseTwoFactorStep(ctx context.Context, userID string, step int64) (bool, error) {
+	args := m.Called(ctx, userID, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	args := m.Called(ctx, userID, codeHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	args := m.Called(ctx, userID, recoveryCodeHashes)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) SetUserRoles(ctx context.Context, userID string, roles []string) error {
+	args := m.Called(ctx, userID, roles)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	args := m.Called(ctx, role)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockUserRepository) CountActiveUsersWithRole(ctx context.Context, role string) (int64, error) {
+	args := m.Called(ctx, role)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockUserRepository) SearchUsers(ctx context.Context, query models.UserSearchQuery, page int, limit int) ([]*models.User, error) {
+	args := m.Called(ctx, query, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.User), args.Error(1)
+}
+
+func (m *MockUserRepository) CountUsers(ctx context.Context, query models.UserSearchQuery) (int64, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockUserRepository) SuspendUser(ctx context.Context, userID string, suspension *models.Suspension) (bool, error) {
+	args := m.Called(ctx, userID, suspension)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) ReactivateUser(ctx context.Context, userID string) (bool, error) {
+	args := m.Called(ctx, userID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) UpdateProfile(ctx context.Context, userID string, updates map[string]interface{}) error {
+	args := m.Called(ctx, userID, updates)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) AnonymiseUser(ctx context.Context, userID string, deletedAt time.Time) (bool, error) {
+	args := m.Called(ctx, userID, deletedAt)
+	return args.Bool(0), args.Error(1)
+}
+
 func (m *MockUserRepository) DeleteUser(ctx context.Context, userID string) error {
 	args := m.Called(ctx, userID)
 	return args.Error(0)
@@ -208,6 +368,114 @@ func (m *MockProductRepository) GetProductCount(ctx context.Context) (int64, err
 	return args.Get(0).(int64), args.Error(1)
 }
 
+func (m *MockProductRepository) ReserveStock(ctx context.Context, productID string, quantity int) error {
+	args := m.Called(ctx, productID, quantity)
+	return args.Error(0)
+}
+
+func (m *MockProductRepository) ReleaseStock(ctx context.Context, productID string, quantity int) error {
+	args := m.Called(ctx, productID, quantity)
+	return args.Error(0)
+}
+
+func (m *MockProductRepository) AdjustStock(ctx context.Context, productID string, delta int) (*models.Product, error) {
+	args := m.Called(ctx, productID, delta)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Product), args.Error(1)
+}
+
+// MockInventoryRepository mocks the inventory repository
+type MockInventoryRepository struct {
+	mock.Mock
+}
+
+func (m *MockInventoryRepository) CreateStockAdjustment(ctx context.Context, adj *models.StockAdjustment) error {
+	args := m.Called(ctx, adj)
+	return args.Error(0)
+}
+
+func (m *MockInventoryRepository) GetStockAdjustmentsByProduct(ctx context.Context, productID string, page int, limit int) ([]*models.StockAdjustment, error) {
+	args := m.Called(ctx, productID, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.StockAdjustment), args.Error(1)
+}
+
+// MockPromotionRepository mocks the promotion repository
+type MockPromotionRepository struct {
+	mock.Mock
+}
+
+func (m *MockPromotionRepository) CreatePromotion(ctx context.Context, promotion *models.Promotion) error {
+	args := m.Called(ctx, promotion)
+	return args.Error(0)
+}
+
+func (m *MockPromotionRepository) GetPromotionByID(ctx context.Context, promotionID string) (*models.Promotion, error) {
+	args := m.Called(ctx, promotionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Promotion), args.Error(1)
+}
+
+func (m *MockPromotionRepository) GetPromotionByCode(ctx context.Context, code string) (*models.Promotion, error) {
+	args := m.Called(ctx, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Promotion), args.Error(1)
+}
+
+func (m *MockPromotionRepository) GetAllPromotions(ctx context.Context, page int, limit int) ([]*models.Promotion, error) {
+	args := m.Called(ctx, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Promotion), args.Error(1)
+}
+
+func (m *MockPromotionRepository) UpdatePromotion(ctx context.Context, promotionID string, updates map[string]interface{}) error {
+	args := m.Called(ctx, promotionID, updates)
+	return args.Error(0)
+}
+
+func (m *MockPromotionRepository) ClaimRedemption(ctx context.Context, promotionID string, userID string, perUserLimit int) error {
+	args := m.Called(ctx, promotionID, userID, perUserLimit)
+	return args.Error(0)
+}
+
+func (m *MockPromotionRepository) ReleaseRedemption(ctx context.Context, promotionID string, userID string) error {
+	args := m.Called(ctx, promotionID, userID)
+	return args.Error(0)
+}
+
+func (m *MockPromotionRepository) CountUserRedemptions(ctx context.Context, promotionID string, userID string) (int64, error) {
+	args := m.Called(ctx, promotionID, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockPromotionRepository) CreateRedemption(ctx context.Context, redemption *models.PromotionRedemption) error {
+	args := m.Called(ctx, redemption)
+	return args.Error(0)
+}
+
+func (m *MockPromotionRepository) DeleteRedemption(ctx context.Context, redemptionID string) error {
+	args := m.Called(ctx, redemptionID)
+	return args.Error(0)
+}
+
+func (m *MockPromotionRepository) GetRedemptionsByPromotion(ctx context.Context, promotionID string, page int, limit int) ([]*models.PromotionRedemption, error) {
+	args := m.Called(ctx, promotionID, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.PromotionRedemption), args.Error(1)
+}
+
 // MockPaymentRepository mocks the payment repository
 type MockPaymentRepository struct {
 	mock.Mock
@@ -226,6 +494,35 @@ func (m *MockPaymentRepository) GetPaymentByCheckoutRequestID(ctx context.Contex
 	return args.Get(0).(*models.PaymentRecord), args.Error(1)
 }
 
+func (m *MockPaymentRepository) GetPaymentByID(ctx context.Context, paymentID string) (*models.PaymentRecord, error) {
+	args := m.Called(ctx, paymentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PaymentRecord), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetPaymentsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.PaymentRecord, error) {
+	args := m.Called(ctx, invoiceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.PaymentRecord), args.Error(1)
+}
+
+func (m *MockPaymentRepository) SearchPayments(ctx context.Context, query models.PaymentSearchQuery, page int, limit int) ([]*models.PaymentRecord, error) {
+	args := m.Called(ctx, query, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.PaymentRecord), args.Error(1)
+}
+
+func (m *MockPaymentRepository) CountPayments(ctx context.Context, query models.PaymentSearchQuery) (int64, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (m *MockPaymentRepository) GetPaymentByInvoiceID(ctx context.Context, invoiceID string) (*models.PaymentRecord, error) {
 	args := m.Called(ctx, invoiceID)
 	if args.Get(0) == nil {
@@ -234,16 +531,83 @@ func (m *MockPaymentRepository) GetPaymentByInvoiceID(ctx context.Context, invoi
 	return args.Get(0).(*models.PaymentRecord), args.Error(1)
 }
 
+func (m *MockPaymentRepository) GetStaleInitiatedPayments(ctx context.Context, olderThan time.Time, limit int) ([]*models.PaymentRecord, error) {
+	args := m.Called(ctx, olderThan, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.PaymentRecord), args.Error(1)
+}
+
 func (m *MockPaymentRepository) UpdatePaymentStatus(ctx context.Context, checkoutID string, status string, receiptNum string, transDate string) error {
 	args := m.Called(ctx, checkoutID, status, receiptNum, transDate)
 	return args.Error(0)
 }
 
+func (m *MockPaymentRepository) TransitionPaymentStatus(ctx context.Context, checkoutID string, fromStatus string, toStatus string, settlement *models.PaymentSettlement) (bool, error) {
+	args := m.Called(ctx, checkoutID, fromStatus, toStatus, settlement)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetPaymentsNeedingReview(ctx context.Context, page int, limit int) ([]*models.PaymentRecord, error) {
+	args := m.Called(ctx, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.PaymentRecord), args.Error(1)
+}
+
+func (m *MockPaymentRepository) MarkPaymentReviewed(ctx context.Context, paymentID string, adminID string, note string) error {
+	args := m.Called(ctx, paymentID, adminID, note)
+	return args.Error(0)
+}
+
 func (m *MockPaymentRepository) ReversePaymentsByInvoiceID(ctx context.Context, invoiceID string) error {
 	args := m.Called(ctx, invoiceID)
 	return args.Error(0)
 }
 
+// MockC2BRepository mocks the C2B transaction repository
+type MockC2BRepository struct {
+	mock.Mock
+}
+
+func (m *MockC2BRepository) CreateC2BTransaction(ctx context.Context, txn *models.C2BTransaction) error {
+	args := m.Called(ctx, txn)
+	return args.Error(0)
+}
+
+func (m *MockC2BRepository) DeleteC2BTransaction(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockC2BRepository) GetC2BTransactionByID(ctx context.Context, id string) (*models.C2BTransaction, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.C2BTransaction), args.Error(1)
+}
+
+func (m *MockC2BRepository) GetC2BTransactionsByStatus(ctx context.Context, status string, page int, limit int) ([]*models.C2BTransaction, error) {
+	args := m.Called(ctx, status, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.C2BTransaction), args.Error(1)
+}
+
+func (m *MockC2BRepository) AllocateC2BTransaction(ctx context.Context, id string, invoiceID string, adminID string, note string) error {
+	args := m.Called(ctx, id, invoiceID, adminID, note)
+	return args.Error(0)
+}
+
+func (m *MockC2BRepository) ReleaseC2BAllocation(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
 // MockReversalRepository mocks the reversal repository
 type MockReversalRepository struct {
 	mock.Mock
@@ -253,23 +617,476 @@ func (m *MockReversalRepository) CreateReversalRecord(ctx context.Context, rever
 	args := m.Called(ctx, reversal)
 	return args.Error(0)
 }
-// MockReportRepository mocks the report repository
-type MockReportRepository struct {
-	mock.Mock
-}
 
-func (m *MockReportRepository) GetSummaryReport(ctx context.Context, startDate, endDate string) (*models.SummaryReport, error) {
-	args := m.Called(ctx, startDate, endDate)
+func (m *MockReversalRepository) GetReversalByConversationID(ctx context.Context, conversationID, originatorConversationID string) (*models.ReversalRecord, error) {
+	args := m.Called(ctx, conversationID, originatorConversationID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(*models.SummaryReport), args.Error(1)
+	return args.Get(0).(*models.ReversalRecord), args.Error(1)
 }
 
-func (m *MockReportRepository) GetDailyBreakdown(ctx context.Context, startDate, endDate string) ([]models.DailySalesReport, error) {
-	args := m.Called(ctx, startDate, endDate)
+func (m *MockReversalRepository) TransitionReversalStatus(ctx context.Context, reversalID, fromStatus, toStatus string, resultCode int, resultDesc, resultTransactionID string) (bool, error) {
+	args := m.Called(ctx, reversalID, fromStatus, toStatus, resultCode, resultDesc, resultTransactionID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockReversalRepository) GetReversalsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.ReversalRecord, error) {
+	args := m.Called(ctx, invoiceID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]models.DailySalesReport), args.Error(1)
-}
\ No newline at end of file
+	return args.Get(0).([]*models.ReversalRecord), args.Error(1)
+}
+
+func (m *MockReversalRepository) AddRefundedAmount(ctx context.Context, reversalID string, amount float64) error {
+	args := m.Called(ctx, reversalID, amount)
+	return args.Error(0)
+}
+
+// MockRefundRepository mocks the refund repository
+type MockRefundRepository struct {
+	mock.Mock
+}
+
+func (m *MockRefundRepository) CreateRefund(ctx context.Context, refund *models.Refund) error {
+	args := m.Called(ctx, refund)
+	return args.Error(0)
+}
+
+func (m *MockRefundRepository) SetRefundReferences(ctx context.Context, refundID, conversationID, originatorConversationID string) error {
+	args := m.Called(ctx, refundID, conversationID, originatorConversationID)
+	return args.Error(0)
+}
+
+func (m *MockRefundRepository) GetRefundByConversationID(ctx context.Context, conversationID, originatorConversationID string) (*models.Refund, error) {
+	args := m.Called(ctx, conversationID, originatorConversationID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Refund), args.Error(1)
+}
+
+func (m *MockRefundRepository) GetRefundsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.Refund, error) {
+	args := m.Called(ctx, invoiceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Refund), args.Error(1)
+}
+
+func (m *MockRefundRepository) TransitionRefundStatus(ctx context.Context, refundID, fromStatus, toStatus string, result *models.RefundResult) (bool, error) {
+	args := m.Called(ctx, refundID, fromStatus, toStatus, result)
+	return args.Bool(0), args.Error(1)
+}
+
+// MockReportRepository mocks the report repository
+type MockReportRepository struct {
+	mock.Mock
+}
+
+func (m *MockReportRepository) GetSummaryReport(ctx context.Context, startDate, endDate string) (*models.SummaryReport, error) {
+	args := m.Called(ctx, startDate, endDate)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.SummaryReport), args.Error(1)
+}
+
+func (m *MockReportRepository) GetDailyBreakdown(ctx context.Context, startDate, endDate string) ([]models.DailySalesReport, error) {
+	args := m.Called(ctx, startDate, endDate)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.DailySalesReport), args.Error(1)
+}
+
+func (m *MockReportRepository) GetPromotionReport(ctx context.Context, startDate, endDate string) ([]models.PromotionReport, error) {
+	args := m.Called(ctx, startDate, endDate)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.PromotionReport), args.Error(1)
+}
+
+func (m *MockReportRepository) GetRefundReport(ctx context.Context, startDate, endDate string) (*models.RefundReport, error) {
+	args := m.Called(ctx, startDate, endDate)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.RefundReport), args.Error(1)
+}
+
+// MockCallbackAuditRepository mocks the rejected callback audit repository
+type MockCallbackAuditRepository struct {
+	mock.Mock
+}
+
+func (m *MockCallbackAuditRepository) RecordRejectedCallback(ctx context.Context, entry *models.RejectedCallback) error {
+	args := m.Called(ctx, entry)
+	return args.Error(0)
+}
+
+func (m *MockCallbackAuditRepository) ListRejectedCallbacks(ctx context.Context, source string, page int, limit int) ([]*models.RejectedCallback, error) {
+	args := m.Called(ctx, source, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.RejectedCallback), args.Error(1)
+}
+
+func (m *MockCallbackAuditRepository) CountRejectedCallbacks(ctx context.Context, source string) (int64, error) {
+	args := m.Called(ctx, source)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// MockTokenRepository mocks the token blacklist
+type MockTokenRepository struct {
+	mock.Mock
+}
+
+func (m *MockTokenRepository) BlacklistToken(ctx context.Context, token string, expiresAt time.Time) error {
+	args := m.Called(ctx, token, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockTokenRepository) IsTokenBlacklisted(ctx context.Context, token string) (bool, error) {
+	args := m.Called(ctx, token)
+	return args.Bool(0), args.Error(1)
+}
+
+// MockSessionRepository mocks the login session repository
+type MockSessionRepository struct {
+	mock.Mock
+}
+
+func (m *MockSessionRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	args := m.Called(ctx, session)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) GetSessionByID(ctx context.Context, sessionID string) (*models.Session, error) {
+	args := m.Called(ctx, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) RotateRefreshToken(ctx context.Context, sessionID, refreshTokenHash string, rotation *models.SessionRotation) (bool, error) {
+	args := m.Called(ctx, sessionID, refreshTokenHash, rotation)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSessionRepository) ListActiveSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) RevokeSession(ctx context.Context, sessionID, reason string) (bool, error) {
+	args := m.Called(ctx, sessionID, reason)
+	return args.Bool(0), args.Error(1)
+}
+
+// MockAccountTokenRepository mocks the password reset and email verification token repository
+type MockAccountTokenRepository struct {
+	mock.Mock
+}
+
+func (m *MockAccountTokenRepository) CreateAccountToken(ctx context.Context, token *models.AccountToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *MockAccountTokenRepository) GetAccountToken(ctx context.Context, tokenHash string) (*models.AccountToken, error) {
+	args := m.Called(ctx, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.AccountToken), args.Error(1)
+}
+
+func (m *MockAccountTokenRepository) MarkAccountTokenUsed(ctx context.Context, tokenHash string) (bool, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockAccountTokenRepository) InvalidateAccountTokens(ctx context.Context, userID, purpose string) error {
+	args := m.Called(ctx, userID, purpose)
+	return args.Error(0)
+}
+
+// MockLoginThrottleRepository mocks the failed login counter repository
+type MockLoginThrottleRepository struct {
+	mock.Mock
+}
+
+func (m *MockLoginThrottleRepository) GetLoginThrottles(ctx context.Context, keys []string) ([]*models.LoginThrottle, error) {
+	args := m.Called(ctx, keys)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.LoginThrottle), args.Error(1)
+}
+
+func (m *MockLoginThrottleRepository) RecordLoginFailure(ctx context.Context, scope, subject string, window time.Duration) (*models.LoginThrottle, error) {
+	args := m.Called(ctx, scope, subject, window)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.LoginThrottle), args.Error(1)
+}
+
+func (m *MockLoginThrottleRepository) LockLogin(ctx context.Context, key string, until time.Time, window time.Duration) error {
+	args := m.Called(ctx, key, until, window)
+	return args.Error(0)
+}
+
+func (m *MockLoginThrottleRepository) ClearLoginThrottle(ctx context.Context, key string) (bool, error) {
+	args := m.Called(ctx, key)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockLoginThrottleRepository) ListLockedLogins(ctx context.Context) ([]*models.LoginThrottle, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.LoginThrottle), args.Error(1)
+}
+
+// MockAuthEventRepository mocks the sign-in audit log repository
+type MockAuthEventRepository struct {
+	mock.Mock
+}
+
+func (m *MockAuthEventRepository) RecordAuthEvent(ctx context.Context, event *models.AuthEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *MockAuthEventRepository) ListAuthEvents(ctx context.Context, eventType, userID string, page, limit int) ([]*models.AuthEvent, error) {
+	args := m.Called(ctx, eventType, userID, page, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.AuthEvent), args.Error(1)
+}
+
+func (m *MockAuthEventRepository) CountAuthEvents(ctx context.Context, eventType, userID string) (int64, error) {
+	args := m.Called(ctx, eventType, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// MockRoleRepository mocks the role repository
+type MockRoleRepository struct {
+	mock.Mock
+}
+
+func (m *MockRoleRepository) EnsureRoles(ctx context.Context, roles []*models.Role) error {
+	args := m.Called(ctx, roles)
+	return args.Error(0)
+}
+
+func (m *MockRoleRepository) CreateRole(ctx context.Context, role *models.Role) error {
+	args := m.Called(ctx, role)
+	return args.Error(0)
+}
+
+func (m *MockRoleRepository) GetRole(ctx context.Context, roleID string) (*models.Role, error) {
+	args := m.Called(ctx, roleID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Role), args.Error(1)
+}
+
+func (m *MockRoleRepository) GetRoles(ctx context.Context, roleIDs []string) ([]*models.Role, error) {
+	args := m.Called(ctx, roleIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Role), args.Error(1)
+}
+
+func (m *MockRoleRepository) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Role), args.Error(1)
+}
+
+func (m *MockRoleRepository) UpdateRole(ctx context.Context, roleID string, description string, permissions []string) (bool, error) {
+	args := m.Called(ctx, roleID, description, permissions)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRoleRepository) DeleteRole(ctx context.Context, roleID string) (bool, error) {
+	args := m.Called(ctx, roleID)
+	return args.Bool(0), args.Error(1)
+}
+
+// MockAddressRepository mocks the address repository
+type MockAddressRepository struct {
+	mock.Mock
+}
+
+func (m *MockAddressRepository) CreateAddress(ctx context.Context, address *models.Address) error {
+	args := m.Called(ctx, address)
+	return args.Error(0)
+}
+
+func (m *MockAddressRepository) GetAddress(ctx context.Context, userID, addressID string) (*models.Address, error) {
+	args := m.Called(ctx, userID, addressID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Address), args.Error(1)
+}
+
+func (m *MockAddressRepository) ListAddresses(ctx context.Context, userID string) ([]*models.Address, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Address), args.Error(1)
+}
+
+func (m *MockAddressRepository) CountAddresses(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockAddressRepository) UpdateAddress(ctx context.Context, userID string, address *models.Address) (bool, error) {
+	args := m.Called(ctx, userID, address)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockAddressRepository) SetDefaultAddress(ctx context.Context, userID, addressID string) (bool, error) {
+	args := m.Called(ctx, userID, addressID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockAddressRepository) DeleteAddress(ctx context.Context, userID, addressID string) (bool, error) {
+	args := m.Called(ctx, userID, addressID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockAddressRepository) DeleteAddressesByUser(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// MockDeliveryZoneRepository mocks the delivery zone repository
+type MockDeliveryZoneRepository struct {
+	mock.Mock
+}
+
+func (m *MockDeliveryZoneRepository) CreateDeliveryZone(ctx context.Context, zone *models.DeliveryZone) error {
+	args := m.Called(ctx, zone)
+	return args.Error(0)
+}
+
+func (m *MockDeliveryZoneRepository) GetDeliveryZone(ctx context.Context, zoneID string) (*models.DeliveryZone, error) {
+	args := m.Called(ctx, zoneID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.DeliveryZone), args.Error(1)
+}
+
+func (m *MockDeliveryZoneRepository) ListDeliveryZones(ctx context.Context, activeOnly bool) ([]*models.DeliveryZone, error) {
+	args := m.Called(ctx, activeOnly)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.DeliveryZone), args.Error(1)
+}
+
+func (m *MockDeliveryZoneRepository) UpdateDeliveryZone(ctx context.Context, zone *models.DeliveryZone) (bool, error) {
+	args := m.Called(ctx, zone)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDeliveryZoneRepository) DeleteDeliveryZone(ctx context.Context, zoneID string) (bool, error) {
+	args := m.Called(ctx, zoneID)
+	return args.Bool(0), args.Error(1)
+}
+
+// MockShipmentRepository mocks the shipment repository
+type MockShipmentRepository struct {
+	mock.Mock
+}
+
+func (m *MockShipmentRepository) CreateShipment(ctx context.Context, shipment *models.Shipment) error {
+	args := m.Called(ctx, shipment)
+	return args.Error(0)
+}
+
+func (m *MockShipmentRepository) DeleteShipment(ctx context.Context, shipmentID string) error {
+	args := m.Called(ctx, shipmentID)
+	return args.Error(0)
+}
+
+func (m *MockShipmentRepository) GetShipment(ctx context.Context, shipmentID string) (*models.Shipment, error) {
+	args := m.Called(ctx, shipmentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Shipment), args.Error(1)
+}
+
+func (m *MockShipmentRepository) ListShipmentsByOrder(ctx context.Context, orderID string) ([]*models.Shipment, error) {
+	args := m.Called(ctx, orderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Shipment), args.Error(1)
+}
+
+func (m *MockShipmentRepository) UpdateShipmentTracking(ctx context.Context, shipmentID string, updates map[string]interface{}) (bool, error) {
+	args := m.Called(ctx, shipmentID, updates)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockShipmentRepository) DispatchShipment(ctx context.Context, shipmentID, status, deliveryCode string, dispatchedAt time.Time) (bool, error) {
+	args := m.Called(ctx, shipmentID, status, deliveryCode, dispatchedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockShipmentRepository) RecordDeliveryCodeFailure(ctx context.Context, shipmentID string) error {
+	args := m.Called(ctx, shipmentID)
+	return args.Error(0)
+}
+
+func (m *MockShipmentRepository) DeliverShipment(ctx context.Context, shipmentID string, proof *models.ProofOfDelivery) (bool, error) {
+	args := m.Called(ctx, shipmentID, proof)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockShipmentRepository) FailShipment(ctx context.Context, shipmentID, reason string, failedAt time.Time) (bool, error) {
+	args := m.Called(ctx, shipmentID, reason, failedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockShipmentRepository) SaveSignature(ctx context.Context, signature *models.DeliverySignature) error {
+	args := m.Called(ctx, signature)
+	return args.Error(0)
+}
+
+func (m *MockShipmentRepository) GetSignature(ctx context.Context, shipmentID string) (*models.DeliverySignature, error) {
+	args := m.Called(ctx, shipmentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.DeliverySignature), args.Error(1)
+}
+
+func (m *MockShipmentRepository) AnonymiseShipmentsByUser(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
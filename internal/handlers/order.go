@@ -2,70 +2,85 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"fmt"
+	"time"
 
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// CreateOrder creates a new order for the authenticated user
-func CreateOrder(c *gin.Context) {
-	var req models.CreateOrderRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+// orderLine is a product and quantity requested for a new order
+type orderLine struct {
+	ProductID string
+	Quantity  int
+}
 
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
-		return
+// errOrderRejected aborts an order's unit of work when the order is refused
+// for a business reason rather than a storage failure
+var errOrderRejected = errors.New("order rejected")
+
+// orderError describes why an order could not be placed as an HTTP response
+type orderError struct {
+	Status int
+	Body   gin.H
+}
+
+// priceItem returns the gross cost of qty units of a product and the discount
+// earned from the product's own percentage discount.
+func priceItem(prod *models.Product, qty int) (float64, float64) {
+	itemCost := prod.Price * float64(qty)
+	itemDiscount := 0.0
+	if prod.Discount > 0 {
+		itemDiscount = (prod.Discount / 100.0) * itemCost
 	}
+	return itemCost, itemDiscount
+}
 
+// placeOrder prices the requested lines, applies an optional coupon, reserves
+// stock and persists the order together with its invoice, which is paid with
+// paymentProvider (empty for the default). Orders sent to one of the user's
+// addresses carry the delivery fee of its zone. It backs both CreateOrder and
+// CheckoutCart.
+func placeOrder(ctx context.Context, userID, phone, addressID string, lines []orderLine, couponCode, paymentProvider string, metadata models.OrderMetadata) (*models.Order, *orderError) {
 	productRepo := NewProductRepository
 	orderRepo := NewOrderRepository
 
+	paymentProvider, perr := checkoutPaymentProvider(paymentProvider)
+	if perr != nil {
+		return nil, perr
+	}
+
+	// Customers can no longer set their own discounts; only promotions grant them
+	metadata.Discounts = nil
+
 	// Build order items and calculate cost/discounts
 	var items []models.OrderItem
+	products := make(map[string]*models.Product)
 	var cost float64
 	var discountsTotal float64
-	metadata := models.OrderMetadata{}
-	if req.Metadata != nil {
-		metadata = *req.Metadata
-	}
 
-	for _, p := range req.Products {
-		prod, err := productRepo.GetProductByID(context.Background(), p.ProductID)
+	for _, p := range lines {
+		prod, err := productRepo.GetProductByID(ctx, p.ProductID)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("product not found: %s", p.ProductID)})
-				return
+				return nil, &orderError{http.StatusBadRequest, gin.H{"error": fmt.Sprintf("product not found: %s", p.ProductID)}}
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve product"})
-			return
+			return nil, &orderError{http.StatusInternalServerError, gin.H{"error": "failed to retrieve product"}}
 		}
 
+		products[prod.ID] = prod
+
 		qty := p.Quantity
 		if qty < 1 { qty = 1 }
 
-		itemCost := prod.Price * float64(qty)
-		itemDiscount := 0.0
-
 		// apply product's inherent discount (percentage) if set
-		if prod.Discount > 0 {
-			itemDiscount += (prod.Discount / 100.0) * itemCost
-		}
-
-		// apply metadata overrides for per-product absolute discounts
-		if metadata.Discounts != nil {
-			if d, ok := metadata.Discounts[p.ProductID]; ok {
-				itemDiscount += d
-			}
-		}
+		itemCost, itemDiscount := priceItem(prod, qty)
 
 		cost += itemCost
 		discountsTotal += itemDiscount
@@ -78,39 +93,138 @@ func CreateOrder(c *gin.Context) {
 		})
 	}
 
+	var promo *models.Promotion
+	var promoDiscount float64
+	if couponCode != "" {
+		var oerr *orderError
+		promo, promoDiscount, oerr = applyCoupon(ctx, userID, couponCode, items)
+		if oerr != nil {
+			return nil, oerr
+		}
+		discountsTotal += promoDiscount
+	}
+
 	totalCost := cost - discountsTotal
 	if totalCost < 0 { totalCost = 0 }
 
+	// Orders without an address are collected by the customer
+	var delivery *models.OrderDelivery
+	var deliveryFee float64
+	if addressID != "" {
+		var oerr *orderError
+		delivery, oerr = quoteDelivery(ctx, userID, addressID, time.Now())
+		if oerr != nil {
+			return nil, oerr
+		}
+		deliveryFee = delivery.Fee
+		totalCost += deliveryFee
+		metadata.LocationDetails = delivery.Address.Summary()
+	}
+
 	order := &models.Order{
-		ID:        uuid.New().String(),
-		Products:  items,
-		Cost:      cost,
-		Discount:  discountsTotal,
-		TotalCost: totalCost,
-		UserID:    userID.(string),
-		Phone:     req.Phone,
-		Metadata:  metadata,
-	}
-
-	if err := orderRepo.CreateOrder(context.Background(), order); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order"})
+		ID:            uuid.New().String(),
+		Products:      items,
+		Cost:          cost,
+		Discount:      discountsTotal,
+		DeliveryFee:   deliveryFee,
+		TotalCost:     totalCost,
+		UserID:        userID,
+		Phone:         phone,
+		Metadata:      metadata,
+		Delivery:      delivery,
+		StockReserved: true,
+	}
+
+	// Stock reservation, coupon redemption, order and invoice commit or roll back together
+	var oerr *orderError
+	err := NewUnitOfWork.Do(ctx, func(ctx context.Context) error {
+		oerr = nil
+		order.Promotion = nil
+
+		// Reserve stock before persisting the order so we never sell units we do not have
+		shortages, err := reserveStock(ctx, items, products)
+		if err != nil {
+			oerr = &orderError{http.StatusInternalServerError, gin.H{"error": "failed to reserve stock"}}
+			return err
+		}
+		if len(shortages) > 0 {
+			oerr = &orderError{http.StatusConflict, gin.H{"error": "insufficient stock", "products": shortages}}
+			return errOrderRejected
+		}
+		database.OnRollback(ctx, func() { _ = releaseStock(context.Background(), items) })
+
+		if promo != nil {
+			applied, perr := redeemPromotion(ctx, promo, promoDiscount, userID, order.ID)
+			if perr != nil {
+				oerr = perr
+				return errOrderRejected
+			}
+			order.Promotion = applied
+		}
+
+		if err := orderRepo.CreateOrder(ctx, order); err != nil {
+			oerr = &orderError{http.StatusInternalServerError, gin.H{"error": "failed to create order"}}
+			return err
+		}
+
+		// Create corresponding invoice
+		invoiceRepo := NewInvoiceRepository
+		invoice := &models.Invoice{
+			ID:            uuid.New().String(),
+			OrderID:       order.ID,
+			InvoiceAmount: order.TotalCost,
+			PaidAmount:    0,
+			TaxAmount:     0,
+			DeliveryFee:   order.DeliveryFee,
+			Type:          models.InvoiceTypePayable,
+			PaidOn:        make(map[string]float64),
+			PaymentProvider: paymentProvider,
+		}
+
+		if err := invoiceRepo.CreateInvoice(ctx, invoice); err != nil {
+			oerr = &orderError{http.StatusInternalServerError, gin.H{"error": "failed to create invoice"}}
+			return err
+		}
+
+		return nil
+	})
+	if oerr != nil {
+		return nil, oerr
+	}
+	if err != nil {
+		return nil, &orderError{http.StatusInternalServerError, gin.H{"error": "failed to create order"}}
+	}
+
+	return order, nil
+}
+
+// CreateOrder creates a new order for the authenticated user
+func CreateOrder(c *gin.Context) {
+	var req models.CreateOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Create corresponding invoice
-	invoiceRepo := NewInvoiceRepository
-	invoice := &models.Invoice{
-		ID:            uuid.New().String(),
-		OrderID:       order.ID,
-		InvoiceAmount: order.TotalCost,
-		PaidAmount:    0,
-		TaxAmount:     0,
-		Type:          models.InvoiceTypePayable,
-		PaidOn:        make(map[string]float64),
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	metadata := models.OrderMetadata{}
+	if req.Metadata != nil {
+		metadata = *req.Metadata
+	}
+
+	lines := make([]orderLine, 0, len(req.Products))
+	for _, p := range req.Products {
+		lines = append(lines, orderLine{ProductID: p.ProductID, Quantity: p.Quantity})
 	}
 
-	if err := invoiceRepo.CreateInvoice(context.Background(), invoice); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invoice"})
+	order, oerr := placeOrder(context.Background(), userID.(string), req.Phone, req.AddressID, lines, req.CouponCode, req.PaymentProvider, metadata)
+	if oerr != nil {
+		c.JSON(oerr.Status, oerr.Body)
 		return
 	}
 
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -52,6 +53,23 @@ func AdminUpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
+	// An order is only complete once every item has been delivered with proof
+	if s == models.OrderStatusComplete {
+		order, ok := findOrderParam(c)
+		if !ok {
+			return
+		}
+		left, err := undeliveredItems(context.Background(), order)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve shipments"})
+			return
+		}
+		if len(left) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "order has items without proof of delivery", "undelivered": left})
+			return
+		}
+	}
+
 	orderRepo := NewOrderRepository
 	if err := orderRepo.UpdateOrderStatus(context.Background(), orderID, s); err != nil {
 		if err.Error() == "order not found" {
@@ -76,11 +94,47 @@ func AdminUpdateOrderStatus(c *gin.Context) {
 		invoice, err := invoiceRepo.GetInvoiceByOrderID(context.Background(), orderID)
 		if err == nil && invoice.Type == models.InvoiceTypePayable && invoice.PaidAmount > 0 {
 			// Reverse invoice payments (clears paid amounts and marks invoice receivable)
-			_ = invoiceRepo.ReverseAllPayments(context.Background(), invoice.ID, time.Now().Format("2006-01-02"))
+			today := time.Now().Format("2006-01-02")
+			if err := invoiceRepo.ReverseAllPayments(context.Background(), invoice.ID, today); err == nil {
+				// Mark payment records as reversed
+				paymentRepo := NewPaymentRepository
+				_ = paymentRepo.ReversePaymentsByInvoiceID(context.Background(), invoice.ID)
+
+				// Record what the customer is owed so it can be paid back with an M-Pesa refund
+				revRepo := NewReversalRepository
+				_ = revRepo.CreateReversalRecord(context.Background(), &models.ReversalRecord{
+					ID:         fmt.Sprintf("rev_%s_%d", invoice.ID, time.Now().Unix()),
+					InvoiceID:  invoice.ID,
+					Amount:     invoice.PaidAmount,
+					Date:       today,
+					Phone:      order.Phone,
+					AdminID:    c.GetString("userID"),
+					Reason:     "order " + s,
+					Status:     models.ReversalStatusCompleted,
+					RefundOwed: invoice.PaidAmount,
+				})
+			}
+		}
+
+		// Return reserved stock to inventory; the reservation flag ensures this happens once per order
+		if len(order.Products) > 0 {
+			released, err := orderRepo.ReleaseStockReservation(context.Background(), orderID)
+			if err == nil && released {
+				_ = releaseStock(context.Background(), order.Products)
+				order.StockReserved = false
+			}
+		}
 
-			// Mark payment records as reversed
-			paymentRepo := NewPaymentRepository
-			_ = paymentRepo.ReversePaymentsByInvoiceID(context.Background(), invoice.ID)
+		// Give back the coupon use, to both the promotion's and the customer's limits;
+		// the released flag ensures this happens once per order
+		if order.Promotion != nil && !order.Promotion.Released {
+			released, err := orderRepo.ReleasePromotion(context.Background(), orderID)
+			if err == nil && released {
+				promoRepo := NewPromotionRepository
+				_ = promoRepo.ReleaseRedemption(context.Background(), order.Promotion.PromotionID, order.UserID)
+				_ = promoRepo.DeleteRedemption(context.Background(), order.Promotion.RedemptionID)
+				order.Promotion.Released = true
+			}
 		}
 	}
 
@@ -91,10 +91,17 @@ func TestAdminUpdateOrderStatus_Success(t *testing.T) {
 	mockOrderRepo.On("GetOrderByID", mock.Anything, orderID).Return(&models.Order{
 		ID:        orderID,
 		UserID:    uuid.New().String(),
+		Products:  []models.OrderItem{{ProductID: "p1", Quantity: 1, Price: 100.0}},
 		Status:    models.OrderStatusComplete,
 		TotalCost: 100.0,
 	}, nil)
 
+	// Every item has been delivered with proof
+	mockShipmentRepo := useShipmentRepo(t)
+	mockShipmentRepo.On("ListShipmentsByOrder", mock.Anything, orderID).Return([]*models.Shipment{
+		{Status: models.ShipmentStatusDelivered, Items: []models.ShipmentItem{{ProductID: "p1", Quantity: 1}}},
+	}, nil)
+
 	oldOrderRepo := NewOrderRepository
 	NewOrderRepository = OrderRepository(mockOrderRepo)
 	defer func() { NewOrderRepository = oldOrderRepo }()
@@ -174,6 +181,8 @@ func TestAdminUpdateOrderStatus_OrderNotFound(t *testing.T) {
 	// Setup mock to return "order not found" error
 	mockOrderRepo := new(MockOrderRepository)
 	mockOrderRepo.On("UpdateOrderStatus", mock.Anything, orderID, models.OrderStatusComplete).Return(assert.AnError)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, orderID).Return(&models.Order{ID: orderID}, nil)
+	useShipmentRepo(t).On("ListShipmentsByOrder", mock.Anything, orderID).Return([]*models.Shipment{}, nil)
 
 	oldOrderRepo := NewOrderRepository
 	NewOrderRepository = OrderRepository(mockOrderRepo)
@@ -229,16 +238,25 @@ func TestAdminUpdateOrderStatus_CancelledWithReversal(t *testing.T) {
 	mockPaymentRepo := new(MockPaymentRepository)
 	mockPaymentRepo.On("ReversePaymentsByInvoiceID", mock.Anything, invoiceID).Return(nil)
 
+	// The amount paid is recorded as a refund owed to the customer
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("CreateReversalRecord", mock.Anything, mock.MatchedBy(func(rev *models.ReversalRecord) bool {
+		return rev.InvoiceID == invoiceID && rev.RefundOwed == 50.0 && rev.Status == models.ReversalStatusCompleted
+	})).Return(nil)
+
 	oldOrderRepo := NewOrderRepository
 	oldInvoiceRepo := NewInvoiceRepository
 	oldPaymentRepo := NewPaymentRepository
+	oldReversalRepo := NewReversalRepository
 	NewOrderRepository = OrderRepository(mockOrderRepo)
 	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
 	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	NewReversalRepository = ReversalRepository(mockReversalRepo)
 	defer func() {
 		NewOrderRepository = oldOrderRepo
 		NewInvoiceRepository = oldInvoiceRepo
 		NewPaymentRepository = oldPaymentRepo
+		NewReversalRepository = oldReversalRepo
 	}()
 
 	w := httptest.NewRecorder()
@@ -253,4 +271,170 @@ func TestAdminUpdateOrderStatus_CancelledWithReversal(t *testing.T) {
 	mockOrderRepo.AssertExpectations(t)
 	mockInvoiceRepo.AssertExpectations(t)
 	mockPaymentRepo.AssertExpectations(t)
+	mockReversalRepo.AssertExpectations(t)
+}
+
+func TestAdminUpdateOrderStatus_CancelledReleasesStock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	orderID := uuid.New().String()
+	productID := uuid.New().String()
+
+	body := []byte(`{"status":"cancelled"}`)
+	httpReq := httptest.NewRequest("PUT", "/admin/orders/"+orderID+"/status", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Setup mocks
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("UpdateOrderStatus", mock.Anything, orderID, models.OrderStatusCancelled).Return(nil)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, orderID).Return(&models.Order{
+		ID:            orderID,
+		Status:        models.OrderStatusCancelled,
+		Products:      []models.OrderItem{{ProductID: productID, Quantity: 2}, {ProductID: productID, Quantity: 1}},
+		StockReserved: true,
+	}, nil)
+	mockOrderRepo.On("ReleaseStockReservation", mock.Anything, orderID).Return(true, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByOrderID", mock.Anything, orderID).Return(nil, assert.AnError)
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("ReleaseStock", mock.Anything, productID, 3).Return(nil)
+
+	oldOrderRepo := NewOrderRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	oldProductRepo := NewProductRepository
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	NewProductRepository = ProductRepository(mockProductRepo)
+	defer func() {
+		NewOrderRepository = oldOrderRepo
+		NewInvoiceRepository = oldInvoiceRepo
+		NewProductRepository = oldProductRepo
+	}()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: orderID}}
+
+	AdminUpdateOrderStatus(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.Order
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.False(t, response.StockReserved)
+
+	mockOrderRepo.AssertExpectations(t)
+	mockProductRepo.AssertExpectations(t)
+}
+
+func TestAdminUpdateOrderStatus_ReturnedAlreadyReleased(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	orderID := uuid.New().String()
+	productID := uuid.New().String()
+
+	body := []byte(`{"status":"returned"}`)
+	httpReq := httptest.NewRequest("PUT", "/admin/orders/"+orderID+"/status", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Stock was already returned by an earlier cancellation
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("UpdateOrderStatus", mock.Anything, orderID, models.OrderStatusReturned).Return(nil)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, orderID).Return(&models.Order{
+		ID:       orderID,
+		Status:   models.OrderStatusReturned,
+		Products: []models.OrderItem{{ProductID: productID, Quantity: 2}},
+	}, nil)
+	mockOrderRepo.On("ReleaseStockReservation", mock.Anything, orderID).Return(false, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByOrderID", mock.Anything, orderID).Return(nil, assert.AnError)
+
+	mockProductRepo := new(MockProductRepository)
+
+	oldOrderRepo := NewOrderRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	oldProductRepo := NewProductRepository
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	NewProductRepository = ProductRepository(mockProductRepo)
+	defer func() {
+		NewOrderRepository = oldOrderRepo
+		NewInvoiceRepository = oldInvoiceRepo
+		NewProductRepository = oldProductRepo
+	}()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: orderID}}
+
+	AdminUpdateOrderStatus(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockProductRepo.AssertNotCalled(t, "ReleaseStock", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAdminUpdateOrderStatus_CancelledReleasesCoupon(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	orderID := uuid.New().String()
+	order := &models.Order{
+		ID:        orderID,
+		UserID:    "user-1",
+		Status:    models.OrderStatusCancelled,
+		Promotion: &models.AppliedPromotion{PromotionID: "promo-1", Code: "WELCOME10", RedemptionID: "redemption-1", Discount: 10},
+	}
+
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("UpdateOrderStatus", mock.Anything, orderID, mock.Anything).Return(nil)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, orderID).Return(order, nil)
+	mockOrderRepo.On("ReleasePromotion", mock.Anything, orderID).Return(true, nil).Once()
+	mockOrderRepo.On("ReleasePromotion", mock.Anything, orderID).Return(false, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByOrderID", mock.Anything, orderID).Return(nil, assert.AnError)
+
+	mockPromoRepo := new(MockPromotionRepository)
+	mockPromoRepo.On("ReleaseRedemption", mock.Anything, "promo-1", "user-1").Return(nil).Once()
+	mockPromoRepo.On("DeleteRedemption", mock.Anything, "redemption-1").Return(nil).Once()
+
+	oldOrderRepo := NewOrderRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	oldPromoRepo := NewPromotionRepository
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	NewPromotionRepository = PromotionRepository(mockPromoRepo)
+	defer func() {
+		NewOrderRepository = oldOrderRepo
+		NewInvoiceRepository = oldInvoiceRepo
+		NewPromotionRepository = oldPromoRepo
+	}()
+
+	updateStatus := func(status string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("PUT", "/admin/orders/"+orderID+"/status", bytes.NewBufferString(`{"status":"`+status+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = gin.Params{gin.Param{Key: "id", Value: orderID}}
+		AdminUpdateOrderStatus(c)
+		return w
+	}
+
+	w := updateStatus(models.OrderStatusCancelled)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.Order
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.True(t, response.Promotion.Released)
+
+	// A later return of the same order gives nothing back a second time
+	order.Promotion.Released = false
+	w = updateStatus(models.OrderStatusReturned)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockOrderRepo.AssertExpectations(t)
+	mockPromoRepo.AssertExpectations(t)
 }
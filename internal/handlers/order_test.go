@@ -3,10 +3,12 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -40,15 +42,17 @@ func TestCreateOrder_Success(t *testing.T) {
 		ID:    productID,
 		Name:  "Test Product",
 		Price: 100,
+		Stock: 5,
 	}
 	
 	// Setup mocks
 	mockProductRepo := new(MockProductRepository)
 	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(product, nil)
+	mockProductRepo.On("ReserveStock", mock.Anything, productID, 2).Return(nil)
 	
 	mockOrderRepo := new(MockOrderRepository)
 	mockOrderRepo.On("CreateOrder", mock.Anything, mock.MatchedBy(func(o *models.Order) bool {
-		return o.UserID == userID && len(o.Products) > 0
+		return o.UserID == userID && len(o.Products) > 0 && o.StockReserved
 	})).Return(nil)
 	
 	mockInvoiceRepo := new(MockInvoiceRepository)
@@ -204,3 +208,168 @@ func TestListOrders_Success(t *testing.T) {
 	
 	mockOrderRepo.AssertExpectations(t)
 }
+
+func TestCreateOrder_InsufficientStock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+
+	product := &models.Product{
+		ID:    productID,
+		Name:  "Scarce Product",
+		Price: 100,
+		Stock: 1,
+	}
+
+	// Setup mocks; no reservation or order should be attempted
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(product, nil)
+
+	mockOrderRepo := new(MockOrderRepository)
+
+	oldProductRepo := NewProductRepository
+	oldOrderRepo := NewOrderRepository
+	NewProductRepository = ProductRepository(mockProductRepo)
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	defer func() {
+		NewProductRepository = oldProductRepo
+		NewOrderRepository = oldOrderRepo
+	}()
+
+	body := []byte(`{"phone":"254712345678","products":[{"productId":"` + productID + `","quantity":3}]}`)
+	httpReq := httptest.NewRequest("POST", "/orders", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	CreateOrder(c)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var response struct {
+		Error    string                 `json:"error"`
+		Products []models.StockShortage `json:"products"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, "insufficient stock", response.Error)
+	assert.Len(t, response.Products, 1)
+	assert.Equal(t, productID, response.Products[0].ProductID)
+	assert.Equal(t, 3, response.Products[0].Requested)
+	assert.Equal(t, 1, response.Products[0].Available)
+
+	mockProductRepo.AssertNotCalled(t, "ReserveStock", mock.Anything, mock.Anything, mock.Anything)
+	mockOrderRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestCreateOrder_ReservationLostRace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	firstID := uuid.New().String()
+	secondID := uuid.New().String()
+
+	first := &models.Product{ID: firstID, Name: "First", Price: 10, Stock: 5}
+	second := &models.Product{ID: secondID, Name: "Second", Price: 20, Stock: 5}
+
+	// The second reservation fails because another order took the stock after our snapshot
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, firstID).Return(first, nil)
+	mockProductRepo.On("GetProductByID", mock.Anything, secondID).Return(second, nil).Once()
+	mockProductRepo.On("GetProductByID", mock.Anything, secondID).Return(&models.Product{ID: secondID, Name: "Second", Stock: 1}, nil)
+	mockProductRepo.On("ReserveStock", mock.Anything, firstID, 1).Return(nil)
+	mockProductRepo.On("ReserveStock", mock.Anything, secondID, 2).Return(errors.New("insufficient stock"))
+	mockProductRepo.On("ReleaseStock", mock.Anything, firstID, 1).Return(nil)
+
+	mockOrderRepo := new(MockOrderRepository)
+
+	oldProductRepo := NewProductRepository
+	oldOrderRepo := NewOrderRepository
+	NewProductRepository = ProductRepository(mockProductRepo)
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	defer func() {
+		NewProductRepository = oldProductRepo
+		NewOrderRepository = oldOrderRepo
+	}()
+
+	body := []byte(`{"phone":"254712345678","products":[{"productId":"` + firstID + `","quantity":1},{"productId":"` + secondID + `","quantity":2}]}`)
+	httpReq := httptest.NewRequest("POST", "/orders", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	CreateOrder(c)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var response struct {
+		Products []models.StockShortage `json:"products"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Len(t, response.Products, 1)
+	assert.Equal(t, secondID, response.Products[0].ProductID)
+	assert.Equal(t, 1, response.Products[0].Available)
+
+	// The first reservation is rolled back and no order is written
+	mockProductRepo.AssertCalled(t, "ReleaseStock", mock.Anything, firstID, 1)
+	mockOrderRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestCreateOrder_InvoiceFailureRollsBack(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+
+	product := &models.Product{ID: productID, Name: "Test Product", Price: 100, Stock: 5}
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(product, nil)
+	mockProductRepo.On("ReserveStock", mock.Anything, productID, 2).Return(nil)
+	mockProductRepo.On("ReleaseStock", mock.Anything, productID, 2).Return(nil)
+
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("CreateOrder", mock.Anything, mock.Anything).Return(nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("CreateInvoice", mock.Anything, mock.Anything).Return(errors.New("write failed"))
+
+	uow := database.NewInMemoryUnitOfWork()
+
+	oldProductRepo := NewProductRepository
+	oldOrderRepo := NewOrderRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	oldUnitOfWork := NewUnitOfWork
+	NewProductRepository = ProductRepository(mockProductRepo)
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	NewUnitOfWork = uow
+	defer func() {
+		NewProductRepository = oldProductRepo
+		NewOrderRepository = oldOrderRepo
+		NewInvoiceRepository = oldInvoiceRepo
+		NewUnitOfWork = oldUnitOfWork
+	}()
+
+	body := []byte(`{"phone":"254712345678","products":[{"productId":"` + productID + `","quantity":2}]}`)
+	httpReq := httptest.NewRequest("POST", "/orders", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	CreateOrder(c)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, 1, uow.Rollbacks)
+	assert.Equal(t, 0, uow.Commits)
+	mockProductRepo.AssertCalled(t, "ReleaseStock", mock.Anything, productID, 2)
+}
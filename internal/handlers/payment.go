@@ -2,13 +2,17 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/eddie-wainaina1/maggiesb/internal/payment/provider"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -19,12 +23,53 @@ var mpesaClient *mpesa.Client
 // InitMpesaClient initializes the M-Pesa client with credentials from environment
 func InitMpesaClient() error {
 	config := mpesa.Config{
-		ConsumerKey:       os.Getenv("MPESA_CONSUMER_KEY"),
-		ConsumerSecret:    os.Getenv("MPESA_CONSUMER_SECRET"),
-		BusinessShortCode: os.Getenv("MPESA_BUSINESS_SHORTCODE"),
-		PassKey:           os.Getenv("MPESA_PASSKEY"),
-		CallbackURL:       os.Getenv("MPESA_CALLBACK_URL"),
-		Environment:       os.Getenv("MPESA_ENV"),
+		ConsumerKey:        os.Getenv("MPESA_CONSUMER_KEY"),
+		ConsumerSecret:     os.Getenv("MPESA_CONSUMER_SECRET"),
+		BusinessShortCode:  os.Getenv("MPESA_BUSINESS_SHORTCODE"),
+		PassKey:            os.Getenv("MPESA_PASSKEY"),
+		CallbackURL:        os.Getenv("MPESA_CALLBACK_URL"),
+		Environment:        os.Getenv("MPESA_ENV"),
+		BaseURL:            os.Getenv("MPESA_BASE_URL"),
+		C2BConfirmationURL: c2bCallbackURL(os.Getenv("MPESA_C2B_CONFIRMATION_URL")),
+		C2BValidationURL:   c2bCallbackURL(os.Getenv("MPESA_C2B_VALIDATION_URL")),
+		C2BResponseType:    os.Getenv("MPESA_C2B_RESPONSE_TYPE"),
+		InitiatorName:      os.Getenv("MPESA_INITIATOR_NAME"),
+		InitiatorPassword:  os.Getenv("MPESA_INITIATOR_PASSWORD"),
+		PublicKeyPath:      os.Getenv("MPESA_PUBLIC_KEY_PATH"),
+		ReversalResultURL:  os.Getenv("MPESA_REVERSAL_RESULT_URL"),
+		ReversalTimeoutURL: os.Getenv("MPESA_REVERSAL_TIMEOUT_URL"),
+		B2CShortCode:       os.Getenv("MPESA_B2C_SHORTCODE"),
+		B2CResultURL:       os.Getenv("MPESA_B2C_RESULT_URL"),
+		B2CTimeoutURL:      os.Getenv("MPESA_B2C_TIMEOUT_URL"),
+	}
+	c2bRejectUnmatched = os.Getenv("MPESA_C2B_REJECT_UNMATCHED") == "true"
+
+	// Resilience settings: MPESA_HTTP_TIMEOUT and MPESA_BREAKER_COOLDOWN are
+	// durations such as "10s"; MPESA_MAX_RETRIES and MPESA_BREAKER_THRESHOLD are
+	// counts, with a negative value turning retries or the breaker off
+	for name, target := range map[string]*time.Duration{
+		"MPESA_HTTP_TIMEOUT":     &config.Timeout,
+		"MPESA_BREAKER_COOLDOWN": &config.BreakerCooldown,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid %s %q", name, v)
+			}
+			*target = d
+		}
+	}
+	for name, target := range map[string]*int{
+		"MPESA_MAX_RETRIES":       &config.MaxRetries,
+		"MPESA_BREAKER_THRESHOLD": &config.BreakerThreshold,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", name, v)
+			}
+			*target = n
+		}
 	}
 
 	if config.ConsumerKey == "" || config.ConsumerSecret == "" {
@@ -39,8 +84,10 @@ func InitMpesaClient() error {
 	return nil
 }
 
-// InitiateMpesaPayment initiates an M-Pesa STK Push payment for an invoice
-func InitiateMpesaPayment(c *gin.Context) {
+// InitiatePayment starts a payment for an invoice with the invoice's payment
+// provider: an STK Push prompt for M-Pesa, a hosted checkout page for cards, or a
+// cash reference the rider collects against for cash on delivery
+func InitiatePayment(c *gin.Context) {
 	// Check authentication first
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -49,15 +96,15 @@ func InitiateMpesaPayment(c *gin.Context) {
 	}
 
 	// Bind and validate request
-	var req models.MpesaPaymentRequest
+	var req models.PaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Check M-Pesa client is initialized
-	if mpesaClient == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "M-Pesa client not initialized"})
+	// Check some payment provider is configured
+	if mpesaClient == nil && len(paymentProviders) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no payment provider configured"})
 		return
 	}
 
@@ -81,28 +128,99 @@ func InitiateMpesaPayment(c *gin.Context) {
 		return
 	}
 
-	// Initiate STK Push
-	stkResp, err := mpesaClient.InitiateSTKPush(
-		req.Phone,
-		strconv.FormatFloat(invoice.InvoiceAmount, 'f', 2, 64),
-		req.InvoiceID,
-	)
+	p := paymentProviderFor(invoice.PaymentProvider)
+	if p == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("payment provider not configured: %s", providerName(invoice.PaymentProvider))})
+		return
+	}
+
+	// Charge the outstanding balance, or less when the customer pays in instalments
+	outstanding := invoice.InvoiceAmount - invoice.PaidAmount
+	if outstanding <= amountTolerance {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invoice already paid"})
+		return
+	}
+	amount := outstanding
+	if req.Amount > 0 {
+		if req.Amount > outstanding+amountTolerance {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "amount exceeds outstanding balance"})
+			return
+		}
+		amount = req.Amount
+	}
+
+	phone := req.Phone
+	if phone == "" {
+		phone = order.Phone
+	}
+	if p.Name() == provider.Mpesa && len(phone) < 10 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phone is required for M-Pesa payments"})
+		return
+	}
+
+	paymentRepo := NewPaymentRepository
+
+	// Cash is collected once on delivery; asking again returns the open collection
+	if p.Name() == provider.CashOnDelivery {
+		payments, err := paymentRepo.GetPaymentsByInvoiceID(context.Background(), invoice.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payments"})
+			return
+		}
+		for _, existing := range payments {
+			if existing.Status == models.PaymentStatusAwaitingCollection {
+				c.JSON(http.StatusOK, gin.H{
+					"checkoutRequestId": existing.CheckoutRequestID,
+					"paymentId":         existing.ID,
+					"provider":          existing.Provider,
+					"status":            existing.Status,
+				})
+				return
+			}
+		}
+	}
+
+	// Daraja posts the result to a URL carrying this token, so a forged callback
+	// needs more than a guessable CheckoutRequestID
+	var callbackToken, callbackTokenHash string
+	if p.Name() == provider.Mpesa {
+		token, hash, err := newCallbackToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		callbackToken, callbackTokenHash = token, hash
+	}
+
+	initiation, err := p.Initiate(context.Background(), provider.InitiateRequest{
+		InvoiceID:     invoice.ID,
+		Phone:         phone,
+		Amount:        amount,
+		CallbackToken: callbackToken,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(mpesa.HTTPStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
 		return
 	}
 
+	status := models.PaymentStatusInitiated
+	if initiation.Offline {
+		status = models.PaymentStatusAwaitingCollection
+	}
+
 	// Create payment record
-	paymentRepo := NewPaymentRepository
 	payment := &models.PaymentRecord{
 		ID:                uuid.New().String(),
-		InvoiceID:         req.InvoiceID,
+		InvoiceID:         invoice.ID,
 		OrderID:           invoice.OrderID,
-		CheckoutRequestID: stkResp.CheckoutRequestID,
-		MerchantRequestID: stkResp.MerchantRequestID,
-		Phone:             req.Phone,
-		Amount:            invoice.InvoiceAmount,
-		Status:            "initiated",
+		Provider:          p.Name(),
+		Method:            initiation.Method,
+		CheckoutRequestID: initiation.Reference,
+		MerchantRequestID: initiation.MerchantReference,
+		Phone:             phone,
+		Amount:            amount,
+		Status:            status,
+		CallbackTokenHash: callbackTokenHash,
 	}
 
 	if err := paymentRepo.CreatePaymentRecord(context.Background(), payment); err != nil {
@@ -110,82 +228,447 @@ func InitiateMpesaPayment(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"checkoutRequestId": stkResp.CheckoutRequestID,
-		"customerMessage":   stkResp.CustomerMessage,
+	resp := gin.H{
+		"checkoutRequestId": initiation.Reference,
+		"customerMessage":   initiation.Message,
 		"paymentId":         payment.ID,
-	})
+		"provider":          payment.Provider,
+		"status":            payment.Status,
+	}
+	if initiation.RedirectURL != "" {
+		resp["redirectUrl"] = initiation.RedirectURL
+	}
+	c.JSON(http.StatusCreated, resp)
 }
 
-// HandleMpesaCallback handles M-Pesa payment callback
+// HandleMpesaCallback handles M-Pesa payment callback. Payments initiated with a
+// callback token are only settled by callbacks to the URL carrying that token.
 func HandleMpesaCallback(c *gin.Context) {
-	var callback models.MpesaCallback
-	if err := c.ShouldBindJSON(&callback); err != nil {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid callback"})
+		return
+	}
+
+	result, err := provider.ParseMpesaCallback(body)
+	if err != nil {
+		auditRejectedCallback(c, models.CallbackSourceSTKPush, models.CallbackRejectInvalidBody, "", body)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid callback"})
 		return
 	}
 
-	stkCallback := callback.Body.StkCallback
 	paymentRepo := NewPaymentRepository
-	invoiceRepo := NewInvoiceRepository
 
 	// Get payment record
-	payment, err := paymentRepo.GetPaymentByCheckoutRequestID(context.Background(), stkCallback.CheckoutRequestID)
+	payment, err := paymentRepo.GetPaymentByCheckoutRequestID(context.Background(), result.Reference)
 	if err != nil {
+		auditRejectedCallback(c, models.CallbackSourceSTKPush, models.CallbackRejectUnknown, result.Reference, body)
 		c.JSON(http.StatusOK, gin.H{"ResultCode": "1", "ResultDesc": "Payment not found"})
 		return
 	}
 
-	// Update payment status
-	status := "failed"
-	receiptNum := ""
-	transDate := ""
+	if reason := callbackTokenRejection(payment, c.Param("token")); reason != "" {
+		rejectCallback(c, models.CallbackSourceSTKPush, reason, result.Reference, body)
+		return
+	}
+	if !withinReplayWindow(paymentCreatedAt(payment)) {
+		rejectCallback(c, models.CallbackSourceSTKPush, models.CallbackRejectReplayWindow, result.Reference, body)
+		return
+	}
 
-	if stkCallback.ResultCode == 0 {
-		status = "completed"
-		// Extract M-Pesa receipt number and transaction date from callback
-		for _, item := range stkCallback.CallbackMetadata.Item {
-			if item.Name == "MpesaReceiptNumber" {
-				receiptNum = fmt.Sprintf("%v", item.Value)
-			}
-			if item.Name == "TransactionDate" {
-				transDate = fmt.Sprintf("%v", item.Value)
-			}
+	settled, err := settlePayment(context.Background(), payment, result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"ResultCode": "1", "ResultDesc": "Failed to update payment"})
+		return
+	}
+
+	// Safaricom retries callbacks; acknowledge repeats without crediting again
+	if !settled {
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback already processed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback received"})
+}
+
+// HandleCardWebhook receives signed charge results from the card gateway
+func HandleCardWebhook(c *gin.Context) {
+	p := paymentProviders[provider.Card]
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "card payments not enabled"})
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook"})
+		return
+	}
+
+	result, err := p.ParseCallback(body, c.Request.Header)
+	if errors.Is(err, provider.ErrPending) {
+		// Nothing to settle until the charge succeeds or fails
+		c.JSON(http.StatusOK, gin.H{"received": true})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook"})
+		return
+	}
+
+	payment, err := NewPaymentRepository.GetPaymentByCheckoutRequestID(context.Background(), result.Reference)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payment"})
+		return
+	}
+	if providerName(payment.Provider) != provider.Card {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
 	}
 
-	// Update payment record
-	if err := paymentRepo.UpdatePaymentStatus(context.Background(), stkCallback.CheckoutRequestID, status, receiptNum, transDate); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"ResultCode": "1", "ResultDesc": "Failed to update payment"})
+	// Gateways retry webhooks until they get a 2xx, so a failure here is retried
+	settled, err := settlePayment(context.Background(), payment, result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update payment"})
 		return
 	}
 
-	// If payment successful, record it in invoice
-	if status == "completed" {
-		invoice, err := invoiceRepo.GetInvoiceByID(context.Background(), payment.InvoiceID)
-		if err == nil {
-			// Record payment in invoice
-			dateStr := transDate // Already formatted by M-Pesa
-			if dateStr == "" {
-				dateStr = "2006-01-02" // Fallback to today
-			}
-			invoiceRepo.RecordPayment(context.Background(), payment.InvoiceID, invoice.InvoiceAmount, dateStr)
+	c.JSON(http.StatusOK, gin.H{"received": true, "settled": settled})
+}
+
+// AdminCollectCashPayment confirms cash collected on delivery for a payment and
+// credits the invoice with the amount collected (admin)
+func AdminCollectCashPayment(c *gin.Context) {
+	paymentID := c.Param("id")
+
+	var req models.CollectPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment, err := NewPaymentRepository.GetPaymentByID(context.Background(), paymentID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payment"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback received"})
+	if payment.Provider != provider.CashOnDelivery {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only cash on delivery payments can be collected"})
+		return
+	}
+	if payment.Status != models.PaymentStatusAwaitingCollection {
+		c.JSON(http.StatusConflict, gin.H{"error": "payment is not awaiting collection"})
+		return
+	}
+
+	amount := payment.Amount
+	if req.Amount > 0 {
+		amount = req.Amount
+	}
+
+	now := time.Now()
+	settlement := paymentSettlement(payment, &provider.Result{
+		Reference:    payment.CheckoutRequestID,
+		Paid:         true,
+		PaidAmount:   amount,
+		Method:       provider.MethodCash,
+		TransactedAt: &now,
+	})
+	settlement.CollectedBy = c.GetString("userID")
+
+	settled, err := creditPayment(context.Background(), payment, payment.CheckoutRequestID, settlement)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update payment"})
+		return
+	}
+	if !settled {
+		c.JSON(http.StatusConflict, gin.H{"error": "payment is not awaiting collection"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "payment collected", "amount": amount, "amountMismatch": settlement.AmountMismatch})
+}
+
+// mpesaTimeZone is the zone M-Pesa reports transaction times in (East Africa Time)
+var mpesaTimeZone = time.FixedZone("EAT", 3*60*60)
+
+// amountTolerance absorbs float rounding when comparing shilling amounts
+const amountTolerance = 0.005
+
+// settlePayment records the final result a provider reported for a payment and, if
+// the customer paid, credits the invoice with the amount actually collected. It is
+// shared by the provider callbacks and the payment reconciler. It returns false if
+// the payment was already settled.
+func settlePayment(ctx context.Context, payment *models.PaymentRecord, result *provider.Result) (bool, error) {
+	if !result.Paid {
+		return NewPaymentRepository.TransitionPaymentStatus(ctx, result.Reference, awaitingStatus(payment), models.PaymentStatusFailed, nil)
+	}
+	return creditPayment(ctx, payment, result.Reference, paymentSettlement(payment, result))
+}
+
+// awaitingStatus is the status a payment waits for its result in: "initiated", or
+// "awaiting_collection" for cash on delivery
+func awaitingStatus(payment *models.PaymentRecord) string {
+	if payment.Status == models.PaymentStatusAwaitingCollection {
+		return models.PaymentStatusAwaitingCollection
+	}
+	return models.PaymentStatusInitiated
 }
 
-// GetPaymentStatus retrieves payment status
+// creditPayment marks a payment completed with the given settlement and credits the
+// invoice. Only a payment still awaiting its result can be settled; it returns false
+// if the payment was already settled. Crediting the invoice runs in the same unit of
+// work as the status change, so a failure leaves the payment awaiting its result for
+// the next callback or reconciler run.
+func creditPayment(ctx context.Context, payment *models.PaymentRecord, reference string, settlement *models.PaymentSettlement) (bool, error) {
+	paymentRepo := NewPaymentRepository
+	invoiceRepo := NewInvoiceRepository
+	fromStatus := awaitingStatus(payment)
+
+	paidOn := paidOnDate(settlement.TransactedAt)
+
+	settled := false
+	err := NewUnitOfWork.Do(ctx, func(ctx context.Context) error {
+		settled = false
+
+		invoice, err := invoiceRepo.GetInvoiceByID(ctx, payment.InvoiceID)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve invoice: %w", err)
+		}
+		settlement.AmountMismatch = amountMismatch(payment.Amount, settlement.PaidAmount, invoice.InvoiceAmount-invoice.PaidAmount)
+
+		// Update payment record, unless a previous callback or the reconciler got there first
+		ok, err := paymentRepo.TransitionPaymentStatus(ctx, reference, fromStatus, models.PaymentStatusCompleted, settlement)
+		if err != nil || !ok {
+			return err
+		}
+		database.OnRollback(ctx, func() {
+			_, _ = paymentRepo.TransitionPaymentStatus(context.Background(), reference, models.PaymentStatusCompleted, fromStatus, nil)
+		})
+
+		// Partial payments are credited as-is; the invoice stays open for the balance
+		if err := invoiceRepo.RecordPayment(ctx, payment.InvoiceID, settlement.PaidAmount, paidOn); err != nil {
+			return err
+		}
+
+		settled = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return settled, nil
+}
+
+// paymentSettlement converts a provider result into the details stored on the
+// payment. When the provider does not report the amount (e.g. a result obtained by
+// STK query) the requested amount is assumed.
+func paymentSettlement(payment *models.PaymentRecord, result *provider.Result) *models.PaymentSettlement {
+	settlement := &models.PaymentSettlement{
+		Method:          result.Method,
+		TransactionDate: result.RawDate,
+		TransactedAt:    result.TransactedAt,
+		PaidAmount:      payment.Amount,
+	}
+	if result.PaidAmount > 0 {
+		settlement.PaidAmount = result.PaidAmount
+	}
+
+	// M-Pesa receipts stay in their own uniquely indexed field
+	if providerName(payment.Provider) == provider.Mpesa {
+		settlement.MpesaReceiptNumber = result.Receipt
+		settlement.PayerPhone = result.Payer
+	} else {
+		settlement.Receipt = result.Receipt
+	}
+
+	return settlement
+}
+
+// paidOnDate is the invoice PaidOn key for a payment: the day the provider took
+// the money, falling back to today when it did not say
+func paidOnDate(transactedAt *time.Time) string {
+	if transactedAt == nil {
+		return time.Now().Format("2006-01-02")
+	}
+	return transactedAt.In(mpesaTimeZone).Format("2006-01-02")
+}
+
+// amountMismatch compares what the provider collected with what was requested and with
+// what the invoice still owed, returning the mismatch to flag for admin review
+func amountMismatch(requested, paid, outstanding float64) string {
+	switch {
+	case paid > requested+amountTolerance || paid > outstanding+amountTolerance:
+		return models.AmountMismatchOverpaid
+	case paid < requested-amountTolerance:
+		return models.AmountMismatchUnderpaid
+	}
+	return ""
+}
+
+// AdminListPaymentsForReview lists payments flagged with an amount mismatch (admin)
+func AdminListPaymentsForReview(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	paymentRepo := NewPaymentRepository
+	payments, err := paymentRepo.GetPaymentsNeedingReview(context.Background(), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": payments, "page": page, "limit": limit})
+}
+
+// AdminReviewPayment marks a flagged payment as resolved (admin)
+func AdminReviewPayment(c *gin.Context) {
+	paymentID := c.Param("id")
+
+	var req models.ReviewPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	paymentRepo := NewPaymentRepository
+	if err := paymentRepo.MarkPaymentReviewed(context.Background(), paymentID, c.GetString("userID"), req.Note); err != nil {
+		if err.Error() == "payment not found or not awaiting review" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "payment reviewed"})
+}
+
+// GetPaymentStatus retrieves a payment's status for the user who owns its order
 func GetPaymentStatus(c *gin.Context) {
-	_, exists := c.Get("userID")
+	paymentID := c.Param("id")
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	paymentRepo := NewPaymentRepository
+	payment, err := paymentRepo.GetPaymentByID(context.Background(), paymentID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payment"})
+		return
+	}
+
+	// Verify user owns the order the payment was made for
+	orderRepo := NewOrderRepository
+	order, err := orderRepo.GetOrderByID(context.Background(), payment.OrderID)
+	if err != nil || order.UserID != userID.(string) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// ListInvoicePayments lists every payment attempt for an invoice owned by the user
+func ListInvoicePayments(c *gin.Context) {
+	invoiceID := c.Param("id")
+	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
 		return
 	}
 
-	// Note: Add a GetPaymentByID method to the repository for this
-	// For now, we'll skip ownership verification
+	invoiceRepo := NewInvoiceRepository
+	invoice, err := invoiceRepo.GetInvoiceByID(context.Background(), invoiceID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve invoice"})
+		return
+	}
+
+	// Verify user owns the order associated with invoice
+	orderRepo := NewOrderRepository
+	order, err := orderRepo.GetOrderByID(context.Background(), invoice.OrderID)
+	if err != nil || order.UserID != userID.(string) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	paymentRepo := NewPaymentRepository
+	payments, err := paymentRepo.GetPaymentsByInvoiceID(context.Background(), invoiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": payments})
+}
+
+// AdminSearchPayments searches payment records (admin)
+// Query parameters: phone, receipt, status, startDate and endDate (YYYY-MM-DD), page, limit
+func AdminSearchPayments(c *gin.Context) {
+	var query models.PaymentSearchQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, date := range []string{query.StartDate, query.EndDate} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date format, use YYYY-MM-DD"})
+			return
+		}
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	paymentRepo := NewPaymentRepository
+	payments, err := paymentRepo.SearchPayments(context.Background(), query, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search payments"})
+		return
+	}
+
+	count, err := paymentRepo.CountPayments(context.Background(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count payments"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"error": "not yet implemented"})
+	c.JSON(http.StatusOK, gin.H{
+		"data":       payments,
+		"page":       page,
+		"limit":      limit,
+		"total":      count,
+		"totalPages": (count + int64(limit) - 1) / int64(limit),
+	})
 }
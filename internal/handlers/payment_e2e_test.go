@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/eddie-wainaina1/maggiesb/internal/payment/darajasim"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// End-to-end payment scenarios: the shop's routes are served over HTTP, the M-Pesa
+// client talks to the Daraja simulator, and the simulator posts its callbacks back
+// to the shop. Payments and refunds live in small in-memory stores so callbacks
+// see what the initiating request saved.
+
+// paymentStore keeps payment records in memory
+type paymentStore struct {
+	*MockPaymentRepository
+	mu       sync.Mutex
+	payments map[string]*models.PaymentRecord // by CheckoutRequestID
+}
+
+func (s *paymentStore) CreatePaymentRecord(ctx context.Context, payment *models.PaymentRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+	stored := *payment
+	s.payments[payment.CheckoutRequestID] = &stored
+	return nil
+}
+
+func (s *paymentStore) GetPaymentByCheckoutRequestID(ctx context.Context, checkoutRequestID string) (*models.PaymentRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[checkoutRequestID]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	found := *payment
+	return &found, nil
+}
+
+func (s *paymentStore) GetStaleInitiatedPayments(ctx context.Context, olderThan time.Time, limit int) ([]*models.PaymentRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stale []*models.PaymentRecord
+	for _, payment := range s.payments {
+		createdAt, _ := time.ParseInLocation("2006-01-02 15:04:05", payment.CreatedAt, time.Local)
+		if payment.Status == models.PaymentStatusInitiated && !createdAt.After(olderThan) {
+			found := *payment
+			stale = append(stale, &found)
+		}
+	}
+	return stale, nil
+}
+
+func (s *paymentStore) TransitionPaymentStatus(ctx context.Context, checkoutID, fromStatus, toStatus string, settlement *models.PaymentSettlement) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[checkoutID]
+	if !ok || payment.Status != fromStatus {
+		return false, nil
+	}
+	payment.Status = toStatus
+	if settlement != nil {
+		payment.MpesaReceiptNumber = settlement.MpesaReceiptNumber
+		payment.PaidAmount = settlement.PaidAmount
+		payment.PayerPhone = settlement.PayerPhone
+		payment.AmountMismatch = settlement.AmountMismatch
+	}
+	return true, nil
+}
+
+// only returns the single stored payment
+func (s *paymentStore) only(t *testing.T) *models.PaymentRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.payments) != 1 {
+		t.Fatalf("expected one payment, have %d", len(s.payments))
+	}
+	for _, payment := range s.payments {
+		found := *payment
+		return &found
+	}
+	return nil
+}
+
+// refundStore keeps refunds in memory
+type refundStore struct {
+	*MockRefundRepository
+	mu      sync.Mutex
+	refunds []*models.Refund
+}
+
+func (s *refundStore) CreateRefund(ctx context.Context, refund *models.Refund) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.refunds {
+		if r.InvoiceID == refund.InvoiceID && r.Status == models.RefundStatusPending && refund.Status == models.RefundStatusPending {
+			return errors.New("refund already pending")
+		}
+	}
+	stored := *refund
+	s.refunds = append(s.refunds, &stored)
+	return nil
+}
+
+func (s *refundStore) SetRefundReferences(ctx context.Context, refundID, conversationID, originatorConversationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, refund := range s.refunds {
+		if refund.ID == refundID {
+			refund.ConversationID = conversationID
+			refund.OriginatorConversationID = originatorConversationID
+			return nil
+		}
+	}
+	return errors.New("refund not found")
+}
+
+func (s *refundStore) GetRefundsByInvoiceID(ctx context.Context, invoiceID string) ([]*models.Refund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []*models.Refund
+	for _, refund := range s.refunds {
+		if refund.InvoiceID == invoiceID {
+			r := *refund
+			found = append(found, &r)
+		}
+	}
+	return found, nil
+}
+
+func (s *refundStore) GetRefundByConversationID(ctx context.Context, conversationID, originatorConversationID string) (*models.Refund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, refund := range s.refunds {
+		if refund.ConversationID == conversationID || refund.OriginatorConversationID == originatorConversationID {
+			r := *refund
+			return &r, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (s *refundStore) TransitionRefundStatus(ctx context.Context, refundID, fromStatus, toStatus string, result *models.RefundResult) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, refund := range s.refunds {
+		if refund.ID == refundID && refund.Status == fromStatus {
+			refund.Status = toStatus
+			if result != nil {
+				refund.TransactionID = result.TransactionID
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// e2eEnv is the shop and the Daraja simulator wired together
+type e2eEnv struct {
+	sim          *darajasim.Server
+	simURL       string
+	app          *httptest.Server
+	payments     *paymentStore
+	clientConfig mpesa.Config
+}
+
+func newE2EEnv(t *testing.T) *e2eEnv {
+	gin.SetMode(gin.TestMode)
+
+	sim := darajasim.NewServer(darajasim.Config{ConsumerKey: "key", ConsumerSecret: "secret", PassKey: "passkey", InitiatorPassword: "password"})
+	simServer := httptest.NewServer(sim)
+	t.Cleanup(simServer.Close)
+
+	// The shop's routes as main.go wires them; the test user is both customer and admin
+	router := gin.New()
+	signedIn := func(c *gin.Context) { c.Set("userID", "user-1") }
+	router.POST("/api/v1/orders/:id/pay", signedIn, InitiatePayment)
+	router.POST("/api/v1/admin/invoices/:id/refund", signedIn, AdminRefundInvoice)
+	router.POST("/api/v1/mpesa/callback/:token", DarajaCallbackGuard(models.CallbackSourceSTKPush), HandleMpesaCallback)
+	router.POST("/api/v1/mpesa/b2c/result/:token", DarajaCallbackGuard(models.CallbackSourceB2C), HandleB2CResult)
+	router.POST("/api/v1/mpesa/b2c/timeout/:token", DarajaCallbackGuard(models.CallbackSourceB2C), HandleB2CTimeout)
+	router.POST("/api/v1/payments/c2b/validation/:token", DarajaCallbackGuard(models.CallbackSourceC2B), HandleC2BValidation)
+	router.POST("/api/v1/payments/c2b/confirmation/:token", DarajaCallbackGuard(models.CallbackSourceC2B), HandleC2BConfirmation)
+	app := httptest.NewServer(router)
+	t.Cleanup(app.Close)
+	// Let callbacks finish before the shop goes away (cleanups run last first)
+	t.Cleanup(sim.Wait)
+
+	keyPath := filepath.Join(t.TempDir(), "daraja.pem")
+	if err := os.WriteFile(keyPath, sim.PublicKeyPEM(), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	clientConfig := mpesa.Config{
+		ConsumerKey:        "key",
+		ConsumerSecret:     "secret",
+		BusinessShortCode:  "174379",
+		PassKey:            "passkey",
+		CallbackURL:        app.URL + "/api/v1/mpesa/callback",
+		InitiatorName:      "testapi",
+		InitiatorPassword:  "password",
+		PublicKeyPath:      keyPath,
+		B2CResultURL:       app.URL + "/api/v1/mpesa/b2c/result",
+		B2CTimeoutURL:      app.URL + "/api/v1/mpesa/b2c/timeout",
+		C2BConfirmationURL: app.URL + "/api/v1/payments/c2b/confirmation/c2b-secret",
+		C2BValidationURL:   app.URL + "/api/v1/payments/c2b/validation/c2b-secret",
+		BaseURL:            simServer.URL,
+	}
+	old := mpesaClient
+	mpesaClient = mpesa.NewClient(clientConfig)
+	t.Cleanup(func() { mpesaClient = old })
+	useCallbackSecurity(t, callbackSecurityConfig{c2bToken: "c2b-secret", c2bShortCode: "174379"})
+
+	payments := &paymentStore{MockPaymentRepository: new(MockPaymentRepository), payments: map[string]*models.PaymentRecord{}}
+	oldPaymentRepo := NewPaymentRepository
+	NewPaymentRepository = payments
+	t.Cleanup(func() { NewPaymentRepository = oldPaymentRepo })
+
+	return &e2eEnv{sim: sim, simURL: simServer.URL, app: app, payments: payments, clientConfig: clientConfig}
+}
+
+func (e *e2eEnv) post(t *testing.T, path, body string) *http.Response {
+	resp, err := http.Post(e.app.URL+path, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// useE2EOrder sets up an M-Pesa invoice of 1500 for order-1, owned by user-1
+func useE2EOrder(t *testing.T) *MockInvoiceRepository {
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", InvoiceAmount: 1500}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", UserID: "user-1", Phone: "254712345678"}, nil)
+
+	oldInvoiceRepo, oldOrderRepo := NewInvoiceRepository, NewOrderRepository
+	NewInvoiceRepository, NewOrderRepository = mockInvoiceRepo, mockOrderRepo
+	t.Cleanup(func() { NewInvoiceRepository, NewOrderRepository = oldInvoiceRepo, oldOrderRepo })
+	return mockInvoiceRepo
+}
+
+func TestE2E_MpesaPaymentCreditedOnceDespiteDuplicateCallbacks(t *testing.T) {
+	env := newE2EEnv(t)
+	mockInvoiceRepo := useE2EOrder(t)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, "inv-1", 1500.0, mock.Anything).Return(nil).Once()
+	env.sim.SetScenario(darajasim.STKPush, darajasim.Scenario{Outcome: darajasim.Succeed, Delay: 20 * time.Millisecond, Duplicates: 2})
+
+	resp := env.post(t, "/api/v1/orders/order-1/pay", `{"invoiceId":"inv-1"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	env.sim.Wait()
+
+	payment := env.payments.only(t)
+	assert.Equal(t, models.PaymentStatusCompleted, payment.Status)
+	assert.NotEmpty(t, payment.MpesaReceiptNumber)
+	assert.Equal(t, 1500.0, payment.PaidAmount)
+	mockInvoiceRepo.AssertNumberOfCalls(t, "RecordPayment", 1)
+
+	deliveries := env.sim.Deliveries()
+	assert.Len(t, deliveries, 3)
+	assert.Contains(t, deliveries[0].Response, "Callback received")
+	for _, d := range deliveries[1:] {
+		assert.Equal(t, http.StatusOK, d.StatusCode)
+		assert.Contains(t, d.Response, "Callback already processed")
+	}
+}
+
+func TestE2E_MpesaPaymentCancelled(t *testing.T) {
+	env := newE2EEnv(t)
+	mockInvoiceRepo := useE2EOrder(t)
+	env.sim.SetScenarioFor(darajasim.STKPush, "254712345678", darajasim.Scenario{Outcome: darajasim.Fail, Delay: 20 * time.Millisecond})
+
+	resp := env.post(t, "/api/v1/orders/order-1/pay", `{"invoiceId":"inv-1"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	env.sim.Wait()
+
+	assert.Equal(t, models.PaymentStatusFailed, env.payments.only(t).Status)
+	mockInvoiceRepo.AssertNotCalled(t, "RecordPayment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestE2E_MpesaPaymentUnansweredIsExpiredByReconciler(t *testing.T) {
+	env := newE2EEnv(t)
+	useE2EOrder(t)
+	env.sim.SetScenario(darajasim.STKPush, darajasim.Scenario{Outcome: darajasim.Timeout})
+
+	resp := env.post(t, "/api/v1/orders/order-1/pay", `{"invoiceId":"inv-1"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	env.sim.Wait()
+	assert.Empty(t, env.sim.Deliveries())
+
+	// Still pending at Daraja: too young to expire, then old enough
+	settled, expired := ReconcilePayments(0, time.Hour)
+	assert.Equal(t, 0, settled+expired)
+	assert.Equal(t, models.PaymentStatusInitiated, env.payments.only(t).Status)
+
+	settled, expired = ReconcilePayments(0, 0)
+	assert.Equal(t, 0, settled)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, models.PaymentStatusExpired, env.payments.only(t).Status)
+}
+
+func TestE2E_MpesaPaymentRejectedByDaraja(t *testing.T) {
+	env := newE2EEnv(t)
+	useE2EOrder(t)
+	env.sim.SetScenario(darajasim.STKPush, darajasim.Scenario{Outcome: darajasim.Reject})
+
+	resp := env.post(t, "/api/v1/orders/order-1/pay", `{"invoiceId":"inv-1"}`)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Empty(t, env.payments.payments)
+}
+
+func TestE2E_MpesaPaymentDarajaTooSlow(t *testing.T) {
+	env := newE2EEnv(t)
+	useE2EOrder(t)
+	config := env.clientConfig
+	config.Timeout = 100 * time.Millisecond
+	mpesaClient = mpesa.NewClient(config)
+	env.sim.SetScenario(darajasim.STKPush, darajasim.Scenario{Outcome: darajasim.Timeout, ResponseDelay: 300 * time.Millisecond})
+
+	resp := env.post(t, "/api/v1/orders/order-1/pay", `{"invoiceId":"inv-1"}`)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Empty(t, env.payments.payments)
+}
+
+func TestE2E_ForgedCallbackRejected(t *testing.T) {
+	env := newE2EEnv(t)
+	mockInvoiceRepo := useE2EOrder(t)
+	auditRepo := useCallbackAudit(t)
+	env.sim.SetScenario(darajasim.STKPush, darajasim.Scenario{Outcome: darajasim.Timeout})
+
+	env.post(t, "/api/v1/orders/order-1/pay", `{"invoiceId":"inv-1"}`)
+	payment := env.payments.only(t)
+
+	// Someone who learns the CheckoutRequestID still cannot settle the payment
+	forged := `{"Body":{"stkCallback":{"CheckoutRequestID":"` + payment.CheckoutRequestID + `","ResultCode":0,"ResultDesc":"ok",` +
+		`"CallbackMetadata":{"Item":[{"Name":"Amount","Value":1500},{"Name":"MpesaReceiptNumber","Value":"FORGED1234"}]}}}}`
+	resp := env.post(t, "/api/v1/mpesa/callback/guessed", forged)
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, models.PaymentStatusInitiated, env.payments.only(t).Status)
+	mockInvoiceRepo.AssertNotCalled(t, "RecordPayment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assertRejected(t, auditRepo, models.CallbackSourceSTKPush, models.CallbackRejectTokenMismatch)
+}
+
+func TestE2E_B2CRefundPaid(t *testing.T) {
+	env := newE2EEnv(t)
+	env.sim.SetScenario(darajasim.B2C, darajasim.Scenario{Outcome: darajasim.Succeed, Delay: 20 * time.Millisecond, Duplicates: 1})
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", Type: models.InvoiceTypeReceivable}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", Phone: "254712345678"}, nil)
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalsByInvoiceID", mock.Anything, "inv-1").Return([]*models.ReversalRecord{{ID: "rev-1", InvoiceID: "inv-1", RefundOwed: 500}}, nil)
+	mockReversalRepo.On("AddRefundedAmount", mock.Anything, "rev-1", 500.0).Return(nil).Once()
+	refunds := &refundStore{MockRefundRepository: new(MockRefundRepository)}
+	useRefundMocks(t, mockInvoiceRepo, mockOrderRepo, mockReversalRepo, refunds.MockRefundRepository)
+	NewRefundRepository = refunds
+
+	resp := env.post(t, "/api/v1/admin/invoices/inv-1/refund", `{"reason":"Order returned"}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	env.sim.Wait()
+
+	refundList, _ := refunds.GetRefundsByInvoiceID(context.Background(), "inv-1")
+	assert.Len(t, refundList, 1)
+	assert.Equal(t, models.RefundStatusPaid, refundList[0].Status)
+	assert.NotEmpty(t, refundList[0].TransactionID)
+	mockReversalRepo.AssertNumberOfCalls(t, "AddRefundedAmount", 1)
+	assert.Contains(t, env.sim.Deliveries()[1].Response, "Callback already processed")
+}
+
+func TestE2E_B2CRefundTimedOut(t *testing.T) {
+	env := newE2EEnv(t)
+	env.sim.SetScenario(darajasim.B2C, darajasim.Scenario{Outcome: darajasim.Timeout, Delay: 20 * time.Millisecond})
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", Type: models.InvoiceTypeReceivable}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", Phone: "254712345678"}, nil)
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalsByInvoiceID", mock.Anything, "inv-1").Return([]*models.ReversalRecord{{ID: "rev-1", InvoiceID: "inv-1", RefundOwed: 500}}, nil)
+	refunds := &refundStore{MockRefundRepository: new(MockRefundRepository)}
+	useRefundMocks(t, mockInvoiceRepo, mockOrderRepo, mockReversalRepo, refunds.MockRefundRepository)
+	NewRefundRepository = refunds
+
+	resp := env.post(t, "/api/v1/admin/invoices/inv-1/refund", `{"reason":"Order returned"}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	env.sim.Wait()
+
+	refundList, _ := refunds.GetRefundsByInvoiceID(context.Background(), "inv-1")
+	assert.Equal(t, models.RefundStatusTimedOut, refundList[0].Status)
+	mockReversalRepo.AssertNotCalled(t, "AddRefundedAmount", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestE2E_C2BPaybillPaymentCreditsInvoice(t *testing.T) {
+	env := newE2EEnv(t)
+	env.sim.SetScenario(darajasim.C2B, darajasim.Scenario{Outcome: darajasim.Succeed, Duplicates: 1})
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", Type: models.InvoiceTypePayable, InvoiceAmount: 250}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, "inv-1", 250.0, mock.Anything).Return(nil).Once()
+	mockC2BRepo := new(MockC2BRepository)
+	mockC2BRepo.On("CreateC2BTransaction", mock.Anything, mock.MatchedBy(func(txn *models.C2BTransaction) bool {
+		return txn.Status == models.C2BStatusCredited && txn.Amount == 250 && txn.Phone == "254712345678"
+	})).Return(nil).Once()
+	mockC2BRepo.On("CreateC2BTransaction", mock.Anything, mock.Anything).Return(errors.New("c2b transaction already recorded"))
+	oldInvoiceRepo, oldC2BRepo := NewInvoiceRepository, NewC2BRepository
+	NewInvoiceRepository, NewC2BRepository = mockInvoiceRepo, mockC2BRepo
+	t.Cleanup(func() { NewInvoiceRepository, NewC2BRepository = oldInvoiceRepo, oldC2BRepo })
+
+	_, err := mpesaClient.RegisterC2BURLs(context.Background())
+	assert.NoError(t, err)
+
+	// The customer pays the Paybill with the invoice ID as account number
+	token, err := mpesaClient.GetAccessToken(context.Background())
+	assert.NoError(t, err)
+	payload, _ := json.Marshal(map[string]interface{}{"ShortCode": "174379", "CommandID": "CustomerPayBillOnline", "Amount": 250, "Msisdn": 254712345678, "BillRefNumber": "inv-1"})
+	req, _ := http.NewRequest("POST", env.simURL+"/mpesa/c2b/v1/simulate", bytes.NewBuffer(payload))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	env.sim.Wait()
+
+	mockInvoiceRepo.AssertNumberOfCalls(t, "RecordPayment", 1)
+	deliveries := env.sim.Deliveries()
+	assert.Len(t, deliveries, 3) // validation, confirmation and its duplicate
+	assert.Contains(t, deliveries[2].Response, "Confirmation already processed")
+}
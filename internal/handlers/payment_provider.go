@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/payment/provider"
+	"github.com/gin-gonic/gin"
+)
+
+// paymentProviders holds the configured providers other than M-Pesa, by name.
+// M-Pesa is built from mpesaClient, which the C2B and reversal endpoints also use.
+var paymentProviders = map[string]provider.PaymentProvider{}
+
+// defaultPaymentProvider is the provider new invoices use when the customer does
+// not choose one at checkout
+var defaultPaymentProvider = provider.Mpesa
+
+// InitPaymentProviders configures cash on delivery and the card gateway from the
+// environment. Call it after InitMpesaClient. It returns the names of the providers
+// that are available.
+func InitPaymentProviders() ([]string, error) {
+	providers := map[string]provider.PaymentProvider{}
+
+	if os.Getenv("PAYMENT_COD_ENABLED") == "true" {
+		providers[provider.CashOnDelivery] = provider.NewCashOnDeliveryProvider()
+	}
+
+	if baseURL := os.Getenv("CARD_GATEWAY_BASE_URL"); baseURL != "" {
+		config := provider.CardConfig{
+			BaseURL:       baseURL,
+			SecretKey:     os.Getenv("CARD_GATEWAY_SECRET_KEY"),
+			WebhookSecret: os.Getenv("CARD_GATEWAY_WEBHOOK_SECRET"),
+			ReturnURL:     os.Getenv("CARD_GATEWAY_RETURN_URL"),
+			Currency:      os.Getenv("CARD_GATEWAY_CURRENCY"),
+		}
+		if config.SecretKey == "" || config.WebhookSecret == "" {
+			return nil, fmt.Errorf("card gateway requires CARD_GATEWAY_SECRET_KEY and CARD_GATEWAY_WEBHOOK_SECRET")
+		}
+		providers[provider.Card] = provider.NewCardProvider(config)
+	}
+
+	paymentProviders = providers
+
+	defaultPaymentProvider = provider.Mpesa
+	if name := os.Getenv("PAYMENT_DEFAULT_PROVIDER"); name != "" {
+		if paymentProviderFor(name) == nil {
+			return nil, fmt.Errorf("default payment provider %q is not configured", name)
+		}
+		defaultPaymentProvider = name
+	}
+
+	var names []string
+	for _, name := range []string{provider.Mpesa, provider.CashOnDelivery, provider.Card} {
+		if paymentProviderFor(name) != nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// paymentProviderFor returns the named provider, or nil if it is not configured.
+// An empty name means M-Pesa, which is how invoices and payments from before other
+// providers existed are stored.
+func paymentProviderFor(name string) provider.PaymentProvider {
+	if name == "" || name == provider.Mpesa {
+		if mpesaClient == nil {
+			return nil
+		}
+		return provider.NewMpesaProvider(mpesaClient)
+	}
+	return paymentProviders[name]
+}
+
+// providerName normalises a stored provider name, mapping empty to M-Pesa
+func providerName(name string) string {
+	if name == "" {
+		return provider.Mpesa
+	}
+	return name
+}
+
+// checkoutPaymentProvider resolves the provider a customer chose for a new order
+func checkoutPaymentProvider(name string) (string, *orderError) {
+	if name == "" {
+		return defaultPaymentProvider, nil
+	}
+	if paymentProviderFor(name) == nil {
+		return "", &orderError{http.StatusBadRequest, gin.H{"error": fmt.Sprintf("payment provider not available: %s", name)}}
+	}
+	return name, nil
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/eddie-wainaina1/maggiesb/internal/payment/provider"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// useProviders replaces the configured non-M-Pesa payment providers for a test
+func useProviders(t *testing.T, providers ...provider.PaymentProvider) {
+	old := paymentProviders
+	paymentProviders = map[string]provider.PaymentProvider{}
+	for _, p := range providers {
+		paymentProviders[p.Name()] = p
+	}
+	t.Cleanup(func() { paymentProviders = old })
+}
+
+// cardGatewayStub stands in for the card gateway, creating pending charges and
+// settling refunds immediately
+func cardGatewayStub(t *testing.T) *provider.CardProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/charges":
+			json.NewEncoder(w).Encode(provider.CardCharge{ID: "ch_1", Status: provider.CardStatusPending, CheckoutURL: "https://pay.example/ch_1"})
+		case "/v1/refunds":
+			var req struct {
+				Charge string `json:"charge"`
+				Amount int64  `json:"amount"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(provider.CardRefund{ID: "re_1", Charge: req.Charge, Amount: req.Amount, Status: provider.CardStatusSucceeded})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return provider.NewCardProvider(provider.CardConfig{BaseURL: server.URL, SecretKey: "sk_test", WebhookSecret: testWebhookSecret})
+}
+
+func usePaymentMocks(t *testing.T, paymentRepo *MockPaymentRepository, invoiceRepo *MockInvoiceRepository, orderRepo *MockOrderRepository) {
+	oldPaymentRepo := NewPaymentRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	oldOrderRepo := NewOrderRepository
+	NewPaymentRepository = PaymentRepository(paymentRepo)
+	NewInvoiceRepository = InvoiceRepository(invoiceRepo)
+	NewOrderRepository = OrderRepository(orderRepo)
+	t.Cleanup(func() {
+		NewPaymentRepository = oldPaymentRepo
+		NewInvoiceRepository = oldInvoiceRepo
+		NewOrderRepository = oldOrderRepo
+	})
+}
+
+func postPayment(body string) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest("POST", "/orders/order-1/pay", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", "user-1")
+
+	InitiatePayment(c)
+	return w
+}
+
+func TestInitiatePayment_CashOnDelivery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useProviders(t, provider.NewCashOnDeliveryProvider())
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", InvoiceAmount: 800, PaymentProvider: provider.CashOnDelivery}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", UserID: "user-1"}, nil)
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentsByInvoiceID", mock.Anything, "inv-1").Return([]*models.PaymentRecord{}, nil)
+	mockPaymentRepo.On("CreatePaymentRecord", mock.Anything, mock.MatchedBy(func(p *models.PaymentRecord) bool {
+		return p.Provider == provider.CashOnDelivery &&
+			p.Method == provider.MethodCash &&
+			p.Status == models.PaymentStatusAwaitingCollection &&
+			p.Amount == 800
+	})).Return(nil)
+	usePaymentMocks(t, mockPaymentRepo, mockInvoiceRepo, mockOrderRepo)
+
+	// No phone is needed to pay cash
+	w := postPayment(`{"invoiceId":"inv-1"}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"awaiting_collection"`)
+	mockPaymentRepo.AssertExpectations(t)
+}
+
+func TestInitiatePayment_CashOnDeliveryReturnsOpenCollection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useProviders(t, provider.NewCashOnDeliveryProvider())
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", InvoiceAmount: 800, PaymentProvider: provider.CashOnDelivery}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", UserID: "user-1"}, nil)
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentsByInvoiceID", mock.Anything, "inv-1").Return([]*models.PaymentRecord{
+		{ID: "pay-1", Provider: provider.CashOnDelivery, CheckoutRequestID: "cod_1", Status: models.PaymentStatusAwaitingCollection},
+	}, nil)
+	usePaymentMocks(t, mockPaymentRepo, mockInvoiceRepo, mockOrderRepo)
+
+	w := postPayment(`{"invoiceId":"inv-1"}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"paymentId":"pay-1"`)
+	mockPaymentRepo.AssertNotCalled(t, "CreatePaymentRecord", mock.Anything, mock.Anything)
+}
+
+func TestInitiatePayment_Card(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useProviders(t, cardGatewayStub(t))
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", InvoiceAmount: 800, PaymentProvider: provider.Card}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", UserID: "user-1"}, nil)
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("CreatePaymentRecord", mock.Anything, mock.MatchedBy(func(p *models.PaymentRecord) bool {
+		return p.Provider == provider.Card && p.CheckoutRequestID == "ch_1" && p.Status == models.PaymentStatusInitiated
+	})).Return(nil)
+	usePaymentMocks(t, mockPaymentRepo, mockInvoiceRepo, mockOrderRepo)
+
+	w := postPayment(`{"invoiceId":"inv-1"}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"redirectUrl":"https://pay.example/ch_1"`)
+	mockPaymentRepo.AssertExpectations(t)
+}
+
+func TestInitiatePayment_ProviderNotConfigured(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useProviders(t, provider.NewCashOnDeliveryProvider())
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", InvoiceAmount: 800, PaymentProvider: provider.Card}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", UserID: "user-1"}, nil)
+	usePaymentMocks(t, new(MockPaymentRepository), mockInvoiceRepo, mockOrderRepo)
+
+	w := postPayment(`{"invoiceId":"inv-1"}`)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "payment provider not configured: card")
+}
+
+func postCardWebhook(body []byte, signature string) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest("POST", "/payments/card/webhook", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(provider.CardSignatureHeader, signature)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	HandleCardWebhook(c)
+	return w
+}
+
+func TestHandleCardWebhook_SettlesPayment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useProviders(t, cardGatewayStub(t))
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "ch_1").Return(&models.PaymentRecord{
+		ID: "pay-1", InvoiceID: "inv-1", Provider: provider.Card, Amount: 800, Status: models.PaymentStatusInitiated,
+	}, nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "ch_1", models.PaymentStatusInitiated, models.PaymentStatusCompleted, mock.MatchedBy(func(s *models.PaymentSettlement) bool {
+		return s.Receipt == "RCPT-1" && s.MpesaReceiptNumber == "" && s.PaidAmount == 800 && s.Method == provider.MethodCard
+	})).Return(true, nil)
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", InvoiceAmount: 800}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, "inv-1", 800.0, "2026-03-01").Return(nil)
+	usePaymentMocks(t, mockPaymentRepo, mockInvoiceRepo, new(MockOrderRepository))
+
+	body, _ := json.Marshal(provider.CardWebhook{Type: "charge.succeeded", Data: provider.CardCharge{
+		ID: "ch_1", Status: provider.CardStatusSucceeded, Amount: 80000, ReceiptNumber: "RCPT-1", PaidAt: "2026-03-01T10:15:00Z",
+	}})
+	w := postCardWebhook(body, hex.EncodeToString(provider.SignCardWebhook(testWebhookSecret, body)))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"settled":true`)
+	mockPaymentRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertExpectations(t)
+}
+
+func TestHandleCardWebhook_RejectsBadSignature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useProviders(t, cardGatewayStub(t))
+	mockPaymentRepo := new(MockPaymentRepository)
+	usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+	body, _ := json.Marshal(provider.CardWebhook{Type: "charge.succeeded", Data: provider.CardCharge{ID: "ch_1", Status: provider.CardStatusSucceeded, Amount: 80000}})
+	w := postCardWebhook(body, hex.EncodeToString(provider.SignCardWebhook("wrong-secret", body)))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockPaymentRepo.AssertNotCalled(t, "GetPaymentByCheckoutRequestID", mock.Anything, mock.Anything)
+}
+
+func TestHandleCardWebhook_NotEnabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useProviders(t)
+
+	w := postCardWebhook([]byte(`{}`), "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func postCollect(paymentID, body string) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest("POST", "/admin/payments/"+paymentID+"/collect", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: paymentID}}
+	c.Set("userID", "admin-1")
+
+	AdminCollectCashPayment(c)
+	return w
+}
+
+func TestAdminCollectCashPayment_CreditsInvoice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByID", mock.Anything, "pay-1").Return(&models.PaymentRecord{
+		ID: "pay-1", InvoiceID: "inv-1", Provider: provider.CashOnDelivery, CheckoutRequestID: "cod_1", Amount: 800, Status: models.PaymentStatusAwaitingCollection,
+	}, nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "cod_1", models.PaymentStatusAwaitingCollection, models.PaymentStatusCompleted, mock.MatchedBy(func(s *models.PaymentSettlement) bool {
+		return s.PaidAmount == 750 && s.CollectedBy == "admin-1" && s.Method == provider.MethodCash && s.AmountMismatch == models.AmountMismatchUnderpaid
+	})).Return(true, nil)
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", InvoiceAmount: 800}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, "inv-1", 750.0, time.Now().In(mpesaTimeZone).Format("2006-01-02")).Return(nil)
+	usePaymentMocks(t, mockPaymentRepo, mockInvoiceRepo, new(MockOrderRepository))
+
+	// The rider came back short, so the payment is flagged for review
+	w := postCollect("pay-1", `{"amount":750}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockPaymentRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertExpectations(t)
+}
+
+func TestAdminCollectCashPayment_RejectsOtherPayments(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		payment  *models.PaymentRecord
+		wantCode int
+	}{
+		{"mpesa payment", &models.PaymentRecord{ID: "pay-1", Status: models.PaymentStatusInitiated}, http.StatusBadRequest},
+		{"already collected", &models.PaymentRecord{ID: "pay-1", Provider: provider.CashOnDelivery, Status: models.PaymentStatusCompleted}, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPaymentRepo := new(MockPaymentRepository)
+			mockPaymentRepo.On("GetPaymentByID", mock.Anything, "pay-1").Return(tt.payment, nil)
+			usePaymentMocks(t, mockPaymentRepo, new(MockInvoiceRepository), new(MockOrderRepository))
+
+			w := postCollect("pay-1", `{}`)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			mockPaymentRepo.AssertNotCalled(t, "TransitionPaymentStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestAdminRefundInvoice_CardRefundedImmediately(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useProviders(t, cardGatewayStub(t))
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", Type: models.InvoiceTypeReceivable, PaymentProvider: provider.Card}, nil)
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalsByInvoiceID", mock.Anything, "inv-1").Return([]*models.ReversalRecord{{ID: "rev-1", RefundOwed: 750.5}}, nil)
+	mockReversalRepo.On("AddRefundedAmount", mock.Anything, "rev-1", 750.5).Return(nil)
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundsByInvoiceID", mock.Anything, "inv-1").Return([]*models.Refund{}, nil)
+	mockRefundRepo.On("CreateRefund", mock.Anything, mock.MatchedBy(func(r *models.Refund) bool {
+		return r.Provider == provider.Card && r.Amount == 750.5 && r.Status == models.RefundStatusPending
+	})).Return(nil)
+	mockRefundRepo.On("SetRefundReferences", mock.Anything, mock.Anything, "re_1", mock.Anything).Return(nil)
+	mockRefundRepo.On("TransitionRefundStatus", mock.Anything, mock.Anything, models.RefundStatusPending, models.RefundStatusPaid, mock.Anything).Return(true, nil)
+	useRefundMocks(t, mockInvoiceRepo, new(MockOrderRepository), mockReversalRepo, mockRefundRepo)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentsByInvoiceID", mock.Anything, "inv-1").Return([]*models.PaymentRecord{
+		{ID: "pay-1", Provider: provider.Card, CheckoutRequestID: "ch_1", Status: models.PaymentStatusCompleted},
+	}, nil)
+	oldPaymentRepo := NewPaymentRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	t.Cleanup(func() { NewPaymentRepository = oldPaymentRepo })
+
+	// Cards are refunded to the cent
+	w := postRefund("inv-1", `{"reason":"Order returned"}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"paid"`)
+	mockRefundRepo.AssertExpectations(t)
+	mockReversalRepo.AssertExpectations(t)
+}
+
+func TestAdminRefundInvoice_CashNeedsPayoutProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", Type: models.InvoiceTypeReceivable, PaymentProvider: provider.CashOnDelivery}, nil)
+	useRefundMocks(t, mockInvoiceRepo, new(MockOrderRepository), new(MockReversalRepository), new(MockRefundRepository))
+
+	w := postRefund("inv-1", `{"reason":"Order returned"}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "choose provider mpesa or card")
+}
+
+func TestCheckoutPaymentProvider(t *testing.T) {
+	useProviders(t, provider.NewCashOnDeliveryProvider())
+
+	name, oerr := checkoutPaymentProvider("")
+	assert.Nil(t, oerr)
+	assert.Equal(t, provider.Mpesa, name)
+
+	name, oerr = checkoutPaymentProvider(provider.CashOnDelivery)
+	assert.Nil(t, oerr)
+	assert.Equal(t, provider.CashOnDelivery, name)
+
+	_, oerr = checkoutPaymentProvider(provider.Card)
+	assert.NotNil(t, oerr)
+	assert.Equal(t, http.StatusBadRequest, oerr.Status)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/eddie-wainaina1/maggiesb/internal/payment/provider"
+)
+
+// reconcileBatchSize caps how many stale payments are queried per run
+const reconcileBatchSize = 50
+
+// StartPaymentReconciler starts a background goroutine that periodically settles
+// payments whose provider callback never arrived.
+// interval: how often to run (e.g., 5 * time.Minute)
+// staleAfter: how long to wait for the callback before querying the provider
+// expireAfter: how long the provider may stay undecided before the payment is marked expired
+func StartPaymentReconciler(interval, staleAfter, expireAfter time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ReconcilePayments(staleAfter, expireAfter)
+		}
+	}()
+}
+
+// ReconcilePayments queries each payment's provider for every payment still
+// "initiated" after staleAfter. Payments with a final result are settled exactly
+// as if their callback had arrived; those still undecided after expireAfter are
+// expired. It returns how many payments were settled and expired.
+func ReconcilePayments(staleAfter, expireAfter time.Duration) (int, int) {
+	if mpesaClient == nil && len(paymentProviders) == 0 {
+		return 0, 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	paymentRepo := NewPaymentRepository
+	payments, err := paymentRepo.GetStaleInitiatedPayments(ctx, now.Add(-staleAfter), reconcileBatchSize)
+	if err != nil {
+		fmt.Printf("Error fetching stale payments: %v\n", err)
+		return 0, 0
+	}
+
+	settled, expired := 0, 0
+	for _, payment := range payments {
+		p := paymentProviderFor(payment.Provider)
+		if p == nil {
+			// Provider no longer configured; leave the payment for when it is
+			continue
+		}
+
+		result, err := p.Query(ctx, payment.CheckoutRequestID)
+		if err == nil {
+			ok, err := settlePayment(ctx, payment, result)
+			if err != nil {
+				fmt.Printf("Error settling payment %s: %v\n", payment.CheckoutRequestID, err)
+				continue
+			}
+			if ok {
+				settled++
+			}
+			continue
+		}
+
+		if errors.Is(err, provider.ErrNotSupported) {
+			continue
+		}
+		if !errors.Is(err, provider.ErrPending) {
+			fmt.Printf("Error querying payment %s: %v\n", payment.CheckoutRequestID, err)
+		}
+
+		// No final answer yet; give up once the payment is old enough
+		createdAt, perr := time.ParseInLocation("2006-01-02 15:04:05", payment.CreatedAt, time.Local)
+		if perr != nil || now.Sub(createdAt) < expireAfter {
+			continue
+		}
+		ok, err := paymentRepo.TransitionPaymentStatus(ctx, payment.CheckoutRequestID, models.PaymentStatusInitiated, models.PaymentStatusExpired, nil)
+		if err != nil {
+			fmt.Printf("Error expiring payment %s: %v\n", payment.CheckoutRequestID, err)
+			continue
+		}
+		if ok {
+			expired++
+		}
+	}
+
+	return settled, expired
+}
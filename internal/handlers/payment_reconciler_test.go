@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// darajaQueryStub stands in for the Daraja API, answering STK queries per checkout ID
+func darajaQueryStub(results map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+
+		var req mpesa.STKPushQueryRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(results[req.CheckoutRequestID])
+	}))
+}
+
+func useStubMpesaClient(t *testing.T, server *httptest.Server) {
+	old := mpesaClient
+	mpesaClient = mpesa.NewClient(mpesa.Config{
+		ConsumerKey:       "key",
+		ConsumerSecret:    "secret",
+		BusinessShortCode: "174379",
+		PassKey:           "passkey",
+		BaseURL:           server.URL,
+	})
+	t.Cleanup(func() { mpesaClient = old })
+}
+
+func TestReconcilePayments_SettlesAndExpires(t *testing.T) {
+	server := darajaQueryStub(map[string]interface{}{
+		"ws_paid":      mpesa.STKPushQueryResponse{ResponseCode: "0", ResultCode: "0", ResultDesc: "The service request is processed successfully."},
+		"ws_cancelled": mpesa.STKPushQueryResponse{ResponseCode: "0", ResultCode: "1032", ResultDesc: "Request cancelled by user"},
+		"ws_pending":   map[string]string{"errorCode": "500.001.1001", "errorMessage": "The transaction is being processed"},
+		"ws_abandoned": map[string]string{"errorCode": "500.001.1001", "errorMessage": "The transaction is being processed"},
+	})
+	defer server.Close()
+	useStubMpesaClient(t, server)
+
+	recent := time.Now().Add(-5 * time.Minute).Format("2006-01-02 15:04:05")
+	old := time.Now().Add(-2 * time.Hour).Format("2006-01-02 15:04:05")
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetStaleInitiatedPayments", mock.Anything, mock.Anything, reconcileBatchSize).Return([]*models.PaymentRecord{
+		{CheckoutRequestID: "ws_paid", InvoiceID: "inv-1", Amount: 250, CreatedAt: recent},
+		{CheckoutRequestID: "ws_cancelled", InvoiceID: "inv-2", CreatedAt: recent},
+		{CheckoutRequestID: "ws_pending", InvoiceID: "inv-3", CreatedAt: recent},
+		{CheckoutRequestID: "ws_abandoned", InvoiceID: "inv-4", CreatedAt: old},
+	}, nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "ws_paid", models.PaymentStatusInitiated, models.PaymentStatusCompleted, mock.MatchedBy(func(s *models.PaymentSettlement) bool {
+		return s.PaidAmount == 250 && s.AmountMismatch == ""
+	})).Return(true, nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "ws_cancelled", models.PaymentStatusInitiated, models.PaymentStatusFailed, (*models.PaymentSettlement)(nil)).Return(true, nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "ws_abandoned", models.PaymentStatusInitiated, models.PaymentStatusExpired, (*models.PaymentSettlement)(nil)).Return(true, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", InvoiceAmount: 250}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, "inv-1", 250.0, time.Now().Format("2006-01-02")).Return(nil)
+
+	oldPaymentRepo := NewPaymentRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	defer func() {
+		NewPaymentRepository = oldPaymentRepo
+		NewInvoiceRepository = oldInvoiceRepo
+	}()
+
+	settled, expired := ReconcilePayments(2*time.Minute, 30*time.Minute)
+
+	assert.Equal(t, 2, settled)
+	assert.Equal(t, 1, expired)
+	mockPaymentRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertExpectations(t)
+	mockPaymentRepo.AssertNotCalled(t, "TransitionPaymentStatus", mock.Anything, "ws_pending", mock.Anything, mock.Anything, mock.Anything)
+	mockInvoiceRepo.AssertNotCalled(t, "GetInvoiceByID", mock.Anything, "inv-2")
+}
+
+func TestReconcilePayments_NoClient(t *testing.T) {
+	old := mpesaClient
+	mpesaClient = nil
+	defer func() { mpesaClient = old }()
+
+	settled, expired := ReconcilePayments(time.Minute, time.Hour)
+	assert.Equal(t, 0, settled)
+	assert.Equal(t, 0, expired)
+}
@@ -3,21 +3,24 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func TestInitiateMpesaPayment_NotAuthenticated(t *testing.T) {
+func TestInitiatePayment_NotAuthenticated(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	
-	req := models.MpesaPaymentRequest{
+	req := models.PaymentRequest{
 		InvoiceID: "invoice-123",
 		Phone:     "254712345678",
 	}
@@ -30,12 +33,12 @@ func TestInitiateMpesaPayment_NotAuthenticated(t *testing.T) {
 	c, _ := gin.CreateTestContext(w)
 	c.Request = httpReq
 	
-	InitiateMpesaPayment(c)
+	InitiatePayment(c)
 	
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
 
-func TestInitiateMpesaPayment_InvalidRequest(t *testing.T) {
+func TestInitiatePayment_InvalidRequest(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	
 	body := []byte(`{"invalid":"data"}`)
@@ -47,15 +50,15 @@ func TestInitiateMpesaPayment_InvalidRequest(t *testing.T) {
 	c.Request = httpReq
 	c.Set("userID", uuid.New().String())
 	
-	InitiateMpesaPayment(c)
+	InitiatePayment(c)
 	
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
-func TestInitiateMpesaPayment_NoMpesaClient(t *testing.T) {
+func TestInitiatePayment_NoMpesaClient(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	
-	req := models.MpesaPaymentRequest{
+	req := models.PaymentRequest{
 		InvoiceID: "invoice-123",
 		Phone:     "254712345678",
 	}
@@ -69,7 +72,7 @@ func TestInitiateMpesaPayment_NoMpesaClient(t *testing.T) {
 	c.Request = httpReq
 	c.Set("userID", uuid.New().String())
 	
-	InitiateMpesaPayment(c)
+	InitiatePayment(c)
 	
 	// Should return error if M-Pesa client not initialized
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
@@ -109,13 +112,21 @@ func TestHandleMpesaCallback_Success(t *testing.T) {
 	mockPaymentRepo := new(MockPaymentRepository)
 	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "checkout-123").Return(&models.PaymentRecord{
 		InvoiceID: invoiceID,
+		Amount:    100,
 	}, nil)
-	// Handler passes: status="completed", receipt number extracted from metadata, transaction date extracted from metadata
-	mockPaymentRepo.On("UpdatePaymentStatus", mock.Anything, "checkout-123", "completed", "receipt-123", "20231201120000").Return(nil)
+	// Handler passes: status="completed" and the receipt, amount, phone and transaction time parsed from metadata
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "checkout-123", "initiated", "completed", mock.MatchedBy(func(s *models.PaymentSettlement) bool {
+		return s.MpesaReceiptNumber == "receipt-123" &&
+			s.TransactionDate == "20231201120000" &&
+			s.TransactedAt != nil && s.TransactedAt.Equal(time.Date(2023, 12, 1, 9, 0, 0, 0, time.UTC)) &&
+			s.PaidAmount == 100 &&
+			s.PayerPhone == "254708374149" &&
+			s.AmountMismatch == ""
+	})).Return(true, nil)
 	
 	mockInvoiceRepo := new(MockInvoiceRepository)
 	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, invoiceID).Return(&models.Invoice{InvoiceAmount: 100}, nil)
-	mockInvoiceRepo.On("RecordPayment", mock.Anything, invoiceID, 100.0, "20231201120000").Return(nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, invoiceID, 100.0, "2023-12-01").Return(nil)
 	
 	oldPaymentRepo := NewPaymentRepository
 	oldInvoiceRepo := NewInvoiceRepository
@@ -151,7 +162,8 @@ func TestHandleMpesaCallback_Success(t *testing.T) {
 	}{
 		{Name: "Amount", Value: 100},
 		{Name: "MpesaReceiptNumber", Value: "receipt-123"},
-		{Name: "TransactionDate", Value: "20231201120000"},
+		{Name: "TransactionDate", Value: 20231201120000},
+		{Name: "PhoneNumber", Value: 254708374149},
 	}
 	
 	body, _ := json.Marshal(callback)
@@ -168,3 +180,387 @@ func TestHandleMpesaCallback_Success(t *testing.T) {
 	mockPaymentRepo.AssertExpectations(t)
 	mockInvoiceRepo.AssertExpectations(t)
 }
+
+func TestHandleMpesaCallback_DuplicateIsNoop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	invoiceID := uuid.New().String()
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "checkout-dup").Return(&models.PaymentRecord{
+		InvoiceID: invoiceID,
+		Amount:    100,
+		Status:    "initiated",
+	}, nil)
+	// The first callback moves the payment out of "initiated"; Safaricom's retry finds nothing to transition
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "checkout-dup", "initiated", "completed", mock.Anything).Return(true, nil).Once()
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "checkout-dup", "initiated", "completed", mock.Anything).Return(false, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, invoiceID).Return(&models.Invoice{InvoiceAmount: 100}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, invoiceID, 100.0, "2023-12-01").Return(nil)
+
+	oldPaymentRepo := NewPaymentRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	defer func() {
+		NewPaymentRepository = oldPaymentRepo
+		NewInvoiceRepository = oldInvoiceRepo
+	}()
+
+	body := `{"Body":{"stkCallback":{"MerchantRequestID":"m-1","CheckoutRequestID":"checkout-dup","ResultCode":0,"ResultDesc":"ok",` +
+		`"CallbackMetadata":{"Item":[{"Name":"Amount","Value":100},{"Name":"MpesaReceiptNumber","Value":"receipt-dup"},{"Name":"TransactionDate","Value":"20231201120000"}]}}}}`
+
+	for i := 0; i < 3; i++ {
+		httpReq := httptest.NewRequest("POST", "/mpesa/callback", bytes.NewBufferString(body))
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httpReq
+
+		HandleMpesaCallback(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"ResultCode":"0"`)
+	}
+
+	// The invoice is credited exactly once
+	mockInvoiceRepo.AssertNumberOfCalls(t, "RecordPayment", 1)
+	mockPaymentRepo.AssertNumberOfCalls(t, "TransitionPaymentStatus", 3)
+}
+
+func TestHandleMpesaCallback_FailedThenSuccessRetryIsNoop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	invoiceID := uuid.New().String()
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "checkout-late").Return(&models.PaymentRecord{
+		InvoiceID: invoiceID,
+		Status:    "failed",
+	}, nil)
+	// Already failed, so a late success callback must not flip it to completed
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "checkout-late", "initiated", "completed", mock.Anything).Return(false, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, invoiceID).Return(&models.Invoice{InvoiceAmount: 100}, nil)
+
+	oldPaymentRepo := NewPaymentRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	defer func() {
+		NewPaymentRepository = oldPaymentRepo
+		NewInvoiceRepository = oldInvoiceRepo
+	}()
+
+	body := `{"Body":{"stkCallback":{"CheckoutRequestID":"checkout-late","ResultCode":0,"ResultDesc":"ok",` +
+		`"CallbackMetadata":{"Item":[{"Name":"MpesaReceiptNumber","Value":"receipt-late"}]}}}}`
+	httpReq := httptest.NewRequest("POST", "/mpesa/callback", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	HandleMpesaCallback(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "already processed")
+	mockInvoiceRepo.AssertNotCalled(t, "RecordPayment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// postMpesaCallback sends a successful STK callback for the given checkout ID and metadata items
+func postMpesaCallback(checkoutID, items string) *httptest.ResponseRecorder {
+	body := `{"Body":{"stkCallback":{"MerchantRequestID":"m-1","CheckoutRequestID":"` + checkoutID + `","ResultCode":0,"ResultDesc":"ok",` +
+		`"CallbackMetadata":{"Item":[` + items + `]}}}}`
+	httpReq := httptest.NewRequest("POST", "/mpesa/callback", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	HandleMpesaCallback(c)
+	return w
+}
+
+func TestHandleMpesaCallback_PartialPaymentCreditsPaidAmount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	invoiceID := uuid.New().String()
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "checkout-part").Return(&models.PaymentRecord{
+		InvoiceID: invoiceID,
+		Amount:    1000,
+	}, nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "checkout-part", "initiated", "completed", mock.MatchedBy(func(s *models.PaymentSettlement) bool {
+		return s.PaidAmount == 400 && s.AmountMismatch == models.AmountMismatchUnderpaid
+	})).Return(true, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, invoiceID).Return(&models.Invoice{InvoiceAmount: 1000}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, invoiceID, 400.0, "2023-12-01").Return(nil)
+
+	oldPaymentRepo := NewPaymentRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	defer func() {
+		NewPaymentRepository = oldPaymentRepo
+		NewInvoiceRepository = oldInvoiceRepo
+	}()
+
+	w := postMpesaCallback("checkout-part", `{"Name":"Amount","Value":400.00},{"Name":"MpesaReceiptNumber","Value":"receipt-part"},{"Name":"TransactionDate","Value":20231201235959}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockPaymentRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertExpectations(t)
+}
+
+func TestHandleMpesaCallback_OverpaymentFlagged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	invoiceID := uuid.New().String()
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "checkout-over").Return(&models.PaymentRecord{
+		InvoiceID: invoiceID,
+		Amount:    300,
+	}, nil)
+	// The amount matches the request, but an earlier payment already covered most of the invoice
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "checkout-over", "initiated", "completed", mock.MatchedBy(func(s *models.PaymentSettlement) bool {
+		return s.PaidAmount == 300 && s.AmountMismatch == models.AmountMismatchOverpaid
+	})).Return(true, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, invoiceID).Return(&models.Invoice{InvoiceAmount: 500, PaidAmount: 400}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, invoiceID, 300.0, "2023-12-01").Return(nil)
+
+	oldPaymentRepo := NewPaymentRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	defer func() {
+		NewPaymentRepository = oldPaymentRepo
+		NewInvoiceRepository = oldInvoiceRepo
+	}()
+
+	w := postMpesaCallback("checkout-over", `{"Name":"Amount","Value":300},{"Name":"MpesaReceiptNumber","Value":"receipt-over"},{"Name":"TransactionDate","Value":20231201080000}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockPaymentRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertExpectations(t)
+}
+
+func TestHandleMpesaCallback_RecordPaymentFailureRollsBack(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	invoiceID := uuid.New().String()
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByCheckoutRequestID", mock.Anything, "checkout-fail").Return(&models.PaymentRecord{
+		InvoiceID: invoiceID,
+		Amount:    100,
+	}, nil)
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "checkout-fail", "initiated", "completed", mock.Anything).Return(true, nil)
+	// Undo puts the payment back to "initiated" so Safaricom's retry can settle it
+	mockPaymentRepo.On("TransitionPaymentStatus", mock.Anything, "checkout-fail", "completed", "initiated", (*models.PaymentSettlement)(nil)).Return(true, nil)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, invoiceID).Return(&models.Invoice{InvoiceAmount: 100}, nil)
+	mockInvoiceRepo.On("RecordPayment", mock.Anything, invoiceID, 100.0, "2023-12-01").Return(assert.AnError)
+
+	oldPaymentRepo := NewPaymentRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	defer func() {
+		NewPaymentRepository = oldPaymentRepo
+		NewInvoiceRepository = oldInvoiceRepo
+	}()
+
+	w := postMpesaCallback("checkout-fail", `{"Name":"Amount","Value":100},{"Name":"MpesaReceiptNumber","Value":"receipt-fail"},{"Name":"TransactionDate","Value":20231201080000}`)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockPaymentRepo.AssertExpectations(t)
+}
+
+func TestAmountMismatch(t *testing.T) {
+	assert.Equal(t, "", amountMismatch(100, 100, 100))
+	assert.Equal(t, "", amountMismatch(100, 100, 250))
+	assert.Equal(t, models.AmountMismatchUnderpaid, amountMismatch(100, 60, 100))
+	assert.Equal(t, models.AmountMismatchOverpaid, amountMismatch(100, 120, 200))
+	assert.Equal(t, models.AmountMismatchOverpaid, amountMismatch(100, 100, 50))
+}
+
+func TestAdminReviewPayment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("MarkPaymentReviewed", mock.Anything, "pay-1", "admin-1", "refunded the excess").Return(nil)
+	mockPaymentRepo.On("MarkPaymentReviewed", mock.Anything, "pay-2", "admin-1", "refunded the excess").Return(errors.New("payment not found or not awaiting review"))
+
+	oldPaymentRepo := NewPaymentRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	defer func() { NewPaymentRepository = oldPaymentRepo }()
+
+	for id, want := range map[string]int{"pay-1": http.StatusOK, "pay-2": http.StatusNotFound} {
+		httpReq := httptest.NewRequest("PUT", "/admin/payments/"+id+"/review", bytes.NewBufferString(`{"note":"refunded the excess"}`))
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httpReq
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		c.Set("userID", "admin-1")
+
+		AdminReviewPayment(c)
+
+		assert.Equal(t, want, w.Code)
+	}
+	mockPaymentRepo.AssertExpectations(t)
+}
+
+func TestGetPaymentStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentByID", mock.Anything, "pay-1").Return(&models.PaymentRecord{ID: "pay-1", OrderID: "order-1", Status: "completed"}, nil)
+	mockPaymentRepo.On("GetPaymentByID", mock.Anything, "pay-missing").Return(nil, mongo.ErrNoDocuments)
+
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", UserID: "user-1"}, nil)
+
+	oldPaymentRepo := NewPaymentRepository
+	oldOrderRepo := NewOrderRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	defer func() {
+		NewPaymentRepository = oldPaymentRepo
+		NewOrderRepository = oldOrderRepo
+	}()
+
+	tests := []struct {
+		name      string
+		paymentID string
+		userID    string
+		want      int
+	}{
+		{"owner", "pay-1", "user-1", http.StatusOK},
+		{"other user", "pay-1", "user-2", http.StatusForbidden},
+		{"missing", "pay-missing", "user-1", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/payments/"+tt.paymentID+"/status", nil)
+			c.Params = gin.Params{{Key: "id", Value: tt.paymentID}}
+			c.Set("userID", tt.userID)
+
+			GetPaymentStatus(c)
+
+			assert.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusOK {
+				assert.Contains(t, w.Body.String(), `"status":"completed"`)
+			}
+		})
+	}
+}
+
+func TestListInvoicePayments(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1"}, nil)
+
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", UserID: "user-1"}, nil)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentsByInvoiceID", mock.Anything, "inv-1").Return([]*models.PaymentRecord{
+		{ID: "pay-2", InvoiceID: "inv-1", Status: "completed"},
+		{ID: "pay-1", InvoiceID: "inv-1", Status: "failed"},
+	}, nil)
+
+	oldInvoiceRepo := NewInvoiceRepository
+	oldOrderRepo := NewOrderRepository
+	oldPaymentRepo := NewPaymentRepository
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	defer func() {
+		NewInvoiceRepository = oldInvoiceRepo
+		NewOrderRepository = oldOrderRepo
+		NewPaymentRepository = oldPaymentRepo
+	}()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/invoices/inv-1/payments", nil)
+	c.Params = gin.Params{{Key: "id", Value: "inv-1"}}
+	c.Set("userID", "user-1")
+
+	ListInvoicePayments(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data []models.PaymentRecord `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Len(t, resp.Data, 2)
+
+	// Another user cannot see the attempts
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/invoices/inv-1/payments", nil)
+	c.Params = gin.Params{{Key: "id", Value: "inv-1"}}
+	c.Set("userID", "user-2")
+
+	ListInvoicePayments(c)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockPaymentRepo.AssertNumberOfCalls(t, "GetPaymentsByInvoiceID", 1)
+}
+
+func TestAdminSearchPayments(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	query := models.PaymentSearchQuery{Phone: "254712345678", Status: "completed", StartDate: "2024-01-01", EndDate: "2024-01-31"}
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("SearchPayments", mock.Anything, query, 2, 5).Return([]*models.PaymentRecord{{ID: "pay-1"}}, nil)
+	mockPaymentRepo.On("CountPayments", mock.Anything, query).Return(int64(6), nil)
+
+	oldPaymentRepo := NewPaymentRepository
+	NewPaymentRepository = PaymentRepository(mockPaymentRepo)
+	defer func() { NewPaymentRepository = oldPaymentRepo }()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/admin/payments?phone=254712345678&status=completed&startDate=2024-01-01&endDate=2024-01-31&page=2&limit=5", nil)
+
+	AdminSearchPayments(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":6`)
+	assert.Contains(t, w.Body.String(), `"totalPages":2`)
+	mockPaymentRepo.AssertExpectations(t)
+}
+
+func TestAdminSearchPayments_InvalidDate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/admin/payments?startDate=01-01-2024", nil)
+
+	AdminSearchPayments(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
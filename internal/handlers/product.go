@@ -30,6 +30,7 @@ func CreateProduct(c *gin.Context) {
 		Description: req.Description,
 		Price:       req.Price,
 		Discount:    req.Discount,
+		Stock:       req.Stock,
 	}
 
 	productRepo := NewProductRepository
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// UpdateProfile changes the signed-in user's name, phone and default delivery
+// details. Fields left out of the request are kept.
+func UpdateProfile(c *gin.Context) {
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	names := []struct {
+		field string
+		value *string
+	}{{"firstName", req.FirstName}, {"lastName", req.LastName}}
+	for _, name := range names {
+		if name.value == nil {
+			continue
+		}
+		if strings.TrimSpace(*name.value) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": name.field + " cannot be empty"})
+			return
+		}
+		updates[name.field] = strings.TrimSpace(*name.value)
+	}
+	if req.Phone != nil {
+		if phone := strings.TrimSpace(*req.Phone); phone != "" {
+			updates["phone"] = phone
+		} else {
+			updates["phone"] = nil
+		}
+	}
+	if req.DeliveryDefaults != nil {
+		if *req.DeliveryDefaults != (models.DeliveryDefaults{}) {
+			updates["deliveryDefaults"] = req.DeliveryDefaults
+		} else {
+			updates["deliveryDefaults"] = nil
+		}
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+		return
+	}
+
+	if err := NewUserRepository.UpdateProfile(c.Request.Context(), c.GetString("userID"), updates); err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword replaces the signed-in user's password after checking the current
+// one, and signs out every other session
+func ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the new password must be different"})
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	// Guesses here count like failed logins
+	ctx := c.Request.Context()
+	email := normalizeLoginEmail(user.Email)
+	ip := c.ClientIP()
+	retryAt, err := loginRetryAt(ctx, email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login attempts"})
+		return
+	}
+	if time.Now().Before(retryAt) {
+		tooManyLoginAttempts(c, retryAt)
+		return
+	}
+	if err := auth.VerifyPassword(user.Password, req.CurrentPassword); err != nil {
+		recordLoginFailure(ctx, email, ip, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process password"})
+		return
+	}
+	if err := NewUserRepository.UpdatePassword(ctx, user.ID, hashedPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+		return
+	}
+	recordAuthEvent(ctx, &models.AuthEvent{ID: uuid.New().String(), Type: models.AuthEventPasswordChanged, UserID: user.ID, Email: user.Email, IP: ip})
+
+	// The session making the request stays signed in
+	revoked, err := revokeUserSessions(ctx, user.ID, c.GetString("sessionID"), models.SessionRevokedPasswordChange)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "password changed, but failed to sign out other sessions"})
+		return
+	}
+	if err := NewAccountTokenRepository.InvalidateAccountTokens(ctx, user.ID, models.AccountTokenPasswordReset); err != nil {
+		log.Printf("Failed to invalidate password reset tokens of user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password changed", "revokedSessions": revoked})
+}
+
+// DeleteAccount deletes the signed-in user's account. Personal data is removed
+// from the user and their orders; invoices are kept intact for accounting. It
+// needs the password, and a code or recovery code from users with two-factor
+// authentication. Staff accounts and users with orders still being fulfilled
+// cannot be deleted.
+func DeleteAccount(c *gin.Context) {
+	var req models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled && (req.Code == "") == (req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide either code or recoveryCode"})
+		return
+	}
+
+	// Guesses here count like failed logins
+	ctx := c.Request.Context()
+	email := normalizeLoginEmail(user.Email)
+	ip := c.ClientIP()
+	retryAt, err := loginRetryAt(ctx, email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login attempts"})
+		return
+	}
+	if time.Now().Before(retryAt) {
+		tooManyLoginAttempts(c, retryAt)
+		return
+	}
+	if err := auth.VerifyPassword(user.Password, req.Password); err != nil {
+		recordLoginFailure(ctx, email, ip, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password or two-factor code"})
+		return
+	}
+	if user.TwoFactorEnabled {
+		valid, err := verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check two-factor code"})
+			return
+		}
+		if !valid {
+			recordLoginFailure(ctx, email, ip, user)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password or two-factor code"})
+			return
+		}
+	}
+
+	if user.IsStaff() {
+		c.JSON(http.StatusConflict, gin.H{"error": "staff accounts need their roles removed by an admin before they can be deleted"})
+		return
+	}
+	openOrders, err := NewOrderRepository.CountOpenOrdersByUser(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check orders"})
+		return
+	}
+	if openOrders > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "wait for your open orders to be delivered or cancelled", "openOrders": openOrders})
+		return
+	}
+
+	// Orders first: if anonymising the user fails, the request can be repeated
+	if _, err := NewOrderRepository.AnonymiseOrdersByUser(ctx, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+	deleted, err := NewUserRepository.AnonymiseUser(ctx, user.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	// The event keeps the user ID only, not the email that was removed
+	recordAuthEvent(ctx, &models.AuthEvent{ID: uuid.New().String(), Type: models.AuthEventAccountDeleted, UserID: user.ID, IP: ip})
+
+	// The account can no longer sign in, so these only tidy up
+	if _, err := revokeUserSessions(ctx, user.ID, "", models.SessionRevokedAccountDeleted); err != nil {
+		log.Printf("Failed to revoke sessions of deleted user %s: %v", user.ID, err)
+	}
+	if err := NewCartRepository.ClearCart(ctx, user.ID); err != nil {
+		log.Printf("Failed to clear cart of deleted user %s: %v", user.ID, err)
+	}
+	if _, err := NewAddressRepository.DeleteAddressesByUser(ctx, user.ID); err != nil {
+		log.Printf("Failed to delete addresses of deleted user %s: %v", user.ID, err)
+	}
+	if _, err := NewShipmentRepository.AnonymiseShipmentsByUser(ctx, user.ID); err != nil {
+		log.Printf("Failed to anonymise shipments of deleted user %s: %v", user.ID, err)
+	}
+	for _, purpose := range []string{models.AccountTokenPasswordReset, models.AccountTokenEmailVerification} {
+		if err := NewAccountTokenRepository.InvalidateAccountTokens(ctx, user.ID, purpose); err != nil {
+			log.Printf("Failed to invalidate %s tokens of deleted user %s: %v", purpose, user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account deleted"})
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// profileUser stores user-1 with password123 and returns the user
+func profileUser(t *testing.T, users *MockUserRepository) *models.User {
+	hashedPassword, _ := auth.HashPassword("password123")
+	user := &models.User{ID: "user-1", Email: "jane@example.com", Password: hashedPassword, Role: "user", Roles: []string{"user"}}
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+	return user
+}
+
+func TestUpdateProfile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	profileUser(t, users)
+	users.On("UpdateProfile", mock.Anything, "user-1", map[string]interface{}{
+		"firstName":        "Janet",
+		"phone":            nil,
+		"deliveryDefaults": &models.DeliveryDefaults{LocationDetails: "Gate B, Kilimani", Notes: "Call on arrival"},
+	}).Return(nil)
+
+	w := asUser(UpdateProfile, "user-1", map[string]interface{}{
+		"firstName":        " Janet ",
+		"phone":            "",
+		"deliveryDefaults": map[string]string{"locationDetails": "Gate B, Kilimani", "notes": "Call on arrival"},
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	users.AssertExpectations(t)
+}
+
+func TestUpdateProfile_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+
+	for _, body := range []map[string]interface{}{
+		{},
+		{"lastName": "  "},
+		{"firstName": ""},
+	} {
+		w := asUser(UpdateProfile, "user-1", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	users.AssertNotCalled(t, "UpdateProfile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestChangePassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	tokens, _ := useAccountEmail(t)
+	sessions, blacklist := useSessionRepos(t)
+	users := useUserRepo(t)
+	profileUser(t, users)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	users.On("UpdatePassword", mock.Anything, "user-1", mock.MatchedBy(func(hash string) bool {
+		return auth.VerifyPassword(hash, "new-password") == nil
+	})).Return(nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventPasswordChanged && e.UserID == "user-1"
+	})).Return(nil)
+	tokens.On("InvalidateAccountTokens", mock.Anything, "user-1", models.AccountTokenPasswordReset).Return(nil)
+
+	// Every session but the one making the request is signed out
+	current, _ := activeSession(t, "user-1")
+	other, _ := activeSession(t, "user-1")
+	other.ID = "session-phone"
+	sessions.On("ListActiveSessions", mock.Anything, "user-1").Return([]*models.Session{current, other}, nil)
+	sessions.On("RevokeSession", mock.Anything, other.ID, models.SessionRevokedPasswordChange).Return(true, nil)
+	sessions.On("GetSessionByID", mock.Anything, other.ID).Return(other, nil)
+	blacklist.On("BlacklistToken", mock.Anything, "jti-old", other.AccessExpiresAt).Return(nil)
+
+	w := postJSON(func(c *gin.Context) {
+		c.Set("userID", "user-1")
+		c.Set("sessionID", current.ID)
+		ChangePassword(c)
+	}, "/profile/password", models.ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "new-password"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"revokedSessions":1`)
+	users.AssertExpectations(t)
+	sessions.AssertNotCalled(t, "RevokeSession", mock.Anything, current.ID, mock.Anything)
+}
+
+func TestChangePassword_WrongCurrentPassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	users := useUserRepo(t)
+	profileUser(t, users)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&models.LoginThrottle{Failures: 1}, nil)
+
+	w := asUser(ChangePassword, "user-1", models.ChangePasswordRequest{CurrentPassword: "guess", NewPassword: "new-password"})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	throttles.AssertNumberOfCalls(t, "RecordLoginFailure", 2)
+	users.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	tokens, _ := useAccountEmail(t)
+	sessions, _ := useSessionRepos(t)
+	users := useUserRepo(t)
+	profileUser(t, users)
+	orders, carts, addresses := new(MockOrderRepository), new(MockCartRepository), new(MockAddressRepository)
+	oldOrders, oldCarts, oldAddresses := NewOrderRepository, NewCartRepository, NewAddressRepository
+	NewOrderRepository, NewCartRepository, NewAddressRepository = orders, carts, addresses
+	t.Cleanup(func() {
+		NewOrderRepository, NewCartRepository, NewAddressRepository = oldOrders, oldCarts, oldAddresses
+	})
+	shipments := useShipmentRepo(t)
+
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	orders.On("CountOpenOrdersByUser", mock.Anything, "user-1").Return(int64(0), nil)
+	orders.On("AnonymiseOrdersByUser", mock.Anything, "user-1").Return(int64(3), nil)
+	users.On("AnonymiseUser", mock.Anything, "user-1", mock.Anything).Return(true, nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventAccountDeleted && e.UserID == "user-1" && e.Email == ""
+	})).Return(nil)
+	sessions.On("ListActiveSessions", mock.Anything, "user-1").Return([]*models.Session{}, nil)
+	carts.On("ClearCart", mock.Anything, "user-1").Return(nil)
+	addresses.On("DeleteAddressesByUser", mock.Anything, "user-1").Return(int64(2), nil)
+	shipments.On("AnonymiseShipmentsByUser", mock.Anything, "user-1").Return(int64(1), nil)
+	tokens.On("InvalidateAccountTokens", mock.Anything, "user-1", mock.Anything).Return(nil)
+
+	w := asUser(DeleteAccount, "user-1", models.DeleteAccountRequest{Password: "password123"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	orders.AssertExpectations(t)
+	users.AssertExpectations(t)
+	events.AssertExpectations(t)
+	carts.AssertExpectations(t)
+	addresses.AssertExpectations(t)
+	shipments.AssertExpectations(t)
+	tokens.AssertNumberOfCalls(t, "InvalidateAccountTokens", 2)
+}
+
+func TestDeleteAccount_Refused(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	users := useUserRepo(t)
+	user := profileUser(t, users)
+	orders := new(MockOrderRepository)
+	oldOrders := NewOrderRepository
+	NewOrderRepository = orders
+	t.Cleanup(func() { NewOrderRepository = oldOrders })
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+
+	// Orders still on their way
+	orders.On("CountOpenOrdersByUser", mock.Anything, "user-1").Return(int64(2), nil).Once()
+	w := asUser(DeleteAccount, "user-1", models.DeleteAccountRequest{Password: "password123"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"openOrders":2`)
+
+	// Staff lose their roles first
+	user.Roles = []string{"user", "warehouse"}
+	w = asUser(DeleteAccount, "user-1", models.DeleteAccountRequest{Password: "password123"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// A second factor is needed once enabled
+	user.Roles = []string{"user"}
+	user.TwoFactorEnabled = true
+	w = asUser(DeleteAccount, "user-1", models.DeleteAccountRequest{Password: "password123"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	orders.AssertNotCalled(t, "AnonymiseOrdersByUser", mock.Anything, mock.Anything)
+	users.AssertNotCalled(t, "AnonymiseUser", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// validatePromotionRule checks that a promotion's discount rule is well formed.
+// It returns an empty string when the rule is valid.
+func validatePromotionRule(p *models.Promotion) string {
+	switch p.Type {
+	case models.PromotionTypePercentage:
+		if p.Value <= 0 || p.Value > 100 {
+			return "percentage value must be between 0 and 100"
+		}
+	case models.PromotionTypeFixed:
+		if p.Value <= 0 {
+			return "fixed value must be greater than 0"
+		}
+	case models.PromotionTypeBuyXGetY:
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+			return "buyQuantity and getQuantity must be at least 1"
+		}
+	default:
+		return "unknown promotion type"
+	}
+
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return "endsAt must be after startsAt"
+	}
+
+	return ""
+}
+
+// promotionUnavailable reports why a promotion cannot be redeemed at the given
+// time, or an empty string if it can.
+func promotionUnavailable(p *models.Promotion, now time.Time) string {
+	if !p.Active {
+		return "coupon is not active"
+	}
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return "coupon is not yet valid"
+	}
+	if p.EndsAt != nil && now.After(*p.EndsAt) {
+		return "coupon has expired"
+	}
+	if p.MaxRedemptions > 0 && p.RedemptionCount >= p.MaxRedemptions {
+		return "coupon redemption limit reached"
+	}
+	return ""
+}
+
+// promotionDiscounts computes the extra discount a promotion grants on each
+// order item. Items are priced net of any discount they already carry, and a
+// promotion never takes an item below zero.
+func promotionDiscounts(p *models.Promotion, items []models.OrderItem) []float64 {
+	eligible := make(map[string]bool, len(p.ProductIDs))
+	for _, id := range p.ProductIDs {
+		eligible[id] = true
+	}
+
+	discounts := make([]float64, len(items))
+	nets := make([]float64, len(items))
+	var eligibleNet float64
+	for i, item := range items {
+		if len(eligible) > 0 && !eligible[item.ProductID] {
+			continue
+		}
+		net := item.Price*float64(item.Quantity) - item.Discount
+		if net < 0 {
+			net = 0
+		}
+		nets[i] = net
+		eligibleNet += net
+	}
+
+	switch p.Type {
+	case models.PromotionTypePercentage:
+		for i := range items {
+			discounts[i] = nets[i] * p.Value / 100.0
+		}
+	case models.PromotionTypeFixed:
+		// Spread the fixed amount over eligible items in proportion to their value
+		if eligibleNet > 0 {
+			amount := p.Value
+			if amount > eligibleNet {
+				amount = eligibleNet
+			}
+			for i := range items {
+				discounts[i] = amount * nets[i] / eligibleNet
+			}
+		}
+	case models.PromotionTypeBuyXGetY:
+		group := p.BuyQuantity + p.GetQuantity
+		for i, item := range items {
+			if nets[i] == 0 || group == 0 {
+				continue
+			}
+			free := (item.Quantity / group) * p.GetQuantity
+			discounts[i] = float64(free) * nets[i] / float64(item.Quantity)
+		}
+	}
+
+	return discounts
+}
+
+// applyCoupon looks up a coupon code and adds its discount to the order items.
+// It returns the promotion and the total discount it granted.
+func applyCoupon(ctx context.Context, userID, code string, items []models.OrderItem) (*models.Promotion, float64, *orderError) {
+	promoRepo := NewPromotionRepository
+
+	promo, err := promoRepo.GetPromotionByCode(ctx, code)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, 0, &orderError{http.StatusBadRequest, gin.H{"error": "invalid coupon code"}}
+		}
+		return nil, 0, &orderError{http.StatusInternalServerError, gin.H{"error": "failed to retrieve coupon"}}
+	}
+
+	if reason := promotionUnavailable(promo, time.Now()); reason != "" {
+		return nil, 0, &orderError{http.StatusBadRequest, gin.H{"error": reason}}
+	}
+
+	if promo.MaxRedemptionsPerUser > 0 {
+		used, err := promoRepo.CountUserRedemptions(ctx, promo.ID, userID)
+		if err != nil {
+			return nil, 0, &orderError{http.StatusInternalServerError, gin.H{"error": "failed to check coupon usage"}}
+		}
+		if used >= int64(promo.MaxRedemptionsPerUser) {
+			return nil, 0, &orderError{http.StatusBadRequest, gin.H{"error": "coupon already used the maximum number of times"}}
+		}
+	}
+
+	var basket float64
+	for _, item := range items {
+		basket += item.Price*float64(item.Quantity) - item.Discount
+	}
+	if basket < promo.MinBasketValue {
+		return nil, 0, &orderError{http.StatusBadRequest, gin.H{"error": "order does not meet the coupon's minimum basket value", "minBasketValue": promo.MinBasketValue}}
+	}
+
+	var total float64
+	for i, d := range promotionDiscounts(promo, items) {
+		items[i].Discount += d
+		total += d
+	}
+	if total <= 0 {
+		return nil, 0, &orderError{http.StatusBadRequest, gin.H{"error": "coupon does not apply to items in this order"}}
+	}
+
+	return promo, total, nil
+}
+
+// redeemPromotion counts a coupon use against the promotion's global and per-user
+// limits and records the redemption against the order so reports can attribute
+// the discount. The limits are claimed atomically, so concurrent checkouts cannot
+// go over them even though applyCoupon checked them earlier.
+func redeemPromotion(ctx context.Context, promo *models.Promotion, discount float64, userID, orderID string) (*models.AppliedPromotion, *orderError) {
+	promoRepo := NewPromotionRepository
+
+	if err := promoRepo.ClaimRedemption(ctx, promo.ID, userID, promo.MaxRedemptionsPerUser); err != nil {
+		switch err.Error() {
+		case "promotion redemption limit reached":
+			return nil, &orderError{http.StatusConflict, gin.H{"error": "coupon redemption limit reached"}}
+		case "promotion user redemption limit reached":
+			return nil, &orderError{http.StatusBadRequest, gin.H{"error": "coupon already used the maximum number of times"}}
+		}
+		return nil, &orderError{http.StatusInternalServerError, gin.H{"error": "failed to redeem coupon"}}
+	}
+	releaseUser := ""
+	if promo.MaxRedemptionsPerUser > 0 {
+		releaseUser = userID
+	}
+	database.OnRollback(ctx, func() { _ = promoRepo.ReleaseRedemption(context.Background(), promo.ID, releaseUser) })
+
+	redemption := &models.PromotionRedemption{
+		ID:          uuid.New().String(),
+		PromotionID: promo.ID,
+		Code:        promo.Code,
+		UserID:      userID,
+		OrderID:     orderID,
+		Discount:    discount,
+	}
+	if err := promoRepo.CreateRedemption(ctx, redemption); err != nil {
+		return nil, &orderError{http.StatusInternalServerError, gin.H{"error": "failed to redeem coupon"}}
+	}
+	database.OnRollback(ctx, func() { _ = promoRepo.DeleteRedemption(context.Background(), redemption.ID) })
+
+	return &models.AppliedPromotion{
+		PromotionID:  promo.ID,
+		Code:         promo.Code,
+		RedemptionID: redemption.ID,
+		Discount:     discount,
+	}, nil
+}
+
+// AdminCreatePromotion creates a new coupon-backed promotion
+func AdminCreatePromotion(c *gin.Context) {
+	var req models.CreatePromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promo := &models.Promotion{
+		ID:                    uuid.New().String(),
+		Code:                  strings.ToUpper(strings.TrimSpace(req.Code)),
+		Description:           req.Description,
+		Type:                  req.Type,
+		Value:                 req.Value,
+		ProductIDs:            req.ProductIDs,
+		BuyQuantity:           req.BuyQuantity,
+		GetQuantity:           req.GetQuantity,
+		MinBasketValue:        req.MinBasketValue,
+		StartsAt:              req.StartsAt,
+		EndsAt:                req.EndsAt,
+		MaxRedemptions:        req.MaxRedemptions,
+		MaxRedemptionsPerUser: req.MaxRedemptionsPerUser,
+		Active:                true,
+		CreatedBy:             c.GetString("userID"),
+	}
+
+	if msg := validatePromotionRule(promo); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	promoRepo := NewPromotionRepository
+	if err := promoRepo.CreatePromotion(context.Background(), promo); err != nil {
+		if err.Error() == "promotion code already exists" {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create promotion"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, promo)
+}
+
+// AdminListPromotions lists promotions with pagination
+func AdminListPromotions(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	promoRepo := NewPromotionRepository
+	promos, err := promoRepo.GetAllPromotions(context.Background(), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve promotions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": promos, "page": page, "limit": limit})
+}
+
+// AdminGetPromotion returns a single promotion
+func AdminGetPromotion(c *gin.Context) {
+	promoRepo := NewPromotionRepository
+	promo, err := promoRepo.GetPromotionByID(context.Background(), c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "promotion not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve promotion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, promo)
+}
+
+// AdminUpdatePromotion changes a promotion's validity, limits or active flag.
+// The code and discount rule are fixed once created so past redemptions stay meaningful.
+func AdminUpdatePromotion(c *gin.Context) {
+	promotionID := c.Param("id")
+
+	var req models.UpdatePromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promoRepo := NewPromotionRepository
+	promo, err := promoRepo.GetPromotionByID(context.Background(), promotionID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "promotion not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve promotion"})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if req.Description != nil {
+		promo.Description = *req.Description
+		updates["description"] = *req.Description
+	}
+	if req.Active != nil {
+		promo.Active = *req.Active
+		updates["active"] = *req.Active
+	}
+	if req.MinBasketValue != nil {
+		promo.MinBasketValue = *req.MinBasketValue
+		updates["minBasketValue"] = *req.MinBasketValue
+	}
+	if req.StartsAt != nil {
+		promo.StartsAt = req.StartsAt
+		updates["startsAt"] = *req.StartsAt
+	}
+	if req.EndsAt != nil {
+		promo.EndsAt = req.EndsAt
+		updates["endsAt"] = *req.EndsAt
+	}
+	if req.MaxRedemptions != nil {
+		promo.MaxRedemptions = *req.MaxRedemptions
+		updates["maxRedemptions"] = *req.MaxRedemptions
+	}
+	if req.MaxRedemptionsPerUser != nil {
+		promo.MaxRedemptionsPerUser = *req.MaxRedemptionsPerUser
+		updates["maxRedemptionsPerUser"] = *req.MaxRedemptionsPerUser
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+		return
+	}
+
+	if msg := validatePromotionRule(promo); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := promoRepo.UpdatePromotion(context.Background(), promotionID, updates); err != nil {
+		if err.Error() == "promotion not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update promotion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, promo)
+}
+
+// AdminListPromotionRedemptions lists the orders a promotion was redeemed on
+func AdminListPromotionRedemptions(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	promoRepo := NewPromotionRepository
+	redemptions, err := promoRepo.GetRedemptionsByPromotion(context.Background(), c.Param("id"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve redemptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": redemptions, "page": page, "limit": limit})
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPromotionDiscounts_Percentage(t *testing.T) {
+	promo := &models.Promotion{Type: models.PromotionTypePercentage, Value: 10}
+	items := []models.OrderItem{
+		{ProductID: "a", Quantity: 2, Price: 100, Discount: 20},
+		{ProductID: "b", Quantity: 1, Price: 50},
+	}
+
+	discounts := promotionDiscounts(promo, items)
+
+	assert.InDelta(t, 18.0, discounts[0], 0.001)
+	assert.InDelta(t, 5.0, discounts[1], 0.001)
+}
+
+func TestPromotionDiscounts_FixedIsCappedAndSpread(t *testing.T) {
+	promo := &models.Promotion{Type: models.PromotionTypeFixed, Value: 500, ProductIDs: []string{"a", "b"}}
+	items := []models.OrderItem{
+		{ProductID: "a", Quantity: 1, Price: 100},
+		{ProductID: "b", Quantity: 1, Price: 300},
+		{ProductID: "c", Quantity: 1, Price: 1000},
+	}
+
+	discounts := promotionDiscounts(promo, items)
+
+	assert.InDelta(t, 100.0, discounts[0], 0.001)
+	assert.InDelta(t, 300.0, discounts[1], 0.001)
+	assert.Equal(t, 0.0, discounts[2])
+}
+
+func TestPromotionDiscounts_BuyXGetY(t *testing.T) {
+	promo := &models.Promotion{Type: models.PromotionTypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1}
+	items := []models.OrderItem{
+		{ProductID: "a", Quantity: 7, Price: 30},
+		{ProductID: "b", Quantity: 2, Price: 10},
+	}
+
+	discounts := promotionDiscounts(promo, items)
+
+	// 7 units = two full "buy 2 get 1" groups, so two units are free
+	assert.InDelta(t, 60.0, discounts[0], 0.001)
+	assert.Equal(t, 0.0, discounts[1])
+}
+
+func TestPromotionUnavailable(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	assert.Equal(t, "", promotionUnavailable(&models.Promotion{Active: true}, now))
+	assert.Equal(t, "coupon is not active", promotionUnavailable(&models.Promotion{}, now))
+	assert.Equal(t, "coupon is not yet valid", promotionUnavailable(&models.Promotion{Active: true, StartsAt: &future}, now))
+	assert.Equal(t, "coupon has expired", promotionUnavailable(&models.Promotion{Active: true, EndsAt: &past}, now))
+	assert.Equal(t, "coupon redemption limit reached", promotionUnavailable(&models.Promotion{Active: true, MaxRedemptions: 5, RedemptionCount: 5}, now))
+}
+
+// setupCouponOrder wires mocks for a single-product order of two units at 100 each
+func setupCouponOrder(t *testing.T, productID string) (*MockProductRepository, *MockOrderRepository, *MockInvoiceRepository, *MockPromotionRepository) {
+	product := &models.Product{ID: productID, Name: "Test Product", Price: 100, Stock: 5}
+
+	mockProductRepo := new(MockProductRepository)
+	mockProductRepo.On("GetProductByID", mock.Anything, productID).Return(product, nil)
+
+	mockOrderRepo := new(MockOrderRepository)
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockPromoRepo := new(MockPromotionRepository)
+
+	oldProductRepo := NewProductRepository
+	oldOrderRepo := NewOrderRepository
+	oldInvoiceRepo := NewInvoiceRepository
+	oldPromoRepo := NewPromotionRepository
+	NewProductRepository = ProductRepository(mockProductRepo)
+	NewOrderRepository = OrderRepository(mockOrderRepo)
+	NewInvoiceRepository = InvoiceRepository(mockInvoiceRepo)
+	NewPromotionRepository = PromotionRepository(mockPromoRepo)
+	t.Cleanup(func() {
+		NewProductRepository = oldProductRepo
+		NewOrderRepository = oldOrderRepo
+		NewInvoiceRepository = oldInvoiceRepo
+		NewPromotionRepository = oldPromoRepo
+	})
+
+	return mockProductRepo, mockOrderRepo, mockInvoiceRepo, mockPromoRepo
+}
+
+func postCouponOrder(userID, body string) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest("POST", "/orders", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", userID)
+
+	CreateOrder(c)
+	return w
+}
+
+func TestCreateOrder_WithCoupon(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	promo := &models.Promotion{ID: "promo-1", Code: "SAVE10", Type: models.PromotionTypePercentage, Value: 10, Active: true, MaxRedemptionsPerUser: 1}
+
+	mockProductRepo, mockOrderRepo, mockInvoiceRepo, mockPromoRepo := setupCouponOrder(t, productID)
+	mockProductRepo.On("ReserveStock", mock.Anything, productID, 2).Return(nil)
+	mockPromoRepo.On("GetPromotionByCode", mock.Anything, "save10").Return(promo, nil)
+	mockPromoRepo.On("CountUserRedemptions", mock.Anything, promo.ID, userID).Return(int64(0), nil)
+	mockPromoRepo.On("ClaimRedemption", mock.Anything, promo.ID, userID, 1).Return(nil)
+	mockPromoRepo.On("CreateRedemption", mock.Anything, mock.MatchedBy(func(r *models.PromotionRedemption) bool {
+		return r.PromotionID == promo.ID && r.UserID == userID && r.OrderID != "" && r.Discount == 20
+	})).Return(nil)
+	mockOrderRepo.On("CreateOrder", mock.Anything, mock.MatchedBy(func(o *models.Order) bool {
+		return o.Promotion != nil && o.Promotion.Code == "SAVE10" && o.Discount == 20 && o.TotalCost == 180 && o.Metadata.Discounts == nil
+	})).Return(nil)
+	mockInvoiceRepo.On("CreateInvoice", mock.Anything, mock.MatchedBy(func(inv *models.Invoice) bool {
+		return inv.InvoiceAmount == 180
+	})).Return(nil)
+
+	// Client-supplied discounts must be ignored
+	body := `{"phone":"254712345678","couponCode":"save10","products":[{"productId":"` + productID + `","quantity":2}],"metadata":{"discounts":{"` + productID + `":150}}}`
+	w := postCouponOrder(userID, body)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	mockOrderRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertExpectations(t)
+	mockPromoRepo.AssertExpectations(t)
+}
+
+func TestCreateOrder_UnknownCoupon(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+
+	mockProductRepo, mockOrderRepo, _, mockPromoRepo := setupCouponOrder(t, productID)
+	mockPromoRepo.On("GetPromotionByCode", mock.Anything, "NOPE").Return(nil, mongo.ErrNoDocuments)
+
+	body := `{"phone":"254712345678","couponCode":"NOPE","products":[{"productId":"` + productID + `","quantity":2}]}`
+	w := postCouponOrder(userID, body)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockProductRepo.AssertNotCalled(t, "ReserveStock", mock.Anything, mock.Anything, mock.Anything)
+	mockOrderRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestCreateOrder_CouponBelowMinimumBasket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	promo := &models.Promotion{ID: "promo-1", Code: "BIG", Type: models.PromotionTypeFixed, Value: 50, Active: true, MinBasketValue: 1000}
+
+	_, mockOrderRepo, _, mockPromoRepo := setupCouponOrder(t, productID)
+	mockPromoRepo.On("GetPromotionByCode", mock.Anything, "BIG").Return(promo, nil)
+
+	body := `{"phone":"254712345678","couponCode":"BIG","products":[{"productId":"` + productID + `","quantity":2}]}`
+	w := postCouponOrder(userID, body)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "minimum basket value")
+	mockOrderRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestCreateOrder_CouponPerUserLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	promo := &models.Promotion{ID: "promo-1", Code: "ONCE", Type: models.PromotionTypeFixed, Value: 50, Active: true, MaxRedemptionsPerUser: 1}
+
+	_, mockOrderRepo, _, mockPromoRepo := setupCouponOrder(t, productID)
+	mockPromoRepo.On("GetPromotionByCode", mock.Anything, "ONCE").Return(promo, nil)
+	mockPromoRepo.On("CountUserRedemptions", mock.Anything, promo.ID, userID).Return(int64(1), nil)
+
+	body := `{"phone":"254712345678","couponCode":"ONCE","products":[{"productId":"` + productID + `","quantity":2}]}`
+	w := postCouponOrder(userID, body)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockOrderRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestCreateOrder_CouponPerUserLimitClaimedConcurrently(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	promo := &models.Promotion{ID: "promo-1", Code: "ONCE", Type: models.PromotionTypeFixed, Value: 50, Active: true, MaxRedemptionsPerUser: 1}
+
+	// A concurrent checkout by the same user took the last use after the count
+	mockProductRepo, mockOrderRepo, _, mockPromoRepo := setupCouponOrder(t, productID)
+	mockProductRepo.On("ReserveStock", mock.Anything, productID, 2).Return(nil)
+	mockProductRepo.On("ReleaseStock", mock.Anything, productID, 2).Return(nil)
+	mockPromoRepo.On("GetPromotionByCode", mock.Anything, "ONCE").Return(promo, nil)
+	mockPromoRepo.On("CountUserRedemptions", mock.Anything, promo.ID, userID).Return(int64(0), nil)
+	mockPromoRepo.On("ClaimRedemption", mock.Anything, promo.ID, userID, 1).Return(errors.New("promotion user redemption limit reached"))
+
+	body := `{"phone":"254712345678","couponCode":"ONCE","products":[{"productId":"` + productID + `","quantity":2}]}`
+	w := postCouponOrder(userID, body)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "maximum number of times")
+	mockProductRepo.AssertExpectations(t)
+	mockPromoRepo.AssertNotCalled(t, "CreateRedemption", mock.Anything, mock.Anything)
+	mockOrderRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestCreateOrder_CouponGlobalLimitReleasesStock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	promo := &models.Promotion{ID: "promo-1", Code: "LAST", Type: models.PromotionTypeFixed, Value: 50, Active: true, MaxRedemptions: 10, RedemptionCount: 9}
+
+	mockProductRepo, mockOrderRepo, _, mockPromoRepo := setupCouponOrder(t, productID)
+	mockProductRepo.On("ReserveStock", mock.Anything, productID, 2).Return(nil)
+	mockProductRepo.On("ReleaseStock", mock.Anything, productID, 2).Return(nil)
+	mockPromoRepo.On("GetPromotionByCode", mock.Anything, "LAST").Return(promo, nil)
+	mockPromoRepo.On("ClaimRedemption", mock.Anything, promo.ID, userID, 0).Return(errors.New("promotion redemption limit reached"))
+
+	body := `{"phone":"254712345678","couponCode":"LAST","products":[{"productId":"` + productID + `","quantity":2}]}`
+	w := postCouponOrder(userID, body)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mockProductRepo.AssertExpectations(t)
+	mockOrderRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestAdminCreatePromotion_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	adminID := uuid.New().String()
+
+	mockPromoRepo := new(MockPromotionRepository)
+	mockPromoRepo.On("CreatePromotion", mock.Anything, mock.MatchedBy(func(p *models.Promotion) bool {
+		return p.Code == "EASTER" && p.Type == models.PromotionTypeBuyXGetY && p.Active && p.CreatedBy == adminID
+	})).Return(nil)
+
+	oldPromoRepo := NewPromotionRepository
+	NewPromotionRepository = PromotionRepository(mockPromoRepo)
+	defer func() { NewPromotionRepository = oldPromoRepo }()
+
+	body, _ := json.Marshal(models.CreatePromotionRequest{Code: "easter", Type: models.PromotionTypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1})
+	httpReq := httptest.NewRequest("POST", "/admin/promotions", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Set("userID", adminID)
+
+	AdminCreatePromotion(c)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	mockPromoRepo.AssertExpectations(t)
+}
+
+func TestAdminCreatePromotion_InvalidRule(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	body, _ := json.Marshal(models.CreatePromotionRequest{Code: "HALF", Type: models.PromotionTypePercentage, Value: 150})
+	httpReq := httptest.NewRequest("POST", "/admin/promotions", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	AdminCreatePromotion(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminCreatePromotion_DuplicateCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockPromoRepo := new(MockPromotionRepository)
+	mockPromoRepo.On("CreatePromotion", mock.Anything, mock.Anything).Return(errors.New("promotion code already exists"))
+
+	oldPromoRepo := NewPromotionRepository
+	NewPromotionRepository = PromotionRepository(mockPromoRepo)
+	defer func() { NewPromotionRepository = oldPromoRepo }()
+
+	body, _ := json.Marshal(models.CreatePromotionRequest{Code: "SAVE10", Type: models.PromotionTypePercentage, Value: 10})
+	httpReq := httptest.NewRequest("POST", "/admin/promotions", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	AdminCreatePromotion(c)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAdminGetPromotionReport_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockReportRepo := new(MockReportRepository)
+	mockReportRepo.On("GetPromotionReport", mock.Anything, "2024-01-01", "2024-01-31").Return([]models.PromotionReport{
+		{PromotionID: "promo-1", Code: "SAVE10", Redemptions: 3, TotalDiscount: 60},
+	}, nil)
+
+	oldReportRepo := NewReportRepository
+	NewReportRepository = ReportRepository(mockReportRepo)
+	defer func() { NewReportRepository = oldReportRepo }()
+
+	httpReq := httptest.NewRequest("GET", "/admin/reports/promotions?startDate=2024-01-01&endDate=2024-01-31", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	AdminGetPromotionReport(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "SAVE10")
+	mockReportRepo.AssertExpectations(t)
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/eddie-wainaina1/maggiesb/internal/payment/provider"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AdminRefundInvoice pays the refund owed on a receivable invoice back to the
+// customer, by default with the provider the invoice was paid with. M-Pesa refunds
+// are B2C payments that stay pending until Safaricom reports the payout on the B2C
+// result URL; card refunds are usually settled by the gateway straight away.
+func AdminRefundInvoice(c *gin.Context) {
+	invoiceID := c.Param("id")
+
+	var req models.RefundInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invoice, err := NewInvoiceRepository.GetInvoiceByID(context.Background(), invoiceID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve invoice"})
+		return
+	}
+
+	if invoice.Type != models.InvoiceTypeReceivable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only receivable invoices can be refunded"})
+		return
+	}
+
+	name := req.Provider
+	if name == "" {
+		name = providerName(invoice.PaymentProvider)
+	}
+	if name == provider.CashOnDelivery {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cash payments cannot be refunded automatically; choose provider mpesa or card"})
+		return
+	}
+	p := paymentProviderFor(name)
+	if p == nil {
+		if name == provider.Mpesa {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "M-Pesa client not initialized or not configured"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("payment provider not configured: %s", name)})
+		return
+	}
+
+	// Only one payout at a time, so a refund cannot be paid twice while the provider
+	// is still processing the first request. The reservation below enforces it; this
+	// check answers the common case before any work is done.
+	refunds, err := NewRefundRepository.GetRefundsByInvoiceID(context.Background(), invoiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve refunds"})
+		return
+	}
+	for _, r := range refunds {
+		if r.Status == models.RefundStatusPending {
+			c.JSON(http.StatusConflict, gin.H{"error": "a refund is already pending for this invoice"})
+			return
+		}
+	}
+
+	reversals, err := NewReversalRepository.GetReversalsByInvoiceID(context.Background(), invoiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve reversals"})
+		return
+	}
+	rev := refundOwedReversal(reversals)
+	if rev == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no refund owed on this invoice"})
+		return
+	}
+	outstanding := rev.RefundOwed - rev.RefundedAmount
+
+	amt := req.Amount
+	if name == provider.Mpesa {
+		// M-Pesa only pays whole shillings; never round a refund up
+		if amt == 0 {
+			amt = math.Floor(outstanding + amountTolerance)
+		}
+		if amt != math.Trunc(amt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "M-Pesa refunds must be whole shillings"})
+			return
+		}
+		if amt < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no refund owed on this invoice"})
+			return
+		}
+	} else if amt == 0 {
+		amt = math.Round(outstanding*100) / 100
+	}
+	if amt > outstanding+amountTolerance {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount exceeds refund owed"})
+		return
+	}
+
+	var refundCallbackTokenHash string
+	refundReq := provider.RefundRequest{
+		InvoiceID: invoice.ID,
+		Amount:    amt,
+		Reason:    req.Reason,
+	}
+	if name == provider.Mpesa {
+		order, err := NewOrderRepository.GetOrderByID(context.Background(), invoice.OrderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve order"})
+			return
+		}
+
+		refundReq.Phone = req.Phone
+		if refundReq.Phone == "" {
+			refundReq.Phone = order.Phone
+		}
+		if refundReq.Phone == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no phone number to refund; provide phone"})
+			return
+		}
+
+		refundReq.CommandID = req.CommandID
+		if refundReq.CommandID == "" {
+			refundReq.CommandID = mpesa.B2CBusinessPayment
+		}
+
+		refundReq.CallbackToken, refundCallbackTokenHash, err = newCallbackToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		// Card refunds go back to the charge that paid the invoice
+		refundReq.PaymentReference, err = latestProviderPayment(context.Background(), invoice.ID, name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve invoice payments"})
+			return
+		}
+		if refundReq.PaymentReference == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("no completed %s payment found to refund", name)})
+			return
+		}
+	}
+
+	// Reserve the payout before asking the provider for it: the store allows one
+	// pending refund per invoice, so a concurrent request fails here instead of
+	// paying the customer a second time
+	refund := &models.Refund{
+		ID:                uuid.New().String(),
+		Provider:          name,
+		InvoiceID:         invoice.ID,
+		OrderID:           invoice.OrderID,
+		ReversalID:        rev.ID,
+		Phone:             refundReq.Phone,
+		Amount:            amt,
+		CommandID:         refundReq.CommandID,
+		Status:            models.RefundStatusPending,
+		CallbackTokenHash: refundCallbackTokenHash,
+		AdminID:           c.GetString("userID"),
+		Reason:            req.Reason,
+	}
+	if err := NewRefundRepository.CreateRefund(context.Background(), refund); err != nil {
+		if err.Error() == "refund already pending" {
+			c.JSON(http.StatusConflict, gin.H{"error": "a refund is already pending for this invoice"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record refund"})
+		return
+	}
+
+	initiation, err := p.Refund(context.Background(), refundReq)
+	if err != nil {
+		failRefund(refund, err.Error())
+		c.JSON(mpesa.HTTPStatus(err, http.StatusBadGateway), gin.H{"error": fmt.Sprintf("%s refund failed: %v", name, err)})
+		return
+	}
+
+	refund.ConversationID = initiation.Reference
+	refund.OriginatorConversationID = initiation.OriginatorReference
+	if err := NewRefundRepository.SetRefundReferences(context.Background(), refund.ID, refund.ConversationID, refund.OriginatorConversationID); err != nil {
+		// The refund stays pending, so it cannot be requested again before an admin checks it
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "refund requested but failed to record it; check the refund", "refund": refund})
+		return
+	}
+	if initiation.Failed {
+		failRefund(refund, initiation.Description)
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("%s refund failed: %s", name, initiation.Description), "refund": refund})
+		return
+	}
+
+	// Refunds the provider settled immediately are credited now, as a result callback would
+	if initiation.Completed {
+		result := &models.RefundResult{ResultDesc: initiation.Description, TransactionID: initiation.Receipt}
+		if _, err := completeRefund(context.Background(), refund, result); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "refund paid but failed to record it; check the refund", "refund": refund})
+			return
+		}
+		refund.Status = models.RefundStatusPaid
+		refund.TransactionID = initiation.Receipt
+		c.JSON(http.StatusCreated, gin.H{"refund": refund})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"refund": refund})
+}
+
+// failRefund marks a reserved refund failed when the provider refused its payout,
+// so the invoice can be refunded again
+func failRefund(refund *models.Refund, desc string) {
+	refund.Status = models.RefundStatusFailed
+	refund.ResultDesc = desc
+	result := &models.RefundResult{ResultDesc: desc}
+	if _, err := NewRefundRepository.TransitionRefundStatus(context.Background(), refund.ID, models.RefundStatusPending, models.RefundStatusFailed, result); err != nil {
+		log.Printf("Failed to mark refund %s failed: %v", refund.ID, err)
+	}
+}
+
+// latestProviderPayment returns the provider reference of the newest completed
+// payment on an invoice made with the named provider, or an empty string if there is none
+func latestProviderPayment(ctx context.Context, invoiceID, name string) (string, error) {
+	payments, err := NewPaymentRepository.GetPaymentsByInvoiceID(ctx, invoiceID)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range payments {
+		if p.Status == models.PaymentStatusCompleted && providerName(p.Provider) == name {
+			return p.CheckoutRequestID, nil
+		}
+	}
+	return "", nil
+}
+
+// refundOwedReversal picks the oldest reversal on an invoice that still has money
+// owed to the customer, or nil if everything owed has been refunded
+func refundOwedReversal(reversals []*models.ReversalRecord) *models.ReversalRecord {
+	for i := len(reversals) - 1; i >= 0; i-- {
+		if reversals[i].RefundOwed-reversals[i].RefundedAmount > amountTolerance {
+			return reversals[i]
+		}
+	}
+	return nil
+}
+
+// AdminListInvoiceRefunds lists the refund payouts made against an invoice
+func AdminListInvoiceRefunds(c *gin.Context) {
+	invoiceID := c.Param("id")
+
+	refunds, err := NewRefundRepository.GetRefundsByInvoiceID(context.Background(), invoiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": refunds})
+}
+
+// HandleB2CResult receives the outcome of a B2C refund payout. A successful result
+// marks the refund paid and credits it against the reversal it settles.
+func HandleB2CResult(c *gin.Context) {
+	var result models.MpesaResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		auditRejectedCallback(c, models.CallbackSourceB2C, models.CallbackRejectInvalidBody, "", nil)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid callback"})
+		return
+	}
+
+	body := result.Result
+	refund, err := NewRefundRepository.GetRefundByConversationID(context.Background(), body.ConversationID, body.OriginatorConversationID)
+	if err != nil {
+		auditRejectedCallback(c, models.CallbackSourceB2C, models.CallbackRejectUnknown, body.ConversationID, nil)
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "1", "ResultDesc": "Refund not found"})
+		return
+	}
+	if reason := callbackTokenHashRejection(refund.CallbackTokenHash, c.Param("token")); reason != "" {
+		rejectCallback(c, models.CallbackSourceB2C, reason, body.ConversationID, nil)
+		return
+	}
+	if !withinReplayWindow(refund.CreatedAt) {
+		rejectCallback(c, models.CallbackSourceB2C, models.CallbackRejectReplayWindow, body.ConversationID, nil)
+		return
+	}
+
+	outcome := refundResult(body)
+	var settled bool
+	if body.ResultCode == 0 {
+		settled, err = completeRefund(context.Background(), refund, outcome)
+	} else {
+		settled, err = NewRefundRepository.TransitionRefundStatus(context.Background(), refund.ID, models.RefundStatusPending, models.RefundStatusFailed, outcome)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"ResultCode": "1", "ResultDesc": "Failed to update refund"})
+		return
+	}
+
+	// Safaricom retries callbacks; acknowledge repeats without crediting again
+	if !settled {
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback already processed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback received"})
+}
+
+// HandleB2CTimeout receives Safaricom's notice that a B2C request expired in its
+// queue. The refund is marked timed out so the admin can request it again.
+func HandleB2CTimeout(c *gin.Context) {
+	var result models.MpesaResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		auditRejectedCallback(c, models.CallbackSourceB2C, models.CallbackRejectInvalidBody, "", nil)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid callback"})
+		return
+	}
+
+	body := result.Result
+	refund, err := NewRefundRepository.GetRefundByConversationID(context.Background(), body.ConversationID, body.OriginatorConversationID)
+	if err != nil {
+		auditRejectedCallback(c, models.CallbackSourceB2C, models.CallbackRejectUnknown, body.ConversationID, nil)
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "1", "ResultDesc": "Refund not found"})
+		return
+	}
+	if reason := callbackTokenHashRejection(refund.CallbackTokenHash, c.Param("token")); reason != "" {
+		rejectCallback(c, models.CallbackSourceB2C, reason, body.ConversationID, nil)
+		return
+	}
+	if !withinReplayWindow(refund.CreatedAt) {
+		rejectCallback(c, models.CallbackSourceB2C, models.CallbackRejectReplayWindow, body.ConversationID, nil)
+		return
+	}
+
+	settled, err := NewRefundRepository.TransitionRefundStatus(context.Background(), refund.ID, models.RefundStatusPending, models.RefundStatusTimedOut, refundResult(body))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"ResultCode": "1", "ResultDesc": "Failed to update refund"})
+		return
+	}
+
+	if !settled {
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback already processed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback received"})
+}
+
+// refundResult extracts the payout details M-Pesa reports in a B2C result
+func refundResult(body models.MpesaResultBody) *models.RefundResult {
+	result := &models.RefundResult{
+		ResultCode:    body.ResultCode,
+		ResultDesc:    body.ResultDesc,
+		TransactionID: body.TransactionID,
+	}
+	for _, p := range body.ResultParameters.ResultParameter {
+		switch p.Key {
+		case "TransactionReceipt":
+			if receipt := provider.MetadataString(p.Value); receipt != "" {
+				result.TransactionID = receipt
+			}
+		case "ReceiverPartyPublicName":
+			result.ReceiverName = provider.MetadataString(p.Value)
+		}
+	}
+	return result
+}
+
+// completeRefund marks a pending refund paid and adds it to the amount refunded on
+// its reversal in one unit of work. It returns false if the refund was already settled.
+func completeRefund(ctx context.Context, refund *models.Refund, result *models.RefundResult) (bool, error) {
+	var settled bool
+	err := NewUnitOfWork.Do(ctx, func(ctx context.Context) error {
+		settled = false
+
+		ok, err := NewRefundRepository.TransitionRefundStatus(ctx, refund.ID, models.RefundStatusPending, models.RefundStatusPaid, result)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		database.OnRollback(ctx, func() {
+			NewRefundRepository.TransitionRefundStatus(context.Background(), refund.ID, models.RefundStatusPaid, models.RefundStatusPending, nil)
+		})
+
+		if err := NewReversalRepository.AddRefundedAmount(ctx, refund.ReversalID, refund.Amount); err != nil {
+			return err
+		}
+		settled = true
+		return nil
+	})
+	return settled, err
+}
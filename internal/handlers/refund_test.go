@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func useRefundMocks(t *testing.T, invoiceRepo *MockInvoiceRepository, orderRepo *MockOrderRepository, reversalRepo *MockReversalRepository, refundRepo *MockRefundRepository) {
+	oldInvoiceRepo := NewInvoiceRepository
+	oldOrderRepo := NewOrderRepository
+	oldReversalRepo := NewReversalRepository
+	oldRefundRepo := NewRefundRepository
+	NewInvoiceRepository = InvoiceRepository(invoiceRepo)
+	NewOrderRepository = OrderRepository(orderRepo)
+	NewReversalRepository = ReversalRepository(reversalRepo)
+	NewRefundRepository = RefundRepository(refundRepo)
+	t.Cleanup(func() {
+		NewInvoiceRepository = oldInvoiceRepo
+		NewOrderRepository = oldOrderRepo
+		NewReversalRepository = oldReversalRepo
+		NewRefundRepository = oldRefundRepo
+	})
+}
+
+// useB2CStubClient points the M-Pesa client at a stand-in that accepts B2C requests
+func useB2CStubClient(t *testing.T) *mpesa.B2CRequest {
+	var got mpesa.B2CRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(mpesa.B2CResponse{
+			ConversationID:           "AG_B2C_1",
+			OriginatorConversationID: "orig-AG_B2C_1",
+			ResponseCode:             "0",
+			ResponseDescription:      "Accept the service request successfully.",
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	old := mpesaClient
+	mpesaClient = mpesa.NewClient(mpesa.Config{
+		ConsumerKey:       "key",
+		ConsumerSecret:    "secret",
+		BusinessShortCode: "174379",
+		InitiatorName:     "apiop",
+		InitiatorPassword: "password",
+		PublicKeyPath:     writeTestPublicKey(t),
+		BaseURL:           server.URL,
+	})
+	t.Cleanup(func() { mpesaClient = old })
+	return &got
+}
+
+func postRefund(invoiceID, body string) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest("POST", "/admin/invoices/"+invoiceID+"/refund", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: invoiceID}}
+	c.Set("userID", "admin-1")
+
+	AdminRefundInvoice(c)
+	return w
+}
+
+func TestAdminRefundInvoice_PaysRefundOwed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sent := useB2CStubClient(t)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", Type: models.InvoiceTypeReceivable}, nil)
+
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", Phone: "254712345678"}, nil)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalsByInvoiceID", mock.Anything, "inv-1").Return([]*models.ReversalRecord{
+		{ID: "rev-1", InvoiceID: "inv-1", RefundOwed: 750.5, RefundedAmount: 250},
+	}, nil)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundsByInvoiceID", mock.Anything, "inv-1").Return([]*models.Refund{
+		{ID: "refund-0", Status: models.RefundStatusFailed},
+	}, nil)
+	mockRefundRepo.On("CreateRefund", mock.Anything, mock.MatchedBy(func(r *models.Refund) bool {
+		return r.ReversalID == "rev-1" &&
+			r.Amount == 500 &&
+			r.Phone == "254712345678" &&
+			r.Status == models.RefundStatusPending &&
+			r.ConversationID == "" &&
+			r.CallbackTokenHash != ""
+	})).Return(nil)
+	mockRefundRepo.On("SetRefundReferences", mock.Anything, mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(nil)
+	useRefundMocks(t, mockInvoiceRepo, mockOrderRepo, mockReversalRepo, mockRefundRepo)
+
+	w := postRefund("inv-1", `{"reason":"Order returned"}`)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	// Only whole shillings are paid; the remaining 50 cents stay owed
+	assert.Equal(t, "500", sent.Amount)
+	assert.Equal(t, "254712345678", sent.PartyB)
+	assert.Equal(t, mpesa.B2CBusinessPayment, sent.CommandID)
+	mockRefundRepo.AssertExpectations(t)
+}
+
+func TestAdminRefundInvoice_RejectsWhilePending(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useB2CStubClient(t)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", Type: models.InvoiceTypeReceivable}, nil)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundsByInvoiceID", mock.Anything, "inv-1").Return([]*models.Refund{
+		{ID: "refund-1", Status: models.RefundStatusPending},
+	}, nil)
+	useRefundMocks(t, mockInvoiceRepo, new(MockOrderRepository), new(MockReversalRepository), mockRefundRepo)
+
+	w := postRefund("inv-1", `{"reason":"Order returned"}`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mockRefundRepo.AssertNotCalled(t, "CreateRefund", mock.Anything, mock.Anything)
+}
+
+func TestAdminRefundInvoice_ConcurrentRequestLosesReservation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sent := useB2CStubClient(t)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", Type: models.InvoiceTypeReceivable}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", Phone: "254712345678"}, nil)
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalsByInvoiceID", mock.Anything, "inv-1").Return([]*models.ReversalRecord{{ID: "rev-1", RefundOwed: 500}}, nil)
+
+	// Both requests saw no pending refund; the other one reserved the payout first
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundsByInvoiceID", mock.Anything, "inv-1").Return([]*models.Refund{}, nil)
+	mockRefundRepo.On("CreateRefund", mock.Anything, mock.Anything).Return(errors.New("refund already pending"))
+	useRefundMocks(t, mockInvoiceRepo, mockOrderRepo, mockReversalRepo, mockRefundRepo)
+
+	w := postRefund("inv-1", `{"reason":"Order returned"}`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Empty(t, sent.Amount, "no payout may be requested without a reservation")
+}
+
+func TestAdminRefundInvoice_RefusedPayoutReleasesReservation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Daraja refuses the B2C request outright
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errorCode": "400.002.02", "errorMessage": "Bad Request - Invalid PartyB"})
+	}))
+	t.Cleanup(server.Close)
+	old := mpesaClient
+	mpesaClient = mpesa.NewClient(mpesa.Config{ConsumerKey: "key", ConsumerSecret: "secret", BusinessShortCode: "174379", InitiatorName: "apiop", InitiatorPassword: "password", PublicKeyPath: writeTestPublicKey(t), BaseURL: server.URL})
+	t.Cleanup(func() { mpesaClient = old })
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", OrderID: "order-1", Type: models.InvoiceTypeReceivable}, nil)
+	mockOrderRepo := new(MockOrderRepository)
+	mockOrderRepo.On("GetOrderByID", mock.Anything, "order-1").Return(&models.Order{ID: "order-1", Phone: "254712345678"}, nil)
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalsByInvoiceID", mock.Anything, "inv-1").Return([]*models.ReversalRecord{{ID: "rev-1", RefundOwed: 500}}, nil)
+
+	var reserved *models.Refund
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundsByInvoiceID", mock.Anything, "inv-1").Return([]*models.Refund{}, nil)
+	mockRefundRepo.On("CreateRefund", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		reserved = args.Get(1).(*models.Refund)
+	}).Return(nil)
+	reservedID := mock.MatchedBy(func(id string) bool { return reserved != nil && id == reserved.ID })
+	mockRefundRepo.On("TransitionRefundStatus", mock.Anything, reservedID, models.RefundStatusPending, models.RefundStatusFailed, mock.MatchedBy(func(r *models.RefundResult) bool {
+		return strings.Contains(r.ResultDesc, "Invalid PartyB")
+	})).Return(true, nil)
+	useRefundMocks(t, mockInvoiceRepo, mockOrderRepo, mockReversalRepo, mockRefundRepo)
+
+	w := postRefund("inv-1", `{"reason":"Order returned"}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockRefundRepo.AssertExpectations(t)
+	mockRefundRepo.AssertNotCalled(t, "SetRefundReferences", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAdminRefundInvoice_Validation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useB2CStubClient(t)
+
+	tests := []struct {
+		name     string
+		invoice  *models.Invoice
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{"payable invoice", &models.Invoice{ID: "inv-1", Type: models.InvoiceTypePayable}, `{"reason":"x"}`, http.StatusBadRequest, "only receivable invoices can be refunded"},
+		{"more than owed", &models.Invoice{ID: "inv-1", Type: models.InvoiceTypeReceivable}, `{"amount":600,"reason":"x"}`, http.StatusBadRequest, "amount exceeds refund owed"},
+		{"fractional amount", &models.Invoice{ID: "inv-1", Type: models.InvoiceTypeReceivable}, `{"amount":99.5,"reason":"x"}`, http.StatusBadRequest, "whole shillings"},
+		{"unknown command", &models.Invoice{ID: "inv-1", Type: models.InvoiceTypeReceivable}, `{"commandId":"SalaryPayment","reason":"x"}`, http.StatusBadRequest, "CommandID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockInvoiceRepo := new(MockInvoiceRepository)
+			mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(tt.invoice, nil)
+			mockReversalRepo := new(MockReversalRepository)
+			mockReversalRepo.On("GetReversalsByInvoiceID", mock.Anything, "inv-1").Return([]*models.ReversalRecord{{ID: "rev-1", RefundOwed: 500}}, nil)
+			mockRefundRepo := new(MockRefundRepository)
+			mockRefundRepo.On("GetRefundsByInvoiceID", mock.Anything, "inv-1").Return([]*models.Refund{}, nil)
+			useRefundMocks(t, mockInvoiceRepo, new(MockOrderRepository), mockReversalRepo, mockRefundRepo)
+
+			w := postRefund("inv-1", tt.body)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Contains(t, w.Body.String(), tt.wantErr)
+		})
+	}
+}
+
+// b2cResult builds a Daraja B2C result payload
+func b2cResult(conversationID string, resultCode int) string {
+	var result models.MpesaResult
+	result.Result = models.MpesaResultBody{
+		ResultCode:               resultCode,
+		ResultDesc:               "The service request is processed successfully.",
+		OriginatorConversationID: "orig-" + conversationID,
+		ConversationID:           conversationID,
+		TransactionID:            "NLJ41HAY6Q",
+	}
+	result.Result.ResultParameters.ResultParameter = []models.MpesaResultParameter{
+		{Key: "TransactionAmount", Value: 500},
+		{Key: "TransactionReceipt", Value: "NLJ41HAY6Q"},
+		{Key: "ReceiverPartyPublicName", Value: "254712345678 - Jane Doe"},
+	}
+	body, _ := json.Marshal(result)
+	return string(body)
+}
+
+func TestHandleB2CResult_SuccessCreditsReversal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundByConversationID", mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(&models.Refund{ID: "refund-1", ReversalID: "rev-1", Amount: 500, Status: models.RefundStatusPending}, nil)
+	mockRefundRepo.On("TransitionRefundStatus", mock.Anything, "refund-1", models.RefundStatusPending, models.RefundStatusPaid, mock.MatchedBy(func(r *models.RefundResult) bool {
+		return r.TransactionID == "NLJ41HAY6Q" && r.ReceiverName == "254712345678 - Jane Doe"
+	})).Return(true, nil)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("AddRefundedAmount", mock.Anything, "rev-1", 500.0).Return(nil)
+	useRefundMocks(t, new(MockInvoiceRepository), new(MockOrderRepository), mockReversalRepo, mockRefundRepo)
+
+	w := postReversalCallback(HandleB2CResult, b2cResult("AG_B2C_1", 0))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Callback received")
+	mockRefundRepo.AssertExpectations(t)
+	mockReversalRepo.AssertExpectations(t)
+}
+
+func TestHandleB2CResult_DuplicateIsAcknowledged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundByConversationID", mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(&models.Refund{ID: "refund-1", ReversalID: "rev-1", Amount: 500, Status: models.RefundStatusPaid}, nil)
+	mockRefundRepo.On("TransitionRefundStatus", mock.Anything, "refund-1", models.RefundStatusPending, models.RefundStatusPaid, mock.Anything).Return(false, nil)
+
+	mockReversalRepo := new(MockReversalRepository)
+	useRefundMocks(t, new(MockInvoiceRepository), new(MockOrderRepository), mockReversalRepo, mockRefundRepo)
+
+	w := postReversalCallback(HandleB2CResult, b2cResult("AG_B2C_1", 0))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Callback already processed")
+	mockReversalRepo.AssertNotCalled(t, "AddRefundedAmount", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleB2CResult_RollsBackWhenCreditFails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundByConversationID", mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(&models.Refund{ID: "refund-1", ReversalID: "rev-1", Amount: 500, Status: models.RefundStatusPending}, nil)
+	mockRefundRepo.On("TransitionRefundStatus", mock.Anything, "refund-1", models.RefundStatusPending, models.RefundStatusPaid, mock.Anything).Return(true, nil)
+	mockRefundRepo.On("TransitionRefundStatus", mock.Anything, "refund-1", models.RefundStatusPaid, models.RefundStatusPending, (*models.RefundResult)(nil)).Return(true, nil)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("AddRefundedAmount", mock.Anything, "rev-1", 500.0).Return(mongo.ErrClientDisconnected)
+	useRefundMocks(t, new(MockInvoiceRepository), new(MockOrderRepository), mockReversalRepo, mockRefundRepo)
+
+	w := postReversalCallback(HandleB2CResult, b2cResult("AG_B2C_1", 0))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockRefundRepo.AssertExpectations(t)
+}
+
+func TestHandleB2CResult_FailureMarksRefundFailed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundByConversationID", mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(&models.Refund{ID: "refund-1", ReversalID: "rev-1", Amount: 500, Status: models.RefundStatusPending}, nil)
+	mockRefundRepo.On("TransitionRefundStatus", mock.Anything, "refund-1", models.RefundStatusPending, models.RefundStatusFailed, mock.MatchedBy(func(r *models.RefundResult) bool {
+		return r.ResultCode == 2001
+	})).Return(true, nil)
+
+	mockReversalRepo := new(MockReversalRepository)
+	useRefundMocks(t, new(MockInvoiceRepository), new(MockOrderRepository), mockReversalRepo, mockRefundRepo)
+
+	w := postReversalCallback(HandleB2CResult, b2cResult("AG_B2C_1", 2001))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockRefundRepo.AssertExpectations(t)
+	mockReversalRepo.AssertNotCalled(t, "AddRefundedAmount", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleB2CTimeout_MarksTimedOut(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockRefundRepo := new(MockRefundRepository)
+	mockRefundRepo.On("GetRefundByConversationID", mock.Anything, "AG_B2C_1", "orig-AG_B2C_1").Return(&models.Refund{ID: "refund-1", Status: models.RefundStatusPending}, nil)
+	mockRefundRepo.On("TransitionRefundStatus", mock.Anything, "refund-1", models.RefundStatusPending, models.RefundStatusTimedOut, mock.Anything).Return(true, nil)
+	useRefundMocks(t, new(MockInvoiceRepository), new(MockOrderRepository), new(MockReversalRepository), mockRefundRepo)
+
+	w := postReversalCallback(HandleB2CTimeout, b2cResult("AG_B2C_1", 1))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockRefundRepo.AssertExpectations(t)
+}
@@ -61,3 +61,56 @@ func AdminGetDailyBreakdown(c *gin.Context) {
 		"data": dailyBreakdown,
 	})
 }
+
+// AdminGetPromotionReport returns redemptions and discounts per promotion for a date range
+// Query parameters: startDate (YYYY-MM-DD), endDate (YYYY-MM-DD)
+func AdminGetPromotionReport(c *gin.Context) {
+	var query models.GetReportsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing or invalid date parameters (startDate, endDate in YYYY-MM-DD format)"})
+		return
+	}
+
+	reportRepo := NewReportRepository
+	if reportRepo == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "report repository not initialized"})
+		return
+	}
+
+	promotions, err := reportRepo.GetPromotionReport(context.Background(), query.StartDate, query.EndDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dateRange": models.DateRange{
+			StartDate: query.StartDate,
+			EndDate:   query.EndDate,
+		},
+		"data": promotions,
+	})
+}
+
+// AdminGetRefundReport reconciles refunds owed to customers against refunds paid out
+func AdminGetRefundReport(c *gin.Context) {
+	var query models.GetReportsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing or invalid date parameters (startDate, endDate in YYYY-MM-DD format)"})
+		return
+	}
+
+	reportRepo := NewReportRepository
+	if reportRepo == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "report repository not initialized"})
+		return
+	}
+
+	report, err := reportRepo.GetRefundReport(context.Background(), query.StartDate, query.EndDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
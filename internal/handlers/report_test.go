@@ -148,3 +148,39 @@ func TestAdminGetDailyBreakdown_Success(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "2026-02-02")
 	mockReportRepo.AssertCalled(t, "GetDailyBreakdown", mock.Anything, startDate, endDate)
 }
+
+func TestAdminGetRefundReport_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	startDate := "2026-02-01"
+	endDate := "2026-02-09"
+
+	mockReportRepo := new(MockReportRepository)
+	mockReportRepo.On("GetRefundReport", mock.Anything, startDate, endDate).Return(&models.RefundReport{
+		DateRange:   models.DateRange{StartDate: startDate, EndDate: endDate},
+		RefundsOwed: 1500.0,
+		RefundsPaid: 1000.0,
+		Outstanding: 500.0,
+		Unsettled: []models.RefundReconciliation{
+			{ReversalID: "rev-2", InvoiceID: "inv-2", Date: "2026-02-03", RefundOwed: 500.0, Outstanding: 500.0},
+		},
+	}, nil)
+
+	oldReportRepo := NewReportRepository
+	NewReportRepository = ReportRepository(mockReportRepo)
+	defer func() {
+		NewReportRepository = oldReportRepo
+	}()
+
+	httpReq := httptest.NewRequest("GET", "/admin/reports/refunds?startDate=2026-02-01&endDate=2026-02-09", nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	AdminGetRefundReport(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"outstanding":500`)
+	assert.Contains(t, w.Body.String(), "rev-2")
+	mockReportRepo.AssertCalled(t, "GetRefundReport", mock.Anything, startDate, endDate)
+}
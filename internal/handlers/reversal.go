@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// HandleReversalResult receives the outcome of an M-Pesa transaction reversal. A
+// successful result completes the pending reversal and adjusts the invoice; any other
+// result marks the reversal failed and leaves the invoice untouched.
+func HandleReversalResult(c *gin.Context) {
+	var result models.MpesaResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		auditRejectedCallback(c, models.CallbackSourceReversal, models.CallbackRejectInvalidBody, "", nil)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid callback"})
+		return
+	}
+
+	body := result.Result
+	rev, err := NewReversalRepository.GetReversalByConversationID(context.Background(), body.ConversationID, body.OriginatorConversationID)
+	if err != nil {
+		auditRejectedCallback(c, models.CallbackSourceReversal, models.CallbackRejectUnknown, body.ConversationID, nil)
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "1", "ResultDesc": "Reversal not found"})
+		return
+	}
+	if reason := callbackTokenHashRejection(rev.CallbackTokenHash, c.Param("token")); reason != "" {
+		rejectCallback(c, models.CallbackSourceReversal, reason, body.ConversationID, nil)
+		return
+	}
+	if !withinReplayWindow(rev.CreatedAt) {
+		rejectCallback(c, models.CallbackSourceReversal, models.CallbackRejectReplayWindow, body.ConversationID, nil)
+		return
+	}
+
+	var settled bool
+	if body.ResultCode == 0 {
+		settled, err = completeReversal(context.Background(), rev, body)
+	} else {
+		settled, err = NewReversalRepository.TransitionReversalStatus(context.Background(), rev.ID, models.ReversalStatusPending, models.ReversalStatusFailed, body.ResultCode, body.ResultDesc, body.TransactionID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"ResultCode": "1", "ResultDesc": "Failed to update reversal"})
+		return
+	}
+
+	// Safaricom retries callbacks; acknowledge repeats without reversing again
+	if !settled {
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback already processed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback received"})
+}
+
+// HandleReversalTimeout receives Safaricom's notice that a reversal request expired in
+// its queue. The reversal is marked timed out and the invoice is left untouched.
+func HandleReversalTimeout(c *gin.Context) {
+	var result models.MpesaResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		auditRejectedCallback(c, models.CallbackSourceReversal, models.CallbackRejectInvalidBody, "", nil)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid callback"})
+		return
+	}
+
+	body := result.Result
+	rev, err := NewReversalRepository.GetReversalByConversationID(context.Background(), body.ConversationID, body.OriginatorConversationID)
+	if err != nil {
+		auditRejectedCallback(c, models.CallbackSourceReversal, models.CallbackRejectUnknown, body.ConversationID, nil)
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "1", "ResultDesc": "Reversal not found"})
+		return
+	}
+	if reason := callbackTokenHashRejection(rev.CallbackTokenHash, c.Param("token")); reason != "" {
+		rejectCallback(c, models.CallbackSourceReversal, reason, body.ConversationID, nil)
+		return
+	}
+	if !withinReplayWindow(rev.CreatedAt) {
+		rejectCallback(c, models.CallbackSourceReversal, models.CallbackRejectReplayWindow, body.ConversationID, nil)
+		return
+	}
+
+	settled, err := NewReversalRepository.TransitionReversalStatus(context.Background(), rev.ID, models.ReversalStatusPending, models.ReversalStatusTimedOut, body.ResultCode, body.ResultDesc, body.TransactionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"ResultCode": "1", "ResultDesc": "Failed to update reversal"})
+		return
+	}
+
+	if !settled {
+		c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback already processed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ResultCode": "0", "ResultDesc": "Callback received"})
+}
+
+// completeReversal marks a pending reversal completed and takes its amount off the
+// invoice in one unit of work. It returns false if the reversal was already settled.
+func completeReversal(ctx context.Context, rev *models.ReversalRecord, body models.MpesaResultBody) (bool, error) {
+	var settled bool
+	err := NewUnitOfWork.Do(ctx, func(ctx context.Context) error {
+		settled = false
+
+		invoice, err := NewInvoiceRepository.GetInvoiceByID(ctx, rev.InvoiceID)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve invoice: %w", err)
+		}
+
+		ok, err := NewReversalRepository.TransitionReversalStatus(ctx, rev.ID, models.ReversalStatusPending, models.ReversalStatusCompleted, body.ResultCode, body.ResultDesc, body.TransactionID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		database.OnRollback(ctx, func() {
+			NewReversalRepository.TransitionReversalStatus(context.Background(), rev.ID, models.ReversalStatusCompleted, models.ReversalStatusPending, 0, "", "")
+		})
+
+		if err := applyReversal(ctx, invoice, rev.Amount, rev.Date); err != nil {
+			return err
+		}
+		settled = true
+		return nil
+	})
+	return settled, err
+}
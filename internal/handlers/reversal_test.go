@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// useReversalMocks swaps in mock invoice, payment and reversal repositories
+func useReversalMocks(t *testing.T, invoiceRepo *MockInvoiceRepository, paymentRepo *MockPaymentRepository, reversalRepo *MockReversalRepository) {
+	oldInvoiceRepo := NewInvoiceRepository
+	oldPaymentRepo := NewPaymentRepository
+	oldReversalRepo := NewReversalRepository
+	NewInvoiceRepository = InvoiceRepository(invoiceRepo)
+	NewPaymentRepository = PaymentRepository(paymentRepo)
+	NewReversalRepository = ReversalRepository(reversalRepo)
+	t.Cleanup(func() {
+		NewInvoiceRepository = oldInvoiceRepo
+		NewPaymentRepository = oldPaymentRepo
+		NewReversalRepository = oldReversalRepo
+	})
+}
+
+// reversalResult builds a Daraja reversal result payload
+func reversalResult(conversationID string, resultCode int) string {
+	body, _ := json.Marshal(models.MpesaResult{Result: models.MpesaResultBody{
+		ResultType:               0,
+		ResultCode:               resultCode,
+		ResultDesc:               "Reversal processed",
+		OriginatorConversationID: "orig-" + conversationID,
+		ConversationID:           conversationID,
+		TransactionID:            "REV123",
+	}})
+	return string(body)
+}
+
+func postReversalCallback(handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest("POST", "/mpesa/reversal", bytes.NewBufferString(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+
+	handler(c)
+	return w
+}
+
+// writeTestPublicKey writes a throwaway RSA public key for SecurityCredential encryption
+func writeTestPublicKey(t *testing.T) string {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal pub failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pub.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}), 0o600); err != nil {
+		t.Fatalf("write pub file: %v", err)
+	}
+	return path
+}
+
+func TestAdminReverseInvoice_MpesaStaysPending(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+		json.NewEncoder(w).Encode(mpesa.ReversalResponse{
+			ConversationID:           "AG_2024",
+			OriginatorConversationID: "orig-AG_2024",
+			ResponseCode:             "0",
+			ResponseDescription:      "Accept the service request successfully.",
+		})
+	}))
+	defer server.Close()
+
+	old := mpesaClient
+	mpesaClient = mpesa.NewClient(mpesa.Config{
+		ConsumerKey:       "key",
+		ConsumerSecret:    "secret",
+		BusinessShortCode: "174379",
+		InitiatorName:     "apiop",
+		InitiatorPassword: "password",
+		PublicKeyPath:     writeTestPublicKey(t),
+		BaseURL:           server.URL,
+	})
+	t.Cleanup(func() { mpesaClient = old })
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", Type: models.InvoiceTypePayable, InvoiceAmount: 500, PaidAmount: 500}, nil)
+
+	mockPaymentRepo := new(MockPaymentRepository)
+	mockPaymentRepo.On("GetPaymentsByInvoiceID", mock.Anything, "inv-1").Return([]*models.PaymentRecord{
+		{ID: "pay-2", Status: "failed"},
+		{ID: "pay-1", Status: "completed", MpesaReceiptNumber: "QKJ1ABC"},
+	}, nil)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("CreateReversalRecord", mock.Anything, mock.MatchedBy(func(rev *models.ReversalRecord) bool {
+		return rev.Status == models.ReversalStatusPending &&
+			rev.TransactionID == "QKJ1ABC" &&
+			rev.ConversationID == "AG_2024" &&
+			rev.OriginatorConversationID == "orig-AG_2024" &&
+			rev.CallbackTokenHash != "" &&
+			rev.Amount == 500
+	})).Return(nil)
+	useReversalMocks(t, mockInvoiceRepo, mockPaymentRepo, mockReversalRepo)
+
+	httpReq := httptest.NewRequest("PUT", "/admin/invoices/inv-1/reverse", bytes.NewBufferString(`{"useMpesa":true,"reason":"Wrong order"}`))
+	httpReq.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httpReq
+	c.Params = gin.Params{gin.Param{Key: "id", Value: "inv-1"}}
+	c.Set("userID", "admin-1")
+
+	AdminReverseInvoice(c)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	mockReversalRepo.AssertExpectations(t)
+	// The invoice is only adjusted once Safaricom confirms the reversal
+	mockInvoiceRepo.AssertNotCalled(t, "ReverseAllPayments", mock.Anything, mock.Anything, mock.Anything)
+	mockPaymentRepo.AssertNotCalled(t, "ReversePaymentsByInvoiceID", mock.Anything, mock.Anything)
+}
+
+func TestHandleReversalResult_SuccessAdjustsInvoice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", Type: models.InvoiceTypePayable, InvoiceAmount: 500, PaidAmount: 500}, nil)
+	mockInvoiceRepo.On("ReversePaymentAmount", mock.Anything, "inv-1", 200.0, "2024-03-01").Return(nil)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_1", "orig-AG_1").Return(&models.ReversalRecord{ID: "rev-1", InvoiceID: "inv-1", Amount: 200, Date: "2024-03-01", Status: models.ReversalStatusPending}, nil)
+	mockReversalRepo.On("TransitionReversalStatus", mock.Anything, "rev-1", models.ReversalStatusPending, models.ReversalStatusCompleted, 0, "Reversal processed", "REV123").Return(true, nil)
+	useReversalMocks(t, mockInvoiceRepo, new(MockPaymentRepository), mockReversalRepo)
+
+	w := postReversalCallback(HandleReversalResult, reversalResult("AG_1", 0))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Callback received")
+	mockInvoiceRepo.AssertExpectations(t)
+	mockReversalRepo.AssertExpectations(t)
+}
+
+func TestHandleReversalResult_DuplicateIsAcknowledged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", Type: models.InvoiceTypePayable, PaidAmount: 300}, nil)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_1", "orig-AG_1").Return(&models.ReversalRecord{ID: "rev-1", InvoiceID: "inv-1", Amount: 200, Status: models.ReversalStatusCompleted}, nil)
+	mockReversalRepo.On("TransitionReversalStatus", mock.Anything, "rev-1", models.ReversalStatusPending, models.ReversalStatusCompleted, 0, "Reversal processed", "REV123").Return(false, nil)
+	useReversalMocks(t, mockInvoiceRepo, new(MockPaymentRepository), mockReversalRepo)
+
+	w := postReversalCallback(HandleReversalResult, reversalResult("AG_1", 0))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Callback already processed")
+	mockInvoiceRepo.AssertNotCalled(t, "ReversePaymentAmount", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleReversalResult_FailureLeavesInvoice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_1", "orig-AG_1").Return(&models.ReversalRecord{ID: "rev-1", InvoiceID: "inv-1", Amount: 200, Status: models.ReversalStatusPending}, nil)
+	mockReversalRepo.On("TransitionReversalStatus", mock.Anything, "rev-1", models.ReversalStatusPending, models.ReversalStatusFailed, 2001, "Reversal processed", "REV123").Return(true, nil)
+	useReversalMocks(t, mockInvoiceRepo, new(MockPaymentRepository), mockReversalRepo)
+
+	w := postReversalCallback(HandleReversalResult, reversalResult("AG_1", 2001))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockReversalRepo.AssertExpectations(t)
+	mockInvoiceRepo.AssertNotCalled(t, "GetInvoiceByID", mock.Anything, mock.Anything)
+}
+
+func TestHandleReversalResult_RollsBackWhenInvoiceUpdateFails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockInvoiceRepo := new(MockInvoiceRepository)
+	mockInvoiceRepo.On("GetInvoiceByID", mock.Anything, "inv-1").Return(&models.Invoice{ID: "inv-1", Type: models.InvoiceTypePayable, PaidAmount: 500}, nil)
+	mockInvoiceRepo.On("ReversePaymentAmount", mock.Anything, "inv-1", 200.0, mock.Anything).Return(mongo.ErrClientDisconnected)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_1", "orig-AG_1").Return(&models.ReversalRecord{ID: "rev-1", InvoiceID: "inv-1", Amount: 200, Status: models.ReversalStatusPending}, nil)
+	mockReversalRepo.On("TransitionReversalStatus", mock.Anything, "rev-1", models.ReversalStatusPending, models.ReversalStatusCompleted, 0, "Reversal processed", "REV123").Return(true, nil)
+	mockReversalRepo.On("TransitionReversalStatus", mock.Anything, "rev-1", models.ReversalStatusCompleted, models.ReversalStatusPending, 0, "", "").Return(true, nil)
+	useReversalMocks(t, mockInvoiceRepo, new(MockPaymentRepository), mockReversalRepo)
+
+	w := postReversalCallback(HandleReversalResult, reversalResult("AG_1", 0))
+
+	// A 500 makes Safaricom retry the callback once the reversal is pending again
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockReversalRepo.AssertExpectations(t)
+}
+
+func TestHandleReversalTimeout_MarksTimedOut(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_1", "orig-AG_1").Return(&models.ReversalRecord{ID: "rev-1", InvoiceID: "inv-1", Amount: 200, Status: models.ReversalStatusPending}, nil)
+	mockReversalRepo.On("TransitionReversalStatus", mock.Anything, "rev-1", models.ReversalStatusPending, models.ReversalStatusTimedOut, 1, "Reversal processed", "REV123").Return(true, nil)
+	useReversalMocks(t, new(MockInvoiceRepository), new(MockPaymentRepository), mockReversalRepo)
+
+	w := postReversalCallback(HandleReversalTimeout, reversalResult("AG_1", 1))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockReversalRepo.AssertExpectations(t)
+}
+
+func TestHandleReversalResult_UnknownReversal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockReversalRepo := new(MockReversalRepository)
+	mockReversalRepo.On("GetReversalByConversationID", mock.Anything, "AG_9", "orig-AG_9").Return(nil, mongo.ErrNoDocuments)
+	useReversalMocks(t, new(MockInvoiceRepository), new(MockPaymentRepository), mockReversalRepo)
+
+	w := postReversalCallback(HandleReversalResult, reversalResult("AG_9", 0))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Reversal not found")
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// roleIDPattern is what a custom role may be called, e.g. "night-shift"
+var roleIDPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+// InitRoles creates the built-in roles that do not exist yet. Roles already in
+// the database are left as they are, so edits made through the API are kept.
+func InitRoles() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return NewRoleRepository.EnsureRoles(ctx, models.DefaultRoles())
+}
+
+// currentRoles loads the roles of the user making the request, once per request
+func currentRoles(c *gin.Context) ([]*models.Role, error) {
+	if value, ok := c.Get("userRoles"); ok {
+		return value.([]*models.Role), nil
+	}
+
+	ctx := c.Request.Context()
+	user, err := NewUserRepository.FindUserByID(ctx, c.GetString("userID"))
+	if err != nil {
+		return nil, err
+	}
+	roles, err := NewRoleRepository.GetRoles(ctx, user.RoleNames())
+	if err != nil {
+		return nil, err
+	}
+	c.Set("userRoles", roles)
+	return roles, nil
+}
+
+// hasPermission reports whether one of the roles of the user making the request
+// grants permission
+func hasPermission(c *gin.Context, permission string) (bool, error) {
+	roles, err := currentRoles(c)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, role := range roles {
+		if role.HasPermission(permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RequirePermission lets through users one of whose roles grants permission. Roles
+// are read from the database on every request, so a change to a role or to a
+// user's roles applies at once. Use it after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, err := hasPermission(c, permission)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve permissions"})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions", "permission": permission})
+			return
+		}
+		c.Next()
+	}
+}
+
+// validatePermissions checks that every permission is one a role can be given
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if permission != models.PermissionAll && !slices.Contains(models.Permissions, permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+	return nil
+}
+
+// AdminListRoles lists every role, and the permissions roles can be given (admin)
+func AdminListRoles(c *gin.Context) {
+	roles, err := NewRoleRepository.ListRoles(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": roles, "permissions": models.Permissions})
+}
+
+// AdminCreateRole adds a custom role (admin)
+func AdminCreateRole(c *gin.Context) {
+	var req models.CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !roleIDPattern.MatchString(req.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role id must be 2-32 lowercase letters, digits, '-' or '_', starting with a letter"})
+		return
+	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := &models.Role{ID: req.ID, Description: req.Description, Permissions: req.Permissions}
+	if err := NewRoleRepository.CreateRole(c.Request.Context(), role); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a role with this id already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create role"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// AdminUpdateRole replaces a role's description and permissions. The admin role
+// cannot be changed, so there is always a role that can undo a mistake (admin).
+func AdminUpdateRole(c *gin.Context) {
+	roleID := c.Param("id")
+	if roleID == models.RoleAdmin {
+		c.JSON(http.StatusConflict, gin.H{"error": "the admin role cannot be changed"})
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	found, err := NewRoleRepository.UpdateRole(ctx, roleID, req.Description, req.Permissions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update role"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+		return
+	}
+
+	role, err := NewRoleRepository.GetRole(ctx, roleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve role"})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// AdminDeleteRole removes a custom role that no user has (admin)
+func AdminDeleteRole(c *gin.Context) {
+	ctx := c.Request.Context()
+	role, err := NewRoleRepository.GetRole(ctx, c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve role"})
+		return
+	}
+	if role.BuiltIn {
+		c.JSON(http.StatusConflict, gin.H{"error": "built-in roles cannot be deleted"})
+		return
+	}
+
+	users, err := NewUserRepository.CountUsersWithRole(ctx, role.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check role members"})
+		return
+	}
+	if users > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%d users still have this role", users)})
+		return
+	}
+
+	deleted, err := NewRoleRepository.DeleteRole(ctx, role.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete role"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "role deleted"})
+}
+
+// AdminSetUserRoles replaces a user's roles (admin). The last admin cannot give
+// up the admin role.
+func AdminSetUserRoles(c *gin.Context) {
+	var req models.SetUserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var roleIDs []string
+	for _, id := range req.Roles {
+		if !slices.Contains(roleIDs, id) {
+			roleIDs = append(roleIDs, id)
+		}
+	}
+
+	ctx := c.Request.Context()
+	user, err := NewUserRepository.FindUserByID(ctx, c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+		return
+	}
+
+	roles, err := NewRoleRepository.GetRoles(ctx, roleIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve roles"})
+		return
+	}
+	for _, id := range roleIDs {
+		if !slices.ContainsFunc(roles, func(r *models.Role) bool { return r.ID == id }) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown role %q", id)})
+			return
+		}
+	}
+
+	if slices.Contains(user.RoleNames(), models.RoleAdmin) && !slices.Contains(roleIDs, models.RoleAdmin) {
+		admins, err := NewUserRepository.CountUsersWithRole(ctx, models.RoleAdmin)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check admins"})
+			return
+		}
+		if admins <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot remove the admin role from the last admin"})
+			return
+		}
+	}
+
+	if err := NewUserRepository.SetUserRoles(ctx, user.ID, roleIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update roles"})
+		return
+	}
+	recordAuthEvent(ctx, &models.AuthEvent{
+		ID:      uuid.New().String(),
+		Type:    models.AuthEventRolesChanged,
+		UserID:  user.ID,
+		Email:   user.Email,
+		ActorID: c.GetString("userID"),
+		Roles:   roleIDs,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "roles updated", "roles": roleIDs})
+}
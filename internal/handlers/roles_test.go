@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func useRoleRepo(t *testing.T) *MockRoleRepository {
+	old := NewRoleRepository
+	roles := new(MockRoleRepository)
+	NewRoleRepository = roles
+	t.Cleanup(func() { NewRoleRepository = old })
+	return roles
+}
+
+// defaultRole returns one of the built-in roles as seeded
+func defaultRole(id string) *models.Role {
+	for _, role := range models.DefaultRoles() {
+		if role.ID == id {
+			role.BuiltIn = true
+			return role
+		}
+	}
+	return nil
+}
+
+// asAdmin serves one request to handler mounted at pattern, signed in as admin-1
+func asAdmin(method, pattern, path string, handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, pattern, func(c *gin.Context) {
+		c.Set("userID", "admin-1")
+	}, handler)
+
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	roles := useRoleRepo(t)
+
+	users.On("FindUserByID", mock.Anything, "packer").Return(&models.User{ID: "packer", Roles: []string{"user", "warehouse"}}, nil)
+	users.On("FindUserByID", mock.Anything, "accountant").Return(&models.User{ID: "accountant", Roles: []string{"support", "finance"}}, nil)
+	users.On("FindUserByID", mock.Anything, "old-admin").Return(&models.User{ID: "old-admin", Role: "admin"}, nil)
+	users.On("FindUserByID", mock.Anything, "gone").Return(nil, mongo.ErrNoDocuments)
+	roles.On("GetRoles", mock.Anything, []string{"user", "warehouse"}).Return([]*models.Role{defaultRole("user"), defaultRole("warehouse")}, nil)
+	roles.On("GetRoles", mock.Anything, []string{"support", "finance"}).Return([]*models.Role{defaultRole("support"), defaultRole("finance")}, nil)
+	roles.On("GetRoles", mock.Anything, []string{"admin"}).Return([]*models.Role{defaultRole("admin")}, nil)
+
+	request := func(userID, permission string) int {
+		router := gin.New()
+		router.GET("/admin", func(c *gin.Context) {
+			c.Set("userID", userID)
+		}, RequirePermission(permission), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+		return w.Code
+	}
+
+	// Warehouse staff can move orders along but not reverse invoices
+	assert.Equal(t, http.StatusOK, request("packer", models.PermissionOrdersWrite))
+	assert.Equal(t, http.StatusForbidden, request("packer", models.PermissionInvoicesReverse))
+
+	// Permissions of all of a user's roles add up
+	assert.Equal(t, http.StatusOK, request("accountant", models.PermissionUsersWrite))
+	assert.Equal(t, http.StatusOK, request("accountant", models.PermissionInvoicesReverse))
+	assert.Equal(t, http.StatusForbidden, request("accountant", models.PermissionRolesManage))
+
+	// Users from before roles could be combined keep their single role
+	assert.Equal(t, http.StatusOK, request("old-admin", models.PermissionRolesManage))
+
+	assert.Equal(t, http.StatusForbidden, request("gone", models.PermissionOrdersRead))
+}
+
+func TestRequirePermission_LoadsRolesOncePerRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	roles := useRoleRepo(t)
+	users.On("FindUserByID", mock.Anything, "packer").Return(&models.User{ID: "packer", Roles: []string{"warehouse"}}, nil).Once()
+	roles.On("GetRoles", mock.Anything, []string{"warehouse"}).Return([]*models.Role{defaultRole("warehouse")}, nil).Once()
+
+	router := gin.New()
+	router.GET("/admin", func(c *gin.Context) {
+		c.Set("userID", "packer")
+	}, RequirePermission(models.PermissionOrdersRead), RequirePermission(models.PermissionOrdersWrite), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	users.AssertExpectations(t)
+	roles.AssertExpectations(t)
+}
+
+func TestAdminCreateRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	roles := useRoleRepo(t)
+
+	w := asAdmin("POST", "/roles", "/roles", AdminCreateRole, models.CreateRoleRequest{ID: "Night Shift", Permissions: []string{}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = asAdmin("POST", "/roles", "/roles", AdminCreateRole, models.CreateRoleRequest{ID: "night-shift", Permissions: []string{"orders:delete"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "orders:delete")
+
+	roles.On("CreateRole", mock.Anything, mock.MatchedBy(func(r *models.Role) bool {
+		return r.ID == "night-shift" && len(r.Permissions) == 1 && !r.BuiltIn
+	})).Return(nil).Once()
+	w = asAdmin("POST", "/roles", "/roles", AdminCreateRole, models.CreateRoleRequest{ID: "night-shift", Permissions: []string{models.PermissionOrdersRead}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	duplicate := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "duplicate key"}}}
+	roles.On("CreateRole", mock.Anything, mock.Anything).Return(duplicate).Once()
+	w = asAdmin("POST", "/roles", "/roles", AdminCreateRole, models.CreateRoleRequest{ID: "night-shift", Permissions: []string{models.PermissionOrdersRead}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAdminUpdateRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	roles := useRoleRepo(t)
+
+	// admin always keeps every permission
+	w := asAdmin("PUT", "/roles/:id", "/roles/admin", AdminUpdateRole, models.UpdateRoleRequest{Permissions: []string{}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	permissions := []string{models.PermissionOrdersRead, models.PermissionOrdersWrite, models.PermissionProductsWrite}
+	updated := &models.Role{ID: "warehouse", Description: "Warehouse", Permissions: permissions, BuiltIn: true}
+	roles.On("UpdateRole", mock.Anything, "warehouse", "Warehouse", permissions).Return(true, nil)
+	roles.On("GetRole", mock.Anything, "warehouse").Return(updated, nil)
+	w = asAdmin("PUT", "/roles/:id", "/roles/warehouse", AdminUpdateRole, models.UpdateRoleRequest{Description: "Warehouse", Permissions: permissions})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	roles.On("UpdateRole", mock.Anything, "missing", "", []string{}).Return(false, nil)
+	w = asAdmin("PUT", "/roles/:id", "/roles/missing", AdminUpdateRole, models.UpdateRoleRequest{Permissions: []string{}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminDeleteRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	roles := useRoleRepo(t)
+	users := useUserRepo(t)
+
+	roles.On("GetRole", mock.Anything, "finance").Return(defaultRole("finance"), nil)
+	w := asAdmin("DELETE", "/roles/:id", "/roles/finance", AdminDeleteRole, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	roles.On("GetRole", mock.Anything, "packer").Return(&models.Role{ID: "packer"}, nil)
+	users.On("CountUsersWithRole", mock.Anything, "packer").Return(int64(2), nil).Once()
+	w = asAdmin("DELETE", "/roles/:id", "/roles/packer", AdminDeleteRole, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	users.On("CountUsersWithRole", mock.Anything, "packer").Return(int64(0), nil).Once()
+	roles.On("DeleteRole", mock.Anything, "packer").Return(true, nil)
+	w = asAdmin("DELETE", "/roles/:id", "/roles/packer", AdminDeleteRole, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	roles.AssertExpectations(t)
+
+	roles.On("GetRole", mock.Anything, "missing").Return(nil, mongo.ErrNoDocuments)
+	w = asAdmin("DELETE", "/roles/:id", "/roles/missing", AdminDeleteRole, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminSetUserRoles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, events := useLoginThrottles(t)
+	roles := useRoleRepo(t)
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1", Email: "jane@example.com", Roles: []string{"user"}}, nil)
+
+	roles.On("GetRoles", mock.Anything, []string{"user", "warehouse", "cook"}).Return([]*models.Role{defaultRole("user"), defaultRole("warehouse")}, nil)
+	w := asAdmin("PUT", "/users/:id/roles", "/users/user-1/roles", AdminSetUserRoles, models.SetUserRolesRequest{Roles: []string{"user", "warehouse", "cook"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "cook")
+
+	// Repeated roles are stored once
+	roles.On("GetRoles", mock.Anything, []string{"warehouse", "user"}).Return([]*models.Role{defaultRole("user"), defaultRole("warehouse")}, nil)
+	users.On("SetUserRoles", mock.Anything, "user-1", []string{"warehouse", "user"}).Return(nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventRolesChanged && e.UserID == "user-1" && e.ActorID == "admin-1" && len(e.Roles) == 2
+	})).Return(nil)
+	w = asAdmin("PUT", "/users/:id/roles", "/users/user-1/roles", AdminSetUserRoles, models.SetUserRolesRequest{Roles: []string{"warehouse", "user", "warehouse"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	users.AssertExpectations(t)
+	events.AssertExpectations(t)
+
+	w = asAdmin("PUT", "/users/:id/roles", "/users/user-1/roles", AdminSetUserRoles, models.SetUserRolesRequest{Roles: []string{}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminSetUserRoles_KeepsLastAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	roles := useRoleRepo(t)
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "admin-1").Return(&models.User{ID: "admin-1", Role: "admin"}, nil)
+	roles.On("GetRoles", mock.Anything, []string{"finance"}).Return([]*models.Role{defaultRole("finance")}, nil)
+	users.On("CountUsersWithRole", mock.Anything, "admin").Return(int64(1), nil)
+
+	w := asAdmin("PUT", "/users/:id/roles", "/users/admin-1/roles", AdminSetUserRoles, models.SetUserRolesRequest{Roles: []string{"finance"}})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	users.AssertNotCalled(t, "SetUserRoles", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestInitRoles(t *testing.T) {
+	roles := useRoleRepo(t)
+	roles.On("EnsureRoles", mock.Anything, mock.MatchedBy(func(defaults []*models.Role) bool {
+		ids := map[string]bool{}
+		for _, role := range defaults {
+			ids[role.ID] = true
+		}
+		return ids["admin"] && ids["support"] && ids["warehouse"] && ids["finance"] && ids["user"]
+	})).Return(nil)
+
+	assert.NoError(t, InitRoles())
+	roles.AssertExpectations(t)
+}
+
+func TestRoleHasPermission(t *testing.T) {
+	assert.True(t, defaultRole("admin").HasPermission("anything:new"))
+	assert.False(t, defaultRole("user").HasPermission(models.PermissionOrdersRead))
+	assert.True(t, (&models.User{Roles: []string{"user", "finance"}}).IsStaff())
+	assert.False(t, (&models.User{Role: "user"}).IsStaff())
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// sessionConfig controls how long tokens last. Access tokens are short-lived and
+// renewed with the refresh token, which is replaced on every use; a session ends
+// when its refresh token goes unused for refreshTTL.
+type sessionConfig struct {
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+var sessionSettings = sessionConfig{
+	accessTTL:  15 * time.Minute,
+	refreshTTL: 30 * 24 * time.Hour,
+}
+
+// maxSessionLabel caps the device name and user agent stored on a session
+const maxSessionLabel = 256
+
+// InitSessions loads token lifetimes from the environment: ACCESS_TOKEN_TTL
+// (default 15m) and REFRESH_TOKEN_TTL (default 720h).
+func InitSessions() error {
+	config := sessionSettings
+	for _, setting := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"ACCESS_TOKEN_TTL", &config.accessTTL},
+		{"REFRESH_TOKEN_TTL", &config.refreshTTL},
+	} {
+		raw := os.Getenv(setting.name)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", setting.name, raw)
+		}
+		*setting.value = d
+	}
+	if config.refreshTTL < config.accessTTL {
+		return fmt.Errorf("REFRESH_TOKEN_TTL must not be shorter than ACCESS_TOKEN_TTL")
+	}
+
+	sessionSettings = config
+	return nil
+}
+
+// startSession opens a session for a user who has just signed in and issues its
+// access and refresh tokens. methods records how they signed in; the session's
+// access tokens carry it for the rest of the session.
+func startSession(c *gin.Context, user *models.User, device string, methods ...string) (*models.AuthResponse, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:          uuid.New().String(),
+		UserID:      user.ID,
+		AuthMethods: methods,
+		Device:      truncateLabel(strings.TrimSpace(device)),
+		UserAgent:   truncateLabel(c.Request.UserAgent()),
+		IP:          c.ClientIP(),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(sessionSettings.refreshTTL),
+	}
+
+	refreshToken, refreshHash, err := newRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshTokenHash = refreshHash
+
+	token, claims, err := auth.GenerateSessionToken(user.ID, user.Email, user.RoleNames(), session.ID, session.AuthMethods, sessionSettings.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	session.AccessTokenID = claims.ID
+	session.AccessExpiresAt = claims.ExpiresAt.Time
+
+	if err := NewSessionRepository.CreateSession(c.Request.Context(), session); err != nil {
+		return nil, err
+	}
+
+	return &models.AuthResponse{
+		Token:            token,
+		User:             user,
+		ExpiresAt:        claims.ExpiresAt.Unix(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt.Unix(),
+		SessionID:        session.ID,
+	}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once: presenting one that has already
+// been exchanged means it was copied, so the session is revoked.
+func RefreshSession(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	sessionRepo := NewSessionRepository
+
+	sessionID, _, ok := strings.Cut(req.RefreshToken, ".")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+
+	session, err := sessionRepo.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve session"})
+		return
+	}
+
+	presentedHash := hashRefreshToken(req.RefreshToken)
+	if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(session.RefreshTokenHash)) != 1 {
+		if containsHash(session.PreviousRefreshHashes, presentedHash) {
+			refreshTokenReused(c, session)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+
+	if !session.Active() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session has expired or been revoked"})
+		return
+	}
+
+	user, err := NewUserRepository.FindUserByID(ctx, session.UserID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+		return
+	}
+	if user.Deleted() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+	if refuseSuspended(c, user) {
+		return
+	}
+
+	refreshToken, refreshHash, err := newRefreshToken(session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+	token, claims, err := auth.GenerateSessionToken(user.ID, user.Email, user.RoleNames(), session.ID, session.AuthMethods, sessionSettings.accessTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	// Retire the access token being replaced first, so a session only ever has one
+	// live access token and revoking it needs to blacklist just that one
+	if err := blacklistAccessToken(ctx, session.AccessTokenID, session.AccessExpiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh session"})
+		return
+	}
+
+	rotation := &models.SessionRotation{
+		RefreshTokenHash: refreshHash,
+		AccessTokenID:    claims.ID,
+		AccessExpiresAt:  claims.ExpiresAt.Time,
+		ExpiresAt:        time.Now().Add(sessionSettings.refreshTTL),
+		UserAgent:        truncateLabel(c.Request.UserAgent()),
+		IP:               c.ClientIP(),
+	}
+	rotated, err := sessionRepo.RotateRefreshToken(ctx, session.ID, presentedHash, rotation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh session"})
+		return
+	}
+	if !rotated {
+		// Another request exchanged the same refresh token first
+		refreshTokenReused(c, session)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.AuthResponse{
+		Token:            token,
+		User:             user,
+		ExpiresAt:        claims.ExpiresAt.Unix(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: rotation.ExpiresAt.Unix(),
+		SessionID:        session.ID,
+	})
+}
+
+// refreshTokenReused revokes a session whose refresh token was presented after
+// being exchanged. Either the client or whoever copied the token is about to lose
+// access; the user signs in again to start a new session.
+func refreshTokenReused(c *gin.Context, session *models.Session) {
+	log.Printf("Refresh token reuse on session %s of user %s from %s; revoking session", session.ID, session.UserID, c.ClientIP())
+	if err := revokeSession(c.Request.Context(), session.ID, models.SessionRevokedReuse); err != nil {
+		log.Printf("Failed to revoke session %s after refresh token reuse: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh session"})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has already been used; session revoked"})
+}
+
+// ListSessions returns the current user's active sessions
+func ListSessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	sessions, err := NewSessionRepository.ListActiveSessions(c.Request.Context(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve sessions"})
+		return
+	}
+
+	currentID := c.GetString("sessionID")
+	for _, session := range sessions {
+		session.Current = currentID != "" && session.ID == currentID
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeSession signs one of the current user's sessions out
+func RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	session, err := NewSessionRepository.GetSessionByID(ctx, c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve session"})
+		return
+	}
+
+	// Other users' sessions are reported as missing rather than forbidden
+	if session.UserID != userID.(string) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if err := revokeSession(ctx, session.ID, models.SessionRevokedByUser); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeAllSessions signs the current user out everywhere, or everywhere else
+// with ?exceptCurrent=true
+func RevokeAllSessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	except := ""
+	if c.Query("exceptCurrent") == "true" {
+		except = c.GetString("sessionID")
+	}
+
+	revoked, err := revokeUserSessions(c.Request.Context(), userID.(string), except, models.SessionRevokedByUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "sessions revoked", "revoked": revoked})
+}
+
+// revokeUserSessions revokes every active session of a user except the one with
+// ID except, and returns how many were revoked
+func revokeUserSessions(ctx context.Context, userID, except, reason string) (int, error) {
+	sessions, err := NewSessionRepository.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == except {
+			continue
+		}
+		if err := revokeSession(ctx, session.ID, reason); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// revokeSession ends a session: its refresh token stops working and its access
+// token is blacklisted. Access tokens it issued earlier were blacklisted when they
+// were replaced.
+func revokeSession(ctx context.Context, sessionID, reason string) error {
+	sessionRepo := NewSessionRepository
+	if _, err := sessionRepo.RevokeSession(ctx, sessionID, reason); err != nil {
+		return err
+	}
+
+	// Read the session back: a refresh that raced with the revoke may have issued a
+	// newer access token, and none can be issued from here on
+	session, err := sessionRepo.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	return blacklistAccessToken(ctx, session.AccessTokenID, session.AccessExpiresAt)
+}
+
+// blacklistAccessToken revokes an access token by its ID until it expires
+func blacklistAccessToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if tokenID == "" || !expiresAt.After(time.Now()) {
+		return nil
+	}
+	return NewTokenRepository.BlacklistToken(ctx, tokenID, expiresAt)
+}
+
+// newRefreshToken creates a refresh token for a session and the hash stored in its
+// place. The session ID prefix lets a refresh find its session, and a reused token
+// be traced to the session it was stolen from.
+func newRefreshToken(sessionID string) (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token = sessionID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func containsHash(hashes []string, hash string) bool {
+	for _, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateLabel(s string) string {
+	if len(s) > maxSessionLabel {
+		return s[:maxSessionLabel]
+	}
+	return s
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// useSessionRepos swaps in session and token blacklist mocks for one test
+func useSessionRepos(t *testing.T) (*MockSessionRepository, *MockTokenRepository) {
+	oldSessions, oldTokens := NewSessionRepository, NewTokenRepository
+	sessions, tokens := new(MockSessionRepository), new(MockTokenRepository)
+	NewSessionRepository, NewTokenRepository = sessions, tokens
+	t.Cleanup(func() { NewSessionRepository, NewTokenRepository = oldSessions, oldTokens })
+	return sessions, tokens
+}
+
+// activeSession returns a session whose current refresh token is returned too
+func activeSession(t *testing.T, userID string) (*models.Session, string) {
+	sessionID := "session-" + userID
+	token, hash, err := newRefreshToken(sessionID)
+	assert.NoError(t, err)
+	now := time.Now()
+	return &models.Session{
+		ID:               sessionID,
+		UserID:           userID,
+		RefreshTokenHash: hash,
+		AccessTokenID:    "jti-old",
+		AccessExpiresAt:  now.Add(10 * time.Minute),
+		CreatedAt:        now.Add(-time.Hour),
+		LastUsedAt:       now.Add(-time.Hour),
+		ExpiresAt:        now.Add(24 * time.Hour),
+	}, token
+}
+
+func postRefresh(refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.RefreshRequest{RefreshToken: refreshToken})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/auth/refresh", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	RefreshSession(c)
+	return w
+}
+
+func TestRefreshSession_RotatesTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	user := &models.User{ID: "user-1", Email: "test@example.com", Role: "user"}
+	session, refreshToken := activeSession(t, user.ID)
+
+	sessionRepo, tokenRepo := useSessionRepos(t)
+	sessionRepo.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+	sessionRepo.On("RotateRefreshToken", mock.Anything, session.ID, hashRefreshToken(refreshToken), mock.MatchedBy(func(r *models.SessionRotation) bool {
+		return r.RefreshTokenHash != session.RefreshTokenHash && r.AccessTokenID != "" && r.AccessTokenID != "jti-old"
+	})).Return(true, nil)
+	// The replaced access token stops working straight away
+	tokenRepo.On("BlacklistToken", mock.Anything, "jti-old", session.AccessExpiresAt).Return(nil)
+
+	mockUserRepo := new(MockUserRepository)
+	mockUserRepo.On("FindUserByID", mock.Anything, user.ID).Return(user, nil)
+	oldUserRepo := NewUserRepository
+	NewUserRepository = mockUserRepo
+	defer func() { NewUserRepository = oldUserRepo }()
+
+	w := postRefresh(refreshToken)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.AuthResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NotEmpty(t, response.Token)
+	assert.NotEmpty(t, response.RefreshToken)
+	assert.NotEqual(t, refreshToken, response.RefreshToken)
+	assert.Equal(t, session.ID, response.SessionID)
+
+	sessionRepo.AssertExpectations(t)
+	tokenRepo.AssertExpectations(t)
+}
+
+func TestRefreshSession_ReusedTokenRevokesSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	session, oldRefreshToken := activeSession(t, "user-1")
+	// The token has since been exchanged for a new one
+	session.PreviousRefreshHashes = []string{hashRefreshToken(oldRefreshToken)}
+	_, session.RefreshTokenHash, _ = newRefreshToken(session.ID)
+
+	sessionRepo, tokenRepo := useSessionRepos(t)
+	sessionRepo.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+	sessionRepo.On("RevokeSession", mock.Anything, session.ID, models.SessionRevokedReuse).Return(true, nil)
+	tokenRepo.On("BlacklistToken", mock.Anything, "jti-old", session.AccessExpiresAt).Return(nil)
+
+	w := postRefresh(oldRefreshToken)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "session revoked")
+	sessionRepo.AssertExpectations(t)
+	tokenRepo.AssertExpectations(t)
+	sessionRepo.AssertNotCalled(t, "RotateRefreshToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRefreshSession_ConcurrentReuseRevokesSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	user := &models.User{ID: "user-1", Email: "test@example.com", Role: "user"}
+	session, refreshToken := activeSession(t, user.ID)
+
+	sessionRepo, tokenRepo := useSessionRepos(t)
+	sessionRepo.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+	// Another request exchanged the same token between our read and our rotation
+	sessionRepo.On("RotateRefreshToken", mock.Anything, session.ID, mock.Anything, mock.Anything).Return(false, nil)
+	sessionRepo.On("RevokeSession", mock.Anything, session.ID, models.SessionRevokedReuse).Return(true, nil)
+	tokenRepo.On("BlacklistToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockUserRepo := new(MockUserRepository)
+	mockUserRepo.On("FindUserByID", mock.Anything, user.ID).Return(user, nil)
+	oldUserRepo := NewUserRepository
+	NewUserRepository = mockUserRepo
+	defer func() { NewUserRepository = oldUserRepo }()
+
+	w := postRefresh(refreshToken)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	sessionRepo.AssertExpectations(t)
+}
+
+func TestRefreshSession_UnknownTokenLeavesSessionAlone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	session, _ := activeSession(t, "user-1")
+	guessed, _, _ := newRefreshToken(session.ID)
+
+	sessionRepo, _ := useSessionRepos(t)
+	sessionRepo.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+
+	w := postRefresh(guessed)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	sessionRepo.AssertNotCalled(t, "RevokeSession", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRefreshSession_RevokedSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	session, refreshToken := activeSession(t, "user-1")
+	revokedAt := time.Now().Add(-time.Minute)
+	session.RevokedAt = &revokedAt
+
+	sessionRepo, _ := useSessionRepos(t)
+	sessionRepo.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+
+	w := postRefresh(refreshToken)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	sessionRepo.AssertNotCalled(t, "RotateRefreshToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRefreshSession_MalformedToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sessionRepo, _ := useSessionRepos(t)
+	sessionRepo.On("GetSessionByID", mock.Anything, "nosuchsession").Return(nil, mongo.ErrNoDocuments)
+
+	assert.Equal(t, http.StatusUnauthorized, postRefresh("garbage").Code)
+	assert.Equal(t, http.StatusUnauthorized, postRefresh("nosuchsession.secret").Code)
+	assert.Equal(t, http.StatusBadRequest, postRefresh("").Code)
+}
+
+func TestListSessions_MarksCurrent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	phone, _ := activeSession(t, "user-1")
+	laptop := &models.Session{ID: "session-laptop", UserID: "user-1", Device: "laptop"}
+
+	sessionRepo, _ := useSessionRepos(t)
+	sessionRepo.On("ListActiveSessions", mock.Anything, "user-1").Return([]*models.Session{phone, laptop}, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/auth/sessions", nil)
+	c.Set("userID", "user-1")
+	c.Set("sessionID", "session-laptop")
+
+	ListSessions(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Len(t, response.Data, 2)
+	assert.Equal(t, false, response.Data[0]["current"])
+	assert.Equal(t, true, response.Data[1]["current"])
+	// Token material never leaves the server
+	assert.NotContains(t, w.Body.String(), phone.RefreshTokenHash)
+	assert.NotContains(t, w.Body.String(), "jti-old")
+}
+
+func TestRevokeSession_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	session, _ := activeSession(t, "user-1")
+
+	sessionRepo, tokenRepo := useSessionRepos(t)
+	sessionRepo.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+	sessionRepo.On("RevokeSession", mock.Anything, session.ID, models.SessionRevokedByUser).Return(true, nil)
+	tokenRepo.On("BlacklistToken", mock.Anything, "jti-old", session.AccessExpiresAt).Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("DELETE", "/auth/sessions/"+session.ID, nil)
+	c.Params = gin.Params{{Key: "id", Value: session.ID}}
+	c.Set("userID", "user-1")
+
+	RevokeSession(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	sessionRepo.AssertExpectations(t)
+	tokenRepo.AssertExpectations(t)
+}
+
+func TestRevokeSession_OtherUsersSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	session, _ := activeSession(t, "user-2")
+
+	sessionRepo, _ := useSessionRepos(t)
+	sessionRepo.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("DELETE", "/auth/sessions/"+session.ID, nil)
+	c.Params = gin.Params{{Key: "id", Value: session.ID}}
+	c.Set("userID", "user-1")
+
+	RevokeSession(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	sessionRepo.AssertNotCalled(t, "RevokeSession", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRevokeAllSessions_ExceptCurrent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	current := &models.Session{ID: "session-current", UserID: "user-1"}
+	other := &models.Session{ID: "session-other", UserID: "user-1", AccessTokenID: "jti-other", AccessExpiresAt: time.Now().Add(5 * time.Minute)}
+
+	sessionRepo, tokenRepo := useSessionRepos(t)
+	sessionRepo.On("ListActiveSessions", mock.Anything, "user-1").Return([]*models.Session{current, other}, nil)
+	sessionRepo.On("RevokeSession", mock.Anything, "session-other", models.SessionRevokedByUser).Return(true, nil)
+	sessionRepo.On("GetSessionByID", mock.Anything, "session-other").Return(other, nil)
+	tokenRepo.On("BlacklistToken", mock.Anything, "jti-other", other.AccessExpiresAt).Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("DELETE", "/auth/sessions?exceptCurrent=true", nil)
+	c.Set("userID", "user-1")
+	c.Set("sessionID", "session-current")
+
+	RevokeAllSessions(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"revoked":1`)
+	sessionRepo.AssertExpectations(t)
+	sessionRepo.AssertNotCalled(t, "RevokeSession", mock.Anything, "session-current", mock.Anything)
+	tokenRepo.AssertExpectations(t)
+}
+
+func TestInitSessions(t *testing.T) {
+	old := sessionSettings
+	t.Cleanup(func() { sessionSettings = old })
+
+	t.Setenv("ACCESS_TOKEN_TTL", "5m")
+	t.Setenv("REFRESH_TOKEN_TTL", "168h")
+	assert.NoError(t, InitSessions())
+	assert.Equal(t, 5*time.Minute, sessionSettings.accessTTL)
+	assert.Equal(t, 168*time.Hour, sessionSettings.refreshTTL)
+
+	t.Setenv("ACCESS_TOKEN_TTL", "soon")
+	assert.Error(t, InitSessions())
+
+	t.Setenv("ACCESS_TOKEN_TTL", "48h")
+	t.Setenv("REFRESH_TOKEN_TTL", "1h")
+	assert.Error(t, InitSessions())
+}
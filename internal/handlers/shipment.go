@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// maxDeliveryCodeAttempts wrong delivery codes lock a shipment; it then has to
+	// be failed and its items shipped again, which issues a new code
+	maxDeliveryCodeAttempts = 5
+	// maxSignatureBytes caps the size of a proof-of-delivery signature image
+	maxSignatureBytes = 512 << 10
+)
+
+// errShipmentsChanged aborts a shipment's unit of work when another shipment was
+// created for the order at the same time
+var errShipmentsChanged = errors.New("order shipments changed")
+
+// signatureTypes are the image types accepted as signatures
+var signatureTypes = map[string]bool{"image/png": true, "image/jpeg": true}
+
+// newDeliveryCode returns a random six-digit delivery code
+func newDeliveryCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// shippable reports whether an order in status can still have items shipped
+func shippable(status string) bool {
+	switch status {
+	case models.OrderStatusInQueue, models.OrderStatusProcessing, models.OrderStatusShipped, models.OrderStatusAwaitingPickup:
+		return true
+	}
+	return false
+}
+
+// findShipmentParam loads the shipment named by the :id parameter, answering 404
+// or 500 itself when it cannot
+func findShipmentParam(c *gin.Context) (*models.Shipment, bool) {
+	shipment, err := NewShipmentRepository.GetShipment(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "shipment not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve shipment"})
+		return nil, false
+	}
+	return shipment, true
+}
+
+// findOrderParam loads the order named by the :id parameter, answering 404 or
+// 500 itself when it cannot
+func findOrderParam(c *gin.Context) (*models.Order, bool) {
+	order, err := NewOrderRepository.GetOrderByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve order"})
+		return nil, false
+	}
+	return order, true
+}
+
+// undeliveredItems returns what is left to deliver on an order
+func undeliveredItems(ctx context.Context, order *models.Order) ([]models.ShipmentItem, error) {
+	shipments, err := NewShipmentRepository.ListShipmentsByOrder(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	return models.OutstandingItems(order, shipments, true), nil
+}
+
+// AdminCreateShipment prepares a shipment of some of an order's items, or all of
+// the items not yet shipped. Items of failed shipments can be shipped again. Of
+// shipments created for an order at the same time, only the first goes through.
+func AdminCreateShipment(c *gin.Context) {
+	var req models.CreateShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order, ok := findOrderParam(c)
+	if !ok {
+		return
+	}
+	if !shippable(order.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "order cannot be shipped", "status": order.Status})
+		return
+	}
+
+	ctx := c.Request.Context()
+	shipmentRepo := NewShipmentRepository
+	shipments, err := shipmentRepo.ListShipmentsByOrder(ctx, order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve shipments"})
+		return
+	}
+	unshipped := models.OutstandingItems(order, shipments, false)
+	if len(unshipped) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "every item has already been shipped"})
+		return
+	}
+
+	items := req.Items
+	if len(items) == 0 {
+		items = unshipped
+	} else {
+		left := make(map[string]int)
+		for _, item := range unshipped {
+			left[item.ProductID] = item.Quantity
+		}
+		for _, item := range items {
+			left[item.ProductID] -= item.Quantity
+			if left[item.ProductID] < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "more units than are left to ship", "productId": item.ProductID, "unshipped": unshipped})
+				return
+			}
+		}
+	}
+
+	method := req.Method
+	if method == "" {
+		method = models.ShipmentMethodPickup
+		if order.Delivery != nil {
+			method = models.ShipmentMethodDelivery
+		}
+	}
+	pickupPoint := strings.TrimSpace(req.PickupPoint)
+	if method == models.ShipmentMethodPickup && pickupPoint == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pickupPoint is required for pickup shipments"})
+		return
+	}
+
+	shipment := &models.Shipment{
+		ID:                uuid.New().String(),
+		OrderID:           order.ID,
+		UserID:            order.UserID,
+		Items:             items,
+		Method:            method,
+		Status:            models.ShipmentStatusPreparing,
+		Carrier:           strings.TrimSpace(req.Carrier),
+		RiderName:         strings.TrimSpace(req.RiderName),
+		RiderPhone:        strings.TrimSpace(req.RiderPhone),
+		TrackingReference: strings.TrimSpace(req.TrackingReference),
+		PickupPoint:       pickupPoint,
+		CreatedBy:         c.GetString("userID"),
+	}
+	// Claiming the order's shipment version fails if another shipment was created
+	// since the order was read, whose items may overlap these
+	err = NewUnitOfWork.Do(ctx, func(ctx context.Context) error {
+		if err := shipmentRepo.CreateShipment(ctx, shipment); err != nil {
+			return err
+		}
+		database.OnRollback(ctx, func() { _ = shipmentRepo.DeleteShipment(context.Background(), shipment.ID) })
+
+		ok, err := NewOrderRepository.ClaimShipmentVersion(ctx, order.ID, order.ShipmentVersion)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errShipmentsChanged
+		}
+		return nil
+	})
+	if errors.Is(err, errShipmentsChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": "the order's shipments changed; check what is left to ship and try again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create shipment"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, shipment)
+}
+
+// AdminListOrderShipments lists an order's shipments and what is left to ship
+func AdminListOrderShipments(c *gin.Context) {
+	order, ok := findOrderParam(c)
+	if !ok {
+		return
+	}
+
+	shipments, err := NewShipmentRepository.ListShipmentsByOrder(c.Request.Context(), order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve shipments"})
+		return
+	}
+	if shipments == nil {
+		shipments = []*models.Shipment{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": shipments, "unshipped": models.OutstandingItems(order, shipments, false)})
+}
+
+// AdminUpdateShipment changes the carrier, rider, tracking reference or pickup
+// point of a shipment still on its way
+func AdminUpdateShipment(c *gin.Context) {
+	var req models.UpdateShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"carrier", req.Carrier},
+		{"riderName", req.RiderName},
+		{"riderPhone", req.RiderPhone},
+		{"trackingReference", req.TrackingReference},
+		{"pickupPoint", req.PickupPoint},
+	}
+	for _, field := range fields {
+		if field.value != nil {
+			updates[field.name] = strings.TrimSpace(*field.value)
+		}
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+		return
+	}
+
+	shipment, ok := findShipmentParam(c)
+	if !ok {
+		return
+	}
+	if shipment.Method == models.ShipmentMethodPickup && updates["pickupPoint"] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pickupPoint is required for pickup shipments"})
+		return
+	}
+
+	updated, err := NewShipmentRepository.UpdateShipmentTracking(c.Request.Context(), shipment.ID, updates)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update shipment"})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusConflict, gin.H{"error": "shipment is no longer on its way", "status": shipment.Status})
+		return
+	}
+
+	shipment, ok = findShipmentParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, shipment)
+}
+
+// AdminDispatchShipment hands a prepared shipment to its rider or carrier, or
+// makes it ready at its pickup point, and issues the customer's delivery code.
+// The order moves to shipped or awaiting pick-up when it is still being prepared.
+func AdminDispatchShipment(c *gin.Context) {
+	shipment, ok := findShipmentParam(c)
+	if !ok {
+		return
+	}
+	if shipment.Status != models.ShipmentStatusPreparing {
+		c.JSON(http.StatusConflict, gin.H{"error": "shipment has already been dispatched", "status": shipment.Status})
+		return
+	}
+
+	ctx := c.Request.Context()
+	orderRepo := NewOrderRepository
+	order, err := orderRepo.GetOrderByID(ctx, shipment.OrderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve order"})
+		return
+	}
+	if !shippable(order.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "order cannot be shipped", "status": order.Status})
+		return
+	}
+
+	status, orderStatus := models.ShipmentStatusDispatched, models.OrderStatusShipped
+	if shipment.Method == models.ShipmentMethodPickup {
+		status, orderStatus = models.ShipmentStatusReadyForPickup, models.OrderStatusAwaitingPickup
+	}
+	code, err := newDeliveryCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to dispatch shipment"})
+		return
+	}
+
+	dispatched, err := NewShipmentRepository.DispatchShipment(ctx, shipment.ID, status, code, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to dispatch shipment"})
+		return
+	}
+	if !dispatched {
+		c.JSON(http.StatusConflict, gin.H{"error": "shipment has already been dispatched"})
+		return
+	}
+
+	if order.Status == models.OrderStatusInQueue || order.Status == models.OrderStatusProcessing {
+		if err := orderRepo.UpdateOrderStatus(ctx, order.ID, orderStatus); err != nil {
+			log.Printf("Failed to move order %s to %s after dispatching shipment %s: %v", order.ID, orderStatus, shipment.ID, err)
+		}
+	}
+
+	shipment, ok = findShipmentParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, shipment)
+}
+
+// AdminDeliverShipment records proof of delivery for a dispatched shipment: the
+// recipient's name, their signature image and the delivery code the customer was
+// given. The order is completed once every item has been delivered.
+func AdminDeliverShipment(c *gin.Context) {
+	var req models.DeliverShipmentRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := c.FormFile("signature")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "signature image is required"})
+		return
+	}
+	if file.Size > maxSignatureBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "signature image is too large", "maxBytes": maxSignatureBytes})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read signature image"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxSignatureBytes+1))
+	f.Close()
+	if err != nil || len(data) > maxSignatureBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read signature image"})
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if !signatureTypes[contentType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "signature must be a PNG or JPEG image"})
+		return
+	}
+
+	shipment, ok := findShipmentParam(c)
+	if !ok {
+		return
+	}
+	if shipment.Status != models.ShipmentStatusDispatched && shipment.Status != models.ShipmentStatusReadyForPickup {
+		c.JSON(http.StatusConflict, gin.H{"error": "shipment is not awaiting delivery", "status": shipment.Status})
+		return
+	}
+	if shipment.DeliveryCodeAttempts >= maxDeliveryCodeAttempts {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "too many wrong delivery codes; fail the shipment and ship the items again",
+			"code":  "delivery_code_locked",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	shipmentRepo := NewShipmentRepository
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(req.Code)), []byte(shipment.DeliveryCode)) != 1 {
+		if err := shipmentRepo.RecordDeliveryCodeFailure(ctx, shipment.ID); err != nil {
+			log.Printf("Failed to record wrong delivery code for shipment %s: %v", shipment.ID, err)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delivery code", "attemptsLeft": maxDeliveryCodeAttempts - shipment.DeliveryCodeAttempts - 1})
+		return
+	}
+
+	if err := shipmentRepo.SaveSignature(ctx, &models.DeliverySignature{
+		ShipmentID:  shipment.ID,
+		UserID:      shipment.UserID,
+		ContentType: contentType,
+		Data:        data,
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save signature"})
+		return
+	}
+
+	proof := &models.ProofOfDelivery{
+		RecipientName: strings.TrimSpace(req.RecipientName),
+		SignatureType: contentType,
+		CodeVerified:  true,
+		RecordedBy:    c.GetString("userID"),
+		DeliveredAt:   time.Now(),
+	}
+	delivered, err := shipmentRepo.DeliverShipment(ctx, shipment.ID, proof)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record delivery"})
+		return
+	}
+	if !delivered {
+		c.JSON(http.StatusConflict, gin.H{"error": "shipment is not awaiting delivery"})
+		return
+	}
+
+	// Complete the order once nothing is left to deliver
+	orderComplete := false
+	orderRepo := NewOrderRepository
+	order, err := orderRepo.GetOrderByID(ctx, shipment.OrderID)
+	if err == nil && shippable(order.Status) {
+		var left []models.ShipmentItem
+		left, err = undeliveredItems(ctx, order)
+		if err == nil && len(left) == 0 {
+			err = orderRepo.UpdateOrderStatus(ctx, order.ID, models.OrderStatusComplete)
+			orderComplete = err == nil
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to check completion of order %s after delivering shipment %s: %v", shipment.OrderID, shipment.ID, err)
+	}
+
+	shipment, ok = findShipmentParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"shipment": shipment, "orderComplete": orderComplete})
+}
+
+// AdminFailShipment gives up on a shipment still on its way, e.g. when the
+// customer could not be reached. Its items can then be shipped again.
+func AdminFailShipment(c *gin.Context) {
+	var req models.FailShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	shipment, ok := findShipmentParam(c)
+	if !ok {
+		return
+	}
+
+	failed, err := NewShipmentRepository.FailShipment(c.Request.Context(), shipment.ID, strings.TrimSpace(req.Reason), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update shipment"})
+		return
+	}
+	if !failed {
+		c.JSON(http.StatusConflict, gin.H{"error": "shipment is no longer on its way", "status": shipment.Status})
+		return
+	}
+
+	shipment, ok = findShipmentParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, shipment)
+}
+
+// AdminGetShipmentSignature returns the signature image captured on delivery
+func AdminGetShipmentSignature(c *gin.Context) {
+	signature, err := NewShipmentRepository.GetSignature(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "signature not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve signature"})
+		return
+	}
+
+	c.Data(http.StatusOK, signature.ContentType, signature.Data)
+}
+
+// shipmentTracking is a shipment as its customer sees it, with the delivery code
+// to give on receipt while it is on its way
+type shipmentTracking struct {
+	*models.Shipment
+	DeliveryCode string `json:"deliveryCode,omitempty"`
+}
+
+// TrackOrder returns the shipments of one of the signed-in user's orders, and the
+// items not yet shipped
+func TrackOrder(c *gin.Context) {
+	order, ok := findOrderParam(c)
+	if !ok {
+		return
+	}
+	if order.UserID != c.GetString("userID") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	shipments, err := NewShipmentRepository.ListShipmentsByOrder(c.Request.Context(), order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve shipments"})
+		return
+	}
+
+	tracking := make([]shipmentTracking, 0, len(shipments))
+	for _, shipment := range shipments {
+		copied := *shipment
+		view := shipmentTracking{Shipment: &copied}
+		if shipment.Status == models.ShipmentStatusDispatched || shipment.Status == models.ShipmentStatusReadyForPickup {
+			view.DeliveryCode = shipment.DeliveryCode
+		}
+		if shipment.Proof != nil {
+			proof := *shipment.Proof
+			proof.RecordedBy = ""
+			view.Proof = &proof
+		}
+		view.CreatedBy = ""
+		tracking = append(tracking, view)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orderId":   order.ID,
+		"status":    order.Status,
+		"data":      tracking,
+		"unshipped": models.OutstandingItems(order, shipments, false),
+	})
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// pngSignature is enough of a PNG file to be detected as one
+var pngSignature = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR signature")
+
+func useShipmentRepo(t *testing.T) *MockShipmentRepository {
+	old := NewShipmentRepository
+	shipments := new(MockShipmentRepository)
+	NewShipmentRepository = shipments
+	t.Cleanup(func() { NewShipmentRepository = old })
+	return shipments
+}
+
+func useOrderRepo(t *testing.T) *MockOrderRepository {
+	old := NewOrderRepository
+	orders := new(MockOrderRepository)
+	NewOrderRepository = orders
+	t.Cleanup(func() { NewOrderRepository = old })
+	return orders
+}
+
+// twoProductOrder is order-1 of user-1: two of p1 and one of p2
+func twoProductOrder(status string) *models.Order {
+	return &models.Order{ID: "order-1", UserID: "user-1", Status: status, Products: []models.OrderItem{
+		{ProductID: "p1", Quantity: 2, Price: 100},
+		{ProductID: "p2", Quantity: 1, Price: 50},
+	}}
+}
+
+// deliverForm posts the proof-of-delivery form for shipment-1 as admin-1
+func deliverForm(recipient, code string, signature []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("recipientName", recipient)
+	form.WriteField("code", code)
+	if signature != nil {
+		part, _ := form.CreateFormFile("signature", "signature.png")
+		part.Write(signature)
+	}
+	form.Close()
+
+	router := gin.New()
+	router.POST("/admin/shipments/:id/deliver", func(c *gin.Context) {
+		c.Set("userID", "admin-1")
+	}, AdminDeliverShipment)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/admin/shipments/shipment-1/deliver", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestOutstandingItems(t *testing.T) {
+	order := twoProductOrder(models.OrderStatusShipped)
+	shipments := []*models.Shipment{
+		{Status: models.ShipmentStatusDelivered, Items: []models.ShipmentItem{{ProductID: "p1", Quantity: 1}}},
+		{Status: models.ShipmentStatusDispatched, Items: []models.ShipmentItem{{ProductID: "p1", Quantity: 1}}},
+		{Status: models.ShipmentStatusFailed, Items: []models.ShipmentItem{{ProductID: "p2", Quantity: 1}}},
+	}
+
+	assert.Equal(t, []models.ShipmentItem{{ProductID: "p2", Quantity: 1}}, models.OutstandingItems(order, shipments, false))
+	assert.Equal(t, []models.ShipmentItem{{ProductID: "p1", Quantity: 1}, {ProductID: "p2", Quantity: 1}}, models.OutstandingItems(order, shipments, true))
+}
+
+func TestAdminCreateShipment_Partial(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orders := useOrderRepo(t)
+	shipments := useShipmentRepo(t)
+	order := twoProductOrder(models.OrderStatusProcessing)
+	order.Delivery = &models.OrderDelivery{AddressID: "addr-1"}
+	orders.On("GetOrderByID", mock.Anything, "order-1").Return(order, nil)
+	orders.On("ClaimShipmentVersion", mock.Anything, "order-1", 0).Return(true, nil)
+	shipments.On("ListShipmentsByOrder", mock.Anything, "order-1").Return([]*models.Shipment{
+		{Status: models.ShipmentStatusPreparing, Items: []models.ShipmentItem{{ProductID: "p1", Quantity: 1}}},
+	}, nil)
+	shipments.On("CreateShipment", mock.Anything, mock.MatchedBy(func(s *models.Shipment) bool {
+		return s.UserID == "user-1" && s.Method == models.ShipmentMethodDelivery && s.Status == models.ShipmentStatusPreparing &&
+			s.RiderName == "Otieno" && len(s.Items) == 1 && s.Items[0] == models.ShipmentItem{ProductID: "p1", Quantity: 1}
+	})).Return(nil).Once()
+	shipments.On("CreateShipment", mock.Anything, mock.MatchedBy(func(s *models.Shipment) bool {
+		return len(s.Items) == 2 && s.Items[1] == models.ShipmentItem{ProductID: "p2", Quantity: 1}
+	})).Return(nil).Once()
+
+	w := asAdmin("POST", "/admin/orders/:id/shipments", "/admin/orders/order-1/shipments", AdminCreateShipment, models.CreateShipmentRequest{
+		Items: []models.ShipmentItem{{ProductID: "p1", Quantity: 1}}, RiderName: " Otieno ", RiderPhone: "254700000001",
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// No more than is left of a product, and only the order's products
+	for _, items := range [][]models.ShipmentItem{
+		{{ProductID: "p1", Quantity: 2}},
+		{{ProductID: "p9", Quantity: 1}},
+	} {
+		w = asAdmin("POST", "/admin/orders/:id/shipments", "/admin/orders/order-1/shipments", AdminCreateShipment, models.CreateShipmentRequest{Items: items})
+		assert.Equal(t, http.StatusBadRequest, w.Code, items)
+	}
+
+	// Without items, everything not yet shipped goes
+	w = asAdmin("POST", "/admin/orders/:id/shipments", "/admin/orders/order-1/shipments", AdminCreateShipment, models.CreateShipmentRequest{})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	shipments.AssertExpectations(t)
+}
+
+func TestAdminCreateShipment_Refused(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orders := useOrderRepo(t)
+	shipments := useShipmentRepo(t)
+	orders.On("GetOrderByID", mock.Anything, "order-1").Return(twoProductOrder(models.OrderStatusInQueue), nil)
+	orders.On("GetOrderByID", mock.Anything, "order-2").Return(twoProductOrder(models.OrderStatusCancelled), nil)
+	shipments.On("ListShipmentsByOrder", mock.Anything, "order-1").Return([]*models.Shipment{}, nil)
+
+	// Collected orders need a pickup point
+	w := asAdmin("POST", "/admin/orders/:id/shipments", "/admin/orders/order-1/shipments", AdminCreateShipment, models.CreateShipmentRequest{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = asAdmin("POST", "/admin/orders/:id/shipments", "/admin/orders/order-2/shipments", AdminCreateShipment, models.CreateShipmentRequest{PickupPoint: "Moi Avenue shop"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	shipments.AssertNotCalled(t, "CreateShipment", mock.Anything, mock.Anything)
+}
+
+func TestAdminCreateShipment_ConcurrentShipment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orders := useOrderRepo(t)
+	shipments := useShipmentRepo(t)
+	order := twoProductOrder(models.OrderStatusProcessing)
+	order.ShipmentVersion = 3
+	orders.On("GetOrderByID", mock.Anything, "order-1").Return(order, nil)
+	shipments.On("ListShipmentsByOrder", mock.Anything, "order-1").Return([]*models.Shipment{}, nil)
+
+	// Another admin shipped the order's items after it was read
+	var created string
+	shipments.On("CreateShipment", mock.Anything, mock.MatchedBy(func(s *models.Shipment) bool {
+		created = s.ID
+		return true
+	})).Return(nil)
+	orders.On("ClaimShipmentVersion", mock.Anything, "order-1", 3).Return(false, nil)
+	shipments.On("DeleteShipment", mock.Anything, mock.MatchedBy(func(id string) bool { return id == created })).Return(nil)
+
+	w := asAdmin("POST", "/admin/orders/:id/shipments", "/admin/orders/order-1/shipments", AdminCreateShipment, models.CreateShipmentRequest{PickupPoint: "Moi Avenue shop"})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	orders.AssertExpectations(t)
+	shipments.AssertExpectations(t)
+}
+
+func TestAdminDispatchShipment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orders := useOrderRepo(t)
+	shipments := useShipmentRepo(t)
+	shipment := &models.Shipment{ID: "shipment-1", OrderID: "order-1", Method: models.ShipmentMethodPickup, Status: models.ShipmentStatusPreparing, PickupPoint: "Moi Avenue shop"}
+	shipments.On("GetShipment", mock.Anything, "shipment-1").Return(shipment, nil)
+	orders.On("GetOrderByID", mock.Anything, "order-1").Return(twoProductOrder(models.OrderStatusProcessing), nil)
+	shipments.On("DispatchShipment", mock.Anything, "shipment-1", models.ShipmentStatusReadyForPickup,
+		mock.MatchedBy(regexp.MustCompile(`^\d{6}$`).MatchString), mock.Anything).Return(true, nil)
+	orders.On("UpdateOrderStatus", mock.Anything, "order-1", models.OrderStatusAwaitingPickup).Return(nil)
+
+	w := asAdmin("POST", "/admin/shipments/:id/dispatch", "/admin/shipments/shipment-1/dispatch", AdminDispatchShipment, nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	shipments.AssertExpectations(t)
+	orders.AssertExpectations(t)
+}
+
+func TestAdminDeliverShipment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orders := useOrderRepo(t)
+	shipments := useShipmentRepo(t)
+	shipment := &models.Shipment{ID: "shipment-1", OrderID: "order-1", UserID: "user-1", Status: models.ShipmentStatusDispatched, DeliveryCode: "482915", Items: []models.ShipmentItem{{ProductID: "p1", Quantity: 2}}}
+	shipments.On("GetShipment", mock.Anything, "shipment-1").Return(shipment, nil)
+	shipments.On("RecordDeliveryCodeFailure", mock.Anything, "shipment-1").Return(nil).Once()
+
+	// A wrong code is counted and refused
+	w := deliverForm("Jane Doe", "000000", pngSignature)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"attemptsLeft":4`)
+
+	// The signature must be an image
+	w = deliverForm("Jane Doe", "482915", []byte("not an image"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = deliverForm("Jane Doe", "482915", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	shipments.On("SaveSignature", mock.Anything, mock.MatchedBy(func(s *models.DeliverySignature) bool {
+		return s.ShipmentID == "shipment-1" && s.UserID == "user-1" && s.ContentType == "image/png"
+	})).Return(nil)
+	shipments.On("DeliverShipment", mock.Anything, "shipment-1", mock.MatchedBy(func(p *models.ProofOfDelivery) bool {
+		return p.RecipientName == "Jane Doe" && p.CodeVerified && p.RecordedBy == "admin-1" && p.SignatureType == "image/png"
+	})).Return(true, nil)
+
+	// p2 is still to come, so the order stays open
+	orders.On("GetOrderByID", mock.Anything, "order-1").Return(twoProductOrder(models.OrderStatusShipped), nil)
+	delivered := *shipment
+	delivered.Status = models.ShipmentStatusDelivered
+	shipments.On("ListShipmentsByOrder", mock.Anything, "order-1").Return([]*models.Shipment{&delivered}, nil).Once()
+
+	w = deliverForm(" Jane Doe ", "482915", pngSignature)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"orderComplete":false`)
+
+	// Delivering the rest completes it
+	rest := &models.Shipment{Status: models.ShipmentStatusDelivered, Items: []models.ShipmentItem{{ProductID: "p2", Quantity: 1}}}
+	shipments.On("ListShipmentsByOrder", mock.Anything, "order-1").Return([]*models.Shipment{&delivered, rest}, nil).Once()
+	orders.On("UpdateOrderStatus", mock.Anything, "order-1", models.OrderStatusComplete).Return(nil).Once()
+
+	w = deliverForm("Jane Doe", "482915", pngSignature)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"orderComplete":true`)
+	shipments.AssertExpectations(t)
+	orders.AssertExpectations(t)
+}
+
+func TestAdminDeliverShipment_LockedAfterWrongCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	shipments := useShipmentRepo(t)
+	shipments.On("GetShipment", mock.Anything, "shipment-1").Return(&models.Shipment{
+		ID: "shipment-1", Status: models.ShipmentStatusDispatched, DeliveryCode: "482915", DeliveryCodeAttempts: maxDeliveryCodeAttempts,
+	}, nil)
+
+	w := deliverForm("Jane Doe", "482915", pngSignature)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "delivery_code_locked")
+	shipments.AssertNotCalled(t, "DeliverShipment", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTrackOrder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orders := useOrderRepo(t)
+	shipments := useShipmentRepo(t)
+	orders.On("GetOrderByID", mock.Anything, "order-1").Return(twoProductOrder(models.OrderStatusShipped), nil)
+	shipments.On("ListShipmentsByOrder", mock.Anything, "order-1").Return([]*models.Shipment{
+		{ID: "shipment-1", Status: models.ShipmentStatusDispatched, DeliveryCode: "482915", RiderName: "Otieno", TrackingReference: "RDR-1",
+			CreatedBy: "admin-1", Items: []models.ShipmentItem{{ProductID: "p1", Quantity: 2}}},
+	}, nil)
+
+	w := asCustomer("GET", "/orders/:id/shipments", "/orders/order-1/shipments", TrackOrder, nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data []struct {
+			Status       string `json:"status"`
+			RiderName    string `json:"riderName"`
+			DeliveryCode string `json:"deliveryCode"`
+			CreatedBy    string `json:"createdBy"`
+		} `json:"data"`
+		Unshipped []models.ShipmentItem `json:"unshipped"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Data, 1)
+	assert.Equal(t, "482915", response.Data[0].DeliveryCode)
+	assert.Equal(t, "Otieno", response.Data[0].RiderName)
+	assert.Empty(t, response.Data[0].CreatedBy)
+	assert.Equal(t, []models.ShipmentItem{{ProductID: "p2", Quantity: 1}}, response.Unshipped)
+
+	// Other customers' orders stay private
+	orders.On("GetOrderByID", mock.Anything, "order-2").Return(&models.Order{ID: "order-2", UserID: "user-2"}, nil)
+	w = asCustomer("GET", "/orders/:id/shipments", "/orders/order-2/shipments", TrackOrder, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAdminUpdateOrderStatus_CompleteNeedsProofOfDelivery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orders := useOrderRepo(t)
+	shipments := useShipmentRepo(t)
+	orders.On("GetOrderByID", mock.Anything, "order-1").Return(twoProductOrder(models.OrderStatusShipped), nil)
+	shipments.On("ListShipmentsByOrder", mock.Anything, "order-1").Return([]*models.Shipment{
+		{Status: models.ShipmentStatusDelivered, Items: []models.ShipmentItem{{ProductID: "p1", Quantity: 2}}},
+		{Status: models.ShipmentStatusDispatched, Items: []models.ShipmentItem{{ProductID: "p2", Quantity: 1}}},
+	}, nil)
+
+	w := asAdmin("PUT", "/admin/orders/:id/status", "/admin/orders/order-1/status", AdminUpdateOrderStatus, map[string]string{"status": models.OrderStatusComplete})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"undelivered":[{"productId":"p2","quantity":1}]`)
+	orders.AssertNotCalled(t, "UpdateOrderStatus", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,513 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// twoFactorConfig controls authenticator app (TOTP) sign-in
+type twoFactorConfig struct {
+	// issuer names the account in authenticator apps
+	issuer string
+	// challengeTTL is how long a user has to enter their code after the password step
+	challengeTTL time.Duration
+	// requiredForStaff keeps users with any staff role (anything but "user") out of
+	// admin routes until they have signed in with a code
+	requiredForStaff bool
+}
+
+var twoFactorSettings = twoFactorConfig{
+	issuer:       "Maggiesb",
+	challengeTTL: 5 * time.Minute,
+}
+
+// recoveryCodeCount is how many single-use recovery codes a user is given
+const recoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// InitTwoFactor loads two-factor settings from the environment: TOTP_ISSUER
+// (default "Maggiesb"), LOGIN_CHALLENGE_TTL (default 5m) and
+// REQUIRE_ADMIN_TWO_FACTOR.
+func InitTwoFactor() error {
+	config := twoFactorSettings
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		if strings.Contains(issuer, ":") {
+			return fmt.Errorf("TOTP_ISSUER must not contain a colon")
+		}
+		config.issuer = issuer
+	}
+	if raw := os.Getenv("LOGIN_CHALLENGE_TTL"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid LOGIN_CHALLENGE_TTL %q", raw)
+		}
+		config.challengeTTL = d
+	}
+	config.requiredForStaff = os.Getenv("REQUIRE_ADMIN_TWO_FACTOR") == "true"
+
+	twoFactorSettings = config
+	return nil
+}
+
+// twoFactorRequired reports whether the user's roles must sign in with a code
+func twoFactorRequired(user *models.User) bool {
+	return twoFactorSettings.requiredForStaff && user.IsStaff()
+}
+
+// startTwoFactorChallenge answers the password step of a login for a user with
+// two-factor authentication. The login completes at VerifyTwoFactorLogin.
+func startTwoFactorChallenge(ctx context.Context, user *models.User) (*models.TwoFactorChallenge, error) {
+	token, expiresAt, err := issueAccountToken(ctx, user, models.AccountTokenLoginChallenge, twoFactorSettings.challengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TwoFactorChallenge{
+		TwoFactorRequired:  true,
+		ChallengeToken:     token,
+		ChallengeExpiresAt: expiresAt.Unix(),
+	}, nil
+}
+
+// VerifyTwoFactorLogin completes a login with the challenge token from Login and a
+// code from the user's authenticator app, or one of their recovery codes. Wrong
+// codes count as failed logins. A challenge can be retried until it expires and
+// works for one login.
+func VerifyTwoFactorLogin(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.Code == "") == (req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide either code or recoveryCode"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tokenRepo := NewAccountTokenRepository
+	hash := hashAccountToken(req.ChallengeToken)
+
+	challenge, err := tokenRepo.GetAccountToken(ctx, hash)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login challenge"})
+		return
+	}
+	if err == mongo.ErrNoDocuments || challenge.Purpose != models.AccountTokenLoginChallenge || !challenge.Usable() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login challenge; sign in again"})
+		return
+	}
+
+	user, err := NewUserRepository.FindUserByID(ctx, challenge.UserID)
+	if err == nil && user.Deleted() {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login challenge; sign in again"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+		return
+	}
+
+	email := normalizeLoginEmail(user.Email)
+	ip := c.ClientIP()
+	retryAt, err := loginRetryAt(ctx, email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login attempts"})
+		return
+	}
+	if time.Now().Before(retryAt) {
+		tooManyLoginAttempts(c, retryAt)
+		return
+	}
+
+	ok, err := verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check two-factor code"})
+		return
+	}
+	if !ok {
+		recordLoginFailure(ctx, email, ip, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	redeemed, err := tokenRepo.MarkAccountTokenUsed(ctx, hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login challenge"})
+		return
+	}
+	if !redeemed {
+		// Completed by a concurrent request
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login challenge; sign in again"})
+		return
+	}
+	clearLoginFailures(ctx, email)
+	if refuseSuspended(c, user) {
+		return
+	}
+
+	response, err := startSession(c, user, req.Device, auth.MethodPassword, auth.MethodOTP)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SetupTwoFactor provisions a new authenticator app secret. Two-factor
+// authentication is not on until the user confirms a code from it.
+func SetupTwoFactor(c *gin.Context) {
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+	if err := NewUserRepository.SetPendingTwoFactor(c.Request.Context(), user.ID, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(twoFactorSettings.issuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor turns two-factor authentication on once the user enters a code
+// from the secret SetupTwoFactor provisioned, and returns their recovery codes.
+// Wrong codes count as failed logins.
+func ConfirmTwoFactor(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	if user.TwoFactor == nil || user.TwoFactor.PendingSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "set up two-factor authentication first"})
+		return
+	}
+
+	// Guesses here count like failed logins
+	ctx := c.Request.Context()
+	email := normalizeLoginEmail(user.Email)
+	ip := c.ClientIP()
+	retryAt, err := loginRetryAt(ctx, email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login attempts"})
+		return
+	}
+	if time.Now().Before(retryAt) {
+		tooManyLoginAttempts(c, retryAt)
+		return
+	}
+
+	secret := user.TwoFactor.PendingSecret
+	step, valid := auth.ValidateTOTP(secret, req.Code, time.Now(), 0)
+	if !valid {
+		recordLoginFailure(ctx, email, ip, user)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+
+	enabled, err := NewUserRepository.EnableTwoFactor(ctx, user.ID, secret, hashes, step)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+		return
+	}
+	if !enabled {
+		// Setup was started again from another device
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor setup was restarted; use the latest secret"})
+		return
+	}
+	recordAuthEvent(ctx, &models.AuthEvent{ID: uuid.New().String(), Type: models.AuthEventTwoFactorEnabled, UserID: user.ID, Email: user.Email, IP: ip})
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns two-factor authentication off. It needs the password and
+// a code or recovery code, and is refused for roles that require two factors.
+func DisableTwoFactor(c *gin.Context) {
+	var req models.DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.Code == "") == (req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide either code or recoveryCode"})
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+		return
+	}
+	if twoFactorRequired(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is required for your role"})
+		return
+	}
+
+	// Guesses here count like failed logins
+	ctx := c.Request.Context()
+	email := normalizeLoginEmail(user.Email)
+	ip := c.ClientIP()
+	retryAt, err := loginRetryAt(ctx, email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login attempts"})
+		return
+	}
+	if time.Now().Before(retryAt) {
+		tooManyLoginAttempts(c, retryAt)
+		return
+	}
+
+	if err := auth.VerifyPassword(user.Password, req.Password); err != nil {
+		recordLoginFailure(ctx, email, ip, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password or two-factor code"})
+		return
+	}
+	valid, err := verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check two-factor code"})
+		return
+	}
+	if !valid {
+		recordLoginFailure(ctx, email, ip, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password or two-factor code"})
+		return
+	}
+
+	if err := NewUserRepository.DisableTwoFactor(ctx, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+		return
+	}
+	recordAuthEvent(ctx, &models.AuthEvent{ID: uuid.New().String(), Type: models.AuthEventTwoFactorDisabled, UserID: user.ID, Email: user.Email, IP: ip})
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes, given a current code
+// from their authenticator app. Wrong codes count as failed logins.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	// Guesses here count like failed logins
+	ctx := c.Request.Context()
+	email := normalizeLoginEmail(user.Email)
+	ip := c.ClientIP()
+	retryAt, err := loginRetryAt(ctx, email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login attempts"})
+		return
+	}
+	if time.Now().Before(retryAt) {
+		tooManyLoginAttempts(c, retryAt)
+		return
+	}
+
+	valid, err := verifySecondFactor(ctx, user, req.Code, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check two-factor code"})
+		return
+	}
+	if !valid {
+		recordLoginFailure(ctx, email, ip, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+	if err := NewUserRepository.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store recovery codes"})
+		return
+	}
+	recordAuthEvent(ctx, &models.AuthEvent{ID: uuid.New().String(), Type: models.AuthEventRecoveryCodesRenewed, UserID: user.ID, Email: user.Email, IP: ip})
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RequireTwoFactor keeps staff out of the routes it guards until they have signed
+// in with a two-factor code, when REQUIRE_ADMIN_TWO_FACTOR is set. Roles are read
+// from the database like RequirePermission does, so users given a staff role
+// after signing in are held to it too. Use it after AuthMiddleware.
+func RequireTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !twoFactorSettings.requiredForStaff {
+			c.Next()
+			return
+		}
+		roles, err := currentRoles(c)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve permissions"})
+			return
+		}
+		roleNames := make([]string, len(roles))
+		for i, role := range roles {
+			roleNames[i] = role.ID
+		}
+		if !models.HasStaffRole(roleNames) {
+			c.Next()
+			return
+		}
+		value, _ := c.Get("authMethods")
+		methods, _ := value.([]string)
+		if slices.Contains(methods, auth.MethodOTP) {
+			c.Next()
+			return
+		}
+
+		// Tell staff who have not set it up yet what to do
+		user, err := NewUserRepository.FindUserByID(c.Request.Context(), c.GetString("userID"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+			return
+		}
+		if !user.TwoFactorEnabled {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "set up two-factor authentication to use admin features",
+				"code":  "two_factor_setup_required",
+			})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "sign in again with your two-factor code to use admin features",
+			"code":  "two_factor_required",
+		})
+	}
+}
+
+// verifySecondFactor checks a code from the user's authenticator app or one of
+// their recovery codes, using it up
+func verifySecondFactor(ctx context.Context, user *models.User, code, recoveryCode string) (bool, error) {
+	if !user.TwoFactorEnabled || user.TwoFactor == nil {
+		return false, nil
+	}
+	userRepo := NewUserRepository
+
+	if code != "" {
+		step, valid := auth.ValidateTOTP(user.TwoFactor.Secret, code, time.Now(), user.TwoFactor.LastStep)
+		if !valid {
+			return false, nil
+		}
+		// Recording the step fails if the same code was just used elsewhere
+		return userRepo.UseTwoFactorStep(ctx, user.ID, step)
+	}
+
+	used, err := userRepo.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(recoveryCode))
+	if err != nil || !used {
+		return false, err
+	}
+	recordAuthEvent(ctx, &models.AuthEvent{ID: uuid.New().String(), Type: models.AuthEventRecoveryCodeUsed, UserID: user.ID, Email: user.Email})
+	return true, nil
+}
+
+// findCurrentUser loads the signed-in user, answering the request if it cannot
+func findCurrentUser(c *gin.Context) (*models.User, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return nil, false
+	}
+
+	user, err := NewUserRepository.FindUserByID(c.Request.Context(), userID.(string))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+		return nil, false
+	}
+	return user, true
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes, formatted like
+// "k3v9q-x7m2a", and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// enrolledUser returns a user with two-factor authentication on, and their secret
+func enrolledUser(t *testing.T, role string) (*models.User, string) {
+	secret, err := auth.GenerateTOTPSecret()
+	assert.NoError(t, err)
+	hashedPassword, _ := auth.HashPassword("password123")
+	return &models.User{
+		ID:               "user-1",
+		Email:            "jane@example.com",
+		Password:         hashedPassword,
+		Role:             role,
+		TwoFactorEnabled: true,
+		TwoFactor:        &models.TwoFactor{Secret: secret, RecoveryCodeHashes: []string{hashRecoveryCode("abcde-fghij")}},
+	}, secret
+}
+
+func currentCode(t *testing.T, secret string) string {
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now()))
+	assert.NoError(t, err)
+	return code
+}
+
+func useTwoFactorSettings(t *testing.T) {
+	old := twoFactorSettings
+	t.Cleanup(func() { twoFactorSettings = old })
+}
+
+// asUser runs a protected handler as userID
+func asUser(handler gin.HandlerFunc, userID string, body interface{}) *httptest.ResponseRecorder {
+	return postJSON(func(c *gin.Context) {
+		c.Set("userID", userID)
+		handler(c)
+	}, "/auth/2fa", body)
+}
+
+// withChallenge stores a usable login challenge for user-1 and returns its token
+func withChallenge(tokens *MockAccountTokenRepository) string {
+	token := "challenge-token"
+	record := &models.AccountToken{ID: hashAccountToken(token), UserID: "user-1", Purpose: models.AccountTokenLoginChallenge, ExpiresAt: time.Now().Add(time.Minute)}
+	tokens.On("GetAccountToken", mock.Anything, record.ID).Return(record, nil)
+	return token
+}
+
+func TestLogin_TwoFactorReturnsChallenge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	tokens, _ := useAccountEmail(t)
+	sessions, _ := useSessionRepos(t)
+	user, _ := enrolledUser(t, "user")
+	users := useUserRepo(t)
+	users.On("FindUserByEmail", mock.Anything, "jane@example.com").Return(user, nil)
+
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	tokens.On("InvalidateAccountTokens", mock.Anything, "user-1", models.AccountTokenLoginChallenge).Return(nil)
+	tokens.On("CreateAccountToken", mock.Anything, mock.MatchedBy(func(tok *models.AccountToken) bool {
+		return tok.Purpose == models.AccountTokenLoginChallenge && time.Until(tok.ExpiresAt) <= twoFactorSettings.challengeTTL
+	})).Return(nil)
+
+	w := postLogin("jane@example.com", "password123")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var challenge models.TwoFactorChallenge
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	assert.True(t, challenge.TwoFactorRequired)
+	assert.NotEmpty(t, challenge.ChallengeToken)
+	assert.NotContains(t, w.Body.String(), `"token"`)
+
+	// No session yet, and failed logins are kept until the code is entered
+	sessions.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything)
+	throttles.AssertNotCalled(t, "ClearLoginThrottle", mock.Anything, mock.Anything)
+	tokens.AssertExpectations(t)
+}
+
+func TestVerifyTwoFactorLogin_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	tokens, _ := useAccountEmail(t)
+	sessions, _ := useSessionRepos(t)
+	user, secret := enrolledUser(t, "admin")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+
+	token := withChallenge(tokens)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	users.On("UseTwoFactorStep", mock.Anything, "user-1", auth.TOTPStep(time.Now())).Return(true, nil)
+	tokens.On("MarkAccountTokenUsed", mock.Anything, hashAccountToken(token)).Return(true, nil)
+	throttles.On("ClearLoginThrottle", mock.Anything, accountKey).Return(true, nil)
+	sessions.On("CreateSession", mock.Anything, mock.MatchedBy(func(s *models.Session) bool {
+		return s.UserID == "user-1" && s.Device == "Work laptop" &&
+			len(s.AuthMethods) == 2 && s.AuthMethods[0] == auth.MethodPassword && s.AuthMethods[1] == auth.MethodOTP
+	})).Return(nil)
+
+	w := postJSON(VerifyTwoFactorLogin, "/auth/2fa/verify", models.TwoFactorLoginRequest{ChallengeToken: token, Code: currentCode(t, secret), Device: "Work laptop"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.AuthResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NotEmpty(t, response.Token)
+	assert.NotEmpty(t, response.RefreshToken)
+	users.AssertExpectations(t)
+	tokens.AssertExpectations(t)
+	sessions.AssertExpectations(t)
+}
+
+func TestVerifyTwoFactorLogin_WrongCodeCountsFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	tokens, _ := useAccountEmail(t)
+	user, secret := enrolledUser(t, "user")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+
+	token := withChallenge(tokens)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleAccount, "jane@example.com", mock.Anything).
+		Return(&models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 1}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleIP, testClientIP, mock.Anything).
+		Return(&models.LoginThrottle{ID: ipKey, Scope: models.LoginThrottleIP, Failures: 1}, nil)
+
+	wrong, _ := auth.TOTPCode(secret, auth.TOTPStep(time.Now())-5)
+	w := postJSON(VerifyTwoFactorLogin, "/auth/2fa/verify", models.TwoFactorLoginRequest{ChallengeToken: token, Code: wrong})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	throttles.AssertExpectations(t)
+	tokens.AssertNotCalled(t, "MarkAccountTokenUsed", mock.Anything, mock.Anything)
+}
+
+func TestVerifyTwoFactorLogin_ReplayedCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	loginProtection.maxAccountFailures, loginProtection.maxIPFailures = 0, 0
+	tokens, _ := useAccountEmail(t)
+	user, secret := enrolledUser(t, "user")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+
+	// The code is right, but another request has just used it
+	token := withChallenge(tokens)
+	users.On("UseTwoFactorStep", mock.Anything, "user-1", mock.Anything).Return(false, nil)
+
+	w := postJSON(VerifyTwoFactorLogin, "/auth/2fa/verify", models.TwoFactorLoginRequest{ChallengeToken: token, Code: currentCode(t, secret)})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	throttles.AssertNotCalled(t, "GetLoginThrottles", mock.Anything, mock.Anything)
+}
+
+func TestVerifyTwoFactorLogin_RecoveryCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	tokens, _ := useAccountEmail(t)
+	sessions, _ := useSessionRepos(t)
+	user, _ := enrolledUser(t, "user")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+
+	token := withChallenge(tokens)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("ClearLoginThrottle", mock.Anything, accountKey).Return(true, nil)
+	users.On("UseRecoveryCode", mock.Anything, "user-1", hashRecoveryCode("abcde-fghij")).Return(true, nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventRecoveryCodeUsed && e.UserID == "user-1"
+	})).Return(nil)
+	tokens.On("MarkAccountTokenUsed", mock.Anything, hashAccountToken(token)).Return(true, nil)
+	sessions.On("CreateSession", mock.Anything, mock.Anything).Return(nil)
+
+	// Recovery codes are accepted without the dash and in any case
+	w := postJSON(VerifyTwoFactorLogin, "/auth/2fa/verify", models.TwoFactorLoginRequest{ChallengeToken: token, RecoveryCode: "ABCDE FGHIJ"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	users.AssertExpectations(t)
+	events.AssertExpectations(t)
+}
+
+func TestVerifyTwoFactorLogin_InvalidChallenge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens, _ := useAccountEmail(t)
+
+	// A password reset token cannot stand in for a login challenge
+	record := &models.AccountToken{ID: hashAccountToken("reset-token"), UserID: "user-1", Purpose: models.AccountTokenPasswordReset, ExpiresAt: time.Now().Add(time.Minute)}
+	tokens.On("GetAccountToken", mock.Anything, record.ID).Return(record, nil)
+	w := postJSON(VerifyTwoFactorLogin, "/auth/2fa/verify", models.TwoFactorLoginRequest{ChallengeToken: "reset-token", Code: "123456"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	expired := &models.AccountToken{ID: hashAccountToken("old"), UserID: "user-1", Purpose: models.AccountTokenLoginChallenge, ExpiresAt: time.Now().Add(-time.Second)}
+	tokens.On("GetAccountToken", mock.Anything, expired.ID).Return(expired, nil)
+	w = postJSON(VerifyTwoFactorLogin, "/auth/2fa/verify", models.TwoFactorLoginRequest{ChallengeToken: "old", Code: "123456"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = postJSON(VerifyTwoFactorLogin, "/auth/2fa/verify", models.TwoFactorLoginRequest{ChallengeToken: "old", Code: "123456", RecoveryCode: "abcde-fghij"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSetupAndConfirmTwoFactor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	loginProtection.maxIPFailures = 0
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	users := useUserRepo(t)
+	user := &models.User{ID: "user-1", Email: "jane@example.com", Role: "user"}
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+
+	var pending string
+	users.On("SetPendingTwoFactor", mock.Anything, "user-1", mock.MatchedBy(func(secret string) bool {
+		pending = secret
+		return true
+	})).Return(nil)
+
+	w := asUser(SetupTwoFactor, "user-1", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var setup models.TwoFactorSetupResponse
+	json.Unmarshal(w.Body.Bytes(), &setup)
+	assert.Equal(t, pending, setup.Secret)
+	uri, err := url.Parse(setup.ProvisioningURI)
+	assert.NoError(t, err)
+	assert.Equal(t, pending, uri.Query().Get("secret"))
+
+	// A wrong code leaves two-factor authentication off and counts as a failed login
+	user.TwoFactor = &models.TwoFactor{PendingSecret: pending}
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleAccount, "jane@example.com", mock.Anything).
+		Return(&models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 1}, nil).Once()
+	w = asUser(ConfirmTwoFactor, "user-1", models.TwoFactorCodeRequest{Code: "000000"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	throttles.AssertExpectations(t)
+
+	var storedHashes []string
+	users.On("EnableTwoFactor", mock.Anything, "user-1", pending, mock.MatchedBy(func(hashes []string) bool {
+		storedHashes = hashes
+		return len(hashes) == recoveryCodeCount
+	}), auth.TOTPStep(time.Now())).Return(true, nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventTwoFactorEnabled && e.UserID == "user-1"
+	})).Return(nil)
+
+	w = asUser(ConfirmTwoFactor, "user-1", models.TwoFactorCodeRequest{Code: currentCode(t, pending)})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var codes models.RecoveryCodesResponse
+	json.Unmarshal(w.Body.Bytes(), &codes)
+	assert.Len(t, codes.RecoveryCodes, recoveryCodeCount)
+	for i, code := range codes.RecoveryCodes {
+		assert.Len(t, code, 11)
+		assert.Equal(t, hashRecoveryCode(code), storedHashes[i], "only hashes of the codes are stored")
+	}
+	users.AssertExpectations(t)
+	events.AssertExpectations(t)
+}
+
+func TestSetupTwoFactor_AlreadyEnabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user, _ := enrolledUser(t, "user")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+
+	w := asUser(SetupTwoFactor, "user-1", nil)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	users.AssertNotCalled(t, "SetPendingTwoFactor", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useTwoFactorSettings(t)
+	throttles, events := useLoginThrottles(t)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+
+	// Admins cannot turn it off while it is required
+	twoFactorSettings.requiredForStaff = true
+	admin, secret := enrolledUser(t, "admin")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(admin, nil).Once()
+	w := asUser(DisableTwoFactor, "user-1", models.DisableTwoFactorRequest{Password: "password123", Code: currentCode(t, secret)})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	user, secret := enrolledUser(t, "user")
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+	users.On("UseTwoFactorStep", mock.Anything, "user-1", mock.Anything).Return(true, nil)
+	users.On("DisableTwoFactor", mock.Anything, "user-1").Return(nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventTwoFactorDisabled && e.UserID == "user-1"
+	})).Return(nil)
+
+	w = asUser(DisableTwoFactor, "user-1", models.DisableTwoFactorRequest{Password: "password123", Code: currentCode(t, secret)})
+	assert.Equal(t, http.StatusOK, w.Code)
+	users.AssertExpectations(t)
+	events.AssertExpectations(t)
+}
+
+func TestDisableTwoFactor_WrongPassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	loginProtection.maxIPFailures = 0
+	user, secret := enrolledUser(t, "user")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleAccount, "jane@example.com", mock.Anything).
+		Return(&models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 1}, nil)
+
+	w := asUser(DisableTwoFactor, "user-1", models.DisableTwoFactorRequest{Password: "wrong", Code: currentCode(t, secret)})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	throttles.AssertExpectations(t)
+	users.AssertNotCalled(t, "DisableTwoFactor", mock.Anything, mock.Anything)
+}
+
+func TestConfirmTwoFactor_RefusedWhileLocked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	users := useUserRepo(t)
+	pending, _ := auth.GenerateTOTPSecret()
+	users.On("FindUserByID", mock.Anything, "user-1").Return(&models.User{
+		ID: "user-1", Email: "jane@example.com", Role: "user", TwoFactor: &models.TwoFactor{PendingSecret: pending},
+	}, nil)
+
+	until := time.Now().Add(10 * time.Minute)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{
+		{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 5, LastFailureAt: time.Now().Add(-5 * time.Minute), LockedUntil: &until},
+	}, nil)
+
+	// Even the right code is refused
+	w := asUser(ConfirmTwoFactor, "user-1", models.TwoFactorCodeRequest{Code: currentCode(t, pending)})
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	users.AssertNotCalled(t, "EnableTwoFactor", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	throttles.AssertNotCalled(t, "RecordLoginFailure", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRegenerateRecoveryCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, events := useLoginThrottles(t)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	user, secret := enrolledUser(t, "user")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+	users.On("UseTwoFactorStep", mock.Anything, "user-1", mock.Anything).Return(true, nil)
+	users.On("ReplaceRecoveryCodes", mock.Anything, "user-1", mock.MatchedBy(func(hashes []string) bool {
+		return len(hashes) == recoveryCodeCount
+	})).Return(nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+
+	w := asUser(RegenerateRecoveryCodes, "user-1", models.TwoFactorCodeRequest{Code: currentCode(t, secret)})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	users.AssertExpectations(t)
+}
+
+func TestRegenerateRecoveryCodes_WrongCodeCountsFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	user, secret := enrolledUser(t, "user")
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(user, nil)
+	throttles.On("GetLoginThrottles", mock.Anything, mock.Anything).Return([]*models.LoginThrottle{}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleAccount, "jane@example.com", mock.Anything).
+		Return(&models.LoginThrottle{ID: accountKey, Scope: models.LoginThrottleAccount, Failures: 1}, nil)
+	throttles.On("RecordLoginFailure", mock.Anything, models.LoginThrottleIP, testClientIP, mock.Anything).
+		Return(&models.LoginThrottle{ID: ipKey, Scope: models.LoginThrottleIP, Failures: 1}, nil)
+
+	wrong, _ := auth.TOTPCode(secret, auth.TOTPStep(time.Now())-5)
+	w := asUser(RegenerateRecoveryCodes, "user-1", models.TwoFactorCodeRequest{Code: wrong})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	throttles.AssertExpectations(t)
+	users.AssertNotCalled(t, "ReplaceRecoveryCodes", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRequireTwoFactor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useTwoFactorSettings(t)
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "not-enrolled").Return(&models.User{ID: "not-enrolled", Role: "admin"}, nil)
+	users.On("FindUserByID", mock.Anything, "enrolled").Return(&models.User{ID: "enrolled", Roles: []string{models.RoleUser, models.RoleWarehouse}, TwoFactorEnabled: true}, nil)
+	users.On("FindUserByID", mock.Anything, "customer").Return(&models.User{ID: "customer", Role: "user"}, nil)
+	roles := useRoleRepo(t)
+	roles.On("GetRoles", mock.Anything, []string{"admin"}).Return([]*models.Role{defaultRole("admin")}, nil)
+	roles.On("GetRoles", mock.Anything, []string{"user", "warehouse"}).Return([]*models.Role{defaultRole("user"), defaultRole("warehouse")}, nil)
+	roles.On("GetRoles", mock.Anything, []string{"user"}).Return([]*models.Role{defaultRole("user")}, nil)
+
+	assert.Equal(t, http.StatusOK, staffRoute("not-enrolled", []string{"admin"}, auth.MethodPassword).Code, "not enforced unless configured")
+
+	twoFactorSettings.requiredForStaff = true
+	w := staffRoute("not-enrolled", []string{"admin"}, auth.MethodPassword)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "two_factor_setup_required")
+
+	// Every staff role, not only admin
+	w = staffRoute("enrolled", []string{"user", "warehouse"}, auth.MethodPassword)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"two_factor_required"`)
+
+	assert.Equal(t, http.StatusOK, staffRoute("enrolled", []string{"user", "warehouse"}, auth.MethodPassword, auth.MethodOTP).Code)
+	assert.Equal(t, http.StatusOK, staffRoute("customer", []string{"user"}, auth.MethodPassword).Code)
+}
+
+func TestRequireTwoFactor_PromotedMidSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useTwoFactorSettings(t)
+	twoFactorSettings.requiredForStaff = true
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "promoted").Return(&models.User{ID: "promoted", Roles: []string{"user", "admin"}}, nil)
+	roles := useRoleRepo(t)
+	roles.On("GetRoles", mock.Anything, []string{"user", "admin"}).Return([]*models.Role{defaultRole("user"), defaultRole("admin")}, nil)
+
+	// The token still says customer, but the account is now an admin's
+	w := staffRoute("promoted", []string{"user"}, auth.MethodPassword)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "two_factor_setup_required")
+}
+
+// staffRoute requests a route guarded by RequireTwoFactor as userID, with the
+// roles and sign-in methods their token carries
+func staffRoute(userID string, tokenRoles []string, methods ...string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.GET("/admin", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Set("roles", tokenRoles)
+		c.Set("authMethods", methods)
+	}, RequireTwoFactor(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+	return w
+}
+
+func TestInitTwoFactor(t *testing.T) {
+	useTwoFactorSettings(t)
+	t.Setenv("TOTP_ISSUER", "Maggie's Shop")
+	t.Setenv("LOGIN_CHALLENGE_TTL", "2m")
+	t.Setenv("REQUIRE_ADMIN_TWO_FACTOR", "true")
+
+	assert.NoError(t, InitTwoFactor())
+	assert.Equal(t, "Maggie's Shop", twoFactorSettings.issuer)
+	assert.Equal(t, 2*time.Minute, twoFactorSettings.challengeTTL)
+	assert.True(t, twoFactorSettings.requiredForStaff)
+
+	t.Setenv("TOTP_ISSUER", "Shop:Admin")
+	assert.Error(t, InitTwoFactor())
+}
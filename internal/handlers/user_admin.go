@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// bootstrapPasswordLength is the shortest password the first admin may be given
+const bootstrapPasswordLength = 12
+
+// BootstrapResult says what BootstrapAdmin did
+type BootstrapResult string
+
+const (
+	BootstrapSkipped  BootstrapResult = "skipped"  // an admin already exists
+	BootstrapPromoted BootstrapResult = "promoted" // a user with the email was given the admin role
+	BootstrapCreated  BootstrapResult = "created"
+)
+
+// refuseSuspended answers a sign-in by a suspended user, and reports whether it did
+func refuseSuspended(c *gin.Context, user *models.User) bool {
+	if !user.Suspended() {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "account suspended", "code": "account_suspended"})
+	return true
+}
+
+// findUserParam loads the user named in the URL, answering the request if it cannot
+func findUserParam(c *gin.Context) (*models.User, bool) {
+	user, err := NewUserRepository.FindUserByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+		return nil, false
+	}
+	return user, true
+}
+
+// pageParams reads the page and limit query parameters
+func pageParams(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+	return page, limit
+}
+
+// AdminListUsers lists users, newest first, optionally filtered by name or email,
+// role and status (admin)
+func AdminListUsers(c *gin.Context) {
+	var query models.UserSearchQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch query.Status {
+	case "", models.UserStatusActive, models.UserStatusSuspended:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or suspended"})
+		return
+	}
+	page, limit := pageParams(c)
+
+	ctx := c.Request.Context()
+	users, err := NewUserRepository.SearchUsers(ctx, query, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search users"})
+		return
+	}
+
+	count, err := NewUserRepository.CountUsers(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       users,
+		"page":       page,
+		"limit":      limit,
+		"total":      count,
+		"totalPages": (count + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// AdminGetUser retrieves a user (admin)
+func AdminGetUser(c *gin.Context) {
+	user, ok := findUserParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// AdminListUserOrders lists a user's orders, newest first (admin)
+func AdminListUserOrders(c *gin.Context) {
+	user, ok := findUserParam(c)
+	if !ok {
+		return
+	}
+	page, limit := pageParams(c)
+
+	ctx := c.Request.Context()
+	orders, err := NewOrderRepository.GetOrdersByUser(ctx, user.ID, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve orders"})
+		return
+	}
+
+	count, err := NewOrderRepository.GetOrderCountByUser(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": orders, "page": page, "limit": limit, "total": count})
+}
+
+// AdminListUserInvoices lists the invoices for a user's orders, newest order
+// first (admin)
+func AdminListUserInvoices(c *gin.Context) {
+	user, ok := findUserParam(c)
+	if !ok {
+		return
+	}
+	page, limit := pageParams(c)
+
+	ctx := c.Request.Context()
+	invoices, err := NewInvoiceRepository.GetInvoicesByUser(ctx, user.ID, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve invoices"})
+		return
+	}
+
+	count, err := NewInvoiceRepository.CountInvoicesByUser(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": invoices, "page": page, "limit": limit, "total": count})
+}
+
+// refuseStaffAccountChange stops users without roles:manage suspending or
+// reactivating staff accounts, answering the request if it does. users:write alone
+// would otherwise let support staff lock out the admins above them.
+func refuseStaffAccountChange(c *gin.Context, user *models.User) bool {
+	if !user.IsStaff() {
+		return false
+	}
+	allowed, err := hasPermission(c, models.PermissionRolesManage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve permissions"})
+		return true
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "changing a staff account needs the roles:manage permission", "permission": models.PermissionRolesManage})
+		return true
+	}
+	return false
+}
+
+// AdminSuspendUser suspends an account and signs it out everywhere. A suspended
+// user cannot sign in or use a token they already have (admin). Staff accounts
+// need roles:manage, and the last active admin cannot be suspended.
+func AdminSuspendUser(c *gin.Context) {
+	var req models.SuspendUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actorID := c.GetString("userID")
+	if c.Param("id") == actorID {
+		c.JSON(http.StatusConflict, gin.H{"error": "you cannot suspend your own account"})
+		return
+	}
+
+	user, ok := findUserParam(c)
+	if !ok {
+		return
+	}
+	if refuseStaffAccountChange(c, user) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	isAdmin := slices.Contains(user.RoleNames(), models.RoleAdmin)
+	if isAdmin && !user.Suspended() {
+		admins, err := NewUserRepository.CountActiveUsersWithRole(ctx, models.RoleAdmin)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check admins"})
+			return
+		}
+		if admins <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot suspend the last active admin"})
+			return
+		}
+	}
+
+	suspension := &models.Suspension{Reason: req.Reason, SuspendedBy: actorID, SuspendedAt: time.Now()}
+	suspended, err := NewUserRepository.SuspendUser(ctx, user.ID, suspension)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to suspend user"})
+		return
+	}
+	if !suspended {
+		c.JSON(http.StatusConflict, gin.H{"error": "account is already suspended"})
+		return
+	}
+	if isAdmin {
+		// Two admins suspending each other at once both pass the check above; the
+		// later one finds no active admin left and undoes its suspension
+		admins, countErr := NewUserRepository.CountActiveUsersWithRole(ctx, models.RoleAdmin)
+		if countErr != nil || admins == 0 {
+			if _, err := NewUserRepository.ReactivateUser(ctx, user.ID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reactivate user"})
+				return
+			}
+			if countErr != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check admins"})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot suspend the last active admin"})
+			return
+		}
+	}
+
+	// AuthMiddleware refuses the user's access tokens from now on; revoking their
+	// sessions stops them being refreshed after a reactivation
+	revoked, err := revokeUserSessions(ctx, user.ID, "", models.SessionRevokedSuspended)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+	recordAuthEvent(ctx, &models.AuthEvent{
+		ID:      uuid.New().String(),
+		Type:    models.AuthEventSuspended,
+		UserID:  user.ID,
+		Email:   user.Email,
+		ActorID: actorID,
+		Reason:  req.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "account suspended", "revokedSessions": revoked})
+}
+
+// AdminReactivateUser lifts an account's suspension (admin). Staff accounts need
+// roles:manage.
+func AdminReactivateUser(c *gin.Context) {
+	user, ok := findUserParam(c)
+	if !ok {
+		return
+	}
+	if refuseStaffAccountChange(c, user) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	reactivated, err := NewUserRepository.ReactivateUser(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reactivate user"})
+		return
+	}
+	if !reactivated {
+		c.JSON(http.StatusConflict, gin.H{"error": "account is not suspended"})
+		return
+	}
+	recordAuthEvent(ctx, &models.AuthEvent{
+		ID:      uuid.New().String(),
+		Type:    models.AuthEventReactivated,
+		UserID:  user.ID,
+		Email:   user.Email,
+		ActorID: c.GetString("userID"),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "account reactivated"})
+}
+
+// BootstrapAdmin makes sure there is an admin to sign in as. It does nothing if
+// any user already has the admin role; otherwise it creates the user with email
+// and password. An existing user with email is only given the role when
+// promoteExisting is set and they have verified the email, since anyone could
+// have registered it.
+func BootstrapAdmin(ctx context.Context, email, password, firstName, lastName string, promoteExisting bool) (BootstrapResult, error) {
+	userRepo := NewUserRepository
+
+	admins, err := userRepo.CountUsersWithRole(ctx, models.RoleAdmin)
+	if err != nil {
+		return "", fmt.Errorf("failed to check admins: %w", err)
+	}
+	if admins > 0 {
+		return BootstrapSkipped, nil
+	}
+
+	email = normalizeLoginEmail(email)
+	if _, err := mail.ParseAddress(email); err != nil {
+		return "", fmt.Errorf("invalid admin email %q", email)
+	}
+
+	user, err := userRepo.FindUserByEmail(ctx, email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	if err == nil {
+		if !promoteExisting {
+			return "", fmt.Errorf("a user with email %s already exists; rerun with --promote-existing to make them admin", email)
+		}
+		if !user.EmailVerified {
+			return "", fmt.Errorf("user %s has not verified their email, so they cannot be made admin; verify it first", email)
+		}
+		roles := []string{models.RoleAdmin}
+		for _, role := range user.RoleNames() {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+		if err := userRepo.SetUserRoles(ctx, user.ID, roles); err != nil {
+			return "", fmt.Errorf("failed to promote user: %w", err)
+		}
+		recordAuthEvent(ctx, &models.AuthEvent{
+			ID:     uuid.New().String(),
+			Type:   models.AuthEventRolesChanged,
+			UserID: user.ID,
+			Email:  user.Email,
+			Roles:  roles,
+		})
+		return BootstrapPromoted, nil
+	}
+
+	if len(password) < bootstrapPasswordLength {
+		return "", errors.New("the admin password must be at least 12 characters")
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return "", fmt.Errorf("failed to process password: %w", err)
+	}
+
+	now := time.Now()
+	user = &models.User{
+		ID:              uuid.New().String(),
+		Email:           email,
+		Password:        hashedPassword,
+		FirstName:       firstName,
+		LastName:        lastName,
+		Role:            models.RoleAdmin,
+		Roles:           []string{models.RoleAdmin},
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if err := userRepo.CreateUser(ctx, user); err != nil {
+		return "", fmt.Errorf("failed to create admin: %w", err)
+	}
+	return BootstrapCreated, nil
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func suspendedUser() *models.User {
+	return &models.User{
+		ID:         "user-1",
+		Email:      "jane@example.com",
+		Role:       "user",
+		Suspension: &models.Suspension{Reason: "chargebacks", SuspendedBy: "admin-1", SuspendedAt: time.Now()},
+	}
+}
+
+func TestAdminListUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	query := models.UserSearchQuery{Q: "jane", Role: "finance", Status: "suspended"}
+	users.On("SearchUsers", mock.Anything, query, 2, 5).Return([]*models.User{suspendedUser()}, nil)
+	users.On("CountUsers", mock.Anything, query).Return(int64(6), nil)
+
+	w := asAdmin("GET", "/users", "/users?q=jane&role=finance&status=suspended&page=2&limit=5", AdminListUsers, nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":6`)
+	assert.Contains(t, w.Body.String(), `"totalPages":2`)
+	assert.Contains(t, w.Body.String(), "chargebacks")
+	assert.NotContains(t, w.Body.String(), "password")
+
+	w = asAdmin("GET", "/users", "/users?status=deleted", AdminListUsers, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminListUserOrdersAndInvoices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	orders := new(MockOrderRepository)
+	invoices := new(MockInvoiceRepository)
+	oldOrders, oldInvoices := NewOrderRepository, NewInvoiceRepository
+	NewOrderRepository, NewInvoiceRepository = orders, invoices
+	t.Cleanup(func() { NewOrderRepository, NewInvoiceRepository = oldOrders, oldInvoices })
+
+	users.On("FindUserByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1"}, nil)
+	users.On("FindUserByID", mock.Anything, "missing").Return(nil, mongo.ErrNoDocuments)
+	orders.On("GetOrdersByUser", mock.Anything, "user-1", 1, 10).Return([]*models.Order{{ID: "order-1", UserID: "user-1"}}, nil)
+	orders.On("GetOrderCountByUser", mock.Anything, "user-1").Return(int64(1), nil)
+	invoices.On("GetInvoicesByUser", mock.Anything, "user-1", 1, 10).Return([]*models.Invoice{{ID: "invoice-1", OrderID: "order-1"}}, nil)
+	invoices.On("CountInvoicesByUser", mock.Anything, "user-1").Return(int64(1), nil)
+
+	w := asAdmin("GET", "/users/:id/orders", "/users/user-1/orders", AdminListUserOrders, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "order-1")
+
+	w = asAdmin("GET", "/users/:id/invoices", "/users/user-1/invoices", AdminListUserInvoices, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "invoice-1")
+
+	w = asAdmin("GET", "/users/:id/invoices", "/users/missing/invoices", AdminListUserInvoices, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminSuspendUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, events := useLoginThrottles(t)
+	sessions, tokens := useSessionRepos(t)
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1", Email: "jane@example.com"}, nil)
+	users.On("SuspendUser", mock.Anything, "user-1", mock.MatchedBy(func(s *models.Suspension) bool {
+		return s.Reason == "chargebacks" && s.SuspendedBy == "admin-1"
+	})).Return(true, nil)
+
+	session, _ := activeSession(t, "user-1")
+	sessions.On("ListActiveSessions", mock.Anything, "user-1").Return([]*models.Session{session}, nil)
+	sessions.On("RevokeSession", mock.Anything, session.ID, models.SessionRevokedSuspended).Return(true, nil)
+	sessions.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+	tokens.On("BlacklistToken", mock.Anything, "jti-old", session.AccessExpiresAt).Return(nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventSuspended && e.UserID == "user-1" && e.ActorID == "admin-1" && e.Reason == "chargebacks"
+	})).Return(nil)
+
+	w := asAdmin("POST", "/users/:id/suspend", "/users/user-1/suspend", AdminSuspendUser, models.SuspendUserRequest{Reason: "chargebacks"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"revokedSessions":1`)
+	sessions.AssertExpectations(t)
+	tokens.AssertExpectations(t)
+	events.AssertExpectations(t)
+}
+
+func TestAdminSuspendUser_Refused(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(suspendedUser(), nil)
+	users.On("SuspendUser", mock.Anything, "user-1", mock.Anything).Return(false, nil)
+
+	// A reason is required
+	w := asAdmin("POST", "/users/:id/suspend", "/users/user-1/suspend", AdminSuspendUser, map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Admins cannot lock themselves out
+	w = asAdmin("POST", "/users/:id/suspend", "/users/admin-1/suspend", AdminSuspendUser, models.SuspendUserRequest{Reason: "testing"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = asAdmin("POST", "/users/:id/suspend", "/users/user-1/suspend", AdminSuspendUser, models.SuspendUserRequest{Reason: "again"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAdminSuspendUser_StaffNeedsRolesManage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	roles := useRoleRepo(t)
+	// admin-1 is signed in but only has the support role, which grants users:write
+	users.On("FindUserByID", mock.Anything, "admin-1").Return(&models.User{ID: "admin-1", Roles: []string{"support"}}, nil)
+	users.On("FindUserByID", mock.Anything, "admin-2").Return(&models.User{ID: "admin-2", Roles: []string{"admin"}}, nil)
+	users.On("FindUserByID", mock.Anything, "admin-3").Return(&models.User{ID: "admin-3", Roles: []string{"admin"}, Suspension: &models.Suspension{Reason: "left"}}, nil)
+	roles.On("GetRoles", mock.Anything, []string{"support"}).Return([]*models.Role{defaultRole("support")}, nil)
+
+	w := asAdmin("POST", "/users/:id/suspend", "/users/admin-2/suspend", AdminSuspendUser, models.SuspendUserRequest{Reason: "takeover"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), models.PermissionRolesManage)
+
+	w = asAdmin("POST", "/users/:id/reactivate", "/users/admin-3/reactivate", AdminReactivateUser, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	users.AssertNotCalled(t, "SuspendUser", mock.Anything, mock.Anything, mock.Anything)
+	users.AssertNotCalled(t, "ReactivateUser", mock.Anything, mock.Anything)
+}
+
+func TestAdminSuspendUser_LastActiveAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	roles := useRoleRepo(t)
+	users.On("FindUserByID", mock.Anything, "admin-1").Return(&models.User{ID: "admin-1", Roles: []string{"admin"}}, nil)
+	users.On("FindUserByID", mock.Anything, "admin-2").Return(&models.User{ID: "admin-2", Roles: []string{"admin"}}, nil)
+	roles.On("GetRoles", mock.Anything, []string{"admin"}).Return([]*models.Role{defaultRole("admin")}, nil)
+	users.On("CountActiveUsersWithRole", mock.Anything, "admin").Return(int64(1), nil)
+
+	w := asAdmin("POST", "/users/:id/suspend", "/users/admin-2/suspend", AdminSuspendUser, models.SuspendUserRequest{Reason: "left"})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "last active admin")
+	users.AssertNotCalled(t, "SuspendUser", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAdminSuspendUser_ConcurrentLastAdminUndone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := useUserRepo(t)
+	roles := useRoleRepo(t)
+	users.On("FindUserByID", mock.Anything, "admin-1").Return(&models.User{ID: "admin-1", Roles: []string{"admin"}}, nil)
+	users.On("FindUserByID", mock.Anything, "admin-2").Return(&models.User{ID: "admin-2", Roles: []string{"admin"}}, nil)
+	roles.On("GetRoles", mock.Anything, []string{"admin"}).Return([]*models.Role{defaultRole("admin")}, nil)
+	// admin-2 suspends admin-1 between the check and this suspension
+	users.On("CountActiveUsersWithRole", mock.Anything, "admin").Return(int64(2), nil).Once()
+	users.On("SuspendUser", mock.Anything, "admin-2", mock.Anything).Return(true, nil)
+	users.On("CountActiveUsersWithRole", mock.Anything, "admin").Return(int64(0), nil).Once()
+	users.On("ReactivateUser", mock.Anything, "admin-2").Return(true, nil)
+
+	w := asAdmin("POST", "/users/:id/suspend", "/users/admin-2/suspend", AdminSuspendUser, models.SuspendUserRequest{Reason: "left"})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	users.AssertExpectations(t)
+}
+
+func TestAdminReactivateUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, events := useLoginThrottles(t)
+	users := useUserRepo(t)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(suspendedUser(), nil)
+	users.On("FindUserByID", mock.Anything, "user-2").Return(&models.User{ID: "user-2"}, nil)
+	users.On("ReactivateUser", mock.Anything, "user-1").Return(true, nil)
+	users.On("ReactivateUser", mock.Anything, "user-2").Return(false, nil)
+	events.On("RecordAuthEvent", mock.Anything, mock.MatchedBy(func(e *models.AuthEvent) bool {
+		return e.Type == models.AuthEventReactivated && e.UserID == "user-1" && e.ActorID == "admin-1"
+	})).Return(nil)
+
+	w := asAdmin("POST", "/users/:id/reactivate", "/users/user-1/reactivate", AdminReactivateUser, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	events.AssertExpectations(t)
+
+	w = asAdmin("POST", "/users/:id/reactivate", "/users/user-2/reactivate", AdminReactivateUser, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestLogin_SuspendedUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttles, _ := useLoginThrottles(t)
+	sessions, _ := useSessionRepos(t)
+	users := useUserRepo(t)
+	user := suspendedUser()
+	user.Password, _ = auth.HashPassword("password123")
+	users.On("FindUserByEmail", mock.Anything, "jane@example.com").Return(user, nil)
+	throttles.On("GetLoginThrottles", mock.Anything, []string{accountKey, ipKey}).Return([]*models.LoginThrottle{}, nil)
+
+	w := postLogin("jane@example.com", "password123")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "account_suspended")
+	sessions.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything)
+}
+
+func TestRefreshSession_SuspendedUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sessions, tokens := useSessionRepos(t)
+	users := useUserRepo(t)
+	session, token := activeSession(t, "user-1")
+	sessions.On("GetSessionByID", mock.Anything, session.ID).Return(session, nil)
+	users.On("FindUserByID", mock.Anything, "user-1").Return(suspendedUser(), nil)
+
+	w := postRefresh(token)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	sessions.AssertNotCalled(t, "RotateRefreshToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	tokens.AssertNotCalled(t, "BlacklistToken", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	t.Run("an admin exists", func(t *testing.T) {
+		users := useUserRepo(t)
+		users.On("CountUsersWithRole", mock.Anything, "admin").Return(int64(1), nil)
+
+		result, err := BootstrapAdmin(context.Background(), "root@example.com", "correct horse battery", "", "", false)
+
+		assert.NoError(t, err)
+		assert.Equal(t, BootstrapSkipped, result)
+		users.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+	})
+
+	t.Run("promotes an existing user", func(t *testing.T) {
+		_, events := useLoginThrottles(t)
+		users := useUserRepo(t)
+		users.On("CountUsersWithRole", mock.Anything, "admin").Return(int64(0), nil)
+		users.On("FindUserByEmail", mock.Anything, "root@example.com").Return(&models.User{ID: "user-1", Email: "root@example.com", EmailVerified: true, Roles: []string{"user", "finance"}}, nil)
+		users.On("SetUserRoles", mock.Anything, "user-1", []string{"admin", "user", "finance"}).Return(nil)
+		events.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+
+		result, err := BootstrapAdmin(context.Background(), " Root@example.com ", "", "", "", true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, BootstrapPromoted, result)
+		users.AssertExpectations(t)
+	})
+
+	t.Run("an existing user needs --promote-existing", func(t *testing.T) {
+		users := useUserRepo(t)
+		users.On("CountUsersWithRole", mock.Anything, "admin").Return(int64(0), nil)
+		users.On("FindUserByEmail", mock.Anything, "root@example.com").Return(&models.User{ID: "user-1", Email: "root@example.com", EmailVerified: true}, nil)
+
+		_, err := BootstrapAdmin(context.Background(), "root@example.com", "correct horse battery", "", "", false)
+
+		assert.ErrorContains(t, err, "--promote-existing")
+		users.AssertNotCalled(t, "SetUserRoles", mock.Anything, mock.Anything, mock.Anything)
+		users.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+	})
+
+	t.Run("refuses to promote an unverified user", func(t *testing.T) {
+		users := useUserRepo(t)
+		users.On("CountUsersWithRole", mock.Anything, "admin").Return(int64(0), nil)
+		users.On("FindUserByEmail", mock.Anything, "root@example.com").Return(&models.User{ID: "user-1", Email: "root@example.com"}, nil)
+
+		_, err := BootstrapAdmin(context.Background(), "root@example.com", "", "", "", true)
+
+		assert.ErrorContains(t, err, "not verified")
+		users.AssertNotCalled(t, "SetUserRoles", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("creates the admin", func(t *testing.T) {
+		users := useUserRepo(t)
+		users.On("CountUsersWithRole", mock.Anything, "admin").Return(int64(0), nil)
+		users.On("FindUserByEmail", mock.Anything, "root@example.com").Return(nil, mongo.ErrNoDocuments)
+		users.On("CreateUser", mock.Anything, mock.MatchedBy(func(u *models.User) bool {
+			return u.Email == "root@example.com" && u.Role == "admin" && u.EmailVerified &&
+				auth.VerifyPassword(u.Password, "correct horse battery") == nil
+		})).Return(nil)
+
+		result, err := BootstrapAdmin(context.Background(), "root@example.com", "correct horse battery", "Ada", "Admin", false)
+
+		assert.NoError(t, err)
+		assert.Equal(t, BootstrapCreated, result)
+		users.AssertExpectations(t)
+	})
+
+	t.Run("refuses a short password", func(t *testing.T) {
+		users := useUserRepo(t)
+		users.On("CountUsersWithRole", mock.Anything, "admin").Return(int64(0), nil)
+		users.On("FindUserByEmail", mock.Anything, "root@example.com").Return(nil, mongo.ErrNoDocuments)
+
+		_, err := BootstrapAdmin(context.Background(), "root@example.com", "short", "", "", false)
+
+		assert.Error(t, err)
+		users.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+	})
+}
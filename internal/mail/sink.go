@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sink stands in for a mail server during development: each message is written to
+// a .eml file in Dir, or to the log if Dir is empty, so links in it can be
+// followed without sending real email
+type Sink struct {
+	Dir    string
+	From   string
+	Logger *log.Logger // log.Default() if nil
+}
+
+// Send records msg
+func (s *Sink) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	logger := s.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	if s.Dir == "" {
+		logger.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405Z"), uuid.New().String()[:8])
+	path := filepath.Join(s.Dir, name)
+	if err := os.WriteFile(path, format(s.From, msg, now), 0o600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	logger.Printf("Mail to %s written to %s", msg.To, path)
+	return nil
+}
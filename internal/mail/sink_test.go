@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSink_WritesMessageFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	var logged bytes.Buffer
+	sink := &Sink{Dir: dir, From: "no-reply@example.com", Logger: log.New(&logged, "", 0)}
+
+	if err := sink.Send(context.Background(), Message{To: "jane@example.com", Subject: "Verify your email", Body: "https://shop.example/verify?token=abc"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one message file, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "To: jane@example.com\r\n") || !strings.Contains(string(data), "verify?token=abc") {
+		t.Fatalf("unexpected message file: %q", data)
+	}
+	if !strings.Contains(logged.String(), files[0]) {
+		t.Fatalf("expected the file path to be logged, got %q", logged.String())
+	}
+}
+
+func TestSink_LogsWithoutDir(t *testing.T) {
+	var logged bytes.Buffer
+	sink := &Sink{Logger: log.New(&logged, "", 0)}
+
+	if err := sink.Send(context.Background(), Message{To: "jane@example.com", Subject: "Verify your email", Body: "token=abc"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if !strings.Contains(logged.String(), "jane@example.com") || !strings.Contains(logged.String(), "token=abc") {
+		t.Fatalf("expected the message to be logged, got %q", logged.String())
+	}
+}
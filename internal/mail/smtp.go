@@ -0,0 +1,117 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// defaultSMTPTimeout bounds a delivery when the caller's context has no deadline
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPConfig configures SMTPSender
+type SMTPConfig struct {
+	Host     string
+	Port     int    // 587 if zero
+	Username string // no authentication if empty
+	Password string
+	From     string // e.g. "Maggie's <no-reply@example.com>"
+}
+
+// SMTPSender delivers messages through an SMTP server, upgrading to TLS with
+// STARTTLS when the server offers it
+type SMTPSender struct {
+	config SMTPConfig
+}
+
+// NewSMTPSender creates an SMTP sender
+func NewSMTPSender(config SMTPConfig) *SMTPSender {
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	return &SMTPSender{config: config}
+}
+
+// Send delivers msg
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSMTPTimeout)
+		defer cancel()
+	}
+
+	addr := net.JoinHostPort(s.config.Host, fmt.Sprint(s.config.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.config.Host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.config.Username != "" {
+		// PlainAuth refuses to send credentials over an unencrypted connection
+		// except to localhost
+		auth := smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	from, err := envelopeAddress(s.config.From)
+	if err != nil {
+		return err
+	}
+	to, err := envelopeAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("SMTP server refused sender: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("SMTP server refused recipient: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := w.Write(format(s.config.From, msg, time.Now())); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
+
+// envelopeAddress extracts the bare address SMTP needs from "Name <addr>"
+func envelopeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return "", fmt.Errorf("invalid email address %q: %w", address, err)
+	}
+	return parsed.Address, nil
+}
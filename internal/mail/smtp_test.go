@@ -0,0 +1,136 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts one message and records its envelope and data
+type fakeSMTP struct {
+	addr string
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	srv := &fakeSMTP{addr: ln.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(srv.done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimSpace(line)
+			switch upper := strings.ToUpper(cmd); {
+			case strings.HasPrefix(upper, "EHLO"):
+				reply("250-localhost")
+				reply("250 8BITMIME")
+			case strings.HasPrefix(upper, "MAIL FROM:"):
+				srv.from = angleAddress(cmd)
+				reply("250 OK")
+			case strings.HasPrefix(upper, "RCPT TO:"):
+				srv.to = append(srv.to, angleAddress(cmd))
+				reply("250 OK")
+			case upper == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				srv.data = data.String()
+				reply("250 queued")
+			case upper == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return srv
+}
+
+// angleAddress extracts the address from "MAIL FROM:<addr> BODY=8BITMIME"
+func angleAddress(cmd string) string {
+	start, end := strings.Index(cmd, "<"), strings.Index(cmd, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return cmd[start+1 : end]
+}
+
+func TestSMTPSender_Send(t *testing.T) {
+	srv := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(srv.addr)
+	portNum, _ := strconv.Atoi(port)
+
+	sender := NewSMTPSender(SMTPConfig{Host: host, Port: portNum, From: "Maggie's <no-reply@example.com>"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := sender.Send(ctx, Message{To: "jane@example.com", Subject: "Reset your password", Body: "Follow this link:\nhttps://shop.example/reset?token=abc"})
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	<-srv.done
+
+	if srv.from != "no-reply@example.com" {
+		t.Fatalf("unexpected envelope sender: %q", srv.from)
+	}
+	if len(srv.to) != 1 || srv.to[0] != "jane@example.com" {
+		t.Fatalf("unexpected recipients: %v", srv.to)
+	}
+	if !strings.Contains(srv.data, "Subject: Reset your password\r\n") || !strings.Contains(srv.data, "https://shop.example/reset?token=abc") {
+		t.Fatalf("unexpected message data: %q", srv.data)
+	}
+}
+
+func TestSMTPSender_RejectsHeaderInjection(t *testing.T) {
+	sender := NewSMTPSender(SMTPConfig{Host: "127.0.0.1", Port: 1, From: "no-reply@example.com"})
+
+	err := sender.Send(context.Background(), Message{To: "jane@example.com\r\nBcc: everyone@example.com", Subject: "Hi"})
+	if err == nil {
+		t.Fatalf("expected recipient with a line break to be refused")
+	}
+}
+
+func TestSMTPSender_UnreachableServer(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	sender := NewSMTPSender(SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "no-reply@example.com"})
+	if err := sender.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi"}); err == nil {
+		t.Fatalf("expected an error when the SMTP server is down")
+	}
+}
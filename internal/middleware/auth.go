@@ -1,14 +1,26 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/auth"
+	"github.com/eddie-wainaina1/maggiesb/internal/database"
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware validates JWT tokens from Authorization header
+// validateToken and accountSuspended are variables so tests can run without a database
+var (
+	validateToken    = auth.ValidateToken
+	accountSuspended = func(ctx context.Context, userID string) (bool, error) {
+		return database.NewUserRepository().IsUserSuspended(ctx, userID)
+	}
+)
+
+// AuthMiddleware validates JWT tokens from Authorization header, and refuses users
+// whose account is suspended
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -27,32 +39,53 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := parts[1]
-		claims, err := auth.ValidateToken(tokenString)
+		claims, err := validateToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
 			c.Abort()
 			return
 		}
 
+		suspended, err := accountSuspended(c.Request.Context(), claims.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check account status"})
+			c.Abort()
+			return
+		}
+		if suspended {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account suspended", "code": "account_suspended"})
+			c.Abort()
+			return
+		}
+
 		// Store claims in context for use in handlers
 		c.Set("userID", claims.UserID)
 		c.Set("email", claims.Email)
 		c.Set("role", claims.Role)
+		c.Set("roles", claims.RoleNames())
+		c.Set("sessionID", claims.SessionID)
+		c.Set("authMethods", claims.AuthMethods)
 
 		c.Next()
 	}
 }
 
+// RequireRole lets through users who have requiredRole among their roles. Prefer
+// handlers.RequirePermission, which follows changes to roles without a new token.
 func RequireRole(requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
+		roles, exists := c.Get("roles")
 		if !exists {
-			c.JSON(http.StatusForbidden, gin.H{"error": "role information not found"})
-			c.Abort()
-			return
+			role, ok := c.Get("role")
+			if !ok {
+				c.JSON(http.StatusForbidden, gin.H{"error": "role information not found"})
+				c.Abort()
+				return
+			}
+			roles = []string{role.(string)}
 		}
 
-		if role.(string) != requiredRole {
+		if !slices.Contains(roles.([]string), requiredRole) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
 			c.Abort()
 			return
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -201,3 +202,65 @@ func TestRequireRole_MultipleRoles(t *testing.T) {
 
 	assert.Equal(t, http.StatusForbidden, rec.Code)
 }
+
+func TestRequireRole_AnyOfSeveralRoles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tc := range []struct {
+		roles []string
+		want  int
+	}{
+		{[]string{"user", "warehouse"}, http.StatusOK},
+		{[]string{"finance"}, http.StatusForbidden},
+	} {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("role", tc.roles[0])
+			c.Set("roles", tc.roles)
+			c.Next()
+		})
+		router.GET("/warehouse", RequireRole("warehouse"), func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+		})
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/warehouse", nil))
+
+		assert.Equal(t, tc.want, rec.Code, "roles %v", tc.roles)
+	}
+}
+
+func TestAuthMiddleware_RejectsSuspendedUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oldValidate, oldSuspended := validateToken, accountSuspended
+	t.Cleanup(func() { validateToken, accountSuspended = oldValidate, oldSuspended })
+
+	validateToken = func(tokenString string) (*auth.Claims, error) {
+		return &auth.Claims{UserID: tokenString, Role: "user"}, nil
+	}
+	accountSuspended = func(ctx context.Context, userID string) (bool, error) {
+		return userID == "suspended-user", nil
+	}
+
+	router := gin.New()
+	router.Use(AuthMiddleware())
+	router.GET("/protected", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	for _, tc := range []struct {
+		userID string
+		want   int
+	}{
+		{"active-user", http.StatusOK},
+		{"suspended-user", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.userID)
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, tc.want, rec.Code, tc.userID)
+	}
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// AccountToken is a one-time token: emailed to a user, proving they control the
+// address when they follow the link, or handed out after the password step of a
+// two-factor login. Only a hash of the token is stored.
+type AccountToken struct {
+	ID        string     `json:"-" bson:"_id"` // sha256 of the token
+	UserID    string     `json:"userId" bson:"userId"`
+	Purpose   string     `json:"purpose" bson:"purpose"`
+	Email     string     `json:"email" bson:"email"` // address the token was sent to
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+}
+
+// AccountToken purposes
+const (
+	AccountTokenPasswordReset     = "password_reset"
+	AccountTokenEmailVerification = "email_verification"
+	AccountTokenLoginChallenge    = "login_challenge"
+)
+
+// Usable reports whether the token can still be redeemed
+func (t *AccountToken) Usable() bool {
+	return t.UsedAt == nil && time.Now().Before(t.ExpiresAt)
+}
+
+// ForgotPasswordRequest asks for a password reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest sets a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// VerifyEmailRequest confirms an email address with a verification token
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
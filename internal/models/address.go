@@ -0,0 +1,122 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// GeoPoint is a GPS position
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude" bson:"latitude" binding:"gte=-90,lte=90"`
+	Longitude float64 `json:"longitude" bson:"longitude" binding:"gte=-180,lte=180"`
+}
+
+// Address is a place in a user's address book that orders can be delivered to
+type Address struct {
+	ID           string    `json:"id" bson:"_id"`
+	UserID       string    `json:"userId" bson:"userId"`
+	Label        string    `json:"label" bson:"label"` // e.g. "Home" or "Office"
+	County       string    `json:"county" bson:"county"`
+	Town         string    `json:"town" bson:"town"`
+	Landmark     string    `json:"landmark" bson:"landmark"` // building, estate or nearby landmark
+	Details      string    `json:"details" bson:"details"`   // house number, floor, gate
+	Location     *GeoPoint `json:"location,omitempty" bson:"location,omitempty"`
+	ContactName  string    `json:"contactName" bson:"contactName"`
+	ContactPhone string    `json:"contactPhone" bson:"contactPhone"`
+	IsDefault    bool      `json:"isDefault" bson:"isDefault"`
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Summary is the address on one line, e.g. for OrderMetadata.LocationDetails
+func (a *Address) Summary() string {
+	var parts []string
+	for _, part := range []string{a.Details, a.Landmark, a.Town, a.County} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// AddressRequest creates or replaces an address
+type AddressRequest struct {
+	Label        string    `json:"label" binding:"max=50"`
+	County       string    `json:"county" binding:"required,max=50"`
+	Town         string    `json:"town" binding:"required,max=100"`
+	Landmark     string    `json:"landmark" binding:"max=200"`
+	Details      string    `json:"details" binding:"max=200"`
+	Location     *GeoPoint `json:"location"`
+	ContactName  string    `json:"contactName" binding:"max=100"`
+	ContactPhone string    `json:"contactPhone" binding:"required,max=20"`
+	IsDefault    bool      `json:"isDefault"`
+}
+
+// DeliveryZone sets the delivery fee and lead time for a county, or for some
+// towns in it
+type DeliveryZone struct {
+	ID            string    `json:"id" bson:"_id"`
+	Name          string    `json:"name" bson:"name"`
+	County        string    `json:"county" bson:"county"`
+	Towns         []string  `json:"towns" bson:"towns"` // empty covers the whole county
+	Fee           float64   `json:"fee" bson:"fee"`
+	LeadTimeHours int       `json:"leadTimeHours" bson:"leadTimeHours"` // from order to delivery
+	Active        bool      `json:"active" bson:"active"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// covers reports whether the zone delivers to the address, and whether it names
+// the address's town rather than covering its whole county
+func (z *DeliveryZone) covers(address *Address) (covered, byTown bool) {
+	if !z.Active || !strings.EqualFold(strings.TrimSpace(z.County), strings.TrimSpace(address.County)) {
+		return false, false
+	}
+	if len(z.Towns) == 0 {
+		return true, false
+	}
+	for _, town := range z.Towns {
+		if strings.EqualFold(strings.TrimSpace(town), strings.TrimSpace(address.Town)) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// MatchDeliveryZone returns the active zone that delivers to address, or nil. A
+// zone naming the address's town wins over one covering its whole county.
+func MatchDeliveryZone(zones []*DeliveryZone, address *Address) *DeliveryZone {
+	var countyZone *DeliveryZone
+	for _, zone := range zones {
+		covered, byTown := zone.covers(address)
+		if byTown {
+			return zone
+		}
+		if covered && countyZone == nil {
+			countyZone = zone
+		}
+	}
+	return countyZone
+}
+
+// DeliveryZoneRequest creates or replaces a delivery zone
+type DeliveryZoneRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	County        string   `json:"county" binding:"required,max=50"`
+	Towns         []string `json:"towns"`
+	Fee           float64  `json:"fee" binding:"gte=0"`
+	LeadTimeHours int      `json:"leadTimeHours" binding:"gte=0"`
+	Active        *bool    `json:"active"` // defaults to true
+}
+
+// OrderDelivery records where an order is delivered and what it cost, as it was
+// when the order was placed
+type OrderDelivery struct {
+	AddressID     string    `json:"addressId" bson:"addressId"`
+	Address       *Address  `json:"address,omitempty" bson:"address,omitempty"` // removed when the customer deletes their account
+	ZoneID        string    `json:"zoneId" bson:"zoneId"`
+	ZoneName      string    `json:"zoneName" bson:"zoneName"`
+	Fee           float64   `json:"fee" bson:"fee"`
+	LeadTimeHours int       `json:"leadTimeHours" bson:"leadTimeHours"`
+	ExpectedBy    time.Time `json:"expectedBy" bson:"expectedBy"`
+}
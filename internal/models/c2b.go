@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// C2BNotification is the payload M-Pesa posts to the C2B validation and
+// confirmation URLs when a customer pays the Paybill or Till number directly
+type C2BNotification struct {
+	TransactionType   string `json:"TransactionType"`
+	TransID           string `json:"TransID" binding:"required"`
+	TransTime         string `json:"TransTime"` // yyyyMMddHHmmss, East Africa Time
+	TransAmount       string `json:"TransAmount" binding:"required"`
+	BusinessShortCode string `json:"BusinessShortCode"`
+	BillRefNumber     string `json:"BillRefNumber"` // account number the customer typed
+	InvoiceNumber     string `json:"InvoiceNumber"`
+	OrgAccountBalance string `json:"OrgAccountBalance"`
+	ThirdPartyTransID string `json:"ThirdPartyTransID"`
+	MSISDN            string `json:"MSISDN"`
+	FirstName         string `json:"FirstName"`
+	MiddleName        string `json:"MiddleName"`
+	LastName          string `json:"LastName"`
+}
+
+// C2B transaction status values
+const (
+	C2BStatusCredited  = "credited"  // matched to an invoice by BillRefNumber and credited
+	C2BStatusSuspense  = "suspense"  // no matching invoice; waiting for an admin to allocate it
+	C2BStatusAllocated = "allocated" // credited to an invoice by an admin
+)
+
+// C2BTransaction records a confirmed Paybill/Till payment
+type C2BTransaction struct {
+	ID              string     `json:"id" bson:"_id"`
+	TransID         string     `json:"transId" bson:"transId"` // M-Pesa receipt number
+	TransactionType string     `json:"transactionType" bson:"transactionType"`
+	TransTime       string     `json:"transTime" bson:"transTime"`
+	TransactedAt    *time.Time `json:"transactedAt,omitempty" bson:"transactedAt,omitempty"`
+	Amount          float64    `json:"amount" bson:"amount"`
+	ShortCode       string     `json:"shortCode" bson:"shortCode"`
+	BillRefNumber   string     `json:"billRefNumber" bson:"billRefNumber"`
+	Phone           string     `json:"phone" bson:"phone"`
+	PayerName       string     `json:"payerName" bson:"payerName"`
+	InvoiceID       string     `json:"invoiceId,omitempty" bson:"invoiceId,omitempty"`
+	Status          string     `json:"status" bson:"status"`
+	AmountMismatch  string     `json:"amountMismatch,omitempty" bson:"amountMismatch,omitempty"` // "overpaid" when more than the invoice balance
+	AllocatedBy     string     `json:"allocatedBy,omitempty" bson:"allocatedBy,omitempty"`
+	AllocationNote  string     `json:"allocationNote,omitempty" bson:"allocationNote,omitempty"`
+	AllocatedAt     *time.Time `json:"allocatedAt,omitempty" bson:"allocatedAt,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
+
+// AllocateC2BRequest payload for an admin allocating a suspense payment to an invoice
+type AllocateC2BRequest struct {
+	InvoiceID string `json:"invoiceId" binding:"required"`
+	Note      string `json:"note"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Sources of Daraja callbacks
+const (
+	CallbackSourceSTKPush  = "stk_push"
+	CallbackSourceReversal = "reversal"
+	CallbackSourceB2C      = "b2c"
+	CallbackSourceC2B      = "c2b"
+)
+
+// Reasons a Daraja callback was rejected
+const (
+	CallbackRejectIPNotAllowed  = "ip_not_allowed"        // sender is outside the configured allowlist
+	CallbackRejectInvalidBody   = "invalid_body"          // payload could not be parsed
+	CallbackRejectUnknown       = "unknown_reference"     // no payment, reversal or refund matches
+	CallbackRejectMissingToken  = "missing_token"         // callback URL carried no token but one is required
+	CallbackRejectTokenMismatch = "token_mismatch"        // callback URL token does not belong to the payment
+	CallbackRejectReplayWindow  = "outside_replay_window" // arrived too long after the request it answers
+
+	CallbackRejectUnauthenticated = "unauthenticated"     // no callback token or sender allowlist is configured to trust it
+	CallbackRejectShortCode       = "short_code_mismatch" // C2B payment to a short code other than the shop's
+)
+
+// RejectedCallback records a callback the shop refused, so forged or replayed
+// requests can be investigated
+type RejectedCallback struct {
+	ID        string    `json:"id" bson:"_id"`
+	Source    string    `json:"source" bson:"source"`
+	Reason    string    `json:"reason" bson:"reason"`
+	RemoteIP  string    `json:"remoteIp" bson:"remoteIp"`
+	Path      string    `json:"path" bson:"path"`                               // request path with any callback token masked
+	Reference string    `json:"reference,omitempty" bson:"reference,omitempty"` // CheckoutRequestID or ConversationID the callback named
+	Body      string    `json:"body,omitempty" bson:"body,omitempty"`           // payload as received, truncated
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// CartItem is a product and quantity held in a user's cart. Prices are not
+// stored; they are looked up from the product catalogue whenever the cart is read.
+type CartItem struct {
+	ProductID string    `json:"productId" bson:"productId"`
+	Quantity  int       `json:"quantity" bson:"quantity"`
+	AddedAt   time.Time `json:"addedAt" bson:"addedAt"`
+}
+
+// Cart is a user's persistent shopping cart (one per user)
+type Cart struct {
+	UserID    string     `json:"userId" bson:"_id"`
+	Items     []CartItem `json:"items" bson:"items"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
+
+// PricedCartItem is a cart line priced against the current product catalogue
+type PricedCartItem struct {
+	ProductID   string  `json:"productId"`
+	Name        string  `json:"name"`
+	Quantity    int     `json:"quantity"`
+	Price       float64 `json:"price"`       // current unit price
+	Discount    float64 `json:"discount"`    // absolute discount for the line
+	LineTotal   float64 `json:"lineTotal"`   // price * quantity - discount
+	Available   int     `json:"available"`   // units currently in stock
+	Unavailable bool    `json:"unavailable"` // product no longer exists
+}
+
+// CartView is the priced representation of a cart returned to clients
+type CartView struct {
+	UserID    string           `json:"userId"`
+	Items     []PricedCartItem `json:"items"`
+	Cost      float64          `json:"cost"`      // total before discounts
+	Discount  float64          `json:"discount"`  // total discount
+	TotalCost float64          `json:"totalCost"` // final cost after discounts
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+// AddCartItemRequest adds units of a product to the cart
+type AddCartItemRequest struct {
+	ProductID string `json:"productId" binding:"required"`
+	Quantity  int    `json:"quantity" binding:"required,gt=0"`
+}
+
+// UpdateCartItemRequest sets the quantity of a product already in the cart
+type UpdateCartItemRequest struct {
+	Quantity int `json:"quantity" binding:"required,gt=0"`
+}
+
+// CheckoutRequest converts the cart into an order
+type CheckoutRequest struct {
+	Phone           string         `json:"phone" binding:"required"`
+	CouponCode      string         `json:"couponCode"`
+	PaymentProvider string         `json:"paymentProvider" binding:"omitempty,oneof=mpesa cod card"` // defaults to the shop's default provider
+	AddressID       string         `json:"addressId"`                                                // address book entry to deliver to; the customer collects the order if empty
+	Metadata        *OrderMetadata `json:"metadata"`
+}
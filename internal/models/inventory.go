@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// StockAdjustment is an audit record for a manual change to a product's stock level
+type StockAdjustment struct {
+	ID         string    `json:"id" bson:"_id"`
+	ProductID  string    `json:"productId" bson:"productId"`
+	Delta      int       `json:"delta" bson:"delta"`           // positive for restocks, negative for shrinkage
+	StockAfter int       `json:"stockAfter" bson:"stockAfter"` // stock level once the adjustment was applied
+	Reason     string    `json:"reason" bson:"reason"`
+	AdminID    string    `json:"adminId" bson:"adminId"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// AdjustStockRequest is used by admin to restock or write off units of a product
+type AdjustStockRequest struct {
+	Delta  int    `json:"delta" binding:"required"`  // non-zero change to apply
+	Reason string `json:"reason" binding:"required"` // e.g. "restock", "damaged", "stock count"
+}
+
+// StockShortage describes a product that cannot satisfy a requested quantity
+type StockShortage struct {
+	ProductID string `json:"productId"`
+	Name      string `json:"name"`
+	Requested int    `json:"requested"`
+	Available int    `json:"available"`
+}
@@ -9,8 +9,10 @@ type Invoice struct {
 	InvoiceAmount float64            `json:"invoiceAmount" bson:"invoiceAmount"` // total invoice amount
 	PaidAmount    float64            `json:"paidAmount" bson:"paidAmount"`       // total amount paid so far
 	TaxAmount     float64            `json:"taxAmount" bson:"taxAmount"`         // tax applied (default 0)
+	DeliveryFee   float64            `json:"deliveryFee" bson:"deliveryFee"`     // part of InvoiceAmount charged for delivery
 	Type          string             `json:"type" bson:"type"`                   // "payable" or "receivable"
 	PaidOn        map[string]float64 `json:"paidOn" bson:"paidOn"`               // map of dates (YYYY-MM-DD) to amounts paid
+	PaymentProvider string           `json:"paymentProvider" bson:"paymentProvider"` // "mpesa", "cod" or "card"; empty on older invoices means "mpesa"
 	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt     time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
@@ -42,6 +44,7 @@ type ReverseInvoiceRequest struct {
 	Date     string  `json:"date"`                             // optional YYYY-MM-DD
 	Phone    string  `json:"phone"`                            // customer phone for M-Pesa reversal (optional)
 	UseMpesa bool    `json:"useMpesa"`                         // attempt M-Pesa reversal when true
+	TransactionID string `json:"transactionId"`               // M-Pesa receipt to reverse; defaults to the latest completed payment
 	Reason   string  `json:"reason" binding:"required"`       // reason for reversal
 }
 
@@ -55,5 +58,28 @@ type ReversalRecord struct {
 	AdminID   string    `json:"adminId" bson:"adminId"`
 	Reason    string    `json:"reason" bson:"reason"`
 	ReceiptURL string   `json:"receiptUrl,omitempty" bson:"receiptUrl,omitempty"`
+	Status    string    `json:"status" bson:"status"` // "pending", "completed", "failed", "timed_out"
+	// M-Pesa reversal details; empty for manual reversals
+	TransactionID            string     `json:"transactionId,omitempty" bson:"transactionId,omitempty"` // receipt being reversed
+	ConversationID           string     `json:"conversationId,omitempty" bson:"conversationId,omitempty"`
+	OriginatorConversationID string     `json:"originatorConversationId,omitempty" bson:"originatorConversationId,omitempty"`
+	CallbackTokenHash        string     `bson:"callbackTokenHash,omitempty" json:"-"` // hash of the token in the result URLs
+	ResultCode               int        `json:"resultCode,omitempty" bson:"resultCode,omitempty"`
+	ResultDesc               string     `json:"resultDesc,omitempty" bson:"resultDesc,omitempty"`
+	ResultTransactionID      string     `json:"resultTransactionId,omitempty" bson:"resultTransactionId,omitempty"` // M-Pesa ID of the reversal itself
+	CompletedAt              *time.Time `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+	// Money taken off the invoice that must still be paid back to the customer (e.g.
+	// returned orders); settled by B2C refunds
+	RefundOwed     float64 `json:"refundOwed,omitempty" bson:"refundOwed,omitempty"`
+	RefundedAmount float64 `json:"refundedAmount,omitempty" bson:"refundedAmount,omitempty"`
 	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
 }
+
+// Reversal status values. M-Pesa reversals stay pending until Safaricom reports the
+// outcome; the invoice is only adjusted once a reversal completes.
+const (
+	ReversalStatusPending   = "pending"
+	ReversalStatusCompleted = "completed"
+	ReversalStatusFailed    = "failed"
+	ReversalStatusTimedOut  = "timed_out"
+)
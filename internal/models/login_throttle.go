@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// LoginThrottle counts recent failed logins for one account or one client IP.
+// The count starts over once there has been no failure, and no lockout, for the
+// failure window.
+type LoginThrottle struct {
+	ID            string     `json:"id" bson:"_id"` // "account:<email>" or "ip:<address>"
+	Scope         string     `json:"scope" bson:"scope"`
+	Subject       string     `json:"subject" bson:"subject"` // the email or IP address
+	Failures      int        `json:"failures" bson:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt" bson:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+	ExpiresAt     time.Time  `json:"-" bson:"expiresAt"` // when the record can be forgotten
+}
+
+// What a login throttle counts failures for
+const (
+	LoginThrottleAccount = "account"
+	LoginThrottleIP      = "ip"
+)
+
+// Locked reports whether logins are refused until LockedUntil
+func (t *LoginThrottle) Locked(now time.Time) bool {
+	return t.LockedUntil != nil && now.Before(*t.LockedUntil)
+}
+
+// AuthEvent is an audit entry for a security-relevant change to how someone can sign in
+type AuthEvent struct {
+	ID          string     `json:"id" bson:"_id"`
+	Type        string     `json:"type" bson:"type"`
+	UserID      string     `json:"userId,omitempty" bson:"userId,omitempty"`
+	Email       string     `json:"email,omitempty" bson:"email,omitempty"`
+	IP          string     `json:"ip,omitempty" bson:"ip,omitempty"`
+	ActorID     string     `json:"actorId,omitempty" bson:"actorId,omitempty"` // admin who made the change
+	Failures    int        `json:"failures,omitempty" bson:"failures,omitempty"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+	Roles       []string   `json:"roles,omitempty" bson:"roles,omitempty"` // the user's new roles
+	Reason      string     `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt" bson:"createdAt"`
+}
+
+// Auth event types
+const (
+	AuthEventAccountLocked = "account_locked"
+	AuthEventIPLocked      = "ip_locked"
+	AuthEventUnlocked      = "unlocked"
+
+	AuthEventTwoFactorEnabled     = "two_factor_enabled"
+	AuthEventTwoFactorDisabled    = "two_factor_disabled"
+	AuthEventRecoveryCodeUsed     = "recovery_code_used"
+	AuthEventRecoveryCodesRenewed = "recovery_codes_renewed"
+
+	AuthEventRolesChanged = "roles_changed"
+	AuthEventSuspended    = "suspended"
+	AuthEventReactivated  = "reactivated"
+
+	AuthEventPasswordChanged = "password_changed"
+	AuthEventAccountDeleted  = "account_deleted"
+)
+
+// LoginThrottleKey is the ID of the throttle counting failures for an account or IP
+func LoginThrottleKey(scope, subject string) string {
+	return scope + ":" + subject
+}
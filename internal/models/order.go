@@ -12,7 +12,7 @@ type OrderItem struct {
 
 // OrderMetadata holds additional order metadata
 type OrderMetadata struct {
-	Discounts      map[string]float64 `json:"discounts" bson:"discounts"`           // per-product absolute discount amounts
+	Discounts      map[string]float64 `json:"discounts,omitempty" bson:"discounts,omitempty"` // deprecated: ignored on new orders, discounts come from promotions
 	Notes          string             `json:"notes" bson:"notes"`
 	LocationDetails string            `json:"locationDetails" bson:"locationDetails"`
 }
@@ -23,11 +23,16 @@ type Order struct {
 	Products   []OrderItem    `json:"products" bson:"products"`
 	Cost       float64        `json:"cost" bson:"cost"`                   // total before discounts
 	Discount   float64        `json:"discount" bson:"discount"`           // total discount applied at creation (absolute)
-	TotalCost  float64        `json:"totalCost" bson:"totalCost"`         // final cost after discounts
+	TotalCost  float64        `json:"totalCost" bson:"totalCost"`         // final cost after discounts, plus the delivery fee
+	DeliveryFee float64       `json:"deliveryFee" bson:"deliveryFee"`     // charged for delivery; 0 when the customer collects the order
 	Status     string         `json:"status" bson:"status"`
 	UserID     string         `json:"user" bson:"user"`
 	Phone      string         `json:"phone" bson:"phone"`
 	Metadata   OrderMetadata  `json:"metadata" bson:"metadata"`
+	Delivery   *OrderDelivery `json:"delivery,omitempty" bson:"delivery,omitempty"` // nil when the customer collects the order
+	StockReserved bool        `json:"stockReserved" bson:"stockReserved"` // true while the order holds product stock
+	Promotion  *AppliedPromotion `json:"promotion,omitempty" bson:"promotion,omitempty"` // coupon redeemed on this order, if any
+	ShipmentVersion int         `json:"-" bson:"shipmentVersion"` // counts shipments created, so concurrent ones cannot ship the same items
 	CreatedAt  time.Time      `json:"createdAt" bson:"createdAt"`
 	UpdatedAt  time.Time      `json:"updatedAt" bson:"updatedAt"`
 }
@@ -50,6 +55,9 @@ type CreateOrderRequest struct {
 		Quantity  int    `json:"quantity" binding:"required,gt=0"`
 	} `json:"products" binding:"required,min=1"`
 	Phone    string            `json:"phone" binding:"required"`
+	CouponCode string          `json:"couponCode"`
+	PaymentProvider string     `json:"paymentProvider" binding:"omitempty,oneof=mpesa cod card"` // defaults to the shop's default provider
+	AddressID string           `json:"addressId"` // address book entry to deliver to; the customer collects the order if empty
 	Metadata *OrderMetadata   `json:"metadata"`
 }
 
@@ -1,9 +1,12 @@
 package models
 
-// MpesaPaymentRequest payload to initiate M-Pesa payment
-type MpesaPaymentRequest struct {
-	InvoiceID string `json:"invoiceId" binding:"required"`
-	Phone     string `json:"phone" binding:"required,min=10"`
+import "time"
+
+// PaymentRequest payload to pay an invoice with its payment provider
+type PaymentRequest struct {
+	InvoiceID string  `json:"invoiceId" binding:"required"`
+	Phone     string  `json:"phone" binding:"omitempty,min=10"` // phone to prompt for M-Pesa; defaults to the order's phone
+	Amount    float64 `json:"amount" binding:"omitempty,gt=0"` // optional partial payment; defaults to the outstanding balance
 }
 
 // MpesaPaymentResponse from STK Push initiation
@@ -32,18 +35,112 @@ type MpesaCallback struct {
 	} `json:"Body"`
 }
 
-// PaymentRecord stores M-Pesa payment transaction details
+// MpesaResultParameter is one key/value item in an asynchronous M-Pesa result
+type MpesaResultParameter struct {
+	Key   string      `json:"Key"`
+	Value interface{} `json:"Value"`
+}
+
+// MpesaResult is the payload M-Pesa posts to the ResultURL or QueueTimeOutURL of
+// asynchronous requests such as reversals
+type MpesaResult struct {
+	Result MpesaResultBody `json:"Result" binding:"required"`
+}
+
+// MpesaResultBody carries the outcome of an asynchronous M-Pesa request
+type MpesaResultBody struct {
+	ResultType               int    `json:"ResultType"`
+	ResultCode               int    `json:"ResultCode"`
+	ResultDesc               string `json:"ResultDesc"`
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	ConversationID           string `json:"ConversationID"`
+	TransactionID            string `json:"TransactionID"`
+	ResultParameters         struct {
+		ResultParameter []MpesaResultParameter `json:"ResultParameter"`
+	} `json:"ResultParameters"`
+}
+
+// PaymentRecord stores the details of a payment attempt with any provider
 type PaymentRecord struct {
 	ID                 string `bson:"_id" json:"id"`
 	InvoiceID          string `bson:"invoiceId" json:"invoiceId"`
 	OrderID            string `bson:"orderId" json:"orderId"`
-	CheckoutRequestID  string `bson:"checkoutRequestId" json:"checkoutRequestId"`
+	Provider           string `bson:"provider" json:"provider"` // "mpesa", "cod" or "card"; empty on older records means "mpesa"
+	Method             string `bson:"method,omitempty" json:"method,omitempty"` // how the customer paid, e.g. "mpesa_stk", "cash", "card"
+	CheckoutRequestID  string `bson:"checkoutRequestId" json:"checkoutRequestId"` // provider's reference: STK CheckoutRequestID, card charge ID or cash reference
 	MerchantRequestID  string `bson:"merchantRequestId" json:"merchantRequestId"`
+	CallbackTokenHash  string `bson:"callbackTokenHash,omitempty" json:"-"` // SHA-256 of the token in the M-Pesa CallBackURL
 	Phone              string `bson:"phone" json:"phone"`
 	Amount             float64 `bson:"amount" json:"amount"`
 	MpesaReceiptNumber string `bson:"mpesaReceiptNumber" json:"mpesaReceiptNumber"`
-	TransactionDate    string `bson:"transactionDate" json:"transactionDate"`
-	Status             string `bson:"status" json:"status"` // "initiated", "completed", "failed"
+	Receipt            string `bson:"receipt,omitempty" json:"receipt,omitempty"` // receipt from providers other than M-Pesa
+	CollectedBy        string `bson:"collectedBy,omitempty" json:"collectedBy,omitempty"` // admin who confirmed a cash collection
+	TransactionDate    string `bson:"transactionDate" json:"transactionDate"` // raw provider timestamp, e.g. yyyyMMddHHmmss from M-Pesa
+	TransactedAt       *time.Time `bson:"transactedAt,omitempty" json:"transactedAt,omitempty"`
+	PaidAmount         float64 `bson:"paidAmount" json:"paidAmount"` // amount the provider actually collected
+	PayerPhone         string `bson:"payerPhone,omitempty" json:"payerPhone,omitempty"`
+	AmountMismatch     string `bson:"amountMismatch,omitempty" json:"amountMismatch,omitempty"` // "underpaid" or "overpaid"
+	NeedsReview        bool   `bson:"needsReview" json:"needsReview"`
+	ReviewedBy         string `bson:"reviewedBy,omitempty" json:"reviewedBy,omitempty"`
+	ReviewNote         string `bson:"reviewNote,omitempty" json:"reviewNote,omitempty"`
+	ReviewedAt         string `bson:"reviewedAt,omitempty" json:"reviewedAt,omitempty"`
+	Status             string `bson:"status" json:"status"` // "initiated", "awaiting_collection", "completed", "failed", "expired", "reversed"
 	CreatedAt          string `bson:"createdAt" json:"createdAt"`
 	UpdatedAt          string `bson:"updatedAt" json:"updatedAt"`
 }
+
+// Payment providers an invoice can be paid with
+const (
+	PaymentProviderMpesa = "mpesa"
+	PaymentProviderCOD   = "cod"
+	PaymentProviderCard  = "card"
+)
+
+// Payment record status values
+const (
+	PaymentStatusInitiated = "initiated"
+	PaymentStatusAwaitingCollection = "awaiting_collection" // cash on delivery, until staff confirm collection
+	PaymentStatusCompleted = "completed"
+	PaymentStatusFailed    = "failed"
+	PaymentStatusExpired   = "expired" // no result from M-Pesa before the reconciler gave up
+	PaymentStatusReversed  = "reversed"
+)
+
+// Amount mismatch values, set when M-Pesa collected a different amount than was requested
+const (
+	AmountMismatchUnderpaid = "underpaid"
+	AmountMismatchOverpaid  = "overpaid" // more than requested, or more than the invoice balance
+)
+
+// PaymentSettlement carries the transaction details a provider reports for a settled payment
+type PaymentSettlement struct {
+	MpesaReceiptNumber string
+	Receipt            string // receipt from providers other than M-Pesa
+	Method             string // left unchanged when empty
+	CollectedBy        string
+	TransactionDate    string
+	TransactedAt       *time.Time
+	PaidAmount         float64
+	PayerPhone         string
+	AmountMismatch     string
+}
+
+// CollectPaymentRequest payload for an admin confirming cash collected on delivery
+type CollectPaymentRequest struct {
+	Amount float64 `json:"amount" binding:"omitempty,gt=0"` // defaults to the amount due on the payment
+}
+
+// ReviewPaymentRequest payload for an admin resolving a flagged payment
+type ReviewPaymentRequest struct {
+	Note string `json:"note" binding:"required"`
+}
+
+// PaymentSearchQuery contains the admin filters for searching payment records
+type PaymentSearchQuery struct {
+	Phone     string `form:"phone"`     // matches the phone charged or the phone that paid
+	Receipt   string `form:"receipt"`   // M-Pesa or other provider receipt number
+	Provider  string `form:"provider"`  // "mpesa", "cod" or "card"
+	Status    string `form:"status"`
+	StartDate string `form:"startDate"` // YYYY-MM-DD, inclusive
+	EndDate   string `form:"endDate"`   // YYYY-MM-DD, inclusive
+}
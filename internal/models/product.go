@@ -8,6 +8,7 @@ type Product struct {
 	Description string    `json:"description" bson:"description"`
 	Price       float64   `json:"price" bson:"price"`
 	Discount    float64   `json:"discount" bson:"discount"`
+	Stock       int       `json:"stock" bson:"stock"` // units currently available for sale
 	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
 }
@@ -17,6 +18,7 @@ type CreateProductRequest struct {
 	Description string  `json:"description" binding:"required,min=1"`
 	Price       float64 `json:"price" binding:"required,gt=0"`
 	Discount    float64 `json:"discount" binding:"min=0,max=100"`
+	Stock       int     `json:"stock" binding:"min=0"`
 }
 
 type UpdateProductRequest struct {
@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// Promotion types
+const (
+	PromotionTypePercentage = "percentage"  // percentage off eligible items
+	PromotionTypeFixed      = "fixed"       // absolute amount off eligible items
+	PromotionTypeBuyXGetY   = "buy_x_get_y" // buy X units, get Y units of the same product free
+)
+
+// Promotion is an admin-managed coupon code and the discount rule it grants
+type Promotion struct {
+	ID                    string     `json:"id" bson:"_id"`
+	Code                  string     `json:"code" bson:"code"` // stored upper-case
+	Description           string     `json:"description" bson:"description"`
+	Type                  string     `json:"type" bson:"type"`
+	Value                 float64    `json:"value" bson:"value"`                               // percent (0-100] or absolute amount
+	ProductIDs            []string   `json:"productIds,omitempty" bson:"productIds,omitempty"` // eligible products; empty means the whole basket
+	BuyQuantity           int        `json:"buyQuantity,omitempty" bson:"buyQuantity,omitempty"`
+	GetQuantity           int        `json:"getQuantity,omitempty" bson:"getQuantity,omitempty"`
+	MinBasketValue        float64    `json:"minBasketValue" bson:"minBasketValue"`
+	StartsAt              *time.Time `json:"startsAt,omitempty" bson:"startsAt,omitempty"`
+	EndsAt                *time.Time `json:"endsAt,omitempty" bson:"endsAt,omitempty"`
+	MaxRedemptions        int        `json:"maxRedemptions" bson:"maxRedemptions"`               // global limit, 0 = unlimited
+	MaxRedemptionsPerUser int        `json:"maxRedemptionsPerUser" bson:"maxRedemptionsPerUser"` // 0 = unlimited
+	RedemptionCount       int        `json:"redemptionCount" bson:"redemptionCount"`
+	Active                bool       `json:"active" bson:"active"`
+	CreatedBy             string     `json:"createdBy" bson:"createdBy"`
+	CreatedAt             time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt             time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
+
+// PromotionRedemption records a promotion used on an order
+type PromotionRedemption struct {
+	ID          string    `json:"id" bson:"_id"`
+	PromotionID string    `json:"promotionId" bson:"promotionId"`
+	Code        string    `json:"code" bson:"code"`
+	UserID      string    `json:"userId" bson:"userId"`
+	OrderID     string    `json:"orderId" bson:"orderId"`
+	Discount    float64   `json:"discount" bson:"discount"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// AppliedPromotion is the snapshot of a promotion stored on an order
+type AppliedPromotion struct {
+	PromotionID  string  `json:"promotionId" bson:"promotionId"`
+	Code         string  `json:"code" bson:"code"`
+	RedemptionID string  `json:"redemptionId" bson:"redemptionId"`
+	Discount     float64 `json:"discount" bson:"discount"`
+	Released     bool    `json:"released,omitempty" bson:"released,omitempty"` // the use was given back when the order was cancelled or returned
+}
+
+// CreatePromotionRequest payload to create a promotion (admin)
+type CreatePromotionRequest struct {
+	Code                  string     `json:"code" binding:"required,min=3,max=32"`
+	Description           string     `json:"description"`
+	Type                  string     `json:"type" binding:"required,oneof=percentage fixed buy_x_get_y"`
+	Value                 float64    `json:"value" binding:"min=0"`
+	ProductIDs            []string   `json:"productIds"`
+	BuyQuantity           int        `json:"buyQuantity" binding:"min=0"`
+	GetQuantity           int        `json:"getQuantity" binding:"min=0"`
+	MinBasketValue        float64    `json:"minBasketValue" binding:"min=0"`
+	StartsAt              *time.Time `json:"startsAt"`
+	EndsAt                *time.Time `json:"endsAt"`
+	MaxRedemptions        int        `json:"maxRedemptions" binding:"min=0"`
+	MaxRedemptionsPerUser int        `json:"maxRedemptionsPerUser" binding:"min=0"`
+}
+
+// UpdatePromotionRequest payload to change a promotion (admin). Only provided fields are updated.
+type UpdatePromotionRequest struct {
+	Description           *string    `json:"description"`
+	Active                *bool      `json:"active"`
+	MinBasketValue        *float64   `json:"minBasketValue" binding:"omitempty,min=0"`
+	StartsAt              *time.Time `json:"startsAt"`
+	EndsAt                *time.Time `json:"endsAt"`
+	MaxRedemptions        *int       `json:"maxRedemptions" binding:"omitempty,min=0"`
+	MaxRedemptionsPerUser *int       `json:"maxRedemptionsPerUser" binding:"omitempty,min=0"`
+}
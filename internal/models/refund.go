@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// Refund is a payout returning money owed to a customer, by M-Pesa B2C or to the
+// card that paid. Each refund settles part or all of the refund owed on a ReversalRecord.
+type Refund struct {
+	ID                       string     `json:"id" bson:"_id"`
+	Provider                 string     `json:"provider" bson:"provider"` // empty on older refunds means "mpesa"
+	InvoiceID                string     `json:"invoiceId" bson:"invoiceId"`
+	OrderID                  string     `json:"orderId" bson:"orderId"`
+	ReversalID               string     `json:"reversalId" bson:"reversalId"` // reversal whose refund this pays
+	Phone                    string     `json:"phone" bson:"phone"`
+	Amount                   float64    `json:"amount" bson:"amount"`
+	CommandID                string     `json:"commandId,omitempty" bson:"commandId,omitempty"` // M-Pesa: "BusinessPayment" or "PromotionPayment"
+	Status                   string     `json:"status" bson:"status"`                           // "pending", "paid", "failed", "timed_out"
+	ConversationID           string     `json:"conversationId" bson:"conversationId"`           // provider's refund reference
+	OriginatorConversationID string     `json:"originatorConversationId" bson:"originatorConversationId"`
+	CallbackTokenHash        string     `bson:"callbackTokenHash,omitempty" json:"-"` // hash of the token in the result URLs
+	ResultCode               int        `json:"resultCode,omitempty" bson:"resultCode,omitempty"`
+	ResultDesc               string     `json:"resultDesc,omitempty" bson:"resultDesc,omitempty"`
+	TransactionID            string     `json:"transactionId,omitempty" bson:"transactionId,omitempty"` // provider receipt of the payout
+	ReceiverName             string     `json:"receiverName,omitempty" bson:"receiverName,omitempty"`   // name M-Pesa reports for the recipient
+	AdminID                  string     `json:"adminId" bson:"adminId"`
+	Reason                   string     `json:"reason" bson:"reason"`
+	CompletedAt              *time.Time `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+	CreatedAt                time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt                time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Refund status values. A refund stays pending until Safaricom reports the payout.
+const (
+	RefundStatusPending  = "pending"
+	RefundStatusPaid     = "paid"
+	RefundStatusFailed   = "failed"
+	RefundStatusTimedOut = "timed_out"
+)
+
+// RefundResult is the outcome of a refund payout as reported by the provider
+type RefundResult struct {
+	ResultCode    int
+	ResultDesc    string
+	TransactionID string
+	ReceiverName  string
+}
+
+// RefundInvoiceRequest is used by admin to pay out the refund owed on an invoice
+type RefundInvoiceRequest struct {
+	// If Amount is zero, refund everything still owed
+	Amount    float64 `json:"amount" binding:"gte=0"`
+	Provider  string  `json:"provider" binding:"omitempty,oneof=mpesa card"`                        // defaults to the invoice's provider; cash refunds need one of these
+	Phone     string  `json:"phone"`                                                                // M-Pesa: defaults to the order's phone
+	CommandID string  `json:"commandId" binding:"omitempty,oneof=BusinessPayment PromotionPayment"` // defaults to BusinessPayment
+	Reason    string  `json:"reason" binding:"required"`
+}
@@ -28,6 +28,34 @@ type SummaryReport struct {
 	DailyBreakdown         []DailySalesReport    `json:"dailyBreakdown"`        // metrics for each day in range
 }
 
+// PromotionReport attributes redemptions and discounts to a single promotion
+type PromotionReport struct {
+	PromotionID   string  `json:"promotionId" bson:"_id"`
+	Code          string  `json:"code" bson:"code"`
+	Redemptions   int     `json:"redemptions" bson:"redemptions"`
+	TotalDiscount float64 `json:"totalDiscount" bson:"totalDiscount"` // sum of discounts granted by the promotion
+}
+
+// RefundReport reconciles refunds owed to customers against refunds paid out
+type RefundReport struct {
+	DateRange      DateRange              `json:"dateRange"`
+	RefundsOwed    float64                `json:"refundsOwed"`    // sum owed on reversals in the range
+	RefundsPaid    float64                `json:"refundsPaid"`    // sum paid out against those reversals
+	RefundsPending float64                `json:"refundsPending"` // payouts requested but not yet confirmed
+	Outstanding    float64                `json:"outstanding"`    // owed minus paid
+	Unsettled      []RefundReconciliation `json:"unsettled"`      // reversals not yet fully refunded
+}
+
+// RefundReconciliation shows what is owed and paid on a single reversal
+type RefundReconciliation struct {
+	ReversalID     string  `json:"reversalId" bson:"_id"`
+	InvoiceID      string  `json:"invoiceId" bson:"invoiceId"`
+	Date           string  `json:"date" bson:"date"`
+	RefundOwed     float64 `json:"refundOwed" bson:"refundOwed"`
+	RefundedAmount float64 `json:"refundedAmount" bson:"refundedAmount"`
+	Outstanding    float64 `json:"outstanding" bson:"outstanding"`
+}
+
 // DateRange represents a start and end date
 type DateRange struct {
 	StartDate string `json:"startDate"` // YYYY-MM-DD
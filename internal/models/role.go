@@ -0,0 +1,127 @@
+package models
+
+import "time"
+
+// Permissions name what a role allows on the admin API, as "<area>:<action>"
+const (
+	PermissionAll             = "*" // every permission, including ones added later
+	PermissionProductsWrite   = "products:write"
+	PermissionInventoryRead   = "inventory:read"
+	PermissionInventoryWrite  = "inventory:write"
+	PermissionOrdersRead      = "orders:read"
+	PermissionOrdersWrite     = "orders:write" // order status
+	PermissionInvoicesRead    = "invoices:read"
+	PermissionInvoicesWrite   = "invoices:write" // record payments
+	PermissionInvoicesReverse = "invoices:reverse"
+	PermissionInvoicesRefund  = "invoices:refund"
+	PermissionPaymentsRead    = "payments:read"
+	PermissionPaymentsWrite   = "payments:write" // review, collect and allocate payments
+	PermissionReportsRead     = "reports:read"
+	PermissionPromotionsRead  = "promotions:read"
+	PermissionPromotionsWrite = "promotions:write"
+	PermissionDeliveryWrite   = "delivery:write" // delivery zones and fees
+	PermissionUsersRead       = "users:read"     // users, lockouts and sign-in events
+	PermissionUsersWrite      = "users:write"
+	PermissionRolesManage     = "roles:manage" // edit roles and assign them to users
+)
+
+// Permissions lists every permission a role can be given
+var Permissions = []string{
+	PermissionProductsWrite,
+	PermissionInventoryRead,
+	PermissionInventoryWrite,
+	PermissionOrdersRead,
+	PermissionOrdersWrite,
+	PermissionInvoicesRead,
+	PermissionInvoicesWrite,
+	PermissionInvoicesReverse,
+	PermissionInvoicesRefund,
+	PermissionPaymentsRead,
+	PermissionPaymentsWrite,
+	PermissionReportsRead,
+	PermissionPromotionsRead,
+	PermissionPromotionsWrite,
+	PermissionDeliveryWrite,
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionRolesManage,
+}
+
+// Built-in roles. RoleUser is every customer's role and grants no permissions.
+const (
+	RoleUser      = "user"
+	RoleAdmin     = "admin"
+	RoleSupport   = "support"
+	RoleWarehouse = "warehouse"
+	RoleFinance   = "finance"
+)
+
+// Role is a named set of permissions. Its ID is the name users are given, e.g.
+// "warehouse".
+type Role struct {
+	ID          string    `json:"id" bson:"_id"`
+	Description string    `json:"description" bson:"description"`
+	Permissions []string  `json:"permissions" bson:"permissions"`
+	BuiltIn     bool      `json:"builtIn" bson:"builtIn"` // seeded at startup; cannot be deleted
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// HasPermission reports whether the role grants permission
+func (r *Role) HasPermission(permission string) bool {
+	for _, p := range r.Permissions {
+		if p == permission || p == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// HasStaffRole reports whether roles include any role besides the customer role
+func HasStaffRole(roles []string) bool {
+	for _, role := range roles {
+		if role != RoleUser {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRoles are created when missing at startup. Apart from admin they can be
+// edited afterwards.
+func DefaultRoles() []*Role {
+	return []*Role{
+		{ID: RoleUser, Description: "Customer", Permissions: []string{}},
+		{ID: RoleAdmin, Description: "Full access", Permissions: []string{PermissionAll}},
+		{ID: RoleSupport, Description: "Customer support", Permissions: []string{
+			PermissionOrdersRead, PermissionInvoicesRead, PermissionPaymentsRead,
+			PermissionPromotionsRead, PermissionUsersRead, PermissionUsersWrite,
+		}},
+		{ID: RoleWarehouse, Description: "Stock and fulfilment", Permissions: []string{
+			PermissionOrdersRead, PermissionOrdersWrite, PermissionInventoryRead, PermissionInventoryWrite,
+			PermissionDeliveryWrite,
+		}},
+		{ID: RoleFinance, Description: "Invoices, payments and reports", Permissions: []string{
+			PermissionOrdersRead, PermissionInvoicesRead, PermissionInvoicesWrite, PermissionInvoicesReverse,
+			PermissionInvoicesRefund, PermissionPaymentsRead, PermissionPaymentsWrite, PermissionReportsRead,
+		}},
+	}
+}
+
+// CreateRoleRequest defines a custom role
+type CreateRoleRequest struct {
+	ID          string   `json:"id" binding:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// UpdateRoleRequest replaces a role's description and permissions
+type UpdateRoleRequest struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// SetUserRolesRequest replaces the roles a user has
+type SetUserRolesRequest struct {
+	Roles []string `json:"roles" binding:"required,min=1"`
+}
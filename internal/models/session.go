@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// Session is a signed-in device. It holds the hash of the refresh token that renews
+// the session's access tokens; every refresh replaces the refresh token, and a
+// replaced one presented again revokes the whole session.
+type Session struct {
+	ID                    string     `json:"id" bson:"_id"`
+	UserID                string     `json:"userId" bson:"userId"`
+	RefreshTokenHash      string     `json:"-" bson:"refreshTokenHash"`
+	PreviousRefreshHashes []string   `json:"-" bson:"previousRefreshHashes,omitempty"` // replaced refresh tokens, to detect reuse
+	AccessTokenID         string     `json:"-" bson:"accessTokenId"`                   // jti of the latest access token issued
+	AccessExpiresAt       time.Time  `json:"-" bson:"accessExpiresAt"`
+	AuthMethods           []string   `json:"authMethods,omitempty" bson:"authMethods,omitempty"` // how the user signed in, e.g. ["pwd", "otp"]
+	Device                string     `json:"device,omitempty" bson:"device,omitempty"`           // name the client gave, e.g. "Jane's phone"
+	UserAgent             string     `json:"userAgent" bson:"userAgent"`
+	IP                    string     `json:"ip" bson:"ip"`
+	CreatedAt             time.Time  `json:"createdAt" bson:"createdAt"`
+	LastUsedAt            time.Time  `json:"lastUsedAt" bson:"lastUsedAt"`
+	ExpiresAt             time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt             *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	RevokedReason         string     `json:"revokedReason,omitempty" bson:"revokedReason,omitempty"`
+	Current               bool       `json:"current" bson:"-"` // the session making the request
+}
+
+// Reasons a session was revoked
+const (
+	SessionRevokedLogout         = "logout"
+	SessionRevokedByUser         = "revoked"
+	SessionRevokedReuse          = "refresh_token_reuse"
+	SessionRevokedPasswordReset  = "password_reset"
+	SessionRevokedSuspended      = "suspended"
+	SessionRevokedPasswordChange = "password_changed"
+	SessionRevokedAccountDeleted = "account_deleted"
+)
+
+// Active reports whether the session can still be refreshed
+func (s *Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// SessionRotation is what a refresh changes on a session
+type SessionRotation struct {
+	RefreshTokenHash string
+	AccessTokenID    string
+	AccessExpiresAt  time.Time
+	ExpiresAt        time.Time
+	UserAgent        string
+	IP               string
+}
+
+// RefreshRequest exchanges a refresh token for new tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
@@ -0,0 +1,141 @@
+package models
+
+import "time"
+
+// Shipment methods
+const (
+	ShipmentMethodDelivery = "delivery" // taken to the customer by a rider or carrier
+	ShipmentMethodPickup   = "pickup"   // collected by the customer from a pickup point
+)
+
+// Shipment status values. A shipment is prepared, then dispatched (or made ready
+// at its pickup point) and finally delivered with proof, or failed. The items of
+// a failed shipment can be shipped again.
+const (
+	ShipmentStatusPreparing      = "preparing"
+	ShipmentStatusDispatched     = "dispatched"
+	ShipmentStatusReadyForPickup = "ready_for_pickup"
+	ShipmentStatusDelivered      = "delivered"
+	ShipmentStatusFailed         = "failed"
+)
+
+// ShipmentItem is a quantity of one of the order's products sent in a shipment
+type ShipmentItem struct {
+	ProductID string `json:"productId" bson:"productId" binding:"required"`
+	Quantity  int    `json:"quantity" bson:"quantity" binding:"required,gt=0"`
+}
+
+// ProofOfDelivery is captured when the customer receives a shipment
+type ProofOfDelivery struct {
+	RecipientName string    `json:"recipientName" bson:"recipientName"`
+	SignatureType string    `json:"signatureType" bson:"signatureType"`     // content type of the stored signature image
+	CodeVerified  bool      `json:"codeVerified" bson:"codeVerified"`       // the customer's delivery code was given
+	RecordedBy    string    `json:"recordedBy,omitempty" bson:"recordedBy"` // staff user who recorded the delivery
+	DeliveredAt   time.Time `json:"deliveredAt" bson:"deliveredAt"`
+}
+
+// Shipment sends some or all of an order's items to the customer
+type Shipment struct {
+	ID                string         `json:"id" bson:"_id"`
+	OrderID           string         `json:"orderId" bson:"orderId"`
+	UserID            string         `json:"userId" bson:"userId"`
+	Items             []ShipmentItem `json:"items" bson:"items"`
+	Method            string         `json:"method" bson:"method"` // "delivery" or "pickup"
+	Status            string         `json:"status" bson:"status"`
+	Carrier           string         `json:"carrier,omitempty" bson:"carrier,omitempty"` // courier company, empty for the shop's own riders
+	RiderName         string         `json:"riderName,omitempty" bson:"riderName,omitempty"`
+	RiderPhone        string         `json:"riderPhone,omitempty" bson:"riderPhone,omitempty"`
+	TrackingReference string         `json:"trackingReference,omitempty" bson:"trackingReference,omitempty"` // carrier's waybill or tracking number
+	PickupPoint       string         `json:"pickupPoint,omitempty" bson:"pickupPoint,omitempty"`
+	// DeliveryCode is issued on dispatch and shown only to the customer, who gives it
+	// to the rider or pickup point on receipt
+	DeliveryCode         string           `json:"-" bson:"deliveryCode,omitempty"`
+	DeliveryCodeAttempts int              `json:"-" bson:"deliveryCodeAttempts"`
+	Proof                *ProofOfDelivery `json:"proof,omitempty" bson:"proof,omitempty"`
+	FailureReason        string           `json:"failureReason,omitempty" bson:"failureReason,omitempty"`
+	CreatedBy            string           `json:"createdBy,omitempty" bson:"createdBy"`
+	CreatedAt            time.Time        `json:"createdAt" bson:"createdAt"`
+	DispatchedAt         *time.Time       `json:"dispatchedAt,omitempty" bson:"dispatchedAt,omitempty"`
+	DeliveredAt          *time.Time       `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+	FailedAt             *time.Time       `json:"failedAt,omitempty" bson:"failedAt,omitempty"`
+	UpdatedAt            time.Time        `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Open reports whether the shipment is still on its way
+func (s *Shipment) Open() bool {
+	return s.Status == ShipmentStatusPreparing || s.Status == ShipmentStatusDispatched || s.Status == ShipmentStatusReadyForPickup
+}
+
+// DeliverySignature is the signature image captured as proof of delivery. It is
+// stored apart from the shipment, under the shipment's ID.
+type DeliverySignature struct {
+	ShipmentID  string    `bson:"_id"`
+	UserID      string    `bson:"userId"`
+	ContentType string    `bson:"contentType"`
+	Data        []byte    `bson:"data"`
+	CreatedAt   time.Time `bson:"createdAt"`
+}
+
+// CreateShipmentRequest ships some of an order's items, or all that are left
+// when Items is empty
+type CreateShipmentRequest struct {
+	Items             []ShipmentItem `json:"items" binding:"dive"`
+	Method            string         `json:"method" binding:"omitempty,oneof=delivery pickup"` // defaults to delivery for orders with an address
+	Carrier           string         `json:"carrier" binding:"max=100"`
+	RiderName         string         `json:"riderName" binding:"max=100"`
+	RiderPhone        string         `json:"riderPhone" binding:"max=20"`
+	TrackingReference string         `json:"trackingReference" binding:"max=100"`
+	PickupPoint       string         `json:"pickupPoint" binding:"max=200"`
+}
+
+// UpdateShipmentRequest changes who carries a shipment or where it is collected.
+// Fields left out are kept.
+type UpdateShipmentRequest struct {
+	Carrier           *string `json:"carrier" binding:"omitempty,max=100"`
+	RiderName         *string `json:"riderName" binding:"omitempty,max=100"`
+	RiderPhone        *string `json:"riderPhone" binding:"omitempty,max=20"`
+	TrackingReference *string `json:"trackingReference" binding:"omitempty,max=100"`
+	PickupPoint       *string `json:"pickupPoint" binding:"omitempty,max=200"`
+}
+
+// DeliverShipmentRequest is the multipart form recording proof of delivery; the
+// signature image is sent as the "signature" file
+type DeliverShipmentRequest struct {
+	RecipientName string `form:"recipientName" binding:"required,max=100"`
+	Code          string `form:"code" binding:"required"`
+}
+
+// FailShipmentRequest gives up on a shipment, e.g. when the customer could not be
+// reached
+type FailShipmentRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// OutstandingItems returns the units of the order's products not yet covered by
+// shipments, in the order's product order. With deliveredOnly it counts only
+// delivered shipments (what is left to deliver), otherwise every shipment that
+// has not failed (what is left to ship).
+func OutstandingItems(order *Order, shipments []*Shipment, deliveredOnly bool) []ShipmentItem {
+	covered := make(map[string]int)
+	for _, s := range shipments {
+		if s.Status == ShipmentStatusFailed || (deliveredOnly && s.Status != ShipmentStatusDelivered) {
+			continue
+		}
+		for _, item := range s.Items {
+			covered[item.ProductID] += item.Quantity
+		}
+	}
+
+	outstanding := []ShipmentItem{}
+	for _, p := range order.Products {
+		take := p.Quantity
+		if covered[p.ProductID] < take {
+			take = covered[p.ProductID]
+		}
+		covered[p.ProductID] -= take
+		if left := p.Quantity - take; left > 0 {
+			outstanding = append(outstanding, ShipmentItem{ProductID: p.ProductID, Quantity: left})
+		}
+	}
+	return outstanding
+}
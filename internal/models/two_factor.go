@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// TwoFactor holds a user's authenticator app (TOTP) settings. The secret is only
+// used once the user has confirmed it with a code.
+type TwoFactor struct {
+	Secret             string     `bson:"secret,omitempty"`
+	PendingSecret      string     `bson:"pendingSecret,omitempty"` // provisioned, awaiting a code to confirm it
+	LastStep           int64      `bson:"lastStep,omitempty"`      // time step of the last code accepted, so each code works once
+	RecoveryCodeHashes []string   `bson:"recoveryCodeHashes,omitempty"`
+	EnabledAt          *time.Time `bson:"enabledAt,omitempty"`
+}
+
+// TwoFactorSetupResponse is the secret to add to an authenticator app
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"` // otpauth:// URI, usually shown as a QR code
+}
+
+// TwoFactorCodeRequest carries a code from the user's authenticator app
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactorRequest turns two-factor authentication off; it needs the
+// password and a code or recovery code
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// RecoveryCodesResponse lists recovery codes. They are shown once; only hashes are kept.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TwoFactorChallenge is the login response for users with two-factor
+// authentication; the challenge token and a code complete the login
+type TwoFactorChallenge struct {
+	TwoFactorRequired  bool   `json:"twoFactorRequired"`
+	ChallengeToken     string `json:"challengeToken"`
+	ChallengeExpiresAt int64  `json:"challengeExpiresAt"`
+}
+
+// TwoFactorLoginRequest completes a login with a code or a recovery code
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+	Device         string `json:"device"` // optional name for the new session
+}
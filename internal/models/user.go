@@ -3,14 +3,84 @@ package models
 import "time"
 
 type User struct {
-	ID        string    `json:"id" bson:"_id"`
-	Email     string    `json:"email" bson:"email"`
-	Password  string    `json:"-" bson:"password"`
-	FirstName string    `json:"firstName" bson:"firstName"`
-	LastName  string    `json:"lastName" bson:"lastName"`
-	Role      string    `json:"role" bson:"role"`
-	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	ID               string            `json:"id" bson:"_id"`
+	Email            string            `json:"email" bson:"email"`
+	Password         string            `json:"-" bson:"password"`
+	FirstName        string            `json:"firstName" bson:"firstName"`
+	LastName         string            `json:"lastName" bson:"lastName"`
+	Phone            string            `json:"phone,omitempty" bson:"phone,omitempty"`
+	DeliveryDefaults *DeliveryDefaults `json:"deliveryDefaults,omitempty" bson:"deliveryDefaults,omitempty"` // prefilled on new orders by clients
+	Role             string            `json:"role" bson:"role"`                                             // first of Roles, for clients that show one role
+	Roles            []string          `json:"roles" bson:"roles,omitempty"`                                 // IDs of the user's roles; see RoleNames
+	EmailVerified    bool              `json:"emailVerified" bson:"emailVerified"`
+	EmailVerifiedAt  *time.Time        `json:"emailVerifiedAt,omitempty" bson:"emailVerifiedAt,omitempty"`
+	TwoFactorEnabled bool              `json:"twoFactorEnabled" bson:"twoFactorEnabled"`
+	TwoFactor        *TwoFactor        `json:"-" bson:"twoFactor,omitempty"`
+	Suspension       *Suspension       `json:"suspension,omitempty" bson:"suspension,omitempty"` // set while the account is suspended
+	DeletedAt        *time.Time        `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`   // set when the account was deleted and anonymised
+	CreatedAt        time.Time         `json:"createdAt" bson:"createdAt"`
+	UpdatedAt        time.Time         `json:"updatedAt" bson:"updatedAt"`
+}
+
+// RoleNames returns the user's roles. Users created before roles could be
+// combined have only Role.
+func (u *User) RoleNames() []string {
+	if len(u.Roles) > 0 {
+		return u.Roles
+	}
+	if u.Role != "" {
+		return []string{u.Role}
+	}
+	return nil
+}
+
+// IsStaff reports whether the user has any role besides the customer role
+func (u *User) IsStaff() bool {
+	return HasStaffRole(u.RoleNames())
+}
+
+// Suspension records why and by whom an account was suspended. Suspended users
+// cannot sign in and their tokens are refused.
+type Suspension struct {
+	Reason      string    `json:"reason" bson:"reason"`
+	SuspendedBy string    `json:"suspendedBy" bson:"suspendedBy"` // admin who suspended the account
+	SuspendedAt time.Time `json:"suspendedAt" bson:"suspendedAt"`
+}
+
+// Suspended reports whether the account is suspended
+func (u *User) Suspended() bool {
+	return u.Suspension != nil
+}
+
+// DeliveryDefaults are the delivery details a user usually orders with
+type DeliveryDefaults struct {
+	Phone           string `json:"phone" bson:"phone"` // contact for the rider, if not the account phone
+	LocationDetails string `json:"locationDetails" bson:"locationDetails"`
+	Notes           string `json:"notes" bson:"notes"`
+}
+
+// Deleted reports whether the account was deleted. Deleted accounts are kept,
+// anonymised, so their orders and invoices still have a customer.
+func (u *User) Deleted() bool {
+	return u.DeletedAt != nil
+}
+
+// User statuses an admin can search by
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+)
+
+// UserSearchQuery contains the admin filters for searching users
+type UserSearchQuery struct {
+	Q      string `form:"q"`      // part of the email, first or last name, any case
+	Role   string `form:"role"`   // users who have this role
+	Status string `form:"status"` // "active" or "suspended"
+}
+
+// SuspendUserRequest suspends an account
+type SuspendUserRequest struct {
+	Reason string `json:"reason" binding:"required"`
 }
 
 type RegisterRequest struct {
@@ -18,17 +88,44 @@ type RegisterRequest struct {
 	Password  string `json:"password" binding:"required,min=6"`
 	FirstName string `json:"firstName" binding:"required"`
 	LastName  string `json:"lastName" binding:"required"`
+	Device    string `json:"device"` // optional name for the new session
 }
 
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+	Device   string `json:"device"` // optional name for the new session
+}
+
+// UpdateProfileRequest changes the fields of the profile that are given
+type UpdateProfileRequest struct {
+	FirstName        *string           `json:"firstName" binding:"omitempty,min=1,max=100"`
+	LastName         *string           `json:"lastName" binding:"omitempty,min=1,max=100"`
+	Phone            *string           `json:"phone" binding:"omitempty,max=20"` // "" clears it
+	DeliveryDefaults *DeliveryDefaults `json:"deliveryDefaults"`
+}
+
+// ChangePasswordRequest replaces the password of the signed-in user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required,min=6"`
+}
+
+// DeleteAccountRequest confirms the deletion of the signed-in user's account.
+// Users with two-factor authentication also give a code or recovery code.
+type DeleteAccountRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
 }
 
 type AuthResponse struct {
-	Token     string `json:"token"`
-	User      *User  `json:"user"`
-	ExpiresAt int64  `json:"expiresAt"`
+	Token            string `json:"token"`
+	User             *User  `json:"user"`
+	ExpiresAt        int64  `json:"expiresAt"`
+	RefreshToken     string `json:"refreshToken,omitempty"`
+	RefreshExpiresAt int64  `json:"refreshExpiresAt,omitempty"`
+	SessionID        string `json:"sessionId,omitempty"`
 }
 
 type Claims struct {
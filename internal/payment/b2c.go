@@ -0,0 +1,95 @@
+package mpesa
+
+import (
+	"context"
+	"fmt"
+)
+
+// B2C command IDs accepted by the payment request API
+const (
+	B2CBusinessPayment  = "BusinessPayment"
+	B2CPromotionPayment = "PromotionPayment"
+)
+
+// B2CRequest payload for paying money from the business to a customer's M-Pesa
+// account. The misspelt Occassion field is Daraja's own.
+type B2CRequest struct {
+	InitiatorName      string `json:"InitiatorName"`
+	SecurityCredential string `json:"SecurityCredential"`
+	CommandID          string `json:"CommandID"`
+	Amount             string `json:"Amount"`
+	PartyA             string `json:"PartyA"`
+	PartyB             string `json:"PartyB"`
+	Remarks            string `json:"Remarks"`
+	QueueTimeOutURL    string `json:"QueueTimeOutURL"`
+	ResultURL          string `json:"ResultURL"`
+	Occasion           string `json:"Occassion"`
+}
+
+// B2CResponse from Safaricom acknowledging a B2C request. The outcome arrives later
+// on B2CResultURL (or B2CTimeoutURL), identified by these IDs.
+type B2CResponse struct {
+	ConversationID           string `json:"ConversationID"`
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+}
+
+// InitiateB2CPayment asks M-Pesa to pay amount (whole shillings) to phone from the
+// B2C short code. Like reversals, the request is only queued: the money has not
+// moved until the result callback reports success. It is never retried, so a lost
+// response cannot pay the customer twice. callbackToken, if set, is embedded in the
+// result and timeout URLs.
+func (c *Client) InitiateB2CPayment(ctx context.Context, phone, amount, commandID, remarks, occasion, callbackToken string) (*B2CResponse, error) {
+	const op = "mpesa B2C error"
+
+	if c.config.InitiatorName == "" || c.config.InitiatorPassword == "" || c.config.PublicKeyPath == "" {
+		return nil, fmt.Errorf("mpesa B2C not configured: missing initiator or public key")
+	}
+
+	if commandID == "" {
+		commandID = B2CBusinessPayment
+	}
+	if commandID != B2CBusinessPayment && commandID != B2CPromotionPayment {
+		return nil, fmt.Errorf("unsupported B2C command %q", commandID)
+	}
+
+	shortCode := c.config.B2CShortCode
+	if shortCode == "" {
+		shortCode = c.config.BusinessShortCode
+	}
+
+	secCred, err := c.encryptSecurityCredential(c.config.InitiatorPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SecurityCredential: %w", err)
+	}
+
+	payload := B2CRequest{
+		InitiatorName:      c.config.InitiatorName,
+		SecurityCredential: secCred,
+		CommandID:          commandID,
+		Amount:             amount,
+		PartyA:             shortCode,
+		PartyB:             phone,
+		Remarks:            remarks,
+		QueueTimeOutURL:    withCallbackToken(c.config.B2CTimeoutURL, callbackToken),
+		ResultURL:          withCallbackToken(c.config.B2CResultURL, callbackToken),
+		Occasion:           occasion,
+	}
+
+	body, err := c.call(ctx, apiRequest{op: op, path: "/mpesa/b2c/v1/paymentrequest", payload: payload})
+	if err != nil {
+		return nil, err
+	}
+
+	var b2cResp B2CResponse
+	if err := decode(op, body, &b2cResp); err != nil {
+		return nil, err
+	}
+
+	if b2cResp.ResponseCode != "0" {
+		return nil, rejected(op, b2cResp.ResponseCode, b2cResp.ResponseDescription)
+	}
+
+	return &b2cResp, nil
+}
@@ -0,0 +1,119 @@
+package mpesa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestPublicKey writes a throwaway RSA public key for SecurityCredential encryption
+func writeTestPublicKey(t *testing.T) string {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal pub failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pub.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}), 0o600); err != nil {
+		t.Fatalf("write pub file: %v", err)
+	}
+	return path
+}
+
+func TestInitiateB2CPayment_Success(t *testing.T) {
+	var got B2CRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+
+		assert.Equal(t, "/mpesa/b2c/v1/paymentrequest", r.URL.Path)
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"ConversationID":"AG_20240115_1","OriginatorConversationID":"10571-7910404-1","ResponseCode":"0","ResponseDescription":"Accept the service request successfully."}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(Config{
+		ConsumerKey:       "key",
+		ConsumerSecret:    "secret",
+		BusinessShortCode: "174379",
+		B2CShortCode:      "600996",
+		InitiatorName:     "testapi",
+		InitiatorPassword: "password",
+		PublicKeyPath:     writeTestPublicKey(t),
+		B2CResultURL:      "https://shop.example.com/api/v1/mpesa/b2c/result",
+		B2CTimeoutURL:     "https://shop.example.com/api/v1/mpesa/b2c/timeout",
+		BaseURL:           server.URL,
+	})
+
+	resp, err := c.InitiateB2CPayment(context.Background(), "254712345678", "500", "", "Refund", "invoice-123", "abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, "AG_20240115_1", resp.ConversationID)
+	assert.Equal(t, "10571-7910404-1", resp.OriginatorConversationID)
+	assert.Equal(t, B2CBusinessPayment, got.CommandID)
+	assert.Equal(t, "600996", got.PartyA)
+	assert.Equal(t, "254712345678", got.PartyB)
+	assert.Equal(t, "500", got.Amount)
+	assert.Equal(t, "invoice-123", got.Occasion)
+	assert.Equal(t, "https://shop.example.com/api/v1/mpesa/b2c/result/abc123", got.ResultURL)
+	assert.Equal(t, "https://shop.example.com/api/v1/mpesa/b2c/timeout/abc123", got.QueueTimeOutURL)
+	assert.NotEmpty(t, got.SecurityCredential)
+}
+
+func TestInitiateB2CPayment_NotConfigured(t *testing.T) {
+	c := NewClient(Config{ConsumerKey: "key", ConsumerSecret: "secret"})
+
+	_, err := c.InitiateB2CPayment(context.Background(), "254712345678", "500", B2CBusinessPayment, "Refund", "invoice-123", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not configured")
+}
+
+func TestInitiateB2CPayment_UnsupportedCommand(t *testing.T) {
+	c := NewClient(Config{InitiatorName: "testapi", InitiatorPassword: "password", PublicKeyPath: "unused.pem"})
+
+	_, err := c.InitiateB2CPayment(context.Background(), "254712345678", "500", "SalaryPayment", "Refund", "invoice-123", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported B2C command")
+}
+
+func TestInitiateB2CPayment_ErrorResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"requestId":"1","errorCode":"400.002.02","errorMessage":"Bad Request - Invalid PartyB"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(Config{
+		ConsumerKey:       "key",
+		ConsumerSecret:    "secret",
+		InitiatorName:     "testapi",
+		InitiatorPassword: "password",
+		PublicKeyPath:     writeTestPublicKey(t),
+		BaseURL:           server.URL,
+	})
+
+	_, err := c.InitiateB2CPayment(context.Background(), "0712", "500", B2CPromotionPayment, "Refund", "invoice-123", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid PartyB")
+}
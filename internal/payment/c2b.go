@@ -0,0 +1,66 @@
+package mpesa
+
+import (
+	"context"
+	"fmt"
+)
+
+// C2B validation result codes returned to Safaricom
+const (
+	C2BAccepted             = "0"
+	C2BRejectInvalidAccount = "C2B00012"
+)
+
+// RegisterURLRequest payload for registering C2B confirmation and validation URLs
+type RegisterURLRequest struct {
+	ShortCode       string `json:"ShortCode"`
+	ResponseType    string `json:"ResponseType"`
+	ConfirmationURL string `json:"ConfirmationURL"`
+	ValidationURL   string `json:"ValidationURL"`
+}
+
+// RegisterURLResponse from Safaricom. The misspelt field name is Daraja's own.
+type RegisterURLResponse struct {
+	OriginatorConversationID string `json:"OriginatorCoversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+}
+
+// RegisterC2BURLs registers the configured confirmation and validation URLs for the
+// business short code, so Paybill/Till payments are reported to this server.
+// Daraja rejects URLs containing keywords such as "mpesa" or "safaricom".
+func (c *Client) RegisterC2BURLs(ctx context.Context) (*RegisterURLResponse, error) {
+	const op = "register URL failed"
+
+	if c.config.C2BConfirmationURL == "" || c.config.C2BValidationURL == "" {
+		return nil, fmt.Errorf("mpesa C2B not configured: missing confirmation or validation URL")
+	}
+
+	responseType := c.config.C2BResponseType
+	if responseType == "" {
+		responseType = "Completed"
+	}
+
+	payload := RegisterURLRequest{
+		ShortCode:       c.config.BusinessShortCode,
+		ResponseType:    responseType,
+		ConfirmationURL: c.config.C2BConfirmationURL,
+		ValidationURL:   c.config.C2BValidationURL,
+	}
+
+	body, err := c.call(ctx, apiRequest{op: op, path: "/mpesa/c2b/v1/registerurl", payload: payload, idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var registerResp RegisterURLResponse
+	if err := decode(op, body, &registerResp); err != nil {
+		return nil, err
+	}
+
+	if registerResp.ResponseCode != "0" {
+		return nil, rejected(op, registerResp.ResponseCode, registerResp.ResponseDescription)
+	}
+
+	return &registerResp, nil
+}
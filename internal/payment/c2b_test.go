@@ -0,0 +1,74 @@
+package mpesa
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterC2BURLs_Success(t *testing.T) {
+	var got RegisterURLRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+
+		assert.Equal(t, "/mpesa/c2b/v1/registerurl", r.URL.Path)
+		assert.Equal(t, "Bearer test_token", r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"OriginatorCoversationID":"6e86-45dd-91ac-fd5d4178ab523408729","ResponseCode":"0","ResponseDescription":"Success"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(Config{
+		ConsumerKey:        "key",
+		ConsumerSecret:     "secret",
+		BusinessShortCode:  "600999",
+		BaseURL:            server.URL,
+		C2BConfirmationURL: "https://shop.example.com/api/v1/payments/c2b/confirmation",
+		C2BValidationURL:   "https://shop.example.com/api/v1/payments/c2b/validation",
+	})
+
+	resp, err := c.RegisterC2BURLs(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "6e86-45dd-91ac-fd5d4178ab523408729", resp.OriginatorConversationID)
+	assert.Equal(t, "600999", got.ShortCode)
+	assert.Equal(t, "Completed", got.ResponseType)
+	assert.Equal(t, "https://shop.example.com/api/v1/payments/c2b/confirmation", got.ConfirmationURL)
+}
+
+func TestRegisterC2BURLs_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorCode":"400.003.02","errorMessage":"Bad Request - Invalid ConfirmationURL"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(Config{
+		BaseURL:            server.URL,
+		C2BConfirmationURL: "https://shop.example.com/mpesa/confirm",
+		C2BValidationURL:   "https://shop.example.com/mpesa/validate",
+	})
+
+	_, err := c.RegisterC2BURLs(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid ConfirmationURL")
+}
+
+func TestRegisterC2BURLs_NotConfigured(t *testing.T) {
+	c := NewClient(Config{})
+
+	_, err := c.RegisterC2BURLs(context.Background())
+	assert.Error(t, err)
+}
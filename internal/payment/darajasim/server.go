@@ -0,0 +1,759 @@
+// Package darajasim is a stand-in for Safaricom's Daraja API. It serves the OAuth,
+// STK Push, STK query, reversal, B2C and C2B endpoints that mpesa.Client calls, and
+// posts results to the callback URLs in each request, so payment flows can be
+// exercised offline. Point mpesa.Config.BaseURL at it.
+//
+// Each request's outcome is chosen by a Scenario: succeed, fail, time out or be
+// refused outright, optionally with a delay and duplicate callbacks.
+package darajasim
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+)
+
+// Operations a scenario can be set for
+const (
+	STKPush  = "stk_push"
+	Reversal = "reversal"
+	B2C      = "b2c"
+	C2B      = "c2b"
+)
+
+// Outcome is what the simulator does with an accepted request
+type Outcome string
+
+const (
+	// Succeed completes the request and posts a successful result
+	Succeed Outcome = "succeed"
+	// Fail accepts the request and posts a failed result (e.g. cancelled by the user)
+	Fail Outcome = "fail"
+	// Timeout never completes the request. An STK Push stays pending, so queries
+	// report it as still processing; reversals and B2C payments are reported on
+	// their QueueTimeOutURL; C2B payments go ahead without asking the validation URL.
+	Timeout Outcome = "timeout"
+	// Reject refuses the API request itself with a Daraja error response
+	Reject Outcome = "reject"
+)
+
+// Scenario decides how the simulator answers a request
+type Scenario struct {
+	Outcome       Outcome
+	ResultCode    int           // result code for Fail; a typical code per operation if zero
+	ResultDesc    string        // result description, or the Reject error message
+	PaidAmount    float64       // STK amount reported paid; the requested amount if zero
+	Delay         time.Duration // wait before posting the result
+	Duplicates    int           // extra copies of the result callback, as Safaricom sends on retry
+	ResponseDelay time.Duration // hold the API response, to trip client timeouts
+}
+
+// Config configures the simulator. Empty credentials accept any.
+type Config struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	PassKey        string // when set, STK Push passwords are checked
+	// InitiatorPassword, when set, must be what reversal and B2C SecurityCredentials
+	// decrypt to. Clients encrypt it with the key from PublicKeyPEM.
+	InitiatorPassword string
+	TokenTTL          time.Duration // lifetime of issued access tokens; an hour if zero
+	CallbackClient    *http.Client  // client used to post callbacks
+	Logger            *log.Logger   // logs requests and callbacks when set
+}
+
+// Delivery is a callback the simulator posted
+type Delivery struct {
+	Operation  string          `json:"operation"`
+	URL        string          `json:"url"`
+	Body       json.RawMessage `json:"body"`
+	StatusCode int             `json:"statusCode"`
+	Response   string          `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// stkTransaction is an STK Push the simulator has accepted
+type stkTransaction struct {
+	merchantRequestID string
+	checkoutRequestID string
+	resolved          bool
+	resultCode        int
+	resultDesc        string
+}
+
+// Server is the simulated Daraja API. It implements http.Handler.
+type Server struct {
+	config Config
+	mux    *http.ServeMux
+	key    *rsa.PrivateKey // stands in for Safaricom's certificate key
+
+	mu        sync.Mutex
+	seq       int
+	scenarios map[string]Scenario // by operation
+	overrides map[string]Scenario // by operation and party
+	tokens    map[string]time.Time
+	stk       map[string]*stkTransaction          // by CheckoutRequestID
+	c2bURLs   map[string]mpesa.RegisterURLRequest // by short code
+	delivered []Delivery
+
+	pending sync.WaitGroup
+}
+
+// NewServer creates a simulator where every request succeeds until told otherwise
+func NewServer(config Config) *Server {
+	if config.TokenTTL == 0 {
+		config.TokenTTL = time.Hour
+	}
+	if config.CallbackClient == nil {
+		config.CallbackClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	// Generating a 2048-bit key cannot fail short of a broken random source
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("darajasim: failed to generate key: %v", err))
+	}
+
+	s := &Server{
+		config:    config,
+		key:       key,
+		mux:       http.NewServeMux(),
+		scenarios: map[string]Scenario{},
+		overrides: map[string]Scenario{},
+		tokens:    map[string]time.Time{},
+		stk:       map[string]*stkTransaction{},
+		c2bURLs:   map[string]mpesa.RegisterURLRequest{},
+	}
+
+	s.mux.HandleFunc("GET /oauth/v1/generate", s.handleOAuth)
+	s.mux.HandleFunc("POST /mpesa/stkpush/v1/processrequest", s.authorized(s.handleSTKPush))
+	s.mux.HandleFunc("POST /mpesa/stkpushquery/v1/query", s.authorized(s.handleSTKQuery))
+	s.mux.HandleFunc("POST /mpesa/reversal/v1/request", s.authorized(s.handleReversal))
+	s.mux.HandleFunc("POST /mpesa/b2c/v1/paymentrequest", s.authorized(s.handleB2C))
+	s.mux.HandleFunc("POST /mpesa/c2b/v1/registerurl", s.authorized(s.handleRegisterURL))
+	s.mux.HandleFunc("POST /mpesa/c2b/v1/simulate", s.authorized(s.handleC2BSimulate))
+
+	// Control endpoints for a simulator running as its own process
+	s.mux.HandleFunc("PUT /simulator/scenarios/{operation}", s.handleSetScenario)
+	s.mux.HandleFunc("GET /simulator/deliveries", s.handleDeliveries)
+	s.mux.HandleFunc("GET /simulator/certificate", s.handleCertificate)
+
+	return s
+}
+
+// ServeHTTP routes a request to the simulated endpoint
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logf("%s %s", r.Method, r.URL.Path)
+	s.mux.ServeHTTP(w, r)
+}
+
+// PublicKeyPEM returns the public key SecurityCredentials must be encrypted with.
+// Write it to the file mpesa.Config.PublicKeyPath names.
+func (s *Server) PublicKeyPEM() []byte {
+	der, _ := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// validCredential checks a SecurityCredential was encrypted with PublicKeyPEM
+func (s *Server) validCredential(credential string) bool {
+	ciphertext, err := base64.StdEncoding.DecodeString(credential)
+	if err != nil {
+		return false
+	}
+	password, err := rsa.DecryptPKCS1v15(nil, s.key, ciphertext)
+	if err != nil {
+		return false
+	}
+	return s.config.InitiatorPassword == "" || string(password) == s.config.InitiatorPassword
+}
+
+// SetScenario sets the outcome of every later request for an operation
+func (s *Server) SetScenario(operation string, scenario Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenarios[operation] = scenario
+}
+
+// SetScenarioFor sets the outcome of later requests involving one party: the
+// customer's phone for STK Push, B2C and C2B, or the TransactionID for reversals.
+// It takes precedence over SetScenario.
+func (s *Server) SetScenarioFor(operation, party string, scenario Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[operation+":"+party] = scenario
+}
+
+// Reset clears scenarios, transactions and the delivery log
+func (s *Server) Reset() {
+	s.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenarios = map[string]Scenario{}
+	s.overrides = map[string]Scenario{}
+	s.stk = map[string]*stkTransaction{}
+	s.delivered = nil
+}
+
+// Wait blocks until every scheduled callback has been posted
+func (s *Server) Wait() {
+	s.pending.Wait()
+}
+
+// Deliveries returns the callbacks posted so far, oldest first
+func (s *Server) Deliveries() []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Delivery(nil), s.delivered...)
+}
+
+// scenarioFor picks the scenario for a request, defaulting to success
+func (s *Server) scenarioFor(operation, party string) Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sc, ok := s.overrides[operation+":"+party]; ok {
+		return sc
+	}
+	if sc, ok := s.scenarios[operation]; ok {
+		return sc
+	}
+	return Scenario{Outcome: Succeed}
+}
+
+// nextID returns a unique sequence number for simulated IDs and receipts
+func (s *Server) nextID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return s.seq
+}
+
+func (s *Server) handleOAuth(w http.ResponseWriter, r *http.Request) {
+	key, secret, ok := r.BasicAuth()
+	if !ok || (s.config.ConsumerKey != "" && (key != s.config.ConsumerKey || secret != s.config.ConsumerSecret)) {
+		writeError(w, http.StatusBadRequest, "400.008.01", "Invalid Authentication passed")
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	s.tokens[token] = time.Now().Add(s.config.TokenTTL)
+	s.mu.Unlock()
+
+	// Daraja sends expires_in as a string
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": token,
+		"expires_in":   fmt.Sprintf("%d", int(s.config.TokenTTL.Seconds())),
+	})
+}
+
+// authorized rejects requests without a live access token from handleOAuth
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		expiry, ok := s.tokens[token]
+		s.mu.Unlock()
+		if !ok || time.Now().After(expiry) {
+			writeError(w, http.StatusUnauthorized, "404.001.03", "Invalid Access Token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleSTKPush(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.STKPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+	if s.config.PassKey != "" && req.Password != base64.StdEncoding.EncodeToString([]byte(req.BusinessShortCode+s.config.PassKey+req.Timestamp)) {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Password")
+		return
+	}
+	if req.CallBackURL == "" || req.PhoneNumber == "" {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid CallBackURL or PhoneNumber")
+		return
+	}
+
+	sc := s.scenarioFor(STKPush, req.PhoneNumber)
+	time.Sleep(sc.ResponseDelay)
+	if sc.Outcome == Reject {
+		writeError(w, http.StatusInternalServerError, "500.001.1001", describe(sc, "Unable to lock subscriber, a transaction is already in process for the current subscriber"))
+		return
+	}
+
+	id := s.nextID()
+	txn := &stkTransaction{
+		merchantRequestID: fmt.Sprintf("sim-%d-%d", id, time.Now().Unix()),
+		checkoutRequestID: fmt.Sprintf("ws_CO_%s%06d", time.Now().Format("02012006150405"), id),
+	}
+	s.mu.Lock()
+	s.stk[txn.checkoutRequestID] = txn
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, mpesa.STKPushResponse{
+		MerchantRequestID:   txn.merchantRequestID,
+		CheckoutRequestID:   txn.checkoutRequestID,
+		ResponseCode:        "0",
+		ResponseDescription: "Success. Request accepted for processing",
+		CustomerMessage:     "Success. Request accepted for processing",
+	})
+
+	// The customer never answers the prompt
+	if sc.Outcome == Timeout {
+		return
+	}
+
+	paid := sc.Outcome == Succeed
+	resultCode, resultDesc := 0, "The service request is processed successfully."
+	if !paid {
+		resultCode, resultDesc = resultOrDefault(sc, 1032, "Request cancelled by user")
+	}
+
+	amount := sc.PaidAmount
+	if amount == 0 {
+		fmt.Sscanf(req.Amount, "%g", &amount)
+	}
+
+	s.schedule(sc, func() (string, string, interface{}) {
+		s.mu.Lock()
+		txn.resolved, txn.resultCode, txn.resultDesc = true, resultCode, resultDesc
+		s.mu.Unlock()
+
+		callback := map[string]interface{}{
+			"MerchantRequestID": txn.merchantRequestID,
+			"CheckoutRequestID": txn.checkoutRequestID,
+			"ResultCode":        resultCode,
+			"ResultDesc":        resultDesc,
+		}
+		if paid {
+			// M-Pesa reports the amount, date and phone as JSON numbers
+			var phone, date json.Number
+			phone = json.Number(req.PhoneNumber)
+			date = json.Number(time.Now().In(eat).Format("20060102150405"))
+			callback["CallbackMetadata"] = map[string]interface{}{
+				"Item": []map[string]interface{}{
+					{"Name": "Amount", "Value": amount},
+					{"Name": "MpesaReceiptNumber", "Value": fmt.Sprintf("SIM%07d", id)},
+					{"Name": "TransactionDate", "Value": date},
+					{"Name": "PhoneNumber", "Value": phone},
+				},
+			}
+		}
+		return STKPush, req.CallBackURL, map[string]interface{}{"Body": map[string]interface{}{"stkCallback": callback}}
+	})
+}
+
+func (s *Server) handleSTKQuery(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.STKPushQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+
+	s.mu.Lock()
+	txn, ok := s.stk[req.CheckoutRequestID]
+	var resolved bool
+	var resultCode int
+	var resultDesc string
+	if ok {
+		resolved, resultCode, resultDesc = txn.resolved, txn.resultCode, txn.resultDesc
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid CheckoutRequestID")
+		return
+	}
+	if !resolved {
+		writeError(w, http.StatusInternalServerError, "500.001.1001", "The transaction is being processed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, mpesa.STKPushQueryResponse{
+		ResponseCode:        "0",
+		ResponseDescription: "The service request has been accepted successsfully",
+		MerchantRequestID:   txn.merchantRequestID,
+		CheckoutRequestID:   txn.checkoutRequestID,
+		ResultCode:          fmt.Sprintf("%d", resultCode),
+		ResultDesc:          resultDesc,
+	})
+}
+
+// reversalRequest is the part of a reversal request the simulator reads
+type reversalRequest struct {
+	Initiator          string `json:"Initiator"`
+	SecurityCredential string `json:"SecurityCredential"`
+	TransactionID      string `json:"TransactionID"`
+	Amount             string `json:"Amount"`
+	ResultURL          string `json:"ResultURL"`
+	QueueTimeOutURL    string `json:"QueueTimeOutURL"`
+}
+
+func (s *Server) handleReversal(w http.ResponseWriter, r *http.Request) {
+	var req reversalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+	if req.Initiator == "" || !s.validCredential(req.SecurityCredential) {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid SecurityCredential")
+		return
+	}
+
+	sc := s.scenarioFor(Reversal, req.TransactionID)
+	s.asyncResult(w, Reversal, sc, req.ResultURL, req.QueueTimeOutURL, func(id int) []resultParameter {
+		return []resultParameter{
+			{"DebitAccountBalance", "Utility Account|KES|51661.00|51661.00|0.00|0.00"},
+			{"Amount", req.Amount},
+			{"TransCompletedTime", time.Now().In(eat).Format("20060102150405")},
+			{"OriginalTransactionID", req.TransactionID},
+			{"Charge", "0.00"},
+			{"CreditPartyPublicName", "Simulated Customer"},
+		}
+	})
+}
+
+func (s *Server) handleB2C(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.B2CRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+	if req.InitiatorName == "" || !s.validCredential(req.SecurityCredential) {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid SecurityCredential")
+		return
+	}
+
+	sc := s.scenarioFor(B2C, req.PartyB)
+	s.asyncResult(w, B2C, sc, req.ResultURL, req.QueueTimeOutURL, func(id int) []resultParameter {
+		return []resultParameter{
+			{"TransactionAmount", req.Amount},
+			{"TransactionReceipt", fmt.Sprintf("SIM%07d", id)},
+			{"ReceiverPartyPublicName", req.PartyB + " - Simulated Customer"},
+			{"TransactionCompletedDateTime", time.Now().In(eat).Format("02.01.2006 15:04:05")},
+			{"B2CUtilityAccountAvailableFunds", 10116.00},
+			{"B2CWorkingAccountAvailableFunds", 900000.00},
+			{"B2CRecipientIsRegisteredCustomer", "Y"},
+		}
+	})
+}
+
+// resultParameter is one of the ResultParameters in an asynchronous result
+type resultParameter struct {
+	Key   string      `json:"Key"`
+	Value interface{} `json:"Value"`
+}
+
+// asyncResult acknowledges a queued request (reversal or B2C) and schedules its
+// result on resultURL, or a timeout notice on timeoutURL
+func (s *Server) asyncResult(w http.ResponseWriter, operation string, sc Scenario, resultURL, timeoutURL string, parameters func(id int) []resultParameter) {
+	time.Sleep(sc.ResponseDelay)
+	if sc.Outcome == Reject {
+		writeError(w, http.StatusBadRequest, "400.002.02", describe(sc, "Bad Request - Invalid Initiator"))
+		return
+	}
+
+	id := s.nextID()
+	conversationID := fmt.Sprintf("AG_%s_%06d", time.Now().Format("20060102"), id)
+	originatorID := fmt.Sprintf("sim-%d-%d", id, time.Now().Unix())
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"ConversationID":           conversationID,
+		"OriginatorConversationID": originatorID,
+		"ResponseCode":             "0",
+		"ResponseDescription":      "Accept the service request successfully.",
+	})
+
+	result := map[string]interface{}{
+		"ResultType":               0,
+		"ConversationID":           conversationID,
+		"OriginatorConversationID": originatorID,
+		"TransactionID":            fmt.Sprintf("SIM%07d", id),
+	}
+	url := resultURL
+	switch sc.Outcome {
+	case Timeout:
+		url = timeoutURL
+		result["ResultCode"], result["ResultDesc"] = resultOrDefault(sc, 1, "The request timed out in the queue")
+	case Fail:
+		result["ResultCode"], result["ResultDesc"] = resultOrDefault(sc, 2001, "The initiator information is invalid.")
+	default:
+		result["ResultCode"], result["ResultDesc"] = 0, "The service request is processed successfully."
+		result["ResultParameters"] = map[string]interface{}{"ResultParameter": parameters(id)}
+	}
+
+	s.schedule(sc, func() (string, string, interface{}) {
+		return operation, url, map[string]interface{}{"Result": result}
+	})
+}
+
+func (s *Server) handleRegisterURL(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.RegisterURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ShortCode == "" || req.ConfirmationURL == "" {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid ShortCode or ConfirmationURL")
+		return
+	}
+
+	s.mu.Lock()
+	s.c2bURLs[req.ShortCode] = req
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"OriginatorCoversationID": fmt.Sprintf("sim-%d", s.nextID()),
+		"ResponseCode":            "0",
+		"ResponseDescription":     "Success",
+	})
+}
+
+// c2bSimulateRequest is Daraja's sandbox request for a customer paying a Paybill
+type c2bSimulateRequest struct {
+	ShortCode     string      `json:"ShortCode"`
+	CommandID     string      `json:"CommandID"`
+	Amount        json.Number `json:"Amount"`
+	Msisdn        json.Number `json:"Msisdn"`
+	BillRefNumber string      `json:"BillRefNumber"`
+}
+
+// handleC2BSimulate plays a customer paying the Paybill: the registered validation
+// URL is asked first and, if it accepts, the confirmation URL is told
+func (s *Server) handleC2BSimulate(w http.ResponseWriter, r *http.Request) {
+	var req c2bSimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+
+	s.mu.Lock()
+	urls, registered := s.c2bURLs[req.ShortCode]
+	s.mu.Unlock()
+	if !registered {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - ShortCode has no registered URLs")
+		return
+	}
+
+	sc := s.scenarioFor(C2B, req.Msisdn.String())
+	time.Sleep(sc.ResponseDelay)
+	if sc.Outcome == Reject {
+		writeError(w, http.StatusBadRequest, "400.002.02", describe(sc, "Bad Request - Invalid Msisdn"))
+		return
+	}
+
+	id := s.nextID()
+	writeJSON(w, http.StatusOK, map[string]string{
+		"OriginatorCoversationID": fmt.Sprintf("sim-%d", id),
+		"ResponseCode":            "0",
+		"ResponseDescription":     "Accept the service request successfully.",
+	})
+
+	// The customer's payment failed at M-Pesa, so nothing is reported
+	if sc.Outcome == Fail {
+		return
+	}
+
+	notification := map[string]string{
+		"TransactionType":   "Pay Bill",
+		"TransID":           fmt.Sprintf("SIM%07d", id),
+		"TransTime":         time.Now().In(eat).Format("20060102150405"),
+		"TransAmount":       req.Amount.String(),
+		"BusinessShortCode": req.ShortCode,
+		"BillRefNumber":     req.BillRefNumber,
+		"OrgAccountBalance": "",
+		"MSISDN":            req.Msisdn.String(),
+		"FirstName":         "Simulated",
+		"LastName":          "Customer",
+	}
+
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		time.Sleep(sc.Delay)
+
+		// An unreachable validation URL is settled by the registered ResponseType
+		if sc.Outcome == Timeout {
+			if urls.ResponseType == "Cancelled" {
+				return
+			}
+		} else if urls.ValidationURL != "" {
+			var validation struct {
+				ResultCode string `json:"ResultCode"`
+			}
+			response := s.deliver(C2B, urls.ValidationURL, notification)
+			if json.Unmarshal([]byte(response), &validation) != nil || validation.ResultCode != "0" {
+				return
+			}
+		}
+
+		for i := 0; i <= sc.Duplicates; i++ {
+			s.deliver(C2B, urls.ConfirmationURL, notification)
+		}
+	}()
+}
+
+// schedule posts a result callback, and any duplicates, after the scenario's delay.
+// build runs when the delay is over, so queries see the request pending until then.
+func (s *Server) schedule(sc Scenario, build func() (operation, url string, payload interface{})) {
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		time.Sleep(sc.Delay)
+		operation, url, payload := build()
+		for i := 0; i <= sc.Duplicates; i++ {
+			s.deliver(operation, url, payload)
+		}
+	}()
+}
+
+// deliver posts a callback and logs it, returning the receiver's response body
+func (s *Server) deliver(operation, url string, payload interface{}) string {
+	body, _ := json.Marshal(payload)
+	delivery := Delivery{Operation: operation, URL: url, Body: body}
+
+	if url == "" {
+		delivery.Error = "no callback URL"
+	} else if resp, err := s.config.CallbackClient.Post(url, "application/json", bytes.NewReader(body)); err != nil {
+		delivery.Error = err.Error()
+	} else {
+		var response bytes.Buffer
+		response.ReadFrom(resp.Body)
+		resp.Body.Close()
+		delivery.StatusCode = resp.StatusCode
+		delivery.Response = response.String()
+	}
+
+	s.logf("callback %s -> %s: %d %s%s", operation, url, delivery.StatusCode, delivery.Response, delivery.Error)
+	s.mu.Lock()
+	s.delivered = append(s.delivered, delivery)
+	s.mu.Unlock()
+	return delivery.Response
+}
+
+// scenarioRequest is the body of PUT /simulator/scenarios/{operation}. Delays are
+// durations such as "2s"; party limits the scenario to one phone or TransactionID.
+type scenarioRequest struct {
+	Outcome       Outcome `json:"outcome"`
+	ResultCode    int     `json:"resultCode"`
+	ResultDesc    string  `json:"resultDesc"`
+	PaidAmount    float64 `json:"paidAmount"`
+	Delay         string  `json:"delay"`
+	Duplicates    int     `json:"duplicates"`
+	ResponseDelay string  `json:"responseDelay"`
+	Party         string  `json:"party"`
+}
+
+func (s *Server) handleSetScenario(w http.ResponseWriter, r *http.Request) {
+	var req scenarioRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400", err.Error())
+		return
+	}
+
+	switch req.Outcome {
+	case Succeed, Fail, Timeout, Reject:
+	default:
+		writeError(w, http.StatusBadRequest, "400", fmt.Sprintf("unknown outcome %q", req.Outcome))
+		return
+	}
+
+	sc := Scenario{
+		Outcome:    req.Outcome,
+		ResultCode: req.ResultCode,
+		ResultDesc: req.ResultDesc,
+		PaidAmount: req.PaidAmount,
+		Duplicates: req.Duplicates,
+	}
+	for _, d := range []struct {
+		value string
+		into  *time.Duration
+	}{{req.Delay, &sc.Delay}, {req.ResponseDelay, &sc.ResponseDelay}} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "400", fmt.Sprintf("invalid duration %q", d.value))
+			return
+		}
+		*d.into = parsed
+	}
+
+	operation := r.PathValue("operation")
+	switch operation {
+	case STKPush, Reversal, B2C, C2B:
+	default:
+		writeError(w, http.StatusNotFound, "404", fmt.Sprintf("unknown operation %q", operation))
+		return
+	}
+
+	if req.Party != "" {
+		s.SetScenarioFor(operation, req.Party, sc)
+	} else {
+		s.SetScenario(operation, sc)
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+func (s *Server) handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": s.Deliveries()})
+}
+
+func (s *Server) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(s.PublicKeyPEM())
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.config.Logger != nil {
+		s.config.Logger.Printf(format, args...)
+	}
+}
+
+// eat is the zone M-Pesa reports times in
+var eat = time.FixedZone("EAT", 3*60*60)
+
+// resultOrDefault returns the scenario's result code and description, or the
+// operation's typical failure when the scenario leaves them unset
+func resultOrDefault(sc Scenario, code int, desc string) (int, string) {
+	if sc.ResultCode != 0 {
+		code = sc.ResultCode
+	}
+	return code, describe(sc, desc)
+}
+
+func describe(sc Scenario, desc string) string {
+	if sc.ResultDesc != "" {
+		return sc.ResultDesc
+	}
+	return desc
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers in Daraja's error shape
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{
+		"requestId":    fmt.Sprintf("sim-%d", time.Now().UnixNano()),
+		"errorCode":    code,
+		"errorMessage": message,
+	})
+}
@@ -0,0 +1,307 @@
+package darajasim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/eddie-wainaina1/maggiesb/internal/payment/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver records callbacks posted by the simulator
+type receiver struct {
+	mu       sync.Mutex
+	bodies   map[string][][]byte // by path
+	response string
+}
+
+func newReceiver(t *testing.T) (*receiver, *httptest.Server) {
+	rec := &receiver{bodies: map[string][][]byte{}, response: `{"ResultCode":"0","ResultDesc":"Accepted"}`}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.bodies[r.URL.Path] = append(rec.bodies[r.URL.Path], body)
+		response := rec.response
+		rec.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return rec, server
+}
+
+func (r *receiver) received(path string) [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bodies[path]
+}
+
+// newSimulator starts a simulator and a client pointed at it, with callbacks going to callbackBase
+func newSimulator(t *testing.T, config Config, callbackBase string) (*Server, *mpesa.Client) {
+	sim := NewServer(config)
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+
+	keyPath := filepath.Join(t.TempDir(), "sim.pem")
+	if err := os.WriteFile(keyPath, sim.PublicKeyPEM(), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	client := mpesa.NewClient(mpesa.Config{
+		ConsumerKey:        "key",
+		ConsumerSecret:     "secret",
+		BusinessShortCode:  "174379",
+		PassKey:            "passkey",
+		CallbackURL:        callbackBase + "/stk",
+		InitiatorName:      "testapi",
+		InitiatorPassword:  "initiator-password",
+		PublicKeyPath:      keyPath,
+		ReversalResultURL:  callbackBase + "/reversal/result",
+		ReversalTimeoutURL: callbackBase + "/reversal/timeout",
+		B2CResultURL:       callbackBase + "/b2c/result",
+		B2CTimeoutURL:      callbackBase + "/b2c/timeout",
+		C2BConfirmationURL: callbackBase + "/c2b/confirmation",
+		C2BValidationURL:   callbackBase + "/c2b/validation",
+		BaseURL:            server.URL,
+	})
+	return sim, client
+}
+
+func simConfig() Config {
+	return Config{ConsumerKey: "key", ConsumerSecret: "secret", PassKey: "passkey", InitiatorPassword: "initiator-password"}
+}
+
+func TestSTKPush_SucceedPostsPaidCallback(t *testing.T) {
+	rec, callbacks := newReceiver(t)
+	sim, client := newSimulator(t, simConfig(), callbacks.URL)
+
+	resp, err := client.InitiateSTKPush(context.Background(), "254712345678", "1500.00", "inv-1", "tok")
+	assert.NoError(t, err)
+	sim.Wait()
+
+	bodies := rec.received("/stk/tok")
+	assert.Len(t, bodies, 1)
+	result, err := provider.ParseMpesaCallback(bodies[0])
+	assert.NoError(t, err)
+	assert.Equal(t, resp.CheckoutRequestID, result.Reference)
+	assert.True(t, result.Paid)
+	assert.Equal(t, 1500.0, result.PaidAmount)
+	assert.Equal(t, "254712345678", result.Payer)
+	assert.NotEmpty(t, result.Receipt)
+	assert.NotNil(t, result.TransactedAt)
+
+	query, err := client.STKPushQuery(context.Background(), resp.CheckoutRequestID)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", query.ResultCode)
+}
+
+func TestSTKPush_FailWithDuplicates(t *testing.T) {
+	rec, callbacks := newReceiver(t)
+	sim, client := newSimulator(t, simConfig(), callbacks.URL)
+	sim.SetScenarioFor(STKPush, "254700000001", Scenario{Outcome: Fail, Duplicates: 2})
+
+	resp, err := client.InitiateSTKPush(context.Background(), "254700000001", "100", "inv-1", "")
+	assert.NoError(t, err)
+	sim.Wait()
+
+	bodies := rec.received("/stk")
+	assert.Len(t, bodies, 3)
+	for _, body := range bodies {
+		result, err := provider.ParseMpesaCallback(body)
+		assert.NoError(t, err)
+		assert.False(t, result.Paid)
+		assert.Equal(t, "Request cancelled by user", result.Description)
+	}
+
+	query, err := client.STKPushQuery(context.Background(), resp.CheckoutRequestID)
+	assert.NoError(t, err)
+	assert.Equal(t, "1032", query.ResultCode)
+	assert.Len(t, sim.Deliveries(), 3)
+}
+
+func TestSTKPush_TimeoutStaysPending(t *testing.T) {
+	rec, callbacks := newReceiver(t)
+	sim, client := newSimulator(t, simConfig(), callbacks.URL)
+	sim.SetScenario(STKPush, Scenario{Outcome: Timeout})
+
+	resp, err := client.InitiateSTKPush(context.Background(), "254712345678", "100", "inv-1", "")
+	assert.NoError(t, err)
+	sim.Wait()
+
+	assert.Empty(t, rec.received("/stk"))
+	_, err = client.STKPushQuery(context.Background(), resp.CheckoutRequestID)
+	assert.True(t, errors.Is(err, mpesa.ErrTransactionPending))
+}
+
+func TestSTKPush_Reject(t *testing.T) {
+	_, callbacks := newReceiver(t)
+	sim, client := newSimulator(t, simConfig(), callbacks.URL)
+	sim.SetScenario(STKPush, Scenario{Outcome: Reject})
+
+	_, err := client.InitiateSTKPush(context.Background(), "254712345678", "100", "inv-1", "")
+	assert.Error(t, err)
+	assert.Empty(t, sim.Deliveries())
+}
+
+func TestSTKPush_ChecksCredentials(t *testing.T) {
+	_, callbacks := newReceiver(t)
+	config := simConfig()
+	config.ConsumerSecret = "other"
+	_, client := newSimulator(t, config, callbacks.URL)
+
+	_, err := client.InitiateSTKPush(context.Background(), "254712345678", "100", "inv-1", "")
+	assert.Error(t, err)
+
+	config = simConfig()
+	config.PassKey = "other"
+	_, client = newSimulator(t, config, callbacks.URL)
+
+	_, err = client.InitiateSTKPush(context.Background(), "254712345678", "100", "inv-1", "")
+	assert.Error(t, err)
+}
+
+func TestB2C_ResultAndTimeout(t *testing.T) {
+	rec, callbacks := newReceiver(t)
+	sim, client := newSimulator(t, simConfig(), callbacks.URL)
+	sim.SetScenarioFor(B2C, "254700000002", Scenario{Outcome: Timeout})
+
+	resp, err := client.InitiateB2CPayment(context.Background(), "254712345678", "500", mpesa.B2CBusinessPayment, "Refund", "inv-1", "")
+	assert.NoError(t, err)
+	_, err = client.InitiateB2CPayment(context.Background(), "254700000002", "500", mpesa.B2CBusinessPayment, "Refund", "inv-2", "")
+	assert.NoError(t, err)
+	sim.Wait()
+
+	results := rec.received("/b2c/result")
+	assert.Len(t, results, 1)
+	var result struct {
+		Result struct {
+			ResultCode       int
+			ConversationID   string
+			ResultParameters struct {
+				ResultParameter []resultParameter
+			}
+		}
+	}
+	assert.NoError(t, json.Unmarshal(results[0], &result))
+	assert.Equal(t, 0, result.Result.ResultCode)
+	assert.Equal(t, resp.ConversationID, result.Result.ConversationID)
+	assert.Contains(t, result.Result.ResultParameters.ResultParameter, resultParameter{"ReceiverPartyPublicName", "254712345678 - Simulated Customer"})
+
+	assert.Len(t, rec.received("/b2c/timeout"), 1)
+}
+
+func TestReversal_Fail(t *testing.T) {
+	rec, callbacks := newReceiver(t)
+	sim, client := newSimulator(t, simConfig(), callbacks.URL)
+	sim.SetScenarioFor(Reversal, "SIM0000009", Scenario{Outcome: Fail, ResultCode: 2001})
+
+	_, err := client.InitiateReversal(context.Background(), "SIM0000009", "100", "inv-1", "")
+	assert.NoError(t, err)
+	sim.Wait()
+
+	results := rec.received("/reversal/result")
+	assert.Len(t, results, 1)
+	assert.Contains(t, string(results[0]), `"ResultCode":2001`)
+}
+
+func TestReversal_RejectsBadCredential(t *testing.T) {
+	_, callbacks := newReceiver(t)
+	config := simConfig()
+	config.InitiatorPassword = "different"
+	_, client := newSimulator(t, config, callbacks.URL)
+
+	_, err := client.InitiateReversal(context.Background(), "SIM0000009", "100", "inv-1", "")
+	assert.Error(t, err)
+}
+
+// simulateC2B pays the Paybill through the sandbox simulate endpoint
+func simulateC2B(t *testing.T, client *mpesa.Client, baseURL, phone, billRef string) int {
+	token, err := client.GetAccessToken(context.Background())
+	assert.NoError(t, err)
+	body := `{"ShortCode":"174379","CommandID":"CustomerPayBillOnline","Amount":250,"Msisdn":` + phone + `,"BillRefNumber":"` + billRef + `"}`
+	req, _ := http.NewRequest("POST", baseURL+"/mpesa/c2b/v1/simulate", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestC2B_ValidationThenConfirmation(t *testing.T) {
+	rec, callbacks := newReceiver(t)
+	sim := NewServer(simConfig())
+	server := httptest.NewServer(sim)
+	defer server.Close()
+	client := mpesa.NewClient(mpesa.Config{
+		ConsumerKey:        "key",
+		ConsumerSecret:     "secret",
+		BusinessShortCode:  "174379",
+		C2BConfirmationURL: callbacks.URL + "/c2b/confirmation",
+		C2BValidationURL:   callbacks.URL + "/c2b/validation",
+		BaseURL:            server.URL,
+	})
+
+	// Payments are refused until URLs are registered
+	assert.Equal(t, http.StatusBadRequest, simulateC2B(t, client, server.URL, "254712345678", "inv-1"))
+
+	_, err := client.RegisterC2BURLs(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, simulateC2B(t, client, server.URL, "254712345678", "inv-1"))
+	sim.Wait()
+	assert.Len(t, rec.received("/c2b/validation"), 1)
+	confirmations := rec.received("/c2b/confirmation")
+	assert.Len(t, confirmations, 1)
+	assert.Contains(t, string(confirmations[0]), `"BillRefNumber":"inv-1"`)
+	assert.Contains(t, string(confirmations[0]), `"TransAmount":"250"`)
+
+	// A rejected validation stops the payment
+	rec.mu.Lock()
+	rec.response = `{"ResultCode":"C2B00012","ResultDesc":"Rejected"}`
+	rec.mu.Unlock()
+	simulateC2B(t, client, server.URL, "254712345678", "unknown")
+	sim.Wait()
+	assert.Len(t, rec.received("/c2b/validation"), 2)
+	assert.Len(t, rec.received("/c2b/confirmation"), 1)
+}
+
+func TestControlEndpoints(t *testing.T) {
+	sim := NewServer(Config{})
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	req, _ := http.NewRequest("PUT", server.URL+"/simulator/scenarios/stk_push", bytes.NewBufferString(`{"outcome":"fail","delay":"10ms","duplicates":1,"party":"254712345678"}`))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	sc := sim.scenarioFor(STKPush, "254712345678")
+	assert.Equal(t, Fail, sc.Outcome)
+	assert.Equal(t, 1, sc.Duplicates)
+	assert.Equal(t, "10ms", sc.Delay.String())
+	assert.Equal(t, Succeed, sim.scenarioFor(STKPush, "254700000000").Outcome)
+
+	for _, body := range []string{`{"outcome":"explode"}`, `{"outcome":"fail","delay":"soon"}`} {
+		req, _ = http.NewRequest("PUT", server.URL+"/simulator/scenarios/stk_push", bytes.NewBufferString(body))
+		resp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(server.URL + "/simulator/certificate")
+	assert.NoError(t, err)
+	pemBytes, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, sim.PublicKeyPEM(), pemBytes)
+}
@@ -0,0 +1,133 @@
+package mpesa
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrorKind classifies why a Daraja call failed, so callers can decide whether to
+// retry and which HTTP status to answer with
+type ErrorKind string
+
+const (
+	// ErrorAuth means Daraja refused our consumer credentials or access token
+	ErrorAuth ErrorKind = "auth"
+	// ErrorValidation means Daraja rejected the request itself, e.g. an invalid phone
+	// number, amount or callback URL. Sending it again will not help.
+	ErrorValidation ErrorKind = "validation"
+	// ErrorUpstream means Daraja failed, could not be reached or sent a response we
+	// could not read
+	ErrorUpstream ErrorKind = "upstream"
+	// ErrorTimeout means Daraja did not answer in time
+	ErrorTimeout ErrorKind = "timeout"
+)
+
+// ErrCircuitOpen is returned, wrapped in an upstream Error, while calls are paused
+// after repeated Daraja failures
+var ErrCircuitOpen = errors.New("M-Pesa is unavailable, try again shortly")
+
+// invalidTokenCode is the Daraja error code for an expired or revoked access token
+const invalidTokenCode = "404.001.03"
+
+// Error is a failed Daraja call
+type Error struct {
+	Kind       ErrorKind
+	Op         string // what failed, e.g. "STK push failed"
+	StatusCode int    // HTTP status Daraja answered with; 0 if it did not answer
+	Code       string // Daraja errorCode or ResponseCode
+	Message    string // Daraja errorMessage or ResponseDescription
+	Err        error  // underlying transport or parse error
+}
+
+func (e *Error) Error() string {
+	var detail string
+	switch {
+	case e.Code != "" && e.Message != "":
+		detail = e.Code + " - " + e.Message
+	case e.Message != "":
+		detail = e.Message
+	case e.Code != "":
+		detail = e.Code
+	}
+	if e.Err != nil {
+		if detail == "" {
+			detail = e.Err.Error()
+		} else {
+			detail += ": " + e.Err.Error()
+		}
+	}
+	return fmt.Sprintf("%s: %s", e.Op, detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the same call may succeed if sent again: timeouts,
+// unreachable or failing servers and rate limiting, but not a transaction Daraja
+// reports as still in progress
+func (e *Error) Temporary() bool {
+	if errors.Is(e.Err, ErrCircuitOpen) || e.Code == pendingErrorCode {
+		return false
+	}
+	switch e.Kind {
+	case ErrorTimeout:
+		return true
+	case ErrorUpstream:
+		return (e.StatusCode == 0 && e.Err != nil) || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	}
+	return false
+}
+
+// HTTPStatus is the status an API handler should answer with when a Daraja call
+// fails with err, or fallback if err did not come from Daraja
+func HTTPStatus(err error, fallback int) int {
+	if errors.Is(err, ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	var mpesaErr *Error
+	if !errors.As(err, &mpesaErr) {
+		return fallback
+	}
+	switch mpesaErr.Kind {
+	case ErrorValidation:
+		return http.StatusBadRequest
+	case ErrorTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		// Bad credentials are our misconfiguration, not the caller's
+		return http.StatusBadGateway
+	}
+}
+
+// errorKind classifies a Daraja error response. Daraja error codes start with the
+// HTTP status they stand for ("400.002.02"), which is trusted over the status line
+// because some errors arrive with a 200.
+func errorKind(status int, code string) ErrorKind {
+	if code == invalidTokenCode {
+		return ErrorAuth
+	}
+	if prefix, _, ok := strings.Cut(code, "."); ok {
+		if n, err := strconv.Atoi(prefix); err == nil {
+			status = n
+		}
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorAuth
+	case status == http.StatusTooManyRequests || status >= 500:
+		return ErrorUpstream
+	case status >= 400:
+		return ErrorValidation
+	}
+	return ErrorUpstream
+}
+
+// rejected builds the error for a request Daraja answered with a non-zero ResponseCode
+func rejected(op, code, description string) *Error {
+	return &Error{Kind: ErrorValidation, Op: op, StatusCode: http.StatusOK, Code: code, Message: description}
+}
@@ -1,17 +1,19 @@
 package mpesa
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -35,16 +37,43 @@ type Config struct {
 	PublicKeyPath     string // path to Safaricom public cert (PEM)
 	ReversalResultURL string // result callback URL for reversal
 	ReversalTimeoutURL string // timeout callback URL for reversal
+	// C2B (Paybill/Till) settings (optional)
+	C2BConfirmationURL string // receives completed customer payments
+	C2BValidationURL   string // asked to accept or reject a payment before it completes
+	C2BResponseType    string // "Completed" or "Cancelled": what M-Pesa does if validation is unreachable
+	// B2C (refund payouts) settings (optional); uses the initiator settings above
+	B2CShortCode  string // short code refunds are paid from; defaults to BusinessShortCode
+	B2CResultURL  string // result callback URL for B2C payments
+	B2CTimeoutURL string // timeout callback URL for B2C payments
+	BaseURL           string // overrides the Daraja base URL (e.g. a local stand-in); optional
+	// Resilience settings (optional); zero values use the defaults
+	Timeout          time.Duration // per-attempt HTTP timeout; 30s
+	MaxRetries       int           // retries of idempotent calls (token, STK query, C2B registration); 2, none if negative
+	RetryBackoff     time.Duration // delay before the first retry, doubling after each; 500ms
+	BreakerThreshold int           // consecutive Daraja failures that pause calls; 5, never if negative
+	BreakerCooldown  time.Duration // how long calls stay paused before a trial call; 30s
 }
 
 // Client handles M-Pesa API interactions
 type Client struct {
 	config       Config
 	baseURL      string
-	accessToken  string
-	tokenExpiry  time.Time
-	tokenMutex   sync.RWMutex
 	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+	breaker      *circuitBreaker
+
+	tokenMutex  sync.Mutex
+	accessToken string
+	tokenExpiry time.Time
+	tokenFetch  *tokenFetch // refresh in progress, shared by concurrent callers
+}
+
+// tokenFetch is one OAuth token request; done is closed once token or err is set
+type tokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
 }
 
 // NewClient creates a new M-Pesa client
@@ -53,57 +82,142 @@ func NewClient(config Config) *Client {
 	if config.Environment == "production" {
 		baseURL = ProductionBaseURL
 	}
+	if config.BaseURL != "" {
+		baseURL = config.BaseURL
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	maxRetries := config.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+	retryBackoff := config.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = defaultRetryBackoff
+	}
+	breaker := &circuitBreaker{threshold: config.BreakerThreshold, cooldown: config.BreakerCooldown}
+	if breaker.threshold == 0 {
+		breaker.threshold = defaultBreakerThreshold
+	}
+	if breaker.cooldown <= 0 {
+		breaker.cooldown = defaultBreakerCooldown
+	}
 
 	return &Client{
-		config:     config,
-		baseURL:    baseURL,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+		config:       config,
+		baseURL:      baseURL,
+		httpClient:   &http.Client{Timeout: timeout},
+		maxRetries:   maxRetries,
+		retryBackoff: retryBackoff,
+		breaker:      breaker,
 	}
 }
 
-// GetAccessToken retrieves a valid OAuth access token
-func (c *Client) GetAccessToken() (string, error) {
-	c.tokenMutex.RLock()
+// CircuitOpen reports whether calls to Daraja are paused after repeated failures
+func (c *Client) CircuitOpen() bool {
+	return c.breaker.open()
+}
+
+// GetAccessToken returns a valid OAuth access token, fetching one if the cached
+// token has expired. Concurrent callers share a single fetch.
+func (c *Client) GetAccessToken(ctx context.Context) (string, error) {
+	c.tokenMutex.Lock()
 	if c.accessToken != "" && time.Now().Before(c.tokenExpiry) {
-		defer c.tokenMutex.RUnlock()
-		return c.accessToken, nil
+		token := c.accessToken
+		c.tokenMutex.Unlock()
+		return token, nil
+	}
+	fetch := c.tokenFetch
+	if fetch == nil {
+		fetch = &tokenFetch{done: make(chan struct{})}
+		c.tokenFetch = fetch
+		// The fetch outlives any one caller giving up, since others may be waiting on it
+		go c.fetchToken(context.WithoutCancel(ctx), fetch)
 	}
-	c.tokenMutex.RUnlock()
+	c.tokenMutex.Unlock()
 
-	url := fmt.Sprintf("%s/oauth/v1/generate?grant_type=client_credentials", c.baseURL)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+	select {
+	case <-fetch.done:
+		return fetch.token, fetch.err
+	case <-ctx.Done():
+		return "", &Error{Kind: ErrorTimeout, Op: tokenOp, Err: ctx.Err()}
 	}
+}
 
-	req.SetBasicAuth(c.config.ConsumerKey, c.config.ConsumerSecret)
+const tokenOp = "failed to get access token"
 
-	resp, err := c.httpClient.Do(req)
+// fetchToken requests a new token, retrying like other idempotent calls, and
+// publishes the result to everyone waiting on fetch
+func (c *Client) fetchToken(ctx context.Context, fetch *tokenFetch) {
+	token, expiresIn, err := c.requestToken(ctx)
+	for attempt := 0; err != nil && attempt < c.maxRetries; attempt++ {
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || !apiErr.Temporary() {
+			break
+		}
+		time.Sleep(c.backoff(attempt))
+		token, expiresIn, err = c.requestToken(ctx)
+	}
+
+	c.tokenMutex.Lock()
+	if err == nil {
+		// Renew a little early so a token does not expire while a request is in flight
+		lifetime := time.Duration(expiresIn) * time.Second
+		c.accessToken = token
+		c.tokenExpiry = time.Now().Add(lifetime - min(time.Minute, lifetime/10))
+	}
+	c.tokenFetch = nil
+	c.tokenMutex.Unlock()
+
+	fetch.token, fetch.err = token, err
+	close(fetch.done)
+}
+
+func (c *Client) requestToken(ctx context.Context) (string, int64, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to get access token: %w", err)
+		return "", 0, fmt.Errorf("failed to create request: %w", err)
 	}
-	defer resp.Body.Close()
+	req.SetBasicAuth(c.config.ConsumerKey, c.config.ConsumerSecret)
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.do(ctx, tokenOp, req)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		// Any refusal from the OAuth endpoint means the credentials are wrong
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Kind == ErrorValidation {
+			apiErr.Kind = ErrorAuth
+		}
+		return "", 0, err
 	}
 
+	// Daraja sends expires_in as a string ("3599"); json.Number accepts either form
 	var tokenResp struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
 	}
-
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return "", fmt.Errorf("failed to parse token response: %w", err)
+	if err := decode(tokenOp, body, &tokenResp); err != nil {
+		return "", 0, err
+	}
+	if tokenResp.AccessToken == "" {
+		return "", 0, &Error{Kind: ErrorAuth, Op: tokenOp, StatusCode: http.StatusOK, Message: "no access token in response"}
 	}
+	expiresIn, _ := tokenResp.ExpiresIn.Int64()
 
+	return tokenResp.AccessToken, expiresIn, nil
+}
+
+// invalidateToken drops token from the cache if it is still the cached one
+func (c *Client) invalidateToken(token string) {
 	c.tokenMutex.Lock()
-	c.accessToken = tokenResp.AccessToken
-	c.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	if c.accessToken == token {
+		c.accessToken = ""
+	}
 	c.tokenMutex.Unlock()
-
-	return tokenResp.AccessToken, nil
 }
 
 // STKPushRequest payload for initiating STK Push
@@ -130,12 +244,43 @@ type STKPushResponse struct {
 	CustomerMessage     string `json:"CustomerMessage"`
 }
 
-// InitiateSTKPush initiates an M-Pesa STK Push payment
-func (c *Client) InitiateSTKPush(phone, amount, invoiceID string) (*STKPushResponse, error) {
-	token, err := c.GetAccessToken()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+// SafaricomCallbackIPs are the addresses Safaricom documents for Daraja callbacks.
+// Check them against the current Daraja documentation before relying on them.
+var SafaricomCallbackIPs = []string{
+	"196.201.214.200",
+	"196.201.214.206",
+	"196.201.213.114",
+	"196.201.214.207",
+	"196.201.214.208",
+	"196.201.213.44",
+	"196.201.212.127",
+	"196.201.212.138",
+	"196.201.212.129",
+	"196.201.212.136",
+	"196.201.212.74",
+	"196.201.212.69",
+}
+
+// CallbackURLFor returns the STK Push callback URL for a payment. A non-empty token
+// is appended as the final path segment, so only Safaricom (which is told the URL)
+// can post a result for that payment.
+func (c *Client) CallbackURLFor(token string) string {
+	return withCallbackToken(c.config.CallbackURL, token)
+}
+
+// withCallbackToken appends a non-empty token to a result URL as its final path segment
+func withCallbackToken(resultURL, token string) string {
+	if token == "" || resultURL == "" {
+		return resultURL
 	}
+	return strings.TrimRight(resultURL, "/") + "/" + url.PathEscape(token)
+}
+
+// InitiateSTKPush initiates an M-Pesa STK Push payment. callbackToken, if set, is
+// embedded in the callback URL (see CallbackURLFor). It is never retried: a push
+// whose response was lost may still reach the customer's phone.
+func (c *Client) InitiateSTKPush(ctx context.Context, phone, amount, invoiceID, callbackToken string) (*STKPushResponse, error) {
+	const op = "STK push failed"
 
 	timestamp := time.Now().Format("20060102150405")
 	password := generatePassword(c.config.BusinessShortCode, c.config.PassKey, timestamp)
@@ -149,67 +294,113 @@ func (c *Client) InitiateSTKPush(phone, amount, invoiceID string) (*STKPushRespo
 		PartyA:            phone,
 		PartyB:            c.config.BusinessShortCode,
 		PhoneNumber:       phone,
-		CallBackURL:       c.config.CallbackURL,
+		CallBackURL:       c.CallbackURLFor(callbackToken),
 		AccountReference:  invoiceID,
 		TransactionDesc:   "Order Payment",
 	}
 
-	payloadBytes, err := json.Marshal(payload)
+	body, err := c.call(ctx, apiRequest{op: op, path: "/mpesa/stkpush/v1/processrequest", payload: payload})
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		return nil, err
 	}
 
-	url := fmt.Sprintf("%s/mpesa/stkpush/v1/processrequest", c.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	var stkResp STKPushResponse
+	if err := decode(op, body, &stkResp); err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if stkResp.ResponseCode != "0" {
+		return nil, rejected(op, stkResp.ResponseCode, stkResp.ResponseDescription)
+	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send STK push request: %w", err)
+	return &stkResp, nil
+}
+
+// STKPushQueryRequest payload for querying the status of an STK Push
+type STKPushQueryRequest struct {
+	BusinessShortCode string `json:"BusinessShortCode"`
+	Password          string `json:"Password"`
+	Timestamp         string `json:"Timestamp"`
+	CheckoutRequestID string `json:"CheckoutRequestID"`
+}
+
+// STKPushQueryResponse from Safaricom. ResultCode "0" means the customer paid;
+// any other result code is a final failure (e.g. "1032" cancelled by the user).
+type STKPushQueryResponse struct {
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	MerchantRequestID   string `json:"MerchantRequestID"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	ResultCode          string `json:"ResultCode"`
+	ResultDesc          string `json:"ResultDesc"`
+}
+
+// ErrTransactionPending is returned by STKPushQuery while the customer has not yet
+// completed or cancelled the payment prompt
+var ErrTransactionPending = errors.New("mpesa transaction is still being processed")
+
+// pendingErrorCode is the Daraja error code for a transaction still in progress
+const pendingErrorCode = "500.001.1001"
+
+// STKPushQuery asks Safaricom for the final result of an STK Push
+func (c *Client) STKPushQuery(ctx context.Context, checkoutRequestID string) (*STKPushQueryResponse, error) {
+	const op = "STK push query failed"
+
+	timestamp := time.Now().Format("20060102150405")
+	payload := STKPushQueryRequest{
+		BusinessShortCode: c.config.BusinessShortCode,
+		Password:          generatePassword(c.config.BusinessShortCode, c.config.PassKey, timestamp),
+		Timestamp:         timestamp,
+		CheckoutRequestID: checkoutRequestID,
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.call(ctx, apiRequest{op: op, path: "/mpesa/stkpushquery/v1/query", payload: payload, idempotent: true})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Code == pendingErrorCode {
+			return nil, ErrTransactionPending
+		}
+		return nil, err
 	}
 
-	var stkResp STKPushResponse
-	if err := json.Unmarshal(body, &stkResp); err != nil {
-		return nil, fmt.Errorf("failed to parse STK push response: %w", err)
+	var queryResp STKPushQueryResponse
+	if err := decode(op, body, &queryResp); err != nil {
+		return nil, err
 	}
 
-	if stkResp.ResponseCode != "0" {
-		return nil, fmt.Errorf("STK push failed: %s - %s", stkResp.ResponseCode, stkResp.ResponseDescription)
+	if queryResp.ResponseCode != "0" {
+		return nil, rejected(op, queryResp.ResponseCode, queryResp.ResponseDescription)
 	}
 
-	return &stkResp, nil
+	return &queryResp, nil
 }
 
-// InitiateReversal attempts to call M-Pesa transaction reversal endpoint.
-// Note: Producing a valid SecurityCredential (encrypted initiator password)
-// is required for production; this method acts as a scaffold and will return
-// an error if SecurityCredential is not set in Config (not implemented).
-func (c *Client) InitiateReversal(phone, amount, invoiceID string) error {
+// ReversalResponse from Safaricom acknowledging a reversal request. The outcome
+// arrives later on ReversalResultURL (or ReversalTimeoutURL), identified by these IDs.
+type ReversalResponse struct {
+	ConversationID           string `json:"ConversationID"`
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+}
+
+// InitiateReversal asks M-Pesa to reverse a completed transaction, identified by its
+// receipt number. The request is only queued: Safaricom reports whether the reversal
+// succeeded asynchronously, so callers must wait for the result callback before
+// treating the money as returned. callbackToken, if set, is embedded in the result and
+// timeout URLs the same way as for STK Push.
+func (c *Client) InitiateReversal(ctx context.Context, transactionID, amount, invoiceID, callbackToken string) (*ReversalResponse, error) {
+	const op = "mpesa reversal error"
+
 	// Validate required reversal config
 	if c.config.InitiatorName == "" || c.config.InitiatorPassword == "" || c.config.PublicKeyPath == "" {
-		return fmt.Errorf("mpesa reversal not configured: missing initiator or public key")
+		return nil, fmt.Errorf("mpesa reversal not configured: missing initiator or public key")
 	}
 
 	// Encrypt initiator password using Safaricom public key to produce SecurityCredential
 	secCred, err := c.encryptSecurityCredential(c.config.InitiatorPassword)
 	if err != nil {
-		return fmt.Errorf("failed to generate SecurityCredential: %w", err)
-	}
-
-	token, err := c.GetAccessToken()
-	if err != nil {
-		return fmt.Errorf("failed to get access token: %w", err)
+		return nil, fmt.Errorf("failed to generate SecurityCredential: %w", err)
 	}
 
 	// Build reversal payload according to M-Pesa Transaction Reversal API
@@ -217,49 +408,31 @@ func (c *Client) InitiateReversal(phone, amount, invoiceID string) error {
 		"Initiator":            c.config.InitiatorName,
 		"SecurityCredential":   secCred,
 		"CommandID":            "TransactionReversal",
+		"TransactionID":        transactionID,
 		"Amount":               amount,
 		"ReceiverParty":        c.config.BusinessShortCode,
 		"RecieverIdentifierType": "11",
-		"ResultURL":            c.config.ReversalResultURL,
-		"QueueTimeOutURL":      c.config.ReversalTimeoutURL,
+		"ResultURL":            withCallbackToken(c.config.ReversalResultURL, callbackToken),
+		"QueueTimeOutURL":      withCallbackToken(c.config.ReversalTimeoutURL, callbackToken),
 		"Remarks":              "Reversal for invoice " + invoiceID,
 		"Occasion":             invoiceID,
 	}
 
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal reversal payload: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/mpesa/reversal/v1/request", c.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return fmt.Errorf("failed to create reversal request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-	resp, err := c.httpClient.Do(req)
+	body, err := c.call(ctx, apiRequest{op: op, path: "/mpesa/reversal/v1/request", payload: payload})
 	if err != nil {
-		return fmt.Errorf("failed to send reversal request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read reversal response: %w", err)
+	var reversalResp ReversalResponse
+	if err := decode(op, body, &reversalResp); err != nil {
+		return nil, err
 	}
 
-	// Try to parse response and detect errors
-	var respMap map[string]interface{}
-	if err := json.Unmarshal(body, &respMap); err == nil {
-		if _, ok := respMap["errorMessage"]; ok {
-			return fmt.Errorf("mpesa reversal error: %v", respMap["errorMessage"])
-		}
+	if reversalResp.ResponseCode != "0" {
+		return nil, rejected(op, reversalResp.ResponseCode, reversalResp.ResponseDescription)
 	}
 
-	return nil
+	return &reversalResp, nil
 }
 
 // encryptSecurityCredential encrypts the initiator password using the provided
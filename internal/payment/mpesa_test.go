@@ -1,6 +1,7 @@
 package mpesa
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -143,12 +144,12 @@ func TestGetAccessToken_CacheHit(t *testing.T) {
 	c.baseURL = server.URL
 
 	// First call - should fetch from server
-	token1, err := c.GetAccessToken()
+	token1, err := c.GetAccessToken(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, "test_token_123", token1)
 
 	// Second call - should use cache
-	token2, err := c.GetAccessToken()
+	token2, err := c.GetAccessToken(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, "test_token_123", token2)
 }
@@ -182,14 +183,14 @@ func TestGetAccessToken_Expiry(t *testing.T) {
 	c.baseURL = server.URL
 
 	// First call
-	token1, _ := c.GetAccessToken()
+	token1, _ := c.GetAccessToken(context.Background())
 	assert.Equal(t, "token_1", token1)
 
 	// Wait for expiry
 	time.Sleep(1100 * time.Millisecond)
 
 	// Second call - should fetch new token
-	token2, _ := c.GetAccessToken()
+	token2, _ := c.GetAccessToken(context.Background())
 	assert.Equal(t, "token_2", token2)
 	assert.Equal(t, 2, callCount)
 }
@@ -204,7 +205,7 @@ func TestGetAccessToken_NetworkError(t *testing.T) {
 	c := NewClient(config)
 	c.baseURL = "http://invalid-url-that-doesnt-exist"
 
-	_, err := c.GetAccessToken()
+	_, err := c.GetAccessToken(context.Background())
 	assert.Error(t, err)
 }
 
@@ -224,7 +225,7 @@ func TestGetAccessToken_InvalidResponse(t *testing.T) {
 	c := NewClient(config)
 	c.baseURL = server.URL
 
-	_, err := c.GetAccessToken()
+	_, err := c.GetAccessToken(context.Background())
 	assert.Error(t, err)
 }
 
@@ -264,7 +265,7 @@ func TestInitiateSTKPush_Success(t *testing.T) {
 	c := NewClient(config)
 	c.baseURL = server.URL
 
-	resp, err := c.InitiateSTKPush("254712345678", "100", "invoice-123")
+	resp, err := c.InitiateSTKPush(context.Background(), "254712345678", "100", "invoice-123", "")
 	assert.NoError(t, err)
 	assert.Equal(t, "0", resp.ResponseCode)
 	assert.Equal(t, "ws_CO_191220191020375136", resp.CheckoutRequestID)
@@ -288,7 +289,7 @@ func TestInitiateSTKPush_NoToken(t *testing.T) {
 	c := NewClient(config)
 	c.baseURL = server.URL
 
-	_, err := c.InitiateSTKPush("254712345678", "100", "invoice-123")
+	_, err := c.InitiateSTKPush(context.Background(), "254712345678", "100", "invoice-123", "")
 	assert.Error(t, err)
 }
 
@@ -323,7 +324,7 @@ func TestInitiateSTKPush_ErrorResponse(t *testing.T) {
 	c := NewClient(config)
 	c.baseURL = server.URL
 
-	_, err := c.InitiateSTKPush("254712345678", "100", "invoice-123")
+	_, err := c.InitiateSTKPush(context.Background(), "254712345678", "100", "invoice-123", "")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "STK push failed")
 }
@@ -335,7 +336,7 @@ func TestInitiateReversal_NotConfigured(t *testing.T) {
 	}
 	c := NewClient(config)
 
-	err := c.InitiateReversal("254712345678", "100", "invoice-123")
+	_, err := c.InitiateReversal(context.Background(), "NHY4GT5HJI", "100", "invoice-123", "")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not configured")
 }
@@ -354,7 +355,7 @@ func TestInitiateReversal_InvalidPublicKey(t *testing.T) {
 	}
 	c := NewClient(config)
 
-	err := c.InitiateReversal("254712345678", "100", "invoice-123")
+	_, err := c.InitiateReversal(context.Background(), "NHY4GT5HJI", "100", "invoice-123", "")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "SecurityCredential")
 }
@@ -370,6 +371,7 @@ func TestInitiateReversal_Success(t *testing.T) {
 	pem.Encode(f, &pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
 	f.Close()
 
+	var got map[string]string
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.RequestURI == "/oauth/v1/generate?grant_type=client_credentials" {
 			w.Header().Set("Content-Type", "application/json")
@@ -380,6 +382,7 @@ func TestInitiateReversal_Success(t *testing.T) {
 			return
 		}
 
+		json.NewDecoder(r.Body).Decode(&got)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"ConversationID":      "AG_20240101_1234567890abcdef",
@@ -402,8 +405,13 @@ func TestInitiateReversal_Success(t *testing.T) {
 	c := NewClient(config)
 	c.baseURL = server.URL
 
-	err := c.InitiateReversal("254712345678", "100", "invoice-123")
+	resp, err := c.InitiateReversal(context.Background(), "NHY4GT5HJI", "100", "invoice-123", "abc123")
 	assert.NoError(t, err)
+	assert.Equal(t, "AG_20240101_1234567890abcdef", resp.ConversationID)
+	assert.Equal(t, "12345-1234567-1", resp.OriginatorConversationID)
+	assert.Equal(t, "NHY4GT5HJI", got["TransactionID"])
+	assert.Equal(t, "https://example.com/reversal/result/abc123", got["ResultURL"])
+	assert.Equal(t, "https://example.com/reversal/timeout/abc123", got["QueueTimeOutURL"])
 }
 
 // TestInitiateReversal_ErrorResponse tests handling of reversal errors
@@ -446,7 +454,7 @@ func TestInitiateReversal_ErrorResponse(t *testing.T) {
 	c := NewClient(config)
 	c.baseURL = server.URL
 
-	err := c.InitiateReversal("254712345678", "100", "invoice-123")
+	_, err := c.InitiateReversal(context.Background(), "NHY4GT5HJI", "100", "invoice-123", "")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "mpesa reversal error")
 }
@@ -481,3 +489,109 @@ func TestGeneratePassword_DifferentInputs(t *testing.T) {
 		assert.Equal(t, expected, got)
 	}
 }
+
+// TestNewClient_BaseURLOverride verifies a configured base URL wins over the environment
+func TestNewClient_BaseURLOverride(t *testing.T) {
+	c := NewClient(Config{Environment: "production", BaseURL: "http://localhost:9000"})
+	assert.Equal(t, "http://localhost:9000", c.baseURL)
+}
+
+// queryTestServer stands in for Daraja, answering STK queries with the given body
+func queryTestServer(t *testing.T, queryBody interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.RequestURI == "/oauth/v1/generate?grant_type=client_credentials" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": "test_token",
+				"expires_in":   3600,
+			})
+			return
+		}
+
+		assert.Equal(t, "/mpesa/stkpushquery/v1/query", r.URL.Path)
+		var req STKPushQueryRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		assert.Equal(t, "ws_CO_123", req.CheckoutRequestID)
+		assert.Equal(t, "174379", req.BusinessShortCode)
+
+		json.NewEncoder(w).Encode(queryBody)
+	}))
+}
+
+// TestSTKPushQuery_Success tests a query that returns a final result
+func TestSTKPushQuery_Success(t *testing.T) {
+	server := queryTestServer(t, STKPushQueryResponse{
+		ResponseCode:      "0",
+		CheckoutRequestID: "ws_CO_123",
+		ResultCode:        "1032",
+		ResultDesc:        "Request cancelled by user",
+	})
+	defer server.Close()
+
+	c := NewClient(Config{ConsumerKey: "k", ConsumerSecret: "s", BusinessShortCode: "174379", PassKey: "p", BaseURL: server.URL})
+
+	resp, err := c.STKPushQuery(context.Background(), "ws_CO_123")
+	assert.NoError(t, err)
+	assert.Equal(t, "1032", resp.ResultCode)
+}
+
+// TestSTKPushQuery_Pending tests the "still processing" error maps to ErrTransactionPending
+func TestSTKPushQuery_Pending(t *testing.T) {
+	server := queryTestServer(t, map[string]string{
+		"requestId":    "1234-5678",
+		"errorCode":    "500.001.1001",
+		"errorMessage": "The transaction is being processed",
+	})
+	defer server.Close()
+
+	c := NewClient(Config{ConsumerKey: "k", ConsumerSecret: "s", BusinessShortCode: "174379", PassKey: "p", BaseURL: server.URL})
+
+	_, err := c.STKPushQuery(context.Background(), "ws_CO_123")
+	assert.ErrorIs(t, err, ErrTransactionPending)
+}
+
+// TestSTKPushQuery_Error tests other Daraja errors are surfaced
+func TestSTKPushQuery_Error(t *testing.T) {
+	server := queryTestServer(t, map[string]string{
+		"requestId":    "1234-5678",
+		"errorCode":    "400.002.02",
+		"errorMessage": "Bad Request - Invalid CheckoutRequestID",
+	})
+	defer server.Close()
+
+	c := NewClient(Config{ConsumerKey: "k", ConsumerSecret: "s", BusinessShortCode: "174379", PassKey: "p", BaseURL: server.URL})
+
+	_, err := c.STKPushQuery(context.Background(), "ws_CO_123")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrTransactionPending)
+	assert.Contains(t, err.Error(), "Invalid CheckoutRequestID")
+}
+
+// TestInitiateSTKPush_CallbackToken ensures the per-payment token is sent in the callback URL
+func TestInitiateSTKPush_CallbackToken(t *testing.T) {
+	var got STKPushRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(STKPushResponse{CheckoutRequestID: "ws_CO_1", ResponseCode: "0"})
+	}))
+	defer server.Close()
+
+	c := NewClient(Config{
+		ConsumerKey:       "test_key",
+		ConsumerSecret:    "test_secret",
+		BusinessShortCode: "174379",
+		PassKey:           "passkey",
+		CallbackURL:       "https://example.com/api/v1/mpesa/callback/",
+		BaseURL:           server.URL,
+	})
+
+	_, err := c.InitiateSTKPush(context.Background(), "254712345678", "100", "invoice-123", "abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/api/v1/mpesa/callback/abc123", got.CallBackURL)
+	assert.Equal(t, "https://example.com/api/v1/mpesa/callback/", c.CallbackURLFor(""))
+}
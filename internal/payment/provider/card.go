@@ -0,0 +1,271 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CardConfig holds the card gateway credentials
+type CardConfig struct {
+	BaseURL       string // gateway API root, e.g. https://api.gateway.example
+	SecretKey     string // API key sent as a bearer token
+	WebhookSecret string // key the gateway signs webhooks with
+	ReturnURL     string // where the hosted checkout page sends the customer afterwards
+	Currency      string // defaults to KES
+}
+
+// CardProvider takes card payments through a hosted checkout page. The customer is
+// redirected to the gateway to enter their card; the gateway then reports the
+// result with a signed webhook, and charges can also be queried directly.
+type CardProvider struct {
+	config     CardConfig
+	httpClient *http.Client
+}
+
+// NewCardProvider creates a card gateway provider
+func NewCardProvider(config CardConfig) *CardProvider {
+	if config.Currency == "" {
+		config.Currency = "KES"
+	}
+	return &CardProvider{
+		config:     config,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name returns "card"
+func (p *CardProvider) Name() string {
+	return Card
+}
+
+// CardSignatureHeader carries the hex HMAC-SHA256 of a webhook body
+const CardSignatureHeader = "X-Signature"
+
+// Card gateway charge and refund statuses
+const (
+	CardStatusPending   = "pending"
+	CardStatusSucceeded = "succeeded"
+	CardStatusFailed    = "failed"
+)
+
+// CardCharge is a charge as the gateway reports it. Amounts are in minor units (cents).
+type CardCharge struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	Reference      string `json:"reference"`
+	CheckoutURL    string `json:"checkout_url,omitempty"`
+	ReceiptNumber  string `json:"receipt_number,omitempty"`
+	FailureMessage string `json:"failure_message,omitempty"`
+	PaidAt         string `json:"paid_at,omitempty"` // RFC 3339
+	PaymentMethod  struct {
+		Type  string `json:"type"`
+		Brand string `json:"brand"`
+		Last4 string `json:"last4"`
+	} `json:"payment_method"`
+}
+
+// CardRefund is a refund as the gateway reports it
+type CardRefund struct {
+	ID             string `json:"id"`
+	Charge         string `json:"charge"`
+	Amount         int64  `json:"amount"`
+	Status         string `json:"status"`
+	FailureMessage string `json:"failure_message,omitempty"`
+}
+
+// CardWebhook is the event the gateway posts when a charge changes state
+type CardWebhook struct {
+	Type string     `json:"type"` // e.g. "charge.succeeded", "charge.failed"
+	Data CardCharge `json:"data"`
+}
+
+// Initiate creates a charge and returns the hosted checkout page for it
+func (p *CardProvider) Initiate(ctx context.Context, req InitiateRequest) (*Initiation, error) {
+	payload := map[string]interface{}{
+		"amount":      toMinorUnits(req.Amount),
+		"currency":    p.config.Currency,
+		"reference":   req.InvoiceID,
+		"description": "Order Payment",
+		"return_url":  p.config.ReturnURL,
+	}
+
+	var charge CardCharge
+	if err := p.do(ctx, http.MethodPost, "/v1/charges", payload, &charge); err != nil {
+		return nil, fmt.Errorf("failed to create card charge: %w", err)
+	}
+
+	return &Initiation{
+		Reference:   charge.ID,
+		Method:      MethodCard,
+		Message:     "Complete your card payment on the checkout page",
+		RedirectURL: charge.CheckoutURL,
+	}, nil
+}
+
+// Query fetches a charge and returns its result, or ErrPending while the customer
+// has not finished checkout
+func (p *CardProvider) Query(ctx context.Context, reference string) (*Result, error) {
+	var charge CardCharge
+	if err := p.do(ctx, http.MethodGet, "/v1/charges/"+url.PathEscape(reference), nil, &charge); err != nil {
+		return nil, fmt.Errorf("failed to query card charge: %w", err)
+	}
+	return chargeResult(&charge)
+}
+
+// Refund refunds part or all of a charge. Gateways usually settle card refunds
+// immediately; a refund still pending is reported as neither completed nor failed.
+func (p *CardProvider) Refund(ctx context.Context, req RefundRequest) (*RefundInitiation, error) {
+	if req.PaymentReference == "" {
+		return nil, fmt.Errorf("card refund needs the charge to refund")
+	}
+
+	payload := map[string]interface{}{
+		"charge": req.PaymentReference,
+		"amount": toMinorUnits(req.Amount),
+		"reason": req.Reason,
+	}
+
+	var refund CardRefund
+	if err := p.do(ctx, http.MethodPost, "/v1/refunds", payload, &refund); err != nil {
+		return nil, fmt.Errorf("failed to create card refund: %w", err)
+	}
+
+	return &RefundInitiation{
+		Reference:   refund.ID,
+		Completed:   refund.Status == CardStatusSucceeded,
+		Failed:      refund.Status == CardStatusFailed,
+		Receipt:     refund.ID,
+		Description: refund.FailureMessage,
+	}, nil
+}
+
+// ParseCallback verifies the webhook signature and reads the charge it reports.
+// Events about charges still pending return ErrPending.
+func (p *CardProvider) ParseCallback(body []byte, header http.Header) (*Result, error) {
+	if p.config.WebhookSecret == "" {
+		return nil, fmt.Errorf("%w: webhook secret not configured", ErrInvalidCallback)
+	}
+	signature, err := hex.DecodeString(header.Get(CardSignatureHeader))
+	if err != nil || !hmac.Equal(signature, SignCardWebhook(p.config.WebhookSecret, body)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidCallback)
+	}
+
+	var event CardWebhook
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+	if event.Data.ID == "" {
+		return nil, fmt.Errorf("%w: missing charge ID", ErrInvalidCallback)
+	}
+
+	return chargeResult(&event.Data)
+}
+
+// SignCardWebhook computes the signature the gateway sends for a webhook body
+func SignCardWebhook(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// chargeResult converts a charge in a final state into a payment result
+func chargeResult(charge *CardCharge) (*Result, error) {
+	switch charge.Status {
+	case CardStatusSucceeded:
+	case CardStatusFailed:
+		return &Result{Reference: charge.ID, Paid: false, Description: charge.FailureMessage, Method: MethodCard}, nil
+	default:
+		return nil, ErrPending
+	}
+
+	result := &Result{
+		Reference:  charge.ID,
+		Paid:       true,
+		Receipt:    charge.ReceiptNumber,
+		PaidAmount: fromMinorUnits(charge.Amount),
+		Method:     MethodCard,
+		RawDate:    charge.PaidAt,
+	}
+	if result.Receipt == "" {
+		result.Receipt = charge.ID
+	}
+	if charge.PaymentMethod.Last4 != "" {
+		result.Payer = fmt.Sprintf("%s ****%s", charge.PaymentMethod.Brand, charge.PaymentMethod.Last4)
+	}
+	if t, err := time.Parse(time.RFC3339, charge.PaidAt); err == nil {
+		result.TransactedAt = &t
+	}
+	return result, nil
+}
+
+// do sends an authenticated JSON request to the gateway and decodes the response
+// into out. Error responses carry {"error": {"message": ...}}.
+func (p *CardProvider) do(ctx context.Context, method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		body = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.config.BaseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.config.SecretKey)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(respBody, &errResp); err == nil && errResp.Error.Message != "" {
+			return fmt.Errorf("gateway returned %d: %s", resp.StatusCode, errResp.Error.Message)
+		}
+		return fmt.Errorf("gateway returned %d", resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// toMinorUnits converts shillings to cents, rounding away float error
+func toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// fromMinorUnits converts cents to shillings
+func fromMinorUnits(amount int64) float64 {
+	return float64(amount) / 100
+}
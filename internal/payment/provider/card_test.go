@@ -0,0 +1,223 @@
+package provider
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCardGateway is a minimal in-memory card gateway: charges are created pending
+// and completed by the test, as the hosted checkout page would
+type fakeCardGateway struct {
+	mu      sync.Mutex
+	charges map[string]*CardCharge
+	refunds []CardRefund
+	nextID  int
+}
+
+func newFakeCardGateway(t *testing.T) (*fakeCardGateway, *httptest.Server) {
+	gw := &fakeCardGateway{charges: map[string]*CardCharge{}}
+	server := httptest.NewServer(http.HandlerFunc(gw.serve))
+	t.Cleanup(server.Close)
+	return gw, server
+}
+
+func (gw *fakeCardGateway) serve(w http.ResponseWriter, r *http.Request) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer sk_test" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "invalid API key"}})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/charges":
+		var req struct {
+			Amount    int64  `json:"amount"`
+			Currency  string `json:"currency"`
+			Reference string `json:"reference"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Amount <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "amount must be positive"}})
+			return
+		}
+		gw.nextID++
+		id := "ch_" + string(rune('0'+gw.nextID))
+		charge := &CardCharge{ID: id, Status: CardStatusPending, Amount: req.Amount, Currency: req.Currency, Reference: req.Reference, CheckoutURL: "https://pay.example/" + id}
+		gw.charges[id] = charge
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(charge)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/charges/"):
+		charge, ok := gw.charges[strings.TrimPrefix(r.URL.Path, "/v1/charges/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "no such charge"}})
+			return
+		}
+		json.NewEncoder(w).Encode(charge)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/refunds":
+		var req struct {
+			Charge string `json:"charge"`
+			Amount int64  `json:"amount"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		refund := CardRefund{ID: "re_1", Charge: req.Charge, Amount: req.Amount, Status: CardStatusSucceeded}
+		if charge, ok := gw.charges[req.Charge]; !ok || charge.Status != CardStatusSucceeded || req.Amount > charge.Amount {
+			refund.Status = CardStatusFailed
+			refund.FailureMessage = "charge cannot be refunded"
+		}
+		gw.refunds = append(gw.refunds, refund)
+		json.NewEncoder(w).Encode(refund)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// complete finishes checkout for a charge
+func (gw *fakeCardGateway) complete(id, status string) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	charge := gw.charges[id]
+	charge.Status = status
+	if status == CardStatusSucceeded {
+		charge.ReceiptNumber = "RCPT-" + id
+		charge.PaidAt = "2026-03-01T10:15:00Z"
+		charge.PaymentMethod.Type = "card"
+		charge.PaymentMethod.Brand = "visa"
+		charge.PaymentMethod.Last4 = "4242"
+	} else {
+		charge.FailureMessage = "card declined"
+	}
+}
+
+func newTestCardProvider(server *httptest.Server) *CardProvider {
+	return NewCardProvider(CardConfig{
+		BaseURL:       server.URL,
+		SecretKey:     "sk_test",
+		WebhookSecret: "whsec_test",
+		ReturnURL:     "https://shop.example/payments/return",
+	})
+}
+
+func TestCardProvider_InitiateAndQuery(t *testing.T) {
+	gw, server := newFakeCardGateway(t)
+	p := newTestCardProvider(server)
+	ctx := context.Background()
+
+	init, err := p.Initiate(ctx, InitiateRequest{InvoiceID: "inv-1", Amount: 1250.50})
+	assert.NoError(t, err)
+	assert.Equal(t, "ch_1", init.Reference)
+	assert.Equal(t, MethodCard, init.Method)
+	assert.Equal(t, "https://pay.example/ch_1", init.RedirectURL)
+	assert.Equal(t, int64(125050), gw.charges["ch_1"].Amount)
+	assert.Equal(t, "KES", gw.charges["ch_1"].Currency)
+	assert.Equal(t, "inv-1", gw.charges["ch_1"].Reference)
+
+	// The customer has not finished checkout yet
+	_, err = p.Query(ctx, "ch_1")
+	assert.True(t, errors.Is(err, ErrPending))
+
+	gw.complete("ch_1", CardStatusSucceeded)
+	result, err := p.Query(ctx, "ch_1")
+	assert.NoError(t, err)
+	assert.True(t, result.Paid)
+	assert.Equal(t, "ch_1", result.Reference)
+	assert.Equal(t, "RCPT-ch_1", result.Receipt)
+	assert.Equal(t, 1250.50, result.PaidAmount)
+	assert.Equal(t, "visa ****4242", result.Payer)
+	assert.True(t, result.TransactedAt.Equal(time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)))
+}
+
+func TestCardProvider_QueryDeclined(t *testing.T) {
+	gw, server := newFakeCardGateway(t)
+	p := newTestCardProvider(server)
+
+	init, err := p.Initiate(context.Background(), InitiateRequest{InvoiceID: "inv-1", Amount: 100})
+	assert.NoError(t, err)
+	gw.complete(init.Reference, CardStatusFailed)
+
+	result, err := p.Query(context.Background(), init.Reference)
+	assert.NoError(t, err)
+	assert.False(t, result.Paid)
+	assert.Equal(t, "card declined", result.Description)
+}
+
+func TestCardProvider_GatewayErrors(t *testing.T) {
+	_, server := newFakeCardGateway(t)
+
+	p := newTestCardProvider(server)
+	_, err := p.Query(context.Background(), "ch_missing")
+	assert.ErrorContains(t, err, "no such charge")
+
+	badKey := NewCardProvider(CardConfig{BaseURL: server.URL, SecretKey: "wrong"})
+	_, err = badKey.Initiate(context.Background(), InitiateRequest{InvoiceID: "inv-1", Amount: 100})
+	assert.ErrorContains(t, err, "invalid API key")
+}
+
+func TestCardProvider_Refund(t *testing.T) {
+	gw, server := newFakeCardGateway(t)
+	p := newTestCardProvider(server)
+	ctx := context.Background()
+
+	init, _ := p.Initiate(ctx, InitiateRequest{InvoiceID: "inv-1", Amount: 500})
+	gw.complete(init.Reference, CardStatusSucceeded)
+
+	refund, err := p.Refund(ctx, RefundRequest{InvoiceID: "inv-1", Amount: 200.25, PaymentReference: init.Reference, Reason: "returned"})
+	assert.NoError(t, err)
+	assert.True(t, refund.Completed)
+	assert.False(t, refund.Failed)
+	assert.Equal(t, int64(20025), gw.refunds[0].Amount)
+
+	refund, err = p.Refund(ctx, RefundRequest{InvoiceID: "inv-1", Amount: 900, PaymentReference: init.Reference})
+	assert.NoError(t, err)
+	assert.True(t, refund.Failed)
+	assert.Equal(t, "charge cannot be refunded", refund.Description)
+
+	_, err = p.Refund(ctx, RefundRequest{InvoiceID: "inv-1", Amount: 100})
+	assert.Error(t, err)
+}
+
+func TestCardProvider_ParseCallback(t *testing.T) {
+	p := NewCardProvider(CardConfig{WebhookSecret: "whsec_test"})
+
+	charge := CardCharge{ID: "ch_9", Status: CardStatusSucceeded, Amount: 30000, ReceiptNumber: "RCPT-9"}
+	body, _ := json.Marshal(CardWebhook{Type: "charge.succeeded", Data: charge})
+	header := http.Header{}
+	header.Set(CardSignatureHeader, hex.EncodeToString(SignCardWebhook("whsec_test", body)))
+
+	result, err := p.ParseCallback(body, header)
+	assert.NoError(t, err)
+	assert.True(t, result.Paid)
+	assert.Equal(t, "ch_9", result.Reference)
+	assert.Equal(t, 300.0, result.PaidAmount)
+
+	// A tampered body no longer matches the signature
+	tampered := []byte(strings.Replace(string(body), "30000", "90000", 1))
+	_, err = p.ParseCallback(tampered, header)
+	assert.True(t, errors.Is(err, ErrInvalidCallback))
+
+	_, err = p.ParseCallback(body, http.Header{})
+	assert.True(t, errors.Is(err, ErrInvalidCallback))
+
+	pendingBody, _ := json.Marshal(CardWebhook{Type: "charge.pending", Data: CardCharge{ID: "ch_9", Status: CardStatusPending}})
+	header.Set(CardSignatureHeader, hex.EncodeToString(SignCardWebhook("whsec_test", pendingBody)))
+	_, err = p.ParseCallback(pendingBody, header)
+	assert.True(t, errors.Is(err, ErrPending))
+}
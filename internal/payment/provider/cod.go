@@ -0,0 +1,48 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// CashOnDeliveryProvider records payments the rider collects in cash. There is
+// nobody to ask about the result: a cash payment stays open until staff confirm the
+// money was collected, and cash refunds are handed over in person.
+type CashOnDeliveryProvider struct{}
+
+// NewCashOnDeliveryProvider creates the cash-on-delivery provider
+func NewCashOnDeliveryProvider() *CashOnDeliveryProvider {
+	return &CashOnDeliveryProvider{}
+}
+
+// Name returns "cod"
+func (p *CashOnDeliveryProvider) Name() string {
+	return CashOnDelivery
+}
+
+// Initiate issues a reference for the cash to be collected on delivery
+func (p *CashOnDeliveryProvider) Initiate(ctx context.Context, req InitiateRequest) (*Initiation, error) {
+	return &Initiation{
+		Reference: "cod_" + uuid.New().String(),
+		Method:    MethodCash,
+		Message:   "Pay in cash when your order is delivered",
+		Offline:   true,
+	}, nil
+}
+
+// Query is not supported; cash payments are settled by staff
+func (p *CashOnDeliveryProvider) Query(ctx context.Context, reference string) (*Result, error) {
+	return nil, ErrNotSupported
+}
+
+// Refund is not supported; pay cash refunds through another provider
+func (p *CashOnDeliveryProvider) Refund(ctx context.Context, req RefundRequest) (*RefundInitiation, error) {
+	return nil, ErrNotSupported
+}
+
+// ParseCallback is not supported; nothing calls back about cash
+func (p *CashOnDeliveryProvider) ParseCallback(body []byte, header http.Header) (*Result, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+)
+
+// mpesaTimeZone is the zone M-Pesa reports transaction times in (East Africa Time)
+var mpesaTimeZone = time.FixedZone("EAT", 3*60*60)
+
+// MpesaProvider takes payments with Daraja STK Push and refunds them with B2C payouts
+type MpesaProvider struct {
+	client *mpesa.Client
+}
+
+// NewMpesaProvider wraps a Daraja client as a PaymentProvider
+func NewMpesaProvider(client *mpesa.Client) *MpesaProvider {
+	return &MpesaProvider{client: client}
+}
+
+// Name returns "mpesa"
+func (p *MpesaProvider) Name() string {
+	return Mpesa
+}
+
+// Initiate sends an STK Push prompt to the customer's phone
+func (p *MpesaProvider) Initiate(ctx context.Context, req InitiateRequest) (*Initiation, error) {
+	resp, err := p.client.InitiateSTKPush(ctx, req.Phone, strconv.FormatFloat(req.Amount, 'f', 2, 64), req.InvoiceID, req.CallbackToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Initiation{
+		Reference:         resp.CheckoutRequestID,
+		MerchantReference: resp.MerchantRequestID,
+		Method:            MethodSTKPush,
+		Message:           resp.CustomerMessage,
+	}, nil
+}
+
+// Query asks Daraja for the result of an STK Push. The query response carries no
+// receipt or amount, so a paid result leaves them for the caller to assume.
+func (p *MpesaProvider) Query(ctx context.Context, reference string) (*Result, error) {
+	resp, err := p.client.STKPushQuery(ctx, reference)
+	if err != nil {
+		if errors.Is(err, mpesa.ErrTransactionPending) {
+			return nil, ErrPending
+		}
+		return nil, err
+	}
+
+	resultCode, err := strconv.Atoi(resp.ResultCode)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected STK query result code %q", resp.ResultCode)
+	}
+
+	return &Result{
+		Reference:   reference,
+		Paid:        resultCode == 0,
+		Description: resp.ResultDesc,
+		Method:      MethodSTKPush,
+	}, nil
+}
+
+// Refund queues a B2C payout of whole shillings to the customer's phone. The payout
+// is only final once Daraja posts the B2C result.
+func (p *MpesaProvider) Refund(ctx context.Context, req RefundRequest) (*RefundInitiation, error) {
+	resp, err := p.client.InitiateB2CPayment(ctx, req.Phone, fmt.Sprintf("%.0f", req.Amount), req.CommandID, "Refund for invoice "+req.InvoiceID, req.InvoiceID, req.CallbackToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefundInitiation{
+		Reference:           resp.ConversationID,
+		OriginatorReference: resp.OriginatorConversationID,
+		Description:         resp.ResponseDescription,
+	}, nil
+}
+
+// ParseCallback reads an STK Push callback
+func (p *MpesaProvider) ParseCallback(body []byte, header http.Header) (*Result, error) {
+	return ParseMpesaCallback(body)
+}
+
+// stkCallback is the payload Daraja posts to the STK Push CallBackURL
+type stkCallback struct {
+	Body struct {
+		StkCallback struct {
+			MerchantRequestID string `json:"MerchantRequestID"`
+			CheckoutRequestID string `json:"CheckoutRequestID"`
+			ResultCode        int    `json:"ResultCode"`
+			ResultDesc        string `json:"ResultDesc"`
+			CallbackMetadata  struct {
+				Item []struct {
+					Name  string      `json:"Name"`
+					Value interface{} `json:"Value"`
+				} `json:"Item"`
+			} `json:"CallbackMetadata"`
+		} `json:"stkCallback"`
+	} `json:"Body"`
+}
+
+// ParseMpesaCallback reads an STK Push callback. It needs no client, so callbacks
+// can still be settled while the Daraja credentials are being rotated. M-Pesa sends
+// Amount, PhoneNumber and TransactionDate as JSON numbers.
+func ParseMpesaCallback(body []byte) (*Result, error) {
+	var callback stkCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+
+	stk := callback.Body.StkCallback
+	if stk.CheckoutRequestID == "" {
+		return nil, fmt.Errorf("%w: missing CheckoutRequestID", ErrInvalidCallback)
+	}
+
+	metadata := make(map[string]interface{})
+	for _, item := range stk.CallbackMetadata.Item {
+		metadata[item.Name] = item.Value
+	}
+
+	result := &Result{
+		Reference:   stk.CheckoutRequestID,
+		Paid:        stk.ResultCode == 0,
+		Description: stk.ResultDesc,
+		Method:      MethodSTKPush,
+		Receipt:     MetadataString(metadata["MpesaReceiptNumber"]),
+		Payer:       MetadataString(metadata["PhoneNumber"]),
+		RawDate:     MetadataString(metadata["TransactionDate"]),
+	}
+	if amount, err := strconv.ParseFloat(MetadataString(metadata["Amount"]), 64); err == nil {
+		result.PaidAmount = amount
+	}
+	if t, err := time.ParseInLocation("20060102150405", result.RawDate, mpesaTimeZone); err == nil {
+		result.TransactedAt = &t
+	}
+
+	return result, nil
+}
+
+// MetadataString renders a Daraja callback metadata or result parameter value
+// without float exponents
+func MetadataString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mpesa "github.com/eddie-wainaina1/maggiesb/internal/payment"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMpesaCallback_Paid(t *testing.T) {
+	body := []byte(`{"Body":{"stkCallback":{"MerchantRequestID":"m-1","CheckoutRequestID":"ws_1","ResultCode":0,"ResultDesc":"ok",
+		"CallbackMetadata":{"Item":[{"Name":"Amount","Value":1500.5},{"Name":"MpesaReceiptNumber","Value":"QGH12345"},
+		{"Name":"TransactionDate","Value":20231201120000},{"Name":"PhoneNumber","Value":254708374149}]}}}}`)
+
+	result, err := ParseMpesaCallback(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "ws_1", result.Reference)
+	assert.True(t, result.Paid)
+	assert.Equal(t, "QGH12345", result.Receipt)
+	assert.Equal(t, 1500.5, result.PaidAmount)
+	assert.Equal(t, "254708374149", result.Payer)
+	assert.Equal(t, "20231201120000", result.RawDate)
+	assert.True(t, result.TransactedAt.Equal(time.Date(2023, 12, 1, 9, 0, 0, 0, time.UTC)))
+	assert.Equal(t, MethodSTKPush, result.Method)
+}
+
+func TestParseMpesaCallback_Cancelled(t *testing.T) {
+	body := []byte(`{"Body":{"stkCallback":{"CheckoutRequestID":"ws_2","ResultCode":1032,"ResultDesc":"Request cancelled by user"}}}`)
+
+	result, err := ParseMpesaCallback(body)
+	assert.NoError(t, err)
+	assert.False(t, result.Paid)
+	assert.Equal(t, "Request cancelled by user", result.Description)
+	assert.Zero(t, result.PaidAmount)
+	assert.Nil(t, result.TransactedAt)
+}
+
+func TestParseMpesaCallback_Invalid(t *testing.T) {
+	for _, body := range []string{`not json`, `{"invalid":"data"}`} {
+		_, err := ParseMpesaCallback([]byte(body))
+		assert.True(t, errors.Is(err, ErrInvalidCallback), body)
+	}
+}
+
+func TestMpesaProvider_Query(t *testing.T) {
+	results := map[string]interface{}{
+		"ws_paid":      mpesa.STKPushQueryResponse{ResponseCode: "0", ResultCode: "0", ResultDesc: "processed"},
+		"ws_cancelled": mpesa.STKPushQueryResponse{ResponseCode: "0", ResultCode: "1032", ResultDesc: "cancelled"},
+		"ws_pending":   map[string]string{"errorCode": "500.001.1001", "errorMessage": "The transaction is being processed"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test_token", "expires_in": 3600})
+			return
+		}
+		var req mpesa.STKPushQueryRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(results[req.CheckoutRequestID])
+	}))
+	defer server.Close()
+
+	p := NewMpesaProvider(mpesa.NewClient(mpesa.Config{ConsumerKey: "key", ConsumerSecret: "secret", BusinessShortCode: "174379", PassKey: "passkey", BaseURL: server.URL}))
+
+	result, err := p.Query(context.Background(), "ws_paid")
+	assert.NoError(t, err)
+	assert.True(t, result.Paid)
+
+	result, err = p.Query(context.Background(), "ws_cancelled")
+	assert.NoError(t, err)
+	assert.False(t, result.Paid)
+
+	_, err = p.Query(context.Background(), "ws_pending")
+	assert.True(t, errors.Is(err, ErrPending))
+}
+
+func TestCashOnDeliveryProvider(t *testing.T) {
+	p := NewCashOnDeliveryProvider()
+
+	init, err := p.Initiate(context.Background(), InitiateRequest{InvoiceID: "inv-1", Amount: 100})
+	assert.NoError(t, err)
+	assert.True(t, init.Offline)
+	assert.Equal(t, MethodCash, init.Method)
+	assert.Contains(t, init.Reference, "cod_")
+
+	_, err = p.Query(context.Background(), init.Reference)
+	assert.True(t, errors.Is(err, ErrNotSupported))
+	_, err = p.Refund(context.Background(), RefundRequest{InvoiceID: "inv-1", Amount: 100})
+	assert.True(t, errors.Is(err, ErrNotSupported))
+}
@@ -0,0 +1,102 @@
+// Package provider defines the interface the shop uses to take and refund payments,
+// with implementations for M-Pesa (Daraja), cash on delivery and a card gateway.
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Provider names, stored on invoices and payment records
+const (
+	Mpesa          = "mpesa"
+	CashOnDelivery = "cod"
+	Card           = "card"
+)
+
+// Payment methods recorded on settled payments
+const (
+	MethodSTKPush = "mpesa_stk"
+	MethodCash    = "cash"
+	MethodCard    = "card"
+)
+
+var (
+	// ErrPending is returned by Query while the provider has no final result yet
+	ErrPending = errors.New("payment result not yet available")
+	// ErrNotSupported is returned for operations a provider cannot perform, such as
+	// querying or refunding cash payments
+	ErrNotSupported = errors.New("operation not supported by payment provider")
+	// ErrInvalidCallback is returned when a callback cannot be parsed or verified
+	ErrInvalidCallback = errors.New("invalid payment callback")
+)
+
+// PaymentProvider takes payments for invoices and pays refunds back
+type PaymentProvider interface {
+	// Name returns the provider name stored on invoices and payment records
+	Name() string
+	// Initiate asks the customer to pay. The payment is settled later by a callback,
+	// a query or (for cash) an admin confirming collection.
+	Initiate(ctx context.Context, req InitiateRequest) (*Initiation, error)
+	// Query fetches the final result of a payment by its provider reference. It
+	// returns ErrPending while the result is not yet known.
+	Query(ctx context.Context, reference string) (*Result, error)
+	// Refund pays money back to the customer
+	Refund(ctx context.Context, req RefundRequest) (*RefundInitiation, error)
+	// ParseCallback reads the result of a payment from a provider callback
+	ParseCallback(body []byte, header http.Header) (*Result, error)
+}
+
+// InitiateRequest describes a payment to take
+type InitiateRequest struct {
+	InvoiceID     string
+	Phone         string
+	Amount        float64
+	CallbackToken string // secret embedded in the callback URL; ignored by providers that sign callbacks
+}
+
+// Initiation is the provider's acknowledgement of a payment request
+type Initiation struct {
+	Reference         string // provider's ID for the payment; callbacks and queries use it
+	MerchantReference string // secondary ID some providers return (M-Pesa MerchantRequestID)
+	Method            string
+	Message           string // what to tell the customer
+	RedirectURL       string // page the customer completes payment on, if any
+	Offline           bool   // settled by staff confirming collection, not by the provider
+}
+
+// Result is the final outcome of a payment
+type Result struct {
+	Reference    string // provider reference the payment was initiated with
+	Paid         bool
+	Description  string
+	Receipt      string     // provider receipt for the money collected
+	PaidAmount   float64    // zero when the provider does not report it
+	Payer        string     // phone number or masked card that paid
+	Method       string     // how the customer paid, when the provider reports it
+	TransactedAt *time.Time // when the money was taken, when the provider reports it
+	RawDate      string     // provider's own timestamp, kept as sent
+}
+
+// RefundRequest describes money to pay back to a customer
+type RefundRequest struct {
+	InvoiceID        string
+	Phone            string
+	Amount           float64
+	PaymentReference string // reference of the payment being refunded
+	Reason           string
+	CommandID        string // M-Pesa B2C command; ignored by other providers
+	CallbackToken    string // secret embedded in the result URLs; ignored by providers that sign callbacks
+}
+
+// RefundInitiation is the provider's acknowledgement of a refund
+type RefundInitiation struct {
+	Reference           string // provider's ID for the refund
+	OriginatorReference string // secondary ID some providers return
+	Completed           bool   // true when the refund was paid immediately
+	Failed              bool   // true when the provider refused the refund outright
+	Receipt             string
+	Description         string
+}
@@ -0,0 +1,251 @@
+package mpesa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults for the resilience settings in Config
+const (
+	defaultTimeout          = 30 * time.Second
+	defaultMaxRetries       = 2
+	defaultRetryBackoff     = 500 * time.Millisecond
+	maxRetryBackoff         = 10 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// apiRequest is one Daraja API call
+type apiRequest struct {
+	op         string      // error prefix, e.g. "STK push failed"
+	path       string      // e.g. "/mpesa/stkpush/v1/processrequest"
+	payload    interface{} // JSON request body
+	idempotent bool        // safe to send again when an attempt's fate is unknown
+}
+
+// call posts req to Daraja with a bearer token and returns the response body.
+// Idempotent calls are retried with backoff on timeouts and server errors. Calls
+// that move money are sent once: a timed-out STK push or payout may still have
+// gone through, and sending it again could charge or pay the customer twice.
+func (c *Client) call(ctx context.Context, req apiRequest) ([]byte, error) {
+	payloadBytes, err := json.Marshal(req.payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	attempts := 1
+	if req.idempotent {
+		attempts += c.maxRetries
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		token, err := c.GetAccessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		httpReq, err := http.NewRequest(http.MethodPost, c.baseURL+req.path, bytes.NewReader(payloadBytes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+
+		body, err := c.do(ctx, req.op, httpReq)
+		if err == nil {
+			return body, nil
+		}
+
+		// Daraja can revoke a token before its stated expiry. It refuses the request
+		// without acting on it, so one resend with a fresh token is safe for any call.
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Kind == ErrorAuth && !refreshed {
+			c.invalidateToken(token)
+			refreshed = true
+			attempt--
+			continue
+		}
+
+		if attempt+1 >= attempts || !errors.As(err, &apiErr) || !apiErr.Temporary() {
+			return nil, err
+		}
+		if err := sleep(ctx, c.backoff(attempt)); err != nil {
+			return nil, &Error{Kind: ErrorTimeout, Op: req.op, Err: err}
+		}
+	}
+}
+
+// do sends one request through the circuit breaker. Any non-2xx response, or a 2xx
+// carrying a Daraja errorCode, is returned as an *Error.
+func (c *Client) do(ctx context.Context, op string, req *http.Request) ([]byte, error) {
+	if !c.breaker.allow() {
+		return nil, &Error{Kind: ErrorUpstream, Op: op, Err: ErrCircuitOpen}
+	}
+
+	body, err := c.exchange(ctx, op, req)
+
+	// Requests abandoned by the caller say nothing about Daraja's health, but a
+	// trial call must still give up its slot or the circuit never closes again
+	if ctx.Err() == nil {
+		var apiErr *Error
+		c.breaker.record(!errors.As(err, &apiErr) || !apiErr.Temporary())
+	} else {
+		c.breaker.release()
+	}
+	return body, err
+}
+
+func (c *Client) exchange(ctx context.Context, op string, req *http.Request) ([]byte, error) {
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, transportError(op, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, transportError(op, err)
+	}
+
+	// Daraja reports errors in a different shape, sometimes with a 200
+	var errResp struct {
+		ErrorCode    string `json:"errorCode"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+	_ = json.Unmarshal(body, &errResp)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && errResp.ErrorCode == "" && errResp.ErrorMessage == "" {
+		return body, nil
+	}
+
+	apiErr := &Error{
+		Kind:       errorKind(resp.StatusCode, errResp.ErrorCode),
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		Code:       errResp.ErrorCode,
+		Message:    errResp.ErrorMessage,
+	}
+	if apiErr.Code == "" && apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return nil, apiErr
+}
+
+// transportError classifies a request that got no response
+func transportError(op string, err error) *Error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &Error{Kind: ErrorTimeout, Op: op, Err: err}
+	}
+	return &Error{Kind: ErrorUpstream, Op: op, Err: err}
+}
+
+// decode reads a successful Daraja response into v
+func decode(op string, body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return &Error{Kind: ErrorUpstream, Op: op, StatusCode: http.StatusOK, Message: "unreadable response", Err: err}
+	}
+	return nil
+}
+
+// backoff is the wait before retry number attempt+1: the base delay doubled per
+// attempt, capped, with jitter so concurrent callers do not retry in step
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retryBackoff << attempt
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// circuitBreaker stops calls to Daraja after threshold consecutive failures, so a
+// Daraja outage fails requests fast instead of tying up handlers for the full
+// timeout. After cooldown a single trial call is let through; its success closes
+// the circuit and its failure keeps it open for another cooldown.
+type circuitBreaker struct {
+	threshold int // zero disables the breaker
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) record(ok bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends a call without counting its outcome. A trial call abandoned this
+// way lets the next call try again.
+func (b *circuitBreaker) release() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// open reports whether calls are currently being refused
+func (b *circuitBreaker) open() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold && (b.probing || time.Since(b.openedAt) < b.cooldown)
+}
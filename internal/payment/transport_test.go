@@ -0,0 +1,366 @@
+package mpesa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer stands in for Daraja, issuing tokens and answering API calls with
+// handle; it counts token requests and API calls
+type flakyServer struct {
+	*httptest.Server
+	tokens atomic.Int32
+	calls  atomic.Int32
+}
+
+func newFlakyServer(t *testing.T, handle func(call int, w http.ResponseWriter, r *http.Request)) *flakyServer {
+	s := &flakyServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			n := s.tokens.Add(1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token_" + string(rune('0'+n)), "expires_in": "3599"})
+			return
+		}
+		handle(int(s.calls.Add(1)), w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func testClient(baseURL string) *Client {
+	return NewClient(Config{
+		ConsumerKey:       "key",
+		ConsumerSecret:    "secret",
+		BusinessShortCode: "174379",
+		PassKey:           "passkey",
+		CallbackURL:       "https://example.com/callback",
+		BaseURL:           baseURL,
+		RetryBackoff:      time.Millisecond,
+	})
+}
+
+func queryOK(w http.ResponseWriter) {
+	json.NewEncoder(w).Encode(STKPushQueryResponse{ResponseCode: "0", ResultCode: "0", ResultDesc: "The service request is processed successfully."})
+}
+
+// TestGetAccessToken_SingleFlight ensures concurrent callers share one token request
+func TestGetAccessToken_SingleFlight(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "shared", "expires_in": "3599"})
+	}))
+	defer server.Close()
+
+	c := testClient(server.URL)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 20)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = c.GetAccessToken(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), fetches.Load())
+	for _, token := range tokens {
+		assert.Equal(t, "shared", token)
+	}
+}
+
+// TestGetAccessToken_CallerGivesUp ensures a cancelled caller returns without
+// abandoning the fetch for everyone else
+func TestGetAccessToken_CallerGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "late", "expires_in": "3599"})
+	}))
+	defer server.Close()
+
+	c := testClient(server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := c.GetAccessToken(ctx)
+	var apiErr *Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, ErrorTimeout, apiErr.Kind)
+
+	token, err := c.GetAccessToken(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "late", token)
+}
+
+// TestGetAccessToken_BadCredentials tests OAuth refusals are auth errors and not retried
+func TestGetAccessToken_BadCredentials(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorCode":"400.008.01","errorMessage":"Invalid Authentication passed"}`))
+	}))
+	defer server.Close()
+
+	_, err := testClient(server.URL).GetAccessToken(context.Background())
+	var apiErr *Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, ErrorAuth, apiErr.Kind)
+	assert.Equal(t, http.StatusBadGateway, HTTPStatus(err, http.StatusInternalServerError))
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+// TestSTKPushQuery_RetriesServerErrors tests idempotent calls are retried until they succeed
+func TestSTKPushQuery_RetriesServerErrors(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		if call < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		queryOK(w)
+	})
+
+	resp, err := testClient(server.URL).STKPushQuery(context.Background(), "ws_CO_123")
+	assert.NoError(t, err)
+	assert.Equal(t, "0", resp.ResultCode)
+	assert.Equal(t, int32(3), server.calls.Load())
+}
+
+// TestSTKPushQuery_RetriesExhausted tests the last failure is returned after MaxRetries
+func TestSTKPushQuery_RetriesExhausted(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"errorCode":"500.003.02","errorMessage":"System is busy"}`))
+	})
+
+	_, err := testClient(server.URL).STKPushQuery(context.Background(), "ws_CO_123")
+	var apiErr *Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, ErrorUpstream, apiErr.Kind)
+	assert.Equal(t, "500.003.02", apiErr.Code)
+	assert.Equal(t, int32(1+defaultMaxRetries), server.calls.Load())
+}
+
+// TestSTKPushQuery_PendingNotRetried tests a transaction still in progress is reported at once
+func TestSTKPushQuery_PendingNotRetried(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"errorCode":"500.001.1001","errorMessage":"The transaction is being processed"}`))
+	})
+
+	_, err := testClient(server.URL).STKPushQuery(context.Background(), "ws_CO_123")
+	assert.ErrorIs(t, err, ErrTransactionPending)
+	assert.Equal(t, int32(1), server.calls.Load())
+}
+
+// TestInitiateSTKPush_NotRetried tests calls that move money are sent only once
+func TestInitiateSTKPush_NotRetried(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := testClient(server.URL).InitiateSTKPush(context.Background(), "254712345678", "100", "invoice-123", "")
+	var apiErr *Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, ErrorUpstream, apiErr.Kind)
+	assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+	assert.Equal(t, int32(1), server.calls.Load())
+}
+
+// TestInitiateSTKPush_ValidationError tests Daraja refusing the request maps to 400
+func TestInitiateSTKPush_ValidationError(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorCode":"400.002.02","errorMessage":"Bad Request - Invalid PhoneNumber"}`))
+	})
+
+	_, err := testClient(server.URL).InitiateSTKPush(context.Background(), "0712", "100", "invoice-123", "")
+	var apiErr *Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, ErrorValidation, apiErr.Kind)
+	assert.Equal(t, http.StatusBadRequest, HTTPStatus(err, http.StatusInternalServerError))
+	assert.Contains(t, err.Error(), "STK push failed: 400.002.02 - Bad Request - Invalid PhoneNumber")
+}
+
+// TestInitiateSTKPush_Timeout tests a slow Daraja surfaces as a timeout
+func TestInitiateSTKPush_Timeout(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+
+	c := testClient(server.URL)
+	c.httpClient.Timeout = 50 * time.Millisecond
+
+	_, err := c.InitiateSTKPush(context.Background(), "254712345678", "100", "invoice-123", "")
+	var apiErr *Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, ErrorTimeout, apiErr.Kind)
+	assert.Equal(t, http.StatusGatewayTimeout, HTTPStatus(err, http.StatusInternalServerError))
+}
+
+// TestCall_RefreshesRevokedToken tests a token Daraja no longer accepts is replaced once
+func TestCall_RefreshesRevokedToken(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token_1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errorCode":"404.001.03","errorMessage":"Invalid Access Token"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(STKPushResponse{CheckoutRequestID: "ws_CO_1", ResponseCode: "0"})
+	})
+
+	resp, err := testClient(server.URL).InitiateSTKPush(context.Background(), "254712345678", "100", "invoice-123", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "ws_CO_1", resp.CheckoutRequestID)
+	assert.Equal(t, int32(2), server.tokens.Load())
+	assert.Equal(t, int32(2), server.calls.Load())
+}
+
+// TestCall_ContextCancelled tests a cancelled caller stops retrying
+func TestCall_ContextCancelled(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	c := testClient(server.URL)
+	c.retryBackoff = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.STKPushQuery(ctx, "ws_CO_123")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), server.calls.Load())
+}
+
+// TestCircuitBreaker_OpensAndRecovers tests repeated failures pause calls until a
+// trial call succeeds after the cooldown
+func TestCircuitBreaker_OpensAndRecovers(t *testing.T) {
+	var healthy atomic.Bool
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		queryOK(w)
+	})
+
+	c := NewClient(Config{
+		ConsumerKey:      "key",
+		ConsumerSecret:   "secret",
+		BaseURL:          server.URL,
+		MaxRetries:       -1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := c.STKPushQuery(ctx, "ws_CO_123")
+		assert.Error(t, err)
+	}
+	assert.True(t, c.CircuitOpen())
+
+	// Refused without reaching Daraja
+	_, err := c.STKPushQuery(ctx, "ws_CO_123")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(err, http.StatusInternalServerError))
+	assert.Equal(t, int32(2), server.calls.Load())
+
+	// A failed trial keeps the circuit open
+	time.Sleep(60 * time.Millisecond)
+	_, err = c.STKPushQuery(ctx, "ws_CO_123")
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	_, err = c.STKPushQuery(ctx, "ws_CO_123")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// A successful trial closes it
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	_, err = c.STKPushQuery(ctx, "ws_CO_123")
+	assert.NoError(t, err)
+	assert.False(t, c.CircuitOpen())
+	_, err = c.STKPushQuery(ctx, "ws_CO_123")
+	assert.NoError(t, err)
+}
+
+// TestCircuitBreaker_CancelledTrialReleasesSlot tests a trial call abandoned by
+// its caller does not leave the circuit refusing every later call
+func TestCircuitBreaker_CancelledTrialReleasesSlot(t *testing.T) {
+	trialCtx, giveUp := context.WithCancel(context.Background())
+	defer giveUp()
+	answered := make(chan struct{})
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		switch call {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// The trial's caller gives up while Daraja is still answering
+			giveUp()
+			<-answered
+		default:
+			queryOK(w)
+		}
+	})
+
+	c := NewClient(Config{
+		ConsumerKey:      "key",
+		ConsumerSecret:   "secret",
+		BaseURL:          server.URL,
+		MaxRetries:       -1,
+		BreakerThreshold: 1,
+		BreakerCooldown:  20 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	_, err := c.STKPushQuery(ctx, "ws_CO_123")
+	assert.Error(t, err)
+	assert.True(t, c.CircuitOpen())
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = c.STKPushQuery(trialCtx, "ws_CO_123")
+	close(answered)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The next call is let through as a new trial and closes the circuit
+	_, err = c.STKPushQuery(ctx, "ws_CO_123")
+	assert.NoError(t, err)
+	assert.False(t, c.CircuitOpen())
+	assert.Equal(t, int32(3), server.calls.Load())
+}
+
+// TestCircuitBreaker_IgnoresValidationErrors tests requests Daraja refuses do not
+// count as Daraja failing
+func TestCircuitBreaker_IgnoresValidationErrors(t *testing.T) {
+	server := newFlakyServer(t, func(call int, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorCode":"400.002.02","errorMessage":"Bad Request - Invalid CheckoutRequestID"}`))
+	})
+
+	c := NewClient(Config{ConsumerKey: "key", ConsumerSecret: "secret", BaseURL: server.URL, BreakerThreshold: 1})
+	for i := 0; i < 3; i++ {
+		_, err := c.STKPushQuery(context.Background(), "bad")
+		assert.False(t, errors.Is(err, ErrCircuitOpen))
+	}
+	assert.False(t, c.CircuitOpen())
+}
+
+// TestHTTPStatus_OtherErrors tests errors that did not come from Daraja keep the fallback
+func TestHTTPStatus_OtherErrors(t *testing.T) {
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(errors.New("not configured"), http.StatusInternalServerError))
+	assert.Equal(t, http.StatusBadGateway, HTTPStatus(&Error{Kind: ErrorUpstream, Op: "x"}, http.StatusInternalServerError))
+}
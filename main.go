@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/eddie-wainaina1/maggiesb/internal/auth"
 	"github.com/eddie-wainaina1/maggiesb/internal/database"
 	"github.com/eddie-wainaina1/maggiesb/internal/handlers"
 	"github.com/eddie-wainaina1/maggiesb/internal/middleware"
+	"github.com/eddie-wainaina1/maggiesb/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -38,21 +41,95 @@ func main() {
 		log.Fatalf("Failed to create indexes: %v", err)
 	}
 
+	// Products from before stock was tracked cannot be ordered until restocked
+	if n, err := database.NewProductRepository().CountProductsWithoutStock(context.Background()); err != nil {
+		log.Printf("Warning: failed to check product stock: %v", err)
+	} else if n > 0 {
+		log.Printf("Warning: %d products have no stock recorded and cannot be ordered until restocked through POST /api/v1/admin/products/:id/stock", n)
+	}
+
+	// Token signing keys; refuses the development secret when APP_ENV=production
+	if err := auth.InitSigningKeys(); err != nil {
+		log.Fatalf("Failed to configure JWT signing keys: %v", err)
+	}
+
 	// Initialize DI repositories
 	handlers.InitDependencies()
 
 	auth.StartTokenCleanupRoutine(1 * time.Hour)
 
+	// Built-in roles (admin, support, warehouse, finance) the first time the app runs
+	if err := handlers.InitRoles(); err != nil {
+		log.Fatalf("Failed to create roles: %v", err)
+	}
+
+	// Access and refresh token lifetimes
+	if err := handlers.InitSessions(); err != nil {
+		log.Fatalf("Failed to configure sessions: %v", err)
+	}
+
+	// Failed login limits, delays and lockouts
+	if err := handlers.InitLoginProtection(); err != nil {
+		log.Fatalf("Failed to configure login protection: %v", err)
+	}
+
+	// Authenticator app (TOTP) sign-in, optionally required for admins
+	if err := handlers.InitTwoFactor(); err != nil {
+		log.Fatalf("Failed to configure two-factor authentication: %v", err)
+	}
+
 	// Initialize M-Pesa client (optional, only if credentials are provided)
 	if err := handlers.InitMpesaClient(); err != nil {
 		log.Printf("Warning: M-Pesa client not initialized: %v", err)
 	}
 
+	// Cash on delivery and the card gateway (optional)
+	providers, err := handlers.InitPaymentProviders()
+	if err != nil {
+		log.Fatalf("Failed to initialize payment providers: %v", err)
+	}
+	if len(providers) == 0 {
+		log.Printf("Warning: no payment provider configured")
+	} else {
+		log.Printf("Payment providers: %v", providers)
+		// Settle payments whose callback never arrived; expire those the provider never decides
+		handlers.StartPaymentReconciler(5*time.Minute, 2*time.Minute, 30*time.Minute)
+	}
+
+	// Authentication of Daraja callbacks: tokens, sender allowlist and replay window
+	if err := handlers.InitCallbackSecurity(); err != nil {
+		log.Fatalf("Failed to configure M-Pesa callback security: %v", err)
+	}
+
+	// Password reset and email verification mail
+	mailDriver, err := handlers.InitAccountEmail()
+	if err != nil {
+		log.Fatalf("Failed to configure account email: %v", err)
+	}
+	log.Printf("Account email driver: %s", mailDriver)
+
 	router := gin.Default()
+
+	// Callback allowlisting and per-IP login limits rely on the client IP; only
+	// trust forwarding headers from the configured proxies
+	var trustedProxies []string
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		for _, proxy := range strings.Split(proxies, ",") {
+			trustedProxies = append(trustedProxies, strings.TrimSpace(proxy))
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	public := router.Group("/api/v1/auth")
 	{
 		public.POST("/register", handlers.Register)
 		public.POST("/login", handlers.Login)
+		public.POST("/refresh", handlers.RefreshSession)
+		public.POST("/2fa/verify", handlers.VerifyTwoFactorLogin)
+		public.POST("/password/forgot", handlers.ForgotPassword)
+		public.POST("/password/reset", handlers.ResetPassword)
+		public.POST("/email/verify", handlers.VerifyEmail)
 	}
 	// Public product routes
 	products := router.Group("/api/v1/products")
@@ -62,63 +139,225 @@ func main() {
 		products.GET("/:id", handlers.GetProduct)
 	}
 
+	// Public delivery zone routes
+	router.GET("/api/v1/delivery-zones", handlers.ListDeliveryZones)
+
 	// Protected routes
 	protected := router.Group("/api/v1")
 	protected.Use(middleware.AuthMiddleware())
 	{
 		protected.GET("/profile", handlers.GetProfile)
+		protected.PUT("/profile", handlers.UpdateProfile)
+		protected.DELETE("/profile", handlers.DeleteAccount)
+		protected.POST("/profile/password", handlers.ChangePassword)
 		protected.POST("/logout", handlers.Logout)
 
+		// Sessions (user)
+		protected.GET("/auth/sessions", handlers.ListSessions)
+		protected.DELETE("/auth/sessions", handlers.RevokeAllSessions)
+		protected.DELETE("/auth/sessions/:id", handlers.RevokeSession)
+		protected.POST("/auth/email/verification", handlers.ResendEmailVerification)
+		protected.POST("/auth/2fa/setup", handlers.SetupTwoFactor)
+		protected.POST("/auth/2fa/confirm", handlers.ConfirmTwoFactor)
+		protected.POST("/auth/2fa/disable", handlers.DisableTwoFactor)
+		protected.POST("/auth/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+
+		// Address book (user)
+		protected.GET("/addresses", handlers.ListAddresses)
+		protected.POST("/addresses", handlers.CreateAddress)
+		protected.PUT("/addresses/:id", handlers.UpdateAddress)
+		protected.DELETE("/addresses/:id", handlers.DeleteAddress)
+		protected.POST("/addresses/:id/default", handlers.SetDefaultAddress)
+		protected.GET("/addresses/:id/delivery", handlers.GetDeliveryQuote)
+
+		// Cart (user)
+		protected.GET("/cart", handlers.GetCart)
+		protected.POST("/cart/items", handlers.AddCartItem)
+		protected.PUT("/cart/items/:productId", handlers.UpdateCartItem)
+		protected.DELETE("/cart/items/:productId", handlers.RemoveCartItem)
+		protected.POST("/cart/checkout", handlers.RequireVerifiedEmail(), handlers.CheckoutCart)
+
 		// Orders (user)
-		protected.POST("/orders", handlers.CreateOrder)
+		protected.POST("/orders", handlers.RequireVerifiedEmail(), handlers.CreateOrder)
 		protected.GET("/orders", handlers.ListOrders)
 		protected.GET("/orders/:id", handlers.GetOrder)
+		protected.GET("/orders/:id/shipments", handlers.TrackOrder)
 
 		// Invoices (user)
 		protected.GET("/invoices/:id", handlers.GetInvoice)
+		protected.GET("/invoices/:id/payments", handlers.ListInvoicePayments)
 		protected.GET("/orders/:id/invoice", handlers.GetInvoiceByOrder)
 
 		// Payments (user)
-		protected.POST("/orders/:id/pay", handlers.InitiateMpesaPayment)
+		protected.POST("/orders/:id/pay", handlers.InitiatePayment)
 		protected.GET("/payments/:id/status", handlers.GetPaymentStatus)
 	}
 
-	// Admin product routes (protected + admin role)
+	// Admin routes need a role granting the permission named on each route, and a
+	// two-factor sign-in from staff when REQUIRE_ADMIN_TWO_FACTOR is set
+	requireStaff := []gin.HandlerFunc{middleware.AuthMiddleware(), handlers.RequireTwoFactor()}
+	can := handlers.RequirePermission
+
+	// Admin product routes (protected + products and inventory permissions)
 	adminProducts := router.Group("/api/v1/admin/products")
-	adminProducts.Use(middleware.AuthMiddleware(), middleware.RequireRole("admin"))
+	adminProducts.Use(requireStaff...)
 	{
-		adminProducts.POST("", handlers.CreateProduct)
-		adminProducts.PUT("/:id", handlers.UpdateProduct)
-		adminProducts.DELETE("/:id", handlers.DeleteProduct)
+		adminProducts.POST("", can(models.PermissionProductsWrite), handlers.CreateProduct)
+		adminProducts.PUT("/:id", can(models.PermissionProductsWrite), handlers.UpdateProduct)
+		adminProducts.DELETE("/:id", can(models.PermissionProductsWrite), handlers.DeleteProduct)
+		adminProducts.POST("/:id/stock", can(models.PermissionInventoryWrite), handlers.AdminAdjustStock)
+		adminProducts.GET("/:id/stock/adjustments", can(models.PermissionInventoryRead), handlers.AdminListStockAdjustments)
 	}
 
-	// Admin order routes (protected + admin role)
+	// Admin order routes (protected + orders permissions)
 	adminOrders := router.Group("/api/v1/admin/orders")
-	adminOrders.Use(middleware.AuthMiddleware(), middleware.RequireRole("admin"))
+	adminOrders.Use(requireStaff...)
 	{
-		adminOrders.GET("", handlers.AdminListOrders)
-		adminOrders.PUT("/:id/status", handlers.AdminUpdateOrderStatus)
+		adminOrders.GET("", can(models.PermissionOrdersRead), handlers.AdminListOrders)
+		adminOrders.PUT("/:id/status", can(models.PermissionOrdersWrite), handlers.AdminUpdateOrderStatus)
+		adminOrders.GET("/:id/shipments", can(models.PermissionOrdersRead), handlers.AdminListOrderShipments)
+		adminOrders.POST("/:id/shipments", can(models.PermissionOrdersWrite), handlers.AdminCreateShipment)
 	}
 
-	// Admin invoice routes (protected + admin role)
+	// Admin shipment routes (protected + orders permissions)
+	adminShipments := router.Group("/api/v1/admin/shipments")
+	adminShipments.Use(requireStaff...)
+	{
+		adminShipments.PUT("/:id", can(models.PermissionOrdersWrite), handlers.AdminUpdateShipment)
+		adminShipments.POST("/:id/dispatch", can(models.PermissionOrdersWrite), handlers.AdminDispatchShipment)
+		adminShipments.POST("/:id/deliver", can(models.PermissionOrdersWrite), handlers.AdminDeliverShipment)
+		adminShipments.POST("/:id/fail", can(models.PermissionOrdersWrite), handlers.AdminFailShipment)
+		adminShipments.GET("/:id/signature", can(models.PermissionOrdersRead), handlers.AdminGetShipmentSignature)
+	}
+
+	// Admin invoice routes (protected + invoices permissions)
 	adminInvoices := router.Group("/api/v1/admin/invoices")
-	adminInvoices.Use(middleware.AuthMiddleware(), middleware.RequireRole("admin"))
+	adminInvoices.Use(requireStaff...)
 	{
-		adminInvoices.GET("", handlers.AdminListInvoices)
-		adminInvoices.PUT("/:id/payment", handlers.AdminRecordPayment)
-		adminInvoices.PUT("/:id/reverse", handlers.AdminReverseInvoice)
+		adminInvoices.GET("", can(models.PermissionInvoicesRead), handlers.AdminListInvoices)
+		adminInvoices.PUT("/:id/payment", can(models.PermissionInvoicesWrite), handlers.AdminRecordPayment)
+		adminInvoices.PUT("/:id/reverse", can(models.PermissionInvoicesReverse), handlers.AdminReverseInvoice)
+		adminInvoices.POST("/:id/refund", can(models.PermissionInvoicesRefund), handlers.AdminRefundInvoice)
+		adminInvoices.GET("/:id/refunds", can(models.PermissionInvoicesRead), handlers.AdminListInvoiceRefunds)
 	}
 
-	// Admin report routes (protected + admin role)
+	// Admin payment routes (protected + payments permissions)
+	adminPayments := router.Group("/api/v1/admin/payments")
+	adminPayments.Use(requireStaff...)
+	{
+		adminPayments.GET("", can(models.PermissionPaymentsRead), handlers.AdminSearchPayments)
+		adminPayments.GET("/review", can(models.PermissionPaymentsRead), handlers.AdminListPaymentsForReview)
+		adminPayments.GET("/callbacks/rejected", can(models.PermissionPaymentsRead), handlers.AdminListRejectedCallbacks)
+		adminPayments.PUT("/:id/review", can(models.PermissionPaymentsWrite), handlers.AdminReviewPayment)
+		adminPayments.POST("/:id/collect", can(models.PermissionPaymentsWrite), handlers.AdminCollectCashPayment)
+		adminPayments.GET("/c2b", can(models.PermissionPaymentsRead), handlers.AdminListC2BTransactions)
+		adminPayments.POST("/c2b/register", can(models.PermissionPaymentsWrite), handlers.AdminRegisterC2BURLs)
+		adminPayments.POST("/c2b/:id/allocate", can(models.PermissionPaymentsWrite), handlers.AdminAllocateC2BTransaction)
+	}
+
+	// Admin report routes (protected + reports:read)
 	adminReports := router.Group("/api/v1/admin/reports")
-	adminReports.Use(middleware.AuthMiddleware(), middleware.RequireRole("admin"))
+	adminReports.Use(requireStaff...)
+	adminReports.Use(can(models.PermissionReportsRead))
 	{
 		adminReports.GET("/summary", handlers.AdminGetSummaryReport)
 		adminReports.GET("/daily", handlers.AdminGetDailyBreakdown)
+		adminReports.GET("/promotions", handlers.AdminGetPromotionReport)
+		adminReports.GET("/refunds", handlers.AdminGetRefundReport)
+	}
+
+	// Admin promotion routes (protected + promotions permissions)
+	adminPromotions := router.Group("/api/v1/admin/promotions")
+	adminPromotions.Use(requireStaff...)
+	{
+		adminPromotions.POST("", can(models.PermissionPromotionsWrite), handlers.AdminCreatePromotion)
+		adminPromotions.GET("", can(models.PermissionPromotionsRead), handlers.AdminListPromotions)
+		adminPromotions.GET("/:id", can(models.PermissionPromotionsRead), handlers.AdminGetPromotion)
+		adminPromotions.PUT("/:id", can(models.PermissionPromotionsWrite), handlers.AdminUpdatePromotion)
+		adminPromotions.GET("/:id/redemptions", can(models.PermissionPromotionsRead), handlers.AdminListPromotionRedemptions)
+	}
+
+	// Admin user routes (protected + users and roles permissions)
+	adminUsers := router.Group("/api/v1/admin/users")
+	adminUsers.Use(requireStaff...)
+	{
+		adminUsers.GET("", can(models.PermissionUsersRead), handlers.AdminListUsers)
+		adminUsers.GET("/:id", can(models.PermissionUsersRead), handlers.AdminGetUser)
+		adminUsers.GET("/:id/orders", can(models.PermissionOrdersRead), handlers.AdminListUserOrders)
+		adminUsers.GET("/:id/invoices", can(models.PermissionInvoicesRead), handlers.AdminListUserInvoices)
+		adminUsers.POST("/:id/suspend", can(models.PermissionUsersWrite), handlers.AdminSuspendUser)
+		adminUsers.POST("/:id/reactivate", can(models.PermissionUsersWrite), handlers.AdminReactivateUser)
+		adminUsers.POST("/:id/unlock", can(models.PermissionUsersWrite), handlers.AdminUnlockUser)
+		adminUsers.PUT("/:id/roles", can(models.PermissionRolesManage), handlers.AdminSetUserRoles)
+	}
+
+	// Admin role routes (protected + roles:manage)
+	adminRoles := router.Group("/api/v1/admin/roles")
+	adminRoles.Use(requireStaff...)
+	adminRoles.Use(can(models.PermissionRolesManage))
+	{
+		adminRoles.GET("", handlers.AdminListRoles)
+		adminRoles.POST("", handlers.AdminCreateRole)
+		adminRoles.PUT("/:id", handlers.AdminUpdateRole)
+		adminRoles.DELETE("/:id", handlers.AdminDeleteRole)
 	}
 
-	// M-Pesa callback route (public)
-	router.POST("/api/v1/mpesa/callback", handlers.HandleMpesaCallback)
+	// Admin delivery zone routes (protected + delivery:write)
+	adminDelivery := router.Group("/api/v1/admin/delivery-zones")
+	adminDelivery.Use(requireStaff...)
+	adminDelivery.Use(can(models.PermissionDeliveryWrite))
+	{
+		adminDelivery.GET("", handlers.AdminListDeliveryZones)
+		adminDelivery.POST("", handlers.AdminCreateDeliveryZone)
+		adminDelivery.PUT("/:id", handlers.AdminUpdateDeliveryZone)
+		adminDelivery.DELETE("/:id", handlers.AdminDeleteDeliveryZone)
+	}
+
+	// Admin sign-in security routes (protected + users permissions)
+	adminAuth := router.Group("/api/v1/admin/auth")
+	adminAuth.Use(requireStaff...)
+	{
+		adminAuth.GET("/lockouts", can(models.PermissionUsersRead), handlers.AdminListLoginLockouts)
+		adminAuth.DELETE("/lockouts/:id", can(models.PermissionUsersWrite), handlers.AdminClearLoginLockout)
+		adminAuth.GET("/events", can(models.PermissionUsersRead), handlers.AdminListAuthEvents)
+	}
+
+	// M-Pesa STK Push callback routes (public, restricted by DarajaCallbackGuard).
+	// Payments carry a token in the callback URL; the bare route serves payments
+	// initiated before tokens were issued.
+	stkCallbackGuard := handlers.DarajaCallbackGuard(models.CallbackSourceSTKPush)
+	router.POST("/api/v1/mpesa/callback", stkCallbackGuard, handlers.HandleMpesaCallback)
+	router.POST("/api/v1/mpesa/callback/:token", stkCallbackGuard, handlers.HandleMpesaCallback)
+
+	// Card gateway webhook (public, verified by signature)
+	router.POST("/api/v1/payments/card/webhook", handlers.HandleCardWebhook)
+
+	// M-Pesa reversal result callbacks (public). Like STK Push, each reversal carries
+	// a token in its result URLs; the bare routes serve reversals requested before tokens.
+	reversalCallbackGuard := handlers.DarajaCallbackGuard(models.CallbackSourceReversal)
+	router.POST("/api/v1/mpesa/reversal/result", reversalCallbackGuard, handlers.HandleReversalResult)
+	router.POST("/api/v1/mpesa/reversal/result/:token", reversalCallbackGuard, handlers.HandleReversalResult)
+	router.POST("/api/v1/mpesa/reversal/timeout", reversalCallbackGuard, handlers.HandleReversalTimeout)
+	router.POST("/api/v1/mpesa/reversal/timeout/:token", reversalCallbackGuard, handlers.HandleReversalTimeout)
+
+	// M-Pesa B2C refund result callbacks (public, token per refund as above)
+	b2cCallbackGuard := handlers.DarajaCallbackGuard(models.CallbackSourceB2C)
+	router.POST("/api/v1/mpesa/b2c/result", b2cCallbackGuard, handlers.HandleB2CResult)
+	router.POST("/api/v1/mpesa/b2c/result/:token", b2cCallbackGuard, handlers.HandleB2CResult)
+	router.POST("/api/v1/mpesa/b2c/timeout", b2cCallbackGuard, handlers.HandleB2CTimeout)
+	router.POST("/api/v1/mpesa/b2c/timeout/:token", b2cCallbackGuard, handlers.HandleB2CTimeout)
+
+	// M-Pesa C2B (Paybill/Till) URLs (public). Daraja refuses to register URLs containing "mpesa".
+	// The URLs are registered with MPESA_C2B_CALLBACK_TOKEN appended; the bare routes
+	// only serve senders on the callback allowlist when no token is configured.
+	c2bCallbackGuard := handlers.DarajaCallbackGuard(models.CallbackSourceC2B)
+	router.POST("/api/v1/payments/c2b/validation", c2bCallbackGuard, handlers.HandleC2BValidation)
+	router.POST("/api/v1/payments/c2b/validation/:token", c2bCallbackGuard, handlers.HandleC2BValidation)
+	router.POST("/api/v1/payments/c2b/confirmation", c2bCallbackGuard, handlers.HandleC2BConfirmation)
+	router.POST("/api/v1/payments/c2b/confirmation/:token", c2bCallbackGuard, handlers.HandleC2BConfirmation)
+
+	// Public keys for verifying access tokens (empty with HS256)
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
 
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {